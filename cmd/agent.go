@@ -0,0 +1,100 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"secretable/pkg/audit"
+	"secretable/pkg/handlers"
+	"secretable/pkg/providers"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var ErrNotSSHKey = errors.New("secret is not an ssh key")
+
+type sshAddCommand struct {
+	Lifetime uint32 `short:"t" long:"lifetime" description:"Key lifetime in seconds, 0 keeps the key until the agent exits"`
+	Confirm  bool   `long:"confirm" description:"Ask the agent to confirm every use of the key"`
+
+	Args struct {
+		ID string `positional-arg-name:"id" description:"ID of the secret as shown by the bot"`
+	} `positional-args:"yes" required:"yes"`
+
+	opts *option
+}
+
+func (c *sshAddCommand) Execute([]string) error {
+	v, err := openVault(c.opts)
+	if err != nil {
+		return err
+	}
+
+	defer v.audit.Close()
+
+	secret, err := v.byID(c.Args.ID)
+	if err != nil {
+		return err
+	}
+
+	if secret.Type != providers.TypeSSHKey {
+		return ErrNotSSHKey
+	}
+
+	key, err := ssh.ParseRawPrivateKey([]byte(secret.Secret))
+
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		passphrase, rerr := readLine("Key passphrase: ")
+		if rerr != nil {
+			return rerr
+		}
+
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase([]byte(secret.Secret), []byte(passphrase))
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "parse private key")
+	}
+
+	conn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+	if err != nil {
+		return errors.Wrap(err, "connect to ssh-agent")
+	}
+
+	defer conn.Close()
+
+	err = agent.NewClient(conn).Add(agent.AddedKey{
+		PrivateKey:       key,
+		Comment:          secret.Username,
+		LifetimeSecs:     c.Lifetime,
+		ConfirmBeforeUse: c.Confirm,
+	})
+	if err != nil {
+		return errors.Wrap(err, "add key to ssh-agent")
+	}
+
+	v.record(audit.ActionReveal, secret.Key, "ssh-agent")
+
+	fingerprint, _ := handlers.SSHFingerprint(secret.Secret)
+	fmt.Println("Identity added: " + secret.Username + " (" + fingerprint + ")")
+
+	return nil
+}
@@ -0,0 +1,333 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"secretable/pkg/audit"
+	"secretable/pkg/crypto"
+	"secretable/pkg/domains"
+	"secretable/pkg/handlers"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+
+	"github.com/mr-tron/base58/base58"
+	"github.com/pkg/errors"
+)
+
+const autofillTokenLength = 24
+
+var ErrNotLoopback = errors.New("the autofill API listens only on a loopback address")
+
+// extensionOrigins are the origins of the browser extensions allowed by CORS,
+// the web pages are rejected.
+var extensionOrigins = []string{"chrome-extension://", "moz-extension://", "safari-web-extension://"}
+
+type autofillCommand struct {
+	Listen    string `long:"listen" default:"127.0.0.1:7879" description:"Loopback address of the API"`
+	LockAfter uint   `long:"lock-after" default:"900" description:"Idle time in seconds after which the API locks, 0 never locks"`
+	Token     string `long:"token" env:"SECRETABLE_AUTOFILL_TOKEN" description:"Bearer token of the extension, generated if empty"`
+
+	opts *option
+}
+
+type autofillLogin struct {
+	Description string `json:"description"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+}
+
+// autofillAgent keeps the unlocked key until the API is idle for lockAfter.
+type autofillAgent struct {
+	v         *vault
+	token     string
+	lockAfter time.Duration
+	locked    chan struct{}
+
+	mx      sync.Mutex
+	privkey *ecdsa.PrivateKey
+	lastUse time.Time
+}
+
+func (c *autofillCommand) Execute([]string) error {
+	host, _, err := net.SplitHostPort(c.Listen)
+	if err != nil {
+		return errors.Wrap(err, "parse listen address")
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return ErrNotLoopback
+	}
+
+	v, err := openVault(c.opts)
+	if err != nil {
+		return err
+	}
+
+	defer v.audit.Close()
+
+	if syncer, ok := v.storage.(providers.Syncer); ok {
+		syncer.Start(context.Background())
+		defer syncer.Close()
+	}
+
+	token := c.Token
+	if token == "" {
+		b, err := crypto.MakeRandom(autofillTokenLength)
+		if err != nil {
+			return errors.Wrap(err, "make random token")
+		}
+
+		token = base58.Encode(b)
+		fmt.Println("Token: " + token)
+	}
+
+	agent := &autofillAgent{
+		v:         v,
+		token:     token,
+		lockAfter: time.Duration(c.LockAfter) * time.Second,
+		locked:    make(chan struct{}, 1),
+		privkey:   v.privkey,
+		lastUse:   time.Now(),
+	}
+
+	if agent.lockAfter > 0 {
+		go agent.lockWhenIdle()
+		go agent.unlockFromStdin()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/status", agent.authorize(agent.serveStatus))
+	mux.HandleFunc("/v1/logins", agent.authorize(agent.serveLogins))
+
+	server := &http.Server{
+		Addr:              c.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: httpTimeout * time.Second,
+	}
+
+	log.Info("🔌 Autofill API listens on " + c.Listen)
+
+	return errors.Wrap(server.ListenAndServe(), "serve autofill API")
+}
+
+// authorize rejects the requests of the web pages and the rebound domains,
+// the extension sends "Authorization: Bearer <token>".
+func (a *autofillAgent) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		if ip := net.ParseIP(strings.Trim(host, "[]")); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+
+			return
+		}
+
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if !isExtensionOrigin(origin) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+			w.Header().Set("Access-Control-Allow-Methods", http.MethodGet)
+			w.Header().Set("Vary", "Origin")
+		}
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(a.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+
+		next(w, r)
+	}
+}
+
+func (a *autofillAgent) serveStatus(w http.ResponseWriter, _ *http.Request) {
+	a.mx.Lock()
+	locked := a.privkey == nil
+	a.mx.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]bool{"locked": locked})
+}
+
+// serveLogins returns the passwords of the domain of the page, e.g.
+// GET /v1/logins?url=https://github.com/login.
+func (a *autofillAgent) serveLogins(w http.ResponseWriter, r *http.Request) {
+	pageURL := r.URL.Query().Get("url")
+
+	host := domains.Host(pageURL)
+	if host == "" {
+		http.Error(w, "url is required", http.StatusBadRequest)
+
+		return
+	}
+
+	privkey := a.unlocked()
+	if privkey == nil {
+		writeJSON(w, http.StatusLocked, map[string]bool{"locked": true})
+
+		return
+	}
+
+	secrets, err := a.v.storage.GetSecrets()
+	if err != nil {
+		log.Error("Get secrets: " + err.Error())
+		http.Error(w, "internal error", http.StatusInternalServerError)
+
+		return
+	}
+
+	logins := []autofillLogin{}
+
+	for _, secret := range secrets {
+		if secret.Type != providers.TypePassword || !domains.Matches(secret, pageURL) {
+			continue
+		}
+
+		decSecret, err := handlers.DecryptSecret(privkey, secret, a.v.signed())
+		if err != nil {
+			log.Error(err.Error())
+
+			continue
+		}
+
+		logins = append(logins, autofillLogin{
+			Description: decSecret.Description,
+			Username:    decSecret.Username,
+			Password:    decSecret.Secret,
+		})
+
+		a.v.record(audit.ActionReveal, audit.SecretKey(secret), "autofill:"+host)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"logins": logins})
+}
+
+// unlocked returns the key unless the API is locked and postpones the lock.
+func (a *autofillAgent) unlocked() *ecdsa.PrivateKey {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	if a.privkey != nil {
+		a.lastUse = time.Now()
+	}
+
+	return a.privkey
+}
+
+func (a *autofillAgent) lockWhenIdle() {
+	for {
+		a.mx.Lock()
+
+		idle := time.Since(a.lastUse)
+		if a.privkey != nil && idle >= a.lockAfter {
+			a.privkey = nil
+			a.locked <- struct{}{}
+
+			log.Info("🔒 Autofill API is locked")
+		}
+
+		a.mx.Unlock()
+
+		wait := a.lockAfter - idle
+		if wait <= 0 {
+			wait = a.lockAfter
+		}
+
+		time.Sleep(wait)
+	}
+}
+
+// unlockFromStdin asks for the master password every time the API locks.
+func (a *autofillAgent) unlockFromStdin() {
+	for range a.locked {
+		for {
+			masterPass, err := readLine("Master password: ")
+			if err != nil {
+				log.Error("Read master password: " + err.Error())
+
+				return
+			}
+
+			privkey, err := handlers.Unlock(a.v.storage, a.v.conf.Salt, masterPass)
+			if err != nil {
+				log.Error("Unlock: " + err.Error())
+
+				continue
+			}
+
+			a.mx.Lock()
+			a.privkey = privkey
+			a.lastUse = time.Now()
+			a.mx.Unlock()
+
+			a.v.record(audit.ActionUnlock, "", "autofill")
+			log.Info("🔓 Autofill API is unlocked")
+
+			break
+		}
+	}
+}
+
+func isExtensionOrigin(origin string) bool {
+	for _, prefix := range extensionOrigins {
+		if strings.HasPrefix(origin, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Write HTTP response: " + err.Error())
+	}
+}
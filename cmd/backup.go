@@ -0,0 +1,210 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"secretable/pkg/backup"
+	"secretable/pkg/config"
+	"secretable/pkg/fileperm"
+	"secretable/pkg/handlers"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultBackupKeep   = 7
+	defaultBackupPrefix = "secretable-"
+)
+
+var ErrNoBackupTarget = errors.New("backup needs one of s3, gcs or webdav")
+
+// newBackupJob returns the job of the backup config along with its schedule.
+func newBackupJob(conf *config.Config) (*backup.Job, backup.Schedule, error) {
+	schedule, err := backup.ParseSchedule(conf.Backup.Schedule)
+	if err != nil {
+		return nil, schedule, errors.Wrap(err, "parse schedule")
+	}
+
+	passphrase, err := conf.BackupPassphrase()
+	if err != nil {
+		return nil, schedule, err
+	}
+
+	if passphrase == "" {
+		return nil, schedule, errors.New("empty backup passphrase")
+	}
+
+	target, err := newBackupTarget(conf.Backup, conf)
+	if err != nil {
+		return nil, schedule, err
+	}
+
+	job := &backup.Job{
+		Target:     target,
+		Passphrase: passphrase,
+		Salt:       conf.Salt,
+		Prefix:     conf.Backup.Prefix,
+		Keep:       conf.Backup.Keep,
+	}
+
+	if job.Prefix == "" {
+		job.Prefix = defaultBackupPrefix
+	}
+
+	if job.Keep <= 0 {
+		job.Keep = defaultBackupKeep
+	}
+
+	return job, schedule, nil
+}
+
+func newBackupTarget(b config.Backup, conf *config.Config) (backup.Target, error) {
+	switch {
+	case b.S3.Bucket != "":
+		return backup.NewS3(b.S3.Endpoint, b.S3.Region, b.S3.Bucket, b.S3.AccessKeyID, b.S3.SecretAccessKey), nil
+	case b.GCS.Bucket != "":
+		creds, err := conf.BackupCredentialsJSON()
+		if err != nil {
+			return nil, err
+		}
+
+		return backup.NewGCS(creds, b.GCS.Bucket)
+	case b.WebDAV.URL != "":
+		return backup.NewWebDAV(b.WebDAV.URL, b.WebDAV.Username, b.WebDAV.Password), nil
+	default:
+		return nil, ErrNoBackupTarget
+	}
+}
+
+type backupCommand struct {
+	opts *option
+}
+
+func (c *backupCommand) Execute([]string) error {
+	path, err := configPath(c.opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	conf, err := config.ParseFromFile(path)
+	if err != nil {
+		return errors.Wrap(err, "parse config from file")
+	}
+
+	if err = log.Configure(conf.Log); err != nil {
+		return errors.Wrap(err, "configure logger")
+	}
+
+	job, _, err := newBackupJob(conf)
+	if err != nil {
+		return err
+	}
+
+	tableProvider, err := newStorageProvider(conf)
+	if err != nil {
+		return errors.Wrap(err, "create tables provider")
+	}
+
+	vaults, err := newVaults(conf)
+	if err != nil {
+		return errors.Wrap(err, "create vaults")
+	}
+
+	archive, err := newArchive(conf)
+	if err != nil {
+		return errors.Wrap(err, "create archive")
+	}
+
+	storages := map[string]providers.StorageProvider{handlers.DefaultVault: tableProvider}
+	for name, tp := range vaults {
+		storages[name] = tp
+	}
+
+	if archive != nil {
+		storages[handlers.ArchiveVault] = archive
+	}
+
+	name, err := job.Run(context.Background(), storages)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(name)
+
+	return nil
+}
+
+type restoreCommand struct {
+	To   string `long:"to" default:"." description:"Directory of the restored storage files"`
+	Args struct {
+		Archive string `positional-arg-name:"archive" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func (c *restoreCommand) Execute([]string) error {
+	data, err := os.ReadFile(c.Args.Archive)
+	if err != nil {
+		return errors.Wrap(err, "read archive")
+	}
+
+	passphrase, err := readLine("Backup passphrase: ")
+	if err != nil {
+		return err
+	}
+
+	archive, err := backup.Open(data, passphrase)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(archive.Vaults))
+	for name := range archive.Vaults {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		b, err := json.Marshal(archive.Vaults[name])
+		if err != nil {
+			return errors.Wrap(err, "encode vault "+name)
+		}
+
+		file := filepath.Join(c.To, name+".json")
+		if err = fileperm.MkdirAll(file); err != nil {
+			return errors.Wrap(err, "mkdir")
+		}
+
+		if err = fileperm.WriteFile(file, b); err != nil {
+			return errors.Wrap(err, "write vault "+name)
+		}
+
+		fmt.Printf("%s: %d secrets -> %s\n", name, len(archive.Vaults[name].Secrets), file)
+	}
+
+	fmt.Println("created: " + archive.Created.Format("2006-01-02 15:04:05 MST"))
+	fmt.Println("salt: " + archive.Salt)
+
+	return nil
+}
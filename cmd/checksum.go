@@ -0,0 +1,42 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"secretable/pkg/handlers"
+
+	"github.com/pkg/errors"
+)
+
+type checksumCommand struct{}
+
+func (c *checksumCommand) Execute([]string) error {
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return errors.Wrap(err, "read value")
+	}
+
+	value := strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+
+	fmt.Printf("%d characters, checksum %s\n", utf8.RuneCountInString(value), handlers.Checksum(value))
+
+	return nil
+}
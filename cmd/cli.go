@@ -0,0 +1,360 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"fmt"
+	"os"
+	"strings"
+
+	"secretable/pkg/audit"
+	"secretable/pkg/config"
+	"secretable/pkg/crypto"
+	"secretable/pkg/handlers"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/pkg/errors"
+)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+func addCommands(parser *flags.Parser, opts *option) error {
+	if _, err := parser.AddCommand("ssh-add",
+		"Load an SSH key into the ssh-agent",
+		"Decrypts the SSH key secret with the given ID and adds it to the ssh-agent "+
+			"from SSH_AUTH_SOCK. The key is never written to disk. "+
+			"The master password is read from the standard input.",
+		&sshAddCommand{opts: opts}); err != nil {
+		return err
+	}
+
+	if _, err := parser.AddCommand("get",
+		"Print a single secret",
+		"Decrypts the only secret whose description contains the query. "+
+			"The JSON format has a stable schema of string values "+
+			"(description, username, secret, tags, version) for the external tools. "+
+			"The master password is read from the standard input.",
+		&getCommand{opts: opts}); err != nil {
+		return err
+	}
+
+	if _, err := parser.AddCommand("env",
+		"Print the secrets of a tag as a .env document",
+		"Decrypts the secrets tagged with the tag and prints them in .env format, "+
+			"the username is the variable name. "+
+			"The master password is read from the standard input.",
+		&envCommand{opts: opts}); err != nil {
+		return err
+	}
+
+	if _, err := parser.AddCommand("doctor",
+		"Check the locales",
+		"Compares every locale with en and lists the missing and extra keys. "+
+			"Exits with an error if a locale is incomplete.",
+		&doctorCommand{opts: opts}); err != nil {
+		return err
+	}
+
+	if _, err := parser.AddCommand("version",
+		"Print the version",
+		"Prints the version, the commit and the build date. "+
+			"With --check compares the version with the latest GitHub release.",
+		&versionCommand{}); err != nil {
+		return err
+	}
+
+	if _, err := parser.AddCommand("self-update",
+		"Update to the latest release",
+		"Downloads the latest release binary of the platform, verifies it with the signed "+
+			"checksums of the release and replaces the executable. "+
+			"Refused if disable_self_update is set.",
+		&selfUpdateCommand{opts: opts}); err != nil {
+		return err
+	}
+
+	if _, err := parser.AddCommand("backup",
+		"Upload a backup archive now",
+		"Makes the encrypted archive of the vaults and uploads it to the storage of the backup "+
+			"config, the oldest archives beyond backup.keep are deleted. Prints the name of the archive.",
+		&backupCommand{opts: opts}); err != nil {
+		return err
+	}
+
+	if _, err := parser.AddCommand("restore",
+		"Decrypt a backup archive",
+		"Decrypts the backup archive with the passphrase from the standard input and writes every vault "+
+			"as a json_file storage <vault>.json along with the salt. The secrets are still encrypted "+
+			"with the master password of the backed up vault.",
+		&restoreCommand{}); err != nil {
+		return err
+	}
+
+	if _, err := parser.AddCommand("lock",
+		"Forget the cached key",
+		"Deletes the key of the vault cached by keychain_ttl from the credential store of the OS, "+
+			"the next command asks for the master password again.",
+		&lockCommand{opts: opts}); err != nil {
+		return err
+	}
+
+	if _, err := parser.AddCommand("healthcheck",
+		"Check the health of the running bot",
+		"Reads the health file written by the running bot and exits with an error if the bot "+
+			"hasn't reported recently, the Telegram updates aren't polled or the storage sync "+
+			"hangs or keeps failing. Meant for the HEALTHCHECK of a container.",
+		&healthcheckCommand{opts: opts}); err != nil {
+		return err
+	}
+
+	if _, err := parser.AddCommand("verify",
+		"Check the stored secrets",
+		"Checks the encoding, the MAC and the decryption of every stored secret and "+
+			"prints the health of each ID without revealing the values. "+
+			"Exits with an error if a secret is broken. "+
+			"The master password is read from the standard input.",
+		&verifyCommand{opts: opts}); err != nil {
+		return err
+	}
+
+	if _, err := parser.AddCommand("checksum",
+		"Print the checksum of a pasted value",
+		"Reads the value from the standard input and prints its length and checksum, "+
+			"the same as the bot shows with reveal_checksum and /len, the final newline is left out.",
+		&checksumCommand{}); err != nil {
+		return err
+	}
+
+	if _, err := parser.AddCommand("encrypt-existing",
+		"Encrypt the plaintext rows",
+		"Encrypts the rows of the storage kept in the clear, e.g. of a spreadsheet of passwords "+
+			"filled in before the bot, in place with the key of the vault. The storage is copied to "+
+			"a json_file storage backup first. The master password is read from the standard input.",
+		&encryptExistingCommand{opts: opts}); err != nil {
+		return err
+	}
+
+	if _, err := parser.AddCommand("pair",
+		"Issue a pairing code of a chat",
+		"Prints a new pairing code of the chat, the chat sends it with /pair "+
+			"and repeats it before the sensitive commands. The previous pairing of the chat is revoked.",
+		&pairCommand{opts: opts}); err != nil {
+		return err
+	}
+
+	if _, err := parser.AddCommand("autofill",
+		"Serve the logins to a browser extension",
+		"Serves the usernames and passwords of a domain on a localhost API for the autofill "+
+			"of a browser extension. The API locks after the idle timeout and is unlocked again "+
+			"with the master password from the standard input.",
+		&autofillCommand{opts: opts}); err != nil {
+		return err
+	}
+
+	exportCmd, err := parser.AddCommand("export",
+		"Export secrets for external tools",
+		"Renders the decrypted secrets in the format of an external tool.",
+		&exportCommand{})
+	if err != nil {
+		return err
+	}
+
+	_, err = exportCmd.AddCommand("k8s",
+		"Print the secrets of a tag as a Kubernetes Secret manifest",
+		"Renders the secrets tagged with the tag as an Opaque Secret with base64 encoded data, "+
+			"the username is the data key. "+
+			"The master password is read from the standard input.",
+		&k8sCommand{opts: opts})
+	if err != nil {
+		return err
+	}
+
+	_, err = exportCmd.AddCommand("docker-secret",
+		"Create a Docker or Podman secret from a secret",
+		"Pipes the decrypted secret with the given ID into \"docker secret create\" "+
+			"or \"podman secret create\" without writing it to disk. "+
+			"The master password is read from the standard input.",
+		&dockerSecretCommand{opts: opts})
+
+	return err
+}
+
+// cliSecret is a decrypted secret along with its audit key.
+type cliSecret struct {
+	providers.SecretsData
+	Key string
+}
+
+// vault is the storage unlocked by the CLI commands.
+type vault struct {
+	conf    *config.Config
+	audit   *audit.Log
+	storage providers.StorageProvider
+	secrets []providers.SecretsData
+	privkey *ecdsa.PrivateKey
+	// marked is set if the key has the signed marker.
+	marked bool
+}
+
+// openVault reads the storage and unlocks it with the master password from
+// the standard input.
+func openVault(opts *option) (*vault, error) {
+	path, err := configPath(opts.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	conf, err := config.ParseFromFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse config from file")
+	}
+
+	if err = log.Configure(conf.Log); err != nil {
+		return nil, errors.Wrap(err, "configure logger")
+	}
+
+	if err = crypto.SelfTest(); err != nil {
+		return nil, errors.Wrap(err, "crypto self-test")
+	}
+
+	tableProvider, err := newStorageProvider(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "create tables provider")
+	}
+
+	auditLog, err := openAudit(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "open audit log")
+	}
+
+	privkey, err := unlockCLI(conf, tableProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, err := tableProvider.GetSecrets()
+	if err != nil {
+		return nil, errors.Wrap(err, "get secrets")
+	}
+
+	marked, err := handlers.VaultSigned(tableProvider, privkey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &vault{
+		conf: conf, audit: auditLog, storage: tableProvider, secrets: secrets, privkey: privkey, marked: marked,
+	}, nil
+}
+
+// signed reports whether the secrets stored before the MACs are signed by the
+// bot, by the audit log or by the marker of the key, the secrets without a
+// MAC are tampered then.
+func (v *vault) signed() bool {
+	return v.marked || v.audit.Signed(handlers.DefaultVault)
+}
+
+// secret decrypts the secret with the index counted from one.
+func (v *vault) secret(index int) (cliSecret, error) {
+	if index < 1 || index > len(v.secrets) {
+		return cliSecret{}, errors.New("wrong index " + fmt.Sprint(index))
+	}
+
+	decSecret, err := handlers.DecryptSecret(v.privkey, v.secrets[index-1], v.signed())
+	if err != nil {
+		return cliSecret{}, err
+	}
+
+	return cliSecret{SecretsData: decSecret, Key: audit.SecretKey(v.secrets[index-1])}, nil
+}
+
+// byID decrypts the secret with the ID.
+func (v *vault) byID(id string) (cliSecret, error) {
+	index := providers.FindByID(v.secrets, id)
+	if index < 0 {
+		return cliSecret{}, errors.New("wrong id " + id)
+	}
+
+	return v.secret(index + 1)
+}
+
+// tagged decrypts the secrets tagged with the tag, the storage is iterated
+// so only the tagged secrets are copied.
+func (v *vault) tagged(tag string) ([]cliSecret, error) {
+	var (
+		secrets []cliSecret
+		decErr  error
+	)
+
+	err := v.storage.Secrets(func(_ int, secret providers.SecretsData) bool {
+		if !secret.HasTag(tag) {
+			return true
+		}
+
+		decSecret, err := handlers.DecryptSecret(v.privkey, secret, v.signed())
+		if err != nil {
+			decErr = err
+
+			return false
+		}
+
+		secrets = append(secrets, cliSecret{SecretsData: decSecret, Key: audit.SecretKey(secret)})
+
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "get secrets")
+	}
+
+	if decErr != nil {
+		return nil, decErr
+	}
+
+	return secrets, nil
+}
+
+// record writes the event to the audit log, the revealed canaries are logged
+// as errors since the CLI has no chat to alert.
+func (v *vault) record(action, key, details string) {
+	err := v.audit.Record(audit.Event{Action: action, SecretKey: key, Details: details})
+	if err != nil {
+		log.Error("Write audit log: "+err.Error(), "file", v.conf.AuditFile)
+	}
+
+	if action != audit.ActionReveal || !v.audit.Canary(key) {
+		return
+	}
+
+	log.Error("🐤 Canary secret revealed", "details", details)
+
+	err = v.audit.Record(audit.Event{Action: audit.ActionCanary, SecretKey: key, Details: audit.CanaryTripped})
+	if err != nil {
+		log.Error("Write audit log: "+err.Error(), "file", v.conf.AuditFile)
+	}
+}
+
+func readLine(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.Wrap(err, "read "+strings.ToLower(strings.TrimSuffix(prompt, ": ")))
+	}
+
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+
+	"secretable/pkg/audit"
+
+	"github.com/pkg/errors"
+)
+
+type dockerSecretCommand struct {
+	Engine string `long:"engine" default:"docker" choice:"docker" choice:"podman" description:"Container engine CLI"`
+
+	Args struct {
+		Name string `positional-arg-name:"name" description:"Name of the created secret"`
+		ID   string `positional-arg-name:"id" description:"ID of the secret as shown by the bot"`
+	} `positional-args:"yes" required:"yes"`
+
+	opts *option
+}
+
+// Execute pipes the decrypted value to "<engine> secret create <name> -", so
+// the plaintext never touches the disk.
+func (c *dockerSecretCommand) Execute([]string) error {
+	v, err := openVault(c.opts)
+	if err != nil {
+		return err
+	}
+
+	defer v.audit.Close()
+
+	secret, err := v.byID(c.Args.ID)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(c.Engine, "secret", "create", c.Args.Name, "-")
+	cmd.Stdin = strings.NewReader(secret.Secret)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err = cmd.Run(); err != nil {
+		return errors.Wrap(err, c.Engine+" secret create")
+	}
+
+	v.record(audit.ActionReveal, secret.Key, c.Engine+"-secret")
+
+	return nil
+}
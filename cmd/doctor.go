@@ -0,0 +1,75 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"secretable/pkg/config"
+
+	"github.com/pkg/errors"
+)
+
+var ErrIncompleteLocales = errors.New("incomplete locales")
+
+type doctorCommand struct {
+	opts *option
+}
+
+func (c *doctorCommand) Execute([]string) error {
+	path, err := configPath(c.opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	conf, err := config.ParseFromFile(path)
+	if err != nil {
+		return errors.Wrap(err, "parse config from file")
+	}
+
+	locales, err := loadLocales(conf.LocalesDir)
+	if err != nil {
+		return errors.Wrap(err, "load locales")
+	}
+
+	complete := true
+
+	for _, report := range locales.Report() {
+		if report.Complete() {
+			fmt.Printf("%s: complete\n", report.Locale)
+
+			continue
+		}
+
+		complete = false
+
+		fmt.Printf("%s: %d missing, %d extra\n", report.Locale, len(report.Missing), len(report.Extra))
+
+		if len(report.Missing) > 0 {
+			fmt.Println("  missing: " + strings.Join(report.Missing, ", "))
+		}
+
+		if len(report.Extra) > 0 {
+			fmt.Println("  extra: " + strings.Join(report.Extra, ", "))
+		}
+	}
+
+	if !complete {
+		return ErrIncompleteLocales
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"secretable/pkg/audit"
+	"secretable/pkg/handlers"
+)
+
+type encryptExistingCommand struct {
+	Backup string `long:"backup" description:"Path of the backup json_file storage, default: plaintext-backup-<time>.json"`
+
+	opts *option
+}
+
+func (c *encryptExistingCommand) Execute([]string) error {
+	v, err := openVault(c.opts)
+	if err != nil {
+		return err
+	}
+
+	defer v.audit.Close()
+
+	if c.Backup == "" {
+		c.Backup = handlers.BackupName(time.Now())
+	}
+
+	rows, err := handlers.EncryptPlaintext(v.privkey, v.storage, c.Backup)
+	if err != nil {
+		return err
+	}
+
+	if len(rows) == 0 {
+		fmt.Println("No plaintext rows")
+
+		return nil
+	}
+
+	ids := make([]string, 0, len(rows))
+
+	for key, secret := range rows {
+		v.record(audit.ActionEdit, audit.SecretKey(secret), key)
+		ids = append(ids, secret.StableID())
+	}
+
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		fmt.Println(id)
+	}
+
+	fmt.Printf("%d rows encrypted, the backup %s keeps them in the clear: delete it once the vault is checked\n", len(rows), c.Backup)
+
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+
+	"secretable/pkg/audit"
+	"secretable/pkg/export"
+)
+
+type envCommand struct {
+	Args struct {
+		Tag string `positional-arg-name:"tag" description:"Tag of the secrets, with or without #"`
+	} `positional-args:"yes" required:"yes"`
+
+	opts *option
+}
+
+func (c *envCommand) Execute([]string) error {
+	v, err := openVault(c.opts)
+	if err != nil {
+		return err
+	}
+
+	defer v.audit.Close()
+
+	secrets, err := v.tagged(c.Args.Tag)
+	if err != nil {
+		return err
+	}
+
+	doc, skipped := export.Env(plainSecrets(secrets))
+	if _, err = os.Stdout.Write(doc); err != nil {
+		return err
+	}
+
+	for _, secret := range secrets {
+		v.record(audit.ActionReveal, secret.Key, "env")
+	}
+
+	printSkipped(skipped)
+
+	return nil
+}
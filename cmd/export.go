@@ -0,0 +1,86 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"secretable/pkg/audit"
+	"secretable/pkg/export"
+	"secretable/pkg/providers"
+)
+
+type exportCommand struct{}
+
+type k8sCommand struct {
+	Tag       string `long:"tag" required:"yes" description:"Tag of the exported secrets, with or without #"`
+	Namespace string `long:"namespace" description:"Namespace of the Secret"`
+	Name      string `long:"name" description:"Name of the Secret, the tag by default"`
+
+	opts *option
+}
+
+func (c *k8sCommand) Execute([]string) error {
+	v, err := openVault(c.opts)
+	if err != nil {
+		return err
+	}
+
+	defer v.audit.Close()
+
+	secrets, err := v.tagged(c.Tag)
+	if err != nil {
+		return err
+	}
+
+	name := c.Name
+	if name == "" {
+		name = strings.TrimPrefix(c.Tag, "#")
+	}
+
+	doc, skipped, err := export.K8sSecret(name, c.Namespace, plainSecrets(secrets))
+	if err != nil {
+		return err
+	}
+
+	if _, err = os.Stdout.Write(doc); err != nil {
+		return err
+	}
+
+	for _, secret := range secrets {
+		v.record(audit.ActionReveal, secret.Key, "k8s")
+	}
+
+	printSkipped(skipped)
+
+	return nil
+}
+
+func plainSecrets(secrets []cliSecret) []providers.SecretsData {
+	plain := make([]providers.SecretsData, len(secrets))
+	for i, secret := range secrets {
+		plain[i] = secret.SecretsData
+	}
+
+	return plain
+}
+
+func printSkipped(skipped []string) {
+	if len(skipped) > 0 {
+		fmt.Fprintln(os.Stderr, "Skipped, the username is not a valid name: "+strings.Join(skipped, ", "))
+	}
+}
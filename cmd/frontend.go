@@ -0,0 +1,103 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"secretable/pkg/chat"
+	"secretable/pkg/config"
+	"secretable/pkg/log"
+	"secretable/pkg/matrix"
+	"secretable/pkg/signalcli"
+	"secretable/pkg/telegram"
+
+	"github.com/pkg/errors"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// frontend is the messenger the bot serves, start blocks until stop is called.
+type frontend struct {
+	name      string
+	transport chat.Transport
+	start     func()
+	stop      func()
+}
+
+// newFrontend creates the Signal frontend if signal.address is set, the Matrix
+// one if matrix.homeserver is, the Telegram bot otherwise. The tracker records
+// the polls of the updates.
+func newFrontend(conf *config.Config, tracker *pollTracker) (frontend, error) {
+	if conf.Signal.Address != "" {
+		transport := signalcli.New(conf.Signal.Address, conf.Signal.Users)
+		transport.OnAlive = tracker.alive
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		log.Info("🧪 Signal frontend is experimental")
+
+		return frontend{
+			name:      "Signal bot",
+			transport: transport,
+			start:     func() { transport.Run(ctx) },
+			stop:      cancel,
+		}, nil
+	}
+
+	if conf.Matrix.Homeserver != "" {
+		token, err := conf.MatrixAccessToken()
+		if err != nil {
+			return frontend{}, errors.Wrap(err, "read Matrix access token")
+		}
+
+		transport := matrix.New(conf.Matrix.Homeserver, conf.Matrix.UserID, token, conf.Matrix.Users)
+		transport.OnAlive = tracker.alive
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		log.Info("🧪 Matrix frontend is experimental")
+
+		return frontend{
+			name:      "Matrix bot",
+			transport: transport,
+			start:     func() { transport.Run(ctx) },
+			stop:      cancel,
+		}, nil
+	}
+
+	token, err := conf.BotToken()
+	if err != nil {
+		return frontend{}, errors.Wrap(err, "read Telegram bot token")
+	}
+
+	bot, err := tb.NewBot(tb.Settings{
+		Token: token,
+		Poller: &tb.LongPoller{
+			Timeout: longPollerTimeout * time.Second,
+		},
+		Client: newTelegramClient(tracker),
+	})
+	if err != nil {
+		return frontend{}, errors.Wrap(err, "create new bot instance")
+	}
+
+	return frontend{
+		name:      "Telegram Bot",
+		transport: telegram.New(bot),
+		start:     bot.Start,
+		stop:      bot.Stop,
+	}, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"secretable/pkg/audit"
+	"secretable/pkg/export"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNotFound  = errors.New("no secrets match the query")
+	ErrAmbiguous = errors.New("query matches several secrets")
+)
+
+type getCommand struct {
+	Format string `long:"format" default:"text" choice:"text" choice:"json" description:"Output format"`
+
+	Args struct {
+		Query string `positional-arg-name:"query" description:"Part of the description, as in the bot"`
+	} `positional-args:"yes" required:"yes"`
+
+	opts *option
+}
+
+func (c *getCommand) Execute([]string) error {
+	v, err := openVault(c.opts)
+	if err != nil {
+		return err
+	}
+
+	defer v.audit.Close()
+
+	index, err := v.find(c.Args.Query)
+	if err != nil {
+		return err
+	}
+
+	secret, err := v.secret(index)
+	if err != nil {
+		return err
+	}
+
+	if c.Format == "json" {
+		doc, err := export.JSON(secret.SecretsData, secret.Key)
+		if err != nil {
+			return err
+		}
+
+		if _, err = os.Stdout.Write(doc); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("%s\n%s\n%s\n", secret.Description, secret.Username, secret.Secret)
+	}
+
+	v.record(audit.ActionReveal, secret.Key, "get")
+
+	return nil
+}
+
+// find returns the index, counted from one, of the only secret whose
+// description contains the query. An exact match wins over partial ones.
+func (v *vault) find(query string) (int, error) {
+	query = strings.ToLower(query)
+
+	var matches []int
+
+	for i, secret := range v.secrets {
+		description := strings.ToLower(secret.Description)
+
+		if description == query {
+			return i + 1, nil
+		}
+
+		if strings.Contains(description, query) {
+			matches = append(matches, i+1)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return 0, ErrNotFound
+	case 1:
+		return matches[0], nil
+	default:
+		return 0, errors.Wrap(ErrAmbiguous, fmt.Sprint(len(matches), " matches"))
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"secretable/pkg/config"
+	"secretable/pkg/fileperm"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	healthInterval = 15 * time.Second
+	// healthTimeout is how long the polls and the syncs may hang.
+	healthTimeout = time.Minute
+	// healthStale is the age of the health file of a bot which doesn't run.
+	healthStale = 3 * healthInterval
+
+	// defaultHealthFailures is the default of sync_alert_failures.
+	defaultHealthFailures = 5
+)
+
+var ErrUnhealthy = errors.New("unhealthy")
+
+// health is the state of the bot written to the health file.
+type health struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+}
+
+type healthcheckCommand struct {
+	opts *option
+}
+
+func (c *healthcheckCommand) Execute([]string) error {
+	path, err := configPath(c.opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	conf, err := config.ParseFromFile(path)
+	if err != nil {
+		return errors.Wrap(err, "parse config from file")
+	}
+
+	b, err := os.ReadFile(conf.HealthPath())
+	if err != nil {
+		return errors.Wrap(err, "read health file")
+	}
+
+	var state health
+	if err = json.Unmarshal(b, &state); err != nil {
+		return errors.Wrap(err, "decode health file")
+	}
+
+	if age := time.Since(state.Time); age > healthStale {
+		return errors.Wrap(ErrUnhealthy, "the bot hasn't reported for "+age.Round(time.Second).String())
+	}
+
+	if state.Error != "" {
+		return errors.Wrap(ErrUnhealthy, state.Error)
+	}
+
+	fmt.Println("healthy")
+
+	return nil
+}
+
+// startHealthFile writes the health of the bot for secretable healthcheck
+// until the context is done, then the file is removed.
+func startHealthFile(ctx context.Context, conf *config.Config, tracker *pollTracker, syncers []providers.Syncer) {
+	path := conf.HealthPath()
+
+	threshold := conf.SyncAlertFailures
+	if threshold <= 0 {
+		threshold = defaultHealthFailures
+	}
+
+	go func() {
+		ticker := time.NewTicker(healthInterval)
+		defer ticker.Stop()
+
+		for {
+			state := health{Time: time.Now(), Error: stuck(tracker, syncers, healthTimeout)}
+			if state.Error == "" {
+				state.Error = failing(syncers, threshold)
+			}
+
+			if err := writeHealth(path, state); err != nil {
+				log.Error("Write health file: "+err.Error(), "file", path)
+			}
+
+			select {
+			case <-ctx.Done():
+				if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+					log.Error("Remove health file: "+err.Error(), "file", path)
+				}
+
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// failing returns the last error of the storage sync which failed the
+// threshold times in a row, empty if none.
+func failing(syncers []providers.Syncer, threshold int) string {
+	for _, syncer := range syncers {
+		status := syncer.SyncStatus()
+		if status.Failures >= threshold {
+			return "storage sync failed " + strconv.Itoa(status.Failures) + " times: " + status.LastError
+		}
+	}
+
+	return ""
+}
+
+// writeHealth replaces the health file, so a check never reads a part of it.
+func writeHealth(path string, state health) error {
+	b, _ := json.Marshal(state)
+
+	if err := fileperm.WriteFile(path+".tmp", b); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"secretable/pkg/config"
+	"secretable/pkg/handlers"
+	"secretable/pkg/keychain"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+
+	"github.com/pkg/errors"
+)
+
+// lockCommand drops the key cached in the credential store of the OS.
+type lockCommand struct {
+	opts *option
+}
+
+func (c *lockCommand) Execute([]string) error {
+	path, err := configPath(c.opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	conf, err := config.ParseFromFile(path)
+	if err != nil {
+		return errors.Wrap(err, "parse config from file")
+	}
+
+	if err = log.Configure(conf.Log); err != nil {
+		return errors.Wrap(err, "configure logger")
+	}
+
+	tableProvider, err := newStorageProvider(conf)
+	if err != nil {
+		return errors.Wrap(err, "create tables provider")
+	}
+
+	account, err := keychainAccount(conf, tableProvider)
+	if err != nil {
+		return err
+	}
+
+	if err = keychain.New().Delete(account); err != nil {
+		return errors.Wrap(err, "delete cached key")
+	}
+
+	fmt.Println("Locked")
+
+	return nil
+}
+
+// unlockCLI returns the private key cached in the credential store of the OS
+// for keychain_ttl, otherwise the vault is unlocked with the master password
+// from the standard input and the key is cached.
+func unlockCLI(conf *config.Config, tp providers.StorageProvider) (*ecdsa.PrivateKey, error) {
+	if conf.KeychainTTL <= 0 {
+		return unlockWithPassword(conf, tp)
+	}
+
+	// The encrypted storage needs the master password to read the key, so
+	// its key isn't cached.
+	account, err := keychainAccount(conf, tp)
+	if err != nil {
+		log.Debug("Skip keychain: " + err.Error())
+
+		return unlockWithPassword(conf, tp)
+	}
+
+	cache := keychain.New()
+
+	b, err := cache.Get(account)
+	if err == nil {
+		privkey, perr := x509.ParsePKCS8PrivateKey(b)
+		if ecKey, ok := privkey.(*ecdsa.PrivateKey); perr == nil && ok {
+			return ecKey, nil
+		}
+
+		err = errors.New("malformed cached key")
+	}
+
+	if !errors.Is(err, keychain.ErrNotFound) {
+		log.Error("Read keychain: "+err.Error(), "account", account)
+	}
+
+	privkey, err := unlockWithPassword(conf, tp)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err = x509.MarshalPKCS8PrivateKey(privkey)
+	if err == nil {
+		err = cache.Put(account, b, time.Duration(conf.KeychainTTL)*time.Second)
+	}
+
+	if err != nil {
+		log.Error("Write keychain: "+err.Error(), "account", account)
+	}
+
+	return privkey, nil
+}
+
+// unlockWithPassword opens the key slots of the unlock config, the master
+// password is asked if none of them opens the vault.
+func unlockWithPassword(conf *config.Config, tp providers.StorageProvider) (*ecdsa.PrivateKey, error) {
+	unlockers, err := newUnlockers(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(unlockers) > 0 {
+		privkey, err := handlers.Unlock(tp, conf.Salt, "", unlockers...)
+		if err == nil {
+			return privkey, nil
+		}
+
+		log.Debug("Skip key slots: " + err.Error())
+	}
+
+	masterPass, err := readLine("Master password: ")
+	if err != nil {
+		return nil, err
+	}
+
+	privkey, err := handlers.Unlock(tp, conf.Salt, masterPass)
+	if err != nil {
+		return nil, errors.Wrap(err, "unlock")
+	}
+
+	return privkey, nil
+}
+
+// keychainAccount names the cached key of the vault by the salt and the
+// wrapped key, so the key cached before /setpass or of another vault is
+// never used.
+func keychainAccount(conf *config.Config, tp providers.StorageProvider) (string, error) {
+	key, err := tp.GetKey()
+	if err != nil {
+		return "", errors.Wrap(err, "get key")
+	}
+
+	if key == "" {
+		return "", handlers.ErrMissingKey
+	}
+
+	h := sha256.Sum256([]byte(conf.Salt + "\n" + key))
+
+	return hex.EncodeToString(h[:8]), nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"secretable/pkg/config"
+	"secretable/pkg/devices"
+
+	"github.com/pkg/errors"
+)
+
+var ErrPairingDisabled = errors.New("device pairing is disabled in the config")
+
+type pairCommand struct {
+	Args struct {
+		ChatID int64 `positional-arg-name:"chat_id" description:"ID of the chat from /id"`
+	} `positional-args:"yes" required:"yes"`
+
+	opts *option
+}
+
+func (c *pairCommand) Execute([]string) error {
+	path, err := configPath(c.opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	conf, err := config.ParseFromFile(path)
+	if err != nil {
+		return errors.Wrap(err, "parse config from file")
+	}
+
+	if !conf.DevicePairing.Enabled {
+		return ErrPairingDisabled
+	}
+
+	code, err := devices.Open(devicesFile(conf)).Issue(c.Args.ChatID)
+	if err != nil {
+		return errors.Wrap(err, "issue pairing code")
+	}
+
+	fmt.Println(code)
+
+	return nil
+}
+
+func devicesFile(conf *config.Config) string {
+	if conf.DevicePairing.File == "" {
+		return "./devices.json"
+	}
+
+	return conf.DevicePairing.File
+}
@@ -0,0 +1,284 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"secretable/pkg/config"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+	"secretable/pkg/sigv4"
+
+	"github.com/pkg/errors"
+)
+
+// newRemoteStorage creates the storage of the JSON file kept in the cloud of
+// the storage source.
+func newRemoteStorage(conf *config.Config) (*providers.RemoteStorage, error) {
+	var (
+		file      providers.RemoteFile
+		cacheFile *string
+		encrypted bool
+		err       error
+	)
+
+	switch conf.StorageSource {
+	case "webdav":
+		log.Info("🗂 Source: WebDAV storage")
+
+		file, err = newWebDAVFile(conf)
+		cacheFile, encrypted = &conf.WebDAVStorage.CacheFile, conf.WebDAVStorage.Encrypted
+	case "dropbox":
+		log.Info("🗂 Source: Dropbox storage")
+
+		file, err = newDropboxFile(conf)
+		cacheFile, encrypted = &conf.DropboxStorage.CacheFile, conf.DropboxStorage.Encrypted
+	case "google_drive":
+		log.Info("🗂 Source: Google Drive storage")
+
+		file, err = newDriveFile(conf)
+		cacheFile, encrypted = &conf.DriveStorage.CacheFile, conf.DriveStorage.Encrypted
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if *cacheFile == "" {
+		*cacheFile = "./" + conf.StorageSource + "-cache.json"
+	}
+
+	log.Info("☁️ Remote file: " + file.String())
+	log.Info("📄 Cache file: " + *cacheFile)
+
+	if encrypted {
+		log.Info("🔒 Remote storage is encrypted with the master password")
+	}
+
+	tp, err := providers.NewRemoteStorage(file, *cacheFile, encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.SyncInterval > 0 || conf.SyncMaxInterval > 0 {
+		tp.SetSyncInterval(time.Duration(conf.SyncInterval)*time.Second, time.Duration(conf.SyncMaxInterval)*time.Second)
+	}
+
+	return tp, nil
+}
+
+func newWebDAVFile(conf *config.Config) (providers.RemoteFile, error) {
+	if conf.WebDAVStorage.URL == "" {
+		return nil, errors.New("webdav storage needs the url")
+	}
+
+	password, err := conf.WebDAVPassword()
+	if err != nil {
+		return nil, err
+	}
+
+	return providers.NewWebDAVFile(conf.WebDAVStorage.URL, conf.WebDAVStorage.Username, password), nil
+}
+
+func newDropboxFile(conf *config.Config) (providers.RemoteFile, error) {
+	d := conf.DropboxStorage
+
+	if d.Path == "" {
+		d.Path = "/secretable.json"
+	}
+
+	refreshToken, err := conf.DropboxRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if refreshToken == "" && d.AccessToken == "" {
+		return nil, errors.New("dropbox storage needs the refresh token or the access token")
+	}
+
+	if refreshToken != "" && d.AppKey == "" {
+		return nil, errors.New("dropbox refresh token needs the app key")
+	}
+
+	return providers.NewDropboxFile(d.Path, d.AccessToken, refreshToken, d.AppKey, d.AppSecret), nil
+}
+
+// newDriveFile returns the Drive file of the config, the new file is created
+// for the empty file ID and its ID is written to the config.
+func newDriveFile(conf *config.Config) (providers.RemoteFile, error) {
+	log.Info("📝 Google credentials: " + conf.GoogleCredentials)
+
+	creds, err := conf.GoogleCredentialsJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.DriveStorage.FileID == "" {
+		id, err := providers.CreateDriveFile(creds, "secretable.json", conf.DriveStorage.ShareWith)
+		if id == "" {
+			return nil, errors.Wrap(err, "create drive file")
+		}
+
+		conf.DriveStorage.FileID = id
+		log.Info("📄 Created Drive file " + id)
+
+		if updateErr := config.UpdateFile(conf); updateErr != nil {
+			return nil, errors.Wrap(updateErr, "update config file")
+		}
+
+		if err != nil {
+			return nil, errors.Wrap(err, "create drive file")
+		}
+	}
+
+	return providers.NewDriveFile(creds, conf.DriveStorage.FileID)
+}
+
+const defaultStorePrefix = "secretable-"
+
+// newSecretStoreStorage creates the storage of the native secrets of the
+// secret manager of the storage source.
+func newSecretStoreStorage(conf *config.Config) (*providers.SecretStoreStorage, error) {
+	var (
+		store  providers.SecretStore
+		prefix string
+	)
+
+	switch conf.StorageSource {
+	case "aws_secrets_manager":
+		log.Info("🗂 Source: AWS Secrets Manager")
+
+		aws := conf.AWSSecretsManager
+		if aws.Region == "" {
+			return nil, errors.New("aws secrets manager needs the region")
+		}
+
+		creds := awsCredentials(aws.AccessKeyID, aws.SecretAccessKey)
+		store, prefix = providers.NewAWSSecretsManager(aws.Endpoint, aws.Region, creds), aws.Prefix
+	case "azure_key_vault":
+		log.Info("🗂 Source: Azure Key Vault")
+
+		kv := conf.AzureKeyVault
+		if kv.URL == "" || kv.TenantID == "" || kv.ClientID == "" {
+			return nil, errors.New("azure key vault needs the url, the tenant id and the client id")
+		}
+
+		secret, err := conf.AzureClientSecret()
+		if err != nil {
+			return nil, err
+		}
+
+		store, prefix = providers.NewAzureKeyVault(kv.URL, kv.TenantID, kv.ClientID, secret), kv.Prefix
+	}
+
+	if prefix == "" {
+		prefix = defaultStorePrefix
+	}
+
+	log.Info("🔐 Secret store: " + store.String() + ", prefix " + prefix)
+
+	tp, err := providers.NewSecretStoreStorage(store, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.SyncInterval > 0 || conf.SyncMaxInterval > 0 {
+		tp.SetSyncInterval(time.Duration(conf.SyncInterval)*time.Second, time.Duration(conf.SyncMaxInterval)*time.Second)
+	}
+
+	return tp, nil
+}
+
+// defaultDocumentsName is the partition of the DynamoDB items and the
+// Firestore collection.
+const defaultDocumentsName = "secretable"
+
+// newDocumentStorage creates the storage of the documents of the secrets in
+// the NoSQL database of the storage source.
+func newDocumentStorage(conf *config.Config) (*providers.DocumentStorage, error) {
+	var store providers.DocumentStore
+
+	switch conf.StorageSource {
+	case "dynamodb":
+		log.Info("🗂 Source: DynamoDB")
+
+		db := conf.DynamoDB
+		if db.Table == "" || db.Region == "" {
+			return nil, errors.New("dynamodb needs the table and the region")
+		}
+
+		if db.Vault == "" {
+			db.Vault = defaultDocumentsName
+		}
+
+		creds := awsCredentials(db.AccessKeyID, db.SecretAccessKey)
+		store = providers.NewDynamoDB(db.Endpoint, db.Region, db.Table, db.Vault, creds)
+	case "firestore":
+		log.Info("🗂 Source: Firestore")
+		log.Info("📝 Google credentials: " + conf.GoogleCredentials)
+
+		creds, err := conf.GoogleCredentialsJSON()
+		if err != nil {
+			return nil, err
+		}
+
+		fs := conf.Firestore
+		if fs.ProjectID == "" {
+			var file struct {
+				ProjectID string `json:"project_id"`
+			}
+
+			_ = json.Unmarshal(creds, &file)
+			fs.ProjectID = file.ProjectID
+		}
+
+		if fs.ProjectID == "" {
+			return nil, errors.New("firestore needs the project id")
+		}
+
+		if fs.Database == "" {
+			fs.Database = "(default)"
+		}
+
+		if fs.Collection == "" {
+			fs.Collection = defaultDocumentsName
+		}
+
+		if store, err = providers.NewFirestore(creds, fs.ProjectID, fs.Database, fs.Collection); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Info("📑 Document store: " + store.String())
+
+	return providers.NewDocumentStorage(store)
+}
+
+// awsCredentials returns the credentials of the config, the environment ones
+// if the access key is empty.
+func awsCredentials(accessKeyID, secretAccessKey string) sigv4.Credentials {
+	if accessKeyID != "" {
+		return sigv4.Credentials{AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey}
+	}
+
+	return sigv4.Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
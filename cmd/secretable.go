@@ -15,21 +15,33 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
+	"secretable/pkg/audit"
+	"secretable/pkg/backup"
+	"secretable/pkg/chat"
 	"secretable/pkg/config"
 	"secretable/pkg/crypto"
+	"secretable/pkg/devices"
+	"secretable/pkg/fileperm"
 	"secretable/pkg/handlers"
+	"secretable/pkg/ingest"
 	"secretable/pkg/localizator"
 	"secretable/pkg/log"
 	"secretable/pkg/providers"
-
-	tb "gopkg.in/tucnak/telebot.v2"
+	"secretable/pkg/slack"
+	"secretable/pkg/systemd"
+	"secretable/pkg/tracing"
+	"secretable/pkg/version"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/mr-tron/base58/base58"
@@ -37,7 +49,8 @@ import (
 )
 
 const (
-	longPollerTimeout = 5 // in sec
+	longPollerTimeout = 5  // in sec
+	httpTimeout       = 10 // in sec
 	saltLength        = 32
 )
 
@@ -58,26 +71,256 @@ func main() {
 		return
 	}
 
-	log.Info("⏳ Initialization Secretable")
+	log.Info("⏳ Initialization Secretable " + version.String())
 
-	locales := new(localizator.Localizator)
-	if err = locales.InitFromFS(localesFS, "locales"); err != nil {
+	conf, err := getConf(opts.ConfigFile)
+	if err != nil {
+		log.Fatal("Get config: " + err.Error())
+
+		return
+	}
+
+	locales, err := loadLocales(conf.LocalesDir)
+	if err != nil {
 		log.Fatal("Initialization locales: " + err.Error())
 
 		return
 	}
 
 	log.Info("🌎 Supported locales: " + strings.Join(locales.GetLocales(), ", "))
+	logLocalesReport(locales)
 
-	conf, err := getConf(opts.ConfigFile)
-	if err != nil {
-		log.Fatal("Get config: " + err.Error())
+	if err = log.Configure(conf.Log); err != nil {
+		log.Fatal("Configure logger: " + err.Error())
+
+		return
+	}
+
+	if err = setReporter(conf.ErrorReporting); err != nil {
+		log.Fatal("Set error reporter: " + err.Error())
 
 		return
 	}
 
-	var tableProvider providers.StorageProvider
+	if conf.Tracing.Endpoint != "" {
+		tracing.Init(conf.Tracing)
+		log.Info("🔭 Traces are exported to " + conf.Tracing.Endpoint)
+	}
+
+	if err = crypto.SelfTest(); err != nil {
+		log.Fatal("Crypto self-test: " + err.Error())
+	}
+
+	tableProvider, err := newStorageProvider(conf)
+	if err != nil {
+		log.Fatal("Unable to create tables provider: " + err.Error())
+	}
+
+	vaults, err := newVaults(conf)
+	if err != nil {
+		log.Fatal("Unable to create vaults: " + err.Error())
+	}
+
+	archive, err := newArchive(conf)
+	if err != nil {
+		log.Fatal("Unable to create the archive: " + err.Error())
+	}
+
+	auditLog, err := openAudit(conf)
+	if err != nil {
+		log.Fatal("Unable to open audit log: " + err.Error())
+	}
+
+	checkPermissions(opts.ConfigFile, conf)
+
+	tracker := &pollTracker{next: http.DefaultTransport, lastPoll: time.Now().UnixNano()}
+
+	front, err := newFrontend(conf, tracker)
+	if err != nil {
+		log.Fatal("Unable to create the frontend: " + err.Error())
+	}
+
+	transport := front.transport
+
+	handler := &handlers.Handler{
+		Chat:           transport,
+		TablesProvider: tableProvider,
+		Locales:        locales,
+		Config:         conf,
+		Audit:          auditLog,
+		Vaults:         vaults,
+
+		ArchiveProvider: archive,
+	}
+
+	if conf.DevicePairing.Enabled {
+		handler.Devices = devices.Open(devicesFile(conf))
+		log.Info("📱 Device pairing is enabled")
+	}
+
+	if conf.Slack.AppToken != "" {
+		handler.Slack = slack.New(conf.Slack.AppToken, conf.Slack.BotToken)
+		log.Info("💬 Slack app is enabled")
+	}
+
+	if conf.EmailIngest.Address != "" {
+		handler.Ingest, err = ingest.LoadKeys(conf.EmailIngest.KeyFile, conf.EmailIngest.SignersFile)
+		if err != nil {
+			log.Fatal("Unable to load the email ingestion keys: " + err.Error())
+		}
+
+		log.Info("📥 Email ingestion from " + conf.EmailIngest.Address)
+	}
+
+	if err = conf.KDF.OrDefault().Validate(); err != nil {
+		log.Fatal("Unable to use the KDF: " + err.Error())
+	}
+
+	if err = handler.CheckLimits(); err != nil {
+		log.Fatal("Unable to use the limits: " + err.Error())
+	}
+
+	handler.Unlockers, err = newUnlockers(conf)
+	if err != nil {
+		log.Fatal("Unable to create the unlockers: " + err.Error())
+	}
+
+	if len(handler.Unlockers) > 0 {
+		if err = handler.AutoUnlock(); err != nil {
+			log.Error("Unable to unlock by the key slots: " + err.Error())
+		} else {
+			log.Info("🔓 Unlocked by the key slots")
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	handler.RestoreGrants()
+	handler.StartRotationReminders()
+	handler.StartArchiving()
+	handler.StartSharingChecks()
+	handler.StartUpdateChecks()
+	handler.WatchExternalChanges()
+	handler.WatchSync()
+	syncers := startSyncers(ctx, tableProvider, vaults)
+	setRouting(transport, handler, conf)
+	go reloadLocales(transport, handler)
+
+	if conf.HTTPListen != "" {
+		go serveHTTP(handler, conf.HTTPListen)
+	}
+
+	if handler.Slack != nil {
+		go handler.ServeSlack(ctx)
+	}
+
+	if handler.Ingest != nil {
+		go handler.WatchMailbox(ctx)
+	}
+
+	if conf.Backup.Schedule != "" {
+		job, schedule, err := newBackupJob(conf)
+		if err != nil {
+			log.Fatal("Unable to configure the backups: " + err.Error())
+		}
+
+		handler.StartBackups(ctx, job, schedule)
+		log.Info("💾 Backups on schedule " + conf.Backup.Schedule)
+	}
+
+	if conf.AccessReview.Enabled {
+		if conf.AccessReview.Schedule == "" {
+			conf.AccessReview.Schedule = handlers.DefaultReviewSchedule
+		}
+
+		schedule, err := backup.ParseSchedule(conf.AccessReview.Schedule)
+		if err != nil {
+			log.Fatal("Unable to parse the access review schedule: " + err.Error())
+		}
+
+		handler.StartAccessReviews(ctx, schedule)
+		log.Info("📋 Access reviews on schedule " + conf.AccessReview.Schedule)
+	}
+
+	go func() {
+		<-ctx.Done()
+		log.Info("🛑 Stop " + front.name)
+		notify(systemd.Stopping)
+		front.stop()
+	}()
+
+	startWatchdog(ctx, tracker, syncers)
+	startHealthFile(ctx, conf, tracker, syncers)
+	notify(systemd.Ready)
+
+	log.Info("🚀 Start " + front.name)
+	front.start()
+
+	for _, syncer := range syncers {
+		if err := syncer.Close(); err != nil {
+			log.Error("Close storage: " + err.Error())
+		}
+	}
+
+	auditLog.Close()
+}
+
+// startSyncers starts the background sync of the storages which read the
+// remote tables, the syncs end with the context.
+func startSyncers(
+	ctx context.Context, tp providers.StorageProvider, vaults map[string]providers.StorageProvider,
+) []providers.Syncer {
+	var syncers []providers.Syncer
+
+	storages := []providers.StorageProvider{tp}
+	for _, vault := range vaults {
+		storages = append(storages, vault)
+	}
+
+	for _, storage := range storages {
+		if syncer, ok := storage.(providers.Syncer); ok {
+			syncer.Start(ctx)
+			syncers = append(syncers, syncer)
+		}
+	}
+
+	return syncers
+}
+
+// loadLocales loads the embedded locales and the locales of the directory
+// over them.
+func loadLocales(dir string) (*localizator.Localizator, error) {
+	locales := new(localizator.Localizator)
+	if err := locales.InitFromFS(localesFS, "locales"); err != nil {
+		return nil, errors.Wrap(err, "embedded")
+	}
+
+	if dir == "" {
+		return locales, nil
+	}
+
+	log.Info("📂 Locales directory: " + dir)
+
+	if err := locales.InitFromFS(os.DirFS(dir), "."); err != nil {
+		return nil, errors.Wrap(err, dir)
+	}
+
+	return locales, nil
+}
 
+func logLocalesReport(locales *localizator.Localizator) {
+	for _, c := range locales.Report() {
+		if c.Complete() {
+			continue
+		}
+
+		log.Info("🌎 Locale "+c.Locale+" differs from en",
+			"missing", strings.Join(c.Missing, ","), "extra", strings.Join(c.Extra, ","))
+	}
+}
+
+func newStorageProvider(conf *config.Config) (providers.StorageProvider, error) {
 	switch conf.StorageSource {
 	case "", "json_file":
 		if conf.JSONStorageFile == "" {
@@ -87,73 +330,339 @@ func main() {
 		log.Info("🗂 Source: JSON Storage")
 		log.Info("📄 JSON Storage file: " + conf.JSONStorageFile)
 
-		tableProvider, err = providers.NewJSONStorage(conf.JSONStorageFile)
-		if err != nil {
-			log.Fatal("Unable to create tables provider: " + err.Error())
+		if conf.JSONStorageEncrypted {
+			log.Info("🔒 JSON Storage is encrypted with the master password")
+
+			return providers.NewEncryptedJSONStorage(conf.JSONStorageFile)
 		}
 
+		return providers.NewJSONStorage(conf.JSONStorageFile)
+	case "csv_file":
+		if conf.CSVStorage.File == "" {
+			conf.CSVStorage.File = "./storage.csv"
+		}
+
+		if conf.CSVStorage.KeyFile == "" {
+			conf.CSVStorage.KeyFile = conf.CSVStorage.File + ".key"
+		}
+
+		log.Info("🗂 Source: CSV Storage")
+		log.Info("📄 CSV Storage file: " + conf.CSVStorage.File)
+
+		return providers.NewCSVStorage(conf.CSVStorage.File, conf.CSVStorage.KeyFile)
 	case "google_sheets":
 		log.Info("🗂 Source: Google Sheets storage")
 		log.Info("📝 Google credentials: " + conf.GoogleCredentials)
+
+		if conf.SpreadsheetID == "" {
+			if err := createSpreadsheet(conf); err != nil {
+				return nil, errors.Wrap(err, "create spreadsheet")
+			}
+		}
+
 		log.Info("📄 Spreadsheet ID: " + conf.SpreadsheetID)
-		tableProvider, err = providers.NewGoogleSheetsStorage(conf.GoogleCredentials, conf.SpreadsheetID)
+
+		return newSheetsStorage(conf, conf.SpreadsheetID)
+	case "webdav", "dropbox", "google_drive":
+		return newRemoteStorage(conf)
+	case "aws_secrets_manager", "azure_key_vault":
+		return newSecretStoreStorage(conf)
+	case "dynamodb", "firestore":
+		return newDocumentStorage(conf)
+	default:
+		return nil, errors.New("undefined storage source: " + conf.StorageSource)
+	}
+}
+
+func setReporter(conf config.ErrorReporting) error {
+	switch {
+	case conf.SentryDSN != "":
+		reporter, err := log.NewSentryReporter(conf.SentryDSN)
+		if err != nil {
+			return err
+		}
+
+		log.SetReporter(reporter)
+		log.Info("🚨 Errors are reported to Sentry")
+	case conf.WebhookURL != "":
+		log.SetReporter(log.NewWebhookReporter(conf.WebhookURL))
+		log.Info("🚨 Errors are reported to webhook")
+	}
+
+	return nil
+}
+
+// createSpreadsheet creates the spreadsheet of the storage and writes its ID
+// to the config.
+func createSpreadsheet(conf *config.Config) error {
+	creds, err := conf.GoogleCredentialsJSON()
+	if err != nil {
+		return err
+	}
+
+	id, err := providers.CreateSpreadsheet(creds, "Secretable", conf.SpreadsheetShareWith)
+	if id == "" {
+		return err
+	}
+
+	conf.SpreadsheetID = id
+	log.Info("📄 Created spreadsheet " + id)
+
+	if updateErr := config.UpdateFile(conf); updateErr != nil {
+		return errors.Wrap(updateErr, "update config file")
+	}
+
+	return err
+}
+
+// newSheetsStorage creates the storage of the spreadsheet whose Drive sharing
+// is managed if configured.
+func newSheetsStorage(conf *config.Config, spreadsheetID string) (*providers.GoogleSheetsStorage, error) {
+	creds, err := conf.GoogleCredentialsJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	tp, err := providers.NewGoogleSheetsStorage(creds, spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.SyncInterval > 0 || conf.SyncMaxInterval > 0 {
+		tp.SetSyncInterval(time.Duration(conf.SyncInterval)*time.Second, time.Duration(conf.SyncMaxInterval)*time.Second)
+	}
+
+	if len(conf.DriveSharing.Accounts) == 0 {
+		return tp, nil
+	}
+
+	accounts := conf.DriveSharing.Accounts
+	if conf.SpreadsheetShareWith != "" {
+		accounts = append([]string{conf.SpreadsheetShareWith}, accounts...)
+	}
+
+	sharing, err := providers.NewDriveSharing(creds, accounts, conf.DriveSharing.Revoke)
+	if err != nil {
+		return nil, errors.Wrap(err, "drive sharing")
+	}
+
+	tp.SetSharing(sharing)
+
+	return tp, nil
+}
+
+// newVaults creates the storages of the named vaults, each vault is a
+// spreadsheet of its own.
+func newVaults(conf *config.Config) (map[string]providers.StorageProvider, error) {
+	if len(conf.Vaults) == 0 {
+		return nil, nil
+	}
+
+	if conf.StorageSource != "google_sheets" {
+		return nil, errors.New("vaults need the google_sheets storage source")
+	}
+
+	vaults := make(map[string]providers.StorageProvider, len(conf.Vaults))
+
+	for name, spreadsheetID := range conf.Vaults {
+		name = strings.ToLower(name)
+		if name == handlers.DefaultVault || name == handlers.ArchiveVault || strings.ContainsAny(name, " \t") {
+			return nil, errors.New("invalid vault name " + name)
+		}
+
+		tp, err := newSheetsStorage(conf, spreadsheetID)
+		if err != nil {
+			return nil, errors.Wrap(err, "vault "+name)
+		}
+
+		log.Info("🗃 Vault " + name + ": " + spreadsheetID)
+
+		vaults[name] = tp
+	}
+
+	return vaults, nil
+}
+
+// newArchive creates the storage of the archive policy, nil if the archiving
+// is disabled.
+func newArchive(conf *config.Config) (providers.StorageProvider, error) {
+	if conf.Archive.Months <= 0 {
+		return nil, nil
+	}
+
+	switch conf.StorageSource {
+	case "", "json_file":
+		if conf.JSONStorageEncrypted {
+			return nil, errors.New("archive needs the unencrypted json_file storage")
+		}
+
+		if conf.Archive.File == "" {
+			conf.Archive.File = strings.TrimSuffix(conf.JSONStorageFile, filepath.Ext(conf.JSONStorageFile)) + ".archive.json"
+		}
+
+		log.Info("🗄 Archive file: " + conf.Archive.File)
+
+		return providers.NewJSONStorage(conf.Archive.File)
+	case "google_sheets":
+		creds, err := conf.GoogleCredentialsJSON()
 		if err != nil {
-			log.Fatal("Unable to create tables provider: " + err.Error())
+			return nil, err
 		}
+
+		log.Info("🗄 Archive sheet of the spreadsheet " + conf.SpreadsheetID)
+
+		return providers.NewGoogleSheetsArchive(creds, conf.SpreadsheetID)
 	default:
-		log.Fatal("Undefined storage source: " + conf.StorageSource)
+		return nil, errors.New("archive needs the google_sheets or json_file storage source")
+	}
+}
+
+func openAudit(conf *config.Config) (*audit.Log, error) {
+	if conf.AuditFile == "" {
+		conf.AuditFile = "./audit.log"
 	}
 
-	bot, err := tb.NewBot(tb.Settings{
-		Token: conf.TelegramBotToken,
-		Poller: &tb.LongPoller{
-			Timeout: longPollerTimeout * time.Second,
-		},
-	})
+	log.Info("📒 Audit log file: " + conf.AuditFile)
 
+	auditLog, err := audit.New(conf.AuditFile)
 	if err != nil {
-		log.Fatal("Unable to create new bot instance: " + err.Error())
+		return nil, err
+	}
+
+	sinks := make([]audit.Sink, 0, len(conf.AuditSinks))
+
+	for _, sinkConf := range conf.AuditSinks {
+		sink, err := audit.NewSink(sinkConf)
+		if err != nil {
+			return nil, errors.Wrap(err, "create audit sink")
+		}
+
+		log.Info("📡 Audit sink: " + sinkConf.Type)
+
+		sinks = append(sinks, sink)
 	}
 
-	setRouting(
-		bot,
-		&handlers.Handler{
-			Bot:            bot,
-			TablesProvider: tableProvider,
-			Locales:        locales,
-			Config:         conf,
-		},
-		conf,
-	)
+	auditLog.AddSinks(sinks...)
 
-	log.Info("🚀 Start Telegram Bot")
-	bot.Start()
+	return auditLog, nil
 }
 
 type option struct {
 	ConfigFile string `short:"c" default:"" long:"config" description:"Path to config file" required:"false"`
 }
 
+// getFlags parses the command line, ok is false when the help or one of the
+// CLI commands was run instead of the bot.
 func getFlags() (opts option, ok bool, err error) {
-	_, err = flags.Parse(&opts)
+	// The errors are not printed by the parser, they are logged by the caller.
+	parser := flags.NewParser(&opts, flags.HelpFlag|flags.PassDoubleDash)
+	parser.SubcommandsOptional = true
+
+	if err = addCommands(parser, &opts); err != nil {
+		return opts, false, errors.Wrap(err, "add commands")
+	}
+
+	_, err = parser.Parse()
 	if flags.WroteHelp(err) {
+		fmt.Println(err.Error())
+
 		return opts, false, nil
 	}
 
+	if err != nil && parser.Active != nil {
+		return opts, false, errors.Wrap(err, parser.Active.Name)
+	}
+
 	if err != nil {
 		return opts, false, errors.Wrap(err, "parse flags")
 	}
 
-	if opts.ConfigFile == "" {
-		homedir, err := os.UserHomeDir()
+	if parser.Active != nil {
+		return opts, false, nil
+	}
+
+	opts.ConfigFile, err = configPath(opts.ConfigFile)
+	if err != nil {
+		return opts, false, err
+	}
+
+	return opts, true, nil
+}
+
+// checkPermissions reports the files of the bot which the group or the others
+// can access and fixes them if configured.
+func checkPermissions(configFile string, conf *config.Config) {
+	files := []string{configFile, conf.AuditFile, conf.Log.File, conf.GoogleCredentials}
+
+	if conf.StorageSource == "" || conf.StorageSource == "json_file" {
+		files = append(files, conf.JSONStorageFile)
+	}
+
+	switch conf.StorageSource {
+	case "csv_file":
+		files = append(files, conf.CSVStorage.File, conf.CSVStorage.KeyFile)
+	case "webdav":
+		files = append(files, conf.WebDAVStorage.CacheFile, conf.WebDAVStorage.PasswordFile)
+	case "dropbox":
+		files = append(files, conf.DropboxStorage.CacheFile, conf.DropboxStorage.RefreshTokenFile)
+	case "google_drive":
+		files = append(files, conf.DriveStorage.CacheFile)
+	case "azure_key_vault":
+		files = append(files, conf.AzureKeyVault.ClientSecretFile)
+	}
+
+	if conf.DevicePairing.Enabled {
+		files = append(files, devicesFile(conf))
+	}
+
+	if conf.EmailIngest.Address != "" {
+		files = append(files, conf.EmailIngest.KeyFile)
+	}
+
+	if conf.Backup.Schedule != "" {
+		files = append(files, conf.Backup.PassphraseFile)
+	}
+
+	if conf.Matrix.Homeserver != "" {
+		files = append(files, conf.Matrix.AccessTokenFile)
+	}
+
+	for _, sink := range conf.AuditSinks {
+		files = append(files, sink.Path)
+	}
+
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+
+		loose, err := fileperm.Check(file, conf.FixFilePermissions)
 		if err != nil {
-			return opts, false, errors.Wrap(err, "get home dir")
+			log.Error("Check file permissions: "+err.Error(), "file", file)
+
+			continue
 		}
 
-		opts.ConfigFile = filepath.Join(homedir, ".secretable", "config.yaml")
+		switch {
+		case loose && conf.FixFilePermissions:
+			log.Info("🔐 Restricted the permissions of " + file + " to the owner")
+		case loose:
+			log.Info("⚠️ File " + file + " is accessible by other users, set fix_file_permissions or chmod 600 it")
+		}
 	}
+}
 
-	return opts, true, nil
+func configPath(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+
+	homedir, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "get home dir")
+	}
+
+	return filepath.Join(homedir, ".secretable", "config.yaml"), nil
 }
 
 func getConf(path string) (conf *config.Config, err error) {
@@ -178,62 +687,117 @@ func getConf(path string) (conf *config.Config, err error) {
 	return conf, nil
 }
 
-func middleware(
-	useMasterPassCheck, isQuery, hasAccessControl bool,
-	cleanupTime int, handler *handlers.Handler,
-	next func(*tb.Message),
-) func(*tb.Message) {
-	next = handler.ControlSetSecretMiddleware(isQuery, next)
-	next = handler.ControlMasterPassMiddleware(useMasterPassCheck, isQuery, next)
+func middleware(cmd handlers.Command, cleanupTime int, handler *handlers.Handler) func(*chat.Message) {
+	next := cmd.Handler
 
-	if hasAccessControl {
-		next = handler.AccessMiddleware(next)
+	if !cmd.Interrupt {
+		next = handler.LimitMiddleware(cmd.Endpoint, next)
+		next = handler.SecondFactorMiddleware(cmd.Endpoint, cmd.Query, next)
+		next = handler.DeviceMiddleware(cmd.Endpoint, cmd.Query, next)
+		next = handler.ControlSetSecretMiddleware(cmd.Query, next)
+		next = handler.ControlMasterPassMiddleware(cmd.NeedsUnlock, cmd.Query, next)
+		next = handler.ControlPanicMiddleware(cmd.Query, next)
 	}
 
-	if cleanupTime > 0 {
+	if cmd.Role != handlers.RoleAnyone {
+		next = handler.AnomalyMiddleware(next)
+		next = handler.AccessMiddleware(cmd.Role, next)
+	}
+
+	next = handler.MaintenanceMiddleware(next)
+
+	if cmd.Cleanup == handlers.CleanupOnTimeout && cleanupTime > 0 {
 		next = handler.CleanupMessagesMiddleware(cleanupTime, next)
 	}
 
-	return handler.LoggerMiddleware(next)
+	next = handler.TracingMiddleware(cmd.Endpoint, next)
+	next = handler.LoggerMiddleware(cmd.Redact, next)
+
+	return handler.RequestMiddleware(next)
 }
 
-func setRouting(bot *tb.Bot, handler *handlers.Handler, conf *config.Config) {
-	var cmds = []tb.Command{
-		{
-			Text: "/id", Description: "Get your chat id",
-		},
-		{
-			Text: "/generate", Description: "Generate a strong password as recommended by OWASP. " +
-				"You can pass the length of the password like: /generate 8",
-		},
-		{
-			Text: "/add", Description: "Add a new secret",
-		},
-		{
-			Text: "/delete", Description: "Delete secret by index, for example: /delete 12",
-		},
-		{
-			Text: "/setpass", Description: "Set new master password, for example: /setpass your_new_master_pass",
-		},
+func callbackMiddleware(cb handlers.Callback, cleanupTime int, handler *handlers.Handler) func(*chat.Callback) {
+	wrap := func(next func(*chat.Message)) func(*chat.Message) {
+		if cb.NeedsUnlock {
+			next = handler.UnlockedMiddleware(next)
+		}
+
+		if cb.Role != handlers.RoleAnyone {
+			next = handler.AccessMiddleware(cb.Role, next)
+		}
+
+		next = handler.MaintenanceMiddleware(next)
+
+		if cb.Cleanup == handlers.CleanupOnTimeout && cleanupTime > 0 {
+			next = handler.CleanupMessagesMiddleware(cleanupTime, next)
+		}
+
+		next = handler.TracingMiddleware(cb.Button.Unique, next)
+		next = handler.LoggerMiddleware(false, next)
+
+		return handler.RequestMiddleware(next)
 	}
 
-	startMessage := "Welcome! Just enter text into the chat to find secrets or use the commands:\n\n"
+	return handler.CallbackMiddleware(cb.Button.Unique, wrap, cb.Handler)
+}
+
+func setRouting(transport chat.Transport, handler *handlers.Handler, conf *config.Config) {
+	setCommands(transport, handler)
 
-	if err := bot.SetCommands(cmds); err != nil {
-		log.Error("Error of setting commands: " + err.Error())
+	for _, cmd := range handler.Commands() {
+		transport.RegisterCommand(cmd.Endpoint, middleware(cmd, conf.CleanupTimeout, handler))
+	}
+
+	for _, cb := range handler.Callbacks() {
+		transport.RegisterButton(cb.Button.Unique, callbackMiddleware(cb, conf.CleanupTimeout, handler))
 	}
+}
+
+// reloadLocales reads the locales again and updates the menu commands on
+// SIGHUP.
+func reloadLocales(transport chat.Transport, handler *handlers.Handler) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	for range sighup {
+		if err := handler.Locales.Reload(); err != nil {
+			log.Error("Reload locales: " + err.Error())
+
+			continue
+		}
 
-	for _, cmd := range cmds {
-		startMessage += fmt.Sprintf("<code>%s</code> - %s\n", cmd.Text, cmd.Description)
+		log.Info("🌎 Locales reloaded: " + strings.Join(handler.Locales.GetLocales(), ", "))
+		logLocalesReport(handler.Locales)
+		setCommands(transport, handler)
 	}
+}
 
-	bot.Handle("/start", middleware(false, false, false, 0, handler, handler.MakeStart(startMessage)))
+func serveHTTP(handler *handlers.Handler, addr string) {
+	log.Info("🌐 Start HTTP endpoint on " + addr)
 
-	bot.Handle("/id", middleware(false, false, false, conf.CleanupTimeout, handler, handler.ID))
-	bot.Handle("/generate", middleware(false, false, false, conf.CleanupTimeout, handler, handler.Generate))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler.HTTPHandler(),
+		ReadHeaderTimeout: httpTimeout * time.Second,
+	}
 
-	bot.Handle("/add", middleware(true, false, true, conf.CleanupTimeout, handler, handler.Set))
-	bot.Handle("/setpass", middleware(true, false, true, conf.CleanupTimeout, handler, handler.ResetPass))
-	bot.Handle("/delete", middleware(true, false, true, conf.CleanupTimeout, handler, handler.Delete))
-	bot.Handle(tb.OnText, middleware(true, true, true, conf.CleanupTimeout, handler, handler.Query))
+	if err := server.ListenAndServe(); err != nil {
+		log.Error("HTTP endpoint: " + err.Error())
+	}
+}
+
+func setCommands(transport chat.Transport, handler *handlers.Handler) {
+	if err := transport.SetCommands("", handler.MenuCommands("en")); err != nil {
+		log.Error("Error of setting commands: " + err.Error())
+	}
+
+	for _, locale := range handler.Locales.GetLocales() {
+		if locale == "en" {
+			continue
+		}
+
+		if err := transport.SetCommands(locale, handler.MenuCommands(locale)); err != nil {
+			log.Error("Error of setting commands for locale " + locale + ": " + err.Error())
+		}
+	}
 }
@@ -0,0 +1,78 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"secretable/pkg/config"
+	"secretable/pkg/version"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrSelfUpdateDisabled = errors.New("self-update is disabled by disable_self_update")
+	ErrDevelopmentBuild   = errors.New("development builds aren't updated")
+)
+
+type selfUpdateCommand struct {
+	opts *option
+
+	Force bool `long:"force" description:"Reinstall the latest release even if the version is the same"`
+}
+
+func (c *selfUpdateCommand) Execute([]string) error {
+	path, err := configPath(c.opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	conf, err := config.ParseFromFile(path)
+	if err != nil {
+		return errors.Wrap(err, "parse config from file")
+	}
+
+	if conf.DisableSelfUpdate {
+		return ErrSelfUpdateDisabled
+	}
+
+	if !version.Released() {
+		return ErrDevelopmentBuild
+	}
+
+	release, err := version.Latest(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if !release.Newer() && !c.Force {
+		fmt.Println("up to date, the latest release is " + release.Tag)
+
+		return nil
+	}
+
+	fmt.Println("updating " + version.Version + " to " + release.Tag + ", " + version.AssetName())
+
+	executable, err := version.Update(context.Background(), release)
+	if err != nil {
+		return errors.Wrap(err, "update")
+	}
+
+	fmt.Println("updated " + executable + ", restart the bot to run " + release.Tag)
+
+	return nil
+}
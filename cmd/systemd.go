@@ -0,0 +1,121 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+	"secretable/pkg/systemd"
+)
+
+const telegramTimeout = time.Minute
+
+// pollTracker records the time of the last successful poll of the updates.
+type pollTracker struct {
+	next     http.RoundTripper
+	lastPoll int64
+}
+
+func (p *pollTracker) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := p.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusOK && strings.HasSuffix(req.URL.Path, "/getUpdates") {
+		p.alive()
+	}
+
+	return resp, err
+}
+
+// alive records a successful poll, a response of signal-cli or a Matrix sync.
+func (p *pollTracker) alive() {
+	atomic.StoreInt64(&p.lastPoll, time.Now().UnixNano())
+}
+
+func (p *pollTracker) since() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&p.lastPoll)))
+}
+
+func newTelegramClient(tracker *pollTracker) *http.Client {
+	return &http.Client{Timeout: telegramTimeout, Transport: tracker}
+}
+
+// notify sends the state to systemd if the bot runs in a Type=notify unit.
+func notify(state string) {
+	sent, err := systemd.Notify(state)
+	if err != nil {
+		log.Error("Notify systemd: "+err.Error(), "state", state)
+
+		return
+	}
+
+	if sent {
+		log.Info("🩺 Notified systemd: " + state)
+	}
+}
+
+// startWatchdog sends the keepalives of WatchdogSec while the updates are
+// polled and no sync of the storages hangs, so systemd restarts a stuck bot.
+func startWatchdog(ctx context.Context, tracker *pollTracker, syncers []providers.Syncer) {
+	interval := systemd.WatchdogInterval()
+	if interval <= 0 {
+		return
+	}
+
+	log.Info("🩺 Systemd watchdog every " + interval.String())
+
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if reason := stuck(tracker, syncers, interval); reason != "" {
+				log.Error("Skip watchdog keepalive: " + reason)
+
+				continue
+			}
+
+			if _, err := systemd.Notify(systemd.Watchdog); err != nil {
+				log.Error("Notify systemd watchdog: " + err.Error())
+			}
+		}
+	}()
+}
+
+// stuck returns why the bot looks hung, empty if it doesn't.
+func stuck(tracker *pollTracker, syncers []providers.Syncer, interval time.Duration) string {
+	if since := tracker.since(); since > interval {
+		return "no updates polled for " + since.Round(time.Second).String()
+	}
+
+	for _, syncer := range syncers {
+		running := syncer.SyncStatus().Running
+		if !running.IsZero() && time.Since(running) > interval {
+			return "storage sync running for " + time.Since(running).Round(time.Second).String()
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,57 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"secretable/pkg/config"
+	"secretable/pkg/keyslots"
+
+	"github.com/pkg/errors"
+)
+
+// newUnlockers returns the unlockers of the key slots from the unlock config,
+// they remember the unwrapped keys so KMS and the YubiKey are asked once.
+func newUnlockers(conf *config.Config) ([]keyslots.Unlocker, error) {
+	var unlockers []keyslots.Unlocker
+
+	if conf.Unlock.Keyfile != "" {
+		keyfile, err := keyslots.NewKeyfile(conf.Unlock.Keyfile)
+		if err != nil {
+			return nil, errors.Wrap(err, "open key file")
+		}
+
+		unlockers = append(unlockers, keyfile)
+	}
+
+	if kms := conf.Unlock.KMS; kms.KeyID != "" {
+		unlockers = append(unlockers, keyslots.NewKMS(kms.Endpoint, kms.Region, kms.KeyID,
+			awsCredentials(kms.AccessKeyID, kms.SecretAccessKey)))
+	}
+
+	if conf.Unlock.YubiKeySlot != 0 {
+		yubikey, err := keyslots.NewYubiKey(conf.Unlock.YubiKeySlot)
+		if err != nil {
+			return nil, errors.Wrap(err, "create yubikey unlocker")
+		}
+
+		unlockers = append(unlockers, yubikey)
+	}
+
+	for i, unlocker := range unlockers {
+		unlockers[i] = keyslots.NewMemo(unlocker)
+	}
+
+	return unlockers, nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"secretable/pkg/audit"
+	"secretable/pkg/handlers"
+
+	"github.com/pkg/errors"
+)
+
+var ErrUnhealthySecrets = errors.New("unhealthy secrets")
+
+type verifyCommand struct {
+	opts *option
+}
+
+func (c *verifyCommand) Execute([]string) error {
+	v, err := openVault(c.opts)
+	if err != nil {
+		return err
+	}
+
+	defer v.audit.Close()
+
+	counts := make(map[handlers.Health]int)
+
+	for _, secret := range v.secrets {
+		health := handlers.CheckSecret(v.privkey, secret, v.signed())
+		counts[health]++
+
+		if health == handlers.HealthMAC {
+			v.record(audit.ActionIntegrity, audit.SecretKey(secret), secret.StableID())
+		}
+
+		fmt.Printf("%s\t%s\n", secret.StableID(), health)
+	}
+
+	fmt.Printf("%d secrets: %d ok, %d unsigned, %d encoding, %d mac, %d decrypt\n", len(v.secrets),
+		counts[handlers.HealthOK], counts[handlers.HealthUnsigned], counts[handlers.HealthEncoding],
+		counts[handlers.HealthMAC], counts[handlers.HealthDecrypt])
+
+	if counts[handlers.HealthEncoding]+counts[handlers.HealthMAC]+counts[handlers.HealthDecrypt] > 0 {
+		return ErrUnhealthySecrets
+	}
+
+	return nil
+}
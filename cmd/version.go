@@ -0,0 +1,47 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"secretable/pkg/version"
+)
+
+type versionCommand struct {
+	Check bool `long:"check" description:"Compare with the latest GitHub release"`
+}
+
+func (c *versionCommand) Execute([]string) error {
+	fmt.Println("secretable " + version.String())
+
+	if !c.Check {
+		return nil
+	}
+
+	release, err := version.Latest(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if release.Newer() {
+		fmt.Println("update available: " + release.Tag + " " + release.URL)
+	} else {
+		fmt.Println("up to date, the latest release is " + release.Tag)
+	}
+
+	return nil
+}
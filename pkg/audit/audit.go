@@ -0,0 +1,629 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"secretable/pkg/fileperm"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ActionReveal  = "reveal"
+	ActionAdd     = "add"
+	ActionDelete  = "delete"
+	ActionSetPass = "setpass"
+	ActionShare   = "share"
+	ActionExpire  = "share_expire"
+	ActionPanic   = "panic"
+	ActionUnlock  = "unlock"
+	ActionEdit    = "edit"
+	ActionPolicy  = "rotation_policy"
+	ActionRemind  = "rotation_remind"
+
+	// ActionSecondFactor records the accepted and rejected TOTP codes.
+	ActionSecondFactor = "second_factor"
+	// ActionDevice records the pairings and the checked pairing codes.
+	ActionDevice = "device"
+	// ActionLink records the one-time links created, viewed and expired.
+	ActionLink = "link"
+	// ActionRetag records the new tags of a secret, the details keep the old
+	// key so the age and the rotation policy are carried over.
+	ActionRetag = "retag"
+	// ActionIntegrity records the secrets whose stored fields don't match
+	// their MAC, the details keep the ID.
+	ActionIntegrity = "integrity"
+	// ActionSigned records the vault whose secrets stored before the MACs
+	// are signed, the details keep the name of the vault. A secret without
+	// the MAC of a signed vault is tampered.
+	ActionSigned = "signed"
+	// ActionKeySlot records the key slots added and removed, the details keep
+	// the change and the type, e.g. "add kms".
+	ActionKeySlot = "key_slot"
+	// ActionDecryptFailure records the chats which reached the limit of the
+	// decrypt failures, the details keep the operation and the count.
+	ActionDecryptFailure = "decrypt_failure"
+	// ActionBlock and ActionUnblock record the chats blocked after the denied
+	// messages and unblocked by an admin, the target is the chat.
+	ActionBlock   = "block"
+	ActionUnblock = "unblock"
+	// ActionInvite records the invite codes issued by an admin, the details
+	// keep the hash of the code and the role. ActionJoin records the chat
+	// which redeemed the code with the same details, ActionLeave removes the
+	// joined chat of the target.
+	ActionInvite = "invite"
+	ActionJoin   = "join"
+	ActionLeave  = "leave"
+	// ActionApproval records the requests of the restricted secrets and the
+	// answers of the approvers, the details keep the step and the target is
+	// the requesting chat.
+	ActionApproval = "approval"
+	// ActionSudo records the elevations of /sudo, the details keep the step:
+	// requested, granted with the duration and the confirmation, rejected,
+	// ended or expired.
+	ActionSudo = "sudo"
+	// ActionAnomaly records the anomaly alerts of the chats and the locks of
+	// the vault, the details keep the kind and the target is the chat.
+	ActionAnomaly = "anomaly"
+	// ActionCanary records the canary secrets added and removed by the
+	// admins and the revealed canaries, the details keep the step.
+	ActionCanary = "canary"
+
+	// CanaryTripped is the step of the revealed canaries.
+	CanaryTripped = "tripped"
+	// ActionCommand records the uses of the commands with a limit, the
+	// details keep the name of the command.
+	ActionCommand = "command"
+	// ActionArchive records the secrets moved to the archive and back, the
+	// details keep the step: archived or restored.
+	ActionArchive = "archive"
+
+	// ArchiveRestored is the step of the secrets moved back from the archive.
+	ArchiveRestored = "restored"
+
+	// UsesReveal and UsesDelete count the revealed and the deleted secrets
+	// along with the commands, the shared secrets and the created links are
+	// counted as revealed.
+	UsesReveal = "reveal"
+	UsesDelete = "delete"
+
+	recentLimit = 50
+	usesLimit   = 1000
+	keyLength   = 8
+)
+
+type Event struct {
+	Time      time.Time `json:"time"`
+	ChatID    int64     `json:"chat_id"`
+	Username  string    `json:"username,omitempty"`
+	Action    string    `json:"action"`
+	SecretKey string    `json:"secret_key,omitempty"`
+	Details   string    `json:"details,omitempty"`
+
+	// Target, MessageID and Expires describe a grant of the share action.
+	Target    int64      `json:"target,omitempty"`
+	MessageID int        `json:"message_id,omitempty"`
+	Expires   *time.Time `json:"expires,omitempty"`
+}
+
+// Grant is a secret shared with another chat until the message expires.
+type Grant struct {
+	SecretKey string
+	From      int64
+	To        int64
+	MessageID int
+	Expires   time.Time
+}
+
+// invite is an invite code not redeemed yet.
+type invite struct {
+	role    string
+	expires time.Time
+}
+
+type Usage struct {
+	Count      int
+	LastAccess time.Time
+}
+
+// Log is an append-only journal of the bot activity. The journal is kept in
+// a JSON lines file and replayed on start to restore the usage counters.
+type Log struct {
+	filepath string
+
+	usage  map[string]*Usage
+	added  map[string]time.Time
+	recent map[int64][]string
+
+	policies map[string]int
+	reminded map[string]time.Time
+
+	chats  map[string]int64
+	grants []Grant
+
+	blocked map[int64]bool
+
+	invites map[string]invite
+	joined  map[int64]string
+
+	// uses keeps the times of the latest reveals and limited commands of
+	// the chats.
+	uses map[int64]map[string][]time.Time
+
+	canaries map[string]bool
+
+	// restored keeps the time the secrets were moved back from the archive.
+	restored map[string]time.Time
+
+	// signed keeps the names of the vaults with all the secrets signed.
+	signed map[string]bool
+
+	// seen keeps the time of the latest event of the chats.
+	seen map[int64]time.Time
+
+	queue chan Event
+	done  chan struct{}
+
+	mx sync.RWMutex
+}
+
+func New(path string) (*Log, error) {
+	l := &Log{
+		filepath: path,
+		usage:    make(map[string]*Usage),
+		added:    make(map[string]time.Time),
+		recent:   make(map[int64][]string),
+		chats:    make(map[string]int64),
+		policies: make(map[string]int),
+		reminded: make(map[string]time.Time),
+		blocked:  make(map[int64]bool),
+		invites:  make(map[string]invite),
+		joined:   make(map[int64]string),
+		uses:     make(map[int64]map[string][]time.Time),
+		seen:     make(map[int64]time.Time),
+		canaries: make(map[string]bool),
+		restored: make(map[string]time.Time),
+		signed:   make(map[string]bool),
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, errors.Wrap(err, "open file")
+		}
+
+		if err := fileperm.MkdirAll(path); err != nil {
+			return nil, errors.Wrap(err, "mkdir")
+		}
+
+		log.Info("📒 Created audit log file " + path)
+
+		return l, nil
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			log.Error("Skip broken audit log line: " + err.Error())
+
+			continue
+		}
+
+		l.apply(event)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read file")
+	}
+
+	return l, nil
+}
+
+// SecretKey returns a short stable reference of the stored secret which does
+// not reveal anything about its content.
+func SecretKey(secret providers.SecretsData) string {
+	h := sha256.Sum256([]byte(secret.Description + "\n" + secret.Username + "\n" + secret.Secret))
+
+	return hex.EncodeToString(h[:keyLength])
+}
+
+func (l *Log) Record(event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b, _ := json.Marshal(event)
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	l.apply(event)
+
+	file, err := fileperm.OpenAppend(l.filepath)
+	if err != nil {
+		return errors.Wrap(err, "open file")
+	}
+
+	defer file.Close()
+
+	if _, err = file.Write(append(b, '\n')); err != nil {
+		return errors.Wrap(err, "write file")
+	}
+
+	l.forward(event)
+
+	return nil
+}
+
+func (l *Log) apply(event Event) {
+	if event.Username != "" {
+		l.chats[strings.ToLower(event.Username)] = event.ChatID
+	}
+
+	if event.ChatID != 0 && event.Time.After(l.seen[event.ChatID]) {
+		l.seen[event.ChatID] = event.Time
+	}
+
+	switch event.Action {
+	case ActionAdd:
+		if event.SecretKey != "" {
+			l.added[event.SecretKey] = event.Time
+		}
+	case ActionEdit:
+		if event.SecretKey != "" {
+			l.added[event.SecretKey] = event.Time
+
+			if days, ok := l.policies[event.Details]; ok {
+				l.policies[event.SecretKey] = days
+			}
+
+			if l.canaries[event.Details] {
+				l.canaries[event.SecretKey] = true
+			}
+		}
+	case ActionRetag:
+		if added, ok := l.added[event.Details]; ok && event.SecretKey != "" {
+			l.added[event.SecretKey] = added
+		}
+
+		if days, ok := l.policies[event.Details]; ok && event.SecretKey != "" {
+			l.policies[event.SecretKey] = days
+		}
+
+		if l.canaries[event.Details] && event.SecretKey != "" {
+			l.canaries[event.SecretKey] = true
+		}
+	case ActionPolicy:
+		if days, err := strconv.Atoi(event.Details); err == nil && event.SecretKey != "" {
+			l.policies[event.SecretKey] = days
+		}
+	case ActionRemind:
+		l.reminded[event.SecretKey] = event.Time
+	case ActionBlock:
+		l.blocked[event.Target] = true
+	case ActionUnblock:
+		delete(l.blocked, event.Target)
+	case ActionInvite:
+		if parts := strings.Fields(event.Details); len(parts) == 2 && event.Expires != nil {
+			l.invites[parts[0]] = invite{role: parts[1], expires: *event.Expires}
+		}
+	case ActionJoin:
+		if parts := strings.Fields(event.Details); len(parts) == 2 {
+			delete(l.invites, parts[0])
+			l.joined[event.ChatID] = parts[1]
+		}
+	case ActionLeave:
+		delete(l.joined, event.Target)
+	case ActionCanary:
+		switch event.Details {
+		case "add":
+			l.canaries[event.SecretKey] = true
+		case "remove":
+			delete(l.canaries, event.SecretKey)
+		}
+	case ActionCommand:
+		l.applyUse(event.ChatID, event.Details, event.Time)
+	case ActionArchive:
+		if event.Details == ArchiveRestored {
+			l.restored[event.SecretKey] = event.Time
+		}
+	case ActionSigned:
+		l.signed[event.Details] = true
+	case ActionDelete:
+		l.applyUse(event.ChatID, UsesDelete, event.Time)
+	case ActionReveal:
+		if event.SecretKey != "" {
+			l.applyReveal(event)
+			l.applyUse(event.ChatID, UsesReveal, event.Time)
+		}
+	case ActionShare:
+		l.applyUse(event.ChatID, UsesReveal, event.Time)
+
+		if event.Expires != nil {
+			l.grants = append(l.grants, Grant{
+				SecretKey: event.SecretKey,
+				From:      event.ChatID,
+				To:        event.Target,
+				MessageID: event.MessageID,
+				Expires:   *event.Expires,
+			})
+		}
+	case ActionLink:
+		if event.Details == "created" {
+			l.applyUse(event.ChatID, UsesReveal, event.Time)
+		}
+	case ActionExpire:
+		for i, g := range l.grants {
+			if g.To == event.Target && g.MessageID == event.MessageID {
+				l.grants = append(l.grants[:i], l.grants[i+1:]...)
+
+				break
+			}
+		}
+	}
+}
+
+func (l *Log) applyReveal(event Event) {
+	u, ok := l.usage[event.SecretKey]
+	if !ok {
+		u = new(Usage)
+		l.usage[event.SecretKey] = u
+	}
+
+	u.Count++
+	if event.Time.After(u.LastAccess) {
+		u.LastAccess = event.Time
+	}
+
+	recent := []string{event.SecretKey}
+
+	for _, key := range l.recent[event.ChatID] {
+		if key != event.SecretKey && len(recent) < recentLimit {
+			recent = append(recent, key)
+		}
+	}
+
+	l.recent[event.ChatID] = recent
+}
+
+func (l *Log) applyUse(chatID int64, name string, at time.Time) {
+	uses, ok := l.uses[chatID]
+	if !ok {
+		uses = make(map[string][]time.Time)
+		l.uses[chatID] = uses
+	}
+
+	times := append(uses[name], at)
+	if len(times) > usesLimit {
+		times = times[len(times)-usesLimit:]
+	}
+
+	uses[name] = times
+}
+
+// Uses returns the times of the uses of the command by the chat since the
+// time, oldest first. Only the latest uses of the limit are kept.
+func (l *Log) Uses(chatID int64, name string, since time.Time) []time.Time {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	var uses []time.Time
+
+	for _, at := range l.uses[chatID][name] {
+		if !at.Before(since) {
+			uses = append(uses, at)
+		}
+	}
+
+	return uses
+}
+
+func (l *Log) Usage(key string) Usage {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	if u, ok := l.usage[key]; ok {
+		return *u
+	}
+
+	return Usage{}
+}
+
+// ChatByUsername returns the chat ID of the user seen by the bot before.
+func (l *Log) ChatByUsername(username string) (int64, bool) {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	id, ok := l.chats[strings.ToLower(strings.TrimPrefix(username, "@"))]
+
+	return id, ok
+}
+
+// Canary reports whether the secret with the key is a canary.
+func (l *Log) Canary(key string) bool {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	return l.canaries[key]
+}
+
+// Signed reports whether the secrets of the vault stored before the MACs are
+// signed.
+func (l *Log) Signed(vault string) bool {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	return l.signed[vault]
+}
+
+// MostRevealed returns the keys of at most n secrets revealed the most, all
+// of the revealed ones if n is zero.
+func (l *Log) MostRevealed(n int) []string {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	keys := make([]string, 0, len(l.usage))
+	for key, u := range l.usage {
+		if u.Count > 0 {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if l.usage[keys[i]].Count != l.usage[keys[j]].Count {
+			return l.usage[keys[i]].Count > l.usage[keys[j]].Count
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	if n > 0 && len(keys) > n {
+		keys = keys[:n]
+	}
+
+	return keys
+}
+
+// LastSeen returns the time of the latest event of the chat.
+func (l *Log) LastSeen(chatID int64) time.Time {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	return l.seen[chatID]
+}
+
+// Blocked reports whether the chat is blocked.
+func (l *Log) Blocked(chatID int64) bool {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	return l.blocked[chatID]
+}
+
+// Invite returns the role of the invite code with the hash, ok is false if
+// the code is unknown, redeemed or expired.
+func (l *Log) Invite(hash string) (role string, ok bool) {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	inv, ok := l.invites[hash]
+	if !ok || time.Now().After(inv.expires) {
+		return "", false
+	}
+
+	return inv.role, true
+}
+
+// Joined returns the role of the chat which redeemed an invite code.
+func (l *Log) Joined(chatID int64) (role string, ok bool) {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	role, ok = l.joined[chatID]
+
+	return role, ok
+}
+
+// JoinedChats returns the chats which redeemed an invite code.
+func (l *Log) JoinedChats() []int64 {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	chats := make([]int64, 0, len(l.joined))
+	for chatID := range l.joined {
+		chats = append(chats, chatID)
+	}
+
+	return chats
+}
+
+// Grants returns the shared secrets whose messages have not been expired yet.
+func (l *Log) Grants() []Grant {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	grants := make([]Grant, len(l.grants))
+	copy(grants, l.grants)
+
+	return grants
+}
+
+// Restored returns the time the secret was moved back from the archive, zero
+// if it never was.
+func (l *Log) Restored(key string) time.Time {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	return l.restored[key]
+}
+
+// Added returns the time when the secret was added, if it is known.
+func (l *Log) Added(key string) (time.Time, bool) {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	t, ok := l.added[key]
+
+	return t, ok
+}
+
+// Policy returns the rotation period in days set for the secret.
+func (l *Log) Policy(key string) (int, bool) {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	days, ok := l.policies[key]
+
+	return days, ok
+}
+
+// Reminded returns the time of the last rotation reminder of the secret.
+func (l *Log) Reminded(key string) time.Time {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	return l.reminded[key]
+}
+
+// Recent returns keys of the secrets revealed in the chat, most recent first.
+func (l *Log) Recent(chatID int64, limit int) []string {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	recent := l.recent[chatID]
+	if len(recent) > limit {
+		recent = recent[:limit]
+	}
+
+	keys := make([]string, len(recent))
+	copy(keys, recent)
+
+	return keys
+}
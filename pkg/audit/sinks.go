@@ -0,0 +1,274 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"secretable/pkg/fileperm"
+	"secretable/pkg/log"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	SinkSyslog  = "syslog"
+	SinkWebhook = "webhook"
+	SinkFile    = "file"
+
+	FormatJSON = "json"
+	FormatCEF  = "cef"
+
+	// SignatureHeader keeps the hex HMAC-SHA256 of the webhook body.
+	SignatureHeader = "X-Secretable-Signature"
+
+	sinkQueueSize  = 256
+	webhookTimeout = 10 * time.Second
+)
+
+var (
+	ErrUnknownSink   = errors.New("unknown audit sink type")
+	ErrUnknownFormat = errors.New("unknown audit sink format")
+)
+
+// SinkConfig describes an external destination of the audit events.
+type SinkConfig struct {
+	// Type is "syslog", "webhook" or "file".
+	Type string `yaml:"type"`
+	// Format is "json" (default) or "cef", the webhook always sends JSON.
+	Format string `yaml:"format"`
+
+	// Network and Address of the syslog server, the local syslog is used
+	// if empty.
+	Network string `yaml:"network"`
+	Address string `yaml:"address"`
+
+	// URL and Secret of the webhook, the body is signed with the secret.
+	URL    string `yaml:"url"`
+	Secret string `yaml:"secret"`
+
+	// Path of the JSON lines file.
+	Path string `yaml:"path"`
+}
+
+// Sink receives a copy of every recorded event.
+type Sink interface {
+	Write(Event) error
+}
+
+func NewSink(conf SinkConfig) (Sink, error) {
+	format, err := newFormatter(conf.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	switch conf.Type {
+	case SinkSyslog:
+		return newSyslogSink(conf, format)
+	case SinkWebhook:
+		return &webhookSink{url: conf.URL, secret: []byte(conf.Secret), client: &http.Client{Timeout: webhookTimeout}}, nil
+	case SinkFile:
+		return &fileSink{path: conf.Path, format: format}, nil
+	default:
+		return nil, errors.Wrap(ErrUnknownSink, conf.Type)
+	}
+}
+
+// AddSinks starts forwarding the recorded events to the sinks. The events are
+// delivered in the background, so a slow sink does not block the bot.
+func (l *Log) AddSinks(sinks ...Sink) {
+	if len(sinks) == 0 {
+		return
+	}
+
+	queue := make(chan Event, sinkQueueSize)
+	done := make(chan struct{})
+
+	l.mx.Lock()
+	l.queue = queue
+	l.done = done
+	l.mx.Unlock()
+
+	go func() {
+		defer close(done)
+
+		for event := range queue {
+			for _, sink := range sinks {
+				if err := sink.Write(event); err != nil {
+					log.Error("Write audit sink: "+err.Error(), "action", event.Action)
+				}
+			}
+		}
+	}()
+}
+
+// Close delivers the queued events to the sinks and stops forwarding.
+func (l *Log) Close() {
+	l.mx.Lock()
+	queue, done := l.queue, l.done
+	l.queue = nil
+	l.mx.Unlock()
+
+	if queue == nil {
+		return
+	}
+
+	close(queue)
+	<-done
+}
+
+// QueueDepth returns the number of the events waiting for the sinks.
+func (l *Log) QueueDepth() int {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	return len(l.queue)
+}
+
+func (l *Log) forward(event Event) {
+	if l.queue == nil {
+		return
+	}
+
+	select {
+	case l.queue <- event:
+	default:
+		log.Error("Audit sink queue is full, event dropped", "action", event.Action)
+	}
+}
+
+type formatter func(Event) ([]byte, error)
+
+func newFormatter(format string) (formatter, error) {
+	switch format {
+	case "", FormatJSON:
+		return func(event Event) ([]byte, error) {
+			return json.Marshal(event)
+		}, nil
+	case FormatCEF:
+		return formatCEF, nil
+	default:
+		return nil, errors.Wrap(ErrUnknownFormat, format)
+	}
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+// formatCEF renders the event in the ArcSight Common Event Format.
+func formatCEF(event Event) ([]byte, error) {
+	severity := 3
+	if event.Action == ActionPanic || event.Action == ActionSetPass {
+		severity = 8
+	}
+
+	if event.Action == ActionCanary && event.Details == CanaryTripped {
+		severity = 10
+	}
+
+	ext := []string{
+		"rt=" + fmt.Sprint(event.Time.UnixNano()/int64(time.Millisecond)),
+		"suid=" + fmt.Sprint(event.ChatID),
+	}
+
+	if event.Username != "" {
+		ext = append(ext, "suser="+cefExtensionEscaper.Replace(event.Username))
+	}
+
+	if event.SecretKey != "" {
+		ext = append(ext, "cs1Label=secret_key", "cs1="+event.SecretKey)
+	}
+
+	if event.Details != "" {
+		ext = append(ext, "msg="+cefExtensionEscaper.Replace(event.Details))
+	}
+
+	if event.Target != 0 {
+		ext = append(ext, "cs2Label=target", "cs2="+fmt.Sprint(event.Target))
+	}
+
+	action := cefHeaderEscaper.Replace(event.Action)
+
+	return []byte(fmt.Sprintf("CEF:0|Secretable|Secretable|1|%s|%s|%d|%s",
+		action, action, severity, strings.Join(ext, " "))), nil
+}
+
+type webhookSink struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+func (s *webhookSink) Write(event Event) error {
+	body, _ := json.Marshal(event)
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(body)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send request")
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.New("webhook responded " + resp.Status)
+	}
+
+	return nil
+}
+
+type fileSink struct {
+	path   string
+	format formatter
+}
+
+func (s *fileSink) Write(event Event) error {
+	b, err := s.format(event)
+	if err != nil {
+		return err
+	}
+
+	file, err := fileperm.OpenAppend(s.path)
+	if err != nil {
+		return errors.Wrap(err, "open file")
+	}
+
+	defer file.Close()
+
+	if _, err = file.Write(append(b, '\n')); err != nil {
+		return errors.Wrap(err, "write file")
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package audit
+
+import (
+	"log/syslog"
+
+	"github.com/pkg/errors"
+)
+
+const syslogTag = "secretable"
+
+type syslogSink struct {
+	writer *syslog.Writer
+	format formatter
+}
+
+func newSyslogSink(conf SinkConfig, format formatter) (Sink, error) {
+	writer, err := syslog.Dial(conf.Network, conf.Address, syslog.LOG_INFO|syslog.LOG_AUTH, syslogTag)
+	if err != nil {
+		return nil, errors.Wrap(err, "dial syslog")
+	}
+
+	return &syslogSink{writer: writer, format: format}, nil
+}
+
+func (s *syslogSink) Write(event Event) error {
+	b, err := s.format(event)
+	if err != nil {
+		return err
+	}
+
+	return s.writer.Info(string(b))
+}
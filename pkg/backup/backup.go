@@ -0,0 +1,253 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package backup makes the encrypted backup archives of the vaults and keeps
+// the last copies in a cloud storage.
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"secretable/pkg/crypto"
+	"secretable/pkg/providers"
+
+	"github.com/mr-tron/base58/base58"
+	"github.com/pkg/errors"
+)
+
+const (
+	archiveFormat = "secretable-backup-v1"
+	saltSize      = 16
+
+	// nameLayout keeps the archive names in the order of their time.
+	nameLayout = "20060102T150405Z"
+	nameSuffix = ".backup"
+
+	maxArchiveSize = 256 << 20
+)
+
+var ErrWrongPassphrase = errors.New("wrong backup passphrase")
+
+// Vault is a vault in the format of the json_file storage, so a restored
+// vault is used as the storage file as is.
+type Vault struct {
+	Secrets []providers.SecretsData `json:"secrets"`
+	Key     string                  `json:"key"`
+}
+
+// Archive keeps the stored secrets and the keys wrapped with the master
+// password along with the salt, so the secrets are restored with the master
+// password once the archive is opened.
+type Archive struct {
+	Created time.Time        `json:"created"`
+	Salt    string           `json:"salt"`
+	Vaults  map[string]Vault `json:"vaults"`
+}
+
+// sealedArchive is the archive compressed and encrypted as a whole with a key
+// derived from the backup passphrase.
+type sealedArchive struct {
+	Format string `json:"format"`
+	Salt   string `json:"salt"`
+	Nonce  string `json:"nonce"`
+	Data   string `json:"data"`
+}
+
+// Dump reads the storages of the vaults by their names.
+func Dump(salt string, vaults map[string]providers.StorageProvider) (Archive, error) {
+	archive := Archive{Created: time.Now().UTC(), Salt: salt, Vaults: make(map[string]Vault, len(vaults))}
+
+	for name, tp := range vaults {
+		key, err := tp.GetKey()
+		if err != nil {
+			return archive, errors.Wrap(err, "get key of vault "+name)
+		}
+
+		secrets, err := tp.GetSecrets()
+		if err != nil {
+			return archive, errors.Wrap(err, "get secrets of vault "+name)
+		}
+
+		archive.Vaults[name] = Vault{Secrets: secrets, Key: key}
+	}
+
+	return archive, nil
+}
+
+// Seal compresses and encrypts the archive with the passphrase.
+func Seal(archive Archive, passphrase string) ([]byte, error) {
+	var plaintext bytes.Buffer
+
+	zw := gzip.NewWriter(&plaintext)
+	if err := json.NewEncoder(zw).Encode(archive); err != nil {
+		return nil, errors.Wrap(err, "encode archive")
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, errors.Wrap(err, "compress archive")
+	}
+
+	salt, err := crypto.MakeRandom(saltSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "make salt")
+	}
+
+	aead, err := crypto.DeriveCipher([]byte(passphrase), salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "derive cipher")
+	}
+
+	nonce, err := crypto.MakeRandom(aead.NonceSize())
+	if err != nil {
+		return nil, errors.Wrap(err, "make nonce")
+	}
+
+	return json.Marshal(sealedArchive{
+		Format: archiveFormat,
+		Salt:   base58.Encode(salt),
+		Nonce:  base58.Encode(nonce),
+		Data:   base58.Encode(aead.Seal(nil, nonce, plaintext.Bytes(), nil)),
+	})
+}
+
+// Open decrypts the sealed archive with the passphrase.
+func Open(data []byte, passphrase string) (Archive, error) {
+	var (
+		sealed  sealedArchive
+		archive Archive
+	)
+
+	if err := json.Unmarshal(data, &sealed); err != nil || sealed.Format != archiveFormat {
+		return archive, errors.New("not a " + archiveFormat + " archive")
+	}
+
+	salt, err := base58.Decode(sealed.Salt)
+	if err != nil {
+		return archive, errors.Wrap(err, "decode salt")
+	}
+
+	nonce, err := base58.Decode(sealed.Nonce)
+	if err != nil {
+		return archive, errors.Wrap(err, "decode nonce")
+	}
+
+	ciphertext, err := base58.Decode(sealed.Data)
+	if err != nil {
+		return archive, errors.Wrap(err, "decode data")
+	}
+
+	aead, err := crypto.DeriveCipher([]byte(passphrase), salt)
+	if err != nil {
+		return archive, errors.Wrap(err, "derive cipher")
+	}
+
+	if len(nonce) != aead.NonceSize() {
+		return archive, errors.New("invalid nonce size")
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return archive, ErrWrongPassphrase
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(plaintext))
+	if err != nil {
+		return archive, errors.Wrap(err, "decompress archive")
+	}
+
+	err = json.NewDecoder(io.LimitReader(zr, maxArchiveSize)).Decode(&archive)
+
+	return archive, errors.Wrap(err, "decode archive")
+}
+
+// Target is a cloud storage of the archives.
+type Target interface {
+	Put(ctx context.Context, name string, data []byte) error
+	// List returns the names of the stored objects with the prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// Name returns the archive name of the time.
+func Name(prefix string, t time.Time) string {
+	return prefix + t.UTC().Format(nameLayout) + nameSuffix
+}
+
+// Upload stores the archive under a new name and deletes the oldest archives
+// of the prefix beyond the last keep ones. The name of the stored archive is
+// returned.
+func Upload(ctx context.Context, target Target, prefix string, data []byte, keep int) (string, error) {
+	name := Name(prefix, time.Now())
+
+	if err := target.Put(ctx, name, data); err != nil {
+		return "", errors.Wrap(err, "put archive")
+	}
+
+	names, err := target.List(ctx, prefix)
+	if err != nil {
+		return name, errors.Wrap(err, "list archives")
+	}
+
+	var archives []string
+
+	for _, n := range names {
+		if strings.HasSuffix(n, nameSuffix) {
+			archives = append(archives, n)
+		}
+	}
+
+	sort.Strings(archives)
+
+	for len(archives) > keep {
+		if err = target.Delete(ctx, archives[0]); err != nil {
+			return name, errors.Wrap(err, "delete archive "+archives[0])
+		}
+
+		archives = archives[1:]
+	}
+
+	return name, nil
+}
+
+// Job makes the archive of the vaults and uploads it to the target.
+type Job struct {
+	Target     Target
+	Passphrase string
+	Salt       string
+	// Prefix of the archive names, Keep is the number of the archives kept.
+	Prefix string
+	Keep   int
+}
+
+// Run uploads the archive of the vaults and returns its name.
+func (j *Job) Run(ctx context.Context, vaults map[string]providers.StorageProvider) (string, error) {
+	archive, err := Dump(j.Salt, vaults)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := Seal(archive, j.Passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	return Upload(ctx, j.Target, j.Prefix, data, j.Keep)
+}
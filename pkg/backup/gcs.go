@@ -0,0 +1,67 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+// GCS is a bucket of Google Cloud Storage.
+type GCS struct {
+	bucket  string
+	service *storage.Service
+}
+
+// NewGCS returns the bucket accessed with the content of the credentials file
+// of a service account.
+func NewGCS(googleCreds []byte, bucket string) (*GCS, error) {
+	service, err := storage.NewService(context.Background(),
+		option.WithCredentialsJSON(googleCreds), option.WithScopes(storage.DevstorageReadWriteScope))
+	if err != nil {
+		return nil, errors.Wrap(err, "new storage service")
+	}
+
+	return &GCS{bucket: bucket, service: service}, nil
+}
+
+func (g *GCS) Put(ctx context.Context, name string, data []byte) error {
+	_, err := g.service.Objects.Insert(g.bucket, &storage.Object{Name: name}).
+		Media(bytes.NewReader(data)).Context(ctx).Do()
+
+	return errors.Wrap(err, "insert object")
+}
+
+func (g *GCS) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+
+	err := g.service.Objects.List(g.bucket).Prefix(prefix).Pages(ctx, func(objects *storage.Objects) error {
+		for _, object := range objects.Items {
+			names = append(names, object.Name)
+		}
+
+		return nil
+	})
+
+	return names, errors.Wrap(err, "list objects")
+}
+
+func (g *GCS) Delete(ctx context.Context, name string) error {
+	return errors.Wrap(g.service.Objects.Delete(g.bucket, name).Context(ctx).Do(), "delete object")
+}
@@ -0,0 +1,159 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"secretable/pkg/sigv4"
+
+	"github.com/pkg/errors"
+)
+
+const httpTimeout = 5 * time.Minute
+
+// S3 is a bucket of S3 or a compatible storage addressed in the path style,
+// the requests are signed with the signature version 4.
+type S3 struct {
+	// Endpoint is e.g. https://s3.eu-central-1.amazonaws.com or the address
+	// of MinIO.
+	Endpoint    string
+	Region      string
+	Bucket      string
+	Credentials sigv4.Credentials
+
+	client *http.Client
+}
+
+func NewS3(endpoint, region, bucket, accessKeyID, secretAccessKey string) *S3 {
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+
+	return &S3{
+		Endpoint:    strings.TrimSuffix(endpoint, "/"),
+		Region:      region,
+		Bucket:      bucket,
+		Credentials: sigv4.Credentials{AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey},
+		client:      &http.Client{Timeout: httpTimeout},
+	}
+}
+
+func (s *S3) Put(ctx context.Context, name string, data []byte) error {
+	_, err := s.do(ctx, http.MethodPut, name, nil, data)
+
+	return err
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]string, error) {
+	var (
+		names []string
+		token string
+	)
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		body, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+
+		if err = xml.Unmarshal(body, &result); err != nil {
+			return nil, errors.Wrap(err, "decode list")
+		}
+
+		for _, object := range result.Contents {
+			names = append(names, object.Key)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3) Delete(ctx context.Context, name string) error {
+	_, err := s.do(ctx, http.MethodDelete, name, nil, nil)
+
+	return err
+}
+
+// do sends the signed request of the object, or of the bucket if the name is
+// empty, and returns the body of the response.
+func (s *S3) do(ctx context.Context, method, name string, query url.Values, body []byte) ([]byte, error) {
+	path := "/" + s.Bucket
+	if name != "" {
+		path += "/" + name
+	}
+
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse endpoint")
+	}
+
+	uri := sigv4.EscapePath(strings.TrimSuffix(endpoint.Path, "/") + path)
+	rawQuery := sigv4.CanonicalQuery(query)
+
+	reqURL := endpoint.Scheme + "://" + endpoint.Host + uri
+	if rawQuery != "" {
+		reqURL += "?" + rawQuery
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "new request")
+	}
+
+	s.Credentials.Sign(req, "s3", s.Region, uri, rawQuery, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "send request")
+	}
+
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxArchiveSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "read response")
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return nil, errors.New(method + " " + path + ": " + resp.Status)
+	}
+
+	return respBody, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// WebDAV is a collection of a WebDAV server, e.g. Nextcloud, with the basic
+// authentication.
+type WebDAV struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewWebDAV returns the collection of the URL, the collection must exist.
+func NewWebDAV(collectionURL, username, password string) *WebDAV {
+	return &WebDAV{
+		url:      strings.TrimSuffix(collectionURL, "/") + "/",
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: httpTimeout},
+	}
+}
+
+func (d *WebDAV) Put(ctx context.Context, name string, data []byte) error {
+	_, err := d.do(ctx, http.MethodPut, d.url+url.PathEscape(name), nil, data)
+
+	return err
+}
+
+// List returns the names of the members of the collection with the prefix.
+func (d *WebDAV) List(ctx context.Context, prefix string) ([]string, error) {
+	body, err := d.do(ctx, "PROPFIND", d.url, map[string]string{
+		"Depth":        "1",
+		"Content-Type": "application/xml",
+	}, []byte(`<?xml version="1.0"?><propfind xmlns="DAV:"><prop><resourcetype/></prop></propfind>`))
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Responses []struct {
+			Href string `xml:"href"`
+		} `xml:"response"`
+	}
+
+	if err = xml.Unmarshal(body, &result); err != nil {
+		return nil, errors.Wrap(err, "decode multistatus")
+	}
+
+	var names []string
+
+	for _, resp := range result.Responses {
+		href, err := url.PathUnescape(resp.Href)
+		if err != nil || strings.HasSuffix(href, "/") {
+			continue
+		}
+
+		if name := path.Base(href); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+func (d *WebDAV) Delete(ctx context.Context, name string) error {
+	_, err := d.do(ctx, http.MethodDelete, d.url+url.PathEscape(name), nil, nil)
+
+	return err
+}
+
+func (d *WebDAV) do(ctx context.Context, method, target string, headers map[string]string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "new request")
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	if d.username != "" {
+		req.SetBasicAuth(d.username, d.password)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "send request")
+	}
+
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxArchiveSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "read response")
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return nil, errors.New(method + " " + target + ": " + resp.Status)
+	}
+
+	return respBody, nil
+}
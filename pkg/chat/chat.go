@@ -0,0 +1,111 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package chat is the messenger layer of the handlers. A frontend implements
+// the Transport and passes the messages and the button presses of its users
+// as the Message and the Callback.
+package chat
+
+// OnText is the endpoint of the messages which aren't commands.
+const OnText = "\atext"
+
+type Chat struct {
+	ID        int64
+	Username  string
+	FirstName string
+	LastName  string
+}
+
+type User struct {
+	ID           int64
+	Username     string
+	FirstName    string
+	LastName     string
+	LanguageCode string
+}
+
+type Message struct {
+	ID     int
+	Chat   *Chat
+	Sender *User
+	Text   string
+}
+
+// Button is an inline button of a message. The presses are routed by Unique
+// to the handler of the button along with Data. A button with WebAppURL opens
+// the Web App instead.
+type Button struct {
+	Unique    string
+	Text      string
+	Data      string
+	WebAppURL string
+}
+
+// Callback is a press of a button, the message is the one of the button.
+type Callback struct {
+	ID      string
+	Data    string
+	Sender  *User
+	Message *Message
+}
+
+// Options of a sent message, the messages are silent by default.
+type Options struct {
+	Buttons [][]Button
+	// ForceReply asks the client to answer the message.
+	ForceReply bool
+	Notify     bool
+}
+
+// File is a sent document, or a photo with the HTML caption.
+type File struct {
+	Name    string
+	Data    []byte
+	Caption string
+	Photo   bool
+}
+
+// Command is an entry of the command menu.
+type Command struct {
+	Text        string
+	Description string
+}
+
+// Transport sends the messages of the handlers and routes the messages of the
+// users to them. The texts are HTML.
+type Transport interface {
+	SendMessage(chatID int64, text string, opts Options) (*Message, error)
+	SendFile(chatID int64, file File, opts Options) (*Message, error)
+	EditMessage(chatID int64, messageID int, text string, opts Options) error
+	DeleteMessage(chatID int64, messageID int) error
+	// RespondCallback tells the client the press is handled.
+	RespondCallback(c *Callback) error
+
+	// RegisterCommand routes the command, e.g. "/add", or OnText.
+	RegisterCommand(endpoint string, handler func(*Message))
+	// RegisterButton routes the presses of the buttons with the unique.
+	RegisterButton(unique string, handler func(*Callback))
+	// SetCommands sets the command menu of the locale, the empty locale is
+	// the default one.
+	SetCommands(locale string, cmds []Command) error
+
+	// Token is the secret of the bot the Web App init data is signed with.
+	Token() string
+}
+
+// MemberChecker is the transport which tells whether the user is a member of
+// a group, e.g. for the groups of the allowed list.
+type MemberChecker interface {
+	IsMember(groupID, userID int64) (bool, error)
+}
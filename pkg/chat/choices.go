@@ -0,0 +1,47 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chat
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// WithChoices appends the numbered buttons to the text for the messengers
+// without the inline buttons, the choice is pressed by answering its number.
+// The Web App buttons are left out.
+func WithChoices(text string, rows [][]Button) (string, []Button) {
+	var buttons []Button
+
+	for _, row := range rows {
+		for _, btn := range row {
+			if btn.WebAppURL == "" {
+				buttons = append(buttons, btn)
+			}
+		}
+	}
+
+	if len(buttons) == 0 {
+		return text, nil
+	}
+
+	lines := make([]string, len(buttons))
+	for i, btn := range buttons {
+		lines[i] = fmt.Sprintf("%d. %s", i+1, html.EscapeString(btn.Text))
+	}
+
+	return text + "\n\n" + strings.Join(lines, "\n"), buttons
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// AllowedChat is a chat ID, e.g. 123 or -100123 of a group chat.
+	AllowedChat = "chat"
+	// AllowedUser is "user:<id>", the user is allowed in any chat.
+	AllowedUser = "user"
+	// AllowedUsername is "@username" of the user.
+	AllowedUsername = "username"
+	// AllowedGroup is "group:<id>", the members of the Telegram group read
+	// the secrets.
+	AllowedGroup = "group"
+)
+
+var ErrInvalidAllowed = errors.New("invalid allowed list entry")
+
+// Allowed is an entry of the allowed list.
+type Allowed struct {
+	Kind     string
+	ID       int64
+	Username string
+}
+
+// ParseAllowed decodes the entry of the allowed list.
+func ParseAllowed(s string) (Allowed, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasPrefix(s, "@") && len(s) > 1 {
+		return Allowed{Kind: AllowedUsername, Username: strings.ToLower(s[1:])}, nil
+	}
+
+	kind, value := AllowedChat, s
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		kind, value = s[:i], s[i+1:]
+	}
+
+	if kind != AllowedChat && kind != AllowedUser && kind != AllowedGroup {
+		return Allowed{}, errors.Wrap(ErrInvalidAllowed, s)
+	}
+
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return Allowed{}, errors.Wrap(ErrInvalidAllowed, s)
+	}
+
+	return Allowed{Kind: kind, ID: id}, nil
+}
+
+func (a Allowed) String() string {
+	switch a.Kind {
+	case AllowedUsername:
+		return "@" + a.Username
+	case AllowedChat:
+		return strconv.FormatInt(a.ID, 10)
+	}
+
+	return a.Kind + ":" + strconv.FormatInt(a.ID, 10)
+}
+
+func (a *Allowed) UnmarshalYAML(value *yaml.Node) error {
+	allowed, err := ParseAllowed(value.Value)
+	if err != nil {
+		return err
+	}
+
+	*a = allowed
+
+	return nil
+}
+
+// MarshalYAML keeps the chat IDs numbers, as the allowed list was before the
+// other entries.
+func (a Allowed) MarshalYAML() (interface{}, error) {
+	if a.Kind == AllowedChat {
+		return a.ID, nil
+	}
+
+	return a.String(), nil
+}
@@ -18,8 +18,12 @@ import (
 	"bytes"
 	"io"
 	"os"
-	"path/filepath"
+	"secretable/pkg/audit"
+	"secretable/pkg/crypto"
+	"secretable/pkg/fileperm"
 	"secretable/pkg/log"
+	"secretable/pkg/passwords"
+	"secretable/pkg/tracing"
 
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
@@ -28,17 +32,583 @@ import (
 type Config struct {
 	filePath string
 
+	// secrets keeps the values read from the secret files.
+	secrets secretFiles
+
 	StorageSource string `yaml:"storage_source"`
 
 	GoogleCredentials string `yaml:"google_credentials_file"`
 	SpreadsheetID     string `yaml:"spreadsheet_id"`
+	// SpreadsheetShareWith is the email the spreadsheet created for the empty
+	// spreadsheet ID is shared with.
+	SpreadsheetShareWith string `yaml:"spreadsheet_share_with"`
+	// Vaults maps the names of the additional vaults to their spreadsheet
+	// IDs, every vault has its own key wrapped with the master password.
+	Vaults map[string]string `yaml:"vaults"`
+	// DriveSharing keeps the spreadsheets shared with the service account
+	// and the listed accounts only.
+	DriveSharing DriveSharing `yaml:"drive_sharing"`
+	// SyncAlertFailures is the number of the failed syncs of the spreadsheet
+	// in a row the admins are alerted after, default 5.
+	SyncAlertFailures int `yaml:"sync_alert_failures"`
+	// SyncInterval is the time between the syncs of the spreadsheet in
+	// seconds, default 10. The syncs back off up to SyncMaxInterval, default
+	// 300, while the spreadsheet isn't modified.
+	SyncInterval    int `yaml:"sync_interval"`
+	SyncMaxInterval int `yaml:"sync_max_interval"`
 
 	JSONStorageFile string `yaml:"json_storage_file"`
+	// JSONStorageEncrypted encrypts the whole JSON storage file with the
+	// master password, so the descriptions aren't readable either.
+	JSONStorageEncrypted bool `yaml:"json_storage_encrypted"`
+	// CSVStorage keeps the secrets in a CSV file with the columns of the
+	// spreadsheet for the csv_file storage source.
+	CSVStorage CSVStorage `yaml:"csv_storage"`
+	// WebDAVStorage keeps the JSON storage file on a WebDAV server for the
+	// webdav storage source.
+	WebDAVStorage WebDAVStorage `yaml:"webdav_storage"`
+	// DropboxStorage keeps the JSON storage file in Dropbox for the dropbox
+	// storage source.
+	DropboxStorage DropboxStorage `yaml:"dropbox_storage"`
+	// DriveStorage keeps the JSON storage file in Google Drive for the
+	// google_drive storage source, with the google_credentials_file.
+	DriveStorage DriveStorage `yaml:"drive_storage"`
+	// AWSSecretsManager keeps the secrets as the native secrets of AWS
+	// Secrets Manager for the aws_secrets_manager storage source.
+	AWSSecretsManager AWSSecretsManager `yaml:"aws_secrets_manager"`
+	// AzureKeyVault keeps the secrets as the native secrets of Azure Key
+	// Vault for the azure_key_vault storage source.
+	AzureKeyVault AzureKeyVault `yaml:"azure_key_vault"`
+	// DynamoDB keeps every secret in an item of a DynamoDB table for the
+	// dynamodb storage source.
+	DynamoDB DynamoDB `yaml:"dynamodb"`
+	// Firestore keeps every secret in a document of a Firestore collection
+	// for the firestore storage source, with the google_credentials_file.
+	Firestore Firestore `yaml:"firestore"`
+
+	AuditFile          string `yaml:"audit_file"`
+	PasswordMaxAgeDays int    `yaml:"password_max_age_days"`
+
+	// FixFilePermissions revokes the access of the group and the others to
+	// the files of the bot on start, otherwise such files are only reported.
+	FixFilePermissions bool `yaml:"fix_file_permissions"`
+
+	// AuditSinks receive a copy of every audit event, e.g. for a SIEM.
+	AuditSinks []audit.SinkConfig `yaml:"audit_sinks"`
+
+	// RotationDays maps a tag to the rotation period of its secrets in days.
+	RotationDays map[string]int `yaml:"rotation_days"`
+
+	PasswordPresets map[string]passwords.Preset `yaml:"password_presets"`
+
+	// SecretTemplates are the structured secrets defined by the admins, e.g. a
+	// database of host, port, user and password, filled by /add <name>.
+	SecretTemplates map[string]SecretTemplate `yaml:"secret_templates"`
+
+	// TokenChunkSize splits the API tokens longer than the size into ordered
+	// messages, zero splits only the values over the Telegram message limit.
+	TokenChunkSize int `yaml:"token_chunk_size"`
+
+	// MaskedDisplay hides the passwords of the query responses behind the
+	// reveal buttons.
+	MaskedDisplay MaskedDisplay `yaml:"masked_display"`
+
+	// SafeTransfer sends the password alone in a code block along with its
+	// length and the marked invisible runes, so the paste can be checked.
+	SafeTransfer bool `yaml:"safe_transfer"`
+
+	// RevealChecksum adds the length and the checksum of the password to the
+	// revealed secrets.
+	RevealChecksum bool `yaml:"reveal_checksum"`
+
+	// Archive moves the secrets of the default vault not revealed for a while
+	// out of the search.
+	Archive Archive `yaml:"archive"`
+
+	// VaultMode is "shared" (default) or "private" where every chat sees
+	// only the secrets it has added.
+	VaultMode string `yaml:"vault_mode"`
+
+	// HTTPListen is the address of the External Secrets webhook provider
+	// endpoint, the endpoint is disabled if empty.
+	HTTPListen string     `yaml:"http_listen"`
+	HTTPTokens []APIToken `yaml:"http_tokens"`
+	// PublicURL is the external HTTPS address of the HTTP endpoint, the
+	// one-time secret links of /link are disabled if empty.
+	PublicURL string `yaml:"public_url"`
+
+	// KeychainTTL caches the key unlocked by the CLI commands in the
+	// credential store of the OS for the seconds, zero asks for the master
+	// password every time.
+	KeychainTTL int `yaml:"keychain_ttl"`
+
+	// KDF derives the key of the master password which wraps the private
+	// key, the keys wrapped by a weaker KDF are rewrapped on the next unlock.
+	KDF crypto.KDF `yaml:"kdf"`
+
+	// Unlock configures the key slots which open the vault without the
+	// master password, the bot is unlocked by them on start.
+	Unlock Unlock `yaml:"unlock"`
+
+	// LocalesDir contains <locale>.json files merged over the embedded
+	// locales, they are reloaded on SIGHUP.
+	LocalesDir string `yaml:"locales_dir"`
+
+	Log     log.Options     `yaml:"log"`
+	Tracing tracing.Options `yaml:"tracing"`
+
+	// ErrorReporting forwards the scrubbed errors to Sentry or a webhook.
+	ErrorReporting ErrorReporting `yaml:"error_reporting"`
+
+	// SecondFactor asks for a TOTP code before the sensitive commands.
+	SecondFactor SecondFactor `yaml:"second_factor"`
+
+	// DevicePairing asks for the pairing code of the chat before the
+	// sensitive commands.
+	DevicePairing DevicePairing `yaml:"device_pairing"`
+
+	// Slack serves the search, the add and the generate of the Slack app.
+	Slack Slack `yaml:"slack"`
+	// Signal serves the bot over Signal instead of Telegram, experimental.
+	Signal Signal `yaml:"signal"`
+	// Matrix serves the bot over Matrix instead of Telegram, experimental.
+	Matrix Matrix `yaml:"matrix"`
+	// EmailIngest adds the secrets deposited by the PGP encrypted emails.
+	EmailIngest EmailIngest `yaml:"email_ingest"`
+	// Backup uploads the encrypted archive of the vaults on a schedule.
+	Backup Backup `yaml:"backup"`
+
+	// DisableUpdateCheck stops the daily check of the latest GitHub release
+	// which notifies the admins about the updates.
+	DisableUpdateCheck bool `yaml:"disable_update_check"`
+
+	// DisableSelfUpdate refuses the secretable self-update command, e.g. if
+	// the binary is installed by a package manager.
+	DisableSelfUpdate bool `yaml:"disable_self_update"`
+
+	TelegramBotToken string `yaml:"telegram_bot_token"`
+	// TelegramBotTokenFile is read for the token if the token is empty, e.g.
+	// a Docker secret.
+	TelegramBotTokenFile string `yaml:"telegram_bot_token_file"`
+
+	// HealthFile is where the bot writes its health for secretable
+	// healthcheck, default secretable.health of the temp directory.
+	HealthFile string `yaml:"health_file"`
+
+	CleanupTimeout int    `yaml:"cleanup_timeout"`
+	Salt           string `yaml:"salt"`
+	// AllowedList are the chat IDs, the users and the groups allowed to use
+	// the bot, see Allowed.
+	AllowedList []Allowed `yaml:"allowed_list"`
+	AdminList   []int64   `yaml:"admin_list"`
+	// InviteRole is the role of the chats joined with the codes of /invite,
+	// "member" (default) or "reader".
+	InviteRole string `yaml:"invite_role"`
+
+	// Environments label the secrets by the tags naming the environments,
+	// dev, stage and prod by default.
+	Environments map[string]Environment `yaml:"environments"`
+
+	// RestrictedTags are the tags whose secrets the readers see only after an
+	// approver accepts the request.
+	RestrictedTags []string `yaml:"restricted_tags"`
+	// Approvers answer the requests of the restricted tags, the admins if
+	// empty.
+	Approvers []int64 `yaml:"approvers"`
+
+	// Limits caps the uses of the commands by a chat, the key is the name
+	// of the command, "search" of the text queries or "reveal" of the
+	// revealed secrets.
+	Limits map[string]Limit `yaml:"limits"`
+
+	// AccessReview sends the admins the report of the users and their
+	// activity on the schedule.
+	AccessReview AccessReview `yaml:"access_review"`
+
+	// Anomalies alerts the admins about the unusual activity of the chats.
+	Anomalies Anomalies `yaml:"anomalies"`
+
+	// Unauthorized is the policy of the chats out of the allowed list.
+	Unauthorized Unauthorized `yaml:"unauthorized"`
+}
+
+// MaskedDisplay is the mode of the query responses for the lookups in sight
+// of others.
+type MaskedDisplay struct {
+	Enabled bool `yaml:"enabled"`
+	// MaskUsername hides the username as well until it's revealed.
+	MaskUsername bool `yaml:"mask_username"`
+}
+
+// Environment is the label and the guardrail of the secrets tagged with the
+// name of the environment.
+type Environment struct {
+	// Label is put in front of the description, e.g. "🔴 PROD".
+	Label string `yaml:"label"`
+	// Confirm reveals the secrets only after the confirm button is pressed.
+	Confirm bool `yaml:"confirm"`
+}
+
+// Archive is the policy of the archived secrets.
+type Archive struct {
+	// Months without a reveal the secret is archived after, zero disables
+	// the archiving.
+	Months int `yaml:"months"`
+	// File is the archive of the json_file storage, <file>.archive.json by
+	// default. The google_sheets storage keeps the Archive sheet.
+	File string `yaml:"file"`
+}
+
+// Limit caps the uses of a command by a chat.
+type Limit struct {
+	// Max is the number of uses in the period, zero doesn't cap.
+	Max int `yaml:"max"`
+	// Period of the max, e.g. "24h" or "7d", a day by default.
+	Period string `yaml:"period"`
+	// Cooldown is the least time between the uses, e.g. "10m".
+	Cooldown string `yaml:"cooldown"`
+}
+
+// AccessReview is the schedule of the access review report.
+type AccessReview struct {
+	Enabled bool `yaml:"enabled"`
+	// Schedule is a cron expression, the first day of the month at 9:00 by
+	// default.
+	Schedule string `yaml:"schedule"`
+	// StaleDays without activity mark the user as stale, 90 by default.
+	StaleDays int `yaml:"stale_days"`
+}
+
+// Anomalies are the alerts about the unusual activity, the zero values
+// disable them.
+type Anomalies struct {
+	// InactiveDays alerts about the chats back after the days without
+	// activity.
+	InactiveDays int `yaml:"inactive_days"`
+	// ClientChange alerts when the client of a user changes its language,
+	// e.g. a login from another device.
+	ClientChange bool `yaml:"client_change"`
+	// RevealBurst and DeleteBurst alert about the revealed and the deleted
+	// secrets of a chat within the burst window.
+	RevealBurst int `yaml:"reveal_burst"`
+	DeleteBurst int `yaml:"delete_burst"`
+	// BurstWindow is the window of the bursts in minutes, 10 by default.
+	BurstWindow int `yaml:"burst_window"`
+	// AutoLock locks the vault on an alert until it is unlocked again.
+	AutoLock bool `yaml:"auto_lock"`
+}
+
+// Unauthorized is how the bot answers the chats out of the allowed list.
+type Unauthorized struct {
+	// Silent drops the messages instead of the "Access forbidden" reply, so
+	// the bot doesn't confirm it exists.
+	Silent bool `yaml:"silent"`
+	// NotifyAdmins tells the admins about the first denied message of a chat
+	// and about the blocked chats.
+	NotifyAdmins bool `yaml:"notify_admins"`
+	// BlockAfter blocks the chat after the denied messages, a blocked chat is
+	// ignored by every command until /unblock. Zero never blocks.
+	BlockAfter int `yaml:"block_after"`
+}
+
+type SecondFactor struct {
+	// Commands are the protected commands without the slash, default
+	// delete, setpass, env and share.
+	Commands []string `yaml:"commands"`
+	// TOTPSecrets maps a chat ID to its base32 TOTP secret, the second factor
+	// is disabled if empty.
+	TOTPSecrets map[int64]string `yaml:"totp_secrets"`
+}
+
+type SecretTemplate struct {
+	Fields []TemplateField `yaml:"fields"`
+}
+
+// TemplateField is a field of a secret template, the mask is none (default),
+// last4 or all.
+type TemplateField struct {
+	Name  string `yaml:"name"`
+	Label string `yaml:"label"`
+	Mask  string `yaml:"mask"`
+}
+
+// Slack is the Slack app connected in Socket Mode.
+type Slack struct {
+	// AppToken (xapp-) opens the Socket Mode connection, the app is disabled
+	// if empty. BotToken (xoxb-) calls the Web API.
+	AppToken string `yaml:"app_token"`
+	BotToken string `yaml:"bot_token"`
+	// Users maps the Slack user IDs to the chat IDs whose access, vault and
+	// audit apply to the user.
+	Users map[string]int64 `yaml:"users"`
+}
+
+// Signal is the Signal account driven by signal-cli.
+type Signal struct {
+	// Address of the JSON-RPC of "signal-cli -a <number> daemon --tcp", e.g.
+	// localhost:7583. The bot serves Signal instead of Telegram if set.
+	Address string `yaml:"address"`
+	// Users maps the phone numbers or the UUIDs of the Signal users to the
+	// chat IDs whose access, vault and audit apply to the user.
+	Users map[string]int64 `yaml:"users"`
+}
+
+// Matrix is the account of the bot on a Matrix homeserver.
+type Matrix struct {
+	// Homeserver is the URL of the client-server API, e.g. the pantalaimon
+	// proxy http://localhost:8009 serving the encrypted rooms. The bot serves
+	// Matrix instead of Telegram if set.
+	Homeserver string `yaml:"homeserver"`
+	// UserID of the bot, e.g. @secretable:example.com.
+	UserID      string `yaml:"user_id"`
+	AccessToken string `yaml:"access_token"`
+	// AccessTokenFile is read for the access token if the token is empty.
+	AccessTokenFile string `yaml:"access_token_file"`
+	// Users maps the Matrix user IDs to the chat IDs whose access, vault and
+	// audit apply to the user.
+	Users map[string]int64 `yaml:"users"`
+}
+
+// WebDAVStorage is the JSON storage file on a WebDAV server, e.g. Nextcloud,
+// cached in a local file. The file is read every sync_interval.
+type WebDAVStorage struct {
+	// URL of the file, e.g.
+	// https://cloud.example.com/remote.php/dav/files/user/secretable.json.
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// PasswordFile is read for the password if the password is empty.
+	PasswordFile string `yaml:"password_file"`
+	// CacheFile is the local copy of the file, default ./webdav-cache.json.
+	CacheFile string `yaml:"cache_file"`
+	// Encrypted encrypts the whole file with the master password like
+	// json_storage_encrypted.
+	Encrypted bool `yaml:"encrypted"`
+}
+
+// DropboxStorage is the JSON storage file in Dropbox cached in a local file.
+// The file is read every sync_interval.
+type DropboxStorage struct {
+	// Path of the file, default /secretable.json, in the app folder of an
+	// app with the app folder access.
+	Path string `yaml:"path"`
+	// RefreshToken of the offline access of the app key and secret is
+	// preferred to the AccessToken, which expires in hours.
+	AccessToken  string `yaml:"access_token"`
+	RefreshToken string `yaml:"refresh_token"`
+	// RefreshTokenFile is read for the refresh token if it's empty.
+	RefreshTokenFile string `yaml:"refresh_token_file"`
+	AppKey           string `yaml:"app_key"`
+	AppSecret        string `yaml:"app_secret"`
+	// CacheFile is the local copy of the file, default ./dropbox-cache.json.
+	CacheFile string `yaml:"cache_file"`
+	// Encrypted encrypts the whole file with the master password like
+	// json_storage_encrypted.
+	Encrypted bool `yaml:"encrypted"`
+}
+
+// DriveStorage is the JSON storage file in Google Drive cached in a local
+// file. The file is read every sync_interval.
+type DriveStorage struct {
+	// FileID of the file, a new file is created if empty.
+	FileID string `yaml:"file_id"`
+	// ShareWith is the email the created file is shared with.
+	ShareWith string `yaml:"share_with"`
+	// CacheFile is the local copy of the file, default ./google_drive-cache.json.
+	CacheFile string `yaml:"cache_file"`
+	// Encrypted encrypts the whole file with the master password like
+	// json_storage_encrypted.
+	Encrypted bool `yaml:"encrypted"`
+}
+
+// AWSSecretsManager is the region of AWS Secrets Manager, the secrets are read
+// every sync_interval.
+type AWSSecretsManager struct {
+	Region string `yaml:"region"`
+	// Endpoint defaults to the endpoint of the region, e.g. of a VPC
+	// endpoint or LocalStack.
+	Endpoint string `yaml:"endpoint"`
+	// AccessKeyID and SecretAccessKey default to AWS_ACCESS_KEY_ID,
+	// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN of the environment.
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	// Prefix of the names of the secrets, default "secretable-".
+	Prefix string `yaml:"prefix"`
+}
+
+// AzureKeyVault is the Key Vault the service principal of the app signs in
+// to, the secrets are read every sync_interval.
+type AzureKeyVault struct {
+	// URL of the vault, e.g. https://example.vault.azure.net.
+	URL          string `yaml:"url"`
+	TenantID     string `yaml:"tenant_id"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// ClientSecretFile is read for the client secret if it's empty.
+	ClientSecretFile string `yaml:"client_secret_file"`
+	// Prefix of the names of the secrets, default "secretable-".
+	Prefix string `yaml:"prefix"`
+}
+
+// CSVStorage is the CSV file of the secrets and the file of the key.
+type CSVStorage struct {
+	// File defaults to ./storage.csv.
+	File string `yaml:"file"`
+	// KeyFile defaults to the file with the .key extension appended.
+	KeyFile string `yaml:"key_file"`
+}
+
+// DynamoDB is the table of the items of the secrets, with the partition key
+// "vault" and the sort key "id" of the string type. The items are read on
+// demand.
+type DynamoDB struct {
+	Table  string `yaml:"table"`
+	Region string `yaml:"region"`
+	// Endpoint defaults to the endpoint of the region, e.g. of DynamoDB
+	// Local.
+	Endpoint string `yaml:"endpoint"`
+	// AccessKeyID and SecretAccessKey default to AWS_ACCESS_KEY_ID,
+	// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN of the environment.
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	// Vault is the partition key of the items, default "secretable", so
+	// the vaults share a table.
+	Vault string `yaml:"vault"`
+}
 
-	TelegramBotToken string  `yaml:"telegram_bot_token"`
-	CleanupTimeout   int     `yaml:"cleanup_timeout"`
-	Salt             string  `yaml:"salt"`
-	AllowedList      []int64 `yaml:"allowed_list"`
+// Firestore is the collection of the documents of the secrets, the documents
+// are read on demand.
+type Firestore struct {
+	// ProjectID defaults to the project of the google_credentials_file.
+	ProjectID string `yaml:"project_id"`
+	// Database defaults to "(default)".
+	Database string `yaml:"database"`
+	// Collection defaults to "secretable".
+	Collection string `yaml:"collection"`
+}
+
+// Unlock are the unlockers of the key slots, the empty ones are disabled.
+type Unlock struct {
+	// Keyfile is the path of a file of 32 random bytes at least.
+	Keyfile string    `yaml:"keyfile"`
+	KMS     KMSUnlock `yaml:"kms"`
+	// YubiKeySlot is the OTP slot 1 or 2 of the YubiKey programmed for the
+	// HMAC-SHA1 challenge-response.
+	YubiKeySlot int `yaml:"yubikey_slot"`
+}
+
+// KMSUnlock is the symmetric key of AWS KMS which wraps the key.
+type KMSUnlock struct {
+	// KeyID is the ID, the ARN or the alias of the key.
+	KeyID  string `yaml:"key_id"`
+	Region string `yaml:"region"`
+	// Endpoint defaults to the endpoint of the region.
+	Endpoint string `yaml:"endpoint"`
+	// AccessKeyID and SecretAccessKey default to AWS_ACCESS_KEY_ID,
+	// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN of the environment.
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+}
+
+// EmailIngest is the IMAP mailbox watched for the emails which deposit the
+// secrets, e.g. from the automated systems without Telegram access.
+type EmailIngest struct {
+	// Address of the IMAP server with TLS, e.g. imap.example.com:993, the
+	// ingestion is disabled if empty.
+	Address  string `yaml:"address"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// PasswordFile is read for the password if the password is empty.
+	PasswordFile string `yaml:"password_file"`
+	// Mailbox is watched for the unseen emails, default INBOX.
+	Mailbox string `yaml:"mailbox"`
+	// Interval between the checks in seconds, default 60.
+	Interval int `yaml:"interval"`
+	// KeyFile is the armored private key the emails are encrypted to, without
+	// a passphrase. SignersFile keeps the armored public keys of the senders,
+	// the emails signed by other keys are dropped.
+	KeyFile     string `yaml:"key_file"`
+	SignersFile string `yaml:"signers_file"`
+	// ChatID is the chat the secrets are added for, its vault, ownership and
+	// audit apply and it is notified about every deposit.
+	ChatID int64 `yaml:"chat_id"`
+}
+
+// Backup is the scheduled upload of the encrypted archive of the vaults to
+// one of S3, GCS and WebDAV.
+type Backup struct {
+	// Schedule is a cron expression of the local time, e.g. "0 3 * * *", the
+	// backups are disabled if empty.
+	Schedule string `yaml:"schedule"`
+	// PassphraseFile keeps the passphrase the archive is encrypted with, the
+	// keys in the archive are still wrapped with the master password.
+	PassphraseFile string `yaml:"passphrase_file"`
+	// Keep is the number of the archives kept, default 7.
+	Keep int `yaml:"keep"`
+	// Prefix of the archive names, default "secretable-".
+	Prefix string `yaml:"prefix"`
+
+	S3     BackupS3     `yaml:"s3"`
+	GCS    BackupGCS    `yaml:"gcs"`
+	WebDAV BackupWebDAV `yaml:"webdav"`
+}
+
+// BackupS3 is a bucket of S3 or a compatible storage, used if the bucket is
+// set. The endpoint defaults to the AWS endpoint of the region.
+type BackupS3 struct {
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	Bucket          string `yaml:"bucket"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+}
+
+// BackupGCS is a bucket of Google Cloud Storage, used if the bucket is set.
+type BackupGCS struct {
+	Bucket string `yaml:"bucket"`
+	// Credentials of the service account, default google_credentials.
+	Credentials string `yaml:"credentials"`
+}
+
+// BackupWebDAV is an existing collection of a WebDAV server, used if the URL
+// is set.
+type BackupWebDAV struct {
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type DevicePairing struct {
+	Enabled bool `yaml:"enabled"`
+	// File keeps the hashes of the pairing codes, default ./devices.json.
+	File string `yaml:"file"`
+	// Commands are the protected commands without the slash, default
+	// delete, setpass, env and share.
+	Commands []string `yaml:"commands"`
+}
+
+type DriveSharing struct {
+	// Accounts are the Google accounts allowed to open the spreadsheets
+	// besides the service account, the sharing isn't checked if empty.
+	Accounts []string `yaml:"accounts"`
+	// Revoke deletes the other permissions, otherwise they are only reported
+	// to the admins.
+	Revoke bool `yaml:"revoke"`
+}
+
+type ErrorReporting struct {
+	SentryDSN  string `yaml:"sentry_dsn"`
+	WebhookURL string `yaml:"webhook_url"`
+}
+
+// APIToken grants the HTTP access to the secrets tagged with one of the tags.
+type APIToken struct {
+	Name  string   `yaml:"name"`
+	Token string   `yaml:"token"`
+	Tags  []string `yaml:"tags"`
+	// HMACKey signs the secrets pushed with POST /api/v1/secrets, the token
+	// can't push without it.
+	HMACKey string `yaml:"hmac_key"`
 }
 
 func ParseFromFile(path string) (config *Config, err error) {
@@ -47,11 +617,11 @@ func ParseFromFile(path string) (config *Config, err error) {
 	file, err := os.Open(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			if err := fileperm.MkdirAll(path); err != nil {
 				return nil, errors.Wrap(err, "mkdir")
 			}
 
-			if file, err = os.Create(path); err != nil {
+			if file, err = fileperm.Create(path); err != nil {
 				return nil, errors.Wrap(err, "create file")
 			}
 
@@ -72,13 +642,18 @@ func ParseFromFile(path string) (config *Config, err error) {
 	return config, nil
 }
 
+const (
+	VaultModeShared  = "shared"
+	VaultModePrivate = "private"
+)
+
 func UpdateFile(config *Config) error {
 	buf := bytes.NewBuffer([]byte{})
 	if err := yaml.NewEncoder(buf).Encode(config); err != nil {
 		return errors.Wrap(err, "encode to yaml")
 	}
 
-	if err := os.WriteFile(config.filePath, buf.Bytes(), os.ModePerm); err != nil {
+	if err := fileperm.WriteFile(config.filePath, buf.Bytes()); err != nil {
 		return errors.Wrap(err, "write file")
 	}
 
@@ -0,0 +1,192 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// maxSecretFileSize limits the secret files.
+const maxSecretFileSize = 1 << 20
+
+// secretFiles reads every secret file once, so a FIFO isn't read twice.
+type secretFiles struct {
+	values map[string][]byte
+	mx     sync.Mutex
+}
+
+func (s *secretFiles) read(path string) ([]byte, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if b, ok := s.values[path]; ok {
+		return b, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open file")
+	}
+
+	defer file.Close()
+
+	b, err := io.ReadAll(io.LimitReader(file, maxSecretFileSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "read file")
+	}
+
+	if s.values == nil {
+		s.values = make(map[string][]byte)
+	}
+
+	s.values[path] = b
+
+	return b, nil
+}
+
+// BotToken returns the Telegram bot token, TelegramBotTokenFile is read if
+// the token isn't set.
+func (c *Config) BotToken() (string, error) {
+	if c.TelegramBotToken != "" || c.TelegramBotTokenFile == "" {
+		return c.TelegramBotToken, nil
+	}
+
+	b, err := c.secrets.read(c.TelegramBotTokenFile)
+	if err != nil {
+		return "", errors.Wrap(err, "telegram bot token file")
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// MatrixAccessToken returns the access token of the Matrix account, read from
+// the token file if the token is empty.
+func (c *Config) MatrixAccessToken() (string, error) {
+	if c.Matrix.AccessToken != "" || c.Matrix.AccessTokenFile == "" {
+		return c.Matrix.AccessToken, nil
+	}
+
+	b, err := c.secrets.read(c.Matrix.AccessTokenFile)
+	if err != nil {
+		return "", errors.Wrap(err, "matrix access token file")
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// EmailPassword returns the IMAP password of the email ingestion,
+// PasswordFile is read if the password isn't set.
+func (c *Config) EmailPassword() (string, error) {
+	if c.EmailIngest.Password != "" || c.EmailIngest.PasswordFile == "" {
+		return c.EmailIngest.Password, nil
+	}
+
+	b, err := c.secrets.read(c.EmailIngest.PasswordFile)
+	if err != nil {
+		return "", errors.Wrap(err, "email password file")
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// WebDAVPassword returns the password of the WebDAV storage, read from the
+// password file if the password is empty.
+func (c *Config) WebDAVPassword() (string, error) {
+	if c.WebDAVStorage.Password != "" || c.WebDAVStorage.PasswordFile == "" {
+		return c.WebDAVStorage.Password, nil
+	}
+
+	b, err := c.secrets.read(c.WebDAVStorage.PasswordFile)
+	if err != nil {
+		return "", errors.Wrap(err, "webdav password file")
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// DropboxRefreshToken returns the refresh token of the Dropbox storage, read
+// from the token file if the token is empty.
+func (c *Config) DropboxRefreshToken() (string, error) {
+	if c.DropboxStorage.RefreshToken != "" || c.DropboxStorage.RefreshTokenFile == "" {
+		return c.DropboxStorage.RefreshToken, nil
+	}
+
+	b, err := c.secrets.read(c.DropboxStorage.RefreshTokenFile)
+	if err != nil {
+		return "", errors.Wrap(err, "dropbox refresh token file")
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// AzureClientSecret returns the client secret of the Key Vault, read from the
+// secret file if the secret is empty.
+func (c *Config) AzureClientSecret() (string, error) {
+	if c.AzureKeyVault.ClientSecret != "" || c.AzureKeyVault.ClientSecretFile == "" {
+		return c.AzureKeyVault.ClientSecret, nil
+	}
+
+	b, err := c.secrets.read(c.AzureKeyVault.ClientSecretFile)
+	if err != nil {
+		return "", errors.Wrap(err, "azure client secret file")
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// BackupPassphrase returns the content of the passphrase file of the backups.
+func (c *Config) BackupPassphrase() (string, error) {
+	b, err := c.secrets.read(c.Backup.PassphraseFile)
+	if err != nil {
+		return "", errors.Wrap(err, "backup passphrase file")
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// BackupCredentialsJSON returns the content of the credentials file of the
+// GCS backups, google_credentials by default.
+func (c *Config) BackupCredentialsJSON() ([]byte, error) {
+	if c.Backup.GCS.Credentials == "" {
+		return c.GoogleCredentialsJSON()
+	}
+
+	b, err := c.secrets.read(c.Backup.GCS.Credentials)
+
+	return b, errors.Wrap(err, "backup credentials file")
+}
+
+// GoogleCredentialsJSON returns the content of the Google credentials file,
+// the file may be a mounted secret or a FIFO.
+func (c *Config) GoogleCredentialsJSON() ([]byte, error) {
+	b, err := c.secrets.read(c.GoogleCredentials)
+
+	return b, errors.Wrap(err, "google credentials file")
+}
+
+// HealthPath returns the file the bot writes its health to.
+func (c *Config) HealthPath() string {
+	if c.HealthFile != "" {
+		return c.HealthFile
+	}
+
+	return filepath.Join(os.TempDir(), "secretable.health")
+}
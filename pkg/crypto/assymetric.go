@@ -22,6 +22,7 @@ import (
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha512"
+	"crypto/subtle"
 
 	"github.com/pkg/errors"
 )
@@ -32,8 +33,29 @@ var (
 	ErrGenerateEncKey   = errors.New("failed to generate encryption key")
 	ErrInvalidPublicKey = errors.New("invalid public key")
 	ErrInvalidCipher    = errors.New("invalid ciphertext")
+	// ErrDecrypt matches all the errors of the decryption, the ciphertext is
+	// broken or encrypted with another key.
+	ErrDecrypt = errors.New("unable to decrypt")
 )
 
+// decryptError keeps the cause of the failed decryption, errors.Is matches it
+// with ErrDecrypt too.
+type decryptError struct {
+	err error
+}
+
+func (e decryptError) Error() string {
+	return e.err.Error()
+}
+
+func (e decryptError) Unwrap() error {
+	return e.err
+}
+
+func (e decryptError) Is(target error) bool {
+	return target == ErrDecrypt
+}
+
 func EncryptWithPub(pub *ecdsa.PublicKey, input []byte) (out []byte, err error) {
 	ephemeral, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
 	if err != nil {
@@ -74,16 +96,52 @@ func EncryptWithPub(pub *ecdsa.PublicKey, input []byte) (out []byte, err error)
 	return h.Sum(out), nil
 }
 
-func DecryptWithPriv(priv *ecdsa.PrivateKey, cipher []byte) (out []byte, err error) {
+// IsCipher reports whether the data is laid out as the ciphertext of
+// EncryptWithPub: the ephemeral point, the IV, the blocks and the MAC. The MAC
+// isn't checked, so a corrupted ciphertext is still a cipher.
+func IsCipher(cipher []byte) bool {
+	if len(cipher) == 0 || len(cipher) < 1+int(cipher[0]) {
+		return false
+	}
+
+	ephLen := int(cipher[0])
+	encdata := cipher[1+ephLen:]
+
+	if len(encdata) < (sha512.Size+aes.BlockSize) || (len(encdata)-sha512.Size)%aes.BlockSize != 0 {
+		return false
+	}
+
+	x, _ := elliptic.Unmarshal(elliptic.P521(), cipher[1:1+ephLen])
+
+	return x != nil
+}
+
+// DecryptWithPriv decrypts the ciphertext of EncryptWithPub. All the failures
+// return the same ErrDecrypt, so a broken point, MAC or padding can't be told
+// apart by the caller, e.g. by the error text shown to a chat.
+func DecryptWithPriv(priv *ecdsa.PrivateKey, cipher []byte) ([]byte, error) {
+	out, err := decryptWithPriv(priv, cipher)
+	if err != nil {
+		return nil, decryptError{err: ErrDecrypt}
+	}
+
+	return out, nil
+}
+
+func decryptWithPriv(priv *ecdsa.PrivateKey, cipher []byte) (out []byte, err error) {
 	if len(cipher) == 0 {
 		return nil, ErrInvalidCipher
 	}
 
 	ephLen := int(cipher[0])
+	if len(cipher) < 1+ephLen {
+		return nil, ErrInvalidCipher
+	}
+
 	ephPub := cipher[1 : 1+ephLen]
 	encdata := cipher[1+ephLen:]
 
-	if len(encdata) < (sha512.Size + aes.BlockSize) {
+	if len(encdata) < (sha512.Size+aes.BlockSize) || (len(encdata)-sha512.Size)%aes.BlockSize != 0 {
 		return nil, ErrInvalidCipher
 	}
 
@@ -108,6 +166,8 @@ func DecryptWithPriv(priv *ecdsa.PrivateKey, cipher []byte) (out []byte, err err
 		return nil, ErrInvalidMAC
 	}
 
+	// The MAC is checked before the padding, so the padding of a forged
+	// ciphertext is never looked at.
 	paddedOut, err := decryptCBC(encdata[aes.BlockSize:tagStart], encdata[:aes.BlockSize], shared[:32])
 	if err != nil {
 		return
@@ -151,9 +211,26 @@ func makeRandom(length int) ([]byte, error) {
 	return bytes, nil
 }
 
+// removePadding drops the padding of addPadding, the padding bytes are
+// checked in constant time.
 func removePadding(body []byte) ([]byte, error) {
+	if len(body) == 0 || len(body)%32 != 0 {
+		return nil, ErrPaddingIncorrect
+	}
+
 	l := int(body[len(body)-1])
-	if l > 32 {
+
+	good := subtle.ConstantTimeLessOrEq(1, l) & subtle.ConstantTimeLessOrEq(l, 32)
+
+	// The zeros before the length byte are compared over the whole block,
+	// whatever the length is.
+	block := body[len(body)-32 : len(body)-1]
+	for i, b := range block {
+		inPadding := subtle.ConstantTimeLessOrEq(len(block)-i, l-1)
+		good &= subtle.ConstantTimeSelect(inPadding, subtle.ConstantTimeByteEq(b, 0), 1)
+	}
+
+	if good != 1 {
 		return nil, ErrPaddingIncorrect
 	}
 
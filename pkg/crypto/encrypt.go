@@ -15,7 +15,6 @@
 package crypto
 
 import (
-	"crypto/aes"
 	"crypto/cipher"
 	"crypto/ecdsa"
 	"crypto/elliptic"
@@ -23,7 +22,6 @@ import (
 	"crypto/sha512"
 
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/pbkdf2"
 )
 
 const (
@@ -33,26 +31,11 @@ const (
 )
 
 func EncryptWithPhrase(phrase, salt, nonce, plaintext []byte) (cipher []byte, err error) {
-	gcm, err := DeriveCipher(phrase, salt)
-	if err != nil {
-		return nil, err
-	}
-
-	return gcm.Seal(nil, nonce, plaintext, nil), err
+	return DefaultKDF.Encrypt(phrase, salt, nonce, plaintext)
 }
 
 func DecryptWithPhrase(phrase, salt, nonce []byte, ciphertext []byte) ([]byte, error) {
-	gcm, err := DeriveCipher(phrase, salt)
-	if err != nil {
-		return nil, err
-	}
-
-	b, err := gcm.Open(nil, nonce, ciphertext, nil)
-	if err != nil {
-		return nil, errors.Wrap(err, "gcm open")
-	}
-
-	return b, nil
+	return DefaultKDF.Decrypt(phrase, salt, nonce, ciphertext)
 }
 
 func SHA512(s string) []byte {
@@ -70,20 +53,9 @@ func GeneratePrivKey() (priv *ecdsa.PrivateKey, err error) {
 	return priv, nil
 }
 
+// DeriveCipher returns AES-GCM with the key derived by the DefaultKDF.
 func DeriveCipher(password, keySalt []byte) (cipher.AEAD, error) {
-	block, err := aes.NewCipher(
-		pbkdf2.Key(password, keySalt, NumbIterates, AESKeySize, sha512.New),
-	)
-	if err != nil {
-		return nil, errors.Wrap(err, "aes new cipher")
-	}
-
-	c, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, errors.Wrap(err, "new gcm")
-	}
-
-	return c, nil
+	return DefaultKDF.Cipher(password, keySalt)
 }
 
 func MakeRandom(l int) ([]byte, error) {
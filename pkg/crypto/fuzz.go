@@ -0,0 +1,61 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build gofuzz
+// +build gofuzz
+
+package crypto
+
+import "bytes"
+
+// The fuzz targets of go-fuzz, e.g.
+//
+//	go-fuzz-build -func FuzzDecryptWithPriv ./pkg/crypto && go-fuzz
+//
+// Any input must fail without a panic, a value is returned only for the
+// ciphertext of EncryptWithPub.
+
+var fuzzKey = katKey()
+
+func FuzzDecryptWithPriv(data []byte) int {
+	out, err := DecryptWithPriv(fuzzKey, data)
+	if err != nil {
+		return 0
+	}
+
+	sealed, err := EncryptWithPub(&fuzzKey.PublicKey, out)
+	if err != nil {
+		panic(err)
+	}
+
+	again, err := DecryptWithPriv(fuzzKey, sealed)
+	if err != nil || !bytes.Equal(again, out) {
+		panic("round trip of a decrypted value")
+	}
+
+	return 1
+}
+
+func FuzzRemovePadding(data []byte) int {
+	out, err := removePadding(data)
+	if err != nil {
+		return 0
+	}
+
+	if !bytes.Equal(addPadding(out), data) {
+		panic("padding accepted which addPadding doesn't make")
+	}
+
+	return 1
+}
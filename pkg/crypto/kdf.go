@@ -0,0 +1,229 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	KDFPBKDF2   = "pbkdf2-sha512"
+	KDFArgon2id = "argon2id"
+)
+
+// The caps of the parameters, a key slot is not trusted to tell the bot to
+// allocate all of its memory or to spin for hours.
+const (
+	MaxPBKDF2Iterations = 50 * NumbIterates
+	MaxArgon2Iterations = 64
+	MaxArgon2Memory     = 4 * 1024 * 1024
+	MaxArgon2Threads    = 64
+)
+
+var ErrInvalidKDF = errors.New("invalid KDF")
+
+// DefaultKDF derives the keys wrapped before the KDF was configurable.
+var DefaultKDF = KDF{Algorithm: KDFPBKDF2, Iterations: NumbIterates}
+
+// KDF is the algorithm and the parameters which derive the AES key from the
+// password. Iterations are the PBKDF2 iterations or the Argon2 passes, Memory
+// is the Argon2 memory in KiB.
+type KDF struct {
+	Algorithm  string `yaml:"algorithm"`
+	Iterations uint32 `yaml:"iterations"`
+	Memory     uint32 `yaml:"memory"`
+	Threads    uint8  `yaml:"threads"`
+}
+
+// ParseKDF decodes the KDF of String, e.g. "argon2id:i=3,m=65536,p=4".
+func ParseKDF(s string) (KDF, error) {
+	algorithm, params := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		algorithm, params = s[:i], s[i+1:]
+	}
+
+	kdf := KDF{Algorithm: algorithm}
+
+	for _, param := range strings.Split(params, ",") {
+		if param == "" {
+			continue
+		}
+
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return KDF{}, errors.Wrap(ErrInvalidKDF, "param "+param)
+		}
+
+		v, err := strconv.ParseUint(kv[1], 10, 32)
+		if err != nil {
+			return KDF{}, errors.Wrap(ErrInvalidKDF, "param "+param)
+		}
+
+		switch kv[0] {
+		case "i":
+			kdf.Iterations = uint32(v)
+		case "m":
+			kdf.Memory = uint32(v)
+		case "p":
+			if v > 255 {
+				return KDF{}, errors.Wrap(ErrInvalidKDF, "param "+param)
+			}
+
+			kdf.Threads = uint8(v)
+		default:
+			return KDF{}, errors.Wrap(ErrInvalidKDF, "param "+param)
+		}
+	}
+
+	return kdf, kdf.Validate()
+}
+
+func (k KDF) String() string {
+	s := k.Algorithm + ":i=" + strconv.FormatUint(uint64(k.Iterations), 10)
+
+	if k.Algorithm == KDFArgon2id {
+		s += ",m=" + strconv.FormatUint(uint64(k.Memory), 10) + ",p=" + strconv.Itoa(int(k.Threads))
+	}
+
+	return s
+}
+
+// OrDefault fills the parameters left empty, the empty algorithm is the
+// DefaultKDF and Argon2id defaults to 3 passes over 64 MiB with 4 threads.
+func (k KDF) OrDefault() KDF {
+	switch k.Algorithm {
+	case "":
+		return DefaultKDF
+	case KDFPBKDF2:
+		if k.Iterations == 0 {
+			k.Iterations = NumbIterates
+		}
+	case KDFArgon2id:
+		if k.Iterations == 0 {
+			k.Iterations = 3
+		}
+
+		if k.Memory == 0 {
+			k.Memory = 64 * 1024
+		}
+
+		if k.Threads == 0 {
+			k.Threads = 4
+		}
+	}
+
+	return k
+}
+
+// Validate checks the algorithm and the parameters it needs, the parameters
+// are in the bounds of the caps.
+func (k KDF) Validate() error {
+	switch k.Algorithm {
+	case KDFPBKDF2:
+		if k.Iterations > 0 && k.Iterations <= MaxPBKDF2Iterations {
+			return nil
+		}
+	case KDFArgon2id:
+		if k.Iterations > 0 && k.Iterations <= MaxArgon2Iterations &&
+			k.Memory > 0 && k.Memory <= MaxArgon2Memory &&
+			k.Threads > 0 && k.Threads <= MaxArgon2Threads {
+			return nil
+		}
+	}
+
+	return errors.Wrap(ErrInvalidKDF, k.String())
+}
+
+// Weaker reports whether the key derived by the KDF should be derived again
+// by the target: the target is another algorithm or raises a parameter without
+// lowering any other, so a rewrap never drops the cost.
+func (k KDF) Weaker(target KDF) bool {
+	if k.Algorithm != target.Algorithm {
+		return true
+	}
+
+	if target.Iterations < k.Iterations || target.Memory < k.Memory || target.Threads < k.Threads {
+		return false
+	}
+
+	return target != k
+}
+
+// Key derives the AES key of the password.
+func (k KDF) Key(password, salt []byte) ([]byte, error) {
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+
+	if k.Algorithm == KDFArgon2id {
+		return argon2.IDKey(password, salt, k.Iterations, k.Memory, k.Threads, AESKeySize), nil
+	}
+
+	return pbkdf2.Key(password, salt, int(k.Iterations), AESKeySize, sha512.New), nil
+}
+
+// Cipher returns AES-GCM with the key derived from the password.
+func (k KDF) Cipher(password, salt []byte) (cipher.AEAD, error) {
+	key, err := k.Key(password, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "aes new cipher")
+	}
+
+	c, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "new gcm")
+	}
+
+	return c, nil
+}
+
+// Encrypt seals the plaintext with the key derived from the phrase.
+func (k KDF) Encrypt(phrase, salt, nonce, plaintext []byte) ([]byte, error) {
+	gcm, err := k.Cipher(phrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nil, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens the ciphertext of Encrypt, the errors of a wrong phrase match
+// ErrDecrypt.
+func (k KDF) Decrypt(phrase, salt, nonce, ciphertext []byte) ([]byte, error) {
+	gcm, err := k.Cipher(phrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, decryptError{err: errors.Wrap(err, "gcm open")}
+	}
+
+	return b, nil
+}
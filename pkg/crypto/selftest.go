@@ -0,0 +1,164 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// ErrSelfTest is returned by SelfTest if a known answer doesn't match.
+var ErrSelfTest = errors.New("crypto self-test failed")
+
+// The known answers of the primitives below were computed once, the PBKDF2
+// one matches hashlib.pbkdf2_hmac of Python.
+var (
+	kat = struct {
+		password, salt, nonce, plaintext string
+
+		pbkdf2, argon2id, gcm string
+
+		eciesKey, ecies string
+	}{
+		password:  "password",
+		salt:      "secretable salt",
+		nonce:     "0123456789ab",
+		plaintext: "secretable known answer",
+
+		pbkdf2:   "0d759f3c7fbf21b5439ec825361946c041ae3ec398fa6156f408a7718c16e95d",
+		argon2id: "1e8019880e3786fc8bf608146fe44576709e61283295bc040c9982da51a058c9",
+		gcm:      "54fa6e0ee6fceeec682a7a31a120f537aa2962d6e12306a0ab33f8d96082b15fea2bff38ad1776",
+
+		eciesKey: "1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f809" +
+			"1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091",
+		ecies: "8504014e1079cf63aa3db2d39424ab3e1726a8fdd1552c6b6dcd02bf32b2bafaafba211c6631f7c9889c5c86082d894b" +
+			"1a6e03fcf1df8d1f37d42bb6c43230ac586ac6b601cbbc1c66bb147f6f2e06afc0dcf519f1c7afe682796af4f73be2c9" +
+			"d6b96d0fd1d324db029373784e227e51c1a22cdfd94db41a592cdf1297fcf225357ac6f03e2afa0642b00e177b9d55fd" +
+			"ca2b57020d5edfa5c346f1ce09ee7cc7e1dd6fac3269e7bd002448e66bab9001cb55f6e70e91b4ab4d907ed62be0f8ea" +
+			"7aca3d0f39baa2ee6fc317c57891aaf7577feba5f71cebcfd6929b7ae3f6fcd43b7a2ae8589f71133b0fa049f8402027" +
+			"cf21e20ae9e9",
+	}
+
+	katPBKDF2   = KDF{Algorithm: KDFPBKDF2, Iterations: 1000}
+	katArgon2id = KDF{Algorithm: KDFArgon2id, Iterations: 1, Memory: 64, Threads: 1}
+)
+
+// SelfTest checks the KDFs, the wrap of AES-GCM and the ECIES of the secrets
+// against the known answers and the round trips, so a miscompiled binary or
+// a regression fails before any key is touched.
+func SelfTest() error {
+	password, salt := []byte(kat.password), []byte(kat.salt)
+	plaintext := []byte(kat.plaintext)
+
+	for _, c := range []struct {
+		name string
+		kdf  KDF
+		want string
+	}{
+		{"pbkdf2", katPBKDF2, kat.pbkdf2},
+		{"argon2id", katArgon2id, kat.argon2id},
+	} {
+		key, err := c.kdf.Key(password, salt)
+		if err != nil {
+			return errors.Wrap(err, c.name)
+		}
+
+		if hex.EncodeToString(key) != c.want {
+			return errors.Wrap(ErrSelfTest, c.name+" known answer")
+		}
+	}
+
+	sealed, err := katPBKDF2.Encrypt(password, salt, []byte(kat.nonce), plaintext)
+	if err != nil {
+		return errors.Wrap(err, "gcm")
+	}
+
+	if hex.EncodeToString(sealed) != kat.gcm {
+		return errors.Wrap(ErrSelfTest, "gcm known answer")
+	}
+
+	if err = checkGCM(password, salt, sealed, plaintext); err != nil {
+		return err
+	}
+
+	return checkECIES(plaintext)
+}
+
+func checkGCM(password, salt, sealed, plaintext []byte) error {
+	opened, err := katPBKDF2.Decrypt(password, salt, []byte(kat.nonce), sealed)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		return errors.Wrap(ErrSelfTest, "gcm open")
+	}
+
+	forged := append([]byte{}, sealed...)
+	forged[0] ^= 1
+
+	if _, err = katPBKDF2.Decrypt(password, salt, []byte(kat.nonce), forged); !errors.Is(err, ErrDecrypt) {
+		return errors.Wrap(ErrSelfTest, "gcm forged ciphertext")
+	}
+
+	return nil
+}
+
+// katKey returns the private key of the ECIES known answer.
+func katKey() *ecdsa.PrivateKey {
+	d, _ := new(big.Int).SetString(kat.eciesKey, 16)
+
+	priv := &ecdsa.PrivateKey{D: d}
+	priv.Curve = elliptic.P521()
+	priv.X, priv.Y = priv.Curve.ScalarBaseMult(d.Bytes())
+
+	return priv
+}
+
+func checkECIES(plaintext []byte) error {
+	priv := katKey()
+
+	known, _ := hex.DecodeString(kat.ecies)
+
+	opened, err := DecryptWithPriv(priv, known)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		return errors.Wrap(ErrSelfTest, "ecies known answer")
+	}
+
+	sealed, err := EncryptWithPub(&priv.PublicKey, plaintext)
+	if err != nil {
+		return errors.Wrap(err, "ecies")
+	}
+
+	if opened, err = DecryptWithPriv(priv, sealed); err != nil || !bytes.Equal(opened, plaintext) {
+		return errors.Wrap(ErrSelfTest, "ecies round trip")
+	}
+
+	for _, i := range []int{1, len(sealed) / 2, len(sealed) - 1} {
+		forged := append([]byte{}, sealed...)
+		forged[i] ^= 1
+
+		if _, err = DecryptWithPriv(priv, forged); !errors.Is(err, ErrDecrypt) {
+			return errors.Wrap(ErrSelfTest, "ecies forged ciphertext")
+		}
+	}
+
+	if out, err := removePadding(addPadding(plaintext)); err != nil || !bytes.Equal(out, plaintext) {
+		return errors.Wrap(ErrSelfTest, "padding round trip")
+	}
+
+	return nil
+}
@@ -0,0 +1,213 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package devices keeps the pairing codes of the chats. A code is issued on
+// the server, the chat pairs by sending it to the bot and repeats it before the
+// sensitive commands, so a spoofed chat ID alone is not enough.
+package devices
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"secretable/pkg/crypto"
+	"secretable/pkg/fileperm"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mr-tron/base58/base58"
+	"github.com/pkg/errors"
+)
+
+const (
+	codeLength = 9
+	saltLength = 16
+
+	// PairTimeout is the time to pair the chat after the code is issued.
+	PairTimeout = 24 * time.Hour
+)
+
+var (
+	ErrNotIssued = errors.New("no pairing code issued")
+	ErrExpired   = errors.New("pairing code expired")
+)
+
+// Device is the pairing of a chat, only the hash of the code is stored.
+type Device struct {
+	Hash   string    `json:"hash"`
+	Salt   string    `json:"salt"`
+	Issued time.Time `json:"issued"`
+	Paired time.Time `json:"paired,omitempty"`
+}
+
+// Registry is the file of the pairings shared by the bot and the CLI, it is
+// read on every call.
+type Registry struct {
+	path string
+	mx   sync.Mutex
+}
+
+func Open(path string) *Registry {
+	return &Registry{path: path}
+}
+
+// Issue generates a new pairing code of the chat, the previous pairing is
+// revoked.
+func (r *Registry) Issue(chatID int64) (string, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	devices, err := r.read()
+	if err != nil {
+		return "", err
+	}
+
+	code, err := crypto.MakeRandom(codeLength)
+	if err != nil {
+		return "", errors.Wrap(err, "make random code")
+	}
+
+	salt, err := crypto.MakeRandom(saltLength)
+	if err != nil {
+		return "", errors.Wrap(err, "make random salt")
+	}
+
+	encoded := base58.Encode(code)
+
+	devices[key(chatID)] = Device{
+		Hash:   hash(salt, encoded),
+		Salt:   hex.EncodeToString(salt),
+		Issued: time.Now().UTC(),
+	}
+
+	return encoded, r.write(devices)
+}
+
+// Pair completes the pairing of the chat with the issued code.
+func (r *Registry) Pair(chatID int64, code string) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	devices, err := r.read()
+	if err != nil {
+		return err
+	}
+
+	device, ok := devices[key(chatID)]
+	if !ok {
+		return ErrNotIssued
+	}
+
+	if device.Paired.IsZero() && time.Since(device.Issued) > PairTimeout {
+		return ErrExpired
+	}
+
+	if !device.matches(code) {
+		return ErrNotIssued
+	}
+
+	device.Paired = time.Now().UTC()
+	devices[key(chatID)] = device
+
+	return r.write(devices)
+}
+
+// Verify reports whether the chat is paired with the code.
+func (r *Registry) Verify(chatID int64, code string) bool {
+	device, ok := r.device(chatID)
+
+	return ok && !device.Paired.IsZero() && device.matches(code)
+}
+
+// Paired reports whether the chat has completed the pairing.
+func (r *Registry) Paired(chatID int64) bool {
+	device, ok := r.device(chatID)
+
+	return ok && !device.Paired.IsZero()
+}
+
+func (r *Registry) device(chatID int64) (Device, bool) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	devices, err := r.read()
+	if err != nil {
+		return Device{}, false
+	}
+
+	device, ok := devices[key(chatID)]
+
+	return device, ok
+}
+
+func (d Device) matches(code string) bool {
+	salt, err := hex.DecodeString(d.Salt)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(hash(salt, code)), []byte(d.Hash)) == 1
+}
+
+func (r *Registry) read() (map[string]Device, error) {
+	devices := make(map[string]Device)
+
+	file, err := os.Open(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return devices, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "open file")
+	}
+
+	defer file.Close()
+
+	if err = json.NewDecoder(file).Decode(&devices); err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.Wrap(err, "unmarshal json")
+	}
+
+	return devices, nil
+}
+
+// write replaces the file at once, so the other process never reads a
+// partial file.
+func (r *Registry) write(devices map[string]Device) error {
+	b, _ := json.MarshalIndent(devices, "", "  ")
+
+	if err := fileperm.MkdirAll(r.path); err != nil {
+		return errors.Wrap(err, "mkdir")
+	}
+
+	tmp := r.path + ".tmp"
+	if err := fileperm.WriteFile(tmp, b); err != nil {
+		return errors.Wrap(err, "write file")
+	}
+
+	return errors.Wrap(os.Rename(tmp, r.path), "rename file")
+}
+
+func key(chatID int64) string {
+	return strconv.FormatInt(chatID, 10)
+}
+
+func hash(salt []byte, code string) string {
+	sum := sha256.Sum256(append(salt, []byte(code)...))
+
+	return hex.EncodeToString(sum[:])
+}
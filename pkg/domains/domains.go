@@ -0,0 +1,109 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package domains matches the secrets to the sites by the registrable domain,
+// so accounts.google.com and mail.google.com are both google.com.
+package domains
+
+import (
+	"net"
+	"net/url"
+	"secretable/pkg/providers"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Host returns the lower case host of the URL or the domain without the port
+// and the "www." prefix.
+func Host(rawURL string) string {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// Registrable returns the eTLD+1 of the host, e.g. google.com of
+// accounts.google.com and example.co.uk of login.example.co.uk. The IP
+// addresses and the hosts without a public suffix are returned as is.
+func Registrable(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+
+	return domain
+}
+
+// IsDomain reports whether the query is a URL or a domain rather than a
+// part of the description.
+func IsDomain(query string) bool {
+	query = strings.TrimSpace(query)
+	if query == "" || strings.ContainsAny(query, " \t\n") || strings.HasPrefix(query, "#") {
+		return false
+	}
+
+	host := Host(query)
+
+	return strings.Contains(host, ".") && !strings.HasPrefix(host, ".") && !strings.HasSuffix(host, ".")
+}
+
+// Domains returns the registrable domains of the secret: the URL field and
+// the domains or URLs written in the description, e.g. "GitHub github.com".
+func Domains(secret providers.SecretsData) []string {
+	var domains []string
+
+	if secret.URL != "" {
+		if host := Host(secret.URL); host != "" {
+			domains = append(domains, Registrable(host))
+		}
+	}
+
+	for _, word := range strings.Fields(secret.Description) {
+		if IsDomain(word) {
+			domains = append(domains, Registrable(Host(word)))
+		}
+	}
+
+	return domains
+}
+
+// Matches reports whether the secret belongs to the registrable domain of the
+// URL or the domain.
+func Matches(secret providers.SecretsData, rawURL string) bool {
+	host := Host(rawURL)
+	if host == "" {
+		return false
+	}
+
+	domain := Registrable(host)
+
+	for _, d := range Domains(secret) {
+		if d == domain {
+			return true
+		}
+	}
+
+	return false
+}
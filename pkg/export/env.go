@@ -0,0 +1,48 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"regexp"
+	"secretable/pkg/providers"
+	"strings"
+)
+
+var (
+	envName    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	envEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "\n", `\n`, "\r", `\r`)
+)
+
+// Env renders the decrypted secrets as a .env document, the username is the
+// variable name and the secret is its value. Secrets with a username which is
+// not a valid variable name are skipped and their descriptions returned.
+func Env(secrets []providers.SecretsData) (doc []byte, skipped []string) {
+	var buf bytes.Buffer
+
+	for _, secret := range secrets {
+		name := strings.TrimSpace(secret.Username)
+		if !envName.MatchString(name) {
+			skipped = append(skipped, secret.Description)
+
+			continue
+		}
+
+		buf.WriteString("# " + strings.ReplaceAll(secret.Description, "\n", " ") + "\n")
+		buf.WriteString(name + `="` + envEscaper.Replace(secret.Secret) + "\"\n")
+	}
+
+	return buf.Bytes(), skipped
+}
@@ -0,0 +1,52 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"encoding/json"
+	"secretable/pkg/providers"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Record is the stable JSON schema of a single secret. All values are
+// strings, so the document is accepted by the Terraform external data source.
+type Record struct {
+	Description string `json:"description"`
+	Username    string `json:"username"`
+	Secret      string `json:"secret"`
+	// Tags are comma separated tags of the description without "#".
+	Tags string `json:"tags"`
+	// Version changes every time the secret is changed.
+	Version string `json:"version"`
+}
+
+// JSON renders the decrypted secret as a Record, the version is the audit key
+// of the stored secret.
+func JSON(secret providers.SecretsData, version string) ([]byte, error) {
+	doc, err := json.Marshal(Record{
+		Description: secret.Description,
+		Username:    secret.Username,
+		Secret:      secret.Secret,
+		Tags:        strings.Join(secret.Tags(), ","),
+		Version:     version,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal record")
+	}
+
+	return append(doc, '\n'), nil
+}
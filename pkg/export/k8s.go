@@ -0,0 +1,87 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"encoding/base64"
+	"regexp"
+	"secretable/pkg/providers"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const yamlIndent = 2
+
+var (
+	ErrInvalidName = errors.New("invalid kubernetes object name")
+
+	k8sName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]{0,251}[a-z0-9])?$`)
+	k8sKey  = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+)
+
+type k8sSecret struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   k8sMetadata       `yaml:"metadata"`
+	Type       string            `yaml:"type"`
+	Data       map[string]string `yaml:"data"`
+}
+
+type k8sMetadata struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// K8sSecret renders the decrypted secrets as an Opaque Kubernetes Secret
+// manifest, the username is the data key. Secrets with a username which is not
+// a valid data key are skipped and their descriptions returned.
+func K8sSecret(name, namespace string, secrets []providers.SecretsData) (doc []byte, skipped []string, err error) {
+	if !k8sName.MatchString(name) || namespace != "" && !k8sName.MatchString(namespace) {
+		return nil, nil, ErrInvalidName
+	}
+
+	manifest := k8sSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   k8sMetadata{Name: name, Namespace: namespace},
+		Type:       "Opaque",
+		Data:       make(map[string]string),
+	}
+
+	for _, secret := range secrets {
+		key := strings.TrimSpace(secret.Username)
+		if !k8sKey.MatchString(key) {
+			skipped = append(skipped, secret.Description)
+
+			continue
+		}
+
+		manifest.Data[key] = base64.StdEncoding.EncodeToString([]byte(secret.Secret))
+	}
+
+	var buf bytes.Buffer
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(yamlIndent)
+
+	if err = enc.Encode(manifest); err != nil {
+		return nil, nil, errors.Wrap(err, "encode manifest")
+	}
+
+	return buf.Bytes(), skipped, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fileperm keeps the files of the bot readable by the owner only. The
+// modes are applied on creation, the umask may only narrow them further.
+package fileperm
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	File os.FileMode = 0o600
+	Dir  os.FileMode = 0o700
+
+	// loose are the bits which give the group or the others any access.
+	loose os.FileMode = 0o077
+)
+
+// MkdirAll creates the missing parent directories of the file.
+func MkdirAll(path string) error {
+	return os.MkdirAll(filepath.Dir(path), Dir)
+}
+
+// Create creates or truncates the file.
+func Create(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, File)
+}
+
+// OpenAppend opens the file for appending, the file is created if missing.
+func OpenAppend(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, File)
+}
+
+// WriteFile writes the file, the mode of an existing file is kept.
+func WriteFile(path string, b []byte) error {
+	return os.WriteFile(path, b, File)
+}
+
+// Check reports whether the group or the others have access to the file, with
+// the fix the access is revoked. A missing file is fine.
+func Check(path string, fix bool) (bool, error) {
+	if runtime.GOOS == "windows" {
+		return false, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, errors.Wrap(err, "stat file")
+	}
+
+	mode := info.Mode().Perm()
+	if mode&loose == 0 {
+		return false, nil
+	}
+
+	if fix {
+		if err = os.Chmod(path, mode&^loose); err != nil {
+			return true, errors.Wrap(err, "chmod file")
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"secretable/pkg/chat"
+	"secretable/pkg/config"
+	"secretable/pkg/log"
+	"strings"
+	"time"
+)
+
+// groupCacheTTL is how long the group membership is trusted before it is
+// asked from the messenger again.
+const groupCacheTTL = 10 * time.Minute
+
+type groupKey struct {
+	groupID int64
+	userID  int64
+}
+
+type groupMembership struct {
+	ok      bool
+	checked time.Time
+}
+
+// isAllowed checks the chat IDs and the user IDs of the allowed list, the ID
+// of a private chat is the ID of the user.
+func (h *Handler) isAllowed(chatID int64) bool {
+	for _, a := range h.Config.AllowedList {
+		if (a.Kind == config.AllowedChat || a.Kind == config.AllowedUser) && a.ID == chatID {
+			return true
+		}
+	}
+
+	return h.joinedRole(chatID) == RoleMember || h.isAdmin(chatID)
+}
+
+func (h *Handler) isAdmin(chatID int64) bool {
+	for _, a := range h.Config.AdminList {
+		if a == chatID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isPrivate reports whether the message is of the private chat of the
+// sender, the ID of the chat is the ID of the user.
+func isPrivate(msg *chat.Message) bool {
+	return msg.Sender != nil && msg.Chat.ID == msg.Sender.ID
+}
+
+// roleOfMessage returns the highest role of the message by the chat and the
+// sender, the members of the allowed groups are readers. The chats joined
+// with an invite code have the role of the code, the users elevated by /sudo
+// are members in their private chats. The users and the group members are matched only in their
+// private chats, so a group chat doesn't get the rights of a user in it.
+func (h *Handler) roleOfMessage(msg *chat.Message) Role {
+	if h.isAdmin(msg.Chat.ID) {
+		return RoleAdmin
+	}
+
+	role := h.joinedRole(msg.Chat.ID)
+	if role == RoleMember || isPrivate(msg) && h.isElevated(msg.Sender.ID) {
+		return RoleMember
+	}
+
+	for _, a := range h.Config.AllowedList {
+		switch a.Kind {
+		case config.AllowedChat:
+			if a.ID == msg.Chat.ID {
+				return RoleMember
+			}
+		case config.AllowedUser:
+			if isPrivate(msg) && a.ID == msg.Sender.ID {
+				return RoleMember
+			}
+		case config.AllowedUsername:
+			if isPrivate(msg) && msg.Sender.Username != "" && a.Username == strings.ToLower(msg.Sender.Username) {
+				return RoleMember
+			}
+		case config.AllowedGroup:
+			if role == RoleAnyone && (a.ID == msg.Chat.ID || isPrivate(msg) && h.inGroup(a.ID, msg.Sender.ID)) {
+				role = RoleReader
+			}
+		}
+	}
+
+	return role
+}
+
+// inGroup checks the membership of the user in the group, the answers are
+// cached for groupCacheTTL. The last known answer is kept while the messenger
+// fails to answer.
+func (h *Handler) inGroup(groupID, userID int64) bool {
+	checker, ok := h.Chat.(chat.MemberChecker)
+	if !ok {
+		return false
+	}
+
+	key := groupKey{groupID: groupID, userID: userID}
+
+	h.groupsmx.Lock()
+	cached, found := h.groups[key]
+	h.groupsmx.Unlock()
+
+	if found && time.Since(cached.checked) < groupCacheTTL {
+		return cached.ok
+	}
+
+	member, err := checker.IsMember(groupID, userID)
+	if err != nil {
+		log.Error("Check group member: "+err.Error(), "group", groupID, "user", userID)
+
+		return found && cached.ok
+	}
+
+	h.groupsmx.Lock()
+	if h.groups == nil {
+		h.groups = make(map[groupKey]groupMembership)
+	}
+
+	h.groups[key] = groupMembership{ok: member, checked: time.Now()}
+	h.groupsmx.Unlock()
+
+	return member
+}
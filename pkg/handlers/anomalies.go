@@ -0,0 +1,153 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"time"
+)
+
+const defaultBurstWindow = 10 * time.Minute
+
+// LockButton locks the vault from an anomaly alert.
+var LockButton = chat.Button{Unique: "lock"}
+
+// AnomalyMiddleware watches the messages of the allowed chats for a chat back
+// after the inactivity and for the changed client of a user.
+func (h *Handler) AnomalyMiddleware(next func(m *chat.Message)) func(m *chat.Message) {
+	return func(msg *chat.Message) {
+		h.watchActivity(msg)
+
+		next(msg)
+	}
+}
+
+func (h *Handler) watchActivity(msg *chat.Message) {
+	conf := h.Config.Anomalies
+	now := time.Now()
+
+	if conf.InactiveDays > 0 {
+		last := h.Audit.LastSeen(msg.Chat.ID)
+		if value, ok := h.lastseen.Load(msg.Chat.ID); ok && value.(time.Time).After(last) {
+			last = value.(time.Time)
+		}
+
+		h.lastseen.Store(msg.Chat.ID, now)
+
+		if days := int(now.Sub(last).Hours() / 24); !last.IsZero() && days >= conf.InactiveDays {
+			h.alertAnomaly(msg, "inactive", localizator.Args{"Days": days})
+		}
+	}
+
+	if conf.ClientChange && msg.Sender != nil && msg.Sender.LanguageCode != "" {
+		previous, ok := h.clients.Load(msg.Sender.ID)
+		h.clients.Store(msg.Sender.ID, msg.Sender.LanguageCode)
+
+		if ok && previous.(string) != msg.Sender.LanguageCode {
+			h.alertAnomaly(msg, "client", localizator.Args{
+				"Previous": html.EscapeString(previous.(string)),
+				"Language": html.EscapeString(msg.Sender.LanguageCode),
+			})
+		}
+	}
+}
+
+// watchBurst alerts once the reveals or the deletions of the chat reach the
+// burst within the window, the event is already recorded.
+func (h *Handler) watchBurst(event audit.Event) {
+	conf := h.Config.Anomalies
+
+	name, burst := audit.UsesReveal, conf.RevealBurst
+	if event.Action == audit.ActionDelete {
+		name, burst = audit.UsesDelete, conf.DeleteBurst
+	}
+
+	if burst <= 0 {
+		return
+	}
+
+	window := defaultBurstWindow
+	if conf.BurstWindow > 0 {
+		window = time.Duration(conf.BurstWindow) * time.Minute
+	}
+
+	if len(h.Audit.Uses(event.ChatID, name, time.Now().Add(-window))) != burst {
+		return
+	}
+
+	msg := &chat.Message{Chat: &chat.Chat{ID: event.ChatID, Username: event.Username}, Sender: &chat.User{}}
+
+	h.alertAnomaly(msg, name+"_burst", localizator.Args{"Count": burst, "Minutes": int(window.Minutes())})
+}
+
+// alertAnomaly tells the admins about the anomaly of the chat, the vault is
+// locked if auto_lock is set. Otherwise the alert has a button to lock it.
+func (h *Handler) alertAnomaly(msg *chat.Message, kind string, args localizator.Args) {
+	log.Info("🚨 Anomaly", "chat_id", msg.Chat.ID, "kind", kind)
+
+	h.writeAudit(audit.Event{ChatID: msg.Chat.ID, Username: msg.Chat.Username, Action: audit.ActionAnomaly, Target: msg.Chat.ID, Details: kind})
+
+	args["ChatID"] = msg.Chat.ID
+	args["Name"] = html.EscapeString(senderName(msg))
+
+	text := h.Locales.Format("en", "anomaly_"+kind, args)
+
+	opts := chat.Options{Notify: true}
+
+	switch {
+	case !h.isAnyUnlocked():
+	case h.Config.Anomalies.AutoLock:
+		h.lockVault()
+		h.writeAudit(audit.Event{Action: audit.ActionAnomaly, Target: msg.Chat.ID, Details: "auto_lock"})
+
+		text += "\n" + h.Locales.Get("en", "anomaly_locked")
+	default:
+		lock := LockButton
+		lock.Text = h.Locales.Get("en", "anomaly_lock_button")
+		opts.Buttons = [][]chat.Button{{lock}}
+	}
+
+	for _, admin := range h.Config.AdminList {
+		if admin == msg.Chat.ID {
+			continue
+		}
+
+		if _, err := h.Chat.SendMessage(admin, text, opts); err != nil {
+			log.Error("Unable to send an anomaly alert: "+err.Error(), "chat_id", admin)
+		}
+	}
+}
+
+// LockCallback locks the vault until the master password is entered again.
+func (h *Handler) LockCallback(msg *chat.Message, _ *chat.Callback) {
+	if h.isAnyUnlocked() {
+		h.lockVault()
+		h.recordAudit(msg, audit.ActionAnomaly, "", "lock")
+	}
+
+	h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "sessions_locked"))
+}
+
+// lockVault forgets the master passwords of the vaults and the keys of the
+// slots.
+func (h *Handler) lockVault() {
+	h.forgetPasses()
+	h.lockSlots()
+	h.endSession()
+}
@@ -0,0 +1,272 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/crypto"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+	"time"
+
+	"github.com/mr-tron/base58/base58"
+)
+
+const (
+	// approvalTimeout is how long the approvers answer a request.
+	approvalTimeout = time.Hour
+
+	approvalIDLength = 8
+)
+
+var (
+	// ApproveButton reveals the restricted secret to the requesting chat.
+	ApproveButton = chat.Button{Unique: "approve"}
+	// RejectButton turns the request down.
+	RejectButton = chat.Button{Unique: "reject"}
+)
+
+// approvalRequest is a reader waiting for a restricted secret.
+type approvalRequest struct {
+	ChatID    int64
+	Username  string
+	Sender    chat.User
+	SecretKey string
+	Expires   time.Time
+}
+
+// message returns the message of the requesting chat, the secret is sent to
+// it on approval.
+func (r *approvalRequest) message() *chat.Message {
+	sender := r.Sender
+
+	return &chat.Message{Chat: &chat.Chat{ID: r.ChatID, Username: r.Username}, Sender: &sender}
+}
+
+// needsApproval reports whether the chat asks an approver before the secret
+// is revealed: the readers do for the secrets of the restricted tags.
+func (h *Handler) needsApproval(msg *chat.Message, secret providers.SecretsData) bool {
+	if len(h.Config.RestrictedTags) == 0 {
+		return false
+	}
+
+	restricted := false
+
+	for _, tag := range h.Config.RestrictedTags {
+		if secret.HasTag(tag) {
+			restricted = true
+
+			break
+		}
+	}
+
+	return restricted && h.roleOfMessage(msg) < RoleMember
+}
+
+// requestApproval asks the approvers to reveal the secret to the chat, a
+// pending request of the same secret isn't repeated.
+func (h *Handler) requestApproval(msg *chat.Message, secret providers.SecretsData) {
+	locale := msg.Sender.LanguageCode
+	key := audit.SecretKey(secret)
+
+	pending := false
+
+	h.approvals.Range(func(id, value interface{}) bool {
+		req := value.(*approvalRequest)
+
+		switch {
+		case time.Now().After(req.Expires):
+			h.approvals.Delete(id)
+		case req.ChatID == msg.Chat.ID && req.SecretKey == key:
+			pending = true
+		}
+
+		return true
+	})
+
+	if pending {
+		h.sendMessage(msg, h.Locales.Format(locale, "approval_pending", localizator.Args{"ID": secret.StableID()}))
+
+		return
+	}
+
+	raw, err := crypto.MakeRandom(approvalIDLength)
+	if err != nil {
+		h.logger(msg).Error("Make approval ID: " + err.Error())
+		h.sendFailure(msg, "approval_unable_request", err)
+
+		return
+	}
+
+	id := base58.Encode(raw)
+
+	h.approvals.Store(id, &approvalRequest{
+		ChatID:    msg.Chat.ID,
+		Username:  msg.Chat.Username,
+		Sender:    *msg.Sender,
+		SecretKey: key,
+		Expires:   time.Now().Add(approvalTimeout),
+	})
+
+	h.recordAudit(msg, audit.ActionApproval, key, "requested")
+
+	approve := ApproveButton
+	approve.Text = h.Locales.Get("en", "approval_approve_button")
+	approve.Data = id
+
+	reject := RejectButton
+	reject.Text = h.Locales.Get("en", "approval_reject_button")
+	reject.Data = id
+
+	text := h.Locales.Format("en", "approval_request", localizator.Args{
+		"Name":        html.EscapeString(senderName(msg)),
+		"ChatID":      msg.Chat.ID,
+		"ID":          secret.StableID(),
+		"Description": html.EscapeString(secret.Description),
+	})
+
+	for _, approver := range h.approvers() {
+		if approver == msg.Chat.ID {
+			continue
+		}
+
+		_, err := h.Chat.SendMessage(approver, text, chat.Options{
+			Buttons: [][]chat.Button{{approve, reject}},
+			Notify:  true,
+		})
+		if err != nil {
+			log.Error("Unable to send an approval request: "+err.Error(), "chat_id", approver)
+		}
+	}
+
+	h.sendMessage(msg, h.Locales.Format(locale, "approval_requested", localizator.Args{"ID": secret.StableID()}))
+}
+
+// ApproveCallback reveals the secret of the request to the requesting chat,
+// the first answer of the approvers decides.
+func (h *Handler) ApproveCallback(msg *chat.Message, c *chat.Callback) {
+	req, ok := h.takeApproval(msg, c.Data)
+	if !ok {
+		return
+	}
+
+	reqMsg := req.message()
+
+	privkey, err := h.unlock(reqMsg)
+	if err != nil {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "reveal_unlock_first"))
+
+		return
+	}
+
+	secrets, err := h.storage(reqMsg).GetSecrets()
+	if err != nil {
+		h.logger(msg).Error("Get secrets: " + err.Error())
+
+		return
+	}
+
+	index := findSecret(secrets, req.SecretKey)
+	if index < 0 || !h.isVisible(reqMsg, secrets[index]) {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "edit_secret_not_found"))
+
+		return
+	}
+
+	// The approved reveal counts to the limit of the requesting chat.
+	if next := h.nextUse(req.ChatID, audit.UsesReveal, 1); !next.IsZero() {
+		for _, m := range []*chat.Message{msg, reqMsg} {
+			h.sendMessage(m, h.Locales.Format(m.Sender.LanguageCode, "limit_reached", localizator.Args{
+				"Name": audit.UsesReveal,
+				"Next": next,
+			}))
+		}
+
+		return
+	}
+
+	decSecret, err := decryptSecret(privkey, secrets[index], h.signed(reqMsg))
+	if err != nil {
+		h.logger(msg).Error(err.Error())
+		h.sendFailure(msg, "approval_unable_reveal", err)
+
+		return
+	}
+
+	h.recordAuditEvent(msg, audit.Event{Action: audit.ActionApproval, SecretKey: req.SecretKey, Target: req.ChatID, Details: "approved"})
+	h.recordAudit(reqMsg, audit.ActionReveal, req.SecretKey, "approved")
+
+	h.sendSecret(reqMsg, secrets[index].StableID(), decSecret, req.SecretKey, "")
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "approval_approved", localizator.Args{"ChatID": req.ChatID}))
+}
+
+// RejectCallback turns the request down and tells the requesting chat.
+func (h *Handler) RejectCallback(msg *chat.Message, c *chat.Callback) {
+	req, ok := h.takeApproval(msg, c.Data)
+	if !ok {
+		return
+	}
+
+	h.recordAuditEvent(msg, audit.Event{Action: audit.ActionApproval, SecretKey: req.SecretKey, Target: req.ChatID, Details: "rejected"})
+
+	reqMsg := req.message()
+
+	h.sendMessage(reqMsg, h.Locales.Get(reqMsg.Sender.LanguageCode, "approval_request_rejected"))
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "approval_rejected", localizator.Args{"ChatID": req.ChatID}))
+}
+
+// takeApproval removes the pending request answered by the approver, so only
+// the first answer counts.
+func (h *Handler) takeApproval(msg *chat.Message, id string) (*approvalRequest, bool) {
+	locale := msg.Sender.LanguageCode
+
+	if !h.isApprover(msg.Chat.ID) {
+		h.sendMessage(msg, "Access forbidden")
+
+		return nil, false
+	}
+
+	value, ok := h.approvals.LoadAndDelete(id)
+	if !ok || time.Now().After(value.(*approvalRequest).Expires) {
+		h.sendMessage(msg, h.Locales.Get(locale, "approval_handled"))
+
+		return nil, false
+	}
+
+	return value.(*approvalRequest), true
+}
+
+// approvers returns the chats answering the requests, the admins if none
+// are set.
+func (h *Handler) approvers() []int64 {
+	if len(h.Config.Approvers) > 0 {
+		return h.Config.Approvers
+	}
+
+	return h.Config.AdminList
+}
+
+func (h *Handler) isApprover(chatID int64) bool {
+	for _, approver := range h.approvers() {
+		if approver == chatID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,289 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ArchiveVault is the name of the archive in the backups.
+	ArchiveVault = "archive"
+
+	archiveCheckInterval = 24 * time.Hour
+	archiveMonth         = 30 * 24 * time.Hour
+)
+
+// StartArchiving runs the background archiving of the secrets of the default
+// vault not revealed for the months of the archive policy.
+func (h *Handler) StartArchiving() {
+	if h.ArchiveProvider == nil || h.Config.Archive.Months <= 0 {
+		return
+	}
+
+	go func() {
+		for {
+			h.archiveIdle()
+			time.Sleep(archiveCheckInterval)
+		}
+	}()
+}
+
+func (h *Handler) archiveIdle() {
+	secrets, err := h.TablesProvider.GetSecrets()
+	if err != nil {
+		log.Error("Get secrets for archiving: " + err.Error())
+
+		return
+	}
+
+	maxIdle := time.Duration(h.Config.Archive.Months) * archiveMonth
+
+	var idle []providers.SecretsData
+
+	for _, secret := range secrets {
+		key := audit.SecretKey(secret)
+
+		// The canaries are left in sight of the intruders.
+		if h.Audit.Canary(key) {
+			continue
+		}
+
+		lastUse, ok := h.lastUse(key)
+		if !ok {
+			// Start counting from now for the secrets added before auditing.
+			h.writeAudit(audit.Event{Action: audit.ActionAdd, SecretKey: key, Details: "discovered"})
+
+			continue
+		}
+
+		if time.Since(lastUse) > maxIdle {
+			idle = append(idle, secret)
+		}
+	}
+
+	if len(idle) == 0 {
+		return
+	}
+
+	if err = moveSecrets(h.TablesProvider, h.ArchiveProvider, idle); err != nil {
+		log.Error("Archive secrets: " + err.Error())
+
+		return
+	}
+
+	for _, secret := range idle {
+		h.writeAudit(audit.Event{Action: audit.ActionArchive, SecretKey: audit.SecretKey(secret), Details: "archived"})
+	}
+
+	log.Info("🗄 Archived idle secrets", "count", len(idle))
+}
+
+// lastUse returns the latest reveal, change or restore of the secret, if any
+// is known.
+func (h *Handler) lastUse(key string) (time.Time, bool) {
+	lastUse, ok := h.Audit.Added(key)
+
+	if usage := h.Audit.Usage(key); usage.LastAccess.After(lastUse) {
+		lastUse, ok = usage.LastAccess, true
+	}
+
+	if restored := h.Audit.Restored(key); restored.After(lastUse) {
+		lastUse, ok = restored, true
+	}
+
+	return lastUse, ok
+}
+
+// moveSecrets adds the secrets to the storage and deletes them from the
+// other one, the added ones are deleted again if the delete fails.
+func moveSecrets(from, to providers.StorageProvider, secrets []providers.SecretsData) error {
+	ids := make([]string, len(secrets))
+	for i, secret := range secrets {
+		ids[i] = secret.StableID()
+	}
+
+	if err := to.AddSecrets(secrets); err != nil {
+		return errors.Wrap(err, "add secrets")
+	}
+
+	if err := from.DeleteSecrets(ids); err != nil {
+		if rollbackErr := to.DeleteSecrets(ids); rollbackErr != nil {
+			log.Error("Unable to delete the moved secrets: " + rollbackErr.Error())
+		}
+
+		return errors.Wrap(err, "delete secrets")
+	}
+
+	return nil
+}
+
+// Archive searches the archived secrets of the default vault, e.g.
+// /archive search github, and moves them back with /archive restore <id>.
+func (h *Handler) Archive(msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+	args := strings.Fields(strings.TrimPrefix(msg.Text, "/archive"))
+
+	if h.ArchiveProvider == nil {
+		h.sendMessage(msg, h.Locales.Get(locale, "archive_disabled"))
+
+		return
+	}
+
+	if h.vaultName(msg) != DefaultVault {
+		h.sendMessage(msg, h.Locales.Get(locale, "archive_default_vault"))
+
+		return
+	}
+
+	switch {
+	case len(args) > 1 && args[0] == "search":
+		h.searchArchive(msg, strings.ToLower(strings.Join(args[1:], " ")))
+	case len(args) == 2 && args[0] == "restore":
+		if h.hasRole(msg, RoleMember) {
+			h.restoreArchived(msg, args[1])
+		}
+	default:
+		h.sendMessage(msg, h.Locales.Format(locale, "archive_usage", localizator.Args{"Months": h.Config.Archive.Months}))
+	}
+}
+
+func (h *Handler) searchArchive(msg *chat.Message, query string) {
+	locale := msg.Sender.LanguageCode
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		return
+	}
+
+	filter := h.visibleFilter(msg)
+	filter.Description = query
+
+	secrets, err := h.storageOf(msg, h.ArchiveProvider).QuerySecrets(filter, 0, 0)
+	if err != nil {
+		h.logger(msg).Error("Query archived secrets: " + err.Error())
+
+		return
+	}
+
+	if len(secrets) == 0 {
+		h.sendMessage(msg, h.Locales.Get(locale, "query_no_secrets"))
+
+		return
+	}
+
+	for _, secret := range secrets {
+		if !verifySecret(privkey, secret, h.isSigned(ArchiveVault)) {
+			h.reportTampered(msg, secret)
+
+			continue
+		}
+
+		// The approvers answer the requests of the default vault, so the
+		// restricted secrets are restored first.
+		err := h.checkReveal(msg, secret, revealDisplayed)
+		if errors.Is(err, ErrNeedsApproval) {
+			h.sendMessage(msg, h.Locales.Format(locale, "archive_needs_approval", localizator.Args{"ID": secret.StableID()}))
+
+			continue
+		}
+
+		// The limit is checked before the secret is decrypted.
+		switch err := h.gateReveal(msg, secret, revealDisplayed); {
+		case errors.Is(err, ErrRevealLimit):
+			return
+		case err != nil:
+			continue
+		}
+
+		decSecret, err := decryptSecret(privkey, secret, h.isSigned(ArchiveVault))
+		if err != nil {
+			h.logger(msg).Error("Decrypt archived secret: "+err.Error(), "id", secret.StableID())
+
+			continue
+		}
+
+		footer := "\n" + h.Locales.Format(locale, "archive_footer", localizator.Args{"ID": secret.StableID()})
+
+		h.recordAudit(msg, audit.ActionReveal, audit.SecretKey(secret), "")
+		h.sendSecret(msg, secret.StableID(), decSecret, audit.SecretKey(secret), footer)
+	}
+}
+
+// restoreArchived moves the archived secret back to the default vault, it
+// gets a new ID if a secret added meanwhile has taken its ID.
+func (h *Handler) restoreArchived(msg *chat.Message, id string) {
+	locale := msg.Sender.LanguageCode
+	archive := h.storageOf(msg, h.ArchiveProvider)
+
+	archived, err := archive.GetSecrets()
+	if err != nil {
+		h.sendFailure(msg, "archive_unable_restore", err)
+
+		return
+	}
+
+	index := h.findVisible(msg, archived, id)
+	if index < 0 {
+		h.sendMessage(msg, h.Locales.Get(locale, "edit_secret_not_found"))
+
+		return
+	}
+
+	secret := archived[index]
+
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		h.sendFailure(msg, "archive_unable_restore", err)
+
+		return
+	}
+
+	if providers.FindByID(secrets, secret.StableID()) >= 0 {
+		secret.ID = providers.NewID(append(secrets, archived...))
+
+		signed, err := h.signSecrets(msg, secret)
+		if err != nil {
+			h.sendFailure(msg, "archive_unable_restore", err)
+
+			return
+		}
+
+		secret = signed[0]
+	}
+
+	if err = h.storage(msg).AddSecret(secret); err != nil {
+		h.logger(msg).Error("Restore archived secret: " + err.Error())
+		h.sendFailure(msg, "archive_unable_restore", err)
+
+		return
+	}
+
+	if err = archive.DeleteSecrets([]string{archived[index].StableID()}); err != nil {
+		h.logger(msg).Error("Delete restored secret from archive: " + err.Error())
+	}
+
+	h.recordAudit(msg, audit.ActionArchive, audit.SecretKey(secret), audit.ArchiveRestored)
+
+	h.sendMessage(msg, h.Locales.Format(locale, "archive_restored", localizator.Args{"ID": secret.StableID()}))
+}
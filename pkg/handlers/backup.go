@@ -0,0 +1,79 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"html"
+	"time"
+
+	"secretable/pkg/backup"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+)
+
+// StartBackups uploads the archive of the vaults on the schedule until the
+// context is done, the admins are notified about the failed backups.
+func (h *Handler) StartBackups(ctx context.Context, job *backup.Job, schedule backup.Schedule) {
+	go func() {
+		for {
+			next := schedule.Next(time.Now())
+			if next.IsZero() {
+				log.Error("Backup schedule never fires", "schedule", h.Config.Backup.Schedule)
+
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(next)):
+			}
+
+			h.runBackup(ctx, job)
+		}
+	}()
+}
+
+func (h *Handler) runBackup(ctx context.Context, job *backup.Job) {
+	name, err := job.Run(ctx, h.backupVaults())
+	if err != nil {
+		log.Error("Backup: " + err.Error())
+
+		h.notifyAdmins(0, h.Locales.Format("en", "backup_failed", localizator.Args{
+			"Error": html.EscapeString(err.Error()),
+		}))
+
+		return
+	}
+
+	log.Info("💾 Backup uploaded: " + name)
+}
+
+// backupVaults returns the storages of all the vaults by their names.
+func (h *Handler) backupVaults() map[string]providers.StorageProvider {
+	vaults := make(map[string]providers.StorageProvider, len(h.Vaults)+1)
+
+	for _, name := range h.vaultNames() {
+		vaults[name] = h.vaultStorage(name)
+	}
+
+	if h.ArchiveProvider != nil {
+		vaults[ArchiveVault] = h.ArchiveProvider
+	}
+
+	return vaults
+}
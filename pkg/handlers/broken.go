@@ -0,0 +1,202 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"strings"
+)
+
+// numbBroken is the number of the broken rows listed with the buttons.
+const numbBroken = 10
+
+// BrokenButton deletes or re-enters a row which doesn't decrypt.
+var BrokenButton = chat.Button{Unique: "broken"}
+
+// isBroken reports whether the health is of a row which doesn't decode or
+// decrypt.
+func isBroken(health Health) bool {
+	return health == HealthEncoding || health == HealthDecrypt
+}
+
+// sendBrokenNotice tells the chat about the rows the query skipped since
+// they don't decrypt.
+func (h *Handler) sendBrokenNotice(msg *chat.Message, broken []providers.SecretsData) {
+	indexes := make([]int, len(broken))
+	for i := range broken {
+		indexes[i] = i
+	}
+
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "broken_skipped", localizator.Args{
+		"Count": len(broken),
+		"IDs":   formatIDs(broken, indexes),
+	}))
+}
+
+// Broken lists the visible rows which don't decode or decrypt with the buttons
+// deleting or re-entering them.
+func (h *Handler) Broken(msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		return
+	}
+
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		h.logger(msg).Error("Get secrets: " + err.Error())
+		h.sendFailure(msg, "verify_unable_read", err)
+
+		return
+	}
+
+	var (
+		bld       strings.Builder
+		buttons   [][]chat.Button
+		count     int
+		plaintext bool
+	)
+
+	for _, secret := range secrets {
+		if !h.isVisible(msg, secret) {
+			continue
+		}
+
+		health := CheckSecret(privkey, secret, h.signed(msg))
+		if !isBroken(health) {
+			continue
+		}
+
+		count++
+
+		if isPlaintext(secret) {
+			plaintext = true
+		}
+
+		if count > numbBroken {
+			continue
+		}
+
+		bld.WriteString("\n" + h.Locales.Format(locale, "broken_row", localizator.Args{
+			"ID":          secret.StableID(),
+			"Description": html.EscapeString(secret.Description),
+			"Reason":      h.Locales.Get(locale, "broken_"+string(health)),
+		}))
+
+		key := audit.SecretKey(secret)
+
+		remove := BrokenButton
+		remove.Text = h.Locales.Format(locale, "broken_delete_button", localizator.Args{"ID": secret.StableID()})
+		remove.Data = "delete|" + key
+
+		reenter := BrokenButton
+		reenter.Text = h.Locales.Format(locale, "broken_reenter_button", localizator.Args{"ID": secret.StableID()})
+		reenter.Data = "reenter|" + key
+
+		buttons = append(buttons, []chat.Button{remove, reenter})
+	}
+
+	if count == 0 {
+		h.sendMessage(msg, h.Locales.Get(locale, "broken_none"))
+
+		return
+	}
+
+	text := h.Locales.Format(locale, "broken_header", localizator.Args{"Count": count}) + bld.String()
+
+	if count > numbBroken {
+		text += "\n" + h.Locales.Format(locale, "broken_more", localizator.Args{"Count": count - numbBroken})
+	}
+
+	if plaintext {
+		text += "\n\n" + h.Locales.Get(locale, "broken_plaintext")
+	}
+
+	h.sendMessageWithOptions(msg, text, chat.Options{Buttons: buttons})
+}
+
+// BrokenCallback deletes the broken row or asks for its values again, the rows
+// which decrypt by now are left as they are.
+func (h *Handler) BrokenCallback(msg *chat.Message, c *chat.Callback) {
+	locale := msg.Sender.LanguageCode
+
+	parts := strings.SplitN(c.Data, "|", 2)
+	if len(parts) != 2 {
+		return
+	}
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		h.sendMessage(msg, h.Locales.Get(locale, "reveal_unlock_first"))
+
+		return
+	}
+
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		h.logger(msg).Error("Get secrets: " + err.Error())
+
+		return
+	}
+
+	index := findSecret(secrets, parts[1])
+	if index < 0 || !h.isVisible(msg, secrets[index]) {
+		h.sendMessage(msg, h.Locales.Get(locale, "edit_secret_not_found"))
+
+		return
+	}
+
+	secret := secrets[index]
+
+	if !isBroken(CheckSecret(privkey, secret, h.signed(msg))) {
+		h.sendMessage(msg, h.Locales.Get(locale, "broken_healthy"))
+
+		return
+	}
+
+	if parts[0] == "delete" {
+		if err = h.storage(msg).DeleteSecrets([]string{secret.StableID()}); err != nil {
+			h.logger(msg).Error("Delete broken secret: " + err.Error())
+			h.sendFailure(msg, "delete_unable_delete", err)
+
+			return
+		}
+
+		h.recordAudit(msg, audit.ActionDelete, parts[1], secret.StableID())
+		h.sendMessage(msg, h.Locales.Get(locale, "delete_secret_deleted"))
+
+		return
+	}
+
+	if _, ok := h.fieldsOf(secret.Type); ok {
+		h.startStructuredFlow(msg, secret.Type, parts[1])
+
+		return
+	}
+
+	// The edit flow replaces the row by the key, the old values aren't
+	// needed.
+	h.conversations.start(msg.Chat.ID, convEdit, parts[1])
+
+	h.sendMessage(msg, h.Locales.Format(locale, "broken_reenter", localizator.Args{
+		"ID":          secret.StableID(),
+		"Description": html.EscapeString(secret.Description),
+	}))
+}
@@ -0,0 +1,304 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	bulkDelete  = "delete"
+	bulkRetag   = "retag"
+	bulkEncrypt = "encrypt"
+
+	bulkTimeout = 5 * time.Minute
+)
+
+// BulkButton confirms or cancels the pending bulk operation.
+var BulkButton = chat.Button{Unique: "bulk"}
+
+// bulkOperation is the bulk operation waiting for the confirmation. The secrets
+// are kept by the audit keys, so the secrets changed in the meantime are left
+// untouched.
+type bulkOperation struct {
+	Action string
+	Keys   []string
+	// Tag is replaced with NewTag, NewTag is added if Tag is empty.
+	Tag    string
+	NewTag string
+	// Backup is the path of the backup of the encrypted storage.
+	Backup string
+	At     time.Time
+}
+
+// DeleteAll previews the secrets of the tag or the query and asks to confirm
+// the deletion.
+func (h *Handler) DeleteAll(msg *chat.Message) {
+	arg := strings.TrimSpace(strings.TrimPrefix(msg.Text, "/deleteall"))
+	if arg == "" {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "bulk_deleteall_usage"))
+
+		return
+	}
+
+	op := &bulkOperation{Action: bulkDelete}
+
+	h.previewBulk(msg, op, arg, "bulk_delete_preview")
+}
+
+// Retag previews the secrets of the tag or the query and asks to confirm the
+// new tag. The tag is replaced, the secrets of the query get the tag added.
+func (h *Handler) Retag(msg *chat.Message) {
+	args := strings.Fields(strings.TrimPrefix(msg.Text, "/retag"))
+	if len(args) < 2 || !isTag(args[len(args)-1]) {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "bulk_retag_usage"))
+
+		return
+	}
+
+	op := &bulkOperation{Action: bulkRetag, NewTag: strings.ToLower(args[len(args)-1])}
+
+	query := strings.Join(args[:len(args)-1], " ")
+	if len(args) == 2 && isTag(args[0]) {
+		op.Tag = strings.ToLower(args[0])
+	}
+
+	h.previewBulk(msg, op, query, "bulk_retag_preview")
+}
+
+func (h *Handler) previewBulk(msg *chat.Message, op *bulkOperation, query, previewKey string) {
+	locale := msg.Sender.LanguageCode
+
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		h.logger(msg).Error("Get secrets: " + err.Error())
+		h.sendFailure(msg, "bulk_unable", err)
+
+		return
+	}
+
+	var indexes []int
+
+	for _, index := range h.matchBulk(msg, secrets, query) {
+		if op.Action == bulkRetag && op.Tag == "" && secrets[index].HasTag(op.NewTag) {
+			continue
+		}
+
+		op.Keys = append(op.Keys, audit.SecretKey(secrets[index]))
+		indexes = append(indexes, index)
+	}
+
+	if len(indexes) == 0 {
+		h.sendMessage(msg, h.Locales.Get(locale, "bulk_no_secrets"))
+
+		return
+	}
+
+	op.At = time.Now()
+	h.bulkstates.Store(msg.Chat.ID, op)
+
+	confirm := BulkButton
+	confirm.Text = h.Locales.Get(locale, "bulk_confirm_button")
+	confirm.Data = "confirm"
+
+	cancel := BulkButton
+	cancel.Text = h.Locales.Get(locale, "bulk_cancel_button")
+	cancel.Data = "cancel"
+
+	h.sendMessageWithOptions(msg, h.Locales.Format(locale, previewKey, localizator.Args{
+		"Count": len(indexes),
+		"IDs":   formatIDs(secrets, indexes),
+		"Tag":   op.NewTag,
+	}), chat.Options{Buttons: [][]chat.Button{{confirm, cancel}}})
+}
+
+// matchBulk returns the indexes of the visible secrets of the "#tag" or
+// matching the query.
+func (h *Handler) matchBulk(msg *chat.Message, secrets []providers.SecretsData, query string) []int {
+	if !isTag(query) {
+		return h.matchQuery(msg, secrets, strings.ToLower(query))
+	}
+
+	var indexes []int
+
+	for index, secret := range secrets {
+		if h.isVisible(msg, secret) && secret.HasTag(query) {
+			indexes = append(indexes, index)
+		}
+	}
+
+	return indexes
+}
+
+func (h *Handler) BulkCallback(msg *chat.Message, c *chat.Callback) {
+	state, ok := h.bulkstates.LoadAndDelete(msg.Chat.ID)
+	if !ok {
+		return
+	}
+
+	op := state.(*bulkOperation)
+	locale := msg.Sender.LanguageCode
+
+	if c.Data != "confirm" {
+		h.sendMessage(msg, h.Locales.Get(locale, "bulk_canceled"))
+
+		return
+	}
+
+	if time.Since(op.At) > bulkTimeout {
+		h.sendMessage(msg, h.Locales.Get(locale, "bulk_expired"))
+
+		return
+	}
+
+	apply, doneKey := h.bulkDelete, "bulk_deleted"
+
+	switch op.Action {
+	case bulkRetag:
+		apply, doneKey = h.bulkRetag, "bulk_retagged"
+	case bulkEncrypt:
+		apply, doneKey = h.bulkEncrypt, "encrypt_done"
+	}
+
+	count, err := apply(msg, op)
+	if err != nil {
+		h.logger(msg).Error("Bulk "+op.Action+": "+err.Error(), "chat_id", msg.Chat.ID)
+		h.sendFailure(msg, "bulk_unable", err)
+
+		return
+	}
+
+	h.sendMessage(msg, h.Locales.Format(locale, doneKey, localizator.Args{"Count": count, "Backup": html.EscapeString(op.Backup)}))
+}
+
+// pendingSecrets returns the indexes of the visible secrets of the operation
+// which are still stored.
+func (h *Handler) pendingSecrets(msg *chat.Message, op *bulkOperation) ([]providers.SecretsData, []int, error) {
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get secrets")
+	}
+
+	keys := make(map[string]bool, len(op.Keys))
+	for _, key := range op.Keys {
+		keys[key] = true
+	}
+
+	var indexes []int
+
+	for index, secret := range secrets {
+		if keys[audit.SecretKey(secret)] && h.isVisible(msg, secret) {
+			indexes = append(indexes, index)
+		}
+	}
+
+	return secrets, indexes, nil
+}
+
+func (h *Handler) bulkDelete(msg *chat.Message, op *bulkOperation) (int, error) {
+	secrets, indexes, err := h.pendingSecrets(msg, op)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([]string, len(indexes))
+	for i, index := range indexes {
+		ids[i] = secrets[index].StableID()
+	}
+
+	if err = h.storage(msg).DeleteSecrets(ids); err != nil {
+		return 0, errors.Wrap(err, "delete secrets")
+	}
+
+	for _, index := range indexes {
+		h.recordAudit(msg, audit.ActionDelete, audit.SecretKey(secrets[index]), secrets[index].StableID())
+	}
+
+	return len(indexes), nil
+}
+
+// bulkRetag rewrites the descriptions of the secrets in place.
+func (h *Handler) bulkRetag(msg *chat.Message, op *bulkOperation) (int, error) {
+	secrets, indexes, err := h.pendingSecrets(msg, op)
+	if err != nil {
+		return 0, err
+	}
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	retagged := make([]providers.SecretsData, len(indexes))
+
+	for i, index := range indexes {
+		if !verifySecret(privkey, secrets[index], h.signed(msg)) {
+			return 0, errors.Wrap(ErrTampered, secrets[index].StableID())
+		}
+
+		retagged[i] = secrets[index]
+		retagged[i].ID = secrets[index].StableID()
+		retagged[i].Description = retag(secrets[index].Description, op.Tag, op.NewTag)
+		retagged[i] = signSecret(privkey, retagged[i])
+	}
+
+	if err = h.storage(msg).UpdateSecrets(retagged); err != nil {
+		return 0, errors.Wrap(err, "update secrets")
+	}
+
+	for i, index := range indexes {
+		h.recordAudit(msg, audit.ActionRetag, audit.SecretKey(retagged[i]), audit.SecretKey(secrets[index]))
+	}
+
+	return len(indexes), nil
+}
+
+// retag replaces the tag of the description with the new one, the new tag is
+// appended if the tag is empty.
+func retag(description, tag, newTag string) string {
+	if tag == "" {
+		return description + " " + newTag
+	}
+
+	words := strings.Fields(description)
+	tagged := make([]string, 0, len(words))
+	seen := false
+
+	for _, word := range words {
+		if strings.EqualFold(word, tag) || strings.EqualFold(word, newTag) {
+			if seen {
+				continue
+			}
+
+			word, seen = newTag, true
+		}
+
+		tagged = append(tagged, word)
+	}
+
+	return strings.Join(tagged, " ")
+}
+
+func isTag(word string) bool {
+	return len(word) > 1 && strings.HasPrefix(word, "#") && !strings.ContainsAny(word, " \t\n")
+}
@@ -0,0 +1,143 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+	"strings"
+	"time"
+)
+
+// Canary marks a decoy secret of the active vault, the admins are alerted as
+// soon as it is revealed. "/canary" lists the canaries, "/canary <id>" adds
+// one and "/canary remove <id>" removes it. The mark is kept in the audit log
+// only, so the secret looks like any other.
+func (h *Handler) Canary(msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+	args := strings.Fields(strings.TrimPrefix(msg.Text, "/canary"))
+
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		h.logger(msg).Error("Get secrets: " + err.Error())
+		h.sendFailure(msg, "canary_unable_change", err)
+
+		return
+	}
+
+	if len(args) == 0 {
+		h.listCanaries(msg, secrets)
+
+		return
+	}
+
+	step, doneKey := "add", "canary_added"
+	if args[0] == "remove" {
+		step, doneKey, args = "remove", "canary_removed", args[1:]
+	}
+
+	if len(args) != 1 {
+		h.sendMessage(msg, h.Locales.Get(locale, "canary_usage"))
+
+		return
+	}
+
+	index := providers.FindByID(secrets, args[0])
+	if index < 0 {
+		h.sendMessage(msg, h.Locales.Get(locale, "edit_secret_not_found"))
+
+		return
+	}
+
+	key := audit.SecretKey(secrets[index])
+	h.recordAudit(msg, audit.ActionCanary, key, step)
+
+	h.sendMessage(msg, h.Locales.Format(locale, doneKey, localizator.Args{"ID": secrets[index].StableID()}))
+}
+
+func (h *Handler) listCanaries(msg *chat.Message, secrets []providers.SecretsData) {
+	var lines []string
+
+	for _, secret := range secrets {
+		if h.Audit.Canary(audit.SecretKey(secret)) {
+			lines = append(lines, secret.StableID()+" "+html.EscapeString(secret.Description))
+		}
+	}
+
+	if len(lines) == 0 {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "canary_none"))
+
+		return
+	}
+
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "canary_list", localizator.Args{
+		"Canaries": strings.Join(lines, "\n"),
+	}))
+}
+
+// watchCanary alerts the admins if the event reveals a canary, the message is
+// nil for the events not of a chat.
+func (h *Handler) watchCanary(m *chat.Message, event audit.Event) {
+	switch {
+	case event.SecretKey == "" || !h.Audit.Canary(event.SecretKey):
+		return
+	case event.Action == audit.ActionReveal, event.Action == audit.ActionShare:
+	case event.Action == audit.ActionLink && (event.Details == "created" || event.Details == "viewed"):
+	default:
+		return
+	}
+
+	log.Error("🐤 Canary secret revealed", "chat_id", event.ChatID, "action", event.Action, "details", event.Details)
+
+	h.writeAudit(audit.Event{
+		ChatID: event.ChatID, Username: event.Username, Action: audit.ActionCanary,
+		SecretKey: event.SecretKey, Details: audit.CanaryTripped, Target: event.Target,
+	})
+
+	args := localizator.Args{
+		"ChatID":   event.ChatID,
+		"Username": html.EscapeString(event.Username),
+		"Action":   html.EscapeString(strings.TrimSpace(event.Action + " " + event.Details)),
+		"At":       time.Now(),
+		"Secret":   event.SecretKey,
+	}
+
+	key := "canary_alert"
+
+	if m != nil {
+		key = "canary_alert_chat"
+		args["Vault"] = html.EscapeString(h.vaultName(m))
+		args["Name"] = html.EscapeString(senderName(m))
+		args["UserID"] = m.Sender.ID
+		args["Language"] = html.EscapeString(m.Sender.LanguageCode)
+
+		secrets, err := h.storage(m).GetSecrets()
+		if index := findSecret(secrets, event.SecretKey); err == nil && index >= 0 {
+			args["Secret"] = secrets[index].StableID() + " " + html.EscapeString(secrets[index].Description)
+		}
+	}
+
+	text := h.Locales.Format("en", key, args)
+
+	for _, admin := range h.Config.AdminList {
+		if _, err := h.Chat.SendMessage(admin, text, chat.Options{Notify: true}); err != nil {
+			log.Error("Unable to send a canary alert: "+err.Error(), "chat_id", admin)
+		}
+	}
+}
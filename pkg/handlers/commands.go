@@ -0,0 +1,392 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"secretable/pkg/chat"
+	"strings"
+)
+
+// Role is the access level a chat needs to run a command.
+type Role int
+
+const (
+	// RoleAnyone allows the command for every chat.
+	RoleAnyone Role = iota
+	// RoleReader allows the command for the members of the allowed groups
+	// and the chats from the allowed list.
+	RoleReader
+	// RoleMember allows the command only for chats from the allowed list.
+	RoleMember
+	// RoleAdmin allows the command only for chats from the admin list.
+	RoleAdmin
+)
+
+// CleanupPolicy defines what happens with the messages of a command.
+type CleanupPolicy int
+
+const (
+	// CleanupNone keeps the received message in the chat.
+	CleanupNone CleanupPolicy = iota
+	// CleanupOnTimeout deletes the received message after the cleanup timeout.
+	CleanupOnTimeout
+)
+
+// Command describes a single bot route.
+type Command struct {
+	// Endpoint is a chat endpoint, e.g. "/add" or chat.OnText.
+	Endpoint string
+	Handler  func(*chat.Message)
+
+	Role    Role
+	Cleanup CleanupPolicy
+
+	// NeedsUnlock requires the master password before the handler is called.
+	NeedsUnlock bool
+	// Query marks the free text endpoint which also receives the answers of
+	// the pending flows (master password, new secret).
+	Query bool
+	// Redact hides the message text in the logs.
+	Redact bool
+	// Interrupt passes the command by the middlewares of the pending flows,
+	// so the handler sees the flows before they are dropped.
+	Interrupt bool
+
+	// DescriptionKey is a locale key of the command description. Commands
+	// without description are not shown in the menu and in the help.
+	DescriptionKey string
+}
+
+// Commands returns the table of all bot routes.
+func (h *Handler) Commands() []Command {
+	return []Command{
+		{
+			Endpoint: "/start", Handler: h.Start,
+			Role: RoleAnyone, Cleanup: CleanupNone,
+		},
+		{
+			Endpoint: "/help", Handler: h.Help,
+			Role: RoleAnyone, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_help_description",
+		},
+		{
+			Endpoint: "/id", Handler: h.ID,
+			Role: RoleAnyone, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_id_description",
+		},
+		{
+			Endpoint: "/whoami", Handler: h.WhoAmI,
+			Role: RoleAnyone, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_whoami_description",
+		},
+		{
+			Endpoint: "/join", Handler: h.Join,
+			Role: RoleAnyone, Cleanup: CleanupOnTimeout, Redact: true,
+			DescriptionKey: "command_join_description",
+		},
+		{
+			Endpoint: "/cancel", Handler: h.Cancel,
+			Role: RoleAnyone, Cleanup: CleanupOnTimeout, Interrupt: true,
+			DescriptionKey: "command_cancel_description",
+		},
+		{
+			Endpoint: "/version", Handler: h.Version,
+			Role: RoleReader, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_version_description",
+		},
+		{
+			Endpoint: "/sudo", Handler: h.Sudo,
+			Role: RoleReader, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_sudo_description",
+		},
+		{
+			Endpoint: "/generate", Handler: h.Generate,
+			Role: RoleAnyone, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_generate_description",
+		},
+		{
+			Endpoint: "/add", Handler: h.Set,
+			Role: RoleMember, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_add_description",
+		},
+		{
+			Endpoint: "/edit", Handler: h.Edit,
+			Role: RoleMember, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_edit_description",
+		},
+		{
+			Endpoint: "/rotate", Handler: h.Rotate,
+			Role: RoleMember, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_rotate_description",
+		},
+		{
+			Endpoint: "/app", Handler: h.App,
+			Role: RoleMember, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_app_description",
+		},
+		{
+			Endpoint: "/recent", Handler: h.Recent,
+			Role: RoleReader, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_recent_description",
+		},
+		{
+			Endpoint: "/review", Handler: h.Review,
+			Role: RoleAdmin, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_review_description",
+		},
+		{
+			Endpoint: "/archive", Handler: h.Archive,
+			Role: RoleReader, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_archive_description",
+		},
+		{
+			Endpoint: "/len", Handler: h.Len,
+			Role: RoleReader, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_len_description",
+		},
+		{
+			Endpoint: "/audit_passwords", Handler: h.AuditPasswords,
+			Role: RoleMember, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_audit_passwords_description",
+		},
+		{
+			Endpoint: "/verify", Handler: h.Verify,
+			Role: RoleMember, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_verify_description",
+		},
+		{
+			Endpoint: "/broken", Handler: h.Broken,
+			Role: RoleMember, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_broken_description",
+		},
+		{
+			Endpoint: "/env", Handler: h.Env,
+			Role: RoleReader, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_env_description",
+		},
+		{
+			Endpoint: "/share", Handler: h.Share,
+			Role: RoleMember, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_share_description",
+		},
+		{
+			Endpoint: "/link", Handler: h.Link,
+			Role: RoleMember, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_link_description",
+		},
+		{
+			Endpoint: "/delete", Handler: h.Delete,
+			Role: RoleMember, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_delete_description",
+		},
+		{
+			Endpoint: "/setpass", Handler: h.ResetPass,
+			Role: RoleMember, Cleanup: CleanupOnTimeout, NeedsUnlock: true, Redact: true,
+			DescriptionKey: "command_setpass_description",
+		},
+		{
+			Endpoint: "/vault", Handler: h.Vault,
+			Role: RoleReader, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_vault_description",
+		},
+		{
+			Endpoint: "/pair", Handler: h.Pair,
+			Role: RoleMember, Cleanup: CleanupOnTimeout, Redact: true,
+			DescriptionKey: "command_pair_description",
+		},
+		{
+			Endpoint: "/sessions", Handler: h.Sessions,
+			Role: RoleReader, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_sessions_description",
+		},
+		{
+			Endpoint: "/sync", Handler: h.Sync,
+			Role: RoleAdmin, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_sync_description",
+		},
+		{
+			Endpoint: "/status", Handler: h.Status,
+			Role: RoleAdmin, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_status_description",
+		},
+		{
+			Endpoint: "/maintenance", Handler: h.Maintenance,
+			Role: RoleAdmin, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_maintenance_description",
+		},
+		{
+			Endpoint: "/broadcast", Handler: h.Broadcast,
+			Role: RoleAdmin, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_broadcast_description",
+		},
+		{
+			Endpoint: "/encrypt_existing", Handler: h.EncryptExisting,
+			Role: RoleAdmin, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_encrypt_existing_description",
+		},
+		{
+			Endpoint: "/deleteall", Handler: h.DeleteAll,
+			Role: RoleAdmin, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_deleteall_description",
+		},
+		{
+			Endpoint: "/retag", Handler: h.Retag,
+			Role: RoleAdmin, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_retag_description",
+		},
+		{
+			Endpoint: "/slots", Handler: h.Slots,
+			Role: RoleAdmin, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+			DescriptionKey: "command_slots_description",
+		},
+		{
+			Endpoint: "/invite", Handler: h.Invite,
+			Role: RoleAdmin, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_invite_description",
+		},
+		{
+			Endpoint: "/canary", Handler: h.Canary,
+			Role: RoleAdmin, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_canary_description",
+		},
+		{
+			Endpoint: "/unblock", Handler: h.Unblock,
+			Role: RoleAdmin, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_unblock_description",
+		},
+		{
+			Endpoint: "/panic", Handler: h.Panic,
+			Role: RoleAdmin, Cleanup: CleanupOnTimeout,
+			DescriptionKey: "command_panic_description",
+		},
+		{
+			Endpoint: chat.OnText, Handler: h.Query,
+			Role: RoleReader, Cleanup: CleanupOnTimeout, NeedsUnlock: true, Query: true,
+		},
+	}
+}
+
+// Callback describes an inline button route. The presses pass the same
+// middlewares as the commands, the handler gets the message of the press with
+// the chat and the sender of the callback.
+type Callback struct {
+	Button  *chat.Button
+	Handler func(*chat.Message, *chat.Callback)
+
+	Role Role
+	// Cleanup deletes the message of the button after the cleanup timeout.
+	Cleanup CleanupPolicy
+
+	// NeedsUnlock asks to unlock the vault before the button is pressed again.
+	NeedsUnlock bool
+}
+
+// Callbacks returns the table of all inline button routes.
+func (h *Handler) Callbacks() []Callback {
+	return []Callback{
+		{
+			Button: &RotateButton, Handler: h.RotateCallback,
+			Role: RoleMember, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+		},
+		{
+			Button: &RevealButton, Handler: h.RevealCallback,
+			Role: RoleReader, Cleanup: CleanupNone, NeedsUnlock: true,
+		},
+		{
+			Button: &ApproveButton, Handler: h.ApproveCallback,
+			Role: RoleMember, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+		},
+		{
+			Button: &RejectButton, Handler: h.RejectCallback,
+			Role: RoleMember, Cleanup: CleanupOnTimeout,
+		},
+		{
+			Button: &SudoApproveButton, Handler: h.SudoApproveCallback,
+			Role: RoleAdmin, Cleanup: CleanupOnTimeout,
+		},
+		{
+			Button: &SudoRejectButton, Handler: h.SudoRejectCallback,
+			Role: RoleAdmin, Cleanup: CleanupOnTimeout,
+		},
+		{
+			Button: &LockButton, Handler: h.LockCallback,
+			Role: RoleAdmin, Cleanup: CleanupOnTimeout,
+		},
+		{
+			Button: &ConfirmButton, Handler: h.ConfirmCallback,
+			Role: RoleReader, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+		},
+		{
+			Button: &BrokenButton, Handler: h.BrokenCallback,
+			Role: RoleMember, Cleanup: CleanupOnTimeout, NeedsUnlock: true,
+		},
+		{
+			Button: &MaskButton, Handler: h.MaskCallback,
+			Role: RoleReader, Cleanup: CleanupNone, NeedsUnlock: true,
+		},
+		{
+			Button: &OnboardButton, Handler: h.OnboardCallback,
+			Role: RoleMember, Cleanup: CleanupNone,
+		},
+		{
+			Button: &TemplateButton, Handler: h.TemplateCallback,
+			Role: RoleMember, Cleanup: CleanupNone, NeedsUnlock: true,
+		},
+		{
+			Button: &BulkButton, Handler: h.BulkCallback,
+			Role: RoleAdmin, Cleanup: CleanupNone, NeedsUnlock: true,
+		},
+	}
+}
+
+// MenuCommands returns the commands shown in the command menu for the locale.
+func (h *Handler) MenuCommands(locale string) []chat.Command {
+	var cmds []chat.Command
+
+	for _, cmd := range h.Commands() {
+		if cmd.DescriptionKey == "" {
+			continue
+		}
+
+		cmds = append(cmds, chat.Command{
+			Text:        cmd.Endpoint,
+			Description: h.Locales.Get(locale, cmd.DescriptionKey),
+		})
+	}
+
+	return cmds
+}
+
+func (h *Handler) Help(msg *chat.Message) {
+	h.sendMessage(msg, h.makeHelp(msg.Sender.LanguageCode))
+}
+
+func (h *Handler) Start(msg *chat.Message) {
+	h.sendMessageWithoutCleanup(msg, h.makeHelp(msg.Sender.LanguageCode))
+}
+
+func (h *Handler) makeHelp(locale string) string {
+	var bld strings.Builder
+
+	bld.WriteString(h.Locales.Get(locale, "help_header"))
+	bld.WriteString("\n\n")
+
+	for _, cmd := range h.MenuCommands(locale) {
+		bld.WriteString(fmt.Sprintf("<code>%s</code> - %s\n", cmd.Text, cmd.Description))
+	}
+
+	return bld.String()
+}
@@ -0,0 +1,195 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"secretable/pkg/chat"
+	"sync"
+	"time"
+)
+
+// conversationTimeout is how long the bot waits for the answer of a question.
+const conversationTimeout = 5 * time.Minute
+
+// conversationKind is the question the chat is expected to answer.
+type conversationKind int
+
+const (
+	convNone conversationKind = iota
+	// convMasterPass waits for the master password to unlock the vault.
+	convMasterPass
+	// convOnboarding is the guided setup of the first master password.
+	convOnboarding
+	// convPassChange is the /setpass flow.
+	convPassChange
+	// convAddSecret waits for the lines of a new secret of the type.
+	convAddSecret
+	// convStructured waits for the next field of a structured secret.
+	convStructured
+	// convEdit waits for the new lines of the edited secret.
+	convEdit
+)
+
+// conversation is the pending question of a chat, every chat has at most one
+// and a new question replaces the previous one.
+type conversation struct {
+	Kind    conversationKind
+	State   interface{}
+	Expires time.Time
+}
+
+// conversations keeps the pending questions of the chats. The answers are
+// routed by the kind of the question, so a message is never taken by a
+// question asked before another one.
+type conversations struct {
+	chats map[int64]conversation
+	mx    sync.Mutex
+}
+
+// start asks the chat a new question, the pending one is dropped.
+func (c *conversations) start(chatID int64, kind conversationKind, state interface{}) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.chats == nil {
+		c.chats = make(map[int64]conversation)
+	}
+
+	c.chats[chatID] = conversation{Kind: kind, State: state, Expires: time.Now().Add(conversationTimeout)}
+}
+
+// touch extends the pending question of the kind after an answered step.
+func (c *conversations) touch(chatID int64, kind conversationKind) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if conv, ok := c.chats[chatID]; ok && conv.Kind == kind {
+		conv.Expires = time.Now().Add(conversationTimeout)
+		c.chats[chatID] = conv
+	}
+}
+
+// current returns the pending question of the chat, the kind is convNone if
+// there is none. The expired question is dropped and reported once.
+func (c *conversations) current(chatID int64) (conv conversation, expired bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	conv, ok := c.chats[chatID]
+	if !ok {
+		return conversation{}, false
+	}
+
+	if time.Now().After(conv.Expires) {
+		delete(c.chats, chatID)
+
+		return conversation{}, true
+	}
+
+	return conv, false
+}
+
+// pending reports whether the chat is asked a question, the expired one
+// included, so its late answer is still treated as sensitive.
+func (c *conversations) pending(chatID int64) bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	_, ok := c.chats[chatID]
+
+	return ok
+}
+
+// finish drops the pending question if it is one of the kinds, the question
+// asked meanwhile stays.
+func (c *conversations) finish(chatID int64, kinds ...conversationKind) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	conv, ok := c.chats[chatID]
+	if !ok {
+		return
+	}
+
+	for _, kind := range kinds {
+		if conv.Kind == kind {
+			delete(c.chats, chatID)
+
+			return
+		}
+	}
+}
+
+// cancel drops the pending question of the chat and returns its kind.
+func (c *conversations) cancel(chatID int64) conversationKind {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	conv, ok := c.chats[chatID]
+	delete(c.chats, chatID)
+
+	if !ok || time.Now().After(conv.Expires) {
+		return convNone
+	}
+
+	return conv.Kind
+}
+
+// clear drops the pending questions of all the chats.
+func (c *conversations) clear() {
+	c.mx.Lock()
+	c.chats = nil
+	c.mx.Unlock()
+}
+
+// cancelKeys are the confirmations of the canceled questions.
+var cancelKeys = map[conversationKind]string{
+	convMasterPass: "cancel_unlock",
+	convOnboarding: "cancel_onboarding",
+	convPassChange: "cancel_setpass",
+	convAddSecret:  "cancel_add",
+	convStructured: "cancel_add",
+	convEdit:       "cancel_edit",
+}
+
+// Cancel drops the pending question and the commands waiting for a
+// confirmation of the chat.
+func (h *Handler) Cancel(msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+
+	key, canceled := cancelKeys[h.conversations.cancel(msg.Chat.ID)]
+
+	for _, states := range []*sync.Map{&h.panicstates, &h.factorstates, &h.devicestates, &h.bulkstates} {
+		if _, ok := states.LoadAndDelete(msg.Chat.ID); ok && !canceled {
+			key, canceled = "cancel_confirmation", true
+		}
+	}
+
+	if !canceled {
+		h.sendMessage(msg, h.Locales.Get(locale, "cancel_nothing"))
+
+		return
+	}
+
+	h.logger(msg).Info("🚫 Flow canceled", "chat_id", msg.Chat.ID, "flow", key)
+	h.sendMessage(msg, h.Locales.Get(locale, key))
+}
+
+// expired tells the chat the answer came after the question expired, the
+// message isn't handled as a query since it may carry a password or a secret.
+func (h *Handler) expired(msg *chat.Message) {
+	h.deleteMessage(msg)
+	h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "conversation_expired"))
+}
@@ -0,0 +1,418 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"os"
+	"secretable/pkg/chat"
+	"secretable/pkg/config"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTransport records the texts sent and the messages deleted.
+type fakeTransport struct {
+	mx      sync.Mutex
+	sent    []string
+	deleted []int
+}
+
+func (t *fakeTransport) SendMessage(chatID int64, text string, opts chat.Options) (*chat.Message, error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.sent = append(t.sent, text)
+
+	return &chat.Message{ID: -len(t.sent), Chat: &chat.Chat{ID: chatID}}, nil
+}
+
+func (t *fakeTransport) SendFile(chatID int64, file chat.File, opts chat.Options) (*chat.Message, error) {
+	return &chat.Message{Chat: &chat.Chat{ID: chatID}}, nil
+}
+
+func (t *fakeTransport) EditMessage(chatID int64, messageID int, text string, opts chat.Options) error {
+	return nil
+}
+
+func (t *fakeTransport) DeleteMessage(chatID int64, messageID int) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	// The cleanups of the sent messages have negative IDs.
+	if messageID > 0 {
+		t.deleted = append(t.deleted, messageID)
+	}
+
+	return nil
+}
+
+func (t *fakeTransport) RespondCallback(c *chat.Callback) error {
+	return nil
+}
+
+func (t *fakeTransport) RegisterCommand(endpoint string, handler func(*chat.Message)) {
+}
+
+func (t *fakeTransport) RegisterButton(unique string, handler func(*chat.Callback)) {
+}
+
+func (t *fakeTransport) SetCommands(locale string, cmds []chat.Command) error {
+	return nil
+}
+
+func (t *fakeTransport) Token() string {
+	return ""
+}
+
+func (t *fakeTransport) texts() []string {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return append([]string(nil), t.sent...)
+}
+
+func newTestHandler(t *testing.T) (*Handler, *fakeTransport) {
+	t.Helper()
+
+	locales := &localizator.Localizator{}
+	if err := locales.InitFromFS(os.DirFS("../../cmd"), "locales"); err != nil {
+		t.Fatal(err)
+	}
+
+	transport := &fakeTransport{}
+
+	return &Handler{Config: &config.Config{}, Chat: transport, Locales: locales}, transport
+}
+
+func newTestMessage(text string) *chat.Message {
+	return &chat.Message{ID: 1, Chat: &chat.Chat{ID: 1}, Sender: &chat.User{ID: 1}, Text: text}
+}
+
+// expire moves the pending question of the chat past the timeout.
+func expire(c *conversations, chatID int64) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	conv := c.chats[chatID]
+	conv.Expires = time.Now().Add(-time.Second)
+	c.chats[chatID] = conv
+}
+
+func TestConversations(t *testing.T) {
+	tests := []struct {
+		name        string
+		steps       func(c *conversations)
+		wantKind    conversationKind
+		wantExpired bool
+		wantPending bool
+	}{
+		{
+			name:     "none",
+			steps:    func(c *conversations) {},
+			wantKind: convNone,
+		},
+		{
+			name: "started",
+			steps: func(c *conversations) {
+				c.start(1, convAddSecret, "")
+			},
+			wantKind:    convAddSecret,
+			wantPending: true,
+		},
+		{
+			name: "replaced",
+			steps: func(c *conversations) {
+				c.start(1, convAddSecret, "")
+				c.start(1, convEdit, "key")
+			},
+			wantKind:    convEdit,
+			wantPending: true,
+		},
+		{
+			name: "other chat",
+			steps: func(c *conversations) {
+				c.start(2, convAddSecret, "")
+			},
+			wantKind: convNone,
+		},
+		{
+			name: "finished",
+			steps: func(c *conversations) {
+				c.start(1, convEdit, "key")
+				c.finish(1, convAddSecret, convEdit)
+			},
+			wantKind: convNone,
+		},
+		{
+			name: "finished other kind",
+			steps: func(c *conversations) {
+				c.start(1, convPassChange, nil)
+				c.finish(1, convAddSecret, convEdit)
+			},
+			wantKind:    convPassChange,
+			wantPending: true,
+		},
+		{
+			name: "canceled",
+			steps: func(c *conversations) {
+				c.start(1, convAddSecret, "")
+				c.cancel(1)
+			},
+			wantKind: convNone,
+		},
+		{
+			name: "expired",
+			steps: func(c *conversations) {
+				c.start(1, convAddSecret, "")
+				expire(c, 1)
+			},
+			wantKind:    convNone,
+			wantExpired: true,
+			wantPending: true,
+		},
+		{
+			name: "touched",
+			steps: func(c *conversations) {
+				c.start(1, convPassChange, nil)
+				expire(c, 1)
+				c.touch(1, convPassChange)
+			},
+			wantKind:    convPassChange,
+			wantPending: true,
+		},
+		{
+			name: "touched other kind",
+			steps: func(c *conversations) {
+				c.start(1, convPassChange, nil)
+				expire(c, 1)
+				c.touch(1, convEdit)
+			},
+			wantKind:    convNone,
+			wantExpired: true,
+			wantPending: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &conversations{}
+			tt.steps(c)
+
+			// The pending question is checked first, current drops the
+			// expired one.
+			if pending := c.pending(1); pending != tt.wantPending {
+				t.Errorf("pending() = %v, want %v", pending, tt.wantPending)
+			}
+
+			conv, expired := c.current(1)
+			if conv.Kind != tt.wantKind || expired != tt.wantExpired {
+				t.Errorf("current() = %v, %v, want %v, %v", conv.Kind, expired, tt.wantKind, tt.wantExpired)
+			}
+
+			if _, expired = c.current(1); expired {
+				t.Error("current() reports the expired question twice")
+			}
+		})
+	}
+}
+
+func TestConversationTimeout(t *testing.T) {
+	c := &conversations{}
+
+	before := time.Now()
+	c.start(1, convAddSecret, "")
+
+	expires := c.chats[1].Expires
+	if expires.Before(before.Add(conversationTimeout)) || expires.After(time.Now().Add(conversationTimeout)) {
+		t.Errorf("Expires = %v, want %v after the start", expires, conversationTimeout)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps func(h *Handler)
+		want  string
+	}{
+		{
+			name:  "nothing",
+			steps: func(h *Handler) {},
+			want:  "cancel_nothing",
+		},
+		{
+			name: "add",
+			steps: func(h *Handler) {
+				h.conversations.start(1, convAddSecret, "")
+			},
+			want: "cancel_add",
+		},
+		{
+			name: "edit",
+			steps: func(h *Handler) {
+				h.conversations.start(1, convEdit, "key")
+			},
+			want: "cancel_edit",
+		},
+		{
+			name: "expired",
+			steps: func(h *Handler) {
+				h.conversations.start(1, convAddSecret, "")
+				expire(&h.conversations, 1)
+			},
+			want: "cancel_nothing",
+		},
+		{
+			name: "confirmation",
+			steps: func(h *Handler) {
+				h.panicstates.Store(int64(1), true)
+			},
+			want: "cancel_confirmation",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, transport := newTestHandler(t)
+			tt.steps(h)
+
+			h.Cancel(newTestMessage("/cancel"))
+
+			want := h.Locales.Get("", tt.want)
+			if texts := transport.texts(); len(texts) != 1 || texts[0] != want {
+				t.Errorf("sent %q, want %q", texts, want)
+			}
+
+			if h.conversations.pending(1) {
+				t.Error("the question is pending after /cancel")
+			}
+		})
+	}
+}
+
+func TestControlSetSecretMiddleware(t *testing.T) {
+	tests := []struct {
+		name         string
+		isSetHandler bool
+		steps        func(h *Handler)
+		wantNext     bool
+		wantKind     conversationKind
+		wantSent     string
+		wantDeleted  bool
+	}{
+		{
+			name:         "search",
+			isSetHandler: true,
+			steps:        func(h *Handler) {},
+			wantNext:     true,
+		},
+		{
+			name:         "structured field",
+			isSetHandler: true,
+			steps: func(h *Handler) {
+				h.startStructuredFlow(newTestMessage(""), providers.TypeCard, "")
+			},
+			wantKind: convStructured,
+			wantSent: "add_structured_field",
+		},
+		{
+			name:         "unlocked meanwhile",
+			isSetHandler: true,
+			steps: func(h *Handler) {
+				h.conversations.start(1, convMasterPass, nil)
+			},
+			wantSent:    "conversation_unlocked",
+			wantDeleted: true,
+		},
+		{
+			name:         "expired",
+			isSetHandler: true,
+			steps: func(h *Handler) {
+				h.conversations.start(1, convAddSecret, "")
+				expire(&h.conversations, 1)
+			},
+			wantSent:    "conversation_expired",
+			wantDeleted: true,
+		},
+		{
+			name: "command",
+			steps: func(h *Handler) {
+				h.conversations.start(1, convAddSecret, "")
+			},
+			wantNext: true,
+		},
+		{
+			name: "command during setpass",
+			steps: func(h *Handler) {
+				h.conversations.start(1, convPassChange, nil)
+			},
+			wantNext: true,
+			wantKind: convPassChange,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, transport := newTestHandler(t)
+			tt.steps(h)
+
+			// The prompt of the structured flow is sent before the answer.
+			before := len(transport.texts())
+
+			var next bool
+
+			h.ControlSetSecretMiddleware(tt.isSetHandler, func(*chat.Message) { next = true })(newTestMessage("Card"))
+
+			if next != tt.wantNext {
+				t.Errorf("next called = %v, want %v", next, tt.wantNext)
+			}
+
+			if conv, _ := h.conversations.current(1); conv.Kind != tt.wantKind {
+				t.Errorf("kind = %v, want %v", conv.Kind, tt.wantKind)
+			}
+
+			texts := transport.texts()[before:]
+			if tt.wantSent == "" && len(texts) > 0 {
+				t.Errorf("sent %q, want nothing", texts)
+			}
+
+			if tt.wantSent != "" && (len(texts) != 1 || !hasKeyText(h, texts[0], tt.wantSent)) {
+				t.Errorf("sent %q, want %s", texts, tt.wantSent)
+			}
+
+			transport.mx.Lock()
+			deleted := len(transport.deleted) > 0
+			transport.mx.Unlock()
+
+			if deleted != tt.wantDeleted {
+				t.Errorf("answer deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+// hasKeyText reports whether the text is the one of the locale key, the
+// formatted texts are matched by the text before the first argument.
+func hasKeyText(h *Handler, text, key string) bool {
+	want := h.Locales.Get("", key)
+	if i := strings.Index(want, "{{"); i >= 0 {
+		want = want[:i]
+	}
+
+	return strings.HasPrefix(text, want)
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// deniedTextLength is the length of the denied message shown to the admins.
+const deniedTextLength = 64
+
+// deny answers the chat out of the allowed list by the unauthorized policy:
+// the reply or the silence, the notification of the admins about the first
+// message and the block after the limit.
+func (h *Handler) deny(msg *chat.Message) {
+	policy := h.Config.Unauthorized
+
+	value, _ := h.denials.LoadOrStore(msg.Chat.ID, new(int32))
+	count := int(atomic.AddInt32(value.(*int32), 1))
+
+	log.Info("🚫 Access denied", "chat_id", msg.Chat.ID, "count", count)
+
+	if !policy.Silent {
+		h.sendMessage(msg, "Access forbidden")
+	}
+
+	args := localizator.Args{
+		"ChatID": msg.Chat.ID,
+		"Name":   html.EscapeString(senderName(msg)),
+		"Text":   html.EscapeString(truncate(msg.Text, deniedTextLength)),
+		"Count":  count,
+	}
+
+	if policy.NotifyAdmins && count == 1 {
+		h.notifyAdmins(msg.Chat.ID, h.Locales.Format("en", "deny_notify", args))
+	}
+
+	if policy.BlockAfter <= 0 || count != policy.BlockAfter {
+		return
+	}
+
+	h.writeAudit(audit.Event{Action: audit.ActionBlock, Target: msg.Chat.ID, Details: strconv.Itoa(count)})
+	h.denials.Delete(msg.Chat.ID)
+
+	if policy.NotifyAdmins {
+		h.notifyAdmins(msg.Chat.ID, h.Locales.Format("en", "deny_blocked_notify", args))
+	}
+}
+
+// isBlocked reports whether the messages of the chat are dropped, the admins
+// are never blocked.
+func (h *Handler) isBlocked(chatID int64) bool {
+	return h.Audit.Blocked(chatID) && !h.isAdmin(chatID)
+}
+
+// Unblock lets the blocked chat in again, the chat still needs the allowed
+// list.
+func (h *Handler) Unblock(msg *chat.Message) {
+	chatID, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(msg.Text, "/unblock")), 10, 64)
+	if err != nil {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "unblock_usage"))
+
+		return
+	}
+
+	if !h.Audit.Blocked(chatID) {
+		h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "unblock_not_blocked", localizator.Args{"ChatID": chatID}))
+
+		return
+	}
+
+	h.recordAuditEvent(msg, audit.Event{Action: audit.ActionUnblock, Target: chatID})
+	h.denials.Delete(chatID)
+
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "unblock_done", localizator.Args{"ChatID": chatID}))
+}
+
+// truncate cuts the text to the runes of the limit.
+func truncate(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+
+	return string([]rune(text)[:limit]) + "…"
+}
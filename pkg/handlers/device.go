@@ -0,0 +1,145 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/devices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Pair completes the pairing of the chat with the code issued by
+// "secretable pair". The message is deleted right away.
+func (h *Handler) Pair(msg *chat.Message) {
+	h.deleteMessage(msg)
+
+	locale := msg.Sender.LanguageCode
+
+	if h.Devices == nil {
+		h.sendMessage(msg, h.Locales.Get(locale, "device_pairing_disabled"))
+
+		return
+	}
+
+	// The wrong codes count as the failures of the chat, as the ones of
+	// the second factor.
+	if h.throttled(msg) {
+		return
+	}
+
+	code := strings.TrimSpace(strings.TrimPrefix(msg.Text, "/pair"))
+
+	err := h.Devices.Pair(msg.Chat.ID, code)
+
+	switch {
+	case err == nil:
+		h.recordAudit(msg, audit.ActionDevice, "", "pair:accepted")
+		h.sendMessage(msg, h.Locales.Get(locale, "device_paired"))
+	case errors.Is(err, devices.ErrExpired):
+		h.recordAudit(msg, audit.ActionDevice, "", "pair:expired")
+		h.sendMessage(msg, h.Locales.Get(locale, "device_pair_expired"))
+	case errors.Is(err, devices.ErrNotIssued):
+		h.recordAudit(msg, audit.ActionDevice, "", "pair:rejected")
+		h.decryptFailed(msg.Chat.ID, "pair")
+		h.sendMessage(msg, h.Locales.Get(locale, "device_pair_failed"))
+	default:
+		h.logger(msg).Error("Pair device: " + err.Error())
+		h.sendError(msg, "device_unable_pair")
+	}
+}
+
+// DeviceMiddleware holds the protected commands until the pairing code of the
+// chat is sent, the code is received by the query endpoint.
+func (h *Handler) DeviceMiddleware(endpoint string, isQuery bool, next func(m *chat.Message)) func(m *chat.Message) {
+	protected := h.Devices != nil && h.isDeviceProtected(endpoint)
+
+	return func(msg *chat.Message) {
+		pending, ok := h.devicestates.Load(msg.Chat.ID)
+		h.devicestates.Delete(msg.Chat.ID)
+
+		if isQuery && ok {
+			h.confirmDevice(msg, pending.(*pendingCommand))
+
+			return
+		}
+
+		if !protected {
+			next(msg)
+
+			return
+		}
+
+		if !h.Devices.Paired(msg.Chat.ID) {
+			h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "device_not_paired"))
+
+			return
+		}
+
+		h.devicestates.Store(msg.Chat.ID, &pendingCommand{Msg: msg, Next: next, At: time.Now()})
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "device_enter_code"))
+	}
+}
+
+func (h *Handler) confirmDevice(msg *chat.Message, pending *pendingCommand) {
+	h.deleteMessage(msg)
+
+	locale := msg.Sender.LanguageCode
+	command := strings.Fields(pending.Msg.Text)[0]
+
+	if time.Since(pending.At) > secondFactorTimeout {
+		h.sendMessage(msg, h.Locales.Get(locale, "device_code_expired"))
+
+		return
+	}
+
+	if h.throttled(msg) {
+		return
+	}
+
+	if !h.Devices.Verify(msg.Chat.ID, strings.TrimSpace(msg.Text)) {
+		h.recordAudit(msg, audit.ActionDevice, "", command+":rejected")
+		h.decryptFailed(msg.Chat.ID, "device")
+		h.sendMessage(msg, h.Locales.Get(locale, "device_wrong_code"))
+
+		return
+	}
+
+	h.recordAudit(msg, audit.ActionDevice, "", command+":accepted")
+
+	// The command runs within the request of the code.
+	h.contexts.Store(pending.Msg, h.context(msg))
+	defer h.contexts.Delete(pending.Msg)
+
+	pending.Next(pending.Msg)
+}
+
+func (h *Handler) isDeviceProtected(endpoint string) bool {
+	commands := h.Config.DevicePairing.Commands
+	if len(commands) == 0 {
+		commands = defaultProtectedCommands
+	}
+
+	for _, command := range commands {
+		if "/"+strings.TrimPrefix(command, "/") == endpoint {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,162 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/providers"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const editPasswordLength = 16
+
+func (h *Handler) Edit(msg *chat.Message) {
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "edit_resp_wrong_index"))
+
+		return
+	}
+
+	index := h.findVisible(msg, secrets, strings.TrimPrefix(msg.Text, "/edit"))
+	if index < 0 {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "edit_resp_wrong_index"))
+
+		return
+	}
+
+	h.startEdit(msg, secrets[index])
+}
+
+// startEdit asks for the new values of the secret, the current description
+// and username are offered along with a freshly generated password.
+func (h *Handler) startEdit(msg *chat.Message, secret providers.SecretsData) {
+	if _, ok := h.fieldsOf(secret.Type); ok {
+		h.startStructuredFlow(msg, secret.Type, audit.SecretKey(secret))
+
+		return
+	}
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		return
+	}
+
+	decSecret, err := decryptSecret(privkey, secret, h.signed(msg))
+	if err != nil {
+		h.logger(msg).Error(err.Error())
+		h.sendFailure(msg, "edit_unable_edit", err)
+
+		return
+	}
+
+	h.conversations.start(msg.Chat.ID, convEdit, audit.SecretKey(secret))
+
+	current := fmt.Sprintf("%s\n%s\n%s",
+		html.EscapeString(decSecret.Description),
+		html.EscapeString(decSecret.Username),
+		html.EscapeString(generatePassword(editPasswordLength)),
+	)
+
+	if decSecret.URL != "" {
+		current += "\n" + html.EscapeString(decSecret.URL)
+	}
+
+	h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "edit_resp_command")+"\n\n<code>"+current+"</code>")
+}
+
+func (h *Handler) queryEditSecret(msg *chat.Message, key string) {
+	secret, ok := h.parseNewSecret(msg, h.masterPass(msg))
+	if !ok {
+		return
+	}
+
+	h.replaceSecret(msg, key, secret)
+}
+
+// replaceSecret replaces the secret with the audit key keeping its type and
+// owner.
+func (h *Handler) replaceSecret(msg *chat.Message, key string, secret providers.SecretsData) {
+	err := h.swapSecret(msg, key, secret)
+	if errors.Is(err, ErrSecretNotFound) {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "edit_secret_not_found"))
+
+		return
+	}
+
+	if err != nil {
+		h.logger(msg).Error("Replace secret: " + err.Error())
+		h.sendFailure(msg, "edit_unable_edit", err)
+
+		return
+	}
+
+	h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "edit_secret_edited"))
+}
+
+// swapSecret stores the secret in place of the secret with the audit key under
+// the same ID and records the edit.
+func (h *Handler) swapSecret(msg *chat.Message, key string, secret providers.SecretsData) error {
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		return errors.Wrap(err, "get secrets")
+	}
+
+	index := findSecret(secrets, key)
+	if index < 0 || !h.isVisible(msg, secrets[index]) {
+		return ErrSecretNotFound
+	}
+
+	if secret.Type == "" {
+		secret.Type = secrets[index].Type
+	}
+
+	secret.ID = secrets[index].StableID()
+	secret.Owner = secrets[index].Owner
+	if secret.Owner == 0 {
+		secret.Owner = msg.Chat.ID
+	}
+
+	signed, err := h.signSecrets(msg, secret)
+	if err != nil {
+		return errors.Wrap(err, "sign secret")
+	}
+
+	secret = signed[0]
+
+	if err = h.storage(msg).UpdateSecret(secret.ID, secret); err != nil {
+		return errors.Wrap(err, "update secret")
+	}
+
+	h.recordAudit(msg, audit.ActionEdit, audit.SecretKey(secret), key)
+
+	return nil
+}
+
+// findSecret returns the index of the secret with the audit key or -1.
+func findSecret(secrets []providers.SecretsData, key string) int {
+	for index, secret := range secrets {
+		if audit.SecretKey(secret) == key {
+			return index
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,204 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"html"
+	"strings"
+	"time"
+
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/imap"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultMailbox       = "INBOX"
+	defaultEmailInterval = 60 // in sec
+	imapTimeout          = 30 * time.Second
+)
+
+// WatchMailbox checks the mailbox of email_ingest for the unseen emails and
+// adds their secrets until the context is done.
+func (h *Handler) WatchMailbox(ctx context.Context) {
+	interval := h.Config.EmailIngest.Interval
+	if interval <= 0 {
+		interval = defaultEmailInterval
+	}
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		h.checkMailbox()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// emailMessage returns the message of the chat the deposits are added for.
+func (h *Handler) emailMessage() (*chat.Message, bool) {
+	chatID := h.Config.EmailIngest.ChatID
+	if !h.isAllowed(chatID) {
+		return nil, false
+	}
+
+	return &chat.Message{
+		Chat:   &chat.Chat{ID: chatID},
+		Sender: &chat.User{ID: chatID},
+		Text:   "[email]",
+	}, true
+}
+
+// checkMailbox adds the secrets of the unseen emails and flags them as seen.
+// The emails are left unseen while the vault is locked or the secret can't be
+// added, so they are added on the next check.
+func (h *Handler) checkMailbox() {
+	conf := h.Config.EmailIngest
+
+	msg, ok := h.emailMessage()
+	if !ok {
+		log.Error("Email ingestion chat isn't allowed", "chat_id", conf.ChatID)
+
+		return
+	}
+
+	if _, err := h.unlock(msg); err != nil {
+		return
+	}
+
+	password, err := h.Config.EmailPassword()
+	if err != nil {
+		log.Error("Read email password: " + err.Error())
+
+		return
+	}
+
+	mailbox := conf.Mailbox
+	if mailbox == "" {
+		mailbox = defaultMailbox
+	}
+
+	client, err := imap.Dial(conf.Address, imapTimeout)
+	if err != nil {
+		log.Error("Connect to IMAP server: "+err.Error(), "address", conf.Address)
+
+		return
+	}
+
+	defer func() {
+		if err := client.Logout(); err != nil {
+			log.Error("Logout from IMAP server: "+err.Error(), "address", conf.Address)
+		}
+	}()
+
+	if err = client.Login(conf.Username, password); err != nil {
+		log.Error("Login to IMAP server: "+err.Error(), "address", conf.Address)
+
+		return
+	}
+
+	if err = client.Select(mailbox); err != nil {
+		log.Error("Select mailbox: "+err.Error(), "mailbox", mailbox)
+
+		return
+	}
+
+	uids, err := client.Unseen()
+	if err != nil {
+		log.Error("Search unseen emails: "+err.Error(), "mailbox", mailbox)
+
+		return
+	}
+
+	for _, uid := range uids {
+		raw, err := client.Fetch(uid)
+		if err != nil {
+			log.Error("Fetch email: "+err.Error(), "uid", uid)
+
+			continue
+		}
+
+		if err = h.ingestEmail(msg, raw, uid); err != nil {
+			log.Error("Keep email unseen: "+err.Error(), "uid", uid)
+
+			continue
+		}
+
+		if err = client.MarkSeen(uid); err != nil {
+			log.Error("Flag email as seen: "+err.Error(), "uid", uid)
+		}
+	}
+}
+
+// ingestEmail adds the secret of the email, the emails which aren't encrypted
+// to the bot and signed by a known key or aren't a secret are dropped. The
+// error is returned if the secret can't be added for now, the email is kept
+// for the next check then.
+func (h *Handler) ingestEmail(msg *chat.Message, raw []byte, uid uint32) error {
+	plaintext, signer, err := h.Ingest.Open(raw)
+	if err != nil {
+		log.Error("Drop email: "+err.Error(), "uid", uid)
+
+		return nil
+	}
+
+	text := strings.TrimSpace(strings.ReplaceAll(string(plaintext), "\r\n", "\n"))
+
+	arr, siteURL, ok := splitSecret(text)
+	if !ok {
+		log.Error("Drop email: fewer than 3 lines", "uid", uid, "signer", signer)
+
+		return nil
+	}
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		return errors.Wrap(err, "unlock")
+	}
+
+	secret, err := encryptSecret(privkey, arr[0], arr[1], arr[2])
+	if err != nil {
+		return errors.Wrap(err, "encrypt secret")
+	}
+
+	secret.URL = siteURL
+	secret.Owner = msg.Chat.ID
+
+	if secret, err = h.addSecret(msg, secret); err != nil {
+		return errors.Wrap(err, "add secret")
+	}
+
+	h.recordAudit(msg, audit.ActionAdd, audit.SecretKey(secret), "email:"+signer)
+
+	_, err = h.Chat.SendMessage(msg.Chat.ID, h.Locales.Format("en", "email_added", localizator.Args{
+		"ID":     secret.StableID(),
+		"Signer": html.EscapeString(signer),
+	}), chat.Options{Notify: true})
+	if err != nil {
+		log.Error("Unable to send a message to the chat: "+err.Error(), "chat_id", msg.Chat.ID)
+	}
+
+	return nil
+}
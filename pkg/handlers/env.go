@@ -0,0 +1,117 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/export"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"strings"
+)
+
+// Env sends the secrets tagged with the tag as a .env file.
+func (h *Handler) Env(msg *chat.Message) {
+	tag := strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(msg.Text, "/env")), "#")
+	if tag == "" || strings.ContainsAny(tag, " \n") {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "env_wrong_format"))
+
+		return
+	}
+
+	decSecrets, keys, leftOut, err := h.decryptTagged(msg, tag)
+	if err != nil {
+		h.logger(msg).Error("Decrypt tagged secrets: "+err.Error(), "tag", tag)
+		h.sendFailure(msg, "env_unable_export", err)
+
+		return
+	}
+
+	if len(leftOut) > 0 {
+		h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "env_left_out", localizator.Args{
+			"IDs": strings.Join(leftOut, ", "),
+		}))
+	}
+
+	if len(decSecrets) == 0 {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "env_not_found"))
+
+		return
+	}
+
+	if !h.allowReveal(msg, len(keys)) {
+		return
+	}
+
+	doc, skipped := export.Env(decSecrets)
+
+	if len(doc) > 0 {
+		h.sendDocument(msg, doc, tag+".env")
+
+		for _, key := range keys {
+			h.recordAudit(msg, audit.ActionReveal, key, "env")
+		}
+	}
+
+	if len(skipped) > 0 {
+		h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "env_skipped", localizator.Args{
+			"Names": html.EscapeString(strings.Join(skipped, "\n")),
+		}))
+	}
+}
+
+// decryptTagged decrypts the visible secrets tagged with the tag and returns
+// them along with their audit keys. The secrets needing an approval or a
+// confirmation are left out, their IDs are returned.
+func (h *Handler) decryptTagged(msg *chat.Message, tag string) ([]providers.SecretsData, []string, []string, error) {
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	filter := h.visibleFilter(msg)
+	filter.Tag = tag
+
+	secrets, err := h.storage(msg).QuerySecrets(filter, 0, 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var (
+		decSecrets []providers.SecretsData
+		keys       []string
+		leftOut    []string
+	)
+
+	for _, secret := range secrets {
+		if h.checkReveal(msg, secret, revealWhole) != nil {
+			leftOut = append(leftOut, secret.StableID())
+
+			continue
+		}
+
+		decSecret, err := decryptSecret(privkey, secret, h.signed(msg))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		decSecrets = append(decSecrets, decSecret)
+		keys = append(keys, audit.SecretKey(secret))
+	}
+
+	return decSecrets, keys, leftOut, nil
+}
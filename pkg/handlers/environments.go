@@ -0,0 +1,191 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/config"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ConfirmButton reveals the secret of an environment with the confirmation,
+// the data is the key or the action along with the key and its arguments.
+var ConfirmButton = chat.Button{Unique: "confirm"}
+
+const (
+	// confirmShare and confirmLink are the actions of the confirm button
+	// sharing the secret or creating its link instead of the reveal.
+	confirmShare = "share"
+	confirmLink  = "link"
+)
+
+// defaultEnvironments label the secrets tagged #dev, #stage and #prod unless
+// the environments are configured.
+var defaultEnvironments = map[string]config.Environment{
+	"dev":   {Label: "🟢 DEV"},
+	"stage": {Label: "🟡 STAGE"},
+	"prod":  {Label: "🔴 PROD"},
+}
+
+// environmentOf returns the environment of the first tag of the secret which
+// names one, the label is the uppercase name if not set.
+func (h *Handler) environmentOf(secret providers.SecretsData) (config.Environment, bool) {
+	environments := h.Config.Environments
+	if environments == nil {
+		environments = defaultEnvironments
+	}
+
+	for _, tag := range secret.Tags() {
+		for name, env := range environments {
+			if strings.EqualFold(name, tag) {
+				if env.Label == "" {
+					env.Label = strings.ToUpper(name)
+				}
+
+				return env, true
+			}
+		}
+	}
+
+	return config.Environment{}, false
+}
+
+// labelEnvironment puts the label of the environment in front of the
+// description of the decrypted secret.
+func (h *Handler) labelEnvironment(secret providers.SecretsData) providers.SecretsData {
+	if env, ok := h.environmentOf(secret); ok {
+		secret.Description = env.Label + " " + secret.Description
+	}
+
+	return secret
+}
+
+// needsConfirmation reports whether the secret is revealed only after the
+// confirm button is pressed.
+func (h *Handler) needsConfirmation(secret providers.SecretsData) bool {
+	env, ok := h.environmentOf(secret)
+
+	return ok && env.Confirm
+}
+
+// requestConfirmation sends the ID and the environment of the secret along
+// with the button revealing it.
+func (h *Handler) requestConfirmation(msg *chat.Message, secret providers.SecretsData) {
+	h.askConfirmation(msg, secret, audit.SecretKey(secret))
+}
+
+// askConfirmation sends the ID and the environment of the secret along with
+// the confirm button of the data.
+func (h *Handler) askConfirmation(msg *chat.Message, secret providers.SecretsData, data string) {
+	locale := msg.Sender.LanguageCode
+	env, _ := h.environmentOf(secret)
+
+	btn := ConfirmButton
+	btn.Text = h.Locales.Format(locale, "environment_confirm_button", localizator.Args{"Label": env.Label})
+	btn.Data = data
+
+	h.sendMessageWithOptions(msg, h.Locales.Format(locale, "environment_confirm", localizator.Args{
+		"ID":    secret.StableID(),
+		"Label": html.EscapeString(env.Label),
+	}), chat.Options{Buttons: [][]chat.Button{{btn}}})
+}
+
+// ConfirmCallback reveals the secret of the confirmation, the archived
+// secrets of the default vault as well, or shares it or creates its link.
+func (h *Handler) ConfirmCallback(msg *chat.Message, c *chat.Callback) {
+	locale := msg.Sender.LanguageCode
+
+	args := strings.Split(c.Data, "|")
+	key := args[0]
+
+	if args[0] == confirmShare || args[0] == confirmLink {
+		if len(args) < 3 || !h.hasRole(msg, RoleMember) {
+			return
+		}
+
+		key = args[1]
+	}
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		h.sendMessage(msg, h.Locales.Get(locale, "reveal_unlock_first"))
+
+		return
+	}
+
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		h.logger(msg).Error("Get secrets: " + err.Error())
+
+		return
+	}
+
+	index := findSecret(secrets, key)
+	archived := false
+
+	if index < 0 && key == c.Data && h.ArchiveProvider != nil && h.vaultName(msg) == DefaultVault {
+		if secrets, err = h.storageOf(msg, h.ArchiveProvider).GetSecrets(); err != nil {
+			h.logger(msg).Error("Get archived secrets: " + err.Error())
+
+			return
+		}
+
+		index, archived = findSecret(secrets, key), true
+	}
+
+	if index < 0 || !h.isVisible(msg, secrets[index]) {
+		h.sendMessage(msg, h.Locales.Get(locale, "edit_secret_not_found"))
+
+		return
+	}
+
+	switch args[0] {
+	case confirmShare:
+		h.confirmShare(msg, privkey, secrets[index], args[2:])
+
+		return
+	case confirmLink:
+		h.confirmLink(msg, privkey, secrets[index], args[2])
+
+		return
+	}
+
+	if archived && errors.Is(h.checkReveal(msg, secrets[index], revealConfirmed), ErrNeedsApproval) {
+		h.sendMessage(msg, h.Locales.Format(locale, "archive_needs_approval", localizator.Args{"ID": secrets[index].StableID()}))
+
+		return
+	}
+
+	if h.gateReveal(msg, secrets[index], revealConfirmed) != nil {
+		return
+	}
+
+	decSecret, err := decryptSecret(privkey, secrets[index], h.signed(msg))
+	if err != nil {
+		h.logger(msg).Error(err.Error())
+		h.sendFailure(msg, "environment_unable_reveal", err)
+
+		return
+	}
+
+	h.recordAudit(msg, audit.ActionReveal, key, "confirmed")
+	h.sendSecret(msg, secrets[index].StableID(), decSecret, key, "")
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+)
+
+// externalChangeNotifier is the storage which reports the secrets changed
+// outside of the bot.
+type externalChangeNotifier interface {
+	OnExternalChange(func(providers.ExternalChange))
+}
+
+// WatchExternalChanges alerts the admins of the secrets changed directly in
+// the spreadsheets of the vaults, such edits of the ciphertext usually mean a
+// corrupted secret.
+func (h *Handler) WatchExternalChanges() {
+	for _, name := range h.vaultNames() {
+		notifier, ok := h.vaultStorage(name).(externalChangeNotifier)
+		if !ok {
+			continue
+		}
+
+		vault := name
+
+		notifier.OnExternalChange(func(change providers.ExternalChange) {
+			log.Info("✏️ Secrets changed in the spreadsheet", "vault", vault,
+				"modified", change.Modified, "added", change.Added, "removed", change.Removed)
+
+			go h.notifyAdmins(0, h.Locales.Format("en", "external_change", localizator.Args{
+				"Vault":    html.EscapeString(vault),
+				"Modified": change.Modified,
+				"Added":    change.Added,
+				"Removed":  change.Removed,
+			}))
+		})
+	}
+}
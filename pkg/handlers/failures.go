@@ -0,0 +1,127 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// failureWindow and failureLimit throttle the decryption with the
+	// values of a chat: after the limit of failures in the window the chat
+	// waits for the rest of the window.
+	failureWindow = 15 * time.Minute
+	failureLimit  = 5
+)
+
+// failureCount is the decrypt failures of a chat.
+type failureCount struct {
+	ChatID int64
+	// Count is the failures of the current window, Total of all the time.
+	Count int
+	Total int
+	Since time.Time
+	Last  time.Time
+}
+
+// decryptFailed counts the failed decryption of the operation, e.g. a wrong
+// master password, in the chat. The admins are notified when the chat reaches
+// the limit.
+func (h *Handler) decryptFailed(chatID int64, operation string) {
+	now := time.Now()
+
+	h.failuresmx.Lock()
+
+	if h.failures == nil {
+		h.failures = make(map[int64]*failureCount)
+	}
+
+	f, ok := h.failures[chatID]
+	if !ok {
+		f = &failureCount{ChatID: chatID}
+		h.failures[chatID] = f
+	}
+
+	if now.Sub(f.Since) > failureWindow {
+		f.Count, f.Since = 0, now
+	}
+
+	f.Count++
+	f.Total++
+	f.Last = now
+	count := f.Count
+
+	h.failuresmx.Unlock()
+
+	log.Info("🧯 Decrypt failure", "chat_id", chatID, "operation", operation, "count", count)
+
+	if count != failureLimit {
+		return
+	}
+
+	h.writeAudit(audit.Event{ChatID: chatID, Action: audit.ActionDecryptFailure, Details: operation + " " + strconv.Itoa(count)})
+	h.notifyAdmins(0, h.Locales.Format("en", "decrypt_failures_notify", localizator.Args{
+		"ChatID": chatID, "Count": count, "Operation": operation, "Minutes": int(failureWindow.Minutes()),
+	}))
+}
+
+// throttled reports whether the chat has reached the limit of the failures,
+// the chat is told when it may try again.
+func (h *Handler) throttled(msg *chat.Message) bool {
+	h.failuresmx.Lock()
+	f, ok := h.failures[msg.Chat.ID]
+	blocked := ok && f.Count >= failureLimit && time.Since(f.Since) <= failureWindow
+
+	var wait time.Duration
+	if blocked {
+		wait = failureWindow - time.Since(f.Since)
+	}
+
+	h.failuresmx.Unlock()
+
+	if blocked {
+		h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "decrypt_throttled", localizator.Args{
+			"Minutes": int(wait.Minutes()) + 1,
+		}))
+	}
+
+	return blocked
+}
+
+// failureCounts returns the failures of the chats, the latest first.
+func (h *Handler) failureCounts() []failureCount {
+	h.failuresmx.Lock()
+	defer h.failuresmx.Unlock()
+
+	counts := make([]failureCount, 0, len(h.failures))
+	for _, f := range h.failures {
+		c := *f
+		if time.Since(c.Since) > failureWindow {
+			c.Count = 0
+		}
+
+		counts = append(counts, c)
+	}
+
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Last.After(counts[j].Last) })
+
+	return counts
+}
@@ -15,130 +15,297 @@
 package handlers
 
 import (
-	"crypto/rand"
 	"fmt"
 	"html"
-	"math/big"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
 	"secretable/pkg/config"
-	"secretable/pkg/crypto"
+	"secretable/pkg/devices"
+	"secretable/pkg/domains"
+	"secretable/pkg/ingest"
+	"secretable/pkg/keyslots"
 	"secretable/pkg/localizator"
-	"secretable/pkg/log"
+	"secretable/pkg/passwords"
 	"secretable/pkg/providers"
+	"secretable/pkg/slack"
+	"secretable/pkg/version"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
-	"github.com/mr-tron/base58/base58"
-	tb "gopkg.in/tucnak/telebot.v2"
+	"github.com/pkg/errors"
 )
 
 const (
 	numbQueryColumns = 3
-
-	genchars = "abcdefghijklmnopqrstuvwxyz" +
-		"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
-		"0123456789" +
-		` !"#$%&'()*+,-./:;<=>?@[\]^_{|}~` + "`"
+	numbRecent       = 10
 
 	saltLength = 16
 )
 
 type Handler struct {
-	Bot            *tb.Bot
+	// Chat is the transport of the messenger the bot serves.
+	Chat           chat.Transport
 	TablesProvider providers.StorageProvider
 	Locales        *localizator.Localizator
 	Config         *config.Config
-
-	mastePass string
-	setstates sync.Map
-
-	waitmpstates sync.Map
+	Audit          *audit.Log
+	// Devices are the chat pairings, nil if the pairing is disabled.
+	Devices *devices.Registry
+	// Slack is the client of the Slack app, nil if the app is disabled.
+	Slack *slack.Client
+	// Ingest opens the emails of email_ingest, nil if the ingestion is
+	// disabled.
+	Ingest *ingest.Keys
+	// Vaults are the storages of the named vaults the chats switch to with
+	// /vault, TablesProvider is the default one. The webhook and the
+	// rotation reminders read only the default vault.
+	Vaults map[string]providers.StorageProvider
+	// ArchiveProvider keeps the secrets of the default vault moved out by the
+	// archive policy, nil if the archiving is disabled.
+	ArchiveProvider providers.StorageProvider
+
+	// Unlockers open the key slots other than the password, AutoUnlock opens
+	// the vault with them on start.
+	Unlockers []keyslots.Unlocker
+
+	// masterPasses holds the master passwords of the vaults unlocked by the
+	// chats by the vault name, every vault is unlocked on its own. The
+	// handlers read it without a lock, see vaultPasses.
+	masterPasses atomic.Value
+
+	// autounlocked is set while the vault is opened by the Unlockers.
+	autounlocked int32
+
+	// conversations keeps the questions the chats are expected to answer.
+	conversations conversations
+
+	panicstates  sync.Map
+	factorstates sync.Map
+	devicestates sync.Map
+	bulkstates   sync.Map
+	links        sync.Map
+
+	// groups caches the membership of the users in the allowed groups.
+	groups   map[groupKey]groupMembership
+	groupsmx sync.Mutex
+
+	// approvals keeps the pending requests of the restricted secrets.
+	approvals sync.Map
+
+	// elevations keeps the end of the /sudo elevation of the users,
+	// sudorequests the elevations waiting for an admin.
+	elevations   sync.Map
+	sudorequests sync.Map
+
+	// lastseen keeps the latest message of the chats and clients the
+	// language of the users, see AnomalyMiddleware.
+	lastseen sync.Map
+	clients  sync.Map
+
+	// invitesmx makes the redeeming of an invite code one step.
+	invitesmx sync.Mutex
+
+	// denials counts the denied messages of the chats out of the allowed list.
+	denials sync.Map
+
+	// activevaults keeps the name of the vault chosen by the chat.
+	activevaults sync.Map
+
+	// totpsteps keeps the last accepted TOTP step of the chats.
+	totpsteps sync.Map
+
+	// keymx guards the salt and the encrypted key while the key is rewrapped.
+	keymx sync.RWMutex
+
+	// signmx makes the signing of the secrets stored before the MACs one
+	// step, see signVault. signedVaults keeps the names of the vaults whose
+	// keys have the signed marker.
+	signmx       sync.Mutex
+	signedVaults sync.Map
+
+	// contexts keeps the request context of the messages being handled.
+	contexts sync.Map
+
+	// failures counts the decrypt failures of the chats.
+	failures   map[int64]*failureCount
+	failuresmx sync.Mutex
+
+	session   unlockSession
+	sessionmx sync.RWMutex
+
+	maintenance   string
+	maintenancemx sync.RWMutex
+
+	// latest keeps the latest release found by the update checks.
+	latest   version.Release
+	latestmx sync.RWMutex
 }
 
-func (h *Handler) Delete(msg *tb.Message) {
-	index, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(msg.Text, "/delete")))
+func (h *Handler) Delete(msg *chat.Message) {
+	id := strings.TrimSpace(strings.TrimPrefix(msg.Text, "/delete"))
+
+	secrets, err := h.storage(msg).GetSecrets()
 	if err != nil {
 		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "delete_resp_wrong_index"))
 
 		return
 	}
 
-	err = h.TablesProvider.DeleteSecret(index - 1)
+	index := h.findVisible(msg, secrets, id)
+	if index < 0 {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "delete_resp_wrong_index"))
+
+		return
+	}
+
+	// The secret is deleted by its ID, a sync since the read doesn't move
+	// another row under the position.
+	err = h.storage(msg).DeleteSecrets([]string{secrets[index].StableID()})
 
 	if err != nil {
-		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "delete_unable_delete"))
+		h.logger(msg).Error("Delete secret: " + err.Error())
+		h.sendFailure(msg, "delete_unable_delete", err)
 
 		return
 	}
 
+	h.recordAudit(msg, audit.ActionDelete, audit.SecretKey(secrets[index]), secrets[index].StableID())
+
 	h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "delete_secret_deleted"))
 }
 
-func (h *Handler) Generate(msg *tb.Message) {
+func (h *Handler) Generate(msg *chat.Message) {
 	lengthStr := strings.TrimSpace(strings.TrimPrefix(msg.Text, "/generate"))
 
+	if args := strings.Fields(lengthStr); len(args) > 0 && args[0] == "preset" {
+		h.generatePreset(msg, args[1:])
+
+		return
+	}
+
 	lengthInt, _ := strconv.Atoi(lengthStr)
 	if lengthInt <= 0 || lengthInt > 128 {
 		lengthInt = 16
 	}
 
-	chars := []rune(genchars)
+	h.sendMessage(msg, fmt.Sprintf("<code>%v</code>", html.EscapeString(generatePassword(lengthInt))))
+}
+
+func (h *Handler) generatePreset(msg *chat.Message, args []string) {
+	presets := h.presets()
+
+	preset, ok := passwords.Preset{}, false
+	if len(args) == 1 {
+		preset, ok = presets[args[0]]
+	}
+
+	if !ok {
+		names := make([]string, 0, len(presets))
+		for name := range presets {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
 
-	var bld strings.Builder
+		h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "generate_unknown_preset", localizator.Args{
+			"Presets": strings.Join(names, ", "),
+		}))
 
-	for i := 0; i < lengthInt; i++ {
-		nBig, _ := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
-		bld.WriteRune(chars[int(nBig.Int64())])
+		return
 	}
-	h.sendMessage(msg, fmt.Sprintf("<code>%v</code>", html.EscapeString(bld.String())))
+
+	password, err := passwords.GeneratePreset(preset)
+	if err != nil {
+		h.logger(msg).Error("Generate password by preset " + args[0] + ": " + err.Error())
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "generate_invalid_preset"))
+
+		return
+	}
+
+	h.sendMessage(msg, fmt.Sprintf("<code>%v</code>", html.EscapeString(password)))
 }
 
-func (h *Handler) ID(m *tb.Message) {
+// presets returns the built-in presets merged with the presets from config.
+func (h *Handler) presets() map[string]passwords.Preset {
+	presets := make(map[string]passwords.Preset, len(passwords.Presets)+len(h.Config.PasswordPresets))
+
+	for name, p := range passwords.Presets {
+		presets[name] = p
+	}
+
+	for name, p := range h.Config.PasswordPresets {
+		presets[name] = p
+	}
+
+	return presets
+}
+
+func generatePassword(length int) string {
+	return passwords.Generate(length, passwords.DefaultCharset)
+}
+
+func (h *Handler) ID(m *chat.Message) {
 	h.sendMessage(m, fmt.Sprintf("<code>%v</code>", m.Chat.ID))
 }
 
-func (h *Handler) Query(msg *tb.Message) {
-	privkey, err := getPrivkey(h.TablesProvider, h.Config.Salt, h.mastePass)
+func (h *Handler) Query(msg *chat.Message) {
+	privkey, err := h.unlock(msg)
 	if err != nil {
 		return
 	}
 
-	secrets, err := h.TablesProvider.GetSecrets()
+	secrets, err := h.querySecrets(msg, strings.ToLower(msg.Text))
 	if err != nil {
+		h.logger(msg).Error("Query secrets: " + err.Error())
+
 		return
 	}
 
-	query := strings.ToLower(msg.Text)
-	exists := false
+	var (
+		exists bool
+		broken []providers.SecretsData
+	)
+
+	for _, secret := range secrets {
+		exists = true
+
+		if !verifySecret(privkey, secret, h.signed(msg)) {
+			h.reportTampered(msg, secret)
 
-	for index, secret := range secrets {
-		if !strings.Contains(strings.ToLower(secret.Description), query) {
 			continue
 		}
 
-		username, _ := base58.Decode(secret.Username)
-		password, _ := base58.Decode(secret.Secret)
+		// The limit is checked before the secret is decrypted, reaching it
+		// ends the search but the broken rows found so far are still listed.
+		err := h.gateReveal(msg, secret, revealDisplayed)
+		if errors.Is(err, ErrRevealLimit) {
+			break
+		}
 
-		decUsername, err := crypto.DecryptWithPriv(privkey, username)
 		if err != nil {
-			log.Error("Decrypt username with private key: " + err.Error())
-
-			break
+			continue
 		}
 
-		decPassword, err := crypto.DecryptWithPriv(privkey, password)
+		// The rows which don't decrypt are listed by /broken, the search goes
+		// on so they don't hide the rest.
+		decSecret, err := decryptSecret(privkey, secret, h.signed(msg))
 		if err != nil {
-			log.Error("Decrypt password with private key: " + err.Error())
+			h.logger(msg).Error("Decrypt secret: "+err.Error(), "id", secret.StableID())
 
-			break
-		}
+			broken = append(broken, secret)
 
-		secret.Username = string(decUsername)
-		secret.Secret = string(decPassword)
+			continue
+		}
 
-		exists = true
+		h.recordAudit(msg, audit.ActionReveal, audit.SecretKey(secret), "")
+		h.sendSecret(msg, secret.StableID(), decSecret, audit.SecretKey(secret), "")
+	}
 
-		h.sendMessage(msg, makeQueryResponse(index+1, secret))
+	if len(broken) > 0 {
+		h.sendBrokenNotice(msg, broken)
 	}
 
 	if !exists {
@@ -146,66 +313,147 @@ func (h *Handler) Query(msg *tb.Message) {
 	}
 }
 
-func (h *Handler) ResetPass(msg *tb.Message) {
-	data := strings.TrimSpace(strings.TrimPrefix(msg.Text, "/setpass"))
+// querySecrets returns the visible secrets matching the query, see matchQuery.
+// The description is filtered by the storage.
+func (h *Handler) querySecrets(msg *chat.Message, query string) ([]providers.SecretsData, error) {
+	if domains.IsDomain(query) {
+		var found []providers.SecretsData
 
-	if data == "" {
-		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "setpass_empty_pass"))
+		err := h.storage(msg).Secrets(func(_ int, secret providers.SecretsData) bool {
+			if h.isVisible(msg, secret) && domains.Matches(secret, query) {
+				found = append(found, secret)
+			}
 
-		return
+			return true
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "get secrets")
+		}
+
+		if len(found) > 0 {
+			return found, nil
+		}
 	}
 
-	privkeyBytes, ok, err := getPrivkeyAsBytes(h.TablesProvider, h.Config.Salt, h.mastePass)
-	if err != nil || !ok {
-		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "setpass_unable_set"))
+	filter := h.visibleFilter(msg)
+	filter.Description = query
 
-		return
+	secrets, err := h.storage(msg).QuerySecrets(filter, 0, 0)
+
+	return secrets, errors.Wrap(err, "query secrets")
+}
+
+// matchQuery returns the indexes of the visible secrets matching the query. A
+// URL or a domain matches the secrets of its registrable domain, e.g.
+// accounts.google.com finds google.com, the description is searched if none
+// matches.
+func (h *Handler) matchQuery(msg *chat.Message, secrets []providers.SecretsData, query string) []int {
+	var indexes []int
+
+	if domains.IsDomain(query) {
+		for index, secret := range secrets {
+			if h.isVisible(msg, secret) && domains.Matches(secret, query) {
+				indexes = append(indexes, index)
+			}
+		}
+
+		if len(indexes) > 0 {
+			return indexes
+		}
+	}
+
+	for index, secret := range secrets {
+		if h.isVisible(msg, secret) && strings.Contains(strings.ToLower(secret.Description), query) {
+			indexes = append(indexes, index)
+		}
 	}
 
-	b, _ := crypto.MakeRandom(saltLength)
-	oldSalt := h.Config.Salt
-	h.Config.Salt = base58.Encode(b)
+	return indexes
+}
 
-	err = config.UpdateFile(h.Config)
-	if err != nil {
-		log.Error("Update config: " + err.Error())
+func (h *Handler) Set(msg *chat.Message) {
+	secretType := strings.TrimSpace(strings.TrimPrefix(msg.Text, "/add"))
 
-		h.Config.Salt = oldSalt
-		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "setpass_unable_set"))
+	if secretType == templatePicker && len(h.Config.SecretTemplates) > 0 {
+		h.sendTemplates(msg)
 
 		return
 	}
 
-	nonce, _ := crypto.MakeRandom(crypto.NonceSize)
+	if _, ok := h.fieldsOf(secretType); ok {
+		h.startStructuredFlow(msg, secretType, "")
 
-	cypher, err := crypto.EncryptWithPhrase([]byte(data), []byte(h.Config.Salt), nonce, privkeyBytes)
-	if err != nil {
-		log.Error("Encrypt with password: " + err.Error())
-		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "setpass_unable_set"))
+		return
+	}
+
+	promptKey, ok := secretTypes[secretType]
+	if !ok {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "add_unknown_type")+h.templatesHint(msg.Sender.LanguageCode))
 
 		return
 	}
 
-	cypher = append(nonce, cypher...)
+	h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, promptKey))
+	h.conversations.start(msg.Chat.ID, convAddSecret, secretType)
+}
 
-	if err = h.TablesProvider.SetKey(base58.Encode(cypher)); err != nil {
-		log.Error("Store encrypted key to table: " + err.Error())
-		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "setpass_unable_set"))
+func (h *Handler) Recent(msg *chat.Message) {
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		return
+	}
 
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
 		return
 	}
 
-	h.mastePass = data
-	h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "setpasspass_setted"))
-}
+	indexes := make(map[string]int, len(secrets))
+	for index, secret := range secrets {
+		indexes[audit.SecretKey(secret)] = index
+	}
 
-func (h *Handler) Set(msg *tb.Message) {
-	h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "add_resp_command"))
-	h.setstates.Store(msg.Chat.ID, true)
-}
+	exists := false
+
+	for _, key := range h.Audit.Recent(msg.Chat.ID, numbRecent) {
+		index, ok := indexes[key]
+		if !ok || !h.isVisible(msg, secrets[index]) {
+			continue
+		}
+
+		exists = true
+
+		if !verifySecret(privkey, secrets[index], h.signed(msg)) {
+			h.reportTampered(msg, secrets[index])
 
-func (h *Handler) MakeStart(infoMsg string) func(m *tb.Message) {
-	return func(m *tb.Message) {
-		h.sendMessageWithoutCleanup(m, infoMsg)
+			continue
+		}
+
+		// The limit is checked before the secret is decrypted.
+		err := h.gateReveal(msg, secrets[index], revealDisplayed)
+		if errors.Is(err, ErrRevealLimit) {
+			break
+		}
+
+		if err != nil {
+			continue
+		}
+
+		decSecret, err := decryptSecret(privkey, secrets[index], h.signed(msg))
+		if err != nil {
+			h.logger(msg).Error(err.Error())
+
+			continue
+		}
+
+		usage := h.Audit.Usage(key)
+		h.recordAudit(msg, audit.ActionReveal, key, "")
+		h.sendSecret(msg, secrets[index].StableID(), decSecret, key, "\n"+h.Locales.Format(msg.Sender.LanguageCode, "recent_usage",
+			localizator.Args{"Count": usage.Count, "LastAccess": usage.LastAccess},
+		))
+	}
+
+	if !exists {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "recent_no_secrets"))
 	}
 }
@@ -15,50 +15,108 @@
 package handlers
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/x509"
-	"fmt"
 	"html"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/config"
 	"secretable/pkg/crypto"
+	"secretable/pkg/keyslots"
+	"secretable/pkg/localizator"
 	"secretable/pkg/log"
 	"secretable/pkg/providers"
+	"secretable/pkg/tracing"
+	"sync/atomic"
 	"time"
 
 	"github.com/mr-tron/base58/base58"
 	"github.com/pkg/errors"
-	tb "gopkg.in/tucnak/telebot.v2"
 )
 
 var (
-	ErrMissingKey    = errors.New("missing private key")
-	ErrInvalidFormat = errors.New("invalid format")
+	ErrMissingKey     = errors.New("missing private key")
+	ErrInvalidFormat  = keyslots.ErrInvalidSlots
+	ErrSecretNotFound = errors.New("secret not found")
+	ErrTampered       = errors.New("secret is modified outside of the bot")
 )
 
-func (h *Handler) sendMessage(m *tb.Message, msg string) {
-	resp, err := h.Bot.Send(m.Chat, msg, tb.Silent, tb.ModeHTML)
+// reasons are the messages explaining the errors of the storages and the
+// crypto, in the order they are checked.
+var reasons = []struct {
+	err error
+	key string
+}{
+	{providers.ErrNotFound, "error_not_found"},
+	{ErrSecretNotFound, "error_not_found"},
+	{providers.ErrConflict, "error_conflict"},
+	{providers.ErrUnauthorized, "error_unauthorized"},
+	{providers.ErrQuotaExceeded, "error_quota_exceeded"},
+	{crypto.ErrDecrypt, "error_decrypt"},
+}
+
+// send sends to the chat of the message within the traced request.
+func (h *Handler) send(m *chat.Message, text string, opts chat.Options) (*chat.Message, error) {
+	_, span := tracing.Start(h.context(m), "chat.Send")
+	defer span.Finish()
+
+	resp, err := h.Chat.SendMessage(m.Chat.ID, text, opts)
+
+	return resp, span.SetError(err)
+}
+
+func (h *Handler) sendMessage(m *chat.Message, msg string) {
+	resp, err := h.send(m, msg, chat.Options{})
 	if err != nil {
-		log.Error("Unable to send a message to telegram: "+err.Error(), "chat_id", m.Chat.ID, "message", msg)
+		h.logger(m).Error("Unable to send a message to the chat: "+err.Error(), "chat_id", m.Chat.ID, "message", log.Redact(msg))
 
 		return
 	}
 
-	go cleanupMessage(h.Bot, resp, h.Config.CleanupTimeout)
+	go h.cleanupMessage(resp, h.Config.CleanupTimeout)
 }
 
-func (h *Handler) sendMessageWithoutCleanup(m *tb.Message, msg string) {
-	_, err := h.Bot.Send(m.Chat, msg, tb.Silent, tb.ModeHTML)
+func (h *Handler) sendMessageWithOptions(m *chat.Message, msg string, opts chat.Options) {
+	resp, err := h.send(m, msg, opts)
 	if err != nil {
-		log.Error("Unable to send a message to telegram"+err.Error(), "chat_id", m.Chat.ID, "message", msg)
+		h.logger(m).Error("Unable to send a message to the chat: "+err.Error(), "chat_id", m.Chat.ID)
 
 		return
 	}
+
+	go h.cleanupMessage(resp, h.Config.CleanupTimeout)
 }
 
-func (h *Handler) hasAccess(msg *tb.Message) bool {
-	for _, a := range h.Config.AllowedList {
-		if a == msg.Chat.ID {
-			return true
-		}
+func (h *Handler) sendMessageWithoutCleanup(m *chat.Message, msg string) {
+	_, err := h.send(m, msg, chat.Options{})
+	if err != nil {
+		h.logger(m).Error("Unable to send a message to the chat: "+err.Error(), "chat_id", m.Chat.ID, "message", log.Redact(msg))
+
+		return
+	}
+}
+
+func (h *Handler) hasAccess(msg *chat.Message) bool {
+	return h.hasRole(msg, RoleMember)
+}
+
+// hasRole checks the role of the chat, the chats out of the allowed list are
+// answered by the unauthorized policy and the blocked chats are ignored.
+func (h *Handler) hasRole(msg *chat.Message, role Role) bool {
+	if h.isBlocked(msg.Chat.ID) {
+		return false
+	}
+
+	have := h.roleOfMessage(msg)
+	if have >= role {
+		return true
+	}
+
+	if have == RoleAnyone {
+		h.deny(msg)
+
+		return false
 	}
 
 	h.sendMessage(msg, "Access forbidden")
@@ -66,7 +124,18 @@ func (h *Handler) hasAccess(msg *tb.Message) bool {
 	return false
 }
 
-func getPrivkeyAsBytes(tp providers.StorageProvider, salt, masterPass string) ([]byte, bool, error) {
+// getPrivkeyAsBytes unwraps the private key from the key slots with the master
+// password and the unlockers, ok is false if the vault has no key yet.
+func getPrivkeyAsBytes(tp providers.StorageProvider, salt, masterPass string,
+	unlockers ...keyslots.Unlocker) ([]byte, bool, error) {
+	if masterPass != "" {
+		if err := tp.Open(masterPass); err != nil {
+			return nil, false, errors.Wrap(err, "open storage")
+		}
+
+		unlockers = append([]keyslots.Unlocker{keyslots.NewPassword(masterPass, salt)}, unlockers...)
+	}
+
 	k, err := tp.GetKey()
 	if err != nil {
 		return nil, false, errors.Wrap(err, "get key")
@@ -75,28 +144,22 @@ func getPrivkeyAsBytes(tp providers.StorageProvider, salt, masterPass string) ([
 		return nil, false, nil
 	}
 
-	key, err := base58.Decode(k)
+	slots, err := keyslots.Parse(k)
 	if err != nil {
-		return nil, false, errors.Wrap(err, "base58 decode")
-	}
-
-	if len(key) < crypto.NonceSize {
-		return nil, false, ErrInvalidFormat
+		return nil, false, errors.Wrap(err, "parse key slots")
 	}
 
-	nonce := key[:crypto.NonceSize]
-	encprivkey := key[crypto.NonceSize:]
-
-	decPrivkey, err := crypto.DecryptWithPhrase([]byte(masterPass), []byte(salt), nonce, encprivkey)
+	decPrivkey, err := slots.Open(context.Background(), unlockers...)
 	if err != nil {
-		return nil, false, errors.Wrap(err, "decrypt with phrase")
+		return nil, false, err
 	}
 
 	return decPrivkey, true, nil
 }
 
-func getPrivkey(tp providers.StorageProvider, salt, masterPass string) (*ecdsa.PrivateKey, error) {
-	decPrivkey, ok, err := getPrivkeyAsBytes(tp, salt, masterPass)
+func getPrivkey(tp providers.StorageProvider, salt, masterPass string,
+	unlockers ...keyslots.Unlocker) (*ecdsa.PrivateKey, error) {
+	decPrivkey, ok, err := getPrivkeyAsBytes(tp, salt, masterPass, unlockers...)
 	if err != nil {
 		return nil, err
 	}
@@ -113,19 +176,248 @@ func getPrivkey(tp providers.StorageProvider, salt, masterPass string) (*ecdsa.P
 	return privkey.(*ecdsa.PrivateKey), nil
 }
 
-func makeQueryResponse(index int, secret providers.SecretsData) string {
-	return fmt.Sprintf("(%d) <b>%s</b>\n<code>%s</code>\n<code>%s</code>",
-		index,
-		html.EscapeString(secret.Description),
-		html.EscapeString(secret.Username),
-		html.EscapeString(secret.Secret),
-	)
+// Unlock decrypts the private key of the vault with the master password or
+// one of the unlockers of the key slots, the empty password is skipped.
+func Unlock(tp providers.StorageProvider, salt, masterPass string, unlockers ...keyslots.Unlocker) (*ecdsa.PrivateKey, error) {
+	return getPrivkey(tp, salt, masterPass, unlockers...)
+}
+
+// DecryptSecret decrypts the username and the secret of the stored secret,
+// signed is set once the vault is signed, see Log.Signed.
+func DecryptSecret(privkey *ecdsa.PrivateKey, secret providers.SecretsData, signed bool) (providers.SecretsData, error) {
+	return decryptSecret(privkey, secret, signed)
+}
+
+func decryptSecret(privkey *ecdsa.PrivateKey, secret providers.SecretsData, signed bool) (providers.SecretsData, error) {
+	if !verifySecret(privkey, secret, signed) {
+		return secret, ErrTampered
+	}
+
+	username, _ := base58.Decode(secret.Username)
+	password, _ := base58.Decode(secret.Secret)
+
+	decUsername, err := crypto.DecryptWithPriv(privkey, username)
+	if err != nil {
+		return secret, errors.Wrap(err, "decrypt username with private key")
+	}
+
+	decPassword, err := crypto.DecryptWithPriv(privkey, password)
+	if err != nil {
+		return secret, errors.Wrap(err, "decrypt password with private key")
+	}
+
+	secret.Username = string(decUsername)
+	secret.Secret = string(decPassword)
+
+	return secret, nil
+}
+
+func encryptSecret(privkey *ecdsa.PrivateKey, description, username, secret string) (providers.SecretsData, error) {
+	cypher1, err := crypto.EncryptWithPub(&privkey.PublicKey, []byte(username))
+	if err != nil {
+		return providers.SecretsData{}, errors.Wrap(err, "encrypt username with public key")
+	}
+
+	cypher2, err := crypto.EncryptWithPub(&privkey.PublicKey, []byte(secret))
+	if err != nil {
+		return providers.SecretsData{}, errors.Wrap(err, "encrypt password with public key")
+	}
+
+	return providers.SecretsData{
+		Description: description,
+		Username:    base58.Encode(cypher1),
+		Secret:      base58.Encode(cypher2),
+	}, nil
+}
+
+func (h *Handler) recordAudit(m *chat.Message, action, secretKey, details string) {
+	h.recordAuditEvent(m, audit.Event{
+		Action:    action,
+		SecretKey: secretKey,
+		Details:   details,
+	})
+}
+
+func (h *Handler) recordAuditEvent(m *chat.Message, event audit.Event) {
+	event.ChatID = m.Chat.ID
+	event.Username = m.Chat.Username
+
+	h.writeAuditOf(m, event)
+}
+
+func (h *Handler) writeAudit(event audit.Event) {
+	h.writeAuditOf(nil, event)
+}
+
+// writeAuditOf records the event of the message, nil if the event isn't of a
+// chat, and watches it for the bursts and the canaries.
+func (h *Handler) writeAuditOf(m *chat.Message, event audit.Event) {
+	if err := h.Audit.Record(event); err != nil {
+		log.Error("Unable to write the audit log: "+err.Error(), "chat_id", event.ChatID, "action", event.Action)
+	}
+
+	if event.Action == audit.ActionReveal || event.Action == audit.ActionDelete {
+		h.watchBurst(event)
+	}
+
+	h.watchCanary(m, event)
 }
 
-func cleanupMessage(b *tb.Bot, m *tb.Message, cleanupTime int) {
+// isVisible reports whether the chat can see the secret in the current vault
+// mode. Secrets without owner stay visible to every chat in the private mode.
+func (h *Handler) isVisible(m *chat.Message, secret providers.SecretsData) bool {
+	if h.Config.VaultMode != config.VaultModePrivate || secret.Owner == 0 {
+		return true
+	}
+
+	return secret.Owner == m.Chat.ID
+}
+
+// visibleFilter returns the filter of the secrets the chat can see.
+func (h *Handler) visibleFilter(m *chat.Message) providers.SecretsFilter {
+	if h.Config.VaultMode != config.VaultModePrivate {
+		return providers.SecretsFilter{}
+	}
+
+	return providers.SecretsFilter{Owner: m.Chat.ID}
+}
+
+// findVisible returns the position of the secret with the ID if the chat can
+// see it, or -1.
+func (h *Handler) findVisible(m *chat.Message, secrets []providers.SecretsData, id string) int {
+	index := providers.FindByID(secrets, id)
+	if index < 0 || !h.isVisible(m, secrets[index]) {
+		return -1
+	}
+
+	return index
+}
+
+// addSecret stores the signed secret under a new ID.
+func (h *Handler) addSecret(m *chat.Message, secret providers.SecretsData) (providers.SecretsData, error) {
+	secrets, err := h.storage(m).GetSecrets()
+	if err != nil {
+		return secret, errors.Wrap(err, "get secrets")
+	}
+
+	secret.ID = providers.NewID(secrets)
+
+	signed, err := h.signSecrets(m, secret)
+	if err != nil {
+		return secret, errors.Wrap(err, "sign secret")
+	}
+
+	return signed[0], h.storage(m).AddSecret(signed[0])
+}
+
+func (h *Handler) makeQueryResponse(locale, id string, secret providers.SecretsData) string {
+	return h.Locales.Format(locale, "layout_secret", localizator.Args{
+		"ID":          id,
+		"Description": html.EscapeString(secret.Description),
+		"Username":    html.EscapeString(secret.Username),
+		"Secret":      html.EscapeString(secret.Secret),
+		"URL":         html.EscapeString(secret.URL),
+	})
+}
+
+// sendFile sends the file to the chat of the message within the traced
+// request.
+func (h *Handler) sendFile(m *chat.Message, file chat.File) {
+	_, span := tracing.Start(h.context(m), "chat.SendFile")
+	defer span.Finish()
+
+	resp, err := h.Chat.SendFile(m.Chat.ID, file, chat.Options{})
+	if err = span.SetError(err); err != nil {
+		h.logger(m).Error("Unable to send a file to the chat: "+err.Error(), "chat_id", m.Chat.ID)
+
+		return
+	}
+
+	go h.cleanupMessage(resp, h.Config.CleanupTimeout)
+}
+
+func (h *Handler) sendPhoto(m *chat.Message, photo []byte, caption string) {
+	h.sendFile(m, chat.File{Data: photo, Caption: caption, Photo: true})
+}
+
+func (h *Handler) sendDocument(m *chat.Message, data []byte, filename string) {
+	h.sendFile(m, chat.File{Data: data, Name: filename})
+}
+
+func (h *Handler) cleanupMessage(m *chat.Message, cleanupTime int) {
+	atomic.AddInt64(&pendingCleanups, 1)
+	defer atomic.AddInt64(&pendingCleanups, -1)
+
 	time.Sleep(time.Second * time.Duration(cleanupTime))
 
-	if err := b.Delete(m); err != nil {
-		log.Error("Unable to delete a message to telegram: "+err.Error(), "chat_id", m.Chat.ID)
+	if err := h.Chat.DeleteMessage(m.Chat.ID, m.ID); err != nil {
+		log.Error("Unable to delete a message of the chat: "+err.Error(), "chat_id", m.Chat.ID)
 	}
 }
+
+// context returns the request context of the message.
+func (h *Handler) context(m *chat.Message) context.Context {
+	if ctx, ok := h.contexts.Load(m); ok {
+		return ctx.(context.Context)
+	}
+
+	return context.Background()
+}
+
+// storage returns the storage of the active vault of the chat traced within
+// the message request.
+func (h *Handler) storage(m *chat.Message) providers.StorageProvider {
+	return h.storageOf(m, h.vault(m))
+}
+
+// storageOf returns the storage provider traced within the message request.
+func (h *Handler) storageOf(m *chat.Message, tp providers.StorageProvider) providers.StorageProvider {
+	return tracing.Provider(h.context(m), tp)
+}
+
+// unlock decrypts the private key with the current master password.
+func (h *Handler) unlock(m *chat.Message) (*ecdsa.PrivateKey, error) {
+	_, span := tracing.Start(h.context(m), "crypto.unlock")
+	defer span.Finish()
+
+	h.keymx.RLock()
+	defer h.keymx.RUnlock()
+
+	privkey, err := getPrivkey(h.storage(m), h.Config.Salt, h.masterPass(m), h.unlockers()...)
+	if err == nil {
+		h.signVault(m, privkey)
+	}
+
+	return privkey, span.SetError(err)
+}
+
+// logger returns the logger of the message request.
+func (h *Handler) logger(m *chat.Message) log.Logger {
+	return log.Ctx(h.context(m))
+}
+
+// sendError sends the localized error with the correlation ID of the request,
+// so the report can be matched to the logs.
+func (h *Handler) sendError(m *chat.Message, key string) {
+	h.sendFailure(m, key, nil)
+}
+
+// sendFailure sends the localized error along with the reason of the err if
+// it is known.
+func (h *Handler) sendFailure(m *chat.Message, key string, err error) {
+	text := h.Locales.Get(m.Sender.LanguageCode, key)
+
+	for _, reason := range reasons {
+		if errors.Is(err, reason.err) {
+			text += "\n" + h.Locales.Get(m.Sender.LanguageCode, reason.key)
+
+			break
+		}
+	}
+
+	if id := log.RequestID(h.context(m)); id != "" {
+		text += "\n\n" + h.Locales.Format(m.Sender.LanguageCode, "error_id", localizator.Args{"ID": id})
+	}
+
+	h.sendMessage(m, text)
+}
@@ -0,0 +1,212 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"html"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"secretable/pkg/audit"
+	"secretable/pkg/config"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+)
+
+const (
+	ingestPath = "/api/v1/secrets"
+
+	signatureHeader = "X-Secretable-Signature"
+	timestampHeader = "X-Secretable-Timestamp"
+	signaturePrefix = "sha256="
+
+	// maxIngestBody limits the pushed secret, maxIngestSkew limits the age of
+	// a signed request so it can't be replayed later.
+	maxIngestBody = 64 << 10
+	maxIngestSkew = 5 * time.Minute
+)
+
+// ingestRequest is a secret pushed by an automation, the tags are appended
+// to the description.
+type ingestRequest struct {
+	Description string   `json:"description"`
+	Username    string   `json:"username"`
+	Secret      string   `json:"secret"`
+	URL         string   `json:"url"`
+	Tags        []string `json:"tags"`
+}
+
+type ingestResponse struct {
+	ID string `json:"id"`
+}
+
+// serveIngest adds the secret pushed with POST /api/v1/secrets to the default
+// vault. The request is authorized with the bearer token and signed with its
+// HMAC key: the signature header is "sha256=" followed by the hex HMAC-SHA256
+// of "<timestamp>.<body>" along with the Unix timestamp header. The secret
+// must be tagged and the token may push only the secrets of its tags.
+func (h *Handler) serveIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	token, ok := h.findToken(r)
+	if !ok || token.HMACKey == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxIngestBody+1))
+	if err != nil || len(body) > maxIngestBody {
+		http.Error(w, "bad request", http.StatusBadRequest)
+
+		return
+	}
+
+	if !validIngestSignature(token, r.Header.Get(timestampHeader), r.Header.Get(signatureHeader), body, time.Now()) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+		return
+	}
+
+	var req ingestRequest
+	if err = json.Unmarshal(body, &req); err != nil || strings.TrimSpace(req.Description) == "" || req.Secret == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+
+		return
+	}
+
+	secret := providers.SecretsData{Description: taggedDescription(req.Description, req.Tags)}
+
+	tags := secret.Tags()
+	if len(tags) == 0 {
+		http.Error(w, "secret has no tags", http.StatusBadRequest)
+
+		return
+	}
+
+	for _, tag := range tags {
+		if !tokenHasTag(token, tag) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+
+			return
+		}
+	}
+
+	h.keymx.RLock()
+	privkey, err := getPrivkey(h.TablesProvider, h.Config.Salt, h.vaultPass(DefaultVault), h.unlockers()...)
+	h.keymx.RUnlock()
+
+	if err != nil {
+		http.Error(w, "vault is locked", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	encSecret, err := encryptSecret(privkey, secret.Description, req.Username, req.Secret)
+	if err != nil {
+		log.Error("Encrypt pushed secret: " + err.Error())
+		http.Error(w, "internal error", http.StatusInternalServerError)
+
+		return
+	}
+
+	encSecret.URL = strings.TrimSpace(req.URL)
+
+	secrets, err := h.TablesProvider.GetSecrets()
+	if err != nil {
+		log.Error("Get secrets: " + err.Error())
+		http.Error(w, "internal error", http.StatusInternalServerError)
+
+		return
+	}
+
+	encSecret.ID = providers.NewID(secrets)
+	encSecret = signSecret(privkey, encSecret)
+
+	if err = h.TablesProvider.AddSecret(encSecret); err != nil {
+		log.Error("Add pushed secret: "+err.Error(), "token", token.Name)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+
+		return
+	}
+
+	h.writeAudit(audit.Event{
+		Action:    audit.ActionAdd,
+		SecretKey: audit.SecretKey(encSecret),
+		Details:   "http:" + token.Name,
+	})
+
+	go h.notifyAdmins(0, h.Locales.Format("en", "api_secret_added", localizator.Args{
+		"ID":          encSecret.StableID(),
+		"Description": html.EscapeString(encSecret.Description),
+		"Token":       html.EscapeString(token.Name),
+	}))
+
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, ingestResponse{ID: encSecret.StableID()})
+}
+
+// validIngestSignature checks the HMAC of the timestamp and the body with the
+// key of the token, the timestamp must be within maxIngestSkew of now.
+func validIngestSignature(token config.APIToken, timestamp, signature string, body []byte, now time.Time) bool {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	if skew := now.Sub(time.Unix(sec, 0)); skew > maxIngestSkew || skew < -maxIngestSkew {
+		return false
+	}
+
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil || !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(token.HMACKey))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// taggedDescription appends the tags missing in the description.
+func taggedDescription(description string, tags []string) string {
+	description = strings.TrimSpace(description)
+	current := providers.SecretsData{Description: description}
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+		if tag == "" || strings.ContainsAny(tag, " \t\n") || current.HasTag(tag) {
+			continue
+		}
+
+		current.Description += " #" + tag
+	}
+
+	return current.Description
+}
@@ -0,0 +1,295 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/keyslots"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+	"strconv"
+
+	"github.com/mr-tron/base58/base58"
+	"github.com/pkg/errors"
+)
+
+const macLength = 16
+
+// rowMAC authenticates the stored fields of the secret with a key derived
+// from the private key, so it survives the change of the master password.
+func rowMAC(privkey *ecdsa.PrivateKey, secret providers.SecretsData) string {
+	keyMAC := hmac.New(sha256.New, privkey.D.Bytes())
+	keyMAC.Write([]byte("secretable row mac"))
+
+	mac := hmac.New(sha256.New, keyMAC.Sum(nil))
+
+	for _, field := range []string{
+		secret.StableID(), secret.Description, secret.Username, secret.Secret,
+		strconv.FormatInt(secret.Owner, 10), secret.Type, secret.URL,
+	} {
+		var size [8]byte
+
+		binary.BigEndian.PutUint64(size[:], uint64(len(field)))
+		mac.Write(size[:])
+		mac.Write([]byte(field))
+	}
+
+	return base58.Encode(mac.Sum(nil)[:macLength])
+}
+
+// signSecret sets the MAC of the secret to be stored.
+func signSecret(privkey *ecdsa.PrivateKey, secret providers.SecretsData) providers.SecretsData {
+	secret.MAC = rowMAC(privkey, secret)
+
+	return secret
+}
+
+// verifySecret reports whether the stored fields match the MAC, the secrets
+// stored before the MACs are accepted until the vault is signed.
+func verifySecret(privkey *ecdsa.PrivateKey, secret providers.SecretsData, signed bool) bool {
+	if secret.MAC == "" {
+		return !signed
+	}
+
+	return hmac.Equal([]byte(secret.MAC), []byte(rowMAC(privkey, secret)))
+}
+
+// signSecrets signs the secrets with the unlocked private key.
+func (h *Handler) signSecrets(m *chat.Message, secrets ...providers.SecretsData) ([]providers.SecretsData, error) {
+	privkey, err := h.unlock(m)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := make([]providers.SecretsData, len(secrets))
+	for i, secret := range secrets {
+		signed[i] = signSecret(privkey, secret)
+	}
+
+	return signed, nil
+}
+
+// reportTampered flags the secret whose stored fields don't match the MAC in
+// the chat and in the audit log.
+func (h *Handler) reportTampered(m *chat.Message, secret providers.SecretsData) {
+	h.logger(m).Error("Secret is modified outside of the bot", "chat_id", m.Chat.ID, "id", secret.StableID())
+	h.recordAudit(m, audit.ActionIntegrity, audit.SecretKey(secret), secret.StableID())
+
+	h.sendMessage(m, h.Locales.Format(m.Sender.LanguageCode, "integrity_tampered", localizator.Args{
+		"ID": secret.StableID(),
+	}))
+}
+
+// signedMarker is the data of the signed marker of the key, the MAC of the
+// private key, so the marker can't be forged without the key.
+func signedMarker(privkey *ecdsa.PrivateKey) string {
+	mac := hmac.New(sha256.New, privkey.D.Bytes())
+	mac.Write([]byte("secretable signed vault"))
+
+	return base58.Encode(mac.Sum(nil)[:macLength])
+}
+
+// VaultSigned reports whether the key of the storage has the signed marker of
+// the private key, so a lost audit log doesn't accept the secrets without a
+// MAC again.
+func VaultSigned(tp providers.StorageProvider, privkey *ecdsa.PrivateKey) (bool, error) {
+	k, err := tp.GetKey()
+	if err != nil {
+		return false, errors.Wrap(err, "get key")
+	}
+
+	slots, err := keyslots.Parse(k)
+	if err != nil {
+		return false, errors.Wrap(err, "parse key slots")
+	}
+
+	return hmac.Equal([]byte(slots.Marker()), []byte(signedMarker(privkey))), nil
+}
+
+// markSigned stores the signed marker of the private key along with the key
+// slots of the storage.
+func markSigned(tp providers.StorageProvider, privkey *ecdsa.PrivateKey) error {
+	k, err := tp.GetKey()
+	if err != nil {
+		return errors.Wrap(err, "get key")
+	}
+
+	slots, err := keyslots.Parse(k)
+	if err != nil {
+		return errors.Wrap(err, "parse key slots")
+	}
+
+	return errors.Wrap(tp.SetKey(slots.Mark(signedMarker(privkey)).String()), "store key slots")
+}
+
+// signed reports whether the active vault of the chat is signed, so its
+// secrets without a MAC are tampered.
+func (h *Handler) signed(m *chat.Message) bool {
+	return h.isSigned(h.vaultName(m))
+}
+
+// isSigned reports whether the vault is signed by the audit log or by the
+// marker of its key, the archive is signed along with the default vault.
+func (h *Handler) isSigned(name string) bool {
+	if h.Audit.Signed(name) {
+		return true
+	}
+
+	if name == ArchiveVault {
+		name = DefaultVault
+	}
+
+	_, ok := h.signedVaults.Load(name)
+
+	return ok
+}
+
+// signedBy reports whether the vault of the storage is signed, the marker of
+// the key is read if the vault isn't used by a chat yet. A failed read keeps
+// the MACs enforced.
+func (h *Handler) signedBy(name string, tp providers.StorageProvider, privkey *ecdsa.PrivateKey) bool {
+	if h.isSigned(name) {
+		return true
+	}
+
+	marked, err := VaultSigned(tp, privkey)
+	if err != nil {
+		log.Error("Check signed vault: "+err.Error(), "vault", name)
+
+		return true
+	}
+
+	if marked {
+		h.signedVaults.Store(name, true)
+	}
+
+	return marked
+}
+
+// signVault signs the secrets of the active vault of the chat stored before
+// the MACs once, on the first use of the unlocked vault, and marks its key
+// signed. The archive is signed along with the default vault. The rows kept
+// in the clear are left for /encrypt_existing. The vault marked signed isn't
+// signed again if the audit log lost its record, the record is restored.
+func (h *Handler) signVault(m *chat.Message, privkey *ecdsa.PrivateKey) {
+	name := h.vaultName(m)
+	if _, ok := h.signedVaults.Load(name); ok {
+		return
+	}
+
+	h.signmx.Lock()
+	defer h.signmx.Unlock()
+
+	if _, ok := h.signedVaults.Load(name); ok {
+		return
+	}
+
+	marked, err := VaultSigned(h.storage(m), privkey)
+	if err != nil {
+		h.logger(m).Error("Check signed vault: "+err.Error(), "vault", name)
+
+		return
+	}
+
+	if marked {
+		h.restoreSigned(m, name)
+		h.signedVaults.Store(name, true)
+
+		return
+	}
+
+	// The archive goes first, so the default vault is signed again if the
+	// archive fails.
+	vaults, storages := []string{name}, []providers.StorageProvider{h.storage(m)}
+	if name == DefaultVault && h.ArchiveProvider != nil {
+		vaults = append([]string{ArchiveVault}, vaults...)
+		storages = append([]providers.StorageProvider{h.storageOf(m, h.ArchiveProvider)}, storages...)
+	}
+
+	for i, vault := range vaults {
+		if h.Audit.Signed(vault) {
+			continue
+		}
+
+		count, err := signLegacy(privkey, storages[i])
+		if err != nil {
+			h.logger(m).Error("Sign secrets: "+err.Error(), "vault", vault)
+
+			return
+		}
+
+		h.recordAudit(m, audit.ActionSigned, "", vault)
+		h.logger(m).Info("🔏 Vault signed", "vault", vault, "count", count)
+	}
+
+	if err = markSigned(h.storage(m), privkey); err != nil {
+		h.logger(m).Error("Mark vault signed: "+err.Error(), "vault", name)
+
+		return
+	}
+
+	h.signedVaults.Store(name, true)
+}
+
+// restoreSigned records the vault marked signed in the audit log if the log
+// has no record of it, e.g. the log is lost.
+func (h *Handler) restoreSigned(m *chat.Message, name string) {
+	vaults := []string{name}
+	if name == DefaultVault && h.ArchiveProvider != nil {
+		vaults = append(vaults, ArchiveVault)
+	}
+
+	for _, vault := range vaults {
+		if h.Audit.Signed(vault) {
+			continue
+		}
+
+		h.logger(m).Error("The audit log has no record of the vault marked signed, the record is restored", "vault", vault)
+		h.recordAudit(m, audit.ActionSigned, "", vault)
+	}
+}
+
+// signLegacy signs the secrets of the storage without a MAC and returns their
+// number, the broken ones are signed as well so /broken lists them as they
+// are instead of the tampered ones.
+func signLegacy(privkey *ecdsa.PrivateKey, storage providers.StorageProvider) (int, error) {
+	secrets, err := storage.GetSecrets()
+	if err != nil {
+		return 0, errors.Wrap(err, "get secrets")
+	}
+
+	var signed []providers.SecretsData
+
+	for _, secret := range secrets {
+		if secret.MAC != "" || isPlaintext(secret) {
+			continue
+		}
+
+		secret.ID = secret.StableID()
+		signed = append(signed, signSecret(privkey, secret))
+	}
+
+	if len(signed) == 0 {
+		return 0, nil
+	}
+
+	return len(signed), errors.Wrap(storage.UpdateSecrets(signed), "update secrets")
+}
@@ -0,0 +1,199 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"html"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/crypto"
+	"secretable/pkg/localizator"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mr-tron/base58/base58"
+)
+
+const (
+	defaultInviteDuration = 24 * time.Hour
+	maxInviteDuration     = 7 * 24 * time.Hour
+
+	inviteCodeLength = 10
+)
+
+// inviteRoles are the roles an invite code grants.
+var inviteRoles = map[string]Role{
+	"reader": RoleReader,
+	"member": RoleMember,
+}
+
+// Invite issues a one-time code which lets a new chat in with /join, e.g.
+// /invite reader 2d. "/invite revoke <chat id>" removes a joined chat.
+func (h *Handler) Invite(msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+	args := strings.Fields(strings.TrimPrefix(msg.Text, "/invite"))
+
+	if len(args) > 0 && args[0] == "revoke" {
+		h.revokeJoined(msg, args[1:])
+
+		return
+	}
+
+	role := h.inviteRole()
+	duration := defaultInviteDuration
+
+	for _, arg := range args {
+		if _, ok := inviteRoles[arg]; ok {
+			role = arg
+
+			continue
+		}
+
+		d, err := parseDuration(arg)
+		if err != nil || d > maxInviteDuration {
+			h.sendMessage(msg, h.Locales.Get(locale, "invite_usage"))
+
+			return
+		}
+
+		duration = d
+	}
+
+	code, err := crypto.MakeRandom(inviteCodeLength)
+	if err != nil {
+		h.logger(msg).Error("Make invite code: " + err.Error())
+		h.sendFailure(msg, "invite_unable_create", err)
+
+		return
+	}
+
+	encoded := base58.Encode(code)
+	expires := time.Now().Add(duration)
+
+	h.recordAuditEvent(msg, audit.Event{Action: audit.ActionInvite, Details: inviteHash(encoded) + " " + role, Expires: &expires})
+
+	h.sendMessage(msg, h.Locales.Format(locale, "invite_created", localizator.Args{
+		"Code":    encoded,
+		"Role":    h.Locales.Get(locale, roleKeys[inviteRoles[role]]),
+		"Expires": expires,
+	}))
+}
+
+// Join lets the chat in with the invite code, the admins are notified.
+func (h *Handler) Join(msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+	code := strings.TrimSpace(strings.TrimPrefix(msg.Text, "/join"))
+
+	if code == "" {
+		h.sendMessage(msg, h.Locales.Get(locale, "join_usage"))
+
+		return
+	}
+
+	if h.roleOfMessage(msg) >= RoleMember {
+		h.sendMessage(msg, h.Locales.Get(locale, "join_already"))
+
+		return
+	}
+
+	hash := inviteHash(code)
+
+	// The check and the record of the code are one step, so the code is
+	// redeemed once.
+	h.invitesmx.Lock()
+
+	role, ok := h.Audit.Invite(hash)
+	if ok {
+		h.recordAuditEvent(msg, audit.Event{Action: audit.ActionJoin, Details: hash + " " + role})
+	}
+
+	h.invitesmx.Unlock()
+
+	if !ok {
+		h.sendMessage(msg, h.Locales.Get(locale, "join_invalid"))
+
+		return
+	}
+
+	h.denials.Delete(msg.Chat.ID)
+
+	roleName := h.Locales.Get(locale, roleKeys[inviteRoles[role]])
+
+	h.sendMessage(msg, h.Locales.Format(locale, "join_done", localizator.Args{"Role": roleName}))
+	h.notifyAdmins(msg.Chat.ID, h.Locales.Format("en", "join_notify", localizator.Args{
+		"ChatID": msg.Chat.ID,
+		"Name":   html.EscapeString(senderName(msg)),
+		"Role":   h.Locales.Get("en", roleKeys[inviteRoles[role]]),
+	}))
+}
+
+func (h *Handler) revokeJoined(msg *chat.Message, args []string) {
+	locale := msg.Sender.LanguageCode
+
+	var (
+		chatID int64
+		err    error
+	)
+
+	if len(args) == 1 {
+		chatID, err = strconv.ParseInt(args[0], 10, 64)
+	}
+
+	if len(args) != 1 || err != nil {
+		h.sendMessage(msg, h.Locales.Get(locale, "invite_usage"))
+
+		return
+	}
+
+	if _, ok := h.Audit.Joined(chatID); !ok {
+		h.sendMessage(msg, h.Locales.Format(locale, "invite_not_joined", localizator.Args{"ChatID": chatID}))
+
+		return
+	}
+
+	h.recordAuditEvent(msg, audit.Event{Action: audit.ActionLeave, Target: chatID})
+
+	h.sendMessage(msg, h.Locales.Format(locale, "invite_revoked", localizator.Args{"ChatID": chatID}))
+}
+
+// joinedRole returns the role of the chat which redeemed an invite code.
+func (h *Handler) joinedRole(chatID int64) Role {
+	name, ok := h.Audit.Joined(chatID)
+	if !ok {
+		return RoleAnyone
+	}
+
+	return inviteRoles[name]
+}
+
+// inviteRole returns the role of the invite codes from config, member by
+// default.
+func (h *Handler) inviteRole() string {
+	if _, ok := inviteRoles[h.Config.InviteRole]; ok {
+		return h.Config.InviteRole
+	}
+
+	return "member"
+}
+
+// inviteHash is the reference of the invite code kept by the audit log.
+func inviteHash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,171 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/config"
+	"secretable/pkg/localizator"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// searchLimit is the name of the limit of the text queries.
+const searchLimit = "search"
+
+const defaultLimitPeriod = 24 * time.Hour
+
+// limit is the parsed limit of the config.
+type limit struct {
+	Max      int
+	Period   time.Duration
+	Cooldown time.Duration
+}
+
+// CheckLimits validates the limits of the config.
+func (h *Handler) CheckLimits() error {
+	for name, l := range h.Config.Limits {
+		if _, err := parseLimit(l); err != nil {
+			return errors.Wrap(err, "limit "+name)
+		}
+	}
+
+	return nil
+}
+
+// LimitMiddleware caps the uses of the command by the limit of the config,
+// the uses are kept in the audit log.
+func (h *Handler) LimitMiddleware(endpoint string, next func(m *chat.Message)) func(m *chat.Message) {
+	name := strings.TrimPrefix(endpoint, "/")
+	if endpoint == chat.OnText {
+		name = searchLimit
+	}
+
+	if _, ok := h.Config.Limits[name]; !ok {
+		return next
+	}
+
+	return func(msg *chat.Message) {
+		if !h.allowUses(msg, name, 1) {
+			return
+		}
+
+		h.recordAudit(msg, audit.ActionCommand, "", name)
+
+		next(msg)
+	}
+}
+
+// allowReveal checks the reveal limit before the secrets of the count are
+// revealed to the chat.
+func (h *Handler) allowReveal(msg *chat.Message, count int) bool {
+	return h.allowUses(msg, audit.UsesReveal, count)
+}
+
+// allowUses reports whether the chat may use the limit count times more, the
+// chat is told when the limit is reached.
+func (h *Handler) allowUses(msg *chat.Message, name string, count int) bool {
+	next := h.nextUse(msg.Chat.ID, name, count)
+	if next.IsZero() {
+		return true
+	}
+
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "limit_reached", localizator.Args{
+		"Name": name,
+		"Next": next,
+	}))
+
+	return false
+}
+
+// nextUse returns when the chat may use the limit count times more, zero if
+// it may now. The paths without the chat to tell, e.g. the Web App or the
+// webhook, answer with the time themselves.
+func (h *Handler) nextUse(chatID int64, name string, count int) time.Time {
+	conf, ok := h.Config.Limits[name]
+	if !ok {
+		return time.Time{}
+	}
+
+	l, err := parseLimit(conf)
+	if err != nil {
+		return time.Time{}
+	}
+
+	now := time.Now()
+	uses := h.Audit.Uses(chatID, name, now.Add(-maxDuration(l.Period, l.Cooldown)))
+
+	var next time.Time
+
+	if l.Cooldown > 0 && len(uses) > 0 && now.Sub(uses[len(uses)-1]) < l.Cooldown {
+		next = uses[len(uses)-1].Add(l.Cooldown)
+	}
+
+	if l.Max > 0 {
+		inPeriod := uses[:0:0]
+
+		for _, at := range uses {
+			if now.Sub(at) < l.Period {
+				inPeriod = append(inPeriod, at)
+			}
+		}
+
+		// The uses over the max wait for the oldest uses to leave the period.
+		switch over := len(inPeriod) + count - l.Max; {
+		case over <= 0:
+		case count > l.Max:
+			next = now.Add(l.Period)
+		case inPeriod[over-1].Add(l.Period).After(next):
+			next = inPeriod[over-1].Add(l.Period)
+		}
+	}
+
+	return next
+}
+
+func parseLimit(conf config.Limit) (limit, error) {
+	l := limit{Max: conf.Max, Period: defaultLimitPeriod}
+
+	var err error
+
+	if conf.Period != "" {
+		if l.Period, err = parseDuration(conf.Period); err != nil {
+			return l, errors.Wrap(err, "period")
+		}
+	}
+
+	if conf.Cooldown != "" {
+		if l.Cooldown, err = parseDuration(conf.Cooldown); err != nil {
+			return l, errors.Wrap(err, "cooldown")
+		}
+	}
+
+	if l.Max < 0 {
+		return l, errors.New("negative max")
+	}
+
+	return l, nil
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+
+	return b
+}
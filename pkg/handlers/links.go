@@ -0,0 +1,389 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"html/template"
+	"net/http"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/crypto"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mr-tron/base58/base58"
+	"github.com/pkg/errors"
+)
+
+const (
+	linksPath = "/s/"
+
+	defaultLinkDuration = time.Hour
+	maxLinkDuration     = 7 * 24 * time.Hour
+
+	linkIDLength  = 16
+	linkKeyLength = 32
+)
+
+// secretLink is a secret shown once by the HTTP endpoint. Only the link
+// carries the key, the bot keeps the encrypted secret until it is viewed or
+// expires.
+type secretLink struct {
+	From      int64
+	Locale    string
+	ID        string
+	SecretKey string
+	Salt      []byte
+	Nonce     []byte
+	Cipher    []byte
+	Expires   time.Time
+
+	// Failures counts the requests with a wrong key, the link is burned at
+	// the failure limit.
+	Failures int32
+}
+
+var linkPage = template.Must(template.New("link").Parse(`<!DOCTYPE html>
+<html lang="{{.Locale}}" dir="{{.Dir}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Secret}}<pre>{{.Secret}}</pre>
+{{end}}<p>{{.Text}}</p>
+{{if .Button}}<form method="post"><button type="submit">{{.Button}}</button></form>
+{{end}}</body>
+</html>
+`))
+
+type linkPageData struct {
+	Locale string
+	Dir    string
+	Title  string
+	Text   string
+	Button string
+	Secret template.HTML
+}
+
+// Link creates a one-time HTTPS link to the secret for someone outside of
+// Telegram, e.g. /link 3 30m.
+func (h *Handler) Link(msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+
+	if h.Config.HTTPListen == "" || !strings.HasPrefix(h.Config.PublicURL, "https://") {
+		h.sendMessage(msg, h.Locales.Get(locale, "link_disabled"))
+
+		return
+	}
+
+	args := strings.Fields(strings.TrimPrefix(msg.Text, "/link"))
+
+	if len(args) < 1 || len(args) > 2 {
+		h.sendMessage(msg, h.Locales.Get(locale, "link_wrong_format"))
+
+		return
+	}
+
+	duration := defaultLinkDuration
+
+	if len(args) == 2 {
+		var err error
+		if duration, err = parseDuration(args[1]); err != nil || duration > maxLinkDuration {
+			h.sendMessage(msg, h.Locales.Get(locale, "link_wrong_duration"))
+
+			return
+		}
+	}
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		return
+	}
+
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		h.sendMessage(msg, h.Locales.Get(locale, "link_wrong_format"))
+
+		return
+	}
+
+	index := h.findVisible(msg, secrets, args[0])
+	if index < 0 {
+		h.sendMessage(msg, h.Locales.Get(locale, "link_wrong_format"))
+
+		return
+	}
+
+	h.linkSecret(msg, privkey, secrets[index], duration, revealWhole)
+}
+
+// confirmLink creates the link of the confirm button, the argument is the
+// duration in seconds.
+func (h *Handler) confirmLink(msg *chat.Message, privkey *ecdsa.PrivateKey, secret providers.SecretsData, arg string) {
+	seconds, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return
+	}
+
+	h.linkSecret(msg, privkey, secret, time.Duration(seconds)*time.Second, revealConfirmed)
+}
+
+// linkSecret creates the link to the secret valid for the duration, the link
+// to the secret of an environment with the confirmation is created by the
+// confirm button.
+func (h *Handler) linkSecret(msg *chat.Message, privkey *ecdsa.PrivateKey, secret providers.SecretsData,
+	duration time.Duration, mode revealMode,
+) {
+	locale := msg.Sender.LanguageCode
+
+	switch err := h.checkReveal(msg, secret, mode); {
+	case errors.Is(err, ErrNeedsConfirmation):
+		h.askConfirmation(msg, secret, confirmLink+"|"+audit.SecretKey(secret)+"|"+strconv.FormatInt(int64(duration/time.Second), 10))
+
+		return
+	case errors.Is(err, ErrNeedsApproval):
+		h.requestApproval(msg, secret)
+
+		return
+	}
+
+	if !h.allowReveal(msg, 1) {
+		return
+	}
+
+	decSecret, err := decryptSecret(privkey, secret, h.signed(msg))
+	if err != nil {
+		h.logger(msg).Error(err.Error())
+		h.sendFailure(msg, "link_unable_create", err)
+
+		return
+	}
+
+	link := &secretLink{
+		From:      msg.Chat.ID,
+		Locale:    locale,
+		ID:        secret.StableID(),
+		SecretKey: audit.SecretKey(secret),
+		Expires:   time.Now().Add(duration),
+	}
+
+	id, key, err := sealLink(link, h.formatSecret(locale, link.ID, decSecret))
+	if err != nil {
+		h.logger(msg).Error("Seal link: " + err.Error())
+		h.sendError(msg, "link_unable_create")
+
+		return
+	}
+
+	h.links.Store(id, link)
+	time.AfterFunc(duration, func() { h.expireLink(id) })
+
+	h.recordAuditEvent(msg, audit.Event{
+		Action:    audit.ActionLink,
+		SecretKey: link.SecretKey,
+		Details:   "created",
+		Expires:   &link.Expires,
+	})
+
+	url := strings.TrimSuffix(h.Config.PublicURL, "/") + linksPath + id + "/" + key
+
+	// The link in the code span has no preview.
+	h.sendMessage(msg, h.Locales.Format(locale, "link_created", localizator.Args{
+		"Expires": link.Expires,
+	})+"\n\n<code>"+url+"</code>")
+}
+
+// sealLink encrypts the formatted secret with a random key of the link.
+func sealLink(link *secretLink, text string) (id, key string, err error) {
+	idBytes, err := crypto.MakeRandom(linkIDLength)
+	if err != nil {
+		return "", "", err
+	}
+
+	keyBytes, err := crypto.MakeRandom(linkKeyLength)
+	if err != nil {
+		return "", "", err
+	}
+
+	link.Salt, _ = crypto.MakeRandom(saltLength)
+	link.Nonce, _ = crypto.MakeRandom(crypto.NonceSize)
+
+	link.Cipher, err = crypto.EncryptWithPhrase(keyBytes, link.Salt, link.Nonce, []byte(text))
+	if err != nil {
+		return "", "", err
+	}
+
+	return base58.Encode(idBytes), base58.Encode(keyBytes), nil
+}
+
+func (h *Handler) expireLink(id string) {
+	value, ok := h.links.LoadAndDelete(id)
+	if !ok {
+		return
+	}
+
+	link := value.(*secretLink)
+
+	h.writeAudit(audit.Event{
+		ChatID:    link.From,
+		Action:    audit.ActionLink,
+		SecretKey: link.SecretKey,
+		Details:   "expired",
+	})
+}
+
+// serveLink asks to reveal the secret on GET, so the link previews don't open
+// it, and reveals it once on POST.
+func (h *Handler) serveLink(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; form-action 'self'")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, linksPath), "/", 2)
+
+	value, ok := h.links.Load(parts[0])
+	if !ok || len(parts) != 2 || time.Now().After(value.(*secretLink).Expires) {
+		h.writeLinkPage(w, http.StatusNotFound, r.Header.Get("Accept-Language"), "", "")
+
+		return
+	}
+
+	link := value.(*secretLink)
+
+	var text []byte
+
+	key, err := base58.Decode(parts[1])
+	if err == nil {
+		text, err = crypto.DecryptWithPhrase(key, link.Salt, link.Nonce, link.Cipher)
+	}
+
+	if err != nil {
+		h.linkFailed(parts[0], link)
+		h.writeLinkPage(w, http.StatusNotFound, link.Locale, "", "")
+
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		h.writeLinkPage(w, http.StatusOK, link.Locale, "", h.Locales.Get(link.Locale, "link_page_reveal_button"))
+
+		return
+	}
+
+	// The secret is revealed only by the request which removes the link.
+	if _, ok = h.links.LoadAndDelete(parts[0]); !ok {
+		h.writeLinkPage(w, http.StatusNotFound, link.Locale, "", "")
+
+		return
+	}
+
+	h.writeAudit(audit.Event{
+		ChatID:    link.From,
+		Action:    audit.ActionLink,
+		SecretKey: link.SecretKey,
+		Details:   "viewed",
+	})
+
+	_, err = h.Chat.SendMessage(link.From, h.Locales.Format(link.Locale, "link_viewed", localizator.Args{
+		"ID": link.ID,
+	}), chat.Options{Notify: true})
+	if err != nil {
+		log.Error("Unable to notify about a viewed link: "+err.Error(), "chat_id", link.From)
+	}
+
+	h.writeLinkPage(w, http.StatusOK, link.Locale, string(text), "")
+}
+
+func (h *Handler) writeLinkPage(w http.ResponseWriter, status int, locale, secret, button string) {
+	if i := strings.IndexAny(locale, ",;"); i >= 0 {
+		locale = locale[:i]
+	}
+
+	data := linkPageData{
+		Locale: locale,
+		Dir:    "ltr",
+		Title:  h.Locales.Get(locale, "link_page_title"),
+		Text:   h.Locales.Get(locale, "link_page_gone"),
+		Button: button,
+		// The secret is formatted with the HTML layouts of the messages.
+		Secret: template.HTML(secret),
+	}
+
+	if h.Locales.RTL(locale) {
+		data.Dir = "rtl"
+	}
+
+	if button != "" {
+		data.Text = h.Locales.Get(locale, "link_page_hint")
+	}
+
+	if secret != "" {
+		data.Text = h.Locales.Get(locale, "link_page_viewed")
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := linkPage.Execute(w, data); err != nil {
+		log.Error("Write link page: " + err.Error())
+	}
+}
+
+// linkFailed counts the request of the link with a wrong key, the link is
+// removed at the failure limit so its key can't be guessed.
+func (h *Handler) linkFailed(id string, link *secretLink) {
+	failures := atomic.AddInt32(&link.Failures, 1)
+
+	log.Info("🧯 Decrypt failure", "chat_id", link.From, "operation", "link", "count", failures)
+
+	if failures < failureLimit {
+		return
+	}
+
+	if _, ok := h.links.LoadAndDelete(id); !ok {
+		return
+	}
+
+	h.writeAudit(audit.Event{
+		ChatID:    link.From,
+		Action:    audit.ActionLink,
+		SecretKey: link.SecretKey,
+		Details:   "burned",
+	})
+
+	_, err := h.Chat.SendMessage(link.From, h.Locales.Format(link.Locale, "link_burned", localizator.Args{
+		"ID": link.ID, "Count": failures,
+	}), chat.Options{Notify: true})
+	if err != nil {
+		log.Error("Unable to notify about a burned link: "+err.Error(), "chat_id", link.From)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/chat"
+	"secretable/pkg/config"
+	"secretable/pkg/localizator"
+	"strings"
+)
+
+// Maintenance enables the maintenance mode with the given message,
+// "/maintenance off" disables it.
+func (h *Handler) Maintenance(msg *chat.Message) {
+	text := strings.TrimSpace(strings.TrimPrefix(msg.Text, "/maintenance"))
+
+	if text == "" {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "maintenance_empty_message"))
+
+		return
+	}
+
+	if text == "off" {
+		h.setMaintenance("")
+		h.logger(msg).Info("🛠 Maintenance mode disabled", "chat_id", msg.Chat.ID)
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "maintenance_disabled"))
+
+		return
+	}
+
+	h.setMaintenance(text)
+	h.logger(msg).Info("🛠 Maintenance mode enabled", "chat_id", msg.Chat.ID)
+	h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "maintenance_enabled"))
+}
+
+func (h *Handler) MaintenanceMiddleware(next func(m *chat.Message)) func(m *chat.Message) {
+	return func(msg *chat.Message) {
+		text := h.getMaintenance()
+
+		if text == "" || h.isAdmin(msg.Chat.ID) {
+			next(msg)
+
+			return
+		}
+
+		h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "maintenance_notice", localizator.Args{
+			"Notice": html.EscapeString(text),
+		}))
+	}
+}
+
+func (h *Handler) Broadcast(msg *chat.Message) {
+	text := strings.TrimSpace(strings.TrimPrefix(msg.Text, "/broadcast"))
+
+	if text == "" {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "broadcast_empty_message"))
+
+		return
+	}
+
+	sent, failed := 0, 0
+
+	for _, chatID := range h.allowedChats() {
+		if chatID == msg.Chat.ID {
+			continue
+		}
+
+		if _, err := h.Chat.SendMessage(chatID, "📢 "+html.EscapeString(text), chat.Options{Notify: true}); err != nil {
+			h.logger(msg).Error("Unable to send a broadcast message: "+err.Error(), "chat_id", chatID)
+
+			failed++
+
+			continue
+		}
+
+		sent++
+	}
+
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "broadcast_sent", localizator.Args{
+		"Sent": sent, "Failed": failed,
+	}))
+}
+
+// allowedChats returns the allowed, joined and admin chats without
+// duplicates, the allowed users are reached in their private chats. The
+// usernames and the groups aren't chats the bot can write to.
+func (h *Handler) allowedChats() []int64 {
+	seen := make(map[int64]bool)
+
+	list := append(append([]int64(nil), h.Config.AdminList...), h.Audit.JoinedChats()...)
+
+	for _, a := range h.Config.AllowedList {
+		if a.Kind == config.AllowedChat || a.Kind == config.AllowedUser {
+			list = append(list, a.ID)
+		}
+	}
+
+	var chats []int64
+
+	for _, chatID := range list {
+		if !seen[chatID] {
+			seen[chatID] = true
+			chats = append(chats, chatID)
+		}
+	}
+
+	return chats
+}
+
+func (h *Handler) setMaintenance(text string) {
+	h.maintenancemx.Lock()
+	h.maintenance = text
+	h.maintenancemx.Unlock()
+}
+
+func (h *Handler) getMaintenance() string {
+	h.maintenancemx.RLock()
+	defer h.maintenancemx.RUnlock()
+
+	return h.maintenance
+}
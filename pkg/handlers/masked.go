@@ -0,0 +1,194 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maskedValue replaces the hidden values, the length of the value isn't
+// shown.
+var maskedValue = strings.Repeat(maskChar, 5)
+
+const (
+	// revealedPassword and revealedUsername are the flags of the revealed
+	// values in the data of MaskButton, confirmedPassword is the password
+	// revealed by the confirm button of its environment.
+	revealedPassword  = "p"
+	revealedUsername  = "u"
+	confirmedPassword = "c"
+)
+
+// MaskButton toggles a value of the secret shown by masked_display, the data
+// is the key and the flags of the values revealed by the press.
+var MaskButton = chat.Button{Unique: "mask"}
+
+// sendMasked sends the secret with the password hidden behind the button,
+// the username is shown unless mask_username is set.
+func (h *Handler) sendMasked(msg *chat.Message, id string, secret providers.SecretsData, key, footer string) {
+	revealed := revealedUsername
+	if h.Config.MaskedDisplay.MaskUsername {
+		revealed = ""
+	}
+
+	h.sendMessageWithOptions(msg, h.formatMasked(msg.Sender.LanguageCode, id, secret, revealed)+footer,
+		chat.Options{Buttons: h.maskButtons(msg.Sender.LanguageCode, key, revealed)})
+}
+
+// displaysMasked reports whether sendSecret shows the secret with the masked
+// display, so its password is revealed by the button.
+func (h *Handler) displaysMasked(secret providers.SecretsData) bool {
+	if _, ok := h.fieldsOf(secret.Type); ok || !h.Config.MaskedDisplay.Enabled {
+		return false
+	}
+
+	switch secret.Type {
+	case providers.TypeSSHKey, providers.TypeToken, providers.TypeWiFi:
+		return false
+	}
+
+	return true
+}
+
+// MaskCallback shows or hides a value of the masked secret in place. The
+// password of an environment with the confirmation is revealed by the confirm
+// button shown in place of the toggle.
+func (h *Handler) MaskCallback(msg *chat.Message, c *chat.Callback) {
+	parts := strings.SplitN(c.Data, "|", 2)
+	if len(parts) != 2 {
+		return
+	}
+
+	locale := msg.Sender.LanguageCode
+	revealed := parts[1]
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		h.sendMessage(msg, h.Locales.Get(locale, "reveal_unlock_first"))
+
+		return
+	}
+
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		return
+	}
+
+	index := findSecret(secrets, parts[0])
+	if index < 0 || !h.isVisible(msg, secrets[index]) {
+		h.sendMessage(msg, h.Locales.Get(locale, "edit_secret_not_found"))
+
+		return
+	}
+
+	mode := revealDisplayed
+
+	switch {
+	case strings.Contains(revealed, confirmedPassword):
+		mode = revealConfirmed
+	case strings.Contains(revealed, revealedPassword):
+		mode = revealWhole
+	}
+
+	err = h.checkReveal(msg, secrets[index], mode)
+	if errors.Is(err, ErrNeedsApproval) {
+		h.requestApproval(msg, secrets[index])
+
+		return
+	}
+
+	shown := revealed
+	if errors.Is(err, ErrNeedsConfirmation) {
+		shown = strings.ReplaceAll(revealed, revealedPassword, "")
+	}
+
+	// The limit is checked before the password is decrypted for the chat.
+	if shown == revealed && strings.Contains(revealed, revealedPassword) && !h.allowReveal(msg, 1) {
+		return
+	}
+
+	decSecret, err := decryptSecret(privkey, secrets[index], h.signed(msg))
+	if err != nil {
+		h.logger(msg).Error(err.Error())
+
+		return
+	}
+
+	buttons := h.maskButtons(locale, parts[0], shown)
+
+	switch {
+	case shown != revealed:
+		env, _ := h.environmentOf(secrets[index])
+
+		confirm := MaskButton
+		confirm.Text = h.Locales.Format(locale, "environment_confirm_button", localizator.Args{"Label": env.Label})
+		confirm.Data = parts[0] + "|" + revealed + confirmedPassword
+		buttons[0][0] = confirm
+	case strings.Contains(revealed, revealedPassword):
+		h.recordAudit(msg, audit.ActionReveal, parts[0], "password")
+	}
+
+	err = h.Chat.EditMessage(msg.Chat.ID, msg.ID, h.formatMasked(locale, secrets[index].StableID(), h.labelEnvironment(decSecret), shown),
+		chat.Options{Buttons: buttons})
+	if err != nil {
+		h.logger(msg).Error("Unable to edit the masked secret: "+err.Error(), "chat_id", msg.Chat.ID)
+	}
+}
+
+// formatMasked renders the secret with the values out of revealed hidden.
+func (h *Handler) formatMasked(locale, id string, secret providers.SecretsData, revealed string) string {
+	if !strings.Contains(revealed, revealedPassword) {
+		secret.Secret = maskedValue
+	}
+
+	if !strings.Contains(revealed, revealedUsername) && secret.Username != "" {
+		secret.Username = maskedValue
+	}
+
+	return h.makeQueryResponse(locale, id, secret)
+}
+
+// maskButtons returns the toggles of the password and the username, each
+// button keeps the flags of the values revealed after the press.
+func (h *Handler) maskButtons(locale, key, revealed string) [][]chat.Button {
+	toggle := func(flag, revealKey, hideKey string) chat.Button {
+		btn := MaskButton
+		btn.Text = h.Locales.Get(locale, revealKey)
+		btn.Data = key + "|" + revealed + flag
+
+		// The password hidden again is confirmed again.
+		if strings.Contains(revealed, flag) {
+			btn.Text = h.Locales.Get(locale, hideKey)
+			btn.Data = key + "|" + strings.ReplaceAll(revealed, flag, "")
+
+			if flag == revealedPassword {
+				btn.Data = strings.ReplaceAll(btn.Data, confirmedPassword, "")
+			}
+		}
+
+		return btn
+	}
+
+	return [][]chat.Button{{
+		toggle(revealedPassword, "mask_reveal_password", "mask_hide_password"),
+		toggle(revealedUsername, "mask_reveal_username", "mask_hide_username"),
+	}}
+}
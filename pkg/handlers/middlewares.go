@@ -15,26 +15,32 @@
 package handlers
 
 import (
+	"context"
 	"crypto/x509"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
 	"secretable/pkg/crypto"
+	"secretable/pkg/keyslots"
+	"secretable/pkg/localizator"
 	"secretable/pkg/log"
 	"secretable/pkg/providers"
+	"secretable/pkg/tracing"
 	"strings"
+	"sync"
 
-	"github.com/mr-tron/base58/base58"
-	tb "gopkg.in/tucnak/telebot.v2"
+	"github.com/pkg/errors"
 )
 
-func (h *Handler) CleanupMessagesMiddleware(cleanupTime int, next func(m *tb.Message)) func(m *tb.Message) {
-	return func(m *tb.Message) {
-		go cleanupMessage(h.Bot, m, cleanupTime)
+func (h *Handler) CleanupMessagesMiddleware(cleanupTime int, next func(m *chat.Message)) func(m *chat.Message) {
+	return func(m *chat.Message) {
+		go h.cleanupMessage(m, cleanupTime)
 		next(m)
 	}
 }
 
-func (h *Handler) AccessMiddleware(next func(m *tb.Message)) func(m *tb.Message) {
-	return func(m *tb.Message) {
-		if !h.hasAccess(m) {
+func (h *Handler) AccessMiddleware(role Role, next func(m *chat.Message)) func(m *chat.Message) {
+	return func(m *chat.Message) {
+		if !h.hasRole(m, role) {
 			return
 		}
 
@@ -42,18 +48,70 @@ func (h *Handler) AccessMiddleware(next func(m *tb.Message)) func(m *tb.Message)
 	}
 }
 
+// CallbackMiddleware answers the button press and passes it on as a message
+// of the chat and the sender, so the press runs through the message
+// middlewares built by wrap. The message keeps the ID of the message of the
+// button and the unique of the button as the text.
+func (h *Handler) CallbackMiddleware(
+	unique string, wrap func(next func(m *chat.Message)) func(m *chat.Message), next func(*chat.Message, *chat.Callback),
+) func(c *chat.Callback) {
+	return func(c *chat.Callback) {
+		if err := h.Chat.RespondCallback(c); err != nil {
+			log.Error("Unable to respond to callback: " + err.Error())
+		}
+
+		if c.Message == nil || c.Sender == nil {
+			return
+		}
+
+		msg := &chat.Message{ID: c.Message.ID, Chat: c.Message.Chat, Sender: c.Sender, Text: "[" + unique + "]"}
+
+		wrap(func(m *chat.Message) {
+			next(m, c)
+		})(msg)
+	}
+}
+
+// UnlockedMiddleware asks to unlock the vault before the button is pressed
+// again, the buttons never take the master password.
+func (h *Handler) UnlockedMiddleware(next func(m *chat.Message)) func(m *chat.Message) {
+	return func(msg *chat.Message) {
+		if !h.isUnlocked(msg) {
+			h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "callback_unlock_first"))
+
+			return
+		}
+
+		next(msg)
+	}
+}
+
+// ControlMasterPassMiddleware asks for the master password while the vault is
+// locked, the answer of the question unlocks it. The commands which don't use
+// the vault keep the question pending.
 func (h *Handler) ControlMasterPassMiddleware(
-	use bool, isSetHandler bool, next func(m *tb.Message),
-) func(m *tb.Message) {
-	return func(msg *tb.Message) {
-		if h.mastePass != "" {
+	use bool, isSetHandler bool, next func(m *chat.Message),
+) func(m *chat.Message) {
+	return func(msg *chat.Message) {
+		if h.isUnlocked(msg) {
 			next(msg)
 
 			return
 		}
 
-		_, exists := h.waitmpstates.Load(msg.Chat.ID)
-		h.waitmpstates.Delete(msg.Chat.ID)
+		conv, expired := h.conversations.current(msg.Chat.ID)
+
+		if isSetHandler && expired {
+			h.expired(msg)
+
+			return
+		}
+
+		if isSetHandler && conv.Kind == convOnboarding {
+			h.onboardStep(msg, conv.State.(*onboarding))
+
+			return
+		}
 
 		if !use {
 			next(msg)
@@ -61,80 +119,187 @@ func (h *Handler) ControlMasterPassMiddleware(
 			return
 		}
 
-		if !isSetHandler || isSetHandler && !exists {
-			h.waitmpstates.Store(msg.Chat.ID, true)
-			h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "checkpass_please_enter_pass"))
+		// The readers use the vault unlocked by a member.
+		if h.roleOfMessage(msg) < RoleMember {
+			h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "sessions_locked"))
 
 			return
 		}
 
-		h.setPass(msg)
+		if isSetHandler && conv.Kind == convMasterPass {
+			h.conversations.finish(msg.Chat.ID, convMasterPass)
+			h.setPass(msg)
+
+			return
+		}
+
+		if h.needsOnboarding(msg) {
+			h.startOnboarding(msg)
+
+			return
+		}
+
+		h.conversations.start(msg.Chat.ID, convMasterPass, nil)
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "checkpass_please_enter_pass"))
 	}
 }
 
-func (h *Handler) setPass(msg *tb.Message) {
-	if !h.hasAccess(msg) {
+func (h *Handler) setPass(msg *chat.Message) {
+	if !h.hasAccess(msg) || h.throttled(msg) {
 		return
 	}
 
-	newMasterPass := strings.TrimSpace(msg.Text)
+	if !h.openVault(msg, h.vaultName(msg), strings.TrimSpace(msg.Text)) {
+		return
+	}
+
+	h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "setpass_pass_changed"))
+}
+
+// openVault unlocks the vault with the name by the master password, a new
+// private key is generated if the vault has none. The errors are reported to
+// the chat.
+func (h *Handler) openVault(msg *chat.Message, name, newMasterPass string) bool {
+	storage := h.storageOf(msg, h.vaultStorage(name))
+
+	_, exists, err := getPrivkeyAsBytes(storage, h.Config.Salt, newMasterPass)
+	if errors.Is(err, crypto.ErrDecrypt) {
+		h.decryptFailed(msg.Chat.ID, "unlock")
+	}
 
-	_, exists, err := getPrivkeyAsBytes(h.TablesProvider, h.Config.Salt, newMasterPass)
 	if err != nil {
-		log.Error("Get private key: " + err.Error())
-		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "setpass_unable_set"))
+		h.logger(msg).Error("Get private key: " + err.Error())
+		h.sendFailure(msg, "setpass_unable_set", err)
 
-		return
+		return false
+	}
+
+	// The password is verified by the key or creates the vault, so the
+	// storage encrypted at rest is encrypted with it.
+	if err = storage.Seal(newMasterPass); err != nil {
+		h.logger(msg).Error("Seal storage: " + err.Error())
+		h.sendFailure(msg, "setpass_unable_set", err)
+
+		return false
 	}
 
 	if !exists {
-		log.Info("🎲 Generating new private key")
+		h.logger(msg).Info("🎲 Generating new private key")
 
 		privkey, _ := crypto.GeneratePrivKey()
 		binPrivkey, _ := x509.MarshalPKCS8PrivateKey(privkey)
-		nonce, _ := crypto.MakeRandom(crypto.NonceSize)
 
-		cypher, err := crypto.EncryptWithPhrase([]byte(newMasterPass), []byte(h.Config.Salt), nonce, binPrivkey)
+		slots, err := keyslots.Slots(nil).Put(context.Background(), binPrivkey, keyslots.NewPassword(newMasterPass, h.Config.Salt).WithKDF(h.kdf()))
 		if err != nil {
-			log.Error("Encrypt with phrase: " + err.Error())
-			h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "setpass_unable_set"))
+			h.logger(msg).Error("Encrypt with phrase: " + err.Error())
+			h.sendFailure(msg, "setpass_unable_set", err)
 
-			return
+			return false
 		}
 
-		cypher = append(nonce, cypher...)
-
-		err = h.TablesProvider.SetKey(base58.Encode(cypher))
+		err = storage.SetKey(slots.String())
 		if err != nil {
-			log.Error("Store to table: " + err.Error())
-			h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "setpass_unable_set"))
+			h.logger(msg).Error("Store to table: " + err.Error())
+			h.sendFailure(msg, "setpass_unable_set", err)
 
-			return
+			return false
 		}
+	} else {
+		h.upgradeKDF(msg, name, newMasterPass)
 	}
 
-	h.mastePass = newMasterPass
+	h.setVaultPass(name, newMasterPass)
+	h.startSession(msg)
 
-	h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "setpass_pass_changed"))
+	return true
 }
 
-func (h *Handler) ControlSetSecretMiddleware(isSetHandler bool, next func(m *tb.Message)) func(m *tb.Message) {
-	return func(msg *tb.Message) {
-		_, ok := h.setstates.Load(msg.Chat.ID)
-		h.setstates.Delete(msg.Chat.ID)
+// ControlSetSecretMiddleware routes the answers of the pending question of the
+// unlocked vault. A command ends the question of a new or edited secret, the
+// /setpass flow waits for its answer, /cancel or the timeout.
+func (h *Handler) ControlSetSecretMiddleware(isSetHandler bool, next func(m *chat.Message)) func(m *chat.Message) {
+	return func(msg *chat.Message) {
+		if !isSetHandler {
+			h.conversations.finish(msg.Chat.ID, convAddSecret, convStructured, convEdit)
+			next(msg)
+
+			return
+		}
 
-		if isSetHandler && ok {
-			h.querySetNewSecretsSecret(msg, h.mastePass)
+		conv, expired := h.conversations.current(msg.Chat.ID)
+		if expired {
+			h.expired(msg)
 
 			return
 		}
 
+		switch conv.Kind {
+		case convPassChange:
+			h.passChangeStep(msg, conv.State.(*passChange))
+		case convStructured:
+			h.conversations.finish(msg.Chat.ID, convStructured)
+			h.queryStructuredStep(msg, conv.State.(*structuredFlow))
+		case convAddSecret:
+			h.conversations.finish(msg.Chat.ID, convAddSecret)
+			h.querySetNewSecretsSecret(msg, h.masterPass(msg), conv.State.(string))
+		case convEdit:
+			h.conversations.finish(msg.Chat.ID, convEdit)
+			h.queryEditSecret(msg, conv.State.(string))
+		case convMasterPass, convOnboarding:
+			// The vault was unlocked by another chat meanwhile, the password
+			// isn't searched for.
+			h.conversations.finish(msg.Chat.ID, conv.Kind)
+			h.deleteMessage(msg)
+			h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "conversation_unlocked"))
+		default:
+			next(msg)
+		}
+	}
+}
+
+// RequestMiddleware starts the request context of the message with a new
+// correlation ID, the context lives until the handler returns.
+func (h *Handler) RequestMiddleware(next func(m *chat.Message)) func(m *chat.Message) {
+	return func(msg *chat.Message) {
+		h.contexts.Store(msg, log.WithRequestID(context.Background(), log.NewRequestID()))
+		defer h.contexts.Delete(msg)
+
+		next(msg)
+	}
+}
+
+// TracingMiddleware records a span of the command, the nested calls of the
+// storage, crypto and the chat are its children.
+func (h *Handler) TracingMiddleware(endpoint string, next func(m *chat.Message)) func(m *chat.Message) {
+	return func(msg *chat.Message) {
+		if !tracing.Enabled() {
+			next(msg)
+
+			return
+		}
+
+		ctx, span := tracing.Start(h.context(msg), "command "+endpoint,
+			"chat", tracing.ChatHash(msg.Chat.ID))
+		defer span.Finish()
+
+		span.SetAttr("request_id", log.RequestID(ctx))
+		h.contexts.Store(msg, ctx)
+
 		next(msg)
 	}
 }
-func (h *Handler) LoggerMiddleware(next func(m *tb.Message)) func(m *tb.Message) {
-	return func(msg *tb.Message) {
-		log.Info("📩 Message received: "+msg.Text,
+
+// LoggerMiddleware logs the received messages, the text is redacted for the
+// sensitive commands and for the answers of the pending flows which carry
+// master passwords and secrets.
+func (h *Handler) LoggerMiddleware(redact bool, next func(m *chat.Message)) func(m *chat.Message) {
+	return func(msg *chat.Message) {
+		text := msg.Text
+		if redact || h.hasPendingFlow(msg.Chat.ID) {
+			text = log.Redact(text)
+		}
+
+		h.logger(msg).Info("📩 Message received: "+text,
 			"chat_id", msg.Chat.ID,
 			"fullname", msg.Chat.FirstName+" "+msg.Chat.LastName,
 			"username", "@"+msg.Chat.Username,
@@ -144,39 +309,94 @@ func (h *Handler) LoggerMiddleware(next func(m *tb.Message)) func(m *tb.Message)
 	}
 }
 
-func (h *Handler) querySetNewSecretsSecret(msg *tb.Message, masterPass string) {
-	arr := strings.Split(msg.Text, "\n")
+func (h *Handler) hasPendingFlow(chatID int64) bool {
+	if h.conversations.pending(chatID) {
+		return true
+	}
 
-	if len(arr) < numbQueryColumns {
-		h.sendMessage(msg, "Need 3 lines:\nDescription\nUser\nSecret\n\nTry repeat /set")
+	for _, states := range []*sync.Map{&h.factorstates, &h.devicestates, &h.panicstates} {
+		if _, ok := states.Load(chatID); ok {
+			return true
+		}
+	}
+
+	return false
+}
 
+func (h *Handler) querySetNewSecretsSecret(msg *chat.Message, masterPass, secretType string) {
+	secret, ok := h.parseNewSecret(msg, masterPass)
+	if !ok {
 		return
 	}
 
-	arr = arr[:numbQueryColumns]
+	secret.Owner = msg.Chat.ID
+	secret.Type = secretType
+
+	if secretType == providers.TypeSSHKey && !h.validSSHKey(msg) {
+		return
+	}
+
+	secret, err := h.addSecret(msg, secret)
 
-	privkey, err := getPrivkey(h.TablesProvider, h.Config.Salt, masterPass)
 	if err != nil {
+		h.logger(msg).Error("Add secret: " + err.Error())
+		h.sendFailure(msg, "add_unable_add", err)
+
 		return
 	}
 
-	cypher1, _ := crypto.EncryptWithPub(&privkey.PublicKey, []byte(arr[1]))
-	cypher2, _ := crypto.EncryptWithPub(&privkey.PublicKey, []byte(arr[2]))
+	h.recordAudit(msg, audit.ActionAdd, audit.SecretKey(secret), "")
 
-	arr[1] = base58.Encode(cypher1)
-	arr[2] = base58.Encode(cypher2)
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "add_secret_added", localizator.Args{"ID": secret.ID}))
+}
 
-	err = h.TablesProvider.AddSecret(providers.SecretsData{
-		Description: arr[0],
-		Username:    arr[1],
-		Secret:      arr[2],
-	})
+func (h *Handler) parseNewSecret(msg *chat.Message, masterPass string) (providers.SecretsData, bool) {
+	arr, siteURL, ok := splitSecret(msg.Text)
+	if !ok {
+		h.sendMessage(msg, "Need 3 lines:\nDescription\nUser\nSecret\n\nTry repeat /set")
+
+		return providers.SecretsData{}, false
+	}
+
+	h.keymx.RLock()
+	privkey, err := getPrivkey(h.storage(msg), h.Config.Salt, masterPass, h.unlockers()...)
+	h.keymx.RUnlock()
 
 	if err != nil {
-		h.sendMessage(msg, "Error of appending new encrypted")
+		return providers.SecretsData{}, false
+	}
 
-		return
+	secret, err := encryptSecret(privkey, arr[0], arr[1], arr[2])
+	if err != nil {
+		h.logger(msg).Error(err.Error())
+
+		return providers.SecretsData{}, false
+	}
+
+	secret.URL = siteURL
+
+	return secret, true
+}
+
+// splitSecret splits the lines of a new secret into the description, the
+// username and the secret along with the optional site URL.
+func splitSecret(text string) ([]string, string, bool) {
+	arr := strings.Split(text, "\n")
+
+	if len(arr) < numbQueryColumns {
+		return nil, "", false
+	}
+
+	// The private keys are kept as a whole, the rest is one line per column.
+	if strings.HasPrefix(arr[2], pemPrefix) {
+		arr[2] = strings.Join(arr[2:], "\n")
+	}
+
+	// The optional fourth line is the site of the web credential.
+	siteURL := ""
+	if len(arr) > numbQueryColumns && !strings.HasPrefix(arr[2], pemPrefix) {
+		siteURL = strings.TrimSpace(arr[numbQueryColumns])
 	}
 
-	h.sendMessage(msg, "New secret appened")
+	return arr[:numbQueryColumns], siteURL, true
 }
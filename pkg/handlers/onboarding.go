@@ -0,0 +1,159 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/passwords"
+	"strings"
+)
+
+const (
+	masterPassMinLength = 12
+	// passphraseMinLength allows the long passphrases of lower case words.
+	passphraseMinLength = 20
+)
+
+const (
+	onboardIntro = iota
+	onboardEnter
+	onboardConfirm
+)
+
+// OnboardButton answers the onboarding introduction with "start" or "cancel".
+var OnboardButton = chat.Button{Unique: "onboard"}
+
+// onboarding is the guided setup of the master password of a new vault.
+type onboarding struct {
+	Step int
+	Pass string
+}
+
+// needsOnboarding reports whether the vault has no key yet, so the first
+// master password creates it.
+func (h *Handler) needsOnboarding(msg *chat.Message) bool {
+	key, err := h.storage(msg).GetKey()
+	if err != nil {
+		h.logger(msg).Error("Get key: " + err.Error())
+
+		return false
+	}
+
+	return key == ""
+}
+
+func (h *Handler) startOnboarding(msg *chat.Message) {
+	h.conversations.start(msg.Chat.ID, convOnboarding, &onboarding{Step: onboardIntro})
+
+	locale := msg.Sender.LanguageCode
+
+	start := OnboardButton
+	start.Text = h.Locales.Get(locale, "onboard_start_button")
+	start.Data = "start"
+
+	cancel := OnboardButton
+	cancel.Text = h.Locales.Get(locale, "onboard_cancel_button")
+	cancel.Data = "cancel"
+
+	h.sendMessageWithOptions(msg, h.Locales.Get(locale, "onboard_intro"), chat.Options{
+		Buttons: [][]chat.Button{{start, cancel}},
+	})
+}
+
+func (h *Handler) OnboardCallback(msg *chat.Message, c *chat.Callback) {
+	conv, _ := h.conversations.current(msg.Chat.ID)
+	if conv.Kind != convOnboarding {
+		return
+	}
+
+	if c.Data != "start" {
+		h.conversations.finish(msg.Chat.ID, convOnboarding)
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "onboard_canceled"))
+
+		return
+	}
+
+	conv.State.(*onboarding).Step = onboardEnter
+	h.conversations.touch(msg.Chat.ID, convOnboarding)
+	h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "onboard_enter_pass"))
+}
+
+// onboardStep handles the answer of the onboarding. The messages with the
+// password are deleted right away.
+func (h *Handler) onboardStep(msg *chat.Message, state *onboarding) {
+	locale := msg.Sender.LanguageCode
+
+	switch state.Step {
+	case onboardIntro:
+		h.startOnboarding(msg)
+
+		return
+	case onboardEnter:
+		h.deleteMessage(msg)
+
+		pass := strings.TrimSpace(msg.Text)
+		if !strongMasterPass(pass) {
+			h.sendMessage(msg, h.Locales.Format(locale, "onboard_weak_pass", localizator.Args{
+				"MinLength": masterPassMinLength, "PassphraseLength": passphraseMinLength,
+			}))
+
+			return
+		}
+
+		state.Pass = pass
+		state.Step = onboardConfirm
+		h.conversations.touch(msg.Chat.ID, convOnboarding)
+		h.sendMessage(msg, h.Locales.Get(locale, "onboard_confirm_pass"))
+
+		return
+	}
+
+	h.deleteMessage(msg)
+
+	if strings.TrimSpace(msg.Text) != state.Pass {
+		state.Pass = ""
+		state.Step = onboardEnter
+		h.sendMessage(msg, h.Locales.Get(locale, "onboard_mismatch"))
+
+		return
+	}
+
+	h.conversations.finish(msg.Chat.ID, convOnboarding)
+
+	if !h.openVault(msg, h.vaultName(msg), state.Pass) {
+		return
+	}
+
+	h.sendMessage(msg, h.Locales.Get(locale, "onboard_done"))
+}
+
+func (h *Handler) deleteMessage(msg *chat.Message) {
+	if err := h.Chat.DeleteMessage(msg.Chat.ID, msg.ID); err != nil {
+		h.logger(msg).Error("Unable to delete a message of the chat: "+err.Error(), "chat_id", msg.Chat.ID)
+	}
+}
+
+// strongMasterPass requires a long password of three character groups or a
+// longer passphrase, the well known passwords are rejected.
+func strongMasterPass(pass string) bool {
+	length := len([]rune(pass))
+
+	if length >= passphraseMinLength {
+		return passwords.CharGroups(pass) >= 2
+	}
+
+	return length >= masterPassMinLength && !passwords.IsWeak(pass)
+}
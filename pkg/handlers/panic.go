@@ -0,0 +1,133 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"strings"
+	"sync"
+)
+
+const panicPhrase = "WIPE THE VAULT"
+
+// Panic asks the admin to confirm wiping of the key. With the "purge"
+// argument all secrets are deleted as well.
+func (h *Handler) Panic(msg *chat.Message) {
+	purge := strings.TrimSpace(strings.TrimPrefix(msg.Text, "/panic")) == "purge"
+
+	h.panicstates.Store(msg.Chat.ID, purge)
+
+	key := "panic_confirm"
+	if purge {
+		key = "panic_confirm_purge"
+	}
+
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, key, localizator.Args{"Phrase": panicPhrase}))
+}
+
+func (h *Handler) ControlPanicMiddleware(isQuery bool, next func(m *chat.Message)) func(m *chat.Message) {
+	return func(msg *chat.Message) {
+		purge, ok := h.panicstates.Load(msg.Chat.ID)
+		h.panicstates.Delete(msg.Chat.ID)
+
+		if !isQuery || !ok {
+			next(msg)
+
+			return
+		}
+
+		if !h.isAdmin(msg.Chat.ID) || strings.TrimSpace(msg.Text) != panicPhrase {
+			h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "panic_canceled"))
+
+			return
+		}
+
+		h.wipe(msg, purge.(bool))
+	}
+}
+
+func (h *Handler) wipe(msg *chat.Message, purge bool) {
+	h.forgetPasses()
+	h.lockSlots()
+	h.endSession()
+	h.conversations.clear()
+	clearStates(&h.factorstates)
+	clearStates(&h.devicestates)
+	clearStates(&h.bulkstates)
+	clearStates(&h.links)
+	clearStates(&h.activevaults)
+	clearStates(&h.signedVaults)
+
+	ok := true
+
+	for _, storage := range h.vaultStorages(msg) {
+		if err := storage.SetKey(""); err != nil {
+			h.logger(msg).Error("Wipe key: " + err.Error())
+
+			ok = false
+		}
+
+		if !purge {
+			continue
+		}
+
+		if err := purgeSecrets(storage); err != nil {
+			h.logger(msg).Error("Purge secrets: " + err.Error())
+
+			ok = false
+		}
+	}
+
+	details := "key"
+	if purge {
+		details = "key,secrets"
+	}
+
+	h.recordAudit(msg, audit.ActionPanic, "", details)
+	h.logger(msg).Info("🧨 Vault wiped", "chat_id", msg.Chat.ID, "purge", purge)
+
+	if !ok {
+		h.sendError(msg, "panic_partially_wiped")
+
+		return
+	}
+
+	h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "panic_wiped"))
+}
+
+func purgeSecrets(storage providers.StorageProvider) error {
+	secrets, err := storage.GetSecrets()
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, len(secrets))
+	for i, secret := range secrets {
+		ids[i] = secret.StableID()
+	}
+
+	return storage.DeleteSecrets(ids)
+}
+
+func clearStates(m *sync.Map) {
+	m.Range(func(key, _ interface{}) bool {
+		m.Delete(key)
+
+		return true
+	})
+}
@@ -0,0 +1,271 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/config"
+	"secretable/pkg/crypto"
+	"secretable/pkg/keyslots"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"strings"
+
+	"github.com/mr-tron/base58/base58"
+	"github.com/pkg/errors"
+)
+
+const (
+	passChangeOld = iota
+	passChangeNew
+	passChangeConfirm
+)
+
+// passChange is the state of the /setpass flow.
+type passChange struct {
+	Step    int
+	NewPass string
+}
+
+// ResetPass starts the change of the master password. The passwords are asked
+// one by one in replies that are deleted right away, so they stay neither in
+// the chat history nor in the command autocomplete.
+func (h *Handler) ResetPass(msg *chat.Message) {
+	if strings.TrimSpace(strings.TrimPrefix(msg.Text, "/setpass")) != "" {
+		h.deleteMessage(msg)
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "setpass_no_args"))
+	}
+
+	h.conversations.start(msg.Chat.ID, convPassChange, &passChange{Step: passChangeOld})
+	h.sendForceReply(msg, h.Locales.Get(msg.Sender.LanguageCode, "setpass_enter_old"))
+}
+
+func (h *Handler) passChangeStep(msg *chat.Message, state *passChange) {
+	h.deleteMessage(msg)
+
+	locale := msg.Sender.LanguageCode
+	pass := strings.TrimSpace(msg.Text)
+
+	switch state.Step {
+	case passChangeOld:
+		if h.throttled(msg) {
+			h.conversations.finish(msg.Chat.ID, convPassChange)
+
+			return
+		}
+
+		if !h.checkOldPass(msg, pass) {
+			h.conversations.finish(msg.Chat.ID, convPassChange)
+			h.sendMessage(msg, h.Locales.Get(locale, "setpass_wrong_old"))
+
+			return
+		}
+
+		state.Step = passChangeNew
+		h.conversations.touch(msg.Chat.ID, convPassChange)
+		h.sendForceReply(msg, h.Locales.Get(locale, "setpass_enter_new"))
+
+		return
+	case passChangeNew:
+		if !strongMasterPass(pass) {
+			h.sendForceReply(msg, h.Locales.Format(locale, "onboard_weak_pass", localizator.Args{
+				"MinLength": masterPassMinLength, "PassphraseLength": passphraseMinLength,
+			}))
+
+			return
+		}
+
+		state.NewPass = pass
+		state.Step = passChangeConfirm
+		h.conversations.touch(msg.Chat.ID, convPassChange)
+		h.sendForceReply(msg, h.Locales.Get(locale, "setpass_confirm_new"))
+
+		return
+	}
+
+	if pass != state.NewPass {
+		state.NewPass = ""
+		state.Step = passChangeNew
+		h.sendForceReply(msg, h.Locales.Get(locale, "onboard_mismatch"))
+
+		return
+	}
+
+	h.conversations.finish(msg.Chat.ID, convPassChange)
+
+	if err := h.rewrapKey(msg, state.NewPass); err != nil {
+		h.logger(msg).Error("Rewrap key: " + err.Error())
+		h.sendFailure(msg, "setpass_unable_set", err)
+
+		return
+	}
+
+	h.recordAudit(msg, audit.ActionSetPass, "", "")
+	h.sendMessage(msg, h.Locales.Get(locale, "setpasspass_setted"))
+}
+
+// checkOldPass compares the password with the master password of the active
+// vault. The vault opened by another key slot has none, so the password is
+// checked by its slot, the vault is opened by the slots for the rewrap.
+func (h *Handler) checkOldPass(msg *chat.Message, pass string) bool {
+	if current := h.masterPass(msg); current != "" {
+		if subtle.ConstantTimeCompare([]byte(pass), []byte(current)) == 1 {
+			return true
+		}
+
+		h.decryptFailed(msg.Chat.ID, "setpass")
+
+		return false
+	}
+
+	if pass == "" {
+		return false
+	}
+
+	h.keymx.RLock()
+	_, ok, err := getPrivkeyAsBytes(h.storage(msg), h.Config.Salt, pass)
+	h.keymx.RUnlock()
+
+	if errors.Is(err, crypto.ErrDecrypt) {
+		h.decryptFailed(msg.Chat.ID, "setpass")
+	}
+
+	return err == nil && ok
+}
+
+// rewrapKey encrypts the private keys of the vaults with the new master
+// password and a new salt, the vaults without a key are skipped. Every vault
+// with a key is opened by its own master password or the key slots, so the
+// vaults left locked fail the rewrap before the salt changes. The keys are
+// stored before the config, if a key or the config can't be updated the old
+// keys are restored, so the vaults stay readable with one of the passwords.
+// The storage encrypted at rest is encrypted with the new password along with
+// the key.
+func (h *Handler) rewrapKey(msg *chat.Message, newMasterPass string) error {
+	h.keymx.Lock()
+	defer h.keymx.Unlock()
+
+	b, _ := crypto.MakeRandom(saltLength)
+	newSalt := base58.Encode(b)
+
+	var rewrapped []wrappedKey
+
+	for _, name := range h.vaultNames() {
+		key, err := h.rewrapVault(h.storageOf(msg, h.vaultStorage(name)), h.vaultPass(name), newSalt, newMasterPass)
+		if err != nil {
+			return h.restoreKeys(rewrapped, err)
+		}
+
+		if key.storage != nil {
+			key.vault = name
+			rewrapped = append(rewrapped, key)
+		}
+	}
+
+	if len(rewrapped) == 0 {
+		return ErrMissingKey
+	}
+
+	oldSalt := h.Config.Salt
+	h.Config.Salt = newSalt
+
+	if err := config.UpdateFile(h.Config); err != nil {
+		h.Config.Salt = oldSalt
+
+		return h.restoreKeys(rewrapped, errors.Wrap(err, "update config"))
+	}
+
+	for _, key := range rewrapped {
+		h.setVaultPass(key.vault, newMasterPass)
+	}
+
+	return nil
+}
+
+// wrappedKey is the key of a vault as it was before the rewrap, along with the
+// master password the vault was opened by.
+type wrappedKey struct {
+	storage providers.StorageProvider
+	vault   string
+	old     string
+	pass    string
+}
+
+// rewrapVault stores the key of the vault opened by the password encrypted with
+// the new password and salt, the returned storage is nil if the vault has no
+// key yet. The other key slots are kept.
+func (h *Handler) rewrapVault(storage providers.StorageProvider, pass, newSalt, newMasterPass string) (wrappedKey, error) {
+	oldKey, err := storage.GetKey()
+	if err != nil {
+		return wrappedKey{}, errors.Wrap(err, "get key")
+	}
+
+	privkeyBytes, ok, err := getPrivkeyAsBytes(storage, h.Config.Salt, pass, h.unlockers()...)
+	if err != nil {
+		return wrappedKey{}, err
+	}
+
+	if !ok {
+		return wrappedKey{}, nil
+	}
+
+	slots, err := keyslots.Parse(oldKey)
+	if err != nil {
+		return wrappedKey{}, errors.Wrap(err, "parse key slots")
+	}
+
+	slots, err = slots.Put(context.Background(), privkeyBytes, keyslots.NewPassword(newMasterPass, newSalt).WithKDF(h.kdf()))
+	if err != nil {
+		return wrappedKey{}, errors.Wrap(err, "encrypt with password")
+	}
+
+	if err = storage.Seal(newMasterPass); err != nil {
+		return wrappedKey{}, errors.Wrap(err, "seal storage")
+	}
+
+	if err = storage.SetKey(slots.String()); err != nil {
+		if restoreErr := storage.Seal(pass); restoreErr != nil {
+			return wrappedKey{}, errors.Wrap(restoreErr, "restore storage seal after the key update failed with "+err.Error())
+		}
+
+		return wrappedKey{}, errors.Wrap(err, "store encrypted key")
+	}
+
+	return wrappedKey{storage: storage, old: oldKey, pass: pass}, nil
+}
+
+// restoreKeys puts back the keys of the rewrapped vaults after the rewrap
+// failed with the error.
+func (h *Handler) restoreKeys(keys []wrappedKey, err error) error {
+	for _, key := range keys {
+		if restoreErr := key.storage.SetKey(key.old); restoreErr != nil {
+			return errors.Wrap(restoreErr, "restore key after the rewrap failed with "+err.Error())
+		}
+
+		if restoreErr := key.storage.Seal(key.pass); restoreErr != nil {
+			return errors.Wrap(restoreErr, "restore storage seal after the rewrap failed with "+err.Error())
+		}
+	}
+
+	return err
+}
+
+// sendForceReply asks for the answer as a reply to the message.
+func (h *Handler) sendForceReply(m *chat.Message, msg string) {
+	h.sendMessageWithOptions(m, msg, chat.Options{ForceReply: true})
+}
@@ -0,0 +1,217 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"path/filepath"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/crypto"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"time"
+
+	"github.com/mr-tron/base58/base58"
+	"github.com/pkg/errors"
+)
+
+// isPlaintext reports whether the stored secret is a row kept in the clear,
+// e.g. of a spreadsheet filled in by hand before the bot: it has no MAC and
+// neither the username nor the secret is a ciphertext, even a broken one.
+func isPlaintext(secret providers.SecretsData) bool {
+	if secret.MAC != "" {
+		return false
+	}
+
+	for _, field := range []string{secret.Username, secret.Secret} {
+		if cipher, err := base58.Decode(field); err == nil && crypto.IsCipher(cipher) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// BackupName returns the name of the backup of the storage taken before the
+// plaintext rows are encrypted.
+func BackupName(t time.Time) string {
+	return "plaintext-backup-" + t.UTC().Format("20060102-150405") + ".json"
+}
+
+// EncryptPlaintext encrypts the plaintext rows of the storage in place with the
+// key, the storage is copied to the json_file storage of the backup path
+// first. It returns the encrypted secrets keyed by the audit keys of their
+// plaintext rows.
+func EncryptPlaintext(privkey *ecdsa.PrivateKey, storage providers.StorageProvider, backupPath string) (map[string]providers.SecretsData, error) {
+	secrets, err := storage.GetSecrets()
+	if err != nil {
+		return nil, errors.Wrap(err, "get secrets")
+	}
+
+	var indexes []int
+
+	for index, secret := range secrets {
+		if isPlaintext(secret) {
+			indexes = append(indexes, index)
+		}
+	}
+
+	if len(indexes) == 0 {
+		return nil, nil
+	}
+
+	return encryptRows(privkey, storage, secrets, indexes, backupPath)
+}
+
+func encryptRows(privkey *ecdsa.PrivateKey, storage providers.StorageProvider, secrets []providers.SecretsData,
+	indexes []int, backupPath string,
+) (map[string]providers.SecretsData, error) {
+	if err := backupStorage(storage, secrets, backupPath); err != nil {
+		return nil, errors.Wrap(err, "backup storage")
+	}
+
+	encrypted := make([]providers.SecretsData, len(indexes))
+
+	for i, index := range indexes {
+		secret := secrets[index]
+
+		encSecret, err := encryptSecret(privkey, secret.Description, secret.Username, secret.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		encSecret.ID = secret.StableID()
+		encSecret.Owner = secret.Owner
+		encSecret.Type = secret.Type
+		encSecret.URL = secret.URL
+		encrypted[i] = signSecret(privkey, encSecret)
+	}
+
+	if err := storage.UpdateSecrets(encrypted); err != nil {
+		return nil, errors.Wrap(err, "update secrets")
+	}
+
+	rows := make(map[string]providers.SecretsData, len(indexes))
+	for i, index := range indexes {
+		rows[audit.SecretKey(secrets[index])] = encrypted[i]
+	}
+
+	return rows, nil
+}
+
+// backupStorage writes the secrets and the key of the storage to a new
+// json_file storage, the bot opens it as is in the json_file mode.
+func backupStorage(storage providers.StorageProvider, secrets []providers.SecretsData, path string) error {
+	key, err := storage.GetKey()
+	if err != nil {
+		return errors.Wrap(err, "get key")
+	}
+
+	backup, err := providers.NewJSONStorage(path)
+	if err != nil {
+		return err
+	}
+
+	if err = backup.AddSecrets(secrets); err != nil {
+		return errors.Wrap(err, "add secrets")
+	}
+
+	return errors.Wrap(backup.SetKey(key), "set key")
+}
+
+// EncryptExisting previews the plaintext rows of the vault and asks to
+// confirm their encryption.
+func (h *Handler) EncryptExisting(msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		h.logger(msg).Error("Get secrets: " + err.Error())
+		h.sendFailure(msg, "bulk_unable", err)
+
+		return
+	}
+
+	op := &bulkOperation{Action: bulkEncrypt, At: time.Now()}
+	op.Backup = filepath.Join(filepath.Dir(h.Config.AuditFile), BackupName(op.At))
+
+	var indexes []int
+
+	for index, secret := range secrets {
+		if isPlaintext(secret) {
+			op.Keys = append(op.Keys, audit.SecretKey(secret))
+			indexes = append(indexes, index)
+		}
+	}
+
+	if len(indexes) == 0 {
+		h.sendMessage(msg, h.Locales.Get(locale, "encrypt_no_plaintext"))
+
+		return
+	}
+
+	h.bulkstates.Store(msg.Chat.ID, op)
+
+	confirm := BulkButton
+	confirm.Text = h.Locales.Get(locale, "bulk_confirm_button")
+	confirm.Data = "confirm"
+
+	cancel := BulkButton
+	cancel.Text = h.Locales.Get(locale, "bulk_cancel_button")
+	cancel.Data = "cancel"
+
+	h.sendMessageWithOptions(msg, h.Locales.Format(locale, "encrypt_preview", localizator.Args{
+		"Count": len(indexes),
+		"IDs":   formatIDs(secrets, indexes),
+	}), chat.Options{Buttons: [][]chat.Button{{confirm, cancel}}})
+}
+
+// bulkEncrypt encrypts the plaintext rows of the operation which are still
+// stored in the clear.
+func (h *Handler) bulkEncrypt(msg *chat.Message, op *bulkOperation) (int, error) {
+	secrets, indexes, err := h.pendingSecrets(msg, op)
+	if err != nil {
+		return 0, err
+	}
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := indexes[:0]
+
+	for _, index := range indexes {
+		if isPlaintext(secrets[index]) {
+			pending = append(pending, index)
+		}
+	}
+
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	rows, err := encryptRows(privkey, h.storage(msg), secrets, pending, op.Backup)
+	if err != nil {
+		return 0, err
+	}
+
+	for key, secret := range rows {
+		h.recordAudit(msg, audit.ActionEdit, audit.SecretKey(secret), key)
+	}
+
+	return len(rows), nil
+}
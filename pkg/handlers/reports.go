@@ -0,0 +1,164 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/passwords"
+	"secretable/pkg/providers"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultPasswordMaxAge = 365 // in days
+
+func (h *Handler) AuditPasswords(msg *chat.Message) {
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		return
+	}
+
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		return
+	}
+
+	maxAgeDays := h.Config.PasswordMaxAgeDays
+	if maxAgeDays <= 0 {
+		maxAgeDays = defaultPasswordMaxAge
+	}
+
+	maxAge := time.Duration(maxAgeDays) * 24 * time.Hour
+
+	var (
+		reused  = make(map[string][]int)
+		old     []int
+		unknown []int
+		weak    []int
+		broken  []int
+		checked int
+	)
+
+	for index, secret := range secrets {
+		if !h.isVisible(msg, secret) {
+			continue
+		}
+
+		key := audit.SecretKey(secret)
+
+		decSecret, err := decryptSecret(privkey, secret, h.signed(msg))
+		if err != nil {
+			h.logger(msg).Error(err.Error())
+
+			broken = append(broken, index)
+
+			continue
+		}
+
+		checked++
+
+		if _, ok := h.fieldsOf(secret.Type); ok {
+			continue
+		}
+
+		reused[decSecret.Secret] = append(reused[decSecret.Secret], index)
+
+		if passwords.IsWeak(decSecret.Secret) {
+			weak = append(weak, index)
+		}
+
+		// The secrets without the add event in the audit log have no age,
+		// they are listed apart instead of passing as fresh.
+		added, ok := h.Audit.Added(key)
+
+		switch {
+		case !ok:
+			unknown = append(unknown, index)
+		case time.Since(added) > maxAge:
+			old = append(old, index)
+		}
+	}
+
+	var groups [][]int
+
+	for _, indexes := range reused {
+		if len(indexes) > 1 {
+			groups = append(groups, indexes)
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i]) != len(groups[j]) {
+			return len(groups[i]) > len(groups[j])
+		}
+
+		return groups[i][0] < groups[j][0]
+	})
+
+	locale := msg.Sender.LanguageCode
+
+	var bld strings.Builder
+
+	bld.WriteString(h.Locales.Format(locale, "audit_passwords_header", localizator.Args{"Count": checked}))
+
+	bld.WriteString("\n\n<b>" + h.Locales.Get(locale, "audit_passwords_reused") + "</b>\n")
+
+	if len(groups) == 0 {
+		bld.WriteString(h.Locales.Get(locale, "audit_passwords_none") + "\n")
+	}
+
+	for _, indexes := range groups {
+		bld.WriteString(formatIDs(secrets, indexes) + "\n")
+	}
+
+	bld.WriteString("\n<b>" + h.Locales.Format(locale, "audit_passwords_old", localizator.Args{"Days": maxAgeDays}) + "</b>\n")
+	bld.WriteString(formatIDsOrNone(secrets, old, h.Locales.Get(locale, "audit_passwords_none")) + "\n")
+
+	if len(unknown) > 0 {
+		bld.WriteString("\n<b>" + h.Locales.Get(locale, "audit_passwords_unknown") + "</b>\n")
+		bld.WriteString(formatIDs(secrets, unknown) + "\n")
+	}
+
+	bld.WriteString("\n<b>" + h.Locales.Get(locale, "audit_passwords_weak") + "</b>\n")
+	bld.WriteString(formatIDsOrNone(secrets, weak, h.Locales.Get(locale, "audit_passwords_none")) + "\n")
+
+	if len(broken) > 0 {
+		bld.WriteString("\n<b>" + h.Locales.Get(locale, "audit_passwords_broken") + "</b>\n")
+		bld.WriteString(formatIDs(secrets, broken) + "\n")
+	}
+
+	h.sendMessage(msg, bld.String())
+}
+
+// formatIDs lists the IDs of the secrets at the positions.
+func formatIDs(secrets []providers.SecretsData, indexes []int) string {
+	parts := make([]string, len(indexes))
+	for i, index := range indexes {
+		parts[i] = "(" + secrets[index].StableID() + ")"
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func formatIDsOrNone(secrets []providers.SecretsData, indexes []int, none string) string {
+	if len(indexes) == 0 {
+		return none
+	}
+
+	return formatIDs(secrets, indexes)
+}
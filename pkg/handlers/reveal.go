@@ -0,0 +1,82 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"secretable/pkg/chat"
+	"secretable/pkg/providers"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNeedsApproval and ErrNeedsConfirmation are the secrets revealed to
+	// the chat only after an approver or the confirm button.
+	ErrNeedsApproval     = errors.New("secret needs an approval")
+	ErrNeedsConfirmation = errors.New("secret needs a confirmation")
+	// ErrRevealLimit is the reveal limit reached by the chat, the chat is told
+	// when the next reveal is allowed.
+	ErrRevealLimit = errors.New("reveal limit reached")
+)
+
+// revealMode tells how much of the secret the reveal shows.
+type revealMode int
+
+const (
+	// revealDisplayed is the secret shown by sendSecret, the masked display
+	// asks to confirm the password when it is revealed.
+	revealDisplayed revealMode = iota
+	// revealWhole shows all the values of the secret at once, e.g. a share
+	// or a link.
+	revealWhole
+	// revealConfirmed is the reveal of the confirm button.
+	revealConfirmed
+)
+
+// checkReveal reports why the secret isn't revealed to the chat right away,
+// nil if it is. The confirmation goes first, so an approved secret is sent
+// without asking again.
+func (h *Handler) checkReveal(msg *chat.Message, secret providers.SecretsData, mode revealMode) error {
+	deferred := mode == revealDisplayed && h.displaysMasked(secret)
+
+	if mode != revealConfirmed && !deferred && h.needsConfirmation(secret) {
+		return ErrNeedsConfirmation
+	}
+
+	if h.needsApproval(msg, secret) {
+		return ErrNeedsApproval
+	}
+
+	return nil
+}
+
+// gateReveal checks the secret and the reveal limit before the secret is
+// decrypted for the chat, the chat is asked for the approval or the
+// confirmation the secret needs. The paths which can't ask, e.g. Slack or the
+// .env export, leave out the secrets failing checkReveal instead.
+func (h *Handler) gateReveal(msg *chat.Message, secret providers.SecretsData, mode revealMode) error {
+	err := h.checkReveal(msg, secret, mode)
+
+	switch {
+	case errors.Is(err, ErrNeedsConfirmation):
+		h.requestConfirmation(msg, secret)
+	case errors.Is(err, ErrNeedsApproval):
+		h.requestApproval(msg, secret)
+	case err == nil && !h.allowReveal(msg, 1):
+		return ErrRevealLimit
+	}
+
+	return err
+}
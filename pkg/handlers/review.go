@@ -0,0 +1,242 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"html"
+	"secretable/pkg/audit"
+	"secretable/pkg/backup"
+	"secretable/pkg/chat"
+	"secretable/pkg/config"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultReviewSchedule sends the access review on the first day of
+	// every month.
+	DefaultReviewSchedule = "0 9 1 * *"
+
+	defaultStaleDays = 90
+	numbMostRevealed = 10
+)
+
+// reviewedUser is a line of the access review.
+type reviewedUser struct {
+	Name string
+	Role string
+	Seen time.Time
+	// Group is set for the groups, the activity of the members isn't kept.
+	Group bool
+	// Stale is set if the user has no activity for the stale days.
+	Stale bool
+}
+
+// StartAccessReviews sends the access review to the admins on the schedule
+// until the context is done.
+func (h *Handler) StartAccessReviews(ctx context.Context, schedule backup.Schedule) {
+	go func() {
+		for {
+			next := schedule.Next(time.Now())
+			if next.IsZero() {
+				log.Error("Access review schedule never fires", "schedule", h.Config.AccessReview.Schedule)
+
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(next)):
+			}
+
+			h.notifyAdmins(0, h.accessReview("en"))
+			log.Info("📋 Access review sent")
+		}
+	}()
+}
+
+// Review sends the access review to the admin now.
+func (h *Handler) Review(msg *chat.Message) {
+	h.sendMessage(msg, h.accessReview(msg.Sender.LanguageCode))
+}
+
+// accessReview summarizes the users of the allowed list, the admins and the
+// joined chats with their roles and the latest activity, the secrets
+// revealed the most and the stale users, all from the audit log.
+func (h *Handler) accessReview(locale string) string {
+	staleDays := h.Config.AccessReview.StaleDays
+	if staleDays <= 0 {
+		staleDays = defaultStaleDays
+	}
+
+	users := h.reviewedUsers(locale, time.Duration(staleDays)*24*time.Hour)
+
+	var bld strings.Builder
+
+	bld.WriteString(h.Locales.Format(locale, "review_title", localizator.Args{"Count": len(users)}))
+
+	var stale []string
+
+	for _, user := range users {
+		bld.WriteString("\n" + h.Locales.Format(locale, "review_user", localizator.Args{
+			"Name": user.Name, "Role": user.Role, "Seen": user.Seen, "Group": user.Group,
+		}))
+
+		if user.Stale {
+			stale = append(stale, user.Name)
+		}
+	}
+
+	bld.WriteString("\n\n" + h.Locales.Get(locale, "review_most_revealed"))
+
+	revealed := h.mostRevealed()
+	for _, secret := range revealed {
+		bld.WriteString("\n" + h.Locales.Format(locale, "review_secret", localizator.Args{
+			"ID":          secret.StableID(),
+			"Description": html.EscapeString(secret.Description),
+			"Count":       h.Audit.Usage(audit.SecretKey(secret)).Count,
+		}))
+	}
+
+	if len(revealed) == 0 {
+		bld.WriteString("\n" + h.Locales.Get(locale, "review_none"))
+	}
+
+	bld.WriteString("\n\n" + h.Locales.Format(locale, "review_stale", localizator.Args{"Days": staleDays}))
+
+	if len(stale) == 0 {
+		bld.WriteString("\n" + h.Locales.Get(locale, "review_none"))
+	} else {
+		bld.WriteString("\n" + strings.Join(stale, ", "))
+	}
+
+	return bld.String()
+}
+
+// reviewedUsers returns the entries of the allowed list followed by the joined
+// chats and the admins out of the list. The members of the groups aren't
+// known, so a group is a single reader.
+func (h *Handler) reviewedUsers(locale string, staleAge time.Duration) []reviewedUser {
+	var (
+		users  []reviewedUser
+		listed = make(map[int64]bool)
+	)
+
+	add := func(name string, chatID int64, role Role, known bool) {
+		user := reviewedUser{Name: name, Role: h.Locales.Get(locale, roleKeys[role])}
+		if known {
+			user.Seen = h.Audit.LastSeen(chatID)
+			user.Stale = time.Since(user.Seen) > staleAge
+		}
+
+		users = append(users, user)
+	}
+
+	for _, a := range h.Config.AllowedList {
+		switch a.Kind {
+		case config.AllowedUsername:
+			chatID, known := h.Audit.ChatByUsername(a.Username)
+			add("@"+html.EscapeString(a.Username), chatID, h.staticRole(chatID, RoleMember), known)
+
+			listed[chatID] = known
+		case config.AllowedGroup:
+			users = append(users, reviewedUser{
+				Name:  "group:" + strconv.FormatInt(a.ID, 10),
+				Role:  h.Locales.Get(locale, roleKeys[RoleReader]),
+				Group: true,
+			})
+		default:
+			name := strconv.FormatInt(a.ID, 10)
+			if a.Kind == config.AllowedUser {
+				name = "user:" + name
+			}
+
+			add(name, a.ID, h.staticRole(a.ID, RoleMember), true)
+
+			listed[a.ID] = true
+		}
+	}
+
+	for _, chatID := range h.Audit.JoinedChats() {
+		if !listed[chatID] {
+			add(strconv.FormatInt(chatID, 10), chatID, h.staticRole(chatID, h.joinedRole(chatID)), true)
+
+			listed[chatID] = true
+		}
+	}
+
+	for _, chatID := range h.Config.AdminList {
+		if !listed[chatID] {
+			add(strconv.FormatInt(chatID, 10), chatID, RoleAdmin, true)
+		}
+	}
+
+	return users
+}
+
+// staticRole returns the role of the chat without the /sudo elevations.
+func (h *Handler) staticRole(chatID int64, role Role) Role {
+	if h.isAdmin(chatID) {
+		return RoleAdmin
+	}
+
+	return role
+}
+
+// mostRevealed returns the secrets of all the vaults and the archive revealed
+// the most, the deleted ones are left out.
+func (h *Handler) mostRevealed() []providers.SecretsData {
+	storages := make([]providers.StorageProvider, 0, len(h.Vaults)+2)
+	for _, name := range h.vaultNames() {
+		storages = append(storages, h.vaultStorage(name))
+	}
+
+	if h.ArchiveProvider != nil {
+		storages = append(storages, h.ArchiveProvider)
+	}
+
+	found := make(map[string]providers.SecretsData)
+
+	for _, storage := range storages {
+		err := storage.Secrets(func(_ int, secret providers.SecretsData) bool {
+			found[audit.SecretKey(secret)] = secret
+
+			return true
+		})
+		if err != nil {
+			log.Error("Get secrets for access review: " + err.Error())
+		}
+	}
+
+	var secrets []providers.SecretsData
+
+	for _, key := range h.Audit.MostRevealed(0) {
+		if secret, ok := found[key]; ok {
+			secrets = append(secrets, secret)
+		}
+
+		if len(secrets) == numbMostRevealed {
+			break
+		}
+	}
+
+	return secrets
+}
@@ -0,0 +1,185 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	rotationCheckInterval  = time.Hour
+	rotationRemindInterval = 24 * time.Hour
+)
+
+// RotateButton starts the edit flow of the secret from a rotation reminder.
+var RotateButton = chat.Button{Unique: "rotate"}
+
+// Rotate sets the rotation period of the secret in days, 0 disables reminders.
+func (h *Handler) Rotate(msg *chat.Message) {
+	args := strings.Fields(strings.TrimPrefix(msg.Text, "/rotate"))
+	if len(args) != 2 {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "rotate_wrong_format"))
+
+		return
+	}
+
+	days, err := strconv.Atoi(args[1])
+	if err != nil || days < 0 {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "rotate_wrong_format"))
+
+		return
+	}
+
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "rotate_wrong_format"))
+
+		return
+	}
+
+	index := h.findVisible(msg, secrets, args[0])
+	if index < 0 {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "rotate_wrong_format"))
+
+		return
+	}
+
+	h.recordAudit(msg, audit.ActionPolicy, audit.SecretKey(secrets[index]), strconv.Itoa(days))
+
+	if days == 0 {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "rotate_disabled"))
+
+		return
+	}
+
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "rotate_policy_set", localizator.Args{"Days": days}))
+}
+
+func (h *Handler) RotateCallback(msg *chat.Message, c *chat.Callback) {
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		return
+	}
+
+	index := findSecret(secrets, c.Data)
+	if index < 0 || !h.isVisible(msg, secrets[index]) {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "edit_secret_not_found"))
+
+		return
+	}
+
+	h.startEdit(msg, secrets[index])
+}
+
+// StartRotationReminders runs the background check of the rotation policies.
+func (h *Handler) StartRotationReminders() {
+	go func() {
+		for {
+			h.checkRotation()
+			time.Sleep(rotationCheckInterval)
+		}
+	}()
+}
+
+func (h *Handler) checkRotation() {
+	err := h.TablesProvider.Secrets(func(_ int, secret providers.SecretsData) bool {
+		h.checkSecretRotation(secret)
+
+		return true
+	})
+	if err != nil {
+		log.Error("Get secrets for rotation check: " + err.Error())
+	}
+}
+
+func (h *Handler) checkSecretRotation(secret providers.SecretsData) {
+	key := audit.SecretKey(secret)
+
+	days := h.rotationDays(secret, key)
+	if days <= 0 {
+		return
+	}
+
+	changed, ok := h.Audit.Added(key)
+	if !ok {
+		// Start counting from now for the secrets added before auditing.
+		h.writeAudit(audit.Event{Action: audit.ActionAdd, SecretKey: key, Details: "discovered"})
+
+		return
+	}
+
+	if time.Since(changed) < time.Duration(days)*24*time.Hour ||
+		time.Since(h.Audit.Reminded(key)) < rotationRemindInterval {
+		return
+	}
+
+	h.remindRotation(secret, key, changed, days)
+}
+
+func (h *Handler) remindRotation(secret providers.SecretsData, key string, changed time.Time, days int) {
+	recipients := []int64{secret.Owner}
+	if secret.Owner == 0 {
+		recipients = h.Config.AdminList
+	}
+
+	btn := RotateButton
+	btn.Text = h.Locales.Get("en", "rotate_button")
+	btn.Data = key
+
+	text := h.Locales.Format("en", "rotate_reminder", localizator.Args{
+		"ID":          secret.StableID(),
+		"Description": html.EscapeString(secret.Description),
+		"Age":         int(time.Since(changed).Hours() / 24),
+		"Days":        days,
+	})
+
+	for _, chatID := range recipients {
+		_, err := h.Chat.SendMessage(chatID, text, chat.Options{
+			Buttons: [][]chat.Button{{btn}},
+			Notify:  true,
+		})
+		if err != nil {
+			log.Error("Unable to send a rotation reminder: "+err.Error(), "chat_id", chatID)
+		}
+	}
+
+	h.writeAudit(audit.Event{Action: audit.ActionRemind, SecretKey: key})
+}
+
+// rotationDays returns the rotation period of the secret: the policy set by
+// /rotate or the shortest period of its tags.
+func (h *Handler) rotationDays(secret providers.SecretsData, key string) int {
+	if days, ok := h.Audit.Policy(key); ok {
+		return days
+	}
+
+	days := 0
+
+	for _, tag := range secret.Tags() {
+		if d, ok := h.Config.RotationDays[tag]; ok && d > 0 && (days == 0 || d < days) {
+			days = d
+		}
+	}
+
+	return days
+}
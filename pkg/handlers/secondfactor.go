@@ -0,0 +1,131 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/totp"
+	"strings"
+	"time"
+)
+
+const secondFactorTimeout = 2 * time.Minute
+
+// defaultProtectedCommands ask for the second factor unless the config lists
+// the commands.
+var defaultProtectedCommands = []string{"delete", "deleteall", "setpass", "env", "share", "link"}
+
+// pendingCommand is the protected command waiting for the TOTP code.
+type pendingCommand struct {
+	Msg  *chat.Message
+	Next func(m *chat.Message)
+	At   time.Time
+}
+
+// SecondFactorMiddleware holds the protected commands until the TOTP code of
+// the chat is sent, the code is received by the query endpoint.
+func (h *Handler) SecondFactorMiddleware(endpoint string, isQuery bool, next func(m *chat.Message)) func(m *chat.Message) {
+	protected := h.isProtected(endpoint)
+
+	return func(msg *chat.Message) {
+		pending, ok := h.factorstates.Load(msg.Chat.ID)
+		h.factorstates.Delete(msg.Chat.ID)
+
+		if isQuery && ok {
+			h.confirmSecondFactor(msg, pending.(*pendingCommand))
+
+			return
+		}
+
+		if !protected {
+			next(msg)
+
+			return
+		}
+
+		if _, ok := h.Config.SecondFactor.TOTPSecrets[msg.Chat.ID]; !ok {
+			h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "second_factor_not_enrolled"))
+
+			return
+		}
+
+		h.factorstates.Store(msg.Chat.ID, &pendingCommand{Msg: msg, Next: next, At: time.Now()})
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "second_factor_enter_code"))
+	}
+}
+
+func (h *Handler) confirmSecondFactor(msg *chat.Message, pending *pendingCommand) {
+	h.deleteMessage(msg)
+
+	locale := msg.Sender.LanguageCode
+	command := strings.Fields(pending.Msg.Text)[0]
+
+	if time.Since(pending.At) > secondFactorTimeout {
+		h.sendMessage(msg, h.Locales.Get(locale, "second_factor_expired"))
+
+		return
+	}
+
+	// The wrong codes count as the failures of the chat, so the code is not
+	// guessed by retrying the command.
+	if h.throttled(msg) {
+		return
+	}
+
+	step, ok := totp.Validate(h.Config.SecondFactor.TOTPSecrets[msg.Chat.ID], msg.Text, time.Now())
+
+	if last, used := h.totpsteps.Load(msg.Chat.ID); ok && used && step <= last.(int64) {
+		ok = false
+	}
+
+	if !ok {
+		h.recordAudit(msg, audit.ActionSecondFactor, "", command+":rejected")
+		h.decryptFailed(msg.Chat.ID, "second_factor")
+		h.sendMessage(msg, h.Locales.Get(locale, "second_factor_wrong_code"))
+
+		return
+	}
+
+	h.totpsteps.Store(msg.Chat.ID, step)
+	h.recordAudit(msg, audit.ActionSecondFactor, "", command+":accepted")
+
+	// The command runs within the request of the code.
+	h.contexts.Store(pending.Msg, h.context(msg))
+	defer h.contexts.Delete(pending.Msg)
+
+	pending.Next(pending.Msg)
+}
+
+// isProtected reports whether the command needs the second factor, it is
+// disabled while no chat has a TOTP secret.
+func (h *Handler) isProtected(endpoint string) bool {
+	if len(h.Config.SecondFactor.TOTPSecrets) == 0 {
+		return false
+	}
+
+	commands := h.Config.SecondFactor.Commands
+	if len(commands) == 0 {
+		commands = defaultProtectedCommands
+	}
+
+	for _, command := range commands {
+		if "/"+strings.TrimPrefix(command, "/") == endpoint {
+			return true
+		}
+	}
+
+	return false
+}
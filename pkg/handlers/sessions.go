@@ -0,0 +1,86 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"time"
+)
+
+// unlockSession describes who has entered the master password.
+type unlockSession struct {
+	ChatID int64
+	Name   string
+	At     time.Time
+}
+
+func (h *Handler) Sessions(msg *chat.Message) {
+	s, ok := h.currentSession()
+	if !ok {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "sessions_locked"))
+
+		return
+	}
+
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "sessions_unlocked", sessionArgs(s)))
+}
+
+func (h *Handler) startSession(msg *chat.Message) {
+	s := unlockSession{
+		ChatID: msg.Chat.ID,
+		Name:   senderName(msg),
+		At:     time.Now(),
+	}
+
+	h.sessionmx.Lock()
+	h.session = s
+	h.sessionmx.Unlock()
+
+	h.recordAudit(msg, audit.ActionUnlock, "", "")
+	h.notifyAdmins(msg.Chat.ID, h.Locales.Format("en", "sessions_notify_unlock", sessionArgs(s)))
+}
+
+func (h *Handler) endSession() {
+	h.sessionmx.Lock()
+	h.session = unlockSession{}
+	h.sessionmx.Unlock()
+}
+
+func (h *Handler) currentSession() (unlockSession, bool) {
+	h.sessionmx.RLock()
+	defer h.sessionmx.RUnlock()
+
+	return h.session, !h.session.At.IsZero()
+}
+
+func sessionArgs(s unlockSession) localizator.Args {
+	return localizator.Args{"Name": s.Name, "ChatID": s.ChatID, "At": s.At}
+}
+
+// notifyAdmins sends the text to every admin chat except the source one.
+func (h *Handler) notifyAdmins(except int64, text string) {
+	for _, admin := range h.Config.AdminList {
+		if admin == except {
+			continue
+		}
+
+		if _, err := h.Chat.SendMessage(admin, text, chat.Options{Notify: true}); err != nil {
+			log.Error("Unable to notify admin: "+err.Error(), "chat_id", admin)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultShareDuration = time.Hour
+	// Telegram allows bots to delete messages only within 48 hours.
+	maxShareDuration = 48 * time.Hour
+)
+
+var ErrInvalidDuration = errors.New("invalid duration")
+
+func (h *Handler) Share(msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+	args := strings.Fields(strings.TrimPrefix(msg.Text, "/share"))
+
+	if len(args) < 2 || len(args) > 3 {
+		h.sendMessage(msg, h.Locales.Get(locale, "share_wrong_format"))
+
+		return
+	}
+
+	duration := defaultShareDuration
+
+	if len(args) == 3 {
+		var err error
+		if duration, err = parseDuration(args[2]); err != nil || duration > maxShareDuration {
+			h.sendMessage(msg, h.Locales.Get(locale, "share_wrong_duration"))
+
+			return
+		}
+	}
+
+	recipient, ok := h.resolveChat(args[1])
+	if !ok || !h.isAllowed(recipient) {
+		h.sendMessage(msg, h.Locales.Get(locale, "share_recipient_not_allowed"))
+
+		return
+	}
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		return
+	}
+
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		h.sendMessage(msg, h.Locales.Get(locale, "share_wrong_format"))
+
+		return
+	}
+
+	index := h.findVisible(msg, secrets, args[0])
+	if index < 0 {
+		h.sendMessage(msg, h.Locales.Get(locale, "share_wrong_format"))
+
+		return
+	}
+
+	h.shareSecret(msg, privkey, secrets[index], recipient, duration, revealWhole)
+}
+
+// confirmShare shares the secret of the confirm button, the arguments are the
+// recipient and the duration in seconds.
+func (h *Handler) confirmShare(msg *chat.Message, privkey *ecdsa.PrivateKey, secret providers.SecretsData, args []string) {
+	if len(args) != 2 {
+		return
+	}
+
+	recipient, err := strconv.ParseInt(args[0], 10, 64)
+	seconds, durErr := strconv.ParseInt(args[1], 10, 64)
+
+	if err != nil || durErr != nil || !h.isAllowed(recipient) {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "share_recipient_not_allowed"))
+
+		return
+	}
+
+	h.shareSecret(msg, privkey, secret, recipient, time.Duration(seconds)*time.Second, revealConfirmed)
+}
+
+// shareSecret sends the secret to the recipient until the duration ends, the
+// secret of an environment with the confirmation is shared by the confirm
+// button.
+func (h *Handler) shareSecret(msg *chat.Message, privkey *ecdsa.PrivateKey, secret providers.SecretsData,
+	recipient int64, duration time.Duration, mode revealMode,
+) {
+	locale := msg.Sender.LanguageCode
+
+	switch err := h.checkReveal(msg, secret, mode); {
+	case errors.Is(err, ErrNeedsConfirmation):
+		h.askConfirmation(msg, secret, confirmShare+"|"+audit.SecretKey(secret)+"|"+
+			strconv.FormatInt(recipient, 10)+"|"+strconv.FormatInt(int64(duration/time.Second), 10))
+
+		return
+	case errors.Is(err, ErrNeedsApproval):
+		h.requestApproval(msg, secret)
+
+		return
+	}
+
+	if !h.allowReveal(msg, 1) {
+		return
+	}
+
+	decSecret, err := decryptSecret(privkey, secret, h.signed(msg))
+	if err != nil {
+		h.logger(msg).Error(err.Error())
+		h.sendFailure(msg, "share_unable_share", err)
+
+		return
+	}
+
+	expires := time.Now().Add(duration)
+
+	resp, err := h.Chat.SendMessage(recipient,
+		h.Locales.Format(locale, "share_received", localizator.Args{
+			"Sender": senderName(msg), "Expires": expires,
+		})+"\n\n"+h.formatSecret(locale, secret.StableID(), decSecret),
+		chat.Options{},
+	)
+	if err != nil {
+		h.logger(msg).Error("Unable to send a shared secret: "+err.Error(), "chat_id", recipient)
+		h.sendError(msg, "share_unable_share")
+
+		return
+	}
+
+	h.recordAuditEvent(msg, audit.Event{
+		Action:    audit.ActionShare,
+		SecretKey: audit.SecretKey(secret),
+		Target:    recipient,
+		MessageID: resp.ID,
+		Expires:   &expires,
+	})
+
+	go h.expireGrant(audit.Grant{
+		From:      msg.Chat.ID,
+		To:        recipient,
+		MessageID: resp.ID,
+		Expires:   expires,
+	})
+
+	h.sendMessage(msg, h.Locales.Format(locale, "share_sent", localizator.Args{"Expires": expires}))
+}
+
+// RestoreGrants schedules the expiration of the grants left from the previous run.
+func (h *Handler) RestoreGrants() {
+	for _, g := range h.Audit.Grants() {
+		go h.expireGrant(g)
+	}
+}
+
+func (h *Handler) expireGrant(g audit.Grant) {
+	time.Sleep(time.Until(g.Expires))
+
+	err := h.Chat.DeleteMessage(g.To, g.MessageID)
+	if err != nil {
+		log.Error("Unable to delete a shared secret: "+err.Error(), "chat_id", g.To)
+	}
+
+	h.writeAudit(audit.Event{
+		ChatID:    g.From,
+		Action:    audit.ActionExpire,
+		Target:    g.To,
+		MessageID: g.MessageID,
+	})
+}
+
+func (h *Handler) resolveChat(recipient string) (int64, bool) {
+	if strings.HasPrefix(recipient, "@") {
+		return h.Audit.ChatByUsername(recipient)
+	}
+
+	id, err := strconv.ParseInt(recipient, 10, 64)
+
+	return id, err == nil
+}
+
+func senderName(msg *chat.Message) string {
+	if msg.Sender.Username != "" {
+		return "@" + msg.Sender.Username
+	}
+
+	return strings.TrimSpace(msg.Sender.FirstName + " " + msg.Sender.LastName)
+}
+
+// parseDuration extends time.ParseDuration with the days suffix, e.g. "2d".
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, ErrInvalidDuration
+		}
+
+		s = strconv.Itoa(days*24) + "h"
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, ErrInvalidDuration
+	}
+
+	return d, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"strings"
+	"time"
+)
+
+const sharingCheckInterval = 10 * time.Minute
+
+// sharingChecker is the storage which checks who the spreadsheet is shared
+// with.
+type sharingChecker interface {
+	CheckSharing() (drift, revoked []string, err error)
+}
+
+// StartSharingChecks runs the background check of the Drive permissions of the
+// vault spreadsheets. The admins are alerted of the revoked permissions and
+// once the permissions left beyond the allowed accounts change.
+func (h *Handler) StartSharingChecks() {
+	reported := make(map[string]string)
+
+	go func() {
+		for {
+			h.checkSharing(reported)
+			time.Sleep(sharingCheckInterval)
+		}
+	}()
+}
+
+func (h *Handler) checkSharing(reported map[string]string) {
+	for _, name := range h.vaultNames() {
+		checker, ok := h.vaultStorage(name).(sharingChecker)
+		if !ok {
+			continue
+		}
+
+		drift, revoked, err := checker.CheckSharing()
+		if err != nil {
+			log.Error("Check sharing: "+err.Error(), "vault", name)
+		}
+
+		if len(revoked) > 0 {
+			h.alertSharing(name, "sharing_revoked", revoked)
+		}
+
+		permissions := strings.Join(drift, ", ")
+		if permissions == reported[name] {
+			continue
+		}
+
+		reported[name] = permissions
+
+		if permissions != "" {
+			h.alertSharing(name, "sharing_drift", drift)
+		}
+	}
+}
+
+func (h *Handler) alertSharing(vault, key string, permissions []string) {
+	log.Info("⚠️ Spreadsheet is shared beyond the allowed accounts",
+		"vault", vault, "permissions", strings.Join(permissions, ", "), "revoked", key == "sharing_revoked")
+
+	h.notifyAdmins(0, h.Locales.Format("en", key, localizator.Args{
+		"Vault":       html.EscapeString(vault),
+		"Permissions": html.EscapeString(strings.Join(permissions, ", ")),
+	}))
+}
@@ -0,0 +1,349 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+	"secretable/pkg/slack"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	slackAddView = "secretable_add"
+
+	// slackMaxBlocks is the limit of the blocks of a Slack message.
+	slackMaxBlocks = 50
+)
+
+// ServeSlack serves the slash command and the modal of the Slack app until
+// the context is done.
+func (h *Handler) ServeSlack(ctx context.Context) {
+	h.Slack.OnCommand = h.slackCommand
+	h.Slack.OnInteraction = h.slackSubmit
+
+	h.Slack.Run(ctx)
+}
+
+// slackMessage returns the message of the chat the Slack user is linked to, so
+// the access, the vault and the audit of the chat apply to the user.
+func (h *Handler) slackMessage(userID string) (*chat.Message, bool) {
+	chatID, ok := h.Config.Slack.Users[userID]
+	if !ok || !h.isAllowed(chatID) {
+		return nil, false
+	}
+
+	return &chat.Message{
+		Chat:   &chat.Chat{ID: chatID},
+		Sender: &chat.User{ID: chatID},
+		Text:   "[slack]",
+	}, true
+}
+
+// slackCommand handles "search <query>" or just the query, "add" and
+// "generate [length]", the responses are seen only by the user.
+func (h *Handler) slackCommand(cmd slack.Command) interface{} {
+	msg, ok := h.slackMessage(cmd.UserID)
+	if !ok {
+		go h.slackRespond(cmd, h.Locales.Get("", "slack_forbidden"))
+
+		return nil
+	}
+
+	if text := h.getMaintenance(); text != "" && !h.isAdmin(msg.Chat.ID) {
+		go h.slackRespond(cmd, text)
+
+		return nil
+	}
+
+	args := strings.Fields(cmd.Text)
+
+	switch {
+	case len(args) == 0:
+		go h.slackRespond(cmd, h.Locales.Format("", "slack_usage", localizator.Args{"Command": cmd.Command}))
+	case args[0] == "add":
+		go h.slackOpenAdd(msg, cmd)
+	case args[0] == "generate":
+		length := 0
+		if len(args) > 1 {
+			length, _ = strconv.Atoi(args[1])
+		}
+
+		if length <= 0 || length > 128 {
+			length = 16
+		}
+
+		go h.slackRespond(cmd, generatePassword(length))
+	case args[0] == "search":
+		go h.slackSearch(msg, cmd, strings.Join(args[1:], " "))
+	default:
+		go h.slackSearch(msg, cmd, strings.Join(args, " "))
+	}
+
+	return nil
+}
+
+func (h *Handler) slackSearch(msg *chat.Message, cmd slack.Command, query string) {
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		h.slackRespond(cmd, h.Locales.Get("", "webapp_locked"))
+
+		return
+	}
+
+	secrets, err := h.querySecrets(msg, strings.ToLower(query))
+	if err != nil {
+		h.logger(msg).Error("Query secrets: " + err.Error())
+		h.slackRespond(cmd, h.Locales.Get("", "webapp_unable_load"))
+
+		return
+	}
+
+	var (
+		texts   []string
+		leftOut []string
+		limited time.Time
+	)
+
+	// The notes of the left out secrets and of the limit take the last blocks.
+	for _, secret := range secrets {
+		if len(texts) == slackMaxBlocks-2 {
+			break
+		}
+
+		// Slack can't ask for the approval or the confirmation, the secrets
+		// are revealed in the chat instead.
+		if h.checkReveal(msg, secret, revealWhole) != nil {
+			leftOut = append(leftOut, secret.StableID())
+
+			continue
+		}
+
+		if limited = h.nextUse(msg.Chat.ID, audit.UsesReveal, 1); !limited.IsZero() {
+			break
+		}
+
+		decSecret, err := decryptSecret(privkey, secret, h.signed(msg))
+		if errors.Is(err, ErrTampered) {
+			h.reportTampered(msg, secret)
+
+			continue
+		}
+
+		if err != nil {
+			h.logger(msg).Error(err.Error())
+
+			continue
+		}
+
+		h.recordAudit(msg, audit.ActionReveal, audit.SecretKey(secret), "slack")
+		texts = append(texts, h.slackSecret(secret.StableID(), decSecret))
+	}
+
+	if len(leftOut) > 0 {
+		texts = append(texts, h.Locales.Format("", "slack_left_out", localizator.Args{"IDs": strings.Join(leftOut, ", ")}))
+	}
+
+	if !limited.IsZero() {
+		texts = append(texts, h.Locales.Format("", "limit_reached", localizator.Args{"Name": audit.UsesReveal, "Next": limited}))
+	}
+
+	if len(texts) == 0 {
+		h.slackRespond(cmd, h.Locales.Get("", "query_no_secrets"))
+
+		return
+	}
+
+	h.slackRespond(cmd, texts...)
+}
+
+// slackSecret renders the decrypted secret as plain text.
+func (h *Handler) slackSecret(id string, secret providers.SecretsData) string {
+	lines := []string{secret.Description + " (" + id + ")"}
+
+	if fields, ok := h.fieldsOf(secret.Type); ok {
+		values := make(map[string]string)
+		if err := json.Unmarshal([]byte(secret.Secret), &values); err != nil {
+			values = nil
+		}
+
+		for _, field := range fields {
+			lines = append(lines, h.fieldLabel("", field)+": "+values[field.Name])
+		}
+
+		return strings.Join(lines, "\n")
+	}
+
+	if secret.Username != "" {
+		lines = append(lines, h.Locales.Get("", "webapp_username")+": "+secret.Username)
+	}
+
+	lines = append(lines, h.Locales.Get("", "webapp_secret")+": "+secret.Secret)
+
+	if secret.URL != "" {
+		lines = append(lines, h.Locales.Get("", "webapp_url")+": "+secret.URL)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// slackRespond sends the texts as plain text blocks to the user and deletes
+// them after the cleanup timeout.
+func (h *Handler) slackRespond(cmd slack.Command, texts ...string) {
+	blocks := make([]map[string]interface{}, len(texts))
+	for i, text := range texts {
+		blocks[i] = map[string]interface{}{
+			"type": "section",
+			"text": map[string]interface{}{"type": "plain_text", "text": text},
+		}
+	}
+
+	err := h.Slack.Respond(context.Background(), cmd.ResponseURL, map[string]interface{}{
+		"response_type": "ephemeral",
+		"text":          strings.SplitN(texts[0], "\n", 2)[0],
+		"blocks":        blocks,
+	})
+	if err != nil {
+		log.Error("Unable to respond to Slack: "+err.Error(), "slack_user", cmd.UserID)
+
+		return
+	}
+
+	atomic.AddInt64(&pendingCleanups, 1)
+	defer atomic.AddInt64(&pendingCleanups, -1)
+
+	time.Sleep(time.Second * time.Duration(h.Config.CleanupTimeout))
+
+	err = h.Slack.Respond(context.Background(), cmd.ResponseURL, map[string]interface{}{"delete_original": true})
+	if err != nil {
+		log.Error("Unable to delete a Slack response: "+err.Error(), "slack_user", cmd.UserID)
+	}
+}
+
+func (h *Handler) slackOpenAdd(msg *chat.Message, cmd slack.Command) {
+	if _, err := h.unlock(msg); err != nil {
+		h.slackRespond(cmd, h.Locales.Get("", "webapp_locked"))
+
+		return
+	}
+
+	input := func(blockID, labelKey string, optional bool) map[string]interface{} {
+		return map[string]interface{}{
+			"type":     "input",
+			"block_id": blockID,
+			"optional": optional,
+			"label":    map[string]interface{}{"type": "plain_text", "text": h.Locales.Get("", labelKey)},
+			"element":  map[string]interface{}{"type": "plain_text_input", "action_id": "value"},
+		}
+	}
+
+	err := h.Slack.OpenView(context.Background(), cmd.TriggerID, map[string]interface{}{
+		"type":             "modal",
+		"callback_id":      slackAddView,
+		"private_metadata": cmd.ChannelID,
+		"title":            map[string]interface{}{"type": "plain_text", "text": h.Locales.Get("", "slack_add_title")},
+		"submit":           map[string]interface{}{"type": "plain_text", "text": h.Locales.Get("", "webapp_save")},
+		"close":            map[string]interface{}{"type": "plain_text", "text": h.Locales.Get("", "webapp_cancel")},
+		"blocks": []map[string]interface{}{
+			input("description", "webapp_description", false),
+			input("username", "webapp_username", true),
+			input("secret", "webapp_secret", false),
+			input("url", "webapp_url", true),
+		},
+	})
+	if err != nil {
+		log.Error("Unable to open the Slack form: "+err.Error(), "slack_user", cmd.UserID)
+	}
+}
+
+// slackSubmit adds the secret of the submitted form, the form shows the
+// errors of the fields instead of closing if the secret can't be added.
+func (h *Handler) slackSubmit(interaction slack.Interaction) interface{} {
+	view := interaction.View
+	if view.CallbackID != slackAddView {
+		return nil
+	}
+
+	fieldError := func(blockID, key string) interface{} {
+		return map[string]interface{}{
+			"response_action": "errors",
+			"errors":          map[string]string{blockID: h.Locales.Get("", key)},
+		}
+	}
+
+	msg, ok := h.slackMessage(interaction.User.ID)
+	if !ok {
+		return fieldError("description", "slack_forbidden")
+	}
+
+	description := strings.TrimSpace(view.Value("description"))
+	if description == "" {
+		return fieldError("description", "webapp_invalid")
+	}
+
+	if view.Value("secret") == "" {
+		return fieldError("secret", "webapp_invalid")
+	}
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		return fieldError("secret", "webapp_locked")
+	}
+
+	go h.slackAdd(msg, interaction, privkey, description)
+
+	return nil
+}
+
+func (h *Handler) slackAdd(msg *chat.Message, interaction slack.Interaction, privkey *ecdsa.PrivateKey, description string) {
+	view := interaction.View
+
+	text := h.Locales.Get("", "add_unable_add")
+
+	secret, err := encryptSecret(privkey, description, view.Value("username"), view.Value("secret"))
+	if err == nil {
+		secret.URL = strings.TrimSpace(view.Value("url"))
+		secret.Owner = msg.Chat.ID
+		secret, err = h.addSecret(msg, secret)
+	}
+
+	if err != nil {
+		h.logger(msg).Error("Add secret from Slack: " + err.Error())
+	} else {
+		h.recordAudit(msg, audit.ActionAdd, audit.SecretKey(secret), "slack")
+
+		text = h.Locales.Format("", "slack_added", localizator.Args{"ID": secret.StableID()})
+	}
+
+	if view.PrivateMetadata == "" {
+		return
+	}
+
+	err = h.Slack.PostEphemeral(context.Background(), view.PrivateMetadata, interaction.User.ID, text)
+	if err != nil {
+		log.Error("Unable to send a message to Slack: "+err.Error(), "slack_user", interaction.User.ID)
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/crypto"
+	"secretable/pkg/keyslots"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// slotsSession names the session of the vault opened by the key slots.
+const slotsSession = "key slots"
+
+// AutoUnlock opens the default vault with the Unlockers, so the bot serves
+// the secrets without the master password, e.g. with the KMS key of its
+// instance role. The named vaults are opened by the same slots on demand.
+func (h *Handler) AutoUnlock() error {
+	h.keymx.RLock()
+	_, ok, err := getPrivkeyAsBytes(h.TablesProvider, h.Config.Salt, "", h.Unlockers...)
+	h.keymx.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrMissingKey
+	}
+
+	atomic.StoreInt32(&h.autounlocked, 1)
+
+	h.sessionmx.Lock()
+	h.session = unlockSession{Name: slotsSession, At: time.Now()}
+	h.sessionmx.Unlock()
+
+	h.writeAudit(audit.Event{Action: audit.ActionUnlock, Details: slotsSession})
+
+	return nil
+}
+
+// isUnlocked reports whether the active vault of the chat is opened by its
+// master password or by the key slots.
+func (h *Handler) isUnlocked(m *chat.Message) bool {
+	return h.masterPass(m) != "" || atomic.LoadInt32(&h.autounlocked) == 1
+}
+
+// isAnyUnlocked reports whether one of the vaults is opened.
+func (h *Handler) isAnyUnlocked() bool {
+	return h.hasPasses() || atomic.LoadInt32(&h.autounlocked) == 1
+}
+
+// unlockers returns the Unlockers while the vault is opened by them.
+func (h *Handler) unlockers() []keyslots.Unlocker {
+	if atomic.LoadInt32(&h.autounlocked) == 0 {
+		return nil
+	}
+
+	return h.Unlockers
+}
+
+// lockSlots closes the vault opened by the key slots and forgets the keys
+// remembered by the unlockers.
+func (h *Handler) lockSlots() {
+	atomic.StoreInt32(&h.autounlocked, 0)
+
+	for _, unlocker := range h.Unlockers {
+		if memo, ok := unlocker.(*keyslots.Memo); ok {
+			memo.Forget()
+		}
+	}
+}
+
+// Slots lists the key slots of the active vault, adds the slot of a
+// configured unlocker to all vaults or removes one.
+func (h *Handler) Slots(msg *chat.Message) {
+	args := strings.Fields(strings.TrimPrefix(msg.Text, "/slots"))
+	locale := msg.Sender.LanguageCode
+
+	if len(args) == 0 {
+		h.listSlots(msg)
+
+		return
+	}
+
+	if len(args) != 2 || (args[0] != "add" && args[0] != "remove") {
+		h.sendMessage(msg, h.Locales.Get(locale, "slots_usage"))
+
+		return
+	}
+
+	typ := strings.ToLower(args[1])
+
+	// The signed marker isn't a slot, it keeps the MACs enforced.
+	if typ == keyslots.TypeSigned {
+		h.sendMessage(msg, h.Locales.Format(locale, "slots_unknown_type", localizator.Args{"Type": typ}))
+
+		return
+	}
+
+	var err error
+
+	if args[0] == "add" {
+		unlocker, ok := h.unlockerOf(msg, typ)
+		if !ok {
+			h.sendMessage(msg, h.Locales.Format(locale, "slots_unknown_type", localizator.Args{"Type": typ}))
+
+			return
+		}
+
+		err = h.changeSlots(msg, func(slots keyslots.Slots, key []byte, _ string) (keyslots.Slots, error) {
+			return slots.Put(context.Background(), key, unlocker)
+		})
+	} else {
+		err = h.changeSlots(msg, func(slots keyslots.Slots, _ []byte, pass string) (keyslots.Slots, error) {
+			return h.removeSlot(slots, typ, pass)
+		})
+	}
+
+	if errors.Is(err, keyslots.ErrLastSlot) {
+		h.sendMessage(msg, h.Locales.Get(locale, "slots_last"))
+
+		return
+	}
+
+	if err != nil {
+		h.logger(msg).Error("Change key slots: " + err.Error())
+		h.sendFailure(msg, "slots_unable_change", err)
+
+		return
+	}
+
+	if args[0] == "remove" && typ == keyslots.TypePassword && h.hasPasses() {
+		h.forgetPasses()
+		atomic.StoreInt32(&h.autounlocked, 1)
+	}
+
+	h.recordAudit(msg, audit.ActionKeySlot, "", args[0]+" "+typ)
+
+	key := "slots_added"
+	if args[0] == "remove" {
+		key = "slots_removed"
+	}
+
+	h.sendMessage(msg, h.Locales.Format(locale, key, localizator.Args{"Type": typ}))
+}
+
+func (h *Handler) listSlots(msg *chat.Message) {
+	h.keymx.RLock()
+	k, err := h.storage(msg).GetKey()
+	h.keymx.RUnlock()
+
+	if err != nil {
+		h.logger(msg).Error("Get key: " + err.Error())
+		h.sendFailure(msg, "slots_unable_change", err)
+
+		return
+	}
+
+	slots, err := keyslots.Parse(k)
+	if err != nil {
+		h.logger(msg).Error("Parse key slots: " + err.Error())
+		h.sendFailure(msg, "slots_unable_change", err)
+
+		return
+	}
+
+	types := make([]string, 0, len(slots))
+	for _, slot := range slots {
+		if slot.Type != keyslots.TypeSigned {
+			types = append(types, slot.Type)
+		}
+	}
+
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "slots_list", localizator.Args{
+		"Slots": strings.Join(types, ", "),
+	}))
+}
+
+// unlockerOf returns the configured unlocker of the type, the password slot is
+// wrapped with the master password of the active vault of the chat.
+func (h *Handler) unlockerOf(msg *chat.Message, typ string) (keyslots.Unlocker, bool) {
+	if typ == keyslots.TypePassword {
+		pass := h.masterPass(msg)
+
+		return keyslots.NewPassword(pass, h.Config.Salt).WithKDF(h.kdf()), pass != ""
+	}
+
+	for _, unlocker := range h.Unlockers {
+		if unlocker.Type() == typ {
+			return unlocker, true
+		}
+	}
+
+	return nil, false
+}
+
+// removeSlot drops the slot of the type unless none of the remaining slots
+// can be opened by the master password of the vault or the configured
+// unlockers.
+func (h *Handler) removeSlot(slots keyslots.Slots, typ, pass string) (keyslots.Slots, error) {
+	if !slots.Has(typ) {
+		return slots, nil
+	}
+
+	slots, err := slots.Remove(typ)
+	if err != nil {
+		return nil, err
+	}
+
+	unlockers := h.Unlockers
+	if pass != "" && typ != keyslots.TypePassword {
+		unlockers = append([]keyslots.Unlocker{keyslots.NewPassword(pass, h.Config.Salt)}, unlockers...)
+	}
+
+	if _, err = slots.Open(context.Background(), unlockers...); err != nil {
+		return nil, errors.Wrap(keyslots.ErrLastSlot, err.Error())
+	}
+
+	return slots, nil
+}
+
+// changeSlots stores the changed slots of every vault with a key, the old keys
+// are restored if a vault can't be changed.
+func (h *Handler) changeSlots(msg *chat.Message, change func(keyslots.Slots, []byte, string) (keyslots.Slots, error)) error {
+	h.keymx.Lock()
+	defer h.keymx.Unlock()
+
+	var changed []wrappedKey
+
+	restore := func(err error) error {
+		for _, key := range changed {
+			if restoreErr := key.storage.SetKey(key.old); restoreErr != nil {
+				return errors.Wrap(restoreErr, "restore key after the change failed with "+err.Error())
+			}
+		}
+
+		return err
+	}
+
+	for _, name := range h.vaultNames() {
+		key, err := h.changeVaultSlots(h.storageOf(msg, h.vaultStorage(name)), h.vaultPass(name), change)
+		if err != nil {
+			return restore(err)
+		}
+
+		if key.storage != nil {
+			changed = append(changed, key)
+		}
+	}
+
+	if len(changed) == 0 {
+		return ErrMissingKey
+	}
+
+	return nil
+}
+
+// changeVaultSlots stores the changed slots of the vault opened by its master
+// password, the returned storage is nil if the vault has no key yet.
+func (h *Handler) changeVaultSlots(storage providers.StorageProvider, pass string,
+	change func(keyslots.Slots, []byte, string) (keyslots.Slots, error)) (wrappedKey, error) {
+	privkeyBytes, ok, err := getPrivkeyAsBytes(storage, h.Config.Salt, pass, h.unlockers()...)
+	if err != nil || !ok {
+		return wrappedKey{}, err
+	}
+
+	oldKey, err := storage.GetKey()
+	if err != nil {
+		return wrappedKey{}, errors.Wrap(err, "get key")
+	}
+
+	slots, err := keyslots.Parse(oldKey)
+	if err != nil {
+		return wrappedKey{}, errors.Wrap(err, "parse key slots")
+	}
+
+	slots, err = change(slots, privkeyBytes, pass)
+	if err != nil {
+		return wrappedKey{}, err
+	}
+
+	if err = storage.SetKey(slots.String()); err != nil {
+		return wrappedKey{}, errors.Wrap(err, "store key slots")
+	}
+
+	return wrappedKey{storage: storage, old: oldKey, pass: pass}, nil
+}
+
+// kdf returns the KDF of the config which wraps the password slots.
+func (h *Handler) kdf() crypto.KDF {
+	return h.Config.KDF.OrDefault()
+}
+
+// upgradeKDF rewraps the password slot of the vault with the KDF of the config
+// if it was wrapped by a weaker one. The vault stays unlocked if the upgrade
+// fails, it is tried again on the next unlock.
+func (h *Handler) upgradeKDF(msg *chat.Message, name, masterPass string) {
+	h.keymx.Lock()
+	defer h.keymx.Unlock()
+
+	storage := h.storageOf(msg, h.vaultStorage(name))
+	target := h.kdf()
+
+	k, err := storage.GetKey()
+	if err != nil {
+		h.logger(msg).Error("Upgrade KDF: get key: " + err.Error())
+
+		return
+	}
+
+	slots, err := keyslots.Parse(k)
+	if err != nil {
+		h.logger(msg).Error("Upgrade KDF: parse key slots: " + err.Error())
+
+		return
+	}
+
+	current, ok, err := slots.PasswordKDF()
+	if err != nil || !ok || !current.Weaker(target) {
+		return
+	}
+
+	password := keyslots.NewPassword(masterPass, h.Config.Salt)
+
+	privkeyBytes, err := slots.Open(context.Background(), password)
+	if err != nil {
+		h.logger(msg).Error("Upgrade KDF: " + err.Error())
+
+		return
+	}
+
+	slots, err = slots.Put(context.Background(), privkeyBytes, password.WithKDF(target))
+	if err != nil {
+		h.logger(msg).Error("Upgrade KDF: " + err.Error())
+
+		return
+	}
+
+	if err = storage.SetKey(slots.String()); err != nil {
+		h.logger(msg).Error("Upgrade KDF: store key slots: " + err.Error())
+
+		return
+	}
+
+	h.logger(msg).Info("🔐 Upgraded the KDF of the key", "vault", name,
+		"from", current.String(), "to", target.String())
+}
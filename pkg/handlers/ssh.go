@@ -0,0 +1,76 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+const pemPrefix = "-----BEGIN"
+
+// SSHFingerprint returns the SHA256 fingerprint of the private key. The public
+// part of the passphrase protected OpenSSH keys is readable without passphrase.
+func SSHFingerprint(privkey string) (string, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(privkey))
+	if err == nil {
+		return ssh.FingerprintSHA256(signer.PublicKey()), nil
+	}
+
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) && missing.PublicKey != nil {
+		return ssh.FingerprintSHA256(missing.PublicKey), nil
+	}
+
+	return "", errors.Wrap(err, "parse private key")
+}
+
+// formatSSHKey renders the description, the comment (username field) and the
+// fingerprint of the key followed by the key itself.
+func (h *Handler) formatSSHKey(locale, id string, secret providers.SecretsData) string {
+	fingerprint, err := SSHFingerprint(secret.Secret)
+	if err != nil {
+		fingerprint = h.Locales.Get(locale, "ssh_invalid_key")
+	}
+
+	return h.Locales.Format(locale, "layout_ssh_key", localizator.Args{
+		"ID":          id,
+		"Description": html.EscapeString(secret.Description),
+		"Comment":     html.EscapeString(secret.Username),
+		"Fingerprint": html.EscapeString(fingerprint),
+		"Key":         html.EscapeString(strings.TrimSpace(secret.Secret)),
+	})
+}
+
+// validSSHKey checks the private key of the new secret message before it is
+// encrypted.
+func (h *Handler) validSSHKey(msg *chat.Message) bool {
+	arr := strings.SplitN(msg.Text, "\n", numbQueryColumns)
+
+	if _, err := SSHFingerprint(arr[len(arr)-1]); err != nil {
+		h.logger(msg).Error("Parse SSH key: "+err.Error(), "chat_id", msg.Chat.ID)
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "ssh_invalid_key"))
+
+		return false
+	}
+
+	return true
+}
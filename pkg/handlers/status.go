@@ -0,0 +1,84 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"secretable/pkg/version"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// started is the start time of the bot.
+var started = time.Now()
+
+// pendingCleanups counts the messages waiting for the cleanup timeout.
+var pendingCleanups int64
+
+// Status reports the build, the uptime, the vaults with their syncs and the
+// background queues of the bot.
+func (h *Handler) Status(msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+
+	lines := []string{h.Locales.Format(locale, "status_report", localizator.Args{
+		"Version":  html.EscapeString(version.String()),
+		"Uptime":   time.Since(started).Round(time.Second).String(),
+		"Storage":  html.EscapeString(h.Config.StorageSource),
+		"Cleanups": atomic.LoadInt64(&pendingCleanups),
+		"Queue":    h.Audit.QueueDepth(),
+	})}
+
+	if s, ok := h.currentSession(); ok {
+		lines = append(lines, h.Locales.Format(locale, "sessions_unlocked", sessionArgs(s)))
+	} else {
+		lines = append(lines, h.Locales.Get(locale, "sessions_locked"))
+	}
+
+	for _, name := range h.vaultNames() {
+		count := 0
+
+		err := h.storageOf(msg, h.vaultStorage(name)).Secrets(func(int, providers.SecretsData) bool {
+			count++
+
+			return true
+		})
+		if err != nil {
+			h.logger(msg).Error("Get secrets: "+err.Error(), "vault", name)
+			lines = append(lines, h.Locales.Format(locale, "status_vault_unreadable", localizator.Args{
+				"Vault": html.EscapeString(name),
+			}))
+		} else {
+			lines = append(lines, h.Locales.Format(locale, "status_vault", localizator.Args{
+				"Vault": html.EscapeString(name),
+				"Count": count,
+			}))
+		}
+
+		lines = append(lines, h.syncLine(locale, name))
+	}
+
+	for _, f := range h.failureCounts() {
+		lines = append(lines, h.Locales.Format(locale, "status_decrypt_failures", localizator.Args{
+			"ChatID": f.ChatID, "Count": f.Count, "Total": f.Total, "Last": f.Last,
+			"Minutes": int(failureWindow.Minutes()),
+		}))
+	}
+
+	h.sendMessage(msg, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,302 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+	"strings"
+)
+
+const (
+	maskChar     = "•"
+	visibleChars = 4
+)
+
+// RevealButton reveals a single field of a structured secret.
+var RevealButton = chat.Button{Unique: "reveal"}
+
+type maskMode int
+
+// templateMasks are the masks of the template fields by the config name.
+var templateMasks = map[string]maskMode{"": maskNone, "none": maskNone, "last4": maskLast4, "all": maskAll}
+
+const (
+	maskNone maskMode = iota
+	maskLast4
+	maskAll
+)
+
+type secretField struct {
+	Name     string
+	LabelKey string
+	// Label is the label of the template fields which have no locale key.
+	Label string
+	Mask  maskMode
+}
+
+// structuredTypes are the secret types stored as an encrypted JSON object of
+// the fields in the secret column.
+var structuredTypes = map[string][]secretField{
+	providers.TypeCard: {
+		{Name: "number", LabelKey: "field_card_number", Mask: maskLast4},
+		{Name: "expiry", LabelKey: "field_card_expiry"},
+		{Name: "cvc", LabelKey: "field_card_cvc", Mask: maskAll},
+		{Name: "holder", LabelKey: "field_card_holder"},
+	},
+	providers.TypeIdentity: {
+		{Name: "document", LabelKey: "field_identity_document"},
+		{Name: "number", LabelKey: "field_identity_number", Mask: maskLast4},
+		{Name: "name", LabelKey: "field_identity_name"},
+		{Name: "expiry", LabelKey: "field_identity_expiry"},
+	},
+}
+
+// fieldsOf returns the fields of the structured secret type, the built-in
+// types take precedence over the templates of the config.
+func (h *Handler) fieldsOf(secretType string) ([]secretField, bool) {
+	if fields, ok := structuredTypes[secretType]; ok {
+		return fields, true
+	}
+
+	if _, ok := secretTypes[secretType]; ok {
+		return nil, false
+	}
+
+	template, ok := h.Config.SecretTemplates[secretType]
+	if !ok || len(template.Fields) == 0 {
+		return nil, false
+	}
+
+	fields := make([]secretField, len(template.Fields))
+
+	for i, f := range template.Fields {
+		fields[i] = secretField{Name: f.Name, Label: f.Label, Mask: templateMasks[f.Mask]}
+		if fields[i].Label == "" {
+			fields[i].Label = f.Name
+		}
+	}
+
+	return fields, true
+}
+
+func (h *Handler) fieldLabel(locale string, field secretField) string {
+	if field.Label != "" {
+		return field.Label
+	}
+
+	return h.Locales.Get(locale, field.LabelKey)
+}
+
+// structuredFlow collects the fields of a structured secret step by step.
+type structuredFlow struct {
+	Type        string
+	EditKey     string
+	Description string
+	Values      map[string]string
+	Step        int
+}
+
+func (h *Handler) startStructuredFlow(msg *chat.Message, secretType, editKey string) {
+	h.conversations.start(msg.Chat.ID, convStructured, &structuredFlow{
+		Type:    secretType,
+		EditKey: editKey,
+		Values:  make(map[string]string),
+	})
+
+	h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "add_structured_description"))
+}
+
+func (h *Handler) queryStructuredStep(msg *chat.Message, flow *structuredFlow) {
+	fields, _ := h.fieldsOf(flow.Type)
+	value := strings.TrimSpace(msg.Text)
+
+	if flow.Step == 0 {
+		flow.Description = value
+	} else {
+		flow.Values[fields[flow.Step-1].Name] = value
+	}
+
+	flow.Step++
+
+	if flow.Step <= len(fields) {
+		h.conversations.start(msg.Chat.ID, convStructured, flow)
+		h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "add_structured_field", localizator.Args{
+			"Field": h.fieldLabel(msg.Sender.LanguageCode, fields[flow.Step-1]),
+		}))
+
+		return
+	}
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		return
+	}
+
+	// The username column keeps the masked number as a label of the secret.
+	blob, _ := json.Marshal(flow.Values)
+	label := maskValue(flow.Values["number"], maskLast4)
+
+	secret, err := encryptSecret(privkey, flow.Description, label, string(blob))
+	if err != nil {
+		h.logger(msg).Error(err.Error())
+		h.sendError(msg, "add_unable_add")
+
+		return
+	}
+
+	secret.Type = flow.Type
+	secret.Owner = msg.Chat.ID
+
+	if flow.EditKey != "" {
+		h.replaceSecret(msg, flow.EditKey, secret)
+
+		return
+	}
+
+	if secret, err = h.addSecret(msg, secret); err != nil {
+		h.logger(msg).Error("Add secret: " + err.Error())
+		h.sendFailure(msg, "add_unable_add", err)
+
+		return
+	}
+
+	h.recordAudit(msg, audit.ActionAdd, audit.SecretKey(secret), "")
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "add_secret_added", localizator.Args{"ID": secret.ID}))
+}
+
+// formatStructured renders the fields of the structured secret, the sensitive
+// fields are masked unless reveal is set.
+func (h *Handler) formatStructured(locale, id string, secret providers.SecretsData, reveal bool) string {
+	values := make(map[string]string)
+	if err := json.Unmarshal([]byte(secret.Secret), &values); err != nil {
+		log.Error("Unmarshal structured secret: " + err.Error())
+	}
+
+	var bld strings.Builder
+
+	bld.WriteString(h.Locales.Format(locale, "layout_title", localizator.Args{
+		"ID": id, "Description": html.EscapeString(secret.Description),
+	}))
+
+	fields, _ := h.fieldsOf(secret.Type)
+
+	for _, field := range fields {
+		value := values[field.Name]
+		if !reveal {
+			value = maskValue(value, field.Mask)
+		}
+
+		bld.WriteString("\n" + h.Locales.Format(locale, "layout_field", localizator.Args{
+			"Label": h.fieldLabel(locale, field), "Value": html.EscapeString(value),
+		}))
+	}
+
+	return bld.String()
+}
+
+func (h *Handler) sendStructured(msg *chat.Message, id string, secret providers.SecretsData, key, footer string) {
+	var buttons []chat.Button
+
+	fields, _ := h.fieldsOf(secret.Type)
+
+	for _, field := range fields {
+		if field.Mask == maskNone {
+			continue
+		}
+
+		btn := RevealButton
+		btn.Text = h.Locales.Format(msg.Sender.LanguageCode, "reveal_button", localizator.Args{
+			"Field": h.fieldLabel(msg.Sender.LanguageCode, field),
+		})
+		btn.Data = key + "|" + field.Name
+
+		buttons = append(buttons, btn)
+	}
+
+	h.sendMessageWithOptions(msg, h.formatStructured(msg.Sender.LanguageCode, id, secret, false)+footer,
+		chat.Options{Buttons: [][]chat.Button{buttons}})
+}
+
+func (h *Handler) RevealCallback(msg *chat.Message, c *chat.Callback) {
+	parts := strings.SplitN(c.Data, "|", 2)
+	if len(parts) != 2 {
+		return
+	}
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "reveal_unlock_first"))
+
+		return
+	}
+
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		return
+	}
+
+	index := findSecret(secrets, parts[0])
+	if index < 0 || !h.isVisible(msg, secrets[index]) {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "edit_secret_not_found"))
+
+		return
+	}
+
+	// The fields are shown once the secret is confirmed, see checkReveal.
+	if h.gateReveal(msg, secrets[index], revealConfirmed) != nil {
+		return
+	}
+
+	decSecret, err := decryptSecret(privkey, secrets[index], h.signed(msg))
+	if err != nil {
+		h.logger(msg).Error(err.Error())
+
+		return
+	}
+
+	values := make(map[string]string)
+	if err = json.Unmarshal([]byte(decSecret.Secret), &values); err != nil {
+		h.logger(msg).Error("Unmarshal structured secret: " + err.Error())
+
+		return
+	}
+
+	h.recordAudit(msg, audit.ActionReveal, parts[0], parts[1])
+	h.sendMessage(msg, fmt.Sprintf("<code>%s</code>", html.EscapeString(values[parts[1]])))
+}
+
+func maskValue(value string, mode maskMode) string {
+	runes := []rune(value)
+
+	switch mode {
+	case maskAll:
+		return strings.Repeat(maskChar, len(runes))
+	case maskLast4:
+		if len(runes) <= visibleChars {
+			return strings.Repeat(maskChar, len(runes))
+		}
+
+		return strings.Repeat(maskChar, visibleChars) + " " + string(runes[len(runes)-visibleChars:])
+	default:
+		return value
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/crypto"
+	"secretable/pkg/localizator"
+	"strings"
+	"time"
+
+	"github.com/mr-tron/base58/base58"
+)
+
+const (
+	defaultSudoDuration = 15 * time.Minute
+	maxSudoDuration     = 4 * time.Hour
+
+	// sudoRequestTimeout is how long the admins confirm an elevation.
+	sudoRequestTimeout = 10 * time.Minute
+)
+
+var (
+	// SudoApproveButton grants the requested elevation.
+	SudoApproveButton = chat.Button{Unique: "sudo_approve"}
+	// SudoRejectButton turns the elevation down.
+	SudoRejectButton = chat.Button{Unique: "sudo_reject"}
+)
+
+// sudoRequest is an elevation waiting for the confirmation of an admin.
+type sudoRequest struct {
+	Msg      *chat.Message
+	Duration time.Duration
+	Expires  time.Time
+}
+
+// Sudo grants the member rights to a reader for a while, e.g. /sudo 30m. The
+// elevation is confirmed by the TOTP code of the chat or, if the chat has no
+// TOTP secret, by an admin. "/sudo off" ends it. The elevation is of the user
+// in the private chat, a group isn't elevated.
+func (h *Handler) Sudo(msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+	arg := strings.TrimSpace(strings.TrimPrefix(msg.Text, "/sudo"))
+
+	if !isPrivate(msg) {
+		h.sendMessage(msg, h.Locales.Get(locale, "sudo_private_only"))
+
+		return
+	}
+
+	if arg == "off" {
+		key := "sudo_not_elevated"
+		if h.endSudo(msg.Sender.ID, "ended") {
+			key = "sudo_expired"
+		}
+
+		h.sendMessage(msg, h.Locales.Get(locale, key))
+
+		return
+	}
+
+	duration := defaultSudoDuration
+
+	if arg != "" {
+		d, err := parseDuration(arg)
+		if err != nil || d > maxSudoDuration {
+			h.sendMessage(msg, h.Locales.Get(locale, "sudo_usage"))
+
+			return
+		}
+
+		duration = d
+	}
+
+	if h.roleOfMessage(msg) >= RoleMember {
+		h.sendMessage(msg, h.Locales.Get(locale, "sudo_not_needed"))
+
+		return
+	}
+
+	h.recordAudit(msg, audit.ActionSudo, "", "requested "+duration.String())
+
+	if _, ok := h.Config.SecondFactor.TOTPSecrets[msg.Chat.ID]; ok {
+		// The code is checked by the second factor of the query endpoint.
+		h.factorstates.Store(msg.Chat.ID, &pendingCommand{
+			Msg: msg,
+			Next: func(m *chat.Message) {
+				h.grantSudo(m, duration, "totp")
+			},
+			At: time.Now(),
+		})
+		h.sendMessage(msg, h.Locales.Get(locale, "second_factor_enter_code"))
+
+		return
+	}
+
+	h.requestSudo(msg, duration)
+}
+
+// requestSudo asks the admins to confirm the elevation.
+func (h *Handler) requestSudo(msg *chat.Message, duration time.Duration) {
+	raw, err := crypto.MakeRandom(approvalIDLength)
+	if err != nil {
+		h.logger(msg).Error("Make sudo request ID: " + err.Error())
+		h.sendFailure(msg, "sudo_unable_request", err)
+
+		return
+	}
+
+	id := base58.Encode(raw)
+
+	h.sudorequests.Store(id, &sudoRequest{Msg: msg, Duration: duration, Expires: time.Now().Add(sudoRequestTimeout)})
+
+	approve := SudoApproveButton
+	approve.Text = h.Locales.Get("en", "approval_approve_button")
+	approve.Data = id
+
+	reject := SudoRejectButton
+	reject.Text = h.Locales.Get("en", "approval_reject_button")
+	reject.Data = id
+
+	text := h.Locales.Format("en", "sudo_request", localizator.Args{
+		"Name":     html.EscapeString(senderName(msg)),
+		"ChatID":   msg.Chat.ID,
+		"Duration": duration.String(),
+	})
+
+	for _, admin := range h.Config.AdminList {
+		_, err := h.Chat.SendMessage(admin, text, chat.Options{
+			Buttons: [][]chat.Button{{approve, reject}},
+			Notify:  true,
+		})
+		if err != nil {
+			h.logger(msg).Error("Unable to send a sudo request: "+err.Error(), "chat_id", admin)
+		}
+	}
+
+	h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "sudo_requested"))
+}
+
+// SudoApproveCallback grants the elevation of the request.
+func (h *Handler) SudoApproveCallback(msg *chat.Message, c *chat.Callback) {
+	req, ok := h.takeSudoRequest(msg, c.Data)
+	if !ok {
+		return
+	}
+
+	h.grantSudo(req.Msg, req.Duration, "admin "+senderName(msg))
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "sudo_approved", localizator.Args{
+		"ChatID": req.Msg.Chat.ID,
+	}))
+}
+
+// SudoRejectCallback turns the elevation down and tells the requesting chat.
+func (h *Handler) SudoRejectCallback(msg *chat.Message, c *chat.Callback) {
+	req, ok := h.takeSudoRequest(msg, c.Data)
+	if !ok {
+		return
+	}
+
+	h.recordAuditEvent(msg, audit.Event{Action: audit.ActionSudo, Target: req.Msg.Chat.ID, Details: "rejected"})
+
+	h.sendMessage(req.Msg, h.Locales.Get(req.Msg.Sender.LanguageCode, "sudo_rejected"))
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "approval_rejected", localizator.Args{
+		"ChatID": req.Msg.Chat.ID,
+	}))
+}
+
+// takeSudoRequest removes the pending request answered by the admin, so only
+// the first answer counts.
+func (h *Handler) takeSudoRequest(msg *chat.Message, id string) (*sudoRequest, bool) {
+	value, ok := h.sudorequests.LoadAndDelete(id)
+	if !ok || time.Now().After(value.(*sudoRequest).Expires) {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "approval_handled"))
+
+		return nil, false
+	}
+
+	return value.(*sudoRequest), true
+}
+
+// grantSudo elevates the sender until the duration passes, the elevation is
+// reverted by a timer. The private chat of the sender has the ID of the user.
+func (h *Handler) grantSudo(msg *chat.Message, duration time.Duration, by string) {
+	until := time.Now().Add(duration)
+
+	h.elevations.Store(msg.Sender.ID, until)
+	h.recordAuditEvent(msg, audit.Event{
+		Action: audit.ActionSudo, Target: msg.Sender.ID, Details: "granted " + duration.String() + " by " + by, Expires: &until,
+	})
+
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "sudo_granted", localizator.Args{"Expires": until}))
+
+	chatID, locale := msg.Sender.ID, msg.Sender.LanguageCode
+
+	time.AfterFunc(duration, func() {
+		if value, ok := h.elevations.Load(chatID); !ok || !value.(time.Time).Equal(until) {
+			return
+		}
+
+		if h.endSudo(chatID, "expired") {
+			if _, err := h.Chat.SendMessage(chatID, h.Locales.Get(locale, "sudo_expired"), chat.Options{}); err != nil {
+				h.logger(msg).Error("Unable to send the end of sudo: "+err.Error(), "chat_id", chatID)
+			}
+		}
+	})
+}
+
+// endSudo reverts the elevation of the user, ok is false if the user isn't
+// elevated.
+func (h *Handler) endSudo(chatID int64, reason string) bool {
+	if _, ok := h.elevations.LoadAndDelete(chatID); !ok {
+		return false
+	}
+
+	h.writeAudit(audit.Event{ChatID: chatID, Action: audit.ActionSudo, Target: chatID, Details: reason})
+
+	return true
+}
+
+// isElevated reports whether the user has the member rights of /sudo.
+func (h *Handler) isElevated(userID int64) bool {
+	value, ok := h.elevations.Load(userID)
+
+	return ok && time.Now().Before(value.(time.Time))
+}
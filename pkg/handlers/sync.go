@@ -0,0 +1,103 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"secretable/pkg/providers"
+	"strings"
+	"time"
+)
+
+const defaultSyncAlertFailures = 5
+
+// syncWatcher is the storage which syncs in the background and reports the
+// failed syncs.
+type syncWatcher interface {
+	SyncStatus() providers.SyncStatus
+	OnSyncFailure(func(providers.SyncStatus))
+}
+
+// WatchSync alerts the admins once the sync of a vault fails the configured
+// number of times in a row.
+func (h *Handler) WatchSync() {
+	threshold := h.Config.SyncAlertFailures
+	if threshold <= 0 {
+		threshold = defaultSyncAlertFailures
+	}
+
+	for _, name := range h.vaultNames() {
+		watcher, ok := h.vaultStorage(name).(syncWatcher)
+		if !ok {
+			continue
+		}
+
+		vault := name
+
+		watcher.OnSyncFailure(func(status providers.SyncStatus) {
+			log.Error("Sync failed: "+status.LastError, "vault", vault, "failures", status.Failures)
+
+			if status.Failures != threshold {
+				return
+			}
+
+			go h.notifyAdmins(0, h.Locales.Format("en", "sync_failing", localizator.Args{
+				"Vault":    html.EscapeString(vault),
+				"Failures": status.Failures,
+				"Error":    html.EscapeString(status.LastError),
+			}))
+		})
+	}
+}
+
+// Sync reports the last sync and the failures of the vaults.
+func (h *Handler) Sync(msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+
+	lines := make([]string, 0, len(h.vaultNames()))
+
+	for _, name := range h.vaultNames() {
+		lines = append(lines, h.syncLine(locale, name))
+	}
+
+	h.sendMessage(msg, strings.Join(lines, "\n"))
+}
+
+// syncLine describes the last sync and the failures of the vault.
+func (h *Handler) syncLine(locale, name string) string {
+	watcher, ok := h.vaultStorage(name).(syncWatcher)
+	if !ok {
+		return h.Locales.Format(locale, "sync_local", localizator.Args{
+			"Vault": html.EscapeString(name),
+		})
+	}
+
+	status := watcher.SyncStatus()
+
+	key := "sync_status"
+	if status.Failures > 0 {
+		key = "sync_status_failing"
+	}
+
+	return h.Locales.Format(locale, key, localizator.Args{
+		"Vault":    html.EscapeString(name),
+		"Ago":      time.Since(status.LastSync).Round(time.Second).String(),
+		"Failures": status.Failures,
+		"Error":    html.EscapeString(status.LastError),
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"sort"
+	"strings"
+)
+
+// templatePicker is the argument of /add which lists the secret templates.
+const templatePicker = "template"
+
+// TemplateButton starts the flow of the secret template.
+var TemplateButton = chat.Button{Unique: "template"}
+
+// templateNames returns the sorted names of the usable templates.
+func (h *Handler) templateNames() []string {
+	names := make([]string, 0, len(h.Config.SecretTemplates))
+
+	for name := range h.Config.SecretTemplates {
+		if _, reserved := secretTypes[name]; reserved || name == templatePicker {
+			continue
+		}
+
+		if _, ok := h.fieldsOf(name); ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func (h *Handler) sendTemplates(msg *chat.Message) {
+	var rows [][]chat.Button
+
+	for _, name := range h.templateNames() {
+		btn := TemplateButton
+		btn.Text = name
+		btn.Data = name
+
+		rows = append(rows, []chat.Button{btn})
+	}
+
+	h.sendMessageWithOptions(msg, h.Locales.Get(msg.Sender.LanguageCode, "add_pick_template"),
+		chat.Options{Buttons: rows})
+}
+
+func (h *Handler) TemplateCallback(msg *chat.Message, c *chat.Callback) {
+	if _, ok := h.fieldsOf(c.Data); !ok {
+		h.sendMessage(msg, h.Locales.Get(msg.Sender.LanguageCode, "add_unknown_type"))
+
+		return
+	}
+
+	h.startStructuredFlow(msg, c.Data, "")
+}
+
+// templatesHint lists the templates after the built-in types.
+func (h *Handler) templatesHint(locale string) string {
+	names := h.templateNames()
+	if len(names) == 0 {
+		return ""
+	}
+
+	for i, name := range names {
+		names[i] = "<code>/add " + html.EscapeString(name) + "</code>"
+	}
+
+	return "\n" + h.Locales.Format(locale, "add_templates", localizator.Args{"Templates": strings.Join(names, ", ")})
+}
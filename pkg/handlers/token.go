@@ -0,0 +1,87 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"strings"
+)
+
+const (
+	// maxChunkSize keeps the chunk with its markup under the Telegram limit
+	// of 4096 characters per message.
+	maxChunkSize   = 4000
+	previewChars   = 4
+	minPreviewSize = 3 * previewChars
+)
+
+// sendToken sends the masked preview of the API token followed by the full
+// value as a separate monospace message, split into ordered chunks if needed.
+func (h *Handler) sendToken(msg *chat.Message, id string, secret providers.SecretsData, footer string) {
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "layout_token", localizator.Args{
+		"ID":          id,
+		"Description": html.EscapeString(secret.Description),
+		"Username":    html.EscapeString(secret.Username),
+		"Preview":     html.EscapeString(tokenPreview(secret.Secret)),
+	})+footer)
+
+	chunks := splitChunks(strings.TrimSpace(secret.Secret), h.tokenChunkSize())
+	if len(chunks) == 1 {
+		h.sendMessage(msg, "<code>"+html.EscapeString(chunks[0])+"</code>")
+
+		return
+	}
+
+	for i, chunk := range chunks {
+		h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "token_chunk", localizator.Args{
+			"Part": i + 1, "Total": len(chunks),
+		})+
+			"\n<code>"+html.EscapeString(chunk)+"</code>")
+	}
+}
+
+func (h *Handler) tokenChunkSize() int {
+	if h.Config.TokenChunkSize <= 0 || h.Config.TokenChunkSize > maxChunkSize {
+		return maxChunkSize
+	}
+
+	return h.Config.TokenChunkSize
+}
+
+// tokenPreview keeps the first and the last characters of the token, the
+// short tokens are masked entirely.
+func tokenPreview(token string) string {
+	runes := []rune(strings.TrimSpace(token))
+	if len(runes) < minPreviewSize {
+		return strings.Repeat(maskChar, len(runes))
+	}
+
+	return string(runes[:previewChars]) + strings.Repeat(maskChar, previewChars) + string(runes[len(runes)-previewChars:])
+}
+
+func splitChunks(value string, size int) []string {
+	runes := []rune(value)
+	chunks := make([]string, 0, len(runes)/size+1)
+
+	for len(runes) > size {
+		chunks = append(chunks, string(runes[:size]))
+		runes = runes[size:]
+	}
+
+	return append(chunks, string(runes))
+}
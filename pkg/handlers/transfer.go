@@ -0,0 +1,183 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// visibleMarkers show the whitespace of the value, the other invisible runes
+// are shown by their code point.
+var visibleMarkers = map[rune]string{
+	' ':      "␣",
+	'\t':     "⇥",
+	'\n':     "↵",
+	'\r':     "␍",
+	'\u00a0': "⍽",
+}
+
+// valueInfo describes how the value survives the copy and paste.
+type valueInfo struct {
+	Runes int
+	Bytes int
+	// Edges is set if the value starts or ends with the whitespace.
+	Edges bool
+	// Invisible counts the runes which can't be seen, e.g. tabs or zero
+	// width spaces, the inner spaces aren't counted.
+	Invisible int
+	// Escaped counts the runes escaped by the HTML of the messages.
+	Escaped int
+	// Visible is the value with the markers of the invisible runes.
+	Visible string
+	// Checksum is the CRC-32 of the value, see Checksum.
+	Checksum string
+}
+
+// Checksum returns the CRC-32 of the value in base32, 7 characters to compare
+// the pasted value with the revealed one, e.g. by "secretable checksum".
+func Checksum(value string) string {
+	sum := make([]byte, crc32.Size)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE([]byte(value)))
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum)
+}
+
+func describeValue(value string) valueInfo {
+	info := valueInfo{Runes: utf8.RuneCountInString(value), Bytes: len(value), Checksum: Checksum(value)}
+	info.Edges = value != strings.TrimSpace(value)
+
+	var bld strings.Builder
+
+	runes := []rune(value)
+
+	for i, r := range runes {
+		if strings.ContainsRune(`<>&"'`, r) {
+			info.Escaped++
+		}
+
+		inner := r == ' ' && i > 0 && i < len(runes)-1
+
+		switch marker, ok := visibleMarkers[r]; {
+		case inner:
+			bld.WriteRune(r)
+		case ok:
+			info.Invisible++
+
+			bld.WriteString(marker)
+		case !unicode.IsGraphic(r) || unicode.Is(unicode.Cf, r):
+			info.Invisible++
+
+			bld.WriteString(fmt.Sprintf("⟨U+%04X⟩", r))
+		default:
+			bld.WriteRune(r)
+		}
+	}
+
+	info.Visible = bld.String()
+
+	return info
+}
+
+// sendSafe sends the secret for the copy and paste: the value alone in a code
+// block, split by the chunk size of the tokens, and its length. The value
+// with the invisible runes marked follows if it has any.
+func (h *Handler) sendSafe(msg *chat.Message, id string, secret providers.SecretsData, footer string) {
+	locale := msg.Sender.LanguageCode
+
+	h.sendMessage(msg, h.Locales.Format(locale, "layout_title", localizator.Args{
+		"ID": id, "Description": html.EscapeString(secret.Description),
+	})+"\n<code>"+html.EscapeString(secret.Username)+"</code>"+footer)
+
+	chunks := splitChunks(secret.Secret, h.tokenChunkSize())
+
+	for i, chunk := range chunks {
+		text := "<pre>" + html.EscapeString(chunk) + "</pre>"
+		if len(chunks) > 1 {
+			text = h.Locales.Format(locale, "token_chunk", localizator.Args{"Part": i + 1, "Total": len(chunks)}) + "\n" + text
+		}
+
+		h.sendMessage(msg, text)
+	}
+
+	h.sendMessage(msg, h.formatValueInfo(locale, describeValue(secret.Secret)))
+}
+
+func (h *Handler) formatValueInfo(locale string, info valueInfo) string {
+	text := h.Locales.Format(locale, "len_length", localizator.Args{
+		"Runes": info.Runes, "Bytes": info.Bytes, "Checksum": info.Checksum,
+	})
+
+	if info.Edges || info.Invisible > 0 {
+		text += "\n" + h.Locales.Format(locale, "len_invisible", localizator.Args{
+			"Count":   info.Invisible,
+			"Visible": html.EscapeString(info.Visible),
+		})
+	}
+
+	if info.Escaped > 0 {
+		text += "\n" + h.Locales.Format(locale, "len_escaped", localizator.Args{"Count": info.Escaped})
+	}
+
+	return text
+}
+
+// Len tells the length of the secret and the runes mangled by the copy and
+// paste, without the value, e.g. /len 3.
+func (h *Handler) Len(msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+	id := strings.TrimSpace(strings.TrimPrefix(msg.Text, "/len"))
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		return
+	}
+
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		h.sendMessage(msg, h.Locales.Get(locale, "edit_secret_not_found"))
+
+		return
+	}
+
+	index := h.findVisible(msg, secrets, id)
+	if index < 0 {
+		h.sendMessage(msg, h.Locales.Get(locale, "len_usage"))
+
+		return
+	}
+
+	decSecret, err := decryptSecret(privkey, secrets[index], h.signed(msg))
+	if err != nil {
+		h.logger(msg).Error(err.Error())
+		h.sendFailure(msg, "len_unable_check", err)
+
+		return
+	}
+
+	h.sendMessage(msg, h.Locales.Format(locale, "len_report", localizator.Args{
+		"ID":          secrets[index].StableID(),
+		"Description": html.EscapeString(decSecret.Description),
+	})+"\n"+h.formatValueInfo(locale, describeValue(decSecret.Secret)))
+}
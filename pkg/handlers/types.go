@@ -0,0 +1,109 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"secretable/pkg/qrcode"
+	"strings"
+	"unicode/utf8"
+)
+
+const qrScale = 8
+
+// secretTypes maps the supported secret types to the locale key of the /add prompt.
+var secretTypes = map[string]string{
+	providers.TypePassword: "add_resp_command",
+	providers.TypeWiFi:     "add_wifi_resp_command",
+	providers.TypeCard:     "add_structured_description",
+	providers.TypeIdentity: "add_structured_description",
+	providers.TypeSSHKey:   "add_ssh_resp_command",
+	providers.TypeToken:    "add_token_resp_command",
+}
+
+var wifiEscaper = strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `"`, `\"`, `:`, `\:`)
+
+// sendSecret sends the decrypted secret rendered according to its type.
+func (h *Handler) sendSecret(msg *chat.Message, id string, secret providers.SecretsData, key, footer string) {
+	secret = h.labelEnvironment(secret)
+
+	if _, ok := h.fieldsOf(secret.Type); ok {
+		h.sendStructured(msg, id, secret, key, footer)
+
+		return
+	}
+
+	// The safe transfer tells the length and the checksum by itself.
+	plain := footer
+	if h.Config.RevealChecksum {
+		footer = "\n" + h.Locales.Format(msg.Sender.LanguageCode, "reveal_checksum", localizator.Args{
+			"Runes": utf8.RuneCountInString(secret.Secret), "Checksum": Checksum(secret.Secret),
+		}) + footer
+	}
+
+	switch secret.Type {
+	case providers.TypeSSHKey:
+		h.sendMessage(msg, h.formatSSHKey(msg.Sender.LanguageCode, id, secret)+footer)
+	case providers.TypeToken:
+		h.sendToken(msg, id, secret, footer)
+	case providers.TypeWiFi:
+		h.sendMessage(msg, h.makeQueryResponse(msg.Sender.LanguageCode, id, secret)+footer)
+		h.sendWiFiQR(msg, secret)
+	default:
+		if h.Config.MaskedDisplay.Enabled {
+			h.sendMasked(msg, id, secret, key, footer)
+
+			return
+		}
+
+		if h.Config.SafeTransfer {
+			h.sendSafe(msg, id, secret, plain)
+
+			return
+		}
+
+		h.sendMessage(msg, h.makeQueryResponse(msg.Sender.LanguageCode, id, secret)+footer)
+	}
+}
+
+// formatSecret renders the whole decrypted secret as text.
+func (h *Handler) formatSecret(locale, id string, secret providers.SecretsData) string {
+	if _, ok := h.fieldsOf(secret.Type); ok {
+		return h.formatStructured(locale, id, secret, true)
+	}
+
+	if secret.Type == providers.TypeSSHKey {
+		return h.formatSSHKey(locale, id, secret)
+	}
+
+	return h.makeQueryResponse(locale, id, secret)
+}
+
+// sendWiFiQR sends the join code of the network, the username is the SSID.
+func (h *Handler) sendWiFiQR(msg *chat.Message, secret providers.SecretsData) {
+	payload := "WIFI:T:WPA;S:" + wifiEscaper.Replace(secret.Username) +
+		";P:" + wifiEscaper.Replace(secret.Secret) + ";;"
+
+	png, err := qrcode.PNG([]byte(payload), qrScale)
+	if err != nil {
+		h.logger(msg).Error("Render Wi-Fi QR code: " + err.Error())
+
+		return
+	}
+
+	h.sendPhoto(msg, png, h.Locales.Get(msg.Sender.LanguageCode, "wifi_qr_caption"))
+}
@@ -0,0 +1,189 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"sort"
+	"strings"
+)
+
+// DefaultVault names the vault of the storage from the config.
+const DefaultVault = "default"
+
+// Vault switches the active vault of the chat, the vaults are listed without
+// a name. The key of the vault is generated on the switch if the vault has
+// none and the active vault is unlocked by the master password, only a member
+// sets up a new vault. The switch is kept once the new vault is opened.
+func (h *Handler) Vault(msg *chat.Message) {
+	name := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(msg.Text, "/vault")))
+
+	if name == "" {
+		h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "vault_list", localizator.Args{
+			"Vault":  html.EscapeString(h.vaultName(msg)),
+			"Vaults": html.EscapeString(strings.Join(h.vaultNames(), ", ")),
+		}))
+
+		return
+	}
+
+	if _, ok := h.Vaults[name]; !ok && name != DefaultVault {
+		h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "vault_unknown", localizator.Args{
+			"Vaults": html.EscapeString(strings.Join(h.vaultNames(), ", ")),
+		}))
+
+		return
+	}
+
+	h.keymx.RLock()
+	key, err := h.storageOf(msg, h.vaultStorage(name)).GetKey()
+	h.keymx.RUnlock()
+
+	if err != nil {
+		h.logger(msg).Error("Get key: "+err.Error(), "vault", name)
+		h.sendFailure(msg, "vault_unable_switch", err)
+
+		return
+	}
+
+	if key == "" && h.roleOfMessage(msg) < RoleMember {
+		h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "vault_needs_member", localizator.Args{
+			"Vault": html.EscapeString(name),
+		}))
+
+		return
+	}
+
+	// The key of the new vault is wrapped with the master password of the
+	// vault the chat switches from.
+	if pass := h.masterPass(msg); key == "" && pass != "" && !h.openVault(msg, name, pass) {
+		return
+	}
+
+	if name == DefaultVault {
+		h.activevaults.Delete(msg.Chat.ID)
+	} else {
+		h.activevaults.Store(msg.Chat.ID, name)
+	}
+
+	h.logger(msg).Info("🗃 Vault switched", "chat_id", msg.Chat.ID, "vault", name)
+
+	h.sendMessage(msg, h.Locales.Format(msg.Sender.LanguageCode, "vault_switched", localizator.Args{
+		"Vault": html.EscapeString(name),
+	}))
+}
+
+// vaultName returns the name of the active vault of the chat.
+func (h *Handler) vaultName(m *chat.Message) string {
+	if name, ok := h.activevaults.Load(m.Chat.ID); ok {
+		if _, ok = h.Vaults[name.(string)]; ok {
+			return name.(string)
+		}
+	}
+
+	return DefaultVault
+}
+
+// vaultPasses are the master passwords of the unlocked vaults by the vault
+// name. A stored map is never changed, the change stores a copy.
+type vaultPasses map[string]string
+
+// vaultPass returns the master password the vault is unlocked with, empty if
+// the vault isn't unlocked by a password.
+func (h *Handler) vaultPass(name string) string {
+	if passes, ok := h.masterPasses.Load().(*vaultPasses); ok {
+		return (*passes)[name]
+	}
+
+	return ""
+}
+
+// masterPass returns the master password of the active vault of the chat.
+func (h *Handler) masterPass(m *chat.Message) string {
+	return h.vaultPass(h.vaultName(m))
+}
+
+// setVaultPass unlocks the vault with the master password. The copy is stored
+// only if no other change was stored meanwhile, so no change is lost.
+func (h *Handler) setVaultPass(name, pass string) {
+	for {
+		old := h.masterPasses.Load()
+
+		passes := vaultPasses{name: pass}
+		if prev, ok := old.(*vaultPasses); ok {
+			for vault, p := range *prev {
+				if vault != name {
+					passes[vault] = p
+				}
+			}
+		}
+
+		if h.masterPasses.CompareAndSwap(old, &passes) {
+			return
+		}
+	}
+}
+
+// forgetPasses locks the vaults unlocked by the master passwords.
+func (h *Handler) forgetPasses() {
+	h.masterPasses.Store(&vaultPasses{})
+}
+
+// hasPasses reports whether a vault is unlocked by its master password.
+func (h *Handler) hasPasses() bool {
+	passes, ok := h.masterPasses.Load().(*vaultPasses)
+
+	return ok && len(*passes) > 0
+}
+
+// vault returns the storage of the active vault of the chat.
+func (h *Handler) vault(m *chat.Message) providers.StorageProvider {
+	return h.vaultStorage(h.vaultName(m))
+}
+
+// vaultStorage returns the untraced storage of the vault with the name.
+func (h *Handler) vaultStorage(name string) providers.StorageProvider {
+	if tp, ok := h.Vaults[name]; ok {
+		return tp
+	}
+
+	return h.TablesProvider
+}
+
+// vaultNames returns the default vault followed by the configured ones.
+func (h *Handler) vaultNames() []string {
+	names := make([]string, 0, len(h.Vaults))
+	for name := range h.Vaults {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return append([]string{DefaultVault}, names...)
+}
+
+// vaultStorages returns the traced storages of all the vaults.
+func (h *Handler) vaultStorages(m *chat.Message) []providers.StorageProvider {
+	var storages []providers.StorageProvider
+
+	for _, name := range h.vaultNames() {
+		storages = append(storages, h.storageOf(m, h.vaultStorage(name)))
+	}
+
+	return storages
+}
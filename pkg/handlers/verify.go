@@ -0,0 +1,126 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"strings"
+
+	"github.com/mr-tron/base58/base58"
+)
+
+// Health is the state of a stored secret found by the verification.
+type Health string
+
+const (
+	// HealthOK is the signed secret which decrypts with the key.
+	HealthOK Health = "ok"
+	// HealthUnsigned is the secret stored before the MACs which decrypts.
+	HealthUnsigned Health = "unsigned"
+	// HealthEncoding is the secret whose encrypted fields aren't base58.
+	HealthEncoding Health = "encoding"
+	// HealthMAC is the secret whose fields don't match the MAC.
+	HealthMAC Health = "mac"
+	// HealthDecrypt is the secret which doesn't decrypt with the key.
+	HealthDecrypt Health = "decrypt"
+)
+
+// CheckSecret verifies the stored secret without revealing its values, signed
+// is set once the vault is signed.
+func CheckSecret(privkey *ecdsa.PrivateKey, secret providers.SecretsData, signed bool) Health {
+	for _, field := range []string{secret.Username, secret.Secret} {
+		if _, err := base58.Decode(field); err != nil || field == "" {
+			return HealthEncoding
+		}
+	}
+
+	if !verifySecret(privkey, secret, signed) {
+		return HealthMAC
+	}
+
+	if _, err := decryptSecret(privkey, secret, signed); err != nil {
+		return HealthDecrypt
+	}
+
+	if secret.MAC == "" {
+		return HealthUnsigned
+	}
+
+	return HealthOK
+}
+
+// verifyHealths are the problems in the order of the report.
+var verifyHealths = []Health{HealthMAC, HealthEncoding, HealthDecrypt, HealthUnsigned}
+
+// Verify checks every visible secret and reports the IDs of the secrets by
+// their problems.
+func (h *Handler) Verify(msg *chat.Message) {
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		return
+	}
+
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		h.logger(msg).Error("Get secrets: " + err.Error())
+		h.sendFailure(msg, "verify_unable_read", err)
+
+		return
+	}
+
+	var (
+		found   = make(map[Health][]int)
+		checked int
+	)
+
+	for index, secret := range secrets {
+		if !h.isVisible(msg, secret) {
+			continue
+		}
+
+		checked++
+
+		health := CheckSecret(privkey, secret, h.signed(msg))
+		found[health] = append(found[health], index)
+
+		if health == HealthMAC {
+			h.recordAudit(msg, audit.ActionIntegrity, audit.SecretKey(secret), secret.StableID())
+		}
+	}
+
+	locale := msg.Sender.LanguageCode
+
+	var bld strings.Builder
+
+	bld.WriteString(h.Locales.Format(locale, "verify_header", localizator.Args{
+		"Count":   checked,
+		"Healthy": len(found[HealthOK]),
+	}))
+
+	for _, health := range verifyHealths {
+		if len(found[health]) == 0 {
+			continue
+		}
+
+		bld.WriteString("\n\n<b>" + h.Locales.Get(locale, "verify_"+string(health)) + "</b>\n")
+		bld.WriteString(formatIDs(secrets, found[health]))
+	}
+
+	h.sendMessage(msg, bld.String())
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"html"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/log"
+	"secretable/pkg/version"
+	"time"
+)
+
+const updateCheckInterval = 24 * time.Hour
+
+// StartUpdateChecks runs the daily check of the latest release, the admins are
+// notified once about every newer release. The development builds aren't
+// checked.
+func (h *Handler) StartUpdateChecks() {
+	if h.Config.DisableUpdateCheck || !version.Released() {
+		return
+	}
+
+	go func() {
+		for {
+			h.checkUpdate()
+			time.Sleep(updateCheckInterval)
+		}
+	}()
+}
+
+func (h *Handler) checkUpdate() {
+	release, err := version.Latest(context.Background())
+	if err != nil {
+		log.Error("Check latest release: " + err.Error())
+
+		return
+	}
+
+	h.latestmx.Lock()
+	notified := h.latest.Tag == release.Tag
+	h.latest = release
+	h.latestmx.Unlock()
+
+	if notified || !release.Newer() {
+		return
+	}
+
+	log.Info("🆕 New release " + release.Tag + " is available")
+
+	h.notifyAdmins(0, h.Locales.Format("en", "version_update", updateArgs(release)))
+}
+
+// Version answers the version of the build, the admins are told about the
+// newer release too.
+func (h *Handler) Version(msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+
+	text := h.Locales.Format(locale, "version_info", localizator.Args{
+		"Version": html.EscapeString(version.Version),
+		"Commit":  html.EscapeString(version.Commit),
+		"Date":    html.EscapeString(version.Date),
+	})
+
+	h.latestmx.RLock()
+	release := h.latest
+	h.latestmx.RUnlock()
+
+	if h.isAdmin(msg.Chat.ID) && release.Newer() {
+		text += "\n\n" + h.Locales.Format(locale, "version_update", updateArgs(release))
+	}
+
+	h.sendMessage(msg, text)
+}
+
+func updateArgs(release version.Release) localizator.Args {
+	return localizator.Args{
+		"Current": html.EscapeString(version.Version),
+		"Tag":     html.EscapeString(release.Tag),
+		"URL":     html.EscapeString(release.URL),
+	}
+}
@@ -0,0 +1,408 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"secretable/pkg/audit"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+	"secretable/pkg/providers"
+	"secretable/pkg/webapp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	webAppPath    = "/app/"
+	webAppAPIPath = webAppPath + "api/"
+
+	// webAppAuthMaxAge is the lifetime of the init data of the opened Web App.
+	webAppAuthMaxAge = 24 * time.Hour
+	maxWebAppBody    = 64 << 10
+)
+
+// webAppStrings are the localized strings of the page, by the name in the
+// page and the locale key.
+var webAppStrings = map[string]string{
+	"search":      "webapp_search",
+	"all":         "webapp_all",
+	"add":         "webapp_add",
+	"edit":        "webapp_edit",
+	"save":        "webapp_save",
+	"saved":       "webapp_saved",
+	"cancel":      "webapp_cancel",
+	"back":        "webapp_back",
+	"copied":      "webapp_copied",
+	"empty":       "webapp_empty",
+	"description": "webapp_description",
+	"username":    "webapp_username",
+	"secret":      "webapp_secret",
+	"url":         "webapp_url",
+}
+
+type webAppItem struct {
+	Key         string   `json:"key"`
+	Description string   `json:"description"`
+	Type        string   `json:"type,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+type webAppField struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+type webAppSecret struct {
+	webAppItem
+	Editable bool          `json:"editable"`
+	Username string        `json:"username,omitempty"`
+	Secret   string        `json:"secret,omitempty"`
+	URL      string        `json:"url,omitempty"`
+	Fields   []webAppField `json:"fields"`
+}
+
+type webAppForm struct {
+	Description string `json:"description"`
+	Username    string `json:"username"`
+	Secret      string `json:"secret"`
+	URL         string `json:"url"`
+}
+
+// App sends the button which opens the Web App, a searchable list of the
+// secrets with the forms to add and edit them.
+func (h *Handler) App(msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+
+	if h.Config.HTTPListen == "" || !strings.HasPrefix(h.Config.PublicURL, "https://") {
+		h.sendMessage(msg, h.Locales.Get(locale, "webapp_disabled"))
+
+		return
+	}
+
+	_, err := h.send(msg, h.Locales.Get(locale, "webapp_open_text"), chat.Options{
+		Buttons: [][]chat.Button{{{
+			Text:      h.Locales.Get(locale, "webapp_open_button"),
+			WebAppURL: strings.TrimSuffix(h.Config.PublicURL, "/") + webAppPath,
+		}}},
+	})
+	if err != nil {
+		h.logger(msg).Error("Unable to send the Web App button: "+err.Error(), "chat_id", msg.Chat.ID)
+	}
+}
+
+// serveWebApp serves the page of the Web App and its API. The API requests
+// are authorized with "Authorization: tma <init data>" signed by Telegram.
+func (h *Handler) serveWebApp(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, webAppAPIPath) {
+		http.StripPrefix(webAppPath, webapp.Static()).ServeHTTP(w, r)
+
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	// Without the token of a Telegram bot anyone could sign the init data.
+	token := h.Chat.Token()
+	if token == "" {
+		writeWebAppError(w, http.StatusUnauthorized, h.Locales.Get("", "webapp_unauthorized"))
+
+		return
+	}
+
+	user, err := webapp.Validate(strings.TrimPrefix(r.Header.Get("Authorization"), "tma "),
+		token, webAppAuthMaxAge, time.Now())
+	if err != nil {
+		writeWebAppError(w, http.StatusUnauthorized, h.Locales.Get("", "webapp_unauthorized"))
+
+		return
+	}
+
+	// The Web App is opened in the private chat whose ID is the user ID.
+	msg := &chat.Message{
+		Chat:   &chat.Chat{ID: user.ID, Username: user.Username},
+		Sender: &chat.User{ID: user.ID, Username: user.Username, LanguageCode: user.LanguageCode},
+	}
+
+	if !h.isAllowed(user.ID) {
+		writeWebAppError(w, http.StatusForbidden, h.Locales.Get(user.LanguageCode, "webapp_forbidden"))
+
+		return
+	}
+
+	if text := h.getMaintenance(); text != "" && !h.isAdmin(user.ID) {
+		writeWebAppError(w, http.StatusServiceUnavailable, text)
+
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, webAppAPIPath)
+
+	switch {
+	case path == "strings" && r.Method == http.MethodGet:
+		h.serveWebAppStrings(w, msg)
+	case path == "secrets" && r.Method == http.MethodGet:
+		h.serveWebAppList(w, msg)
+	case path == "secrets" && r.Method == http.MethodPost:
+		h.saveWebAppSecret(w, r, msg, "")
+	case strings.HasPrefix(path, "secrets/") && r.Method == http.MethodGet:
+		h.serveWebAppSecret(w, msg, strings.TrimPrefix(path, "secrets/"), r.URL.Query().Get("confirm") == "1")
+	case strings.HasPrefix(path, "secrets/") && r.Method == http.MethodPut:
+		h.saveWebAppSecret(w, r, msg, strings.TrimPrefix(path, "secrets/"))
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func (h *Handler) serveWebAppStrings(w http.ResponseWriter, msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+	strs := make(map[string]string, len(webAppStrings))
+
+	for name, key := range webAppStrings {
+		strs[name] = h.Locales.Get(locale, key)
+	}
+
+	dir := "ltr"
+	if h.Locales.RTL(locale) {
+		dir = "rtl"
+	}
+
+	writeJSON(w, map[string]interface{}{"dir": dir, "strings": strs})
+}
+
+// serveWebAppList lists the descriptions, they are not encrypted, so the list
+// is available while the vault is locked.
+func (h *Handler) serveWebAppList(w http.ResponseWriter, msg *chat.Message) {
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		h.logger(msg).Error("Get secrets: " + err.Error())
+		writeWebAppError(w, http.StatusInternalServerError, h.Locales.Get(msg.Sender.LanguageCode, "webapp_unable_load"))
+
+		return
+	}
+
+	items := make([]webAppItem, 0, len(secrets))
+
+	for _, secret := range secrets {
+		if h.isVisible(msg, secret) {
+			items = append(items, newWebAppItem(secret))
+		}
+	}
+
+	writeJSON(w, map[string]interface{}{"secrets": items})
+}
+
+// serveWebAppSecret reveals the secret with the key. A secret of an
+// environment with the confirmation answers 409 until it is requested again
+// with confirm=1, the restricted ones send the request to the approvers.
+func (h *Handler) serveWebAppSecret(w http.ResponseWriter, msg *chat.Message, key string, confirmed bool) {
+	locale := msg.Sender.LanguageCode
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		writeWebAppError(w, http.StatusLocked, h.Locales.Get(locale, "webapp_locked"))
+
+		return
+	}
+
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		h.logger(msg).Error("Get secrets: " + err.Error())
+		writeWebAppError(w, http.StatusInternalServerError, h.Locales.Get(locale, "webapp_unable_load"))
+
+		return
+	}
+
+	index := findSecret(secrets, key)
+	if index < 0 || !h.isVisible(msg, secrets[index]) {
+		writeWebAppError(w, http.StatusNotFound, h.Locales.Get(locale, "edit_secret_not_found"))
+
+		return
+	}
+
+	mode := revealWhole
+	if confirmed {
+		mode = revealConfirmed
+	}
+
+	switch err = h.checkReveal(msg, secrets[index], mode); {
+	case errors.Is(err, ErrNeedsConfirmation):
+		env, _ := h.environmentOf(secrets[index])
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		writeJSON(w, map[string]interface{}{
+			"error":   h.Locales.Format(locale, "webapp_confirm", localizator.Args{"Label": env.Label}),
+			"confirm": true,
+		})
+
+		return
+	case errors.Is(err, ErrNeedsApproval):
+		h.requestApproval(msg, secrets[index])
+		writeWebAppError(w, http.StatusForbidden, h.Locales.Format(locale, "approval_requested", localizator.Args{
+			"ID": secrets[index].StableID(),
+		}))
+
+		return
+	}
+
+	if next := h.nextUse(msg.Chat.ID, audit.UsesReveal, 1); !next.IsZero() {
+		writeWebAppError(w, http.StatusTooManyRequests, h.Locales.Format(locale, "limit_reached", localizator.Args{
+			"Name": audit.UsesReveal,
+			"Next": next,
+		}))
+
+		return
+	}
+
+	decSecret, err := decryptSecret(privkey, secrets[index], h.signed(msg))
+	if err != nil {
+		h.logger(msg).Error(err.Error())
+		writeWebAppError(w, http.StatusInternalServerError, h.Locales.Get(locale, "webapp_unable_load"))
+
+		return
+	}
+
+	resp := webAppSecret{webAppItem: newWebAppItem(secrets[index])}
+
+	if fields, ok := h.fieldsOf(decSecret.Type); ok {
+		values := make(map[string]string)
+		if err = json.Unmarshal([]byte(decSecret.Secret), &values); err != nil {
+			h.logger(msg).Error("Unmarshal structured secret: " + err.Error())
+		}
+
+		for _, field := range fields {
+			resp.Fields = append(resp.Fields, webAppField{Label: h.fieldLabel(locale, field), Value: values[field.Name]})
+		}
+	} else {
+		resp.Editable = true
+		resp.Username = decSecret.Username
+		resp.Secret = decSecret.Secret
+		resp.URL = decSecret.URL
+		resp.Fields = []webAppField{
+			{Label: h.Locales.Get(locale, "webapp_username"), Value: decSecret.Username},
+			{Label: h.Locales.Get(locale, "webapp_secret"), Value: decSecret.Secret},
+		}
+
+		if decSecret.URL != "" {
+			resp.Fields = append(resp.Fields, webAppField{Label: h.Locales.Get(locale, "webapp_url"), Value: decSecret.URL})
+		}
+	}
+
+	h.recordAudit(msg, audit.ActionReveal, key, "webapp")
+	writeJSON(w, resp)
+}
+
+// saveWebAppSecret adds the secret or replaces the secret with the key.
+func (h *Handler) saveWebAppSecret(w http.ResponseWriter, r *http.Request, msg *chat.Message, key string) {
+	locale := msg.Sender.LanguageCode
+
+	var form webAppForm
+
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebAppBody)).Decode(&form)
+	if err != nil || strings.TrimSpace(form.Description) == "" || form.Secret == "" {
+		writeWebAppError(w, http.StatusBadRequest, h.Locales.Get(locale, "webapp_invalid"))
+
+		return
+	}
+
+	privkey, err := h.unlock(msg)
+	if err != nil {
+		writeWebAppError(w, http.StatusLocked, h.Locales.Get(locale, "webapp_locked"))
+
+		return
+	}
+
+	// The form has no fields of a structured secret, its value would be lost.
+	if key != "" && !h.isWebAppEditable(msg, key) {
+		writeWebAppError(w, http.StatusConflict, h.Locales.Get(locale, "webapp_not_editable"))
+
+		return
+	}
+
+	secret, err := encryptSecret(privkey, strings.TrimSpace(form.Description), form.Username, form.Secret)
+	if err != nil {
+		h.logger(msg).Error(err.Error())
+		writeWebAppError(w, http.StatusInternalServerError, h.Locales.Get(locale, "webapp_unable_save"))
+
+		return
+	}
+
+	secret.URL = strings.TrimSpace(form.URL)
+
+	if key == "" {
+		secret.Owner = msg.Chat.ID
+		secret, err = h.addSecret(msg, secret)
+
+		if err == nil {
+			h.recordAudit(msg, audit.ActionAdd, audit.SecretKey(secret), "webapp")
+		}
+	} else {
+		err = h.swapSecret(msg, key, secret)
+	}
+
+	if errors.Is(err, ErrSecretNotFound) {
+		writeWebAppError(w, http.StatusNotFound, h.Locales.Get(locale, "edit_secret_not_found"))
+
+		return
+	}
+
+	if err != nil {
+		h.logger(msg).Error("Save secret: " + err.Error())
+		writeWebAppError(w, http.StatusInternalServerError, h.Locales.Get(locale, "webapp_unable_save"))
+
+		return
+	}
+
+	writeJSON(w, newWebAppItem(secret))
+}
+
+// isWebAppEditable reports whether the form edits the secret with the key,
+// the structured secrets are edited by /edit. The missing secret is left to
+// swapSecret.
+func (h *Handler) isWebAppEditable(msg *chat.Message, key string) bool {
+	secrets, err := h.storage(msg).GetSecrets()
+	if err != nil {
+		return true
+	}
+
+	index := findSecret(secrets, key)
+	if index < 0 {
+		return true
+	}
+
+	_, structured := h.fieldsOf(secrets[index].Type)
+
+	return !structured
+}
+
+func newWebAppItem(secret providers.SecretsData) webAppItem {
+	return webAppItem{
+		Key:         audit.SecretKey(secret),
+		Description: secret.Description,
+		Type:        secret.Type,
+		Tags:        secret.Tags(),
+	}
+}
+
+func writeWebAppError(w http.ResponseWriter, status int, text string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	writeJSON(w, map[string]string{"error": text})
+}
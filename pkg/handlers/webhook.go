@@ -0,0 +1,216 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"secretable/pkg/audit"
+	"secretable/pkg/config"
+	"secretable/pkg/log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const secretsPath = "/v1/secrets/"
+
+type webhookSecret struct {
+	Description string `json:"description"`
+	Username    string `json:"username"`
+	Value       string `json:"value"`
+}
+
+// HTTPHandler serves the External Secrets Operator webhook provider:
+//
+//	GET /v1/secrets/<tag>/<username> returns the secret as {"value": ...}
+//	GET /v1/secrets/<tag> returns all secrets of the tag as {<username>: <value>}
+//
+// The requests are authorized with "Authorization: Bearer <token>" and every
+// token is scoped to its tags.
+//
+// POST /api/v1/secrets adds the secrets pushed by the automations, see
+// serveIngest.
+//
+// It also serves the one-time links of /link under /s/ and the Web App of
+// /app under /app/.
+func (h *Handler) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(secretsPath, h.serveSecrets)
+	mux.HandleFunc(ingestPath, h.serveIngest)
+	mux.HandleFunc(linksPath, h.serveLink)
+	mux.HandleFunc(webAppPath, h.serveWebApp)
+
+	return mux
+}
+
+func (h *Handler) serveSecrets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	token, ok := h.findToken(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, secretsPath), "/", 2)
+	tag := strings.ToLower(strings.TrimPrefix(parts[0], "#"))
+
+	if !tokenHasTag(token, tag) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+
+		return
+	}
+
+	h.keymx.RLock()
+	privkey, err := getPrivkey(h.TablesProvider, h.Config.Salt, h.vaultPass(DefaultVault), h.unlockers()...)
+	h.keymx.RUnlock()
+
+	if err != nil {
+		http.Error(w, "vault is locked", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	secrets, err := h.TablesProvider.GetSecrets()
+	if err != nil {
+		log.Error("Get secrets: " + err.Error())
+		http.Error(w, "internal error", http.StatusInternalServerError)
+
+		return
+	}
+
+	// The consumers of the webhook can't confirm the environment, the
+	// secrets needing the confirmation aren't served.
+	served := secrets[:0:0]
+
+	for _, secret := range secrets {
+		if secret.HasTag(tag) && !h.needsConfirmation(secret) {
+			served = append(served, secret)
+		}
+	}
+
+	// The reveals of the webhook have no chat, all the tokens share the
+	// reveal limit.
+	count := len(served)
+	if len(parts) == 2 {
+		count = 1
+	}
+
+	if next := h.nextUse(0, audit.UsesReveal, count); !next.IsZero() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(next).Seconds())+1))
+		http.Error(w, "reveal limit reached", http.StatusTooManyRequests)
+
+		return
+	}
+
+	values := make(map[string]string)
+	signed := h.signedBy(DefaultVault, h.TablesProvider, privkey)
+
+	for _, secret := range served {
+		// The rows which don't decrypt are skipped, so they don't hide the
+		// rest of the tag, see /broken.
+		decSecret, err := decryptSecret(privkey, secret, signed)
+		if errors.Is(err, ErrTampered) {
+			log.Error("Skip the secret modified outside of the bot", "id", secret.StableID(), "token", token.Name)
+			h.writeAudit(audit.Event{Action: audit.ActionIntegrity, SecretKey: audit.SecretKey(secret), Details: secret.StableID()})
+
+			continue
+		}
+
+		if err != nil {
+			log.Error("Skip the secret which doesn't decrypt: "+err.Error(), "id", secret.StableID(), "token", token.Name)
+
+			continue
+		}
+
+		name := strings.TrimSpace(decSecret.Username)
+		if len(parts) == 2 && name != parts[1] {
+			continue
+		}
+
+		h.writeAudit(audit.Event{
+			Action:    audit.ActionReveal,
+			SecretKey: audit.SecretKey(secret),
+			Details:   "http:" + token.Name,
+		})
+
+		if len(parts) == 2 {
+			writeJSON(w, webhookSecret{Description: decSecret.Description, Username: name, Value: decSecret.Secret})
+
+			return
+		}
+
+		values[name] = decSecret.Secret
+	}
+
+	if len(parts) == 2 {
+		http.Error(w, "not found", http.StatusNotFound)
+
+		return
+	}
+
+	writeJSON(w, values)
+}
+
+func (h *Handler) findToken(r *http.Request) (config.APIToken, bool) {
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	// The digests are compared, so neither the length of a token nor which
+	// token matched leaks by the time of the request.
+	got := sha256.Sum256([]byte(bearer))
+
+	var (
+		found config.APIToken
+		match int
+	)
+
+	for _, token := range h.Config.HTTPTokens {
+		want := sha256.Sum256([]byte(token.Token))
+
+		if subtle.ConstantTimeCompare(got[:], want[:]) == 1 && token.Token != "" && match == 0 {
+			found, match = token, 1
+		}
+	}
+
+	return found, match == 1
+}
+
+func tokenHasTag(token config.APIToken, tag string) bool {
+	for _, t := range token.Tags {
+		if strings.ToLower(strings.TrimPrefix(t, "#")) == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Write HTTP response: " + err.Error())
+	}
+}
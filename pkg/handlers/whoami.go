@@ -0,0 +1,60 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"html"
+	"secretable/pkg/chat"
+	"secretable/pkg/localizator"
+)
+
+// roleKeys are the locale keys of the role names.
+var roleKeys = map[Role]string{
+	RoleAnyone: "whoami_role_none",
+	RoleReader: "whoami_role_reader",
+	RoleMember: "whoami_role_member",
+	RoleAdmin:  "whoami_role_admin",
+}
+
+// WhoAmI tells the chat how the bot sees it, so a chat missing from the
+// allowed list learns why the bot doesn't answer. The vault and the unlock
+// status are shown to the members only.
+func (h *Handler) WhoAmI(msg *chat.Message) {
+	locale := msg.Sender.LanguageCode
+	role := h.roleOfMessage(msg)
+
+	text := h.Locales.Format(locale, "whoami_report", localizator.Args{
+		"ChatID":   msg.Chat.ID,
+		"UserID":   msg.Sender.ID,
+		"Role":     h.Locales.Get(locale, roleKeys[role]),
+		"Language": html.EscapeString(locale),
+		"Cleanup":  h.Config.CleanupTimeout,
+	})
+
+	if role == RoleAnyone {
+		h.sendMessage(msg, text+"\n"+h.Locales.Get(locale, "whoami_not_allowed"))
+
+		return
+	}
+
+	status := "sessions_locked"
+	if h.isUnlocked(msg) {
+		status = "whoami_unlocked"
+	}
+
+	h.sendMessage(msg, text+"\n"+h.Locales.Format(locale, "whoami_vault", localizator.Args{
+		"Vault": html.EscapeString(h.vaultName(msg)),
+	})+"\n"+h.Locales.Get(locale, status))
+}
@@ -0,0 +1,274 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package imap is a minimal IMAP4rev1 client over TLS: it lists the unseen
+// messages of a mailbox, fetches them and flags them as seen.
+package imap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxLiteral limits the fetched messages.
+const maxLiteral = 16 << 20
+
+var ErrUnsafeArgument = errors.New("argument contains a line break")
+
+// response is an untagged response line along with its literals.
+type response struct {
+	line     string
+	literals [][]byte
+}
+
+type Client struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+	tag     int
+}
+
+// Dial connects to the server with TLS, the timeout applies to every command.
+func Dial(addr string, timeout time.Duration) (*Client, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse address")
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, &tls.Config{ServerName: host})
+	if err != nil {
+		return nil, errors.Wrap(err, "dial")
+	}
+
+	c := &Client{conn: conn, r: bufio.NewReader(conn), timeout: timeout}
+
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	greeting, err := c.readLine()
+	if err != nil {
+		conn.Close()
+
+		return nil, errors.Wrap(err, "read greeting")
+	}
+
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		conn.Close()
+
+		return nil, errors.New("unexpected greeting: " + greeting)
+	}
+
+	return c, nil
+}
+
+func (c *Client) Login(username, password string) error {
+	username, err := quote(username)
+	if err != nil {
+		return err
+	}
+
+	password, err = quote(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.command("LOGIN " + username + " " + password)
+
+	return errors.Wrap(err, "login")
+}
+
+func (c *Client) Select(mailbox string) error {
+	mailbox, err := quote(mailbox)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.command("SELECT " + mailbox)
+
+	return errors.Wrap(err, "select")
+}
+
+// Unseen returns the UIDs of the unseen messages of the selected mailbox.
+func (c *Client) Unseen() ([]uint32, error) {
+	resps, err := c.command("UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, errors.Wrap(err, "search")
+	}
+
+	var uids []uint32
+
+	for _, resp := range resps {
+		if !strings.HasPrefix(resp.line, "SEARCH") {
+			continue
+		}
+
+		for _, field := range strings.Fields(resp.line)[1:] {
+			uid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, errors.Wrap(err, "parse uid")
+			}
+
+			uids = append(uids, uint32(uid))
+		}
+	}
+
+	return uids, nil
+}
+
+// Fetch returns the whole message without flagging it as seen.
+func (c *Client) Fetch(uid uint32) ([]byte, error) {
+	resps, err := c.command("UID FETCH " + strconv.FormatUint(uint64(uid), 10) + " (BODY.PEEK[])")
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch")
+	}
+
+	for _, resp := range resps {
+		if strings.Contains(resp.line, "FETCH") && len(resp.literals) > 0 {
+			return resp.literals[0], nil
+		}
+	}
+
+	return nil, errors.New("no message " + strconv.FormatUint(uint64(uid), 10))
+}
+
+func (c *Client) MarkSeen(uid uint32) error {
+	_, err := c.command("UID STORE " + strconv.FormatUint(uint64(uid), 10) + ` +FLAGS.SILENT (\Seen)`)
+
+	return errors.Wrap(err, "store flags")
+}
+
+// Logout ends the session and closes the connection.
+func (c *Client) Logout() error {
+	_, err := c.command("LOGOUT")
+	c.conn.Close()
+
+	return errors.Wrap(err, "logout")
+}
+
+// command sends the command and reads its untagged responses until the tagged
+// one, an error is returned unless the status is OK.
+func (c *Client) command(cmd string) ([]response, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+
+	_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+
+	if _, err := io.WriteString(c.conn, tag+" "+cmd+"\r\n"); err != nil {
+		return nil, errors.Wrap(err, "write command")
+	}
+
+	var resps []response
+
+	for {
+		resp, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case strings.HasPrefix(resp.line, "* "):
+			resp.line = strings.TrimPrefix(resp.line, "* ")
+			// The numbered responses, e.g. "* 3 FETCH", are kept without the
+			// number.
+			if fields := strings.SplitN(resp.line, " ", 2); len(fields) == 2 {
+				if _, err := strconv.Atoi(fields[0]); err == nil {
+					resp.line = fields[1]
+				}
+			}
+
+			resps = append(resps, resp)
+		case strings.HasPrefix(resp.line, tag+" "):
+			status := strings.TrimPrefix(resp.line, tag+" ")
+			if !strings.HasPrefix(status, "OK") {
+				return nil, errors.New(status)
+			}
+
+			return resps, nil
+		}
+	}
+}
+
+// readResponse reads a line along with the literals it announces, e.g.
+// "BODY[] {42}".
+func (c *Client) readResponse() (response, error) {
+	var resp response
+
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return resp, err
+		}
+
+		resp.line += line
+
+		size, ok := literalSize(line)
+		if !ok {
+			return resp, nil
+		}
+
+		if size > maxLiteral {
+			return resp, errors.New("literal of " + strconv.Itoa(size) + " bytes is too large")
+		}
+
+		literal := make([]byte, size)
+		if _, err = io.ReadFull(c.r, literal); err != nil {
+			return resp, errors.Wrap(err, "read literal")
+		}
+
+		resp.literals = append(resp.literals, literal)
+	}
+}
+
+func (c *Client) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", errors.Wrap(err, "read response")
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// literalSize parses the size of the literal which ends the line.
+func literalSize(line string) (int, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+
+	start := strings.LastIndexByte(line, '{')
+	if start < 0 {
+		return 0, false
+	}
+
+	size, err := strconv.Atoi(line[start+1 : len(line)-1])
+	if err != nil || size < 0 {
+		return 0, false
+	}
+
+	return size, true
+}
+
+// quote returns the quoted string of the argument.
+func quote(s string) (string, error) {
+	if strings.ContainsAny(s, "\r\n") {
+		return "", ErrUnsafeArgument
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`, nil
+}
@@ -0,0 +1,219 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ingest opens the emails which deposit the secrets: the body is an
+// OpenPGP message encrypted to the key of the bot and signed by a known key.
+package ingest
+
+import (
+	"bytes"
+	// The hashes of the signatures made by GnuPG.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+const (
+	armorHeader = "-----BEGIN PGP MESSAGE-----"
+	armorFooter = "-----END PGP MESSAGE-----"
+
+	maxPlaintext = 1 << 20
+	maxParts     = 16
+)
+
+var (
+	ErrNoMessage = errors.New("email has no PGP message")
+	ErrUnsigned  = errors.New("message isn't signed by a known key")
+	ErrLockedKey = errors.New("private key is protected with a passphrase")
+	ErrNoSigners = errors.New("no signer keys")
+	ErrNoPrivate = errors.New("no private key")
+	ErrTooLarge  = errors.New("message is too large")
+
+	// ErrUnencrypted refuses the messages which are only signed, the secret
+	// went through the mail servers in the clear.
+	ErrUnencrypted = errors.New("message isn't encrypted")
+)
+
+// Keys are the private key the emails are encrypted to and the public keys
+// of the senders.
+type Keys struct {
+	private openpgp.EntityList
+	signers openpgp.EntityList
+}
+
+// LoadKeys reads the armored private key and the armored public keys of the
+// signers.
+func LoadKeys(keyFile, signersFile string) (*Keys, error) {
+	private, err := readKeyRing(keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "read private key")
+	}
+
+	if len(private.DecryptionKeys()) == 0 {
+		return nil, ErrNoPrivate
+	}
+
+	for _, key := range private.DecryptionKeys() {
+		if key.PrivateKey.Encrypted {
+			return nil, ErrLockedKey
+		}
+	}
+
+	signers, err := readKeyRing(signersFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "read signer keys")
+	}
+
+	if len(signers) == 0 {
+		return nil, ErrNoSigners
+	}
+
+	return &Keys{private: private, signers: signers}, nil
+}
+
+func readKeyRing(path string) (openpgp.EntityList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open file")
+	}
+
+	defer file.Close()
+
+	return openpgp.ReadArmoredKeyRing(file)
+}
+
+// Open finds the PGP message of the email, inline or PGP/MIME, decrypts it and
+// checks its signature. The plaintext is returned along with the identity of
+// the signer.
+func (k *Keys) Open(raw []byte) ([]byte, string, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "parse email")
+	}
+
+	armored, err := findArmored(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	block, err := armor.Decode(bytes.NewReader(armored))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "decode armor")
+	}
+
+	keyring := append(append(openpgp.EntityList{}, k.private...), k.signers...)
+
+	md, err := openpgp.ReadMessage(block.Body, keyring, nil, nil)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "decrypt")
+	}
+
+	if !md.IsEncrypted {
+		return nil, "", ErrUnencrypted
+	}
+
+	plaintext, err := io.ReadAll(io.LimitReader(md.UnverifiedBody, maxPlaintext+1))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "read plaintext")
+	}
+
+	if len(plaintext) > maxPlaintext {
+		return nil, "", ErrTooLarge
+	}
+
+	// The signature is checked once the body is read to the end.
+	if !md.IsSigned || md.SignedBy == nil || md.SignatureError != nil || !k.isSigner(md.SignedByKeyId) {
+		return nil, "", ErrUnsigned
+	}
+
+	return plaintext, signerName(md.SignedBy.Entity), nil
+}
+
+func (k *Keys) isSigner(id uint64) bool {
+	return len(k.signers.KeysById(id)) > 0
+}
+
+func signerName(entity *openpgp.Entity) string {
+	for name := range entity.Identities {
+		return name
+	}
+
+	return entity.PrimaryKey.KeyIdString()
+}
+
+// findArmored returns the first armored PGP message of the body, the parts of
+// a multipart body are searched in order.
+func findArmored(contentType, encoding string, body io.Reader, depth int) ([]byte, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth > 1 {
+			return nil, ErrNoMessage
+		}
+
+		reader := multipart.NewReader(body, params["boundary"])
+
+		for i := 0; i < maxParts; i++ {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+
+			armored, err := findArmored(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part, depth+1)
+			if err == nil {
+				return armored, nil
+			}
+		}
+
+		return nil, ErrNoMessage
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxPlaintext))
+	if err != nil {
+		return nil, errors.Wrap(err, "read body")
+	}
+
+	start := bytes.Index(data, []byte(armorHeader))
+	if start < 0 {
+		return nil, ErrNoMessage
+	}
+
+	end := bytes.Index(data[start:], []byte(armorFooter))
+	if end < 0 {
+		return nil, ErrNoMessage
+	}
+
+	return data[start : start+end+len(armorFooter)], nil
+}
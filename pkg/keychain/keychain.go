@@ -0,0 +1,99 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package keychain caches the unlocked key of a vault in the credential store
+// of the OS, the Keychain of macOS, the Secret Service of libsecret or the
+// Windows Credential Manager, so the CLI commands run in a row ask for the
+// master password once.
+package keychain
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// service names the items of the bot in the credential store.
+const service = "secretable"
+
+// ErrNotFound is returned for a missing item.
+var ErrNotFound = errors.New("item not found")
+
+// Store is the credential store of the OS, the items are addressed by the
+// service and the account.
+type Store interface {
+	Get(service, account string) (string, error)
+	Set(service, account, label, secret string) error
+	Delete(service, account string) error
+}
+
+// Cache keeps the keys in the store until the expiry written along with
+// them, the store has no expiry of its own.
+type Cache struct {
+	store Store
+}
+
+// New returns the cache in the credential store of the OS.
+func New() *Cache {
+	return &Cache{store: system()}
+}
+
+// Put caches the key of the account for the TTL.
+func (c *Cache) Put(account string, key []byte, ttl time.Duration) error {
+	value := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10) + ":" + base64.StdEncoding.EncodeToString(key)
+
+	return errors.Wrap(c.store.Set(service, account, "Secretable vault key "+account, value), "set item")
+}
+
+// Get returns the cached key of the account, the expired key is deleted and
+// reported as missing.
+func (c *Cache) Get(account string) ([]byte, error) {
+	value, err := c.store.Get(service, account)
+	if err != nil {
+		return nil, errors.Wrap(err, "get item")
+	}
+
+	sep := strings.IndexByte(value, ':')
+	if sep < 0 {
+		return nil, errors.New("malformed item")
+	}
+
+	expires, err := strconv.ParseInt(value[:sep], 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse expiry")
+	}
+
+	if time.Now().Unix() >= expires {
+		_ = c.Delete(account)
+
+		return nil, ErrNotFound
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[sep+1:]))
+
+	return key, errors.Wrap(err, "decode key")
+}
+
+// Delete drops the cached key of the account, the missing key is fine.
+func (c *Cache) Delete(account string) error {
+	err := c.store.Delete(service, account)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+
+	return errors.Wrap(err, "delete item")
+}
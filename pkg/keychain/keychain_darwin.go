@@ -0,0 +1,81 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keychain
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// errItemNotFound is the exit code of security for a missing item.
+const errItemNotFound = 44
+
+// securityStore is the login Keychain managed with the security tool.
+type securityStore struct{}
+
+func system() Store {
+	return securityStore{}
+}
+
+func (securityStore) Get(service, account string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w").Output()
+	if err != nil {
+		return "", securityError(err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Set writes the item with the interactive mode, so the secret is passed on
+// the standard input instead of the arguments seen by the other processes.
+func (securityStore) Set(service, account, label, secret string) error {
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader("add-generic-password -U -s " + quote(service) + " -a " + quote(account) +
+		" -l " + quote(label) + " -w " + quote(secret) + "\n")
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrap(err, strings.TrimSpace(stderr.String()))
+	}
+
+	// The interactive mode exits successfully after a failed command.
+	if stderr.Len() > 0 {
+		return errors.New(strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+func (securityStore) Delete(service, account string) error {
+	return securityError(exec.Command("security", "delete-generic-password", "-s", service, "-a", account).Run())
+}
+
+func securityError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == errItemNotFound {
+		return ErrNotFound
+	}
+
+	return errors.Wrap(err, "security")
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+package keychain
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// secretServiceStore is the Secret Service of the session, e.g. GNOME Keyring
+// or KWallet, managed with secret-tool of libsecret.
+type secretServiceStore struct{}
+
+func system() Store {
+	return secretServiceStore{}
+}
+
+// Get returns the item, secret-tool exits with an error and prints nothing
+// for a missing one.
+func (secretServiceStore) Get(service, account string) (string, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && stderr.Len() == 0 && len(out) == 0 {
+		return "", ErrNotFound
+	}
+
+	if err != nil {
+		return "", errors.Wrap(err, "secret-tool: "+strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Set writes the item, the secret is passed on the standard input.
+func (secretServiceStore) Set(service, account, label, secret string) error {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("secret-tool", "store", "--label="+label, "service", service, "account", account)
+	cmd.Stdin = strings.NewReader(secret)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrap(err, "secret-tool: "+strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// Delete deletes the item, secret-tool succeeds for a missing one.
+func (secretServiceStore) Delete(service, account string) error {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("secret-tool", "clear", "service", service, "account", account)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrap(err, "secret-tool: "+strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keychain
+
+import (
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+)
+
+var (
+	advapi32     = windows.NewLazySystemDLL("advapi32.dll")
+	credReadW    = advapi32.NewProc("CredReadW")
+	credWriteW   = advapi32.NewProc("CredWriteW")
+	credDeleteW  = advapi32.NewProc("CredDeleteW")
+	credFreeProc = advapi32.NewProc("CredFree")
+)
+
+// credential is CREDENTIALW of wincred.h.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// credentialStore is the Windows Credential Manager, the items are the
+// generic credentials of the user named service:account.
+type credentialStore struct{}
+
+func system() Store {
+	return credentialStore{}
+}
+
+func (credentialStore) Get(service, account string) (string, error) {
+	target, err := windows.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return "", err
+	}
+
+	var cred *credential
+
+	ret, _, err := credReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ret == 0 {
+		return "", credError(err)
+	}
+
+	defer credFreeProc.Call(uintptr(unsafe.Pointer(cred)))
+
+	blob := unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)
+
+	return string(blob), nil
+}
+
+func (credentialStore) Set(service, account, label, secret string) error {
+	target, err := windows.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return err
+	}
+
+	comment, err := windows.UTF16PtrFromString(label)
+	if err != nil {
+		return err
+	}
+
+	user, err := windows.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+
+	blob := []byte(secret)
+
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		Comment:            comment,
+		CredentialBlobSize: uint32(len(blob)),
+		CredentialBlob:     &blob[0],
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+
+	if ret, _, err := credWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0); ret == 0 {
+		return credError(err)
+	}
+
+	return nil
+}
+
+func (credentialStore) Delete(service, account string) error {
+	target, err := windows.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return err
+	}
+
+	if ret, _, err := credDeleteW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0); ret == 0 {
+		return credError(err)
+	}
+
+	return nil
+}
+
+func credError(err error) error {
+	if errors.Is(err, windows.ERROR_NOT_FOUND) {
+		return ErrNotFound
+	}
+
+	return errors.Wrap(err, "credential manager")
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyslots
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"secretable/pkg/crypto"
+	"strconv"
+
+	"github.com/mr-tron/base58/base58"
+	"github.com/pkg/errors"
+)
+
+const (
+	// keyfileMinSize is the least number of the bytes of a key file.
+	keyfileMinSize = 32
+	slotSaltSize   = 16
+)
+
+// Keyfile wraps the key with a file of random bytes, e.g. on a removable
+// drive. The data is the salt of the slot, the nonce and the ciphertext in
+// base58.
+type Keyfile struct {
+	content []byte
+}
+
+// NewKeyfile reads the key file, e.g. made with
+// "head -c 64 /dev/urandom > secretable.key".
+func NewKeyfile(path string) (*Keyfile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read key file")
+	}
+
+	if len(b) < keyfileMinSize {
+		return nil, errors.New("key file is shorter than " + strconv.Itoa(keyfileMinSize) + " bytes")
+	}
+
+	return &Keyfile{content: b}, nil
+}
+
+func (k *Keyfile) Type() string {
+	return TypeKeyfile
+}
+
+func (k *Keyfile) Wrap(_ context.Context, key []byte) (string, error) {
+	salt, err := crypto.MakeRandom(slotSaltSize)
+	if err != nil {
+		return "", errors.Wrap(err, "make salt")
+	}
+
+	sealed, err := seal(k.aesKey(salt), key)
+	if err != nil {
+		return "", err
+	}
+
+	return base58.Encode(append(salt, sealed...)), nil
+}
+
+func (k *Keyfile) Unwrap(_ context.Context, data string) ([]byte, error) {
+	b, err := base58.Decode(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "base58 decode")
+	}
+
+	if len(b) < slotSaltSize {
+		return nil, ErrInvalidSlots
+	}
+
+	return open(k.aesKey(b[:slotSaltSize]), b[slotSaltSize:])
+}
+
+// aesKey derives the key of the slot, the file is random so no stretching is
+// needed.
+func (k *Keyfile) aesKey(salt []byte) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write(k.content)
+
+	return h.Sum(nil)
+}
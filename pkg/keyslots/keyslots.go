@@ -0,0 +1,259 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package keyslots wraps the private key of a vault in several slots, like
+// the key slots of LUKS, so the vault is opened by the master password, a key
+// file, a KMS key or a hardware token, whichever is at hand. The stored key of
+// a vault with the password slot only keeps the format of the single wrapped
+// key.
+package keyslots
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"secretable/pkg/crypto"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	TypePassword = "password"
+	TypeKeyfile  = "keyfile"
+	TypeKMS      = "kms"
+	TypeYubiKey  = "yubikey"
+	// TypeSigned is the marker of the vault whose secrets are all signed, it
+	// wraps no key and isn't counted as a slot.
+	TypeSigned = "signed"
+
+	// slotsPrefix marks the stored key of several slots.
+	slotsPrefix = "slots:"
+)
+
+var (
+	// ErrNoSlot is returned if none of the unlockers has a slot of the key.
+	ErrNoSlot = errors.New("no slot of the unlockers")
+	// ErrLastSlot refuses to remove the only slot of the key.
+	ErrLastSlot     = errors.New("the last slot can't be removed")
+	ErrInvalidSlots = errors.New("invalid slots")
+)
+
+// Unlocker wraps the private key into the data of its slot and unwraps it
+// back.
+type Unlocker interface {
+	// Type is the type of the slots of the unlocker.
+	Type() string
+	Wrap(ctx context.Context, key []byte) (string, error)
+	Unwrap(ctx context.Context, data string) ([]byte, error)
+}
+
+// Slot is the key wrapped by the unlocker of the type, a key has one slot of
+// every type at most.
+type Slot struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// Slots are the slots of the stored key.
+type Slots []Slot
+
+// Parse decodes the stored key, the single wrapped key is the password slot.
+func Parse(key string) (Slots, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(key, slotsPrefix) {
+		return Slots{{Type: TypePassword, Data: key}}, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(key, slotsPrefix))
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidSlots, err.Error())
+	}
+
+	var slots Slots
+	if err = json.Unmarshal(b, &slots); err != nil || len(slots) == 0 {
+		return nil, ErrInvalidSlots
+	}
+
+	return slots, nil
+}
+
+// String encodes the slots for the storage, the password slot alone is the
+// single wrapped key, so the older versions and the tools read it.
+func (s Slots) String() string {
+	if len(s) == 0 {
+		return ""
+	}
+
+	if len(s) == 1 && s[0].Type == TypePassword {
+		return s[0].Data
+	}
+
+	sorted := make(Slots, len(s))
+	copy(sorted, s)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Type < sorted[j].Type })
+
+	b, _ := json.Marshal(sorted)
+
+	return slotsPrefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Has reports whether the key has the slot of the type.
+func (s Slots) Has(typ string) bool {
+	for _, slot := range s {
+		if slot.Type == typ {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Open unwraps the key with the first unlocker which has a slot, the error of
+// the last tried slot is returned if none opens it.
+func (s Slots) Open(ctx context.Context, unlockers ...Unlocker) ([]byte, error) {
+	err := ErrNoSlot
+
+	for _, unlocker := range unlockers {
+		for _, slot := range s {
+			if slot.Type != unlocker.Type() {
+				continue
+			}
+
+			key, uerr := unlocker.Unwrap(ctx, slot.Data)
+			if uerr == nil {
+				return key, nil
+			}
+
+			err = errors.Wrap(uerr, "open "+slot.Type+" slot")
+		}
+	}
+
+	return nil, err
+}
+
+// Put wraps the key with the unlocker into its slot, the slot of the same type
+// is replaced.
+func (s Slots) Put(ctx context.Context, key []byte, unlocker Unlocker) (Slots, error) {
+	data, err := unlocker.Wrap(ctx, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "wrap "+unlocker.Type()+" slot")
+	}
+
+	slots := make(Slots, 0, len(s)+1)
+
+	for _, slot := range s {
+		if slot.Type != unlocker.Type() {
+			slots = append(slots, slot)
+		}
+	}
+
+	return append(slots, Slot{Type: unlocker.Type(), Data: data}), nil
+}
+
+// Remove drops the slot of the type, the last slot is kept.
+func (s Slots) Remove(typ string) (Slots, error) {
+	slots := make(Slots, 0, len(s))
+	keys := 0
+
+	for _, slot := range s {
+		if slot.Type != typ {
+			slots = append(slots, slot)
+
+			if slot.Type != TypeSigned {
+				keys++
+			}
+		}
+	}
+
+	if keys == 0 {
+		return s, ErrLastSlot
+	}
+
+	return slots, nil
+}
+
+// Marker returns the data of the signed marker, empty if the key has none.
+func (s Slots) Marker() string {
+	for _, slot := range s {
+		if slot.Type == TypeSigned {
+			return slot.Data
+		}
+	}
+
+	return ""
+}
+
+// Mark returns the slots with the signed marker of the data.
+func (s Slots) Mark(data string) Slots {
+	slots := make(Slots, 0, len(s)+1)
+
+	for _, slot := range s {
+		if slot.Type != TypeSigned {
+			slots = append(slots, slot)
+		}
+	}
+
+	return append(slots, Slot{Type: TypeSigned, Data: data})
+}
+
+// seal encrypts the key with AES-256-GCM, the nonce is prepended.
+func seal(aesKey, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := crypto.MakeRandom(gcm.NonceSize())
+	if err != nil {
+		return nil, errors.Wrap(err, "make nonce")
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aesKey, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrInvalidSlots
+	}
+
+	b, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.Wrap(crypto.ErrDecrypt, err.Error())
+	}
+
+	return b, nil
+}
+
+func newGCM(aesKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "aes new cipher")
+	}
+
+	gcm, err := cipher.NewGCM(block)
+
+	return gcm, errors.Wrap(err, "new gcm")
+}
@@ -0,0 +1,147 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyslots
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"secretable/pkg/sigv4"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	kmsTimeout = 30 * time.Second
+	// kmsMaxSize limits the response of the API.
+	kmsMaxSize = 1 << 20
+)
+
+// KMS wraps the key with a symmetric key of AWS KMS, the data is the
+// ciphertext blob of the Encrypt API in base64. The key never leaves KMS, so
+// the slot is opened only by the identity allowed to decrypt with it.
+type KMS struct {
+	endpoint    string
+	region      string
+	keyID       string
+	credentials sigv4.Credentials
+	client      *http.Client
+}
+
+// NewKMS returns the unlocker of the key ID or alias, the endpoint defaults
+// to the one of the region.
+func NewKMS(endpoint, region, keyID string, credentials sigv4.Credentials) *KMS {
+	if endpoint == "" {
+		endpoint = "https://kms." + region + ".amazonaws.com"
+	}
+
+	return &KMS{
+		endpoint:    strings.TrimSuffix(endpoint, "/") + "/",
+		region:      region,
+		keyID:       keyID,
+		credentials: credentials,
+		client:      &http.Client{Timeout: kmsTimeout},
+	}
+}
+
+func (k *KMS) Type() string {
+	return TypeKMS
+}
+
+func (k *KMS) Wrap(ctx context.Context, key []byte) (string, error) {
+	var resp struct {
+		CiphertextBlob string `json:"CiphertextBlob"`
+	}
+
+	err := k.call(ctx, "Encrypt", map[string]interface{}{
+		"KeyId":     k.keyID,
+		"Plaintext": base64.StdEncoding.EncodeToString(key),
+	}, &resp)
+
+	return resp.CiphertextBlob, err
+}
+
+func (k *KMS) Unwrap(ctx context.Context, data string) ([]byte, error) {
+	var resp struct {
+		Plaintext string `json:"Plaintext"`
+	}
+
+	err := k.call(ctx, "Decrypt", map[string]interface{}{
+		"KeyId":          k.keyID,
+		"CiphertextBlob": data,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+
+	return key, errors.Wrap(err, "decode plaintext")
+}
+
+// call sends the signed request of the action and decodes the response into
+// the result.
+func (k *KMS) call(ctx context.Context, action string, params, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return errors.Wrap(err, "encode request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+
+	endpoint, _ := url.Parse(k.endpoint)
+	k.credentials.Sign(req, "kms", k.region, sigv4.EscapePath(endpoint.Path), "", body, time.Now().UTC())
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, action)
+	}
+
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, kmsMaxSize))
+	if err != nil {
+		return errors.Wrap(err, "read response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+
+		_ = json.Unmarshal(b, &apiErr)
+
+		if apiErr.Type == "" {
+			return errors.New(action + ": " + resp.Status)
+		}
+
+		return errors.New(action + ": " + apiErr.Type[strings.LastIndex(apiErr.Type, "#")+1:] + ": " + apiErr.Message)
+	}
+
+	return errors.Wrap(json.Unmarshal(b, result), "decode "+action)
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyslots
+
+import (
+	"context"
+	"sync"
+)
+
+// Memo remembers the keys unwrapped by the unlocker, so a slot of KMS or a
+// YubiKey is opened once instead of at every request of the bot.
+type Memo struct {
+	Unlocker
+
+	keys map[string][]byte
+	mx   sync.Mutex
+}
+
+func NewMemo(unlocker Unlocker) *Memo {
+	return &Memo{Unlocker: unlocker, keys: make(map[string][]byte)}
+}
+
+func (m *Memo) Unwrap(ctx context.Context, data string) ([]byte, error) {
+	m.mx.Lock()
+	key, ok := m.keys[data]
+	m.mx.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	key, err := m.Unlocker.Unwrap(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mx.Lock()
+	m.keys[data] = key
+	m.mx.Unlock()
+
+	return key, nil
+}
+
+// Forget drops the remembered keys.
+func (m *Memo) Forget() {
+	m.mx.Lock()
+	m.keys = make(map[string][]byte)
+	m.mx.Unlock()
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyslots
+
+import (
+	"context"
+	"secretable/pkg/crypto"
+	"strings"
+
+	"github.com/mr-tron/base58/base58"
+	"github.com/pkg/errors"
+)
+
+// kdfSeparator ends the KDF of the password slot data, the base58 alphabet
+// has no "$".
+const kdfSeparator = "$"
+
+// Password wraps the key with the master password and the salt of the config,
+// the data is the nonce and the ciphertext in base58. The data of another KDF
+// than the DefaultKDF is prefixed with the KDF and "$".
+type Password struct {
+	pass string
+	salt string
+	kdf  crypto.KDF
+}
+
+func NewPassword(pass, salt string) Password {
+	return Password{pass: pass, salt: salt, kdf: crypto.DefaultKDF}
+}
+
+// WithKDF returns the password which wraps the key with the KDF, the slots
+// are unwrapped with the KDF of their data anyway.
+func (p Password) WithKDF(kdf crypto.KDF) Password {
+	p.kdf = kdf
+
+	return p
+}
+
+func (p Password) Type() string {
+	return TypePassword
+}
+
+func (p Password) Wrap(_ context.Context, key []byte) (string, error) {
+	nonce, err := crypto.MakeRandom(crypto.NonceSize)
+	if err != nil {
+		return "", errors.Wrap(err, "make nonce")
+	}
+
+	cypher, err := p.kdf.Encrypt([]byte(p.pass), []byte(p.salt), nonce, key)
+	if err != nil {
+		return "", errors.Wrap(err, "encrypt with phrase")
+	}
+
+	data := base58.Encode(append(nonce, cypher...))
+	if p.kdf != crypto.DefaultKDF {
+		data = p.kdf.String() + kdfSeparator + data
+	}
+
+	return data, nil
+}
+
+func (p Password) Unwrap(_ context.Context, data string) ([]byte, error) {
+	kdf, data, err := splitKDF(data)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := base58.Decode(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "base58 decode")
+	}
+
+	if len(b) < crypto.NonceSize {
+		return nil, ErrInvalidSlots
+	}
+
+	key, err := kdf.Decrypt([]byte(p.pass), []byte(p.salt), b[:crypto.NonceSize], b[crypto.NonceSize:])
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt with phrase")
+	}
+
+	return key, nil
+}
+
+// PasswordKDF returns the KDF of the password slot, ok is false if the key has
+// none.
+func (s Slots) PasswordKDF() (crypto.KDF, bool, error) {
+	for _, slot := range s {
+		if slot.Type != TypePassword {
+			continue
+		}
+
+		kdf, _, err := splitKDF(slot.Data)
+
+		return kdf, err == nil, err
+	}
+
+	return crypto.KDF{}, false, nil
+}
+
+func splitKDF(data string) (crypto.KDF, string, error) {
+	i := strings.LastIndex(data, kdfSeparator)
+	if i < 0 {
+		return crypto.DefaultKDF, data, nil
+	}
+
+	kdf, err := crypto.ParseKDF(data[:i])
+	if err != nil {
+		return crypto.KDF{}, "", errors.Wrap(ErrInvalidSlots, err.Error())
+	}
+
+	return kdf, data[i+1:], nil
+}
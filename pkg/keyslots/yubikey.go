@@ -0,0 +1,118 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyslots
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"secretable/pkg/crypto"
+	"strconv"
+	"strings"
+
+	"github.com/mr-tron/base58/base58"
+	"github.com/pkg/errors"
+)
+
+// challengeSize is the length of the HMAC-SHA1 challenge, the YubiKey takes
+// 64 bytes at most.
+const challengeSize = 32
+
+// YubiKey wraps the key with the HMAC-SHA1 response of the challenge-response
+// slot of a YubiKey, computed by ykman. The data is the random challenge of
+// the slot, the nonce and the ciphertext in base58, the same YubiKey is needed
+// to open it.
+type YubiKey struct {
+	slot int
+}
+
+// NewYubiKey returns the unlocker of the OTP slot 1 or 2 programmed for the
+// challenge-response, e.g. with "ykman otp chalresp --generate 2".
+func NewYubiKey(slot int) (*YubiKey, error) {
+	if slot != 1 && slot != 2 {
+		return nil, errors.New("yubikey slot must be 1 or 2")
+	}
+
+	return &YubiKey{slot: slot}, nil
+}
+
+func (y *YubiKey) Type() string {
+	return TypeYubiKey
+}
+
+func (y *YubiKey) Wrap(ctx context.Context, key []byte) (string, error) {
+	challenge, err := crypto.MakeRandom(challengeSize)
+	if err != nil {
+		return "", errors.Wrap(err, "make challenge")
+	}
+
+	aesKey, err := y.aesKey(ctx, challenge)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := seal(aesKey, key)
+	if err != nil {
+		return "", err
+	}
+
+	return base58.Encode(append(challenge, sealed...)), nil
+}
+
+func (y *YubiKey) Unwrap(ctx context.Context, data string) ([]byte, error) {
+	b, err := base58.Decode(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "base58 decode")
+	}
+
+	if len(b) < challengeSize {
+		return nil, ErrInvalidSlots
+	}
+
+	aesKey, err := y.aesKey(ctx, b[:challengeSize])
+	if err != nil {
+		return nil, err
+	}
+
+	return open(aesKey, b[challengeSize:])
+}
+
+// aesKey derives the key of the slot from the response of the YubiKey, ykman
+// asks on the standard error to touch the key if the slot requires it.
+func (y *YubiKey) aesKey(ctx context.Context, challenge []byte) ([]byte, error) {
+	var stdout bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "ykman", "otp", "calculate", strconv.Itoa(y.slot), hex.EncodeToString(challenge))
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrap(err, "ykman otp calculate")
+	}
+
+	response, err := hex.DecodeString(strings.TrimSpace(stdout.String()))
+	if err != nil || len(response) == 0 {
+		return nil, errors.New("unexpected response of ykman: " + strings.TrimSpace(stdout.String()))
+	}
+
+	h := sha256.New()
+	h.Write(challenge)
+	h.Write(response)
+
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localizator
+
+import (
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// The settings of the locale are the keys of its file.
+const (
+	directionKey          = "locale_direction"
+	dateFormatKey         = "locale_date_format"
+	thousandsSeparatorKey = "locale_thousands_separator"
+
+	directionRTL      = "rtl"
+	defaultDateFormat = "02 Jan 06 15:04 MST"
+)
+
+// Unicode bidi marks keeping the Latin values and digits in place inside the
+// right-to-left text.
+const (
+	rightToLeftMark       = "\u200f"
+	firstStrongIsolate    = "\u2068"
+	popDirectionalIsolate = "\u2069"
+)
+
+// funcs returns the template functions of the locale.
+func (l *Localizator) funcs(locale string) template.FuncMap {
+	return template.FuncMap{
+		"plural":  pluralFunc(locale),
+		"date":    func(t time.Time) string { return l.FormatTime(locale, t) },
+		"number":  func(n interface{}) string { return l.FormatNumber(locale, toInt(n)) },
+		"isolate": func(s string) string { return l.Isolate(locale, s) },
+	}
+}
+
+// RTL reports whether the locale is written from right to left.
+func (l *Localizator) RTL(locale string) bool {
+	return l.Get(locale, directionKey) == directionRTL
+}
+
+// FormatTime formats the time with the date format of the locale.
+func (l *Localizator) FormatTime(locale string, t time.Time) string {
+	layout := l.Get(locale, dateFormatKey)
+	if layout == "" {
+		layout = defaultDateFormat
+	}
+
+	return t.Format(layout)
+}
+
+// FormatNumber groups the thousands of the number with the separator of the
+// locale.
+func (l *Localizator) FormatNumber(locale string, n int64) string {
+	digits := strconv.FormatInt(abs(n), 10)
+	sep := l.Get(locale, thousandsSeparatorKey)
+
+	if sep == "" || len(digits) <= 3 {
+		return strconv.FormatInt(n, 10)
+	}
+
+	var out []byte
+	if n < 0 {
+		out = append(out, '-')
+	}
+
+	for i := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			out = append(out, sep...)
+		}
+
+		out = append(out, digits[i])
+	}
+
+	return string(out)
+}
+
+// Isolate keeps the direction of the value, e.g. a Latin description, from
+// reordering the right-to-left text around it. The copyable <code> values are
+// not isolated, the marks would be copied along with them.
+func (l *Localizator) Isolate(locale, s string) string {
+	if !l.RTL(locale) {
+		return s
+	}
+
+	return firstStrongIsolate + s + popDirectionalIsolate
+}
@@ -5,25 +5,94 @@ import (
 	"io/fs"
 	"path/filepath"
 	"secretable/pkg/log"
+	"sort"
 	"strings"
 	"sync"
+	"text/template"
 
 	"github.com/pkg/errors"
 )
 
+// Args are the named parameters of a localized template.
+type Args map[string]interface{}
+
 type Localizator struct {
-	locales []string
-	m       sync.Map
+	mx        sync.RWMutex
+	sources   []source
+	strings   map[string]string
+	templates map[string]*template.Template
+	known     map[string]bool
+
+	// fallbacks are the keys whose fallback is already logged.
+	fallbacks sync.Map
+}
+
+// source is a directory of <locale>.json files, the later sources override
+// the keys of the earlier ones.
+type source struct {
+	filesystem fs.FS
+	basePath   string
 }
 
+// InitFromFS loads the locales of the directory over the already loaded ones.
 func (l *Localizator) InitFromFS(filesystem fs.FS, basePath string) error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if l.strings == nil {
+		l.strings = make(map[string]string)
+		l.templates = make(map[string]*template.Template)
+	}
+
+	if err := l.load(filesystem, basePath, l.strings, l.templates); err != nil {
+		return err
+	}
+
+	l.sources = append(l.sources, source{filesystem: filesystem, basePath: basePath})
+	l.known = knownLocales(l.strings)
+
+	return nil
+}
+
+// Reload reads all the loaded directories again. The current locales are kept
+// if one of the directories is unreadable.
+func (l *Localizator) Reload() error {
+	l.mx.RLock()
+	sources := l.sources
+	l.mx.RUnlock()
+
+	strs := make(map[string]string)
+	templates := make(map[string]*template.Template)
+
+	for _, src := range sources {
+		if err := l.load(src.filesystem, src.basePath, strs, templates); err != nil {
+			return err
+		}
+	}
+
+	l.mx.Lock()
+	l.strings = strs
+	l.templates = templates
+	l.known = knownLocales(strs)
+	l.mx.Unlock()
+
+	l.fallbacks.Range(func(key, _ interface{}) bool {
+		l.fallbacks.Delete(key)
+
+		return true
+	})
+
+	return nil
+}
+
+func (l *Localizator) load(filesystem fs.FS, basePath string, strs map[string]string, templates map[string]*template.Template) error {
 	files, err := fs.ReadDir(filesystem, basePath)
 	if err != nil {
 		return errors.Wrap(err, "read directory")
 	}
 
 	for _, file := range files {
-		if file.IsDir() {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
 			continue
 		}
 
@@ -45,36 +114,129 @@ func (l *Localizator) InitFromFS(filesystem fs.FS, basePath string) error {
 			continue
 		}
 
-		shortlocale := strings.TrimSuffix(file.Name(), ".json")
+		shortlocale := strings.ToLower(strings.TrimSuffix(file.Name(), ".json"))
 
-		l.locales = append(l.locales, shortlocale)
 		for k, v := range mkv {
-			l.m.Store(shortlocale+"."+k, v)
+			strs[shortlocale+"."+k] = v
+
+			delete(templates, shortlocale+"."+k)
+
+			if !strings.Contains(v, "{{") {
+				continue
+			}
+
+			tmpl, err := template.New(k).Option("missingkey=error").
+				Funcs(l.funcs(shortlocale)).Parse(v)
+			if err != nil {
+				log.Error("Parse template " + shortlocale + "." + k + " :" + err.Error())
+
+				continue
+			}
+
+			templates[shortlocale+"."+k] = tmpl
 		}
 	}
 
 	return nil
 }
 
+func knownLocales(strs map[string]string) map[string]bool {
+	known := make(map[string]bool)
+
+	for fullKey := range strs {
+		locale, _ := splitKey(fullKey)
+		known[locale] = true
+	}
+
+	return known
+}
+
 func (l *Localizator) GetLocales() []string {
-	a := make([]string, len(l.locales))
-	copy(a, l.locales)
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	a := make([]string, 0, len(l.known))
+	for locale := range l.known {
+		a = append(a, locale)
+	}
+
+	sort.Strings(a)
 
 	return a
 }
 
 func (l *Localizator) Get(locale string, key string) string {
-	value, exists := l.m.Load(locale + "." + key)
-	if !exists {
-		if locale != "en" {
-			locale = "en"
-			value, exists = l.m.Load(locale + "." + key)
+	value, _ := l.lookup(locale, key)
+
+	return value
+}
+
+// Format executes the localized template with the named arguments, e.g.
+// {{.Count}} {{plural .Count "secret" "secrets"}}. The raw string is returned
+// if the template fails. The right-to-left text starts with the RLM mark, so
+// Telegram aligns it to the right even if it begins with a Latin value.
+func (l *Localizator) Format(locale string, key string, args Args) string {
+	value, found := l.lookup(locale, key)
+
+	l.mx.RLock()
+	tmpl, ok := l.templates[found+"."+key]
+	l.mx.RUnlock()
+
+	if ok && found != strings.ToLower(locale) {
+		// The fallback template formats the values for the requested locale.
+		tmpl = template.Must(tmpl.Clone()).Funcs(l.funcs(strings.ToLower(locale)))
+	}
+
+	if ok {
+		var bld strings.Builder
+
+		if err := tmpl.Execute(&bld, args); err != nil {
+			log.Error("Execute template " + found + "." + key + " :" + err.Error())
+		} else {
+			value = bld.String()
 		}
+	}
 
-		if !exists {
-			return ""
+	if l.RTL(locale) {
+		return rightToLeftMark + value
+	}
+
+	return value
+}
+
+// lookup returns the value of the key and the locale it was found in. A
+// regional locale like pt-br falls back to pt and then to en. The fallbacks of
+// the loaded locales are logged once per key.
+func (l *Localizator) lookup(locale string, key string) (string, string) {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	locale = strings.ToLower(locale)
+
+	candidates := []string{locale}
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		candidates = append(candidates, locale[:i])
+	}
+
+	candidates = append(candidates, referenceLocale)
+
+	primary := ""
+
+	for _, candidate := range candidates {
+		if primary == "" && l.known[candidate] {
+			primary = candidate
+		}
+
+		if value, ok := l.strings[candidate+"."+key]; ok {
+			if primary != "" && primary != candidate {
+				l.logFallback(primary, key, candidate)
+			}
+
+			return value, candidate
 		}
 	}
 
-	return value.(string)
+	l.logFallback(locale, key, "")
+
+	return "", referenceLocale
 }
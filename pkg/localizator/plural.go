@@ -0,0 +1,113 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localizator
+
+import "strings"
+
+// pluralRule returns the index of the plural form of n.
+type pluralRule func(n int64) int
+
+// pluralRules are the CLDR cardinal rules of the languages with more than the
+// "one" and "other" forms. The other languages use the English rule.
+var pluralRules = map[string]pluralRule{
+	"ru": slavicRule,
+	"uk": slavicRule,
+	"be": slavicRule,
+	"ja": noPluralRule,
+	"ko": noPluralRule,
+	"zh": noPluralRule,
+}
+
+// englishRule selects between the "one" and "other" forms.
+func englishRule(n int64) int {
+	if n == 1 {
+		return 0
+	}
+
+	return 1
+}
+
+// slavicRule selects between the "one", "few" and "many" forms.
+func slavicRule(n int64) int {
+	switch {
+	case n%10 == 1 && n%100 != 11:
+		return 0
+	case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func noPluralRule(int64) int {
+	return 0
+}
+
+// pluralFunc returns the "plural" template function of the locale, it picks
+// one of the forms by the number: {{plural .Count "secret" "secrets"}}.
+func pluralFunc(locale string) func(n interface{}, forms ...string) string {
+	rule, ok := pluralRules[strings.ToLower(strings.SplitN(locale, "-", 2)[0])]
+	if !ok {
+		rule = englishRule
+	}
+
+	return func(n interface{}, forms ...string) string {
+		if len(forms) == 0 {
+			return ""
+		}
+
+		i := rule(abs(toInt(n)))
+		if i >= len(forms) {
+			i = len(forms) - 1
+		}
+
+		return forms[i]
+	}
+}
+
+func toInt(n interface{}) int64 {
+	switch v := n.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case uint:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	default:
+		return 0
+	}
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
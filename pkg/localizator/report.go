@@ -0,0 +1,109 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localizator
+
+import (
+	"secretable/pkg/log"
+	"sort"
+	"strings"
+)
+
+// referenceLocale is the locale every other locale is compared against.
+const referenceLocale = "en"
+
+// Completeness lists the keys the locale lacks or has in addition to the
+// reference locale.
+type Completeness struct {
+	Locale  string
+	Missing []string
+	Extra   []string
+}
+
+// Complete reports whether the locale has exactly the keys of the reference.
+func (c Completeness) Complete() bool {
+	return len(c.Missing) == 0 && len(c.Extra) == 0
+}
+
+// Report compares every locale with en.
+func (l *Localizator) Report() []Completeness {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	keys := make(map[string]map[string]bool)
+
+	for fullKey := range l.strings {
+		locale, key := splitKey(fullKey)
+		if keys[locale] == nil {
+			keys[locale] = make(map[string]bool)
+		}
+
+		keys[locale][key] = true
+	}
+
+	reference := keys[referenceLocale]
+	report := make([]Completeness, 0, len(keys))
+
+	for locale, localeKeys := range keys {
+		if locale == referenceLocale {
+			continue
+		}
+
+		c := Completeness{Locale: locale}
+
+		for key := range reference {
+			if !localeKeys[key] {
+				c.Missing = append(c.Missing, key)
+			}
+		}
+
+		for key := range localeKeys {
+			if !reference[key] {
+				c.Extra = append(c.Extra, key)
+			}
+		}
+
+		sort.Strings(c.Missing)
+		sort.Strings(c.Extra)
+
+		report = append(report, c)
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Locale < report[j].Locale
+	})
+
+	return report
+}
+
+// logFallback logs the first time the key is taken from another locale.
+func (l *Localizator) logFallback(locale, key, from string) {
+	if _, logged := l.fallbacks.LoadOrStore(locale+"."+key, true); logged {
+		return
+	}
+
+	if from == "" {
+		log.Error("Missing locale key "+key, "locale", locale)
+
+		return
+	}
+
+	log.Info("Locale key "+key+" falls back to "+from, "locale", locale)
+}
+
+func splitKey(fullKey string) (locale, key string) {
+	i := strings.Index(fullKey, ".")
+
+	return fullKey[:i], fullKey[i+1:]
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/rs/zerolog/log"
+)
+
+const requestIDLength = 4
+
+type requestIDKey struct{}
+
+// NewRequestID returns a short random correlation ID of a request.
+func NewRequestID() string {
+	b := make([]byte, requestIDLength)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the correlation ID of the context or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+
+	return id
+}
+
+// Logger adds the correlation ID of the request to every event.
+type Logger struct {
+	requestID string
+}
+
+// Ctx returns the logger of the request context.
+func Ctx(ctx context.Context) Logger {
+	return Logger{requestID: RequestID(ctx)}
+}
+
+func (l Logger) Debug(msg string, pairs ...interface{}) {
+	printLog(log.Debug(), msg, l.with(pairs)...)
+}
+
+func (l Logger) Info(msg string, pairs ...interface{}) {
+	printLog(log.Info(), msg, l.with(pairs)...)
+}
+
+func (l Logger) Error(msg string, pairs ...interface{}) {
+	pairs = l.with(pairs)
+
+	report("error", msg, false, pairs...)
+	printLog(log.Error(), msg, pairs...)
+}
+
+func (l Logger) with(pairs []interface{}) []interface{} {
+	if l.requestID == "" {
+		return pairs
+	}
+
+	return append(pairs, "request_id", l.requestID)
+}
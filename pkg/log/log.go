@@ -15,8 +15,11 @@
 package log
 
 import (
+	"io"
 	"os"
+	"strconv"
 
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -25,11 +28,89 @@ const (
 	skipFrameCount = 4
 )
 
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+var ErrUnknownFormat = errors.New("unknown log format")
+
+// Options configure the logger output, the zero value is the console writer
+// on stderr with the info level.
+type Options struct {
+	Level  string `yaml:"level"`  // debug, info, warn or error
+	Format string `yaml:"format"` // console or json
+	// File is the path of the log file, stderr is used if empty.
+	File       string `yaml:"file"`
+	MaxSizeMB  int    `yaml:"max_size_mb"`
+	MaxBackups int    `yaml:"max_backups"`
+}
+
 func Init() {
+	setOutput(consoleWriter(os.Stderr))
+}
+
+// Configure replaces the output of the logger according to the options.
+func Configure(opts Options) error {
+	var out io.Writer = os.Stderr
+
+	if opts.File != "" {
+		file, err := newRotatingFile(opts.File, opts.MaxSizeMB, opts.MaxBackups)
+		if err != nil {
+			return errors.Wrap(err, "open log file")
+		}
+
+		out = file
+	}
+
+	switch opts.Format {
+	case "", FormatConsole:
+		setOutput(consoleWriter(out))
+	case FormatJSON:
+		setOutput(out)
+	default:
+		return errors.Wrap(ErrUnknownFormat, opts.Format)
+	}
+
+	if opts.Level == "" {
+		return nil
+	}
+
+	return SetLevel(opts.Level)
+}
+
+// SetLevel sets the minimal level of the printed events.
+func SetLevel(level string) error {
+	l, err := zerolog.ParseLevel(level)
+	if err != nil {
+		return errors.Wrap(err, "parse level")
+	}
+
+	zerolog.SetGlobalLevel(l)
+
+	return nil
+}
+
+func consoleWriter(out io.Writer) io.Writer {
 	cw := zerolog.NewConsoleWriter()
-	cw.Out = os.Stderr
+	cw.Out = out
 	cw.TimeFormat = "02 Jan 06 15:04:05 MST"
-	log.Logger = log.Output(cw).With().Caller().CallerWithSkipFrameCount(skipFrameCount).Logger()
+
+	if out != os.Stderr {
+		cw.NoColor = true
+	}
+
+	return cw
+}
+
+func setOutput(out io.Writer) {
+	log.Logger = zerolog.New(out).With().Timestamp().CallerWithSkipFrameCount(skipFrameCount).Logger()
+}
+
+// Redact hides the text keeping only its length, so the log still shows that
+// the message was received.
+func Redact(text string) string {
+	return "[redacted " + strconv.Itoa(len([]rune(text))) + " chars]"
 }
 
 func Debug(msg string, pairs ...interface{}) {
@@ -41,14 +122,17 @@ func Info(msg string, pairs ...interface{}) {
 }
 
 func Error(msg string, pairs ...interface{}) {
+	report("error", msg, false, pairs...)
 	printLog(log.Error(), msg, pairs...)
 }
 
 func Panic(msg string, pairs ...interface{}) {
+	report("fatal", msg, true, pairs...)
 	printLog(log.Panic(), msg, pairs...)
 }
 
 func Fatal(msg string, pairs ...interface{}) {
+	report("fatal", msg, true, pairs...)
 	printLog(log.Fatal(), msg, pairs...)
 }
 
@@ -0,0 +1,211 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	reportTimeout = 5 * time.Second
+	scrubbed      = "[scrubbed]"
+	eventIDLength = 16
+)
+
+var (
+	ErrInvalidDSN = errors.New("invalid sentry dsn")
+
+	// The scrubbed values are the Telegram bot tokens and long base58, hex
+	// or base64 runs which may be keys, ciphertexts or passwords.
+	scrubPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\d{6,}:[A-Za-z0-9_-]{30,}`),
+		regexp.MustCompile(`[A-Za-z0-9+=_-]{24,}`),
+	}
+	sensitiveKeys = []string{"pass", "secret", "token", "key"}
+
+	reporter   Reporter
+	reporterMx sync.RWMutex
+)
+
+// Reporter receives the error, panic and fatal log events.
+type Reporter interface {
+	Report(level, msg string, fields map[string]interface{}) error
+}
+
+// SetReporter starts forwarding the scrubbed error events to the reporter.
+func SetReporter(r Reporter) {
+	reporterMx.Lock()
+	defer reporterMx.Unlock()
+
+	reporter = r
+}
+
+// report forwards the event, the fatal and panic events are delivered before
+// the process exits.
+func report(level, msg string, sync bool, pairs ...interface{}) {
+	reporterMx.RLock()
+	r := reporter
+	reporterMx.RUnlock()
+
+	if r == nil {
+		return
+	}
+
+	fields := make(map[string]interface{})
+
+	for i := 0; i+1 < len(pairs); i += 2 {
+		k := fmt.Sprint(pairs[i])
+		fields[k] = scrubField(k, pairs[i+1])
+	}
+
+	send := func() {
+		if err := r.Report(level, Scrub(msg), fields); err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to report error: "+err.Error())
+		}
+	}
+
+	if sync {
+		send()
+
+		return
+	}
+
+	go send()
+}
+
+// Scrub replaces the values which look like secrets.
+func Scrub(s string) string {
+	for _, re := range scrubPatterns {
+		s = re.ReplaceAllString(s, scrubbed)
+	}
+
+	return s
+}
+
+func scrubField(key string, value interface{}) interface{} {
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(strings.ToLower(key), sensitive) {
+			return scrubbed
+		}
+	}
+
+	if s, ok := value.(string); ok {
+		return Scrub(s)
+	}
+
+	return value
+}
+
+// WebhookReporter posts the events as JSON to the URL.
+type WebhookReporter struct {
+	URL    string
+	client http.Client
+}
+
+func NewWebhookReporter(rawurl string) *WebhookReporter {
+	return &WebhookReporter{URL: rawurl, client: http.Client{Timeout: reportTimeout}}
+}
+
+func (w *WebhookReporter) Report(level, msg string, fields map[string]interface{}) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"time":    time.Now().UTC().Format(time.RFC3339),
+		"level":   level,
+		"message": msg,
+		"fields":  fields,
+	})
+
+	return post(&w.client, w.URL, body, nil)
+}
+
+// SentryReporter sends the events to the Sentry store endpoint of the DSN.
+type SentryReporter struct {
+	endpoint string
+	auth     string
+	client   http.Client
+}
+
+func NewSentryReporter(dsn string) (*SentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse dsn")
+	}
+
+	project := strings.Trim(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || project == "" {
+		return nil, ErrInvalidDSN
+	}
+
+	return &SentryReporter{
+		endpoint: u.Scheme + "://" + u.Host + "/api/" + project + "/store/",
+		auth:     "Sentry sentry_version=7, sentry_client=secretable/1, sentry_key=" + u.User.Username(),
+		client:   http.Client{Timeout: reportTimeout},
+	}, nil
+}
+
+func (s *SentryReporter) Report(level, msg string, fields map[string]interface{}) error {
+	id := make([]byte, eventIDLength)
+	_, _ = rand.Read(id)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"event_id":  hex.EncodeToString(id),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"level":     level,
+		"logger":    "secretable",
+		"platform":  "go",
+		"message":   map[string]string{"formatted": msg},
+		"extra":     fields,
+	})
+
+	return post(&s.client, s.endpoint, body, map[string]string{"X-Sentry-Auth": s.auth})
+}
+
+func post(client *http.Client, rawurl string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest(http.MethodPost, rawurl, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send request")
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.New("responded " + resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"fmt"
+	"os"
+	"secretable/pkg/fileperm"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	megabyte = 1 << 20
+
+	defaultMaxSizeMB  = 10
+	defaultMaxBackups = 3
+)
+
+// rotatingFile is a log file which is renamed to <path>.1 when it grows over
+// the max size, the older backups are shifted up to the max backups.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+	mx   sync.Mutex
+}
+
+func newRotatingFile(path string, maxSizeMB, maxBackups int) (*rotatingFile, error) {
+	if maxSizeMB <= 0 {
+		maxSizeMB = defaultMaxSizeMB
+	}
+
+	if maxBackups <= 0 {
+		maxBackups = defaultMaxBackups
+	}
+
+	if err := fileperm.MkdirAll(path); err != nil {
+		return nil, errors.Wrap(err, "mkdir")
+	}
+
+	f := &rotatingFile{path: path, maxSize: int64(maxSizeMB) * megabyte, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := fileperm.OpenAppend(f.path)
+	if err != nil {
+		return errors.Wrap(err, "open file")
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+
+		return errors.Wrap(err, "stat file")
+	}
+
+	f.file = file
+	f.size = info.Size()
+
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if f.size+int64(len(p)) > f.maxSize && f.size > 0 {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return errors.Wrap(err, "close file")
+	}
+
+	for i := f.maxBackups - 1; i > 0; i-- {
+		_ = os.Rename(backupName(f.path, i), backupName(f.path, i+1))
+	}
+
+	if err := os.Rename(f.path, backupName(f.path, 1)); err != nil {
+		return errors.Wrap(err, "rename file")
+	}
+
+	return f.open()
+}
+
+func backupName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
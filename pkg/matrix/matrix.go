@@ -0,0 +1,721 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package matrix is the chat transport of a Matrix account on the
+// client-server API. The messages are received by the long-poll of /sync, the
+// encrypted rooms are served through the pantalaimon proxy which decrypts and
+// encrypts the events for the bot, the rooms without encryption are refused. Matrix has no inline buttons, so the
+// buttons of a message are listed as numbered choices and pressed by
+// answering the number. The files aren't sent, see SendFile.
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"secretable/pkg/chat"
+	"secretable/pkg/log"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	clientPath = "/_matrix/client/v3"
+
+	syncTimeout = 30 * time.Second
+	callTimeout = 30 * time.Second
+
+	reconnectDelay    = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// ErrNoFiles is the file sent over Matrix, e.g. a QR code or an export.
+var ErrNoFiles = errors.New("files aren't sent over Matrix")
+
+// initialFilter leaves the history out of the first sync, so the messages
+// sent while the bot was down aren't handled on start.
+const initialFilter = `{"room":{"timeline":{"limit":0}}}`
+
+// Transport sends and routes the messages of the Matrix account.
+type Transport struct {
+	homeserver string
+	userID     string
+	token      string
+	client     *http.Client
+
+	// chats maps the Matrix user IDs to the chat IDs, users maps them back.
+	chats map[string]int64
+	users map[int64]string
+
+	// OnAlive is called on every answered sync, the sync waits at most
+	// syncTimeout so an idle account is reported too.
+	OnAlive func()
+
+	commands map[string]func(*chat.Message)
+	buttons  map[string]func(*chat.Callback)
+	routesmx sync.RWMutex
+
+	// since is the batch of the next sync, only Run reads and sets it.
+	since string
+
+	// rooms keeps the direct room of the chat, direct the rooms checked to
+	// have only the user and the bot as the members and encrypted the rooms
+	// checked to have the encryption enabled.
+	rooms     sync.Map
+	direct    sync.Map
+	encrypted sync.Map
+
+	// events maps the message IDs to the events, the IDs are counted by
+	// lastID since the events are identified by strings.
+	lastID  int64
+	events  sync.Map
+	lastTxn int64
+
+	// choices keeps the buttons of the last message of the chat.
+	choices sync.Map
+}
+
+// choices are the buttons of a message by their numbers counted from one.
+type choices struct {
+	message *chat.Message
+	buttons []chat.Button
+}
+
+// roomEvent is the event of a message in its room.
+type roomEvent struct {
+	room string
+	id   string
+}
+
+// New creates the transport of the account of the user ID on the homeserver,
+// the users map the Matrix user IDs to their chat IDs.
+func New(homeserver, userID, token string, users map[string]int64) *Transport {
+	t := &Transport{
+		homeserver: strings.TrimSuffix(homeserver, "/"),
+		userID:     userID,
+		token:      token,
+		client:     &http.Client{Timeout: syncTimeout + callTimeout},
+		chats:      make(map[string]int64, len(users)),
+		users:      make(map[int64]string, len(users)),
+		commands:   make(map[string]func(*chat.Message)),
+		buttons:    make(map[string]func(*chat.Callback)),
+	}
+
+	for user, chatID := range users {
+		t.chats[user] = chatID
+
+		if current, ok := t.users[chatID]; !ok || user < current {
+			t.users[chatID] = user
+		}
+	}
+
+	return t
+}
+
+func (t *Transport) SendMessage(chatID int64, text string, opts chat.Options) (*chat.Message, error) {
+	text, buttons := chat.WithChoices(text, opts.Buttons)
+
+	msg, err := t.send(chatID, textContent(text))
+	if err != nil {
+		return nil, err
+	}
+
+	t.setChoices(msg, buttons)
+
+	return msg, nil
+}
+
+// SendFile refuses the files, the media repository of the homeserver keeps
+// the uploads unencrypted even for the encrypted rooms.
+func (t *Transport) SendFile(int64, chat.File, chat.Options) (*chat.Message, error) {
+	return nil, ErrNoFiles
+}
+
+// EditMessage replaces the text of the message with an m.replace event, the
+// clients show the new text in place of the old one.
+func (t *Transport) EditMessage(chatID int64, messageID int, text string, opts chat.Options) error {
+	text, buttons := chat.WithChoices(text, opts.Buttons)
+
+	ev, ok := t.events.Load(messageID)
+	if !ok {
+		return errors.New("no Matrix event of the message " + strconv.Itoa(messageID))
+	}
+
+	content := textContent(text)
+	content["body"] = "* " + content["body"].(string)
+	content["m.new_content"] = textContent(text)
+	content["m.relates_to"] = map[string]string{"rel_type": "m.replace", "event_id": ev.(roomEvent).id}
+
+	if _, err := t.sendEvent(ev.(roomEvent).room, content); err != nil {
+		return err
+	}
+
+	msg := &chat.Message{ID: messageID, Chat: &chat.Chat{ID: chatID}, Sender: &chat.User{ID: chatID}, Text: text}
+
+	if buttons != nil {
+		t.setChoices(msg, buttons)
+	} else if c, ok := t.choices.Load(chatID); ok && c.(choices).message.ID == messageID {
+		t.choices.Delete(chatID)
+	}
+
+	return nil
+}
+
+// DeleteMessage redacts the message, the messages of the users are redacted
+// only if the bot has the power level to redact the events of others.
+func (t *Transport) DeleteMessage(chatID int64, messageID int) error {
+	ev, ok := t.events.Load(messageID)
+	if !ok {
+		return nil
+	}
+
+	path := clientPath + "/rooms/" + url.PathEscape(ev.(roomEvent).room) + "/redact/" +
+		url.PathEscape(ev.(roomEvent).id) + "/" + t.txnID()
+
+	if err := t.call(context.Background(), http.MethodPut, path, struct{}{}, nil); err != nil {
+		return err
+	}
+
+	t.events.Delete(messageID)
+
+	if c, ok := t.choices.Load(chatID); ok && c.(choices).message.ID == messageID {
+		t.choices.Delete(chatID)
+	}
+
+	return nil
+}
+
+// RespondCallback does nothing, the choices are answered with a message.
+func (t *Transport) RespondCallback(*chat.Callback) error {
+	return nil
+}
+
+func (t *Transport) RegisterCommand(endpoint string, handler func(*chat.Message)) {
+	t.routesmx.Lock()
+	defer t.routesmx.Unlock()
+
+	t.commands[endpoint] = handler
+}
+
+func (t *Transport) RegisterButton(unique string, handler func(*chat.Callback)) {
+	t.routesmx.Lock()
+	defer t.routesmx.Unlock()
+
+	t.buttons[unique] = handler
+}
+
+// SetCommands does nothing, Matrix has no command menu.
+func (t *Transport) SetCommands(string, []chat.Command) error {
+	return nil
+}
+
+// Token is empty, the Web App is served only by Telegram.
+func (t *Transport) Token() string {
+	return ""
+}
+
+// Run receives the messages until the context is done, the sync is started
+// again when it fails.
+func (t *Transport) Run(ctx context.Context) {
+	delay := reconnectDelay
+
+	for {
+		err := t.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Error("Matrix sync: "+err.Error(), "homeserver", t.homeserver)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// event is an event of a room in the sync response.
+type event struct {
+	Type     string          `json:"type"`
+	EventID  string          `json:"event_id"`
+	Sender   string          `json:"sender"`
+	StateKey *string         `json:"state_key"`
+	Content  json.RawMessage `json:"content"`
+}
+
+type syncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []event `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]struct {
+			InviteState struct {
+				Events []event `json:"events"`
+			} `json:"invite_state"`
+		} `json:"invite"`
+	} `json:"rooms"`
+}
+
+type messageContent struct {
+	MsgType   string `json:"msgtype"`
+	Body      string `json:"body"`
+	RelatesTo *struct {
+		RelType string `json:"rel_type"`
+	} `json:"m.relates_to"`
+}
+
+type memberContent struct {
+	Membership string `json:"membership"`
+	IsDirect   bool   `json:"is_direct"`
+}
+
+// serve syncs until a sync fails or the context is done. The first sync
+// finds the direct rooms of the users and skips the history.
+func (t *Transport) serve(ctx context.Context) error {
+	if t.since == "" {
+		if err := t.findRooms(ctx); err != nil {
+			return errors.Wrap(err, "find rooms")
+		}
+
+		resp, err := t.sync(ctx, url.Values{"filter": {initialFilter}, "timeout": {"0"}})
+		if err != nil {
+			return err
+		}
+
+		t.handleInvites(ctx, resp)
+		t.since = resp.NextBatch
+
+		log.Info("💬 Connected to Matrix as " + t.userID)
+	}
+
+	for {
+		resp, err := t.sync(ctx, url.Values{
+			"since":   {t.since},
+			"timeout": {strconv.Itoa(int(syncTimeout / time.Millisecond))},
+		})
+		if err != nil {
+			return err
+		}
+
+		if t.OnAlive != nil {
+			t.OnAlive()
+		}
+
+		t.handleInvites(ctx, resp)
+
+		for room, joined := range resp.Rooms.Join {
+			for _, ev := range joined.Timeline.Events {
+				t.handleEvent(ctx, room, ev)
+			}
+		}
+
+		t.since = resp.NextBatch
+	}
+}
+
+func (t *Transport) sync(ctx context.Context, query url.Values) (syncResponse, error) {
+	var resp syncResponse
+
+	err := t.call(ctx, http.MethodGet, clientPath+"/sync?"+query.Encode(), nil, &resp)
+
+	return resp, errors.Wrap(err, "sync")
+}
+
+// findRooms keeps the joined rooms of a user and the bot as the direct rooms
+// of the chats, so the bot reaches the users after a restart.
+func (t *Transport) findRooms(ctx context.Context) error {
+	var joined struct {
+		Rooms []string `json:"joined_rooms"`
+	}
+
+	if err := t.call(ctx, http.MethodGet, clientPath+"/joined_rooms", nil, &joined); err != nil {
+		return err
+	}
+
+	for _, room := range joined.Rooms {
+		user, err := t.directUser(ctx, room)
+		if err != nil {
+			return err
+		}
+
+		if chatID, ok := t.chats[user]; ok && t.isEncrypted(ctx, room) {
+			t.rooms.Store(chatID, room)
+		}
+	}
+
+	return nil
+}
+
+// directUser returns the other member of the room of two members, empty if
+// the room has more members.
+func (t *Transport) directUser(ctx context.Context, room string) (string, error) {
+	var members struct {
+		Joined map[string]json.RawMessage `json:"joined"`
+	}
+
+	err := t.call(ctx, http.MethodGet, clientPath+"/rooms/"+url.PathEscape(room)+"/joined_members", nil, &members)
+	if err != nil {
+		return "", errors.Wrap(err, "joined members")
+	}
+
+	if _, ok := members.Joined[t.userID]; !ok || len(members.Joined) != 2 {
+		return "", nil
+	}
+
+	for user := range members.Joined {
+		if user != t.userID {
+			return user, nil
+		}
+	}
+
+	return "", nil
+}
+
+// handleInvites joins the direct rooms the known users invite the bot to, the
+// invites of others are declined.
+func (t *Transport) handleInvites(ctx context.Context, resp syncResponse) {
+	for room, invited := range resp.Rooms.Invite {
+		var (
+			inviter string
+			direct  bool
+		)
+
+		for _, ev := range invited.InviteState.Events {
+			var content memberContent
+			if ev.Type != "m.room.member" || ev.StateKey == nil || *ev.StateKey != t.userID ||
+				json.Unmarshal(ev.Content, &content) != nil || content.Membership != "invite" {
+				continue
+			}
+
+			inviter, direct = ev.Sender, content.IsDirect
+		}
+
+		chatID, known := t.chats[inviter]
+
+		path := clientPath + "/rooms/" + url.PathEscape(room) + "/leave"
+		if known {
+			path = clientPath + "/join/" + url.PathEscape(room)
+		} else {
+			log.Info("🚫 Decline the Matrix invite of an unknown user " + inviter)
+		}
+
+		if err := t.call(ctx, http.MethodPost, path, struct{}{}, nil); err != nil {
+			log.Error("Answer Matrix invite: "+err.Error(), "room", room)
+
+			continue
+		}
+
+		if known && direct && t.isEncrypted(ctx, room) {
+			t.rooms.Store(chatID, room)
+		}
+	}
+}
+
+// handleEvent routes the text message of a known user in a direct room, the
+// messages of the rooms with other members, of the rooms without encryption
+// and of the unknown users are dropped. A change of the members or of the
+// encryption checks the room again.
+func (t *Transport) handleEvent(ctx context.Context, room string, ev event) {
+	switch ev.Type {
+	case "m.room.member":
+		t.direct.Delete(room)
+
+		return
+	case "m.room.encryption":
+		t.encrypted.Delete(room)
+
+		return
+	}
+
+	if ev.Type != "m.room.message" || ev.Sender == t.userID {
+		return
+	}
+
+	var content messageContent
+	if err := json.Unmarshal(ev.Content, &content); err != nil || content.MsgType != "m.text" || content.Body == "" {
+		return
+	}
+
+	// The edits of the users are new events of the handled messages.
+	if content.RelatesTo != nil && content.RelatesTo.RelType == "m.replace" {
+		return
+	}
+
+	chatID, known := t.chats[ev.Sender]
+	if !known {
+		log.Info("🚫 Drop the Matrix message of an unknown user " + ev.Sender)
+
+		return
+	}
+
+	if !t.isDirect(ctx, room, ev.Sender) {
+		log.Info("🚫 Drop the Matrix message of a room with other members", "room", room)
+
+		return
+	}
+
+	if !t.isEncrypted(ctx, room) {
+		log.Info("🚫 Drop the Matrix message of a room without encryption", "room", room)
+
+		return
+	}
+
+	t.rooms.Store(chatID, room)
+
+	msg := &chat.Message{
+		ID:     t.storeEvent(room, ev.EventID),
+		Chat:   &chat.Chat{ID: chatID, Username: ev.Sender},
+		Sender: &chat.User{ID: chatID, Username: ev.Sender},
+		Text:   content.Body,
+	}
+
+	go t.route(msg)
+}
+
+// isDirect reports whether the user and the bot are the only members of the
+// room, the answer is kept until the members change.
+func (t *Transport) isDirect(ctx context.Context, room, user string) bool {
+	if direct, ok := t.direct.Load(room); ok {
+		return direct.(bool)
+	}
+
+	other, err := t.directUser(ctx, room)
+	if err != nil {
+		log.Error("Check Matrix room: "+err.Error(), "room", room)
+
+		return false
+	}
+
+	t.direct.Store(room, other == user)
+
+	return other == user
+}
+
+// isEncrypted reports whether the room has the encryption enabled, so the
+// secrets never go out in the clear. The answer is kept until the encryption
+// event of the room, Matrix can't disable it once enabled.
+func (t *Transport) isEncrypted(ctx context.Context, room string) bool {
+	if encrypted, ok := t.encrypted.Load(room); ok {
+		return encrypted.(bool)
+	}
+
+	var content struct {
+		Algorithm string `json:"algorithm"`
+	}
+
+	err := t.call(ctx, http.MethodGet, clientPath+"/rooms/"+url.PathEscape(room)+"/state/m.room.encryption", nil, &content)
+	if err != nil && !errors.Is(err, errNotFound) {
+		log.Error("Check Matrix room encryption: "+err.Error(), "room", room)
+
+		return false
+	}
+
+	encrypted := err == nil && content.Algorithm != ""
+	if !encrypted {
+		log.Info("🚫 Refuse the Matrix room without encryption, is the homeserver the pantalaimon proxy?", "room", room)
+	}
+
+	t.encrypted.Store(room, encrypted)
+
+	return encrypted
+}
+
+// send sends the content to the direct room of the chat.
+func (t *Transport) send(chatID int64, content map[string]interface{}) (*chat.Message, error) {
+	room, ok := t.rooms.Load(chatID)
+	if !ok {
+		if _, known := t.users[chatID]; !known {
+			return nil, errors.New("no Matrix user of the chat " + strconv.FormatInt(chatID, 10))
+		}
+
+		return nil, errors.New("no Matrix room of the chat " + strconv.FormatInt(chatID, 10) + ", the user invites the bot first")
+	}
+
+	id, err := t.sendEvent(room.(string), content)
+	if err != nil {
+		return nil, err
+	}
+
+	text, _ := content["body"].(string)
+
+	return &chat.Message{
+		ID:     t.storeEvent(room.(string), id),
+		Chat:   &chat.Chat{ID: chatID},
+		Sender: &chat.User{ID: chatID},
+		Text:   text,
+	}, nil
+}
+
+// sendEvent sends the m.room.message event to the room and returns its ID.
+func (t *Transport) sendEvent(room string, content map[string]interface{}) (string, error) {
+	var sent struct {
+		EventID string `json:"event_id"`
+	}
+
+	path := clientPath + "/rooms/" + url.PathEscape(room) + "/send/m.room.message/" + t.txnID()
+
+	if err := t.call(context.Background(), http.MethodPut, path, content, &sent); err != nil {
+		return "", errors.Wrap(err, "send")
+	}
+
+	return sent.EventID, nil
+}
+
+func (t *Transport) storeEvent(room, id string) int {
+	messageID := int(atomic.AddInt64(&t.lastID, 1))
+	t.events.Store(messageID, roomEvent{room: room, id: id})
+
+	return messageID
+}
+
+// txnID returns a new transaction ID, the homeserver ignores a repeated
+// request with the same one.
+func (t *Transport) txnID() string {
+	return "secretable-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "-" +
+		strconv.FormatInt(atomic.AddInt64(&t.lastTxn, 1), 36)
+}
+
+// call sends the JSON of the body if it isn't nil and decodes the response
+// into the result if it isn't nil.
+func (t *Transport) call(ctx context.Context, method, path string, body, result interface{}) error {
+	var reader io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "encode request")
+		}
+
+		reader = bytes.NewReader(data)
+	}
+
+	return t.do(ctx, method, path, "application/json", reader, result)
+}
+
+// errNotFound is the M_NOT_FOUND error, e.g. of the missing state event.
+var errNotFound = errors.New("M_NOT_FOUND")
+
+// matrixError is the error response of the client-server API.
+type matrixError struct {
+	ErrCode string `json:"errcode"`
+	Error   string `json:"error"`
+}
+
+func (t *Transport) do(ctx context.Context, method, path, contentType string, body io.Reader, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, t.homeserver+path, body)
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+
+	req.Header.Set("Authorization", "Bearer "+t.token)
+
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var merr matrixError
+		if json.NewDecoder(resp.Body).Decode(&merr) != nil || merr.ErrCode == "" {
+			return errors.New(resp.Status)
+		}
+
+		if merr.ErrCode == errNotFound.Error() {
+			return errors.Wrap(errNotFound, merr.Error)
+		}
+
+		return errors.New(merr.ErrCode + ": " + merr.Error)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(result), "decode response")
+}
+
+// route passes the answer of a choice to the handler of its button, a
+// command to its handler and other texts to the OnText handler. An unknown
+// command is passed as a text.
+func (t *Transport) route(msg *chat.Message) {
+	if c, ok := t.choices.Load(msg.Chat.ID); ok {
+		n, err := strconv.Atoi(strings.TrimSpace(msg.Text))
+		if buttons := c.(choices).buttons; err == nil && n >= 1 && n <= len(buttons) {
+			t.choices.Delete(msg.Chat.ID)
+
+			if handler := t.button(buttons[n-1].Unique); handler != nil {
+				handler(&chat.Callback{Data: buttons[n-1].Data, Sender: msg.Sender, Message: c.(choices).message})
+			}
+
+			return
+		}
+	}
+
+	if handler := t.command(msg.Text); handler != nil {
+		handler(msg)
+	}
+}
+
+func (t *Transport) command(text string) func(*chat.Message) {
+	t.routesmx.RLock()
+	defer t.routesmx.RUnlock()
+
+	if fields := strings.Fields(text); len(fields) > 0 && strings.HasPrefix(fields[0], "/") {
+		if handler, ok := t.commands[fields[0]]; ok {
+			return handler
+		}
+	}
+
+	return t.commands[chat.OnText]
+}
+
+func (t *Transport) button(unique string) func(*chat.Callback) {
+	t.routesmx.RLock()
+	defer t.routesmx.RUnlock()
+
+	return t.buttons[unique]
+}
+
+// setChoices replaces the choices of the chat with the buttons of the message.
+func (t *Transport) setChoices(msg *chat.Message, buttons []chat.Button) {
+	if len(buttons) == 0 {
+		t.choices.Delete(msg.Chat.ID)
+
+		return
+	}
+
+	t.choices.Store(msg.Chat.ID, choices{message: msg, buttons: buttons})
+}
@@ -0,0 +1,74 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matrix
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// htmlFormat is the format of the formatted body of the Matrix clients.
+const htmlFormat = "org.matrix.custom.html"
+
+var (
+	spoilerRx = regexp.MustCompile(`<(/?)tg-spoiler>`)
+	preRx     = regexp.MustCompile(`(?s)<pre>.*?</pre>`)
+	tagRx     = regexp.MustCompile(`<[^>]*>`)
+)
+
+// textContent is the content of the m.text message of the HTML of the
+// handlers.
+func textContent(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"msgtype":        "m.text",
+		"body":           plain(text),
+		"format":         htmlFormat,
+		"formatted_body": formatted(text),
+	}
+}
+
+// formatted converts the Telegram HTML of the handlers to the HTML of the
+// Matrix clients: the spoilers are the spans of data-mx-spoiler and the line
+// breaks out of the preformatted blocks are <br>.
+func formatted(text string) string {
+	text = spoilerRx.ReplaceAllStringFunc(text, func(tag string) string {
+		if strings.HasPrefix(tag, "</") {
+			return "</span>"
+		}
+
+		return "<span data-mx-spoiler>"
+	})
+
+	var (
+		b    strings.Builder
+		last int
+	)
+
+	for _, m := range preRx.FindAllStringIndex(text, -1) {
+		b.WriteString(strings.ReplaceAll(text[last:m[0]], "\n", "<br>"))
+		b.WriteString(text[m[0]:m[1]])
+		last = m[1]
+	}
+
+	b.WriteString(strings.ReplaceAll(text[last:], "\n", "<br>"))
+
+	return b.String()
+}
+
+// plain is the text of the HTML for the clients without the formatting.
+func plain(text string) string {
+	return html.UnescapeString(tagRx.ReplaceAllString(text, ""))
+}
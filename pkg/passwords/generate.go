@@ -0,0 +1,183 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package passwords
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	Lower   = "abcdefghijklmnopqrstuvwxyz"
+	Upper   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	Digits  = "0123456789"
+	Symbols = ` !"#$%&'()*+,-./:;<=>?@[\]^_{|}~` + "`"
+
+	DefaultCharset = Lower + Upper + Digits + Symbols
+
+	consonants = "bcdfghjklmnprstvz"
+	vowels     = "aeiou"
+
+	syllablesPerWord = 3
+	maxAttempts      = 1000
+)
+
+var (
+	ErrUnsatisfiable = errors.New("unable to satisfy the preset requirements")
+	ErrUnknownClass  = errors.New("unknown character class")
+	ErrInvalidPreset = errors.New("preset must define length, pattern or words")
+)
+
+// Preset is a named rule set of the generated passwords.
+type Preset struct {
+	// Length and Charset define a random password, the default charset is
+	// used if it is empty.
+	Length  int    `yaml:"length"`
+	Charset string `yaml:"charset"`
+
+	// Pattern is a template where "#" is a digit, "a" is a lower letter,
+	// "A" is an upper letter, "*" is a character of the charset and any
+	// other character is kept as is, e.g. "AAAA-####".
+	Pattern string `yaml:"pattern"`
+
+	// Words and Separator define a passphrase of pronounceable words.
+	Words     int    `yaml:"words"`
+	Separator string `yaml:"separator"`
+
+	// Require lists the character classes (lower, upper, digit, symbol)
+	// which must be present in the password.
+	Require []string `yaml:"require"`
+}
+
+// Presets are the built-in presets, they can be overridden by config.
+var Presets = map[string]Preset{
+	"pin":        {Length: 6, Charset: Digits},
+	"wifi":       {Length: 20, Charset: Lower + Upper + Digits, Require: []string{"lower", "upper", "digit"}},
+	"passphrase": {Words: 5, Separator: "-"},
+	"bank":       {Length: 12, Charset: Lower + Upper + Digits + "!@#$%&*", Require: []string{"lower", "upper", "digit", "symbol"}},
+}
+
+// Generate returns a random password of the length from the charset.
+func Generate(length int, charset string) string {
+	chars := []rune(charset)
+
+	var bld strings.Builder
+
+	for i := 0; i < length; i++ {
+		bld.WriteRune(chars[randomInt(len(chars))])
+	}
+
+	return bld.String()
+}
+
+// GeneratePreset returns a random password which satisfies the preset.
+func GeneratePreset(p Preset) (string, error) {
+	charset := p.Charset
+	if charset == "" {
+		charset = DefaultCharset
+	}
+
+	for _, class := range p.Require {
+		if _, ok := classes[class]; !ok {
+			return "", errors.Wrap(ErrUnknownClass, class)
+		}
+	}
+
+	for i := 0; i < maxAttempts; i++ {
+		var password string
+
+		switch {
+		case p.Pattern != "":
+			password = generatePattern(p.Pattern, charset)
+		case p.Words > 0:
+			password = generatePassphrase(p.Words, p.Separator)
+		case p.Length > 0:
+			password = Generate(p.Length, charset)
+		default:
+			return "", ErrInvalidPreset
+		}
+
+		if satisfies(password, p.Require) {
+			return password, nil
+		}
+	}
+
+	return "", ErrUnsatisfiable
+}
+
+var classes = map[string]func(rune) bool{
+	"lower":  unicode.IsLower,
+	"upper":  unicode.IsUpper,
+	"digit":  unicode.IsDigit,
+	"symbol": func(r rune) bool { return strings.ContainsRune(Symbols, r) },
+}
+
+func satisfies(password string, require []string) bool {
+	for _, class := range require {
+		if strings.IndexFunc(password, classes[class]) < 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func generatePattern(pattern, charset string) string {
+	var bld strings.Builder
+
+	for _, r := range pattern {
+		switch r {
+		case '#':
+			bld.WriteString(Generate(1, Digits))
+		case 'a':
+			bld.WriteString(Generate(1, Lower))
+		case 'A':
+			bld.WriteString(Generate(1, Upper))
+		case '*':
+			bld.WriteString(Generate(1, charset))
+		default:
+			bld.WriteRune(r)
+		}
+	}
+
+	return bld.String()
+}
+
+func generatePassphrase(words int, separator string) string {
+	parts := make([]string, words)
+
+	for i := range parts {
+		var bld strings.Builder
+
+		for j := 0; j < syllablesPerWord; j++ {
+			bld.WriteString(Generate(1, consonants))
+			bld.WriteString(Generate(1, vowels))
+		}
+
+		parts[i] = bld.String()
+	}
+
+	return strings.Join(parts, separator)
+}
+
+func randomInt(max int) int {
+	n, _ := rand.Int(rand.Reader, big.NewInt(int64(max)))
+
+	return int(n.Int64())
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package passwords
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	MinLength     = 8
+	MinCharGroups = 3
+)
+
+var common = map[string]struct{}{
+	"123456": {}, "12345678": {}, "123456789": {}, "1234567890": {}, "qwerty": {},
+	"qwerty123": {}, "password": {}, "password1": {}, "111111": {}, "000000": {},
+	"abc123": {}, "iloveyou": {}, "admin": {}, "welcome": {}, "letmein": {},
+	"monkey": {}, "dragon": {}, "football": {}, "1q2w3e4r": {}, "qwertyuiop": {},
+}
+
+// CharGroups returns the number of character groups (lower, upper, digits,
+// others) used in the password.
+func CharGroups(password string) int {
+	var lower, upper, digit, other bool
+
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	n := 0
+
+	for _, ok := range []bool{lower, upper, digit, other} {
+		if ok {
+			n++
+		}
+	}
+
+	return n
+}
+
+// IsWeak reports whether the password is too short, uses too few character
+// groups or is one of the well known passwords.
+func IsWeak(password string) bool {
+	if len([]rune(password)) < MinLength || CharGroups(password) < MinCharGroups {
+		return true
+	}
+
+	_, ok := common[strings.ToLower(password)]
+
+	return ok
+}
@@ -0,0 +1,254 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"secretable/pkg/sigv4"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// awsDescriptionLength limits the description of a secret.
+const awsDescriptionLength = 2048
+
+// AWSSecretsManager is the SecretStore of AWS Secrets Manager in a region,
+// the deleted secrets are kept for the recovery window of 30 days.
+type AWSSecretsManager struct {
+	endpoint    string
+	region      string
+	credentials sigv4.Credentials
+	client      *http.Client
+}
+
+// NewAWSSecretsManager returns the store of the region, the endpoint defaults
+// to the one of the region.
+func NewAWSSecretsManager(endpoint, region string, credentials sigv4.Credentials) *AWSSecretsManager {
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+
+	return &AWSSecretsManager{
+		endpoint:    strings.TrimSuffix(endpoint, "/") + "/",
+		region:      region,
+		credentials: credentials,
+		client:      &http.Client{Timeout: storeTimeout},
+	}
+}
+
+func (m *AWSSecretsManager) String() string {
+	return "aws:" + m.region
+}
+
+func (m *AWSSecretsManager) List(ctx context.Context, prefix string) ([]StoreSecret, error) {
+	var (
+		secrets []StoreSecret
+		token   string
+	)
+
+	for {
+		req := map[string]interface{}{"MaxResults": 100}
+		if prefix != "" {
+			req["Filters"] = []map[string]interface{}{{"Key": "name", "Values": []string{prefix}}}
+		}
+
+		if token != "" {
+			req["NextToken"] = token
+		}
+
+		var resp struct {
+			SecretList []struct {
+				Name            string  `json:"Name"`
+				Description     string  `json:"Description"`
+				CreatedDate     float64 `json:"CreatedDate"`
+				LastChangedDate float64 `json:"LastChangedDate"`
+				DeletedDate     float64 `json:"DeletedDate"`
+			} `json:"SecretList"`
+			NextToken string `json:"NextToken"`
+		}
+
+		if err := m.call(ctx, "ListSecrets", req, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, s := range resp.SecretList {
+			// The name filter matches the prefix of any word of the name.
+			if s.DeletedDate != 0 || !strings.HasPrefix(s.Name, prefix) {
+				continue
+			}
+
+			secrets = append(secrets, StoreSecret{
+				Name:        s.Name,
+				Description: s.Description,
+				Created:     awsTime(s.CreatedDate),
+				Updated:     awsTime(s.LastChangedDate),
+			})
+		}
+
+		if resp.NextToken == "" {
+			return secrets, nil
+		}
+
+		token = resp.NextToken
+	}
+}
+
+func (m *AWSSecretsManager) Get(ctx context.Context, name string) (string, error) {
+	var resp struct {
+		SecretString string `json:"SecretString"`
+	}
+
+	err := m.call(ctx, "GetSecretValue", map[string]interface{}{"SecretId": name}, &resp)
+
+	return resp.SecretString, err
+}
+
+func (m *AWSSecretsManager) Create(ctx context.Context, secret StoreSecret) error {
+	return m.call(ctx, "CreateSecret", map[string]interface{}{
+		"Name":               secret.Name,
+		"Description":        awsDescription(secret.Description),
+		"SecretString":       secret.Value,
+		"ClientRequestToken": requestToken(),
+	}, nil)
+}
+
+func (m *AWSSecretsManager) Update(ctx context.Context, secret StoreSecret) error {
+	return m.call(ctx, "UpdateSecret", map[string]interface{}{
+		"SecretId":           secret.Name,
+		"Description":        awsDescription(secret.Description),
+		"SecretString":       secret.Value,
+		"ClientRequestToken": requestToken(),
+	}, nil)
+}
+
+func (m *AWSSecretsManager) Delete(ctx context.Context, name string) error {
+	return m.call(ctx, "DeleteSecret", map[string]interface{}{"SecretId": name}, nil)
+}
+
+// call sends the signed request of the action and decodes the response into
+// the result, the errors are marked with their kinds.
+func (m *AWSSecretsManager) call(ctx context.Context, action string, params, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return errors.Wrap(err, "encode request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager."+action)
+
+	endpoint, _ := url.Parse(m.endpoint)
+	m.credentials.Sign(req, "secretsmanager", m.region, sigv4.EscapePath(endpoint.Path), "", body, time.Now().UTC())
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, action)
+	}
+
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, remoteMaxSize))
+	if err != nil {
+		return errors.Wrap(err, "read response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Wrap(awsError(resp.StatusCode, b), action)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return errors.Wrap(json.Unmarshal(b, result), "decode "+action)
+}
+
+// awsError marks the error of an AWS JSON API with its kind by the type.
+func awsError(status int, body []byte) error {
+	var apiErr struct {
+		Type     string `json:"__type"`
+		Message  string `json:"message"`
+		MessageU string `json:"Message"`
+	}
+
+	_ = json.Unmarshal(body, &apiErr)
+
+	kind := apiErr.Type[strings.LastIndex(apiErr.Type, "#")+1:]
+	message := apiErr.Message + apiErr.MessageU
+
+	err := errors.New(kind + ": " + message)
+	if kind == "" {
+		err = errors.New(http.StatusText(status) + ": " + strings.TrimSpace(string(body)))
+	}
+
+	switch {
+	case kind == "ResourceNotFoundException":
+		return kindError{kind: ErrNotFound, err: err}
+	case kind == "ResourceExistsException", kind == "ConditionalCheckFailedException",
+		kind == "InvalidRequestException" && strings.Contains(message, "deletion"):
+		return kindError{kind: ErrConflict, err: err}
+	case kind == "AccessDeniedException", kind == "UnrecognizedClientException",
+		kind == "InvalidSignatureException", kind == "ExpiredTokenException",
+		status == http.StatusForbidden:
+		return kindError{kind: ErrUnauthorized, err: err}
+	case kind == "ThrottlingException", kind == "ProvisionedThroughputExceededException", status == http.StatusTooManyRequests:
+		return kindError{kind: ErrQuotaExceeded, err: err}
+	}
+
+	return err
+}
+
+// awsTime converts the seconds of the epoch of the API.
+func awsTime(seconds float64) time.Time {
+	whole, frac := math.Modf(seconds)
+
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC()
+}
+
+func awsDescription(description string) string {
+	if len(description) <= awsDescriptionLength {
+		return description
+	}
+
+	return strings.ToValidUTF8(description[:awsDescriptionLength], "")
+}
+
+// requestToken returns the idempotency token the SDKs generate for the
+// changes, a random UUID.
+func requestToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	h := hex.EncodeToString(b)
+
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
@@ -0,0 +1,234 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	azureAPIVersion = "7.4"
+	azureScope      = "https://vault.azure.net/.default"
+	// azureTagLength limits the value of a tag.
+	azureTagLength = 256
+)
+
+// AzureKeyVault is the SecretStore of an Azure Key Vault, the bot signs in as
+// the service principal of the tenant. The deleted secrets are kept while the
+// soft delete of the vault keeps them.
+type AzureKeyVault struct {
+	url    string
+	client *http.Client
+}
+
+// NewAzureKeyVault returns the store of the vault URL, e.g.
+// https://example.vault.azure.net, with the client secret of the app.
+func NewAzureKeyVault(vaultURL, tenantID, clientID, clientSecret string) *AzureKeyVault {
+	conf := &clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     "https://login.microsoftonline.com/" + url.PathEscape(tenantID) + "/oauth2/v2.0/token",
+		Scopes:       []string{azureScope},
+	}
+
+	client := conf.Client(context.Background())
+	client.Timeout = storeTimeout
+
+	return &AzureKeyVault{url: strings.TrimSuffix(vaultURL, "/"), client: client}
+}
+
+func (v *AzureKeyVault) String() string {
+	return v.url
+}
+
+type azureAttributes struct {
+	Enabled bool  `json:"enabled"`
+	Created int64 `json:"created"`
+	Updated int64 `json:"updated"`
+}
+
+// List skips the disabled secrets, the names are filtered by the prefix here
+// as the API has no filter.
+func (v *AzureKeyVault) List(ctx context.Context, prefix string) ([]StoreSecret, error) {
+	var secrets []StoreSecret
+
+	next := v.url + "/secrets?api-version=" + azureAPIVersion + "&maxresults=25"
+
+	for next != "" {
+		var resp struct {
+			Value []struct {
+				ID         string            `json:"id"`
+				Attributes azureAttributes   `json:"attributes"`
+				Tags       map[string]string `json:"tags"`
+			} `json:"value"`
+			NextLink string `json:"nextLink"`
+		}
+
+		if err := v.call(ctx, http.MethodGet, next, nil, &resp); err != nil {
+			return nil, errors.Wrap(err, "list secrets")
+		}
+
+		for _, s := range resp.Value {
+			name := s.ID[strings.LastIndex(s.ID, "/")+1:]
+			if !s.Attributes.Enabled || !strings.HasPrefix(name, prefix) {
+				continue
+			}
+
+			secrets = append(secrets, StoreSecret{
+				Name:        name,
+				Description: s.Tags["description"],
+				Created:     time.Unix(s.Attributes.Created, 0).UTC(),
+				Updated:     time.Unix(s.Attributes.Updated, 0).UTC(),
+			})
+		}
+
+		next = resp.NextLink
+	}
+
+	return secrets, nil
+}
+
+func (v *AzureKeyVault) Get(ctx context.Context, name string) (string, error) {
+	var resp struct {
+		Value string `json:"value"`
+	}
+
+	err := v.call(ctx, http.MethodGet, v.secretURL(name), nil, &resp)
+
+	return resp.Value, errors.Wrap(err, "get secret")
+}
+
+// Create sets the secret unless it exists, the deleted secret which is still
+// recoverable is a conflict of the set.
+func (v *AzureKeyVault) Create(ctx context.Context, secret StoreSecret) error {
+	_, err := v.Get(ctx, secret.Name)
+	if err == nil {
+		return kindError{kind: ErrConflict, err: errors.New("secret " + secret.Name + " exists")}
+	}
+
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	return v.Update(ctx, secret)
+}
+
+// Update sets the new version of the secret.
+func (v *AzureKeyVault) Update(ctx context.Context, secret StoreSecret) error {
+	description := secret.Description
+	if len(description) > azureTagLength {
+		description = strings.ToValidUTF8(description[:azureTagLength], "")
+	}
+
+	err := v.call(ctx, http.MethodPut, v.secretURL(secret.Name), map[string]interface{}{
+		"value":       secret.Value,
+		"contentType": "application/json",
+		"tags":        map[string]string{"description": description},
+	}, nil)
+
+	return errors.Wrap(err, "set secret")
+}
+
+func (v *AzureKeyVault) Delete(ctx context.Context, name string) error {
+	return errors.Wrap(v.call(ctx, http.MethodDelete, v.secretURL(name), nil, nil), "delete secret")
+}
+
+func (v *AzureKeyVault) secretURL(name string) string {
+	return v.url + "/secrets/" + url.PathEscape(name) + "?api-version=" + azureAPIVersion
+}
+
+// call sends the request and decodes the response into the result, the errors
+// are marked with their kinds.
+func (v *AzureKeyVault) call(ctx context.Context, method, reqURL string, params, result interface{}) error {
+	var body io.Reader
+
+	if params != nil {
+		b, err := json.Marshal(params)
+		if err != nil {
+			return errors.Wrap(err, "encode request")
+		}
+
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+
+	if params != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, remoteMaxSize))
+	if err != nil {
+		return errors.Wrap(err, "read response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return azureError(resp.StatusCode, b)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return errors.Wrap(json.Unmarshal(b, result), "decode response")
+}
+
+// azureError marks the error of the API with its kind by the status.
+func azureError(status int, body []byte) error {
+	var apiErr struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	err := errors.New(http.StatusText(status) + ": " + strings.TrimSpace(string(body)))
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Error.Code != "" {
+		err = errors.New(apiErr.Error.Code + ": " + apiErr.Error.Message)
+	}
+
+	switch status {
+	case http.StatusNotFound:
+		return kindError{kind: ErrNotFound, err: err}
+	case http.StatusConflict:
+		return kindError{kind: ErrConflict, err: err}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return kindError{kind: ErrUnauthorized, err: err}
+	case http.StatusTooManyRequests:
+		return kindError{kind: ErrQuotaExceeded, err: err}
+	}
+
+	return err
+}
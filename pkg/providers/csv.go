@@ -0,0 +1,408 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"os"
+	"secretable/pkg/fileperm"
+	"secretable/pkg/log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CSVStorage keeps the secrets in a CSV file with the columns of the secrets
+// table of the spreadsheet, so the table is moved between the spreadsheet and
+// the file by the CSV export or the copy and paste. The key is kept in a file
+// of its own like in the keys table. The changes take the exclusive lock of
+// the file, so the instances and the CLI sharing the file don't overwrite
+// each other.
+type CSVStorage struct {
+	filepath string
+	keypath  string
+	mx       sync.RWMutex
+
+	// cache keeps the parsed file until it is written or changed externally,
+	// which is noticed by the modification time and the size of the file.
+	cache   []SecretsData
+	modTime time.Time
+	size    int64
+}
+
+// NewCSVStorage returns the storage of the file and the key file, the missing
+// file is created with the header.
+func NewCSVStorage(path, keyPath string) (*CSVStorage, error) {
+	storage := &CSVStorage{filepath: path, keypath: keyPath}
+
+	_, err := os.Stat(path)
+	if err == nil {
+		return storage, nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, errors.Wrap(err, "stat file")
+	}
+
+	if err = fileperm.MkdirAll(path); err != nil {
+		return nil, errors.Wrap(err, "mkdir")
+	}
+
+	file, err := fileperm.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "create file")
+	}
+
+	defer file.Close()
+
+	if err = writeCSV(file, nil); err != nil {
+		return nil, errors.Wrap(err, "write file")
+	}
+
+	log.Info("🗄 Created CSV storage file " + path)
+
+	return storage, nil
+}
+
+// locked calls the function with the file locked, the exclusive lock is
+// taken for the changes.
+func (t *CSVStorage) locked(exclusive bool, fn func(file *os.File) error) error {
+	file, err := os.OpenFile(t.filepath, os.O_RDWR, fileperm.File)
+	if err != nil {
+		return errors.Wrap(err, "open file")
+	}
+
+	defer file.Close()
+
+	if err = lockFile(file, exclusive); err != nil {
+		return errors.Wrap(err, "lock file")
+	}
+
+	return fn(file)
+}
+
+// change applies the function to the secrets of the file and writes the
+// result under the exclusive lock.
+func (t *CSVStorage) change(fn func(secrets []SecretsData) ([]SecretsData, error)) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.cache = nil
+
+	return t.locked(true, func(file *os.File) error {
+		secrets, err := readCSV(file)
+		if err != nil {
+			return errors.Wrap(err, "read file")
+		}
+
+		if secrets, err = fn(secrets); err != nil {
+			return err
+		}
+
+		return errors.Wrap(writeCSV(file, secrets), "write file")
+	})
+}
+
+// cached returns the parsed file, the file is read again after a write or an
+// external change.
+func (t *CSVStorage) cached() ([]SecretsData, error) {
+	info, err := os.Stat(t.filepath)
+	if err != nil {
+		return nil, errors.Wrap(err, "stat file")
+	}
+
+	t.mx.RLock()
+	if t.cache != nil && t.modTime.Equal(info.ModTime()) && t.size == info.Size() {
+		secrets := t.cache
+		t.mx.RUnlock()
+
+		return secrets, nil
+	}
+	t.mx.RUnlock()
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	var secrets []SecretsData
+
+	err = t.locked(false, func(file *os.File) error {
+		if info, err = file.Stat(); err != nil {
+			return errors.Wrap(err, "stat file")
+		}
+
+		secrets, err = readCSV(file)
+
+		return errors.Wrap(err, "read file")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if secrets == nil {
+		secrets = []SecretsData{}
+	}
+
+	t.cache = secrets
+	t.modTime = info.ModTime()
+	t.size = info.Size()
+
+	return secrets, nil
+}
+
+// readCSV parses the rows of the secrets, the header row and the rows without
+// the secret are skipped like in the spreadsheet.
+func readCSV(r io.Reader) ([]SecretsData, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "parse csv")
+	}
+
+	var secrets []SecretsData
+
+	for i, record := range records {
+		if i == 0 && len(record) > 0 {
+			// The spreadsheet programs start the exported file with the BOM.
+			record[0] = strings.TrimPrefix(record[0], "\ufeff")
+
+			if isCSVHeader(record) {
+				continue
+			}
+		}
+
+		if len(record) < 3 {
+			continue
+		}
+
+		secret := SecretsData{Description: record[0], Username: record[1], Secret: record[2]}
+
+		if len(record) > 3 {
+			secret.Owner, _ = strconv.ParseInt(record[3], 10, 64)
+		}
+
+		if len(record) > 4 {
+			secret.Type = record[4]
+		}
+
+		if len(record) > 5 {
+			secret.URL = record[5]
+		}
+
+		if len(record) > 6 {
+			secret.ID = record[6]
+		}
+
+		if len(record) > 7 {
+			secret.MAC = record[7]
+		}
+
+		secrets = append(secrets, secret)
+	}
+
+	return secrets, nil
+}
+
+func isCSVHeader(record []string) bool {
+	if len(record) < 3 {
+		return false
+	}
+
+	for i := 0; i < 3; i++ {
+		if record[i] != secretsHeader[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// writeCSV rewrites the file with the header and the rows of the secrets.
+func writeCSV(file *os.File, secrets []SecretsData) error {
+	var buf bytes.Buffer
+
+	writer := csv.NewWriter(&buf)
+
+	header := make([]string, len(secretsHeader))
+	for i, column := range secretsHeader {
+		header[i] = column.(string)
+	}
+
+	_ = writer.Write(header)
+
+	for _, secret := range secrets {
+		_ = writer.Write([]string{
+			secret.Description, secret.Username, secret.Secret, formatOwner(secret.Owner),
+			secret.Type, secret.URL, secret.ID, secret.MAC,
+		})
+	}
+
+	writer.Flush()
+
+	if err := writer.Error(); err != nil {
+		return errors.Wrap(err, "encode csv")
+	}
+
+	if _, err := file.WriteAt(buf.Bytes(), 0); err != nil {
+		return errors.Wrap(err, "write file")
+	}
+
+	if err := file.Truncate(int64(buf.Len())); err != nil {
+		return errors.Wrap(err, "truncate file")
+	}
+
+	return errors.Wrap(file.Sync(), "sync file")
+}
+
+func (t *CSVStorage) AddSecret(data SecretsData) error {
+	return t.AddSecrets([]SecretsData{data})
+}
+
+func (t *CSVStorage) AddSecrets(data []SecretsData) error {
+	return t.change(func(secrets []SecretsData) ([]SecretsData, error) {
+		return append(secrets, data...), nil
+	})
+}
+
+func (t *CSVStorage) DeleteSecret(index int) error {
+	return t.change(func(secrets []SecretsData) ([]SecretsData, error) {
+		if index < 0 || index >= len(secrets) {
+			return secrets, nil
+		}
+
+		return append(secrets[:index], secrets[index+1:]...), nil
+	})
+}
+
+func (t *CSVStorage) DeleteSecrets(ids []string) error {
+	deleted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+
+	return t.change(func(secrets []SecretsData) ([]SecretsData, error) {
+		kept := secrets[:0]
+
+		for _, secret := range secrets {
+			if !deleted[secret.StableID()] {
+				kept = append(kept, secret)
+			}
+		}
+
+		return kept, nil
+	})
+}
+
+func (t *CSVStorage) UpdateSecret(id string, data SecretsData) error {
+	data.ID = id
+
+	return t.UpdateSecrets([]SecretsData{data})
+}
+
+func (t *CSVStorage) UpdateSecrets(data []SecretsData) error {
+	return t.change(func(secrets []SecretsData) ([]SecretsData, error) {
+		for _, secret := range data {
+			index := FindByID(secrets, secret.ID)
+			if index < 0 {
+				return nil, ErrNotFound
+			}
+
+			secret.ID = secrets[index].StableID()
+			secrets[index] = secret
+		}
+
+		return secrets, nil
+	})
+}
+
+func (t *CSVStorage) GetSecrets() ([]SecretsData, error) {
+	cache, err := t.cached()
+	if err != nil {
+		return nil, errors.Wrap(err, "read file")
+	}
+
+	secrets := make([]SecretsData, len(cache))
+	copy(secrets, cache)
+
+	return secrets, nil
+}
+
+// Secrets iterates the cached file, the writes replace the cache instead of
+// changing it so the iteration doesn't hold the lock.
+func (t *CSVStorage) Secrets(fn func(index int, secret SecretsData) bool) error {
+	secrets, err := t.cached()
+	if err != nil {
+		return errors.Wrap(err, "read file")
+	}
+
+	for index, secret := range secrets {
+		if !fn(index, secret) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (t *CSVStorage) QuerySecrets(filter SecretsFilter, offset, limit int) ([]SecretsData, error) {
+	secrets, err := t.cached()
+	if err != nil {
+		return nil, errors.Wrap(err, "read file")
+	}
+
+	return filterSecrets(secrets, filter, offset, limit), nil
+}
+
+// SetKey writes the key file under the exclusive lock of the CSV file.
+func (t *CSVStorage) SetKey(key string) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.locked(true, func(*os.File) error {
+		return errors.Wrap(fileperm.WriteFile(t.keypath, []byte(key+"\n")), "write key file")
+	})
+}
+
+// GetKey reads the key file, the missing file is an empty key.
+func (t *CSVStorage) GetKey() (string, error) {
+	var key []byte
+
+	err := t.locked(false, func(*os.File) (err error) {
+		key, err = os.ReadFile(t.keypath)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return errors.Wrap(err, "read key file")
+	})
+
+	return strings.TrimSpace(string(key)), err
+}
+
+// Open does nothing, the file is kept in the clear like the spreadsheet.
+func (t *CSVStorage) Open(string) error {
+	return nil
+}
+
+// Seal does nothing, the file is kept in the clear like the spreadsheet.
+func (t *CSVStorage) Seal(string) error {
+	return nil
+}
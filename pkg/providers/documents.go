@@ -0,0 +1,483 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"context"
+	"secretable/pkg/log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	documentTimeout = 30 * time.Second
+	// keyDocument is the ID of the document of the wrapped key, the IDs of
+	// the secrets have no underscores.
+	keyDocument = "_key"
+	// documentTime is the format of the creation times, of a fixed width so
+	// the databases order them as the strings.
+	documentTime = "2006-01-02T15:04:05.000000000Z07:00"
+)
+
+// DocumentStore is a NoSQL database, e.g. DynamoDB or Firestore, which keeps
+// every secret in a document of its own. The version is the version of the
+// document the conditional writes compare.
+type DocumentStore interface {
+	// List returns the documents of the vault.
+	List(ctx context.Context) ([]Document, error)
+	// Query returns at most the limit of the documents of the secrets
+	// matching the filter after the offset ones, ordered by the creation,
+	// zero limit returns all of them.
+	Query(ctx context.Context, filter SecretsFilter, offset, limit int) ([]Document, error)
+	// Get returns the document, ErrNotFound if it's missing.
+	Get(ctx context.Context, id string) (Document, error)
+	// Create adds the document and returns its version, ErrConflict is
+	// returned if the ID is taken.
+	Create(ctx context.Context, doc Document) (string, error)
+	// Update replaces the document if it still has the version and returns
+	// the new version, ErrConflict is returned otherwise.
+	Update(ctx context.Context, doc Document, version string) (string, error)
+	// Delete deletes the document if it still has the version, ErrConflict
+	// is returned otherwise.
+	Delete(ctx context.Context, id, version string) error
+	// String names the database in the logs.
+	String() string
+}
+
+// Document is a secret or the wrapped key of the DocumentStore.
+type Document struct {
+	ID      string
+	Version string
+	Created time.Time
+	Secret  SecretsData
+	Key     string
+}
+
+// DocumentStorage keeps the secrets in the documents of a DocumentStore. The
+// documents are read on demand instead of the sync in the background, the
+// positions are ordered by the creation. The changes are written on the
+// versions of the last read, so a secret changed by another instance since
+// then is refused with ErrConflict.
+type DocumentStorage struct {
+	store DocumentStore
+
+	// docs keeps the documents of the last read by the IDs.
+	docs map[string]Document
+	mx   sync.Mutex
+}
+
+// NewDocumentStorage returns the storage of the store, the store is read to
+// check the access.
+func NewDocumentStorage(store DocumentStore) (*DocumentStorage, error) {
+	t := &DocumentStorage{store: store, docs: make(map[string]Document)}
+
+	if _, err := t.read(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// read lists the documents of the secrets ordered by the creation and keeps
+// their versions.
+func (t *DocumentStorage) read() ([]Document, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), documentTimeout)
+	defer cancel()
+
+	docs, err := t.store.List(ctx)
+	if err != nil {
+		log.Error("Unable to list documents: "+err.Error(), "store", t.store.String())
+
+		return nil, errors.Wrap(err, "list documents")
+	}
+
+	sortDocuments(docs)
+
+	byID := make(map[string]Document, len(docs))
+	secrets := docs[:0]
+
+	for _, doc := range docs {
+		doc.Secret.ID = doc.ID
+		byID[doc.ID] = doc
+
+		if doc.ID != keyDocument {
+			secrets = append(secrets, doc)
+		}
+	}
+
+	t.mx.Lock()
+	t.docs = byID
+	t.mx.Unlock()
+
+	return secrets, nil
+}
+
+// sortDocuments orders the documents by the creation and the IDs.
+func sortDocuments(docs []Document) {
+	sort.Slice(docs, func(i, j int) bool {
+		if !docs[i].Created.Equal(docs[j].Created) {
+			return docs[i].Created.Before(docs[j].Created)
+		}
+
+		return docs[i].ID < docs[j].ID
+	})
+}
+
+// pageDocuments returns at most the limit of the documents after the offset
+// ones, zero limit returns all of them.
+func pageDocuments(docs []Document, offset, limit int) []Document {
+	if offset >= len(docs) {
+		return nil
+	}
+
+	docs = docs[offset:]
+	if limit > 0 && len(docs) > limit {
+		docs = docs[:limit]
+	}
+
+	return docs
+}
+
+// known returns the documents of the last read with the IDs, the store is
+// read if one of them is unknown.
+func (t *DocumentStorage) known(ids []string) (map[string]Document, error) {
+	t.mx.Lock()
+	docs := t.docs
+	t.mx.Unlock()
+
+	for _, id := range ids {
+		if _, ok := docs[id]; !ok {
+			if _, err := t.read(); err != nil {
+				return nil, err
+			}
+
+			t.mx.Lock()
+			docs = t.docs
+			t.mx.Unlock()
+
+			break
+		}
+	}
+
+	return docs, nil
+}
+
+// written records the new version of the document, the missing version drops
+// the document.
+func (t *DocumentStorage) written(doc Document) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if doc.Version == "" {
+		delete(t.docs, doc.ID)
+
+		return
+	}
+
+	t.docs[doc.ID] = doc
+}
+
+// fields returns the nonempty string fields of the document, each one is an
+// attribute of its own in the database.
+func (d Document) fields() map[string]string {
+	fields := map[string]string{
+		"description": d.Secret.Description,
+		"username":    d.Secret.Username,
+		"secret":      d.Secret.Secret,
+		"type":        d.Secret.Type,
+		"url":         d.Secret.URL,
+		"mac":         d.Secret.MAC,
+		"key":         d.Key,
+	}
+
+	if d.Secret.Owner != 0 {
+		fields["owner"] = strconv.FormatInt(d.Secret.Owner, 10)
+	}
+
+	for name, value := range fields {
+		if value == "" {
+			delete(fields, name)
+		}
+	}
+
+	return fields
+}
+
+// created returns the creation time the queries order by, the key has none,
+// so the queries leave it out.
+func (d Document) created() string {
+	if d.ID == keyDocument {
+		return ""
+	}
+
+	return d.Created.UTC().Format(documentTime)
+}
+
+// setField sets the field returned by fields, the unknown ones are skipped.
+func (d *Document) setField(name, value string) {
+	switch name {
+	case "description":
+		d.Secret.Description = value
+	case "username":
+		d.Secret.Username = value
+	case "secret":
+		d.Secret.Secret = value
+	case "owner":
+		d.Secret.Owner, _ = strconv.ParseInt(value, 10, 64)
+	case "type":
+		d.Secret.Type = value
+	case "url":
+		d.Secret.URL = value
+	case "mac":
+		d.Secret.MAC = value
+	case "key":
+		d.Key = value
+	}
+}
+
+func (t *DocumentStorage) AddSecret(data SecretsData) error {
+	return t.AddSecrets([]SecretsData{data})
+}
+
+// AddSecrets creates the documents one by one.
+func (t *DocumentStorage) AddSecrets(data []SecretsData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), documentTimeout)
+	defer cancel()
+
+	for _, secret := range data {
+		secret.ID = secret.StableID()
+		doc := Document{ID: secret.ID, Created: time.Now().UTC(), Secret: secret}
+
+		version, err := t.store.Create(ctx, doc)
+		if err != nil {
+			log.Error("Unable to create document: "+err.Error(), "store", t.store.String(), "id", doc.ID)
+
+			return errors.Wrap(err, "create document")
+		}
+
+		doc.Version = version
+		t.written(doc)
+	}
+
+	return nil
+}
+
+func (t *DocumentStorage) DeleteSecret(index int) error {
+	secrets, err := t.read()
+	if err != nil {
+		return err
+	}
+
+	if index < 0 || index >= len(secrets) {
+		return ErrNotFound
+	}
+
+	return t.DeleteSecrets([]string{secrets[index].ID})
+}
+
+func (t *DocumentStorage) DeleteSecrets(ids []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), documentTimeout)
+	defer cancel()
+
+	docs, err := t.known(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		doc, ok := docs[id]
+		if !ok || id == keyDocument {
+			continue
+		}
+
+		if err = t.store.Delete(ctx, id, doc.Version); err != nil && !errors.Is(err, ErrNotFound) {
+			log.Error("Unable to delete document: "+err.Error(), "store", t.store.String(), "id", id)
+
+			return errors.Wrap(err, "delete document")
+		}
+
+		t.written(Document{ID: id})
+	}
+
+	return nil
+}
+
+func (t *DocumentStorage) UpdateSecret(id string, data SecretsData) error {
+	data.ID = id
+
+	return t.UpdateSecrets([]SecretsData{data})
+}
+
+func (t *DocumentStorage) UpdateSecrets(data []SecretsData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), documentTimeout)
+	defer cancel()
+
+	ids := make([]string, len(data))
+	for i, secret := range data {
+		ids[i] = secret.ID
+	}
+
+	docs, err := t.known(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, secret := range data {
+		doc, ok := docs[secret.ID]
+		if !ok || secret.ID == keyDocument {
+			return ErrNotFound
+		}
+
+		doc.Secret = secret
+
+		version, err := t.store.Update(ctx, doc, doc.Version)
+		if err != nil {
+			log.Error("Unable to update document: "+err.Error(), "store", t.store.String(), "id", doc.ID)
+
+			return errors.Wrap(err, "update document")
+		}
+
+		doc.Version = version
+		t.written(doc)
+	}
+
+	return nil
+}
+
+func (t *DocumentStorage) GetSecrets() ([]SecretsData, error) {
+	docs, err := t.read()
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := make([]SecretsData, len(docs))
+	for i, doc := range docs {
+		secrets[i] = doc.Secret
+	}
+
+	return secrets, nil
+}
+
+// QuerySecrets queries the store, so only the documents of the filter are
+// read.
+func (t *DocumentStorage) QuerySecrets(filter SecretsFilter, offset, limit int) ([]SecretsData, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), documentTimeout)
+	defer cancel()
+
+	docs, err := t.store.Query(ctx, filter, offset, limit)
+	if err != nil {
+		log.Error("Unable to query documents: "+err.Error(), "store", t.store.String())
+
+		return nil, errors.Wrap(err, "query documents")
+	}
+
+	secrets := make([]SecretsData, len(docs))
+	for i, doc := range docs {
+		secrets[i] = doc.Secret
+		secrets[i].ID = doc.ID
+	}
+
+	return secrets, nil
+}
+
+func (t *DocumentStorage) Secrets(fn func(index int, secret SecretsData) bool) error {
+	docs, err := t.read()
+	if err != nil {
+		return err
+	}
+
+	for index, doc := range docs {
+		if !fn(index, doc.Secret) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// SetKey writes the document of the key on the version of the last read, the
+// missing document is created.
+func (t *DocumentStorage) SetKey(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), documentTimeout)
+	defer cancel()
+
+	t.mx.Lock()
+	doc, ok := t.docs[keyDocument]
+	t.mx.Unlock()
+
+	if !ok {
+		var err error
+		if doc, err = t.store.Get(ctx, keyDocument); err != nil && !errors.Is(err, ErrNotFound) {
+			return errors.Wrap(err, "get key")
+		}
+	}
+
+	doc.ID, doc.Key = keyDocument, key
+
+	var (
+		version string
+		err     error
+	)
+
+	if doc.Version == "" {
+		doc.Created = time.Now().UTC()
+		version, err = t.store.Create(ctx, doc)
+	} else {
+		version, err = t.store.Update(ctx, doc, doc.Version)
+	}
+
+	if err != nil {
+		log.Error("Unable to write key: "+err.Error(), "store", t.store.String())
+
+		return errors.Wrap(err, "write key")
+	}
+
+	doc.Version = version
+	t.written(doc)
+
+	return nil
+}
+
+func (t *DocumentStorage) GetKey() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), documentTimeout)
+	defer cancel()
+
+	doc, err := t.store.Get(ctx, keyDocument)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", errors.Wrap(err, "get key")
+	}
+
+	t.written(doc)
+
+	return doc.Key, nil
+}
+
+// Open does nothing, the database encrypts the documents at rest itself.
+func (t *DocumentStorage) Open(string) error {
+	return nil
+}
+
+// Seal does nothing, the database encrypts the documents at rest itself.
+func (t *DocumentStorage) Seal(string) error {
+	return nil
+}
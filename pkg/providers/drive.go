@@ -0,0 +1,112 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// DriveFile is the file of Google Drive, the revision is the version of the
+// file. Drive has no conditional updates, so the version is compared right
+// before the upload.
+type DriveFile struct {
+	service *drive.Service
+	id      string
+}
+
+// NewDriveFile returns the file of the ID, the credentials are the content of
+// the Google credentials file.
+func NewDriveFile(googleCreds []byte, fileID string) (*DriveFile, error) {
+	service, err := drive.NewService(context.Background(), option.WithCredentialsJSON(googleCreds))
+	if err != nil {
+		return nil, errors.Wrap(err, "init drive service")
+	}
+
+	return &DriveFile{service: service, id: fileID}, nil
+}
+
+func (f *DriveFile) String() string {
+	return "drive:" + f.id
+}
+
+// version returns the version of the file, the trashed file is missing.
+func (f *DriveFile) version(ctx context.Context) (string, error) {
+	file, err := f.service.Files.Get(f.id).Fields("version", "trashed").SupportsAllDrives(true).Context(ctx).Do()
+	if err != nil {
+		return "", errors.Wrap(googleError(err), "get file")
+	}
+
+	if file.Trashed {
+		return "", kindError{kind: ErrNotFound, err: errors.New("file " + f.id + " is trashed")}
+	}
+
+	return strconv.FormatInt(file.Version, 10), nil
+}
+
+// Download returns the file at least of the version read before, a change
+// between the reads is found by the next upload.
+func (f *DriveFile) Download(ctx context.Context, rev string) ([]byte, string, error) {
+	version, err := f.version(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if version == rev {
+		return nil, rev, ErrNotModified
+	}
+
+	resp, err := f.service.Files.Get(f.id).SupportsAllDrives(true).Context(ctx).Download()
+	if err != nil {
+		return nil, "", errors.Wrap(googleError(err), "download file")
+	}
+
+	defer resp.Body.Close()
+
+	b, err := readRemote(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return b, version, nil
+}
+
+// Upload updates the content of the file, the file is created by
+// CreateDriveFile, so it's a conflict if the file wasn't read before.
+func (f *DriveFile) Upload(ctx context.Context, data []byte, rev string) (string, error) {
+	version, err := f.version(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if version != rev {
+		return "", kindError{kind: ErrConflict, err: errors.New("file " + f.id + " has version " + version)}
+	}
+
+	file, err := f.service.Files.Update(f.id, &drive.File{}).
+		Media(bytes.NewReader(data), googleapi.ContentType("application/json")).
+		Fields("version").SupportsAllDrives(true).Context(ctx).Do()
+	if err != nil {
+		return "", errors.Wrap(googleError(err), "update file")
+	}
+
+	return strconv.FormatInt(file.Version, 10), nil
+}
@@ -0,0 +1,228 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+const (
+	dropboxAPI     = "https://api.dropboxapi.com/2/"
+	dropboxContent = "https://content.dropboxapi.com/2/"
+	dropboxToken   = "https://api.dropboxapi.com/oauth2/token"
+)
+
+// DropboxFile is the file of a Dropbox account, the revision is the rev of
+// the file. The uploads are strict updates of the rev, so a file changed by
+// another client is a conflict instead of a conflicted copy.
+type DropboxFile struct {
+	path   string
+	client *http.Client
+}
+
+// NewDropboxFile returns the file of the path, e.g. /Apps/Secretable/vault.json.
+// The refresh token of the app key and secret is preferred to the access
+// token, which expires in hours.
+func NewDropboxFile(path, accessToken, refreshToken, appKey, appSecret string) *DropboxFile {
+	var source oauth2.TokenSource
+
+	if refreshToken != "" {
+		conf := &oauth2.Config{
+			ClientID:     appKey,
+			ClientSecret: appSecret,
+			Endpoint:     oauth2.Endpoint{TokenURL: dropboxToken},
+		}
+
+		source = conf.TokenSource(context.Background(), &oauth2.Token{RefreshToken: refreshToken})
+	} else {
+		source = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: accessToken})
+	}
+
+	client := oauth2.NewClient(context.Background(), source)
+	client.Timeout = remoteTimeout
+
+	return &DropboxFile{path: path, client: client}
+}
+
+func (f *DropboxFile) String() string {
+	return "dropbox:" + f.path
+}
+
+// Download compares the rev of the metadata before the file is downloaded at
+// that rev.
+func (f *DropboxFile) Download(ctx context.Context, rev string) ([]byte, string, error) {
+	var meta struct {
+		Tag string `json:".tag"`
+		Rev string `json:"rev"`
+	}
+
+	body, _ := json.Marshal(map[string]string{"path": f.path})
+
+	resp, err := f.call(ctx, dropboxAPI+"files/get_metadata", "application/json", body, "")
+	if err != nil {
+		return nil, "", errors.Wrap(err, "get metadata")
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&meta)
+	resp.Body.Close()
+
+	if err != nil {
+		return nil, "", errors.Wrap(err, "decode metadata")
+	}
+
+	if meta.Tag != "file" {
+		return nil, "", errors.New(f.path + " is not a file")
+	}
+
+	if meta.Rev == rev {
+		return nil, rev, ErrNotModified
+	}
+
+	resp, err = f.call(ctx, dropboxContent+"files/download", "", nil, dropboxArg(map[string]string{"path": "rev:" + meta.Rev}))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "download file")
+	}
+
+	defer resp.Body.Close()
+
+	b, err := readRemote(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return b, meta.Rev, nil
+}
+
+func (f *DropboxFile) Upload(ctx context.Context, data []byte, rev string) (string, error) {
+	var mode interface{} = "add"
+	if rev != "" {
+		mode = map[string]string{".tag": "update", "update": rev}
+	}
+
+	arg := dropboxArg(map[string]interface{}{
+		"path":            f.path,
+		"mode":            mode,
+		"autorename":      false,
+		"mute":            true,
+		"strict_conflict": true,
+	})
+
+	resp, err := f.call(ctx, dropboxContent+"files/upload", "application/octet-stream", data, arg)
+	if err != nil {
+		return "", errors.Wrap(err, "upload file")
+	}
+
+	defer resp.Body.Close()
+
+	var meta struct {
+		Rev string `json:"rev"`
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return "", errors.Wrap(err, "decode metadata")
+	}
+
+	return meta.Rev, nil
+}
+
+// call posts the body to the endpoint, the arg is the Dropbox-API-Arg of the
+// content endpoints. The error statuses are marked with their kinds.
+func (f *DropboxFile) call(ctx context.Context, url, contentType string, body []byte, arg string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "new request")
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if arg != "" {
+		req.Header.Set("Dropbox-API-Arg", arg)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+
+	return nil, dropboxError(resp)
+}
+
+// dropboxError marks the error of the API with its kind by the status and the
+// error summary.
+func dropboxError(resp *http.Response) error {
+	var apiErr struct {
+		Summary string `json:"error_summary"`
+	}
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(b, &apiErr) != nil || apiErr.Summary == "" {
+		apiErr.Summary = strings.TrimSpace(string(b))
+	}
+
+	err := errors.New(resp.Status + ": " + apiErr.Summary)
+
+	switch {
+	case resp.StatusCode == http.StatusConflict && strings.Contains(apiErr.Summary, "not_found"):
+		return kindError{kind: ErrNotFound, err: err}
+	case resp.StatusCode == http.StatusConflict && strings.Contains(apiErr.Summary, "conflict"):
+		return kindError{kind: ErrConflict, err: err}
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return kindError{kind: ErrUnauthorized, err: err}
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return kindError{kind: ErrQuotaExceeded, err: err}
+	}
+
+	return err
+}
+
+// dropboxArg encodes the argument of the header, which keeps ASCII only.
+func dropboxArg(arg interface{}) string {
+	b, _ := json.Marshal(arg)
+
+	var escaped strings.Builder
+
+	for _, r := range string(b) {
+		switch {
+		case r < utf8.RuneSelf:
+			escaped.WriteRune(r)
+		case r > 0xffff:
+			r1, r2 := utf16.EncodeRune(r)
+			fmt.Fprintf(&escaped, `\u%04x\u%04x`, r1, r2)
+		default:
+			fmt.Fprintf(&escaped, `\u%04x`, r)
+		}
+	}
+
+	return escaped.String()
+}
@@ -0,0 +1,326 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"secretable/pkg/sigv4"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DynamoDB is the DocumentStore of a DynamoDB table with the partition key
+// "vault" and the sort key "id", both strings. The documents of the vault
+// share the partition, the version is a number incremented by every write.
+// The queries read the local secondary index of the sort key "created" in
+// the order of the creation.
+type DynamoDB struct {
+	table       string
+	vault       string
+	endpoint    string
+	region      string
+	credentials sigv4.Credentials
+	client      *http.Client
+}
+
+// dynamoCreatedIndex is the local secondary index of the creation times.
+const dynamoCreatedIndex = "created"
+
+// dynamoItem is an item of the API, the values are typed by S or N.
+type dynamoItem map[string]map[string]string
+
+// NewDynamoDB returns the store of the vault in the table, the endpoint
+// defaults to the one of the region.
+func NewDynamoDB(endpoint, region, table, vault string, credentials sigv4.Credentials) *DynamoDB {
+	if endpoint == "" {
+		endpoint = "https://dynamodb." + region + ".amazonaws.com"
+	}
+
+	return &DynamoDB{
+		table:       table,
+		vault:       vault,
+		endpoint:    strings.TrimSuffix(endpoint, "/") + "/",
+		region:      region,
+		credentials: credentials,
+		client:      &http.Client{Timeout: documentTimeout},
+	}
+}
+
+func (d *DynamoDB) String() string {
+	return "dynamodb:" + d.table + "/" + d.vault
+}
+
+func (d *DynamoDB) List(ctx context.Context) ([]Document, error) {
+	var (
+		docs []Document
+		last dynamoItem
+	)
+
+	for {
+		req := map[string]interface{}{
+			"TableName":                 d.table,
+			"KeyConditionExpression":    "#vault = :vault",
+			"ExpressionAttributeNames":  map[string]string{"#vault": "vault"},
+			"ExpressionAttributeValues": dynamoItem{":vault": {"S": d.vault}},
+			"ConsistentRead":            true,
+		}
+
+		if last != nil {
+			req["ExclusiveStartKey"] = last
+		}
+
+		var resp struct {
+			Items            []dynamoItem `json:"Items"`
+			LastEvaluatedKey dynamoItem   `json:"LastEvaluatedKey"`
+		}
+
+		if err := d.call(ctx, "Query", req, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, item := range resp.Items {
+			docs = append(docs, dynamoDocument(item))
+		}
+
+		if len(resp.LastEvaluatedKey) == 0 {
+			return docs, nil
+		}
+
+		last = resp.LastEvaluatedKey
+	}
+}
+
+// Query filters the items of the index by the owner and by the lowercase
+// "search" copy of the description, the tag is matched again, so "#prod"
+// doesn't find "#production". The items are read until the limit after the
+// offset is found, DynamoDB has no offset of its own.
+func (d *DynamoDB) Query(ctx context.Context, filter SecretsFilter, offset, limit int) ([]Document, error) {
+	names := map[string]string{"#vault": "vault"}
+	values := dynamoItem{":vault": {"S": d.vault}}
+
+	var conditions []string
+
+	if filter.Owner != 0 {
+		names["#owner"] = "owner"
+		values[":owner"] = map[string]string{"S": strconv.FormatInt(filter.Owner, 10)}
+		conditions = append(conditions, "(attribute_not_exists(#owner) OR #owner = :owner)")
+	}
+
+	if filter.Tag != "" {
+		names["#search"] = "search"
+		values[":tag"] = map[string]string{"S": "#" + strings.ToLower(strings.TrimPrefix(filter.Tag, "#"))}
+		conditions = append(conditions, "contains(#search, :tag)")
+	}
+
+	if filter.Description != "" {
+		names["#search"] = "search"
+		values[":description"] = map[string]string{"S": filter.Description}
+		conditions = append(conditions, "contains(#search, :description)")
+	}
+
+	var (
+		docs []Document
+		last dynamoItem
+	)
+
+	for {
+		req := map[string]interface{}{
+			"TableName":                 d.table,
+			"IndexName":                 dynamoCreatedIndex,
+			"KeyConditionExpression":    "#vault = :vault",
+			"ExpressionAttributeNames":  names,
+			"ExpressionAttributeValues": values,
+			"ConsistentRead":            true,
+		}
+
+		if len(conditions) > 0 {
+			req["FilterExpression"] = strings.Join(conditions, " AND ")
+		}
+
+		if last != nil {
+			req["ExclusiveStartKey"] = last
+		}
+
+		var resp struct {
+			Items            []dynamoItem `json:"Items"`
+			LastEvaluatedKey dynamoItem   `json:"LastEvaluatedKey"`
+		}
+
+		if err := d.call(ctx, "Query", req, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, item := range resp.Items {
+			if doc := dynamoDocument(item); filter.Match(doc.Secret) {
+				docs = append(docs, doc)
+			}
+		}
+
+		if len(resp.LastEvaluatedKey) == 0 || limit > 0 && len(docs) >= offset+limit {
+			sortDocuments(docs)
+
+			return pageDocuments(docs, offset, limit), nil
+		}
+
+		last = resp.LastEvaluatedKey
+	}
+}
+
+func (d *DynamoDB) Get(ctx context.Context, id string) (Document, error) {
+	var resp struct {
+		Item dynamoItem `json:"Item"`
+	}
+
+	err := d.call(ctx, "GetItem", map[string]interface{}{
+		"TableName":      d.table,
+		"Key":            d.key(id),
+		"ConsistentRead": true,
+	}, &resp)
+	if err != nil {
+		return Document{}, err
+	}
+
+	if resp.Item == nil {
+		return Document{}, kindError{kind: ErrNotFound, err: errors.New("no item " + id)}
+	}
+
+	return dynamoDocument(resp.Item), nil
+}
+
+func (d *DynamoDB) Create(ctx context.Context, doc Document) (string, error) {
+	return d.put(ctx, doc, "1", map[string]interface{}{
+		"ConditionExpression":      "attribute_not_exists(#id)",
+		"ExpressionAttributeNames": map[string]string{"#id": "id"},
+	})
+}
+
+func (d *DynamoDB) Update(ctx context.Context, doc Document, version string) (string, error) {
+	n, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return "", errors.Wrap(err, "parse version")
+	}
+
+	return d.put(ctx, doc, strconv.FormatInt(n+1, 10), map[string]interface{}{
+		"ConditionExpression":       "#version = :version",
+		"ExpressionAttributeNames":  map[string]string{"#version": "version"},
+		"ExpressionAttributeValues": dynamoItem{":version": {"N": version}},
+	})
+}
+
+func (d *DynamoDB) Delete(ctx context.Context, id, version string) error {
+	return d.call(ctx, "DeleteItem", map[string]interface{}{
+		"TableName":                 d.table,
+		"Key":                       d.key(id),
+		"ConditionExpression":       "#version = :version",
+		"ExpressionAttributeNames":  map[string]string{"#version": "version"},
+		"ExpressionAttributeValues": dynamoItem{":version": {"N": version}},
+	}, nil)
+}
+
+// put writes the item of the document with the version on the condition.
+func (d *DynamoDB) put(ctx context.Context, doc Document, version string, req map[string]interface{}) (string, error) {
+	item := d.key(doc.ID)
+	item["version"] = map[string]string{"N": version}
+
+	if created := doc.created(); created != "" {
+		item["created"] = map[string]string{"S": created}
+	}
+
+	if doc.Secret.Description != "" {
+		item["search"] = map[string]string{"S": strings.ToLower(doc.Secret.Description)}
+	}
+
+	for name, value := range doc.fields() {
+		item[name] = map[string]string{"S": value}
+	}
+
+	req["TableName"] = d.table
+	req["Item"] = item
+
+	if err := d.call(ctx, "PutItem", req, nil); err != nil {
+		return "", err
+	}
+
+	return version, nil
+}
+
+func (d *DynamoDB) key(id string) dynamoItem {
+	return dynamoItem{"vault": {"S": d.vault}, "id": {"S": id}}
+}
+
+// call sends the signed request of the action and decodes the response into
+// the result, the errors are marked with their kinds.
+func (d *DynamoDB) call(ctx context.Context, action string, params, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return errors.Wrap(err, "encode request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+action)
+
+	endpoint, _ := url.Parse(d.endpoint)
+	d.credentials.Sign(req, "dynamodb", d.region, sigv4.EscapePath(endpoint.Path), "", body, time.Now().UTC())
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, action)
+	}
+
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, remoteMaxSize))
+	if err != nil {
+		return errors.Wrap(err, "read response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Wrap(awsError(resp.StatusCode, b), action)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return errors.Wrap(json.Unmarshal(b, result), "decode "+action)
+}
+
+// dynamoDocument decodes the item, the string attributes other than the keys
+// are the fields.
+func dynamoDocument(item dynamoItem) Document {
+	doc := Document{ID: item["id"]["S"], Version: item["version"]["N"]}
+	doc.Created, _ = time.Parse(time.RFC3339Nano, item["created"]["S"])
+
+	for name, value := range item {
+		if s, ok := value["S"]; ok {
+			doc.setField(name, s)
+		}
+	}
+
+	return doc
+}
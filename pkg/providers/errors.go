@@ -0,0 +1,82 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	ErrNotFound = errors.New("secret not found")
+	// ErrConflict is returned when the secret was changed by someone else
+	// since it was read, the change should be repeated on the fresh secrets.
+	ErrConflict = errors.New("secret changed concurrently")
+	// ErrUnauthorized is returned when the storage rejects the credentials
+	// or the access to the document.
+	ErrUnauthorized = errors.New("access to storage denied")
+	// ErrQuotaExceeded is returned when the storage limits the requests.
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+)
+
+// kindError keeps the original error, errors.Is matches it with the kind too.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e kindError) Unwrap() error {
+	return e.err
+}
+
+func (e kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// googleError marks the error of the Google API with its kind.
+func googleError(err error) error {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	switch apiErr.Code {
+	case http.StatusNotFound:
+		return kindError{kind: ErrNotFound, err: err}
+	case http.StatusConflict:
+		return kindError{kind: ErrConflict, err: err}
+	case http.StatusTooManyRequests:
+		return kindError{kind: ErrQuotaExceeded, err: err}
+	case http.StatusUnauthorized:
+		return kindError{kind: ErrUnauthorized, err: err}
+	case http.StatusForbidden:
+		for _, item := range apiErr.Errors {
+			if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" ||
+				item.Reason == "quotaExceeded" {
+				return kindError{kind: ErrQuotaExceeded, err: err}
+			}
+		}
+
+		return kindError{kind: ErrUnauthorized, err: err}
+	}
+
+	return err
+}
@@ -0,0 +1,291 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/firestore/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+// Firestore is the DocumentStore of a Firestore collection, the version is
+// the update time of the document the writes are preconditioned on. The
+// documents keep the "created" field the queries order by, the "tags" array
+// and the owner, "0" for none, they filter by.
+type Firestore struct {
+	service    *firestore.Service
+	client     *http.Client
+	parent     string
+	collection string
+}
+
+// NewFirestore returns the store of the collection in the database of the
+// project, the credentials are the content of the Google credentials file.
+func NewFirestore(googleCreds []byte, projectID, database, collection string) (*Firestore, error) {
+	ctx := context.Background()
+
+	client, _, err := htransport.NewClient(ctx, option.WithCredentialsJSON(googleCreds),
+		option.WithScopes(firestore.DatastoreScope))
+	if err != nil {
+		return nil, errors.Wrap(err, "init firestore client")
+	}
+
+	service, err := firestore.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, errors.Wrap(err, "init firestore service")
+	}
+
+	return &Firestore{
+		service:    service,
+		client:     client,
+		parent:     "projects/" + projectID + "/databases/" + database + "/documents",
+		collection: collection,
+	}, nil
+}
+
+func (f *Firestore) String() string {
+	return "firestore:" + f.collection
+}
+
+func (f *Firestore) List(ctx context.Context) ([]Document, error) {
+	var docs []Document
+
+	err := f.service.Projects.Databases.Documents.List(f.parent, f.collection).PageSize(300).Pages(ctx,
+		func(resp *firestore.ListDocumentsResponse) error {
+			for _, doc := range resp.Documents {
+				docs = append(docs, firestoreDocument(doc))
+			}
+
+			return nil
+		})
+	if err != nil {
+		return nil, errors.Wrap(firestoreError(err), "list documents")
+	}
+
+	return docs, nil
+}
+
+// Query pushes the owner, the tag, the offset and the limit into the query,
+// Firestore has no search of a part of a string, so the documents of a
+// description are matched after the read.
+func (f *Firestore) Query(ctx context.Context, filter SecretsFilter, offset, limit int) ([]Document, error) {
+	query := &firestore.StructuredQuery{
+		From:    []*firestore.CollectionSelector{{CollectionId: f.collection}},
+		OrderBy: []*firestore.Order{{Field: &firestore.FieldReference{FieldPath: "created"}, Direction: "ASCENDING"}},
+	}
+
+	var filters []*firestore.Filter
+
+	if filter.Owner != 0 {
+		filters = append(filters, firestoreFilter("owner", "IN", &firestore.Value{
+			ArrayValue: &firestore.ArrayValue{Values: []*firestore.Value{
+				{StringValue: "0"}, {StringValue: strconv.FormatInt(filter.Owner, 10)},
+			}},
+		}))
+	}
+
+	if filter.Tag != "" {
+		filters = append(filters, firestoreFilter("tags", "ARRAY_CONTAINS", &firestore.Value{
+			StringValue: strings.ToLower(strings.TrimPrefix(filter.Tag, "#")),
+		}))
+	}
+
+	switch len(filters) {
+	case 0:
+	case 1:
+		query.Where = filters[0]
+	default:
+		query.Where = &firestore.Filter{CompositeFilter: &firestore.CompositeFilter{Op: "AND", Filters: filters}}
+	}
+
+	if filter.Description == "" {
+		query.Offset, query.Limit = int64(offset), int64(limit)
+
+		return f.runQuery(ctx, query)
+	}
+
+	docs, err := f.runQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	found := docs[:0]
+
+	for _, doc := range docs {
+		if filter.Match(doc.Secret) {
+			found = append(found, doc)
+		}
+	}
+
+	return pageDocuments(found, offset, limit), nil
+}
+
+// runQuery runs the query itself, the generated call decodes a single result
+// while the API answers with an array of them.
+func (f *Firestore) runQuery(ctx context.Context, query *firestore.StructuredQuery) ([]Document, error) {
+	body, err := json.Marshal(&firestore.RunQueryRequest{StructuredQuery: query})
+	if err != nil {
+		return nil, errors.Wrap(err, "encode query")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		f.service.BasePath+"v1/"+f.parent+":runQuery", bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "new request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "run query")
+	}
+
+	defer resp.Body.Close()
+
+	// The missing index is a failed precondition too, it isn't a conflict.
+	if err = googleapi.CheckResponse(resp); err != nil {
+		return nil, errors.Wrap(googleError(err), "run query")
+	}
+
+	var results []firestore.RunQueryResponse
+	if err = json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, errors.Wrap(err, "decode query")
+	}
+
+	var docs []Document
+
+	for _, result := range results {
+		if result.Document != nil {
+			docs = append(docs, firestoreDocument(result.Document))
+		}
+	}
+
+	return docs, nil
+}
+
+func (f *Firestore) Get(ctx context.Context, id string) (Document, error) {
+	doc, err := f.service.Projects.Databases.Documents.Get(f.name(id)).Context(ctx).Do()
+	if err != nil {
+		return Document{}, errors.Wrap(firestoreError(err), "get document")
+	}
+
+	return firestoreDocument(doc), nil
+}
+
+// Create adds the document, the creation time is set by Firestore.
+func (f *Firestore) Create(ctx context.Context, doc Document) (string, error) {
+	created, err := f.service.Projects.Databases.Documents.CreateDocument(f.parent, f.collection, firestoreFields(doc)).
+		DocumentId(doc.ID).Context(ctx).Do()
+	if err != nil {
+		return "", errors.Wrap(firestoreError(err), "create document")
+	}
+
+	return created.UpdateTime, nil
+}
+
+// Update replaces all the fields of the document.
+func (f *Firestore) Update(ctx context.Context, doc Document, version string) (string, error) {
+	updated, err := f.service.Projects.Databases.Documents.Patch(f.name(doc.ID), firestoreFields(doc)).
+		CurrentDocumentUpdateTime(version).Context(ctx).Do()
+	if err != nil {
+		return "", errors.Wrap(firestoreError(err), "update document")
+	}
+
+	return updated.UpdateTime, nil
+}
+
+func (f *Firestore) Delete(ctx context.Context, id, version string) error {
+	_, err := f.service.Projects.Databases.Documents.Delete(f.name(id)).
+		CurrentDocumentUpdateTime(version).Context(ctx).Do()
+
+	return errors.Wrap(firestoreError(err), "delete document")
+}
+
+func (f *Firestore) name(id string) string {
+	return f.parent + "/" + f.collection + "/" + id
+}
+
+func firestoreFilter(field, op string, value *firestore.Value) *firestore.Filter {
+	return &firestore.Filter{FieldFilter: &firestore.FieldFilter{
+		Field: &firestore.FieldReference{FieldPath: field},
+		Op:    op,
+		Value: value,
+	}}
+}
+
+func firestoreFields(doc Document) *firestore.Document {
+	fields := make(map[string]firestore.Value)
+	for name, value := range doc.fields() {
+		fields[name] = firestore.Value{StringValue: value}
+	}
+
+	if created := doc.created(); created != "" {
+		fields["created"] = firestore.Value{StringValue: created}
+
+		// The query can't select the missing owner.
+		if _, ok := fields["owner"]; !ok {
+			fields["owner"] = firestore.Value{StringValue: "0"}
+		}
+
+		tags := &firestore.ArrayValue{}
+		for _, tag := range doc.Secret.Tags() {
+			tags.Values = append(tags.Values, &firestore.Value{StringValue: tag})
+		}
+
+		fields["tags"] = firestore.Value{ArrayValue: tags}
+	}
+
+	return &firestore.Document{Fields: fields}
+}
+
+// firestoreDocument decodes the document, the creation time of the field is
+// preferred, so the positions follow the order of the queries.
+func firestoreDocument(doc *firestore.Document) Document {
+	d := Document{ID: doc.Name[strings.LastIndex(doc.Name, "/")+1:], Version: doc.UpdateTime}
+	d.Created, _ = time.Parse(time.RFC3339Nano, doc.CreateTime)
+
+	for name, value := range doc.Fields {
+		d.setField(name, value.StringValue)
+	}
+
+	if created, err := time.Parse(time.RFC3339Nano, doc.Fields["created"].StringValue); err == nil {
+		d.Created = created
+	}
+
+	return d
+}
+
+// firestoreError marks the failed precondition of a changed document as a
+// conflict, the other errors are marked by googleError.
+func firestoreError(err error) error {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest &&
+		strings.Contains(apiErr.Body, "FAILED_PRECONDITION") {
+		return kindError{kind: ErrConflict, err: err}
+	}
+
+	return googleError(err)
+}
@@ -0,0 +1,74 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"math/big"
+	"strings"
+)
+
+const (
+	idLength = 6
+	// idAlphabet has no characters which are easy to confuse, e.g. 0 and o.
+	idAlphabet = "23456789abcdefghijkmnpqrstuvwxyz"
+)
+
+// NewID returns a random secret ID which none of the secrets has.
+func NewID(secrets []SecretsData) string {
+	for {
+		var bld strings.Builder
+
+		for i := 0; i < idLength; i++ {
+			n, _ := rand.Int(rand.Reader, big.NewInt(int64(len(idAlphabet))))
+			bld.WriteByte(idAlphabet[n.Int64()])
+		}
+
+		if id := bld.String(); FindByID(secrets, id) < 0 {
+			return id
+		}
+	}
+}
+
+// StableID returns the ID of the secret. The secrets stored before the IDs get
+// an ID derived from the stored values, it is kept once the secret is changed.
+func (s SecretsData) StableID() string {
+	if s.ID != "" {
+		return s.ID
+	}
+
+	h := sha256.Sum256([]byte(s.Description + "\n" + s.Username + "\n" + s.Secret))
+
+	id := make([]byte, idLength)
+	for i := range id {
+		id[i] = idAlphabet[int(h[i])%len(idAlphabet)]
+	}
+
+	return string(id)
+}
+
+// FindByID returns the position of the secret with the ID or -1.
+func FindByID(secrets []SecretsData, id string) int {
+	id = strings.ToLower(strings.TrimSpace(id))
+
+	for index, secret := range secrets {
+		if secret.StableID() == id {
+			return index
+		}
+	}
+
+	return -1
+}
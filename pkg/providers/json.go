@@ -1,12 +1,13 @@
 package providers
 
 import (
+	"bytes"
 	"encoding/json"
-	"io"
 	"os"
-	"path/filepath"
+	"secretable/pkg/fileperm"
 	"secretable/pkg/log"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -19,6 +20,17 @@ type jsonStorage struct {
 type JSONStorage struct {
 	filepath string
 	mx       sync.RWMutex
+
+	// encrypted storage keeps the whole file encrypted with the master
+	// password, seal is set once the storage is opened.
+	encrypted bool
+	seal      *sealer
+
+	// cache keeps the parsed file until it is written or changed externally,
+	// which is noticed by the modification time and the size of the file.
+	cache   *jsonStorage
+	modTime time.Time
+	size    int64
 }
 
 func NewJSONStorage(path string) (*JSONStorage, error) {
@@ -28,11 +40,11 @@ func NewJSONStorage(path string) (*JSONStorage, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			if err := fileperm.MkdirAll(path); err != nil {
 				return nil, errors.Wrap(err, "mkdir")
 			}
 
-			if file, err = os.Create(path); err != nil {
+			if file, err = fileperm.Create(path); err != nil {
 				return nil, errors.Wrap(err, "create file")
 			}
 
@@ -44,6 +56,24 @@ func NewJSONStorage(path string) (*JSONStorage, error) {
 
 	file.Close()
 
+	if b, err := os.ReadFile(path); err == nil {
+		_, storage.encrypted = parseSealed(b)
+	}
+
+	return storage, nil
+}
+
+// NewEncryptedJSONStorage returns the JSON storage whose file is encrypted as a
+// whole with the master password, the file kept in the clear is encrypted once
+// the vault is unlocked.
+func NewEncryptedJSONStorage(path string) (*JSONStorage, error) {
+	storage, err := NewJSONStorage(path)
+	if err != nil {
+		return nil, err
+	}
+
+	storage.encrypted = true
+
 	return storage, nil
 }
 
@@ -51,39 +81,117 @@ func (t *JSONStorage) AddSecret(data SecretsData) error {
 	t.mx.Lock()
 	defer t.mx.Unlock()
 
-	storage, err := readFile(t.filepath)
+	storage, err := t.read()
 	if err != nil {
 		return errors.Wrap(err, "read file")
 	}
 
 	storage.Secrets = append(storage.Secrets, data)
 
-	if err = writeFile(t.filepath, storage); err != nil {
+	if err = t.write(storage); err != nil {
 		return errors.Wrap(err, "write file")
 	}
 
 	return nil
 }
 
-func readFile(path string) (storage jsonStorage, err error) {
-	file, err := os.Open(path)
+func (t *JSONStorage) AddSecrets(data []SecretsData) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	storage, err := t.read()
+	if err != nil {
+		return errors.Wrap(err, "read file")
+	}
+
+	storage.Secrets = append(storage.Secrets, data...)
+
+	if err = t.write(storage); err != nil {
+		return errors.Wrap(err, "write file")
+	}
+
+	return nil
+}
+
+// read parses the file, the encrypted file is readable once the storage is
+// opened. The caller holds the lock.
+func (t *JSONStorage) read() (storage jsonStorage, err error) {
+	b, err := os.ReadFile(t.filepath)
 	if err != nil {
-		return storage, errors.Wrap(err, "open file")
+		return storage, errors.Wrap(err, "read file")
+	}
+
+	if file, ok := parseSealed(b); ok {
+		if t.seal == nil {
+			return storage, ErrSealed
+		}
+
+		if b, err = t.seal.open(file); err != nil {
+			return storage, errors.Wrap(err, "decrypt file")
+		}
 	}
 
-	defer file.Close()
+	if len(bytes.TrimSpace(b)) == 0 {
+		return storage, nil
+	}
 
-	if err = json.NewDecoder(file).Decode(&storage); err != nil && !errors.Is(err, io.EOF) {
+	if err = json.Unmarshal(b, &storage); err != nil {
 		return storage, errors.Wrap(err, "unmarshal json")
 	}
 
 	return storage, nil
 }
 
-func writeFile(path string, storage jsonStorage) (err error) {
+// cached returns the parsed file, the file is read again after a write or an
+// external change.
+func (t *JSONStorage) cached() (jsonStorage, error) {
+	info, err := os.Stat(t.filepath)
+	if err != nil {
+		return jsonStorage{}, errors.Wrap(err, "stat file")
+	}
+
+	t.mx.RLock()
+	if t.cache != nil && t.modTime.Equal(info.ModTime()) && t.size == info.Size() {
+		storage := *t.cache
+		t.mx.RUnlock()
+
+		return storage, nil
+	}
+	t.mx.RUnlock()
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	storage, err := t.read()
+	if err != nil {
+		return storage, err
+	}
+
+	t.cache = &storage
+	t.modTime = info.ModTime()
+	t.size = info.Size()
+
+	return storage, nil
+}
+
+// write writes the file and drops the cache, the file of the encrypted storage
+// is encrypted with the master password. The caller holds the lock.
+func (t *JSONStorage) write(storage jsonStorage) (err error) {
+	t.cache = nil
+
 	b, _ := json.Marshal(storage)
 
-	if err = os.WriteFile(path, b, os.ModePerm); err != nil {
+	if t.encrypted {
+		if t.seal == nil {
+			return ErrSealed
+		}
+
+		if b, err = t.seal.seal(b); err != nil {
+			return errors.Wrap(err, "encrypt file")
+		}
+	}
+
+	if err = fileperm.WriteFile(t.filepath, b); err != nil {
 		return errors.Wrap(err, "write file")
 	}
 
@@ -94,14 +202,14 @@ func (t *JSONStorage) SetKey(key string) error {
 	t.mx.Lock()
 	defer t.mx.Unlock()
 
-	storage, err := readFile(t.filepath)
+	storage, err := t.read()
 	if err != nil {
 		return errors.Wrap(err, "read file")
 	}
 
 	storage.Key = key
 
-	if err = writeFile(t.filepath, storage); err != nil {
+	if err = t.write(storage); err != nil {
 		return errors.Wrap(err, "write file")
 	}
 
@@ -112,7 +220,7 @@ func (t *JSONStorage) DeleteSecret(index int) error {
 	t.mx.Lock()
 	defer t.mx.Unlock()
 
-	storage, err := readFile(t.filepath)
+	storage, err := t.read()
 	if err != nil {
 		return errors.Wrap(err, "read file")
 	}
@@ -123,7 +231,70 @@ func (t *JSONStorage) DeleteSecret(index int) error {
 
 	storage.Secrets = append(storage.Secrets[:index], storage.Secrets[index+1:]...)
 
-	if err = writeFile(t.filepath, storage); err != nil {
+	if err = t.write(storage); err != nil {
+		return errors.Wrap(err, "write file")
+	}
+
+	return nil
+}
+
+func (t *JSONStorage) DeleteSecrets(ids []string) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	storage, err := t.read()
+	if err != nil {
+		return errors.Wrap(err, "read file")
+	}
+
+	deleted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+
+	secrets := storage.Secrets[:0]
+
+	for _, secret := range storage.Secrets {
+		if !deleted[secret.StableID()] {
+			secrets = append(secrets, secret)
+		}
+	}
+
+	storage.Secrets = secrets
+
+	if err = t.write(storage); err != nil {
+		return errors.Wrap(err, "write file")
+	}
+
+	return nil
+}
+
+func (t *JSONStorage) UpdateSecret(id string, data SecretsData) error {
+	data.ID = id
+
+	return t.UpdateSecrets([]SecretsData{data})
+}
+
+func (t *JSONStorage) UpdateSecrets(data []SecretsData) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	storage, err := t.read()
+	if err != nil {
+		return errors.Wrap(err, "read file")
+	}
+
+	for _, secret := range data {
+		index := FindByID(storage.Secrets, secret.ID)
+		if index < 0 {
+			return ErrNotFound
+		}
+
+		secret.ID = storage.Secrets[index].StableID()
+		storage.Secrets[index] = secret
+	}
+
+	if err = t.write(storage); err != nil {
 		return errors.Wrap(err, "write file")
 	}
 
@@ -131,16 +302,46 @@ func (t *JSONStorage) DeleteSecret(index int) error {
 }
 
 func (t *JSONStorage) GetSecrets() (secrets []SecretsData, err error) {
-	storage, err := readFile(t.filepath)
+	storage, err := t.cached()
+	if err != nil {
+		return nil, errors.Wrap(err, "read file")
+	}
+
+	secrets = make([]SecretsData, len(storage.Secrets))
+	copy(secrets, storage.Secrets)
+
+	return secrets, nil
+}
+
+// Secrets iterates the cached file, the writes replace the cache instead of
+// changing it so the iteration doesn't hold the lock.
+func (t *JSONStorage) Secrets(fn func(index int, secret SecretsData) bool) error {
+	storage, err := t.cached()
+	if err != nil {
+		return errors.Wrap(err, "read file")
+	}
+
+	for index, secret := range storage.Secrets {
+		if !fn(index, secret) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// QuerySecrets filters the cached file without copying the other secrets.
+func (t *JSONStorage) QuerySecrets(filter SecretsFilter, offset, limit int) ([]SecretsData, error) {
+	storage, err := t.cached()
 	if err != nil {
 		return nil, errors.Wrap(err, "read file")
 	}
 
-	return storage.Secrets, nil
+	return filterSecrets(storage.Secrets, filter, offset, limit), nil
 }
 
 func (t *JSONStorage) GetKey() (string, error) {
-	storage, err := readFile(t.filepath)
+	storage, err := t.cached()
 	if err != nil {
 		return "", errors.Wrap(err, "read file")
 	}
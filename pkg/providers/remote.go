@@ -0,0 +1,275 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"secretable/pkg/fileperm"
+	"secretable/pkg/log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	remoteTimeout = 30 * time.Second
+	// remoteMaxSize limits the file read from the cloud storage.
+	remoteMaxSize = 64 << 20
+)
+
+// ErrNotModified is returned by the download of the file which still has the
+// revision.
+var ErrNotModified = errors.New("file not modified")
+
+// RemoteFile is the file of a cloud storage kept by the RemoteStorage, the
+// revision is the version of the file the storage compares, e.g. its ETag.
+type RemoteFile interface {
+	// Download returns the file along with its revision, ErrNotModified if
+	// the file still has the revision and ErrNotFound if it's missing.
+	Download(ctx context.Context, rev string) ([]byte, string, error)
+	// Upload writes the file if it still has the revision, the empty revision
+	// creates the file, and returns the new revision. ErrConflict is returned
+	// if the file was changed meanwhile.
+	Upload(ctx context.Context, data []byte, rev string) (string, error)
+	// String names the file in the logs.
+	String() string
+}
+
+// RemoteStorage is the JSON storage whose file is kept in a cloud storage,
+// e.g. WebDAV, Dropbox or Google Drive. The file is cached locally, so the
+// storage is read from the cache and works while the cloud is down, and every
+// change is uploaded on the revision of the last read. The change is refused
+// with ErrConflict if the file was changed in the cloud meanwhile, the cache
+// is read again in the background.
+type RemoteStorage struct {
+	*JSONStorage
+
+	file RemoteFile
+
+	// rev is the revision of the file the cache keeps, empty if the file was
+	// never read. syncmx keeps the uploads from interleaving with the reads.
+	rev    string
+	syncmx sync.Mutex
+
+	syncLoop
+}
+
+// NewRemoteStorage returns the storage of the file cached in the cache file,
+// the encrypted file is encrypted as a whole with the master password. The
+// cache is used as is if the cloud isn't reachable, a missing file is created
+// with the first change.
+func NewRemoteStorage(file RemoteFile, cachePath string, encrypted bool) (*RemoteStorage, error) {
+	t := &RemoteStorage{file: file, syncLoop: newSyncLoop()}
+
+	if err := fileperm.MkdirAll(cachePath); err != nil {
+		return nil, errors.Wrap(err, "mkdir")
+	}
+
+	// The file is read before the JSON storage is created, so the storage
+	// knows whether the file is encrypted.
+	if _, err := t.download(cachePath); err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			return nil, err
+		}
+
+		log.Error("Unable to read remote file, the cache is used: "+err.Error(), "file", file.String())
+	}
+
+	newStorage := NewJSONStorage
+	if encrypted {
+		newStorage = NewEncryptedJSONStorage
+	}
+
+	storage, err := newStorage(cachePath)
+	if err != nil {
+		return nil, err
+	}
+
+	t.JSONStorage = storage
+
+	return t, nil
+}
+
+// Start reads the file in the background until the context is done or the
+// storage is closed.
+func (t *RemoteStorage) Start(ctx context.Context) {
+	t.start(ctx, t.refresh)
+}
+
+// refresh reads the file into the cache unless it's the cached version.
+func (t *RemoteStorage) refresh() (bool, error) {
+	t.syncmx.Lock()
+	defer t.syncmx.Unlock()
+
+	changed, err := t.download(t.filepath)
+	if err != nil {
+		log.Error("Unable to read remote file: "+err.Error(), "file", t.file.String())
+	}
+
+	return changed, err
+}
+
+// download writes the file to the cache unless the cache keeps its revision,
+// a missing file keeps the cache. The caller holds syncmx.
+func (t *RemoteStorage) download(cachePath string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+
+	b, rev, err := t.file.Download(ctx, t.rev)
+
+	switch {
+	case errors.Is(err, ErrNotModified):
+		return false, nil
+	case errors.Is(err, ErrNotFound):
+		t.rev = ""
+
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	if t.JSONStorage != nil {
+		t.JSONStorage.mx.Lock()
+		defer t.JSONStorage.mx.Unlock()
+
+		// The file encrypted by another instance is kept encrypted.
+		if _, ok := parseSealed(b); ok {
+			t.JSONStorage.encrypted = true
+		}
+
+		t.JSONStorage.cache = nil
+	}
+
+	if err = fileperm.WriteFile(cachePath, b); err != nil {
+		return false, errors.Wrap(err, "write cache")
+	}
+
+	t.rev = rev
+
+	return true, nil
+}
+
+// upload writes the cache to the cloud on the revision of the last read. The
+// caller holds syncmx.
+func (t *RemoteStorage) upload(b []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+
+	rev, err := t.file.Upload(ctx, b, t.rev)
+	if err != nil {
+		return err
+	}
+
+	t.rev = rev
+
+	return nil
+}
+
+// readRemote reads the body of the file up to the size limit.
+func readRemote(r io.Reader) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, remoteMaxSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "read file")
+	}
+
+	if len(b) > remoteMaxSize {
+		return nil, errors.New("file exceeds " + strconv.Itoa(remoteMaxSize) + " bytes")
+	}
+
+	return b, nil
+}
+
+// change applies the change to the cache and uploads the cache. The cache is
+// restored if the upload fails, a conflict reads the file from the server.
+func (t *RemoteStorage) change(fn func() error) error {
+	t.syncmx.Lock()
+	defer t.syncmx.Unlock()
+
+	prev, err := os.ReadFile(t.filepath)
+	if err != nil {
+		return errors.Wrap(err, "read cache")
+	}
+
+	if err = fn(); err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(t.filepath)
+	if err != nil {
+		return errors.Wrap(err, "read cache")
+	}
+
+	if bytes.Equal(prev, b) {
+		return nil
+	}
+
+	err = t.upload(b)
+	if err == nil {
+		return nil
+	}
+
+	t.JSONStorage.mx.Lock()
+	t.JSONStorage.cache = nil
+
+	if restoreErr := fileperm.WriteFile(t.filepath, prev); restoreErr != nil {
+		log.Error("Unable to restore remote file cache: "+restoreErr.Error(), "file", t.filepath)
+	}
+	t.JSONStorage.mx.Unlock()
+
+	if errors.Is(err, ErrConflict) {
+		if _, syncErr := t.download(t.filepath); syncErr != nil {
+			log.Error("Unable to read remote file: "+syncErr.Error(), "file", t.file.String())
+		}
+	}
+
+	return err
+}
+
+func (t *RemoteStorage) AddSecret(data SecretsData) error {
+	return t.change(func() error { return t.JSONStorage.AddSecret(data) })
+}
+
+func (t *RemoteStorage) AddSecrets(data []SecretsData) error {
+	return t.change(func() error { return t.JSONStorage.AddSecrets(data) })
+}
+
+func (t *RemoteStorage) DeleteSecret(index int) error {
+	return t.change(func() error { return t.JSONStorage.DeleteSecret(index) })
+}
+
+func (t *RemoteStorage) DeleteSecrets(ids []string) error {
+	return t.change(func() error { return t.JSONStorage.DeleteSecrets(ids) })
+}
+
+func (t *RemoteStorage) UpdateSecret(id string, data SecretsData) error {
+	return t.change(func() error { return t.JSONStorage.UpdateSecret(id, data) })
+}
+
+func (t *RemoteStorage) UpdateSecrets(data []SecretsData) error {
+	return t.change(func() error { return t.JSONStorage.UpdateSecrets(data) })
+}
+
+func (t *RemoteStorage) SetKey(key string) error {
+	return t.change(func() error { return t.JSONStorage.SetKey(key) })
+}
+
+func (t *RemoteStorage) Seal(masterPass string) error {
+	return t.change(func() error { return t.JSONStorage.Seal(masterPass) })
+}
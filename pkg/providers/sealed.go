@@ -0,0 +1,205 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/json"
+	"os"
+	"secretable/pkg/crypto"
+	"secretable/pkg/log"
+
+	"github.com/mr-tron/base58/base58"
+	"github.com/pkg/errors"
+)
+
+const (
+	sealedFormat = "secretable-sealed-v1"
+	sealSaltSize = 16
+)
+
+var (
+	ErrSealed          = errors.New("storage is encrypted and not opened")
+	ErrWrongPassphrase = errors.New("wrong master password")
+)
+
+// sealedFile is the JSON storage encrypted as a whole with a key derived from
+// the master password, only the format is readable.
+type sealedFile struct {
+	Format string `json:"format"`
+	Salt   string `json:"salt"`
+	Nonce  string `json:"nonce"`
+	Data   string `json:"data"`
+}
+
+// sealer keeps the master password of the encrypted storage along with the
+// cipher derived for the salt of the file.
+type sealer struct {
+	phrase []byte
+	salt   []byte
+	aead   cipher.AEAD
+}
+
+func newSealer(phrase string) (*sealer, error) {
+	salt, err := crypto.MakeRandom(sealSaltSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "make salt")
+	}
+
+	aead, err := crypto.DeriveCipher([]byte(phrase), salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "derive cipher")
+	}
+
+	return &sealer{phrase: []byte(phrase), salt: salt, aead: aead}, nil
+}
+
+func (s *sealer) seal(plaintext []byte) ([]byte, error) {
+	nonce, err := crypto.MakeRandom(s.aead.NonceSize())
+	if err != nil {
+		return nil, errors.Wrap(err, "make nonce")
+	}
+
+	return json.Marshal(sealedFile{
+		Format: sealedFormat,
+		Salt:   base58.Encode(s.salt),
+		Nonce:  base58.Encode(nonce),
+		Data:   base58.Encode(s.aead.Seal(nil, nonce, plaintext, nil)),
+	})
+}
+
+func (s *sealer) open(file sealedFile) ([]byte, error) {
+	salt, err := base58.Decode(file.Salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode salt")
+	}
+
+	nonce, err := base58.Decode(file.Nonce)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode nonce")
+	}
+
+	data, err := base58.Decode(file.Data)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode data")
+	}
+
+	if s.aead == nil || !bytes.Equal(salt, s.salt) {
+		aead, err := crypto.DeriveCipher(s.phrase, salt)
+		if err != nil {
+			return nil, errors.Wrap(err, "derive cipher")
+		}
+
+		s.salt, s.aead = salt, aead
+	}
+
+	if len(nonce) != s.aead.NonceSize() {
+		return nil, errors.New("invalid nonce size")
+	}
+
+	plaintext, err := s.aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcm open")
+	}
+
+	return plaintext, nil
+}
+
+func parseSealed(b []byte) (sealedFile, bool) {
+	var file sealedFile
+	if err := json.Unmarshal(b, &file); err != nil || file.Format != sealedFormat {
+		return file, false
+	}
+
+	return file, true
+}
+
+// Open checks the master password against the encrypted file and keeps it for
+// the next reads and writes. The file kept in the clear is readable as is.
+func (t *JSONStorage) Open(masterPass string) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if t.seal != nil {
+		if subtle.ConstantTimeCompare(t.seal.phrase, []byte(masterPass)) != 1 {
+			return ErrWrongPassphrase
+		}
+
+		return nil
+	}
+
+	b, err := os.ReadFile(t.filepath)
+	if err != nil {
+		return errors.Wrap(err, "read file")
+	}
+
+	file, ok := parseSealed(b)
+	if !ok {
+		return nil
+	}
+
+	seal := &sealer{phrase: []byte(masterPass)}
+	if _, err = seal.open(file); err != nil {
+		return ErrWrongPassphrase
+	}
+
+	t.seal = seal
+	t.cache = nil
+
+	return nil
+}
+
+// Seal encrypts the file with the master password if the storage is encrypted,
+// the file kept in the clear is encrypted with the first verified password.
+// The file is encrypted again with a new salt when the password changes.
+func (t *JSONStorage) Seal(masterPass string) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if !t.encrypted {
+		return nil
+	}
+
+	if t.seal != nil && subtle.ConstantTimeCompare(t.seal.phrase, []byte(masterPass)) == 1 {
+		return nil
+	}
+
+	storage, err := t.read()
+	if err != nil {
+		return errors.Wrap(err, "read file")
+	}
+
+	seal, err := newSealer(masterPass)
+	if err != nil {
+		return err
+	}
+
+	prev := t.seal
+	t.seal = seal
+
+	if err = t.write(storage); err != nil {
+		t.seal = prev
+
+		return errors.Wrap(err, "write file")
+	}
+
+	if prev == nil {
+		log.Info("🔒 Encrypted JSON storage file " + t.filepath)
+	}
+
+	return nil
+}
@@ -0,0 +1,526 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"secretable/pkg/log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	storeTimeout = 30 * time.Second
+	// storeNameLength limits the part of the name made of the description.
+	storeNameLength = 64
+	// storeKeyName is the name of the wrapped key after the prefix.
+	storeKeyName = "master-key"
+)
+
+// SecretStore is a cloud secret manager, e.g. AWS Secrets Manager or Azure Key
+// Vault, whose secrets keep the secrets of the storage.
+type SecretStore interface {
+	// List returns the secrets whose names have the prefix without their
+	// values.
+	List(ctx context.Context, prefix string) ([]StoreSecret, error)
+	// Get returns the value of the secret.
+	Get(ctx context.Context, name string) (string, error)
+	// Create adds the secret, ErrConflict is returned if the name is taken,
+	// also by a deleted secret which is still recoverable.
+	Create(ctx context.Context, secret StoreSecret) error
+	// Update writes the new value and description of the secret.
+	Update(ctx context.Context, secret StoreSecret) error
+	Delete(ctx context.Context, name string) error
+	// String names the store in the logs.
+	String() string
+}
+
+// StoreSecret is a secret of the SecretStore. Updated changes with every
+// update, so the unchanged values aren't read again.
+type StoreSecret struct {
+	Name        string
+	Description string
+	Value       string
+	Created     time.Time
+	Updated     time.Time
+}
+
+// storeValue is the value of the secret of the store, the secret is encrypted
+// by the bot as in the other storages.
+type storeValue struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Username    string `json:"username"`
+	Secret      string `json:"secret"`
+	Owner       int64  `json:"owner,omitempty"`
+	Type        string `json:"type,omitempty"`
+	URL         string `json:"url,omitempty"`
+	MAC         string `json:"mac,omitempty"`
+}
+
+// storeEntry is the secret of the storage along with its name in the store.
+type storeEntry struct {
+	name    string
+	created time.Time
+	updated time.Time
+	data    SecretsData
+}
+
+// SecretStoreStorage maps the secrets to the native secrets of a SecretStore,
+// so the policies, the rotation and the audit of the store apply. The name of
+// a native secret is made of the description when the secret is added, the
+// value is JSON of the fields. The secrets are kept in memory, ordered by the
+// creation, and read again in the background.
+type SecretStoreStorage struct {
+	store  SecretStore
+	prefix string
+
+	entries []storeEntry
+	key     string
+	// keyUpdated is the update of the key secret the key was read at.
+	keyUpdated time.Time
+
+	// syncmx keeps the writes from interleaving with the reads.
+	syncmx sync.Mutex
+	mx     sync.RWMutex
+
+	syncLoop
+}
+
+// NewSecretStoreStorage returns the storage of the secrets of the store whose
+// names have the prefix.
+func NewSecretStoreStorage(store SecretStore, prefix string) (*SecretStoreStorage, error) {
+	t := &SecretStoreStorage{store: store, prefix: prefix, syncLoop: newSyncLoop()}
+
+	if _, err := t.update(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Start reads the secrets in the background until the context is done or the
+// storage is closed.
+func (t *SecretStoreStorage) Start(ctx context.Context) {
+	t.start(ctx, t.update)
+}
+
+// update reads the secrets of the store, only the values of the secrets
+// updated since the last read are read again.
+func (t *SecretStoreStorage) update() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	t.syncmx.Lock()
+	defer t.syncmx.Unlock()
+
+	listed, err := t.store.List(ctx, t.prefix)
+	if err != nil {
+		log.Error("Unable to list secrets: "+err.Error(), "store", t.store.String())
+
+		return false, errors.Wrap(err, "list secrets")
+	}
+
+	t.mx.RLock()
+	known := make(map[string]storeEntry, len(t.entries))
+	for _, entry := range t.entries {
+		known[entry.name] = entry
+	}
+	key, keyUpdated := t.key, t.keyUpdated
+	t.mx.RUnlock()
+
+	sort.Slice(listed, func(i, j int) bool {
+		if !listed[i].Created.Equal(listed[j].Created) {
+			return listed[i].Created.Before(listed[j].Created)
+		}
+
+		return listed[i].Name < listed[j].Name
+	})
+
+	changed := false
+	entries := make([]storeEntry, 0, len(listed))
+
+	for _, secret := range listed {
+		if secret.Name == t.prefix+storeKeyName {
+			if keyUpdated.IsZero() || !keyUpdated.Equal(secret.Updated) {
+				if key, err = t.store.Get(ctx, secret.Name); err != nil {
+					return false, errors.Wrap(err, "get key")
+				}
+
+				keyUpdated = secret.Updated
+			}
+
+			continue
+		}
+
+		if entry, ok := known[secret.Name]; ok && entry.updated.Equal(secret.Updated) {
+			entries = append(entries, entry)
+
+			continue
+		}
+
+		changed = true
+
+		value, err := t.store.Get(ctx, secret.Name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return false, errors.Wrap(err, "get secret "+secret.Name)
+		}
+
+		data, err := parseStoreValue(value)
+		if err != nil {
+			log.Error("Skip broken secret: "+err.Error(), "store", t.store.String(), "name", secret.Name)
+
+			continue
+		}
+
+		entries = append(entries, storeEntry{name: secret.Name, created: secret.Created, updated: secret.Updated, data: data})
+	}
+
+	t.mx.Lock()
+	changed = changed || key != t.key || len(entries) != len(t.entries)
+	t.entries = entries
+	t.key, t.keyUpdated = key, keyUpdated
+	t.mx.Unlock()
+
+	return changed, nil
+}
+
+func parseStoreValue(value string) (SecretsData, error) {
+	var v storeValue
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return SecretsData{}, errors.Wrap(err, "unmarshal json")
+	}
+
+	return SecretsData{
+		ID:          v.ID,
+		Description: v.Description,
+		Username:    v.Username,
+		Secret:      v.Secret,
+		Owner:       v.Owner,
+		Type:        v.Type,
+		URL:         v.URL,
+		MAC:         v.MAC,
+	}, nil
+}
+
+func storeSecret(name string, data SecretsData) StoreSecret {
+	b, _ := json.Marshal(storeValue{
+		ID:          data.StableID(),
+		Description: data.Description,
+		Username:    data.Username,
+		Secret:      data.Secret,
+		Owner:       data.Owner,
+		Type:        data.Type,
+		URL:         data.URL,
+		MAC:         data.MAC,
+	})
+
+	return StoreSecret{Name: name, Description: data.Description, Value: string(b)}
+}
+
+// storeName makes the name of the secret of the description out of the
+// letters, the digits and the dashes the stores accept. The ID is appended if
+// the name is taken.
+func storeName(prefix string, data SecretsData, taken map[string]bool) string {
+	var b strings.Builder
+
+	dash := false
+
+	for _, r := range strings.ToLower(data.Description) {
+		if 'a' <= r && r <= 'z' || '0' <= r && r <= '9' {
+			b.WriteRune(r)
+
+			dash = false
+
+			continue
+		}
+
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+
+			dash = true
+		}
+	}
+
+	name := strings.TrimSuffix(b.String(), "-")
+	if len(name) > storeNameLength {
+		name = strings.TrimSuffix(name[:storeNameLength], "-")
+	}
+
+	if name == "" {
+		name = "secret"
+	}
+
+	if taken[prefix+name] {
+		name += "-" + data.StableID()
+	}
+
+	return prefix + name
+}
+
+// taken returns the names of the secrets and the key. The caller holds mx.
+func (t *SecretStoreStorage) taken() map[string]bool {
+	names := make(map[string]bool, len(t.entries)+1)
+	names[t.prefix+storeKeyName] = true
+
+	for _, entry := range t.entries {
+		names[entry.name] = true
+	}
+
+	return names
+}
+
+func (t *SecretStoreStorage) AddSecret(data SecretsData) error {
+	return t.AddSecrets([]SecretsData{data})
+}
+
+// AddSecrets creates the secrets one by one, the store has no batches. The
+// name taken by a deleted secret is retried with the ID appended.
+func (t *SecretStoreStorage) AddSecrets(data []SecretsData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	t.syncmx.Lock()
+	defer t.syncmx.Unlock()
+
+	for _, secret := range data {
+		t.mx.RLock()
+		taken := t.taken()
+		t.mx.RUnlock()
+
+		name := storeName(t.prefix, secret, taken)
+
+		err := t.store.Create(ctx, storeSecret(name, secret))
+		if errors.Is(err, ErrConflict) && !strings.HasSuffix(name, "-"+secret.StableID()) {
+			taken[name] = true
+			name = storeName(t.prefix, secret, taken)
+			err = t.store.Create(ctx, storeSecret(name, secret))
+		}
+
+		if err != nil {
+			log.Error("Unable to create secret: "+err.Error(), "store", t.store.String(), "name", name)
+
+			return errors.Wrap(err, "create secret")
+		}
+
+		t.mx.Lock()
+		t.entries = append(t.entries, storeEntry{name: name, created: time.Now(), data: secret})
+		t.mx.Unlock()
+	}
+
+	return nil
+}
+
+func (t *SecretStoreStorage) DeleteSecret(index int) error {
+	t.mx.RLock()
+	if index < 0 || index >= len(t.entries) {
+		t.mx.RUnlock()
+
+		return nil
+	}
+	id := t.entries[index].data.StableID()
+	t.mx.RUnlock()
+
+	return t.DeleteSecrets([]string{id})
+}
+
+// DeleteSecrets deletes the secrets one by one, the store keeps them for the
+// recovery if it's configured to.
+func (t *SecretStoreStorage) DeleteSecrets(ids []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	t.syncmx.Lock()
+	defer t.syncmx.Unlock()
+
+	deleted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+
+	t.mx.RLock()
+	var names []string
+	for _, entry := range t.entries {
+		if deleted[entry.data.StableID()] {
+			names = append(names, entry.name)
+		}
+	}
+	t.mx.RUnlock()
+
+	for _, name := range names {
+		if err := t.store.Delete(ctx, name); err != nil && !errors.Is(err, ErrNotFound) {
+			log.Error("Unable to delete secret: "+err.Error(), "store", t.store.String(), "name", name)
+
+			return errors.Wrap(err, "delete secret")
+		}
+
+		t.mx.Lock()
+		for i, entry := range t.entries {
+			if entry.name == name {
+				t.entries = append(t.entries[:i:i], t.entries[i+1:]...)
+
+				break
+			}
+		}
+		t.mx.Unlock()
+	}
+
+	return nil
+}
+
+func (t *SecretStoreStorage) UpdateSecret(id string, data SecretsData) error {
+	data.ID = id
+
+	return t.UpdateSecrets([]SecretsData{data})
+}
+
+// UpdateSecrets writes the new values of the secrets, the names are kept.
+func (t *SecretStoreStorage) UpdateSecrets(data []SecretsData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	t.syncmx.Lock()
+	defer t.syncmx.Unlock()
+
+	for _, secret := range data {
+		t.mx.RLock()
+		index := FindByID(t.secrets(), secret.ID)
+		var entry storeEntry
+		if index >= 0 {
+			entry = t.entries[index]
+		}
+		t.mx.RUnlock()
+
+		if index < 0 {
+			return ErrNotFound
+		}
+
+		secret.ID = entry.data.StableID()
+
+		if err := t.store.Update(ctx, storeSecret(entry.name, secret)); err != nil {
+			log.Error("Unable to update secret: "+err.Error(), "store", t.store.String(), "name", entry.name)
+
+			return errors.Wrap(err, "update secret")
+		}
+
+		t.mx.Lock()
+		for i := range t.entries {
+			if t.entries[i].name == entry.name {
+				t.entries[i].data = secret
+			}
+		}
+		t.mx.Unlock()
+	}
+
+	return nil
+}
+
+// secrets returns the secrets of the entries. The caller holds mx.
+func (t *SecretStoreStorage) secrets() []SecretsData {
+	secrets := make([]SecretsData, len(t.entries))
+	for i, entry := range t.entries {
+		secrets[i] = entry.data
+	}
+
+	return secrets
+}
+
+func (t *SecretStoreStorage) GetSecrets() ([]SecretsData, error) {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+
+	return t.secrets(), nil
+}
+
+func (t *SecretStoreStorage) QuerySecrets(filter SecretsFilter, offset, limit int) ([]SecretsData, error) {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+
+	return filterSecrets(t.secrets(), filter, offset, limit), nil
+}
+
+// Secrets iterates the entries under the read lock.
+func (t *SecretStoreStorage) Secrets(fn func(index int, secret SecretsData) bool) error {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+
+	for index, entry := range t.entries {
+		if !fn(index, entry.data) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// SetKey writes the wrapped key to the secret of the key name.
+func (t *SecretStoreStorage) SetKey(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	t.syncmx.Lock()
+	defer t.syncmx.Unlock()
+
+	secret := StoreSecret{Name: t.prefix + storeKeyName, Description: "Secretable key", Value: key}
+
+	t.mx.RLock()
+	exists := t.key != ""
+	t.mx.RUnlock()
+
+	var err error
+	if exists {
+		err = t.store.Update(ctx, secret)
+	} else if err = t.store.Create(ctx, secret); errors.Is(err, ErrConflict) {
+		err = t.store.Update(ctx, secret)
+	}
+
+	if err != nil {
+		log.Error("Unable to write key: "+err.Error(), "store", t.store.String())
+
+		return errors.Wrap(err, "write key")
+	}
+
+	t.mx.Lock()
+	t.key, t.keyUpdated = key, time.Time{}
+	t.mx.Unlock()
+
+	return nil
+}
+
+func (t *SecretStoreStorage) GetKey() (string, error) {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+
+	return t.key, nil
+}
+
+// Open does nothing, the store encrypts the secrets at rest itself.
+func (t *SecretStoreStorage) Open(string) error {
+	return nil
+}
+
+// Seal does nothing, the store encrypts the secrets at rest itself.
+func (t *SecretStoreStorage) Seal(string) error {
+	return nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+// DriveSharing keeps the spreadsheets shared with the service account and the
+// allowed Google accounts only.
+type DriveSharing struct {
+	service *drive.Service
+	allowed map[string]bool
+	enforce bool
+}
+
+// NewDriveSharing returns the sharing of the accounts along with the service
+// account of the credentials. With enforce the other permissions are revoked,
+// otherwise they are only reported.
+func NewDriveSharing(googleCreds []byte, accounts []string, enforce bool) (*DriveSharing, error) {
+	var creds struct {
+		ClientEmail string `json:"client_email"`
+	}
+
+	if err := json.Unmarshal(googleCreds, &creds); err != nil {
+		return nil, errors.Wrap(err, "decode credentials file")
+	}
+
+	service, err := drive.NewService(context.Background(), option.WithCredentialsJSON(googleCreds))
+	if err != nil {
+		return nil, errors.Wrap(err, "init drive service")
+	}
+
+	allowed := map[string]bool{strings.ToLower(creds.ClientEmail): true}
+	for _, account := range accounts {
+		allowed[strings.ToLower(strings.TrimSpace(account))] = true
+	}
+
+	return &DriveSharing{service: service, allowed: allowed, enforce: enforce}, nil
+}
+
+// Check returns the permissions of the file beyond the allowed accounts, e.g.
+// "anyone (reader)", and the revoked ones. With enforce all of them are
+// revoked except the owner who can't be removed.
+func (s *DriveSharing) Check(fileID string) (drift, revoked []string, err error) {
+
+	err = s.service.Permissions.List(fileID).
+		Fields("nextPageToken", "permissions(id,type,role,emailAddress,domain)").
+		SupportsAllDrives(true).
+		Pages(context.Background(), func(list *drive.PermissionList) error {
+			for _, p := range list.Permissions {
+				if (p.Type == "user" || p.Type == "group") && s.allowed[strings.ToLower(p.EmailAddress)] {
+					continue
+				}
+
+				if !s.enforce || p.Role == "owner" {
+					drift = append(drift, describePermission(p))
+
+					continue
+				}
+
+				err := s.service.Permissions.Delete(fileID, p.Id).SupportsAllDrives(true).Do()
+				if err != nil {
+					drift = append(drift, describePermission(p))
+
+					return errors.Wrap(googleError(err), "delete permission "+describePermission(p))
+				}
+
+				revoked = append(revoked, describePermission(p))
+			}
+
+			return nil
+		})
+	if err != nil {
+		return drift, revoked, errors.Wrap(googleError(err), "list permissions")
+	}
+
+	return drift, revoked, nil
+}
+
+func describePermission(p *drive.Permission) string {
+	switch p.Type {
+	case "anyone":
+		return "anyone (" + p.Role + ")"
+	case "domain":
+		return "domain " + p.Domain + " (" + p.Role + ")"
+	}
+
+	return p.EmailAddress + " (" + p.Role + ")"
+}
+
+// CreateSpreadsheet creates a new spreadsheet owned by the service account of
+// the credentials and shares it with the email as an editor, so the owner of
+// the bot sees it in the Drive. The ID of the spreadsheet is returned.
+func CreateSpreadsheet(googleCreds []byte, title, shareWith string) (string, error) {
+	return createDriveFile(googleCreds, &drive.File{
+		Name:     title,
+		MimeType: "application/vnd.google-apps.spreadsheet",
+	}, shareWith)
+}
+
+// CreateDriveFile creates a new empty JSON file owned by the service account
+// of the credentials and shares it with the email like CreateSpreadsheet. The
+// ID of the file is returned.
+func CreateDriveFile(googleCreds []byte, name, shareWith string) (string, error) {
+	return createDriveFile(googleCreds, &drive.File{
+		Name:     name,
+		MimeType: "application/json",
+	}, shareWith)
+}
+
+func createDriveFile(googleCreds []byte, f *drive.File, shareWith string) (string, error) {
+	service, err := drive.NewService(context.Background(), option.WithCredentialsJSON(googleCreds))
+	if err != nil {
+		return "", errors.Wrap(err, "init drive service")
+	}
+
+	file, err := service.Files.Create(f).Fields("id").Do()
+	if err != nil {
+		return "", errors.Wrap(googleError(err), "create file")
+	}
+
+	if shareWith == "" {
+		return file.Id, nil
+	}
+
+	_, err = service.Permissions.Create(file.Id, &drive.Permission{
+		Type:         "user",
+		Role:         "writer",
+		EmailAddress: shareWith,
+	}).SendNotificationEmail(true).Do()
+	if err != nil {
+		return file.Id, errors.Wrap(googleError(err), "share file with "+shareWith)
+	}
+
+	return file.Id, nil
+}
@@ -16,49 +16,116 @@ package providers
 
 import (
 	"context"
+	"math/rand"
 	"secretable/pkg/log"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
 )
 
 const (
-	secretesRange = "Secrets!A1:E"
-	keysRange     = "Keys!A1:E"
-	secretsTitle  = "Secrets"
-	keysTitle     = "Keys"
+	keysRange    = "Keys!A1:E"
+	secretsTitle = "Secrets"
+	archiveTitle = "Archive"
+	keysTitle    = "Keys"
 
-	updateTimeout = 10 // in sec
+	defaultSyncInterval    = 10 * time.Second
+	defaultMaxSyncInterval = 5 * time.Minute
+
+	// syncJitter is the share of the interval the syncs are spread by.
+	syncJitter = 0.1
 )
 
 type GoogleSheetsStorage struct {
 	service       *sheets.Service
+	drive         *drive.Service
 	spreadsheetID string
 
+	// title is the name of the secrets sheet, Secrets or Archive.
+	title string
+
 	secretsID int64
 	keysID    int64
 
+	// header is the number of the frozen header rows of the secrets table,
+	// the tables created before the header have none.
+	header int64
+
 	secrets []SecretsData
 	key     string
 
+	// sharing manages the Drive permissions of the spreadsheet, nil if the
+	// sharing is left as is.
+	sharing *DriveSharing
+
+	// rows keeps the contents of the secrets by the IDs as they were last
+	// read or written by the bot, the rows changed otherwise are reported to
+	// onChange. syncmx keeps the writes from interleaving with the reads.
+	rows     map[string]string
+	onChange func(ExternalChange)
+	syncmx   sync.Mutex
+
+	// status is the state of the sync, onFailure is called after every
+	// failed sync. stop ends the background sync started by Start.
+	status    SyncStatus
+	onFailure func(SyncStatus)
+	stop      context.CancelFunc
+	stopped   chan struct{}
+
+	// interval is the time between the syncs, it's doubled up to maxInterval
+	// while the modification time of the spreadsheet stays lastModified.
+	interval     time.Duration
+	maxInterval  time.Duration
+	lastModified string
+
 	mx sync.RWMutex
 }
 
-func NewGoogleSheetsStorage(googleCredsFile, spreadsheetID string) (*GoogleSheetsStorage, error) {
-	service, err := sheets.NewService(context.Background(), option.WithCredentialsFile(googleCredsFile))
+// ExternalChange counts the secrets changed directly in the spreadsheet.
+type ExternalChange struct {
+	Modified int
+	Added    int
+	Removed  int
+}
+
+// NewGoogleSheetsStorage returns the storage of the spreadsheet, the
+// credentials are the content of the Google credentials file.
+func NewGoogleSheetsStorage(googleCreds []byte, spreadsheetID string) (*GoogleSheetsStorage, error) {
+	return newGoogleSheetsStorage(googleCreds, spreadsheetID, secretsTitle)
+}
+
+// NewGoogleSheetsArchive returns the storage of the Archive sheet of the
+// spreadsheet, the secrets are encrypted with the key of the Keys sheet.
+func NewGoogleSheetsArchive(googleCreds []byte, spreadsheetID string) (*GoogleSheetsStorage, error) {
+	return newGoogleSheetsStorage(googleCreds, spreadsheetID, archiveTitle)
+}
+
+func newGoogleSheetsStorage(googleCreds []byte, spreadsheetID, title string) (*GoogleSheetsStorage, error) {
+	service, err := sheets.NewService(context.Background(), option.WithCredentialsJSON(googleCreds))
 	if err != nil {
 		return nil, errors.Wrap(err, "init sheets service")
 	}
 
+	driveService, err := drive.NewService(context.Background(), option.WithCredentialsJSON(googleCreds))
+	if err != nil {
+		return nil, errors.Wrap(err, "init drive service")
+	}
+
 	tableProvider := new(GoogleSheetsStorage)
 	tableProvider.service = service
+	tableProvider.drive = driveService
 	tableProvider.spreadsheetID = spreadsheetID
+	tableProvider.title = title
+	tableProvider.interval = defaultSyncInterval
+	tableProvider.maxInterval = defaultMaxSyncInterval
 
-	for _, tab := range []string{secretsTitle, keysTitle} {
+	for _, tab := range []string{title, keysTitle} {
 		err = createTable(service, spreadsheetID, tab)
 		if err != nil {
 			return nil, err
@@ -69,21 +136,159 @@ func NewGoogleSheetsStorage(googleCredsFile, spreadsheetID string) (*GoogleSheet
 		return nil, err
 	}
 
-	go func() {
+	return tableProvider, nil
+}
+
+// Start reads the tables in the background until the context is done or the
+// storage is closed.
+func (t *GoogleSheetsStorage) Start(ctx context.Context) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if t.stop != nil {
+		return
+	}
+
+	ctx, t.stop = context.WithCancel(ctx)
+	t.stopped = make(chan struct{})
+
+	go func(stopped chan struct{}) {
+		defer close(stopped)
+
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		interval := t.interval
+
 		for {
-			time.Sleep(time.Second * updateTimeout)
+			jitter := time.Duration((random.Float64()*2 - 1) * syncJitter * float64(interval))
+			timer := time.NewTimer(interval + jitter)
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
 
-			if err = tableProvider.update(); err != nil {
-				log.Error("Unable update tables: " + err.Error())
+				return
+			case <-timer.C:
+				interval = t.sync(interval)
 			}
 		}
-	}()
+	}(t.stopped)
+}
 
-	return tableProvider, nil
+// SetSyncInterval sets the time between the syncs and the longest time the
+// syncs back off to while the spreadsheet isn't modified, zero keeps the
+// default. It's called before Start.
+func (t *GoogleSheetsStorage) SetSyncInterval(interval, maxInterval time.Duration) {
+	if interval > 0 {
+		t.interval = interval
+	}
+
+	if maxInterval > 0 {
+		t.maxInterval = maxInterval
+	}
+
+	if t.maxInterval < t.interval {
+		t.maxInterval = t.interval
+	}
+}
+
+// sync reads the tables if the spreadsheet was modified since the last read
+// and returns the time until the next sync. The time is doubled while the
+// spreadsheet stays the same, the tables are read every interval if the
+// modification time is unknown.
+func (t *GoogleSheetsStorage) sync(interval time.Duration) time.Duration {
+	file, err := t.drive.Files.Get(t.spreadsheetID).Fields("modifiedTime").SupportsAllDrives(true).Do()
+	if err != nil {
+		log.Debug("Unable to get modification time of spreadsheet: " + err.Error())
+	}
+
+	if err == nil && file.ModifiedTime != "" && file.ModifiedTime == t.lastModified {
+		t.synced(nil)
+
+		if interval *= 2; interval > t.maxInterval {
+			interval = t.maxInterval
+		}
+
+		return interval
+	}
+
+	t.lastModified = ""
+
+	if updateErr := t.update(); updateErr != nil {
+		log.Error("Unable update tables: " + updateErr.Error())
+
+		return t.interval
+	}
+
+	if err == nil {
+		t.lastModified = file.ModifiedTime
+	}
+
+	return t.interval
+}
+
+// Close stops the background sync and waits for the running read.
+func (t *GoogleSheetsStorage) Close() error {
+	t.mx.Lock()
+	stop, stopped := t.stop, t.stopped
+	t.stop = nil
+	t.mx.Unlock()
+
+	if stop != nil {
+		stop()
+		<-stopped
+	}
+
+	return nil
+}
+
+// SyncStatus returns the state of the sync of the tables.
+func (t *GoogleSheetsStorage) SyncStatus() SyncStatus {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+
+	return t.status
+}
+
+// OnSyncFailure sets the function called with the status after every failed
+// sync.
+func (t *GoogleSheetsStorage) OnSyncFailure(f func(SyncStatus)) {
+	t.mx.Lock()
+	t.onFailure = f
+	t.mx.Unlock()
+}
+
+// synced records the result of the read of the tables.
+func (t *GoogleSheetsStorage) synced(err error) {
+	t.mx.Lock()
+	t.status.Running = time.Time{}
+
+	if err == nil {
+		t.status.LastSync = time.Now()
+		t.status.Failures = 0
+		t.status.LastError = ""
+		t.mx.Unlock()
+
+		return
+	}
+
+	t.status.Failures++
+	t.status.LastError = err.Error()
+	status, onFailure := t.status, t.onFailure
+	t.mx.Unlock()
+
+	if onFailure != nil {
+		onFailure(status)
+	}
 }
 
+// secretsHeader names the columns of the secrets table.
+var secretsHeader = []interface{}{"Description", "Username", "Secret", "Owner", "Type", "URL", "ID", "MAC"}
+
+// createTable adds the sheet unless it exists. The new secrets sheet gets a
+// frozen header, the new keys sheet is protected and hidden, so the
+// collaborators of the spreadsheet don't overwrite the key by accident.
 func createTable(service *sheets.Service, spreadsheetID, tableTitle string) (err error) {
-	_, err = service.Spreadsheets.BatchUpdate(spreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
+	resp, err := service.Spreadsheets.BatchUpdate(spreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
 		Requests: []*sheets.Request{
 			{
 				AddSheet: &sheets.AddSheetRequest{
@@ -95,34 +300,216 @@ func createTable(service *sheets.Service, spreadsheetID, tableTitle string) (err
 		},
 	}).Do()
 
-	if err != nil && !strings.Contains(err.Error(), "already exists") {
-		return errors.Wrap(err, "add sheet")
+	if err != nil {
+		if strings.Contains(err.Error(), "already exists") {
+			return nil
+		}
+
+		return errors.Wrap(googleError(err), "add sheet")
+	}
+
+	sheetID := resp.Replies[0].AddSheet.Properties.SheetId
+
+	switch tableTitle {
+	case secretsTitle, archiveTitle:
+		return formatSecretsTable(service, spreadsheetID, sheetID, tableTitle)
+	case keysTitle:
+		return protectKeysTable(service, spreadsheetID, sheetID)
 	}
 
 	return nil
 }
 
-func (t *GoogleSheetsStorage) AddSecret(data SecretsData) error {
-	_, err := t.service.Spreadsheets.Values.Append(t.spreadsheetID, secretesRange, &sheets.ValueRange{
-		Values: [][]interface{}{
+func formatSecretsTable(service *sheets.Service, spreadsheetID string, sheetID int64, title string) error {
+	_, err := service.Spreadsheets.Values.Update(spreadsheetID, title+"!A1:H1", &sheets.ValueRange{
+		Values:         [][]interface{}{secretsHeader},
+		MajorDimension: "ROWS",
+	}).ValueInputOption("RAW").Do()
+	if err != nil {
+		return errors.Wrap(googleError(err), "write header")
+	}
+
+	_, err = service.Spreadsheets.BatchUpdate(spreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{
+			{
+				RepeatCell: &sheets.RepeatCellRequest{
+					Range: &sheets.GridRange{
+						SheetId:         sheetID,
+						EndRowIndex:     1,
+						ForceSendFields: []string{"SheetId"},
+					},
+					Cell: &sheets.CellData{
+						UserEnteredFormat: &sheets.CellFormat{
+							TextFormat: &sheets.TextFormat{Bold: true},
+						},
+					},
+					Fields: "userEnteredFormat.textFormat.bold",
+				},
+			},
+			{
+				UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{
+					Properties: &sheets.SheetProperties{
+						SheetId:         sheetID,
+						GridProperties:  &sheets.GridProperties{FrozenRowCount: 1},
+						ForceSendFields: []string{"SheetId"},
+					},
+					Fields: "gridProperties.frozenRowCount",
+				},
+			},
+		},
+	}).Do()
+	if err != nil {
+		return errors.Wrap(googleError(err), "format header")
+	}
+
+	return nil
+}
+
+// protectKeysTable leaves the keys sheet editable by the owner and the bot
+// only and hides it from the tabs.
+func protectKeysTable(service *sheets.Service, spreadsheetID string, sheetID int64) error {
+	_, err := service.Spreadsheets.BatchUpdate(spreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{
 			{
-				data.Description, data.Username, data.Secret,
+				AddProtectedRange: &sheets.AddProtectedRangeRequest{
+					ProtectedRange: &sheets.ProtectedRange{
+						Range: &sheets.GridRange{
+							SheetId:         sheetID,
+							ForceSendFields: []string{"SheetId"},
+						},
+						Description: "Wrapped key of Secretable",
+					},
+				},
+			},
+			{
+				UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{
+					Properties: &sheets.SheetProperties{
+						SheetId:         sheetID,
+						Hidden:          true,
+						ForceSendFields: []string{"SheetId"},
+					},
+					Fields: "hidden",
+				},
 			},
 		},
+	}).Do()
+	if err != nil {
+		return errors.Wrap(googleError(err), "protect sheet")
+	}
+
+	return nil
+}
+
+func (t *GoogleSheetsStorage) AddSecret(data SecretsData) error {
+	return t.AddSecrets([]SecretsData{data})
+}
+
+// AddSecrets appends the rows in a single call.
+func (t *GoogleSheetsStorage) AddSecrets(data []SecretsData) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	values := make([][]interface{}, len(data))
+	for i, secret := range data {
+		values[i] = secretRow(secret)
+	}
+
+	secretsRange := t.title + "!A1:H"
+
+	t.syncmx.Lock()
+
+	_, err := t.service.Spreadsheets.Values.Append(t.spreadsheetID, secretsRange, &sheets.ValueRange{
+		Values:         values,
 		MajorDimension: "ROWS",
 	}).ValueInputOption("RAW").InsertDataOption("INSERT_ROWS").Do()
 	if err != nil {
+		t.syncmx.Unlock()
+
 		log.Error("Unable to append new values to table: "+err.Error(),
 			"spreadsheet_id", t.spreadsheetID,
-			"sheet_range", secretesRange,
+			"sheet_range", secretsRange,
+			"count", len(data),
 		)
 
-		return errors.Wrap(err, "append secrets to table")
+		return errors.Wrap(googleError(err), "append secrets to table")
 	}
 
+	t.written(data, nil)
+	t.syncmx.Unlock()
+
+	t.refresh()
+
 	return nil
 }
 
+func (t *GoogleSheetsStorage) UpdateSecret(id string, data SecretsData) error {
+	data.ID = id
+
+	return t.UpdateSecrets([]SecretsData{data})
+}
+
+// UpdateSecrets rewrites the rows of the secrets in a single call. The rows are
+// looked up in the freshly read tables, so a row moved by another change isn't
+// overwritten.
+func (t *GoogleSheetsStorage) UpdateSecrets(data []SecretsData) error {
+	secrets, err := t.fresh()
+	if err != nil {
+		return err
+	}
+
+	ranges := make([]*sheets.ValueRange, 0, len(data))
+	updated := make([]SecretsData, 0, len(data))
+
+	for _, secret := range data {
+		index := FindByID(secrets, secret.ID)
+		if index < 0 {
+			return ErrNotFound
+		}
+
+		secret.ID = secrets[index].StableID()
+		row := strconv.FormatInt(int64(index)+t.header+1, 10)
+		updated = append(updated, secret)
+
+		ranges = append(ranges, &sheets.ValueRange{
+			Range:          t.title + "!A" + row + ":H" + row,
+			Values:         [][]interface{}{secretRow(secret)},
+			MajorDimension: "ROWS",
+		})
+	}
+
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	t.syncmx.Lock()
+
+	_, err = t.service.Spreadsheets.Values.BatchUpdate(t.spreadsheetID, &sheets.BatchUpdateValuesRequest{
+		Data:             ranges,
+		ValueInputOption: "RAW",
+	}).Do()
+	if err != nil {
+		t.syncmx.Unlock()
+
+		log.Error("Unable to update values of table: "+err.Error(), "spreadsheet_id", t.spreadsheetID, "count", len(ranges))
+
+		return errors.Wrap(googleError(err), "update secrets in table")
+	}
+
+	t.written(updated, nil)
+	t.syncmx.Unlock()
+
+	t.refresh()
+
+	return nil
+}
+
+func secretRow(data SecretsData) []interface{} {
+	return []interface{}{
+		data.Description, data.Username, data.Secret, formatOwner(data.Owner), data.Type, data.URL, data.ID, data.MAC,
+	}
+}
+
 func (t *GoogleSheetsStorage) SetKey(key string) error {
 	_, err := t.service.Spreadsheets.Values.Update(t.spreadsheetID, keysRange, &sheets.ValueRange{
 		Values: [][]interface{}{
@@ -138,17 +525,40 @@ func (t *GoogleSheetsStorage) SetKey(key string) error {
 			"sheet_range", keysRange,
 		)
 
-		return errors.Wrap(err, "append key to table")
+		return errors.Wrap(googleError(err), "append key to table")
 	}
 
 	return nil
 }
 
+// DeleteSecret deletes the row of the secret read before. ErrConflict is
+// returned if another change moved the secret since then.
 func (t *GoogleSheetsStorage) DeleteSecret(index int) error {
-	return t.delete(t.secretsID, index)
+	secrets, err := t.GetSecrets()
+	if err != nil {
+		return err
+	}
+
+	if index < 0 || index >= len(secrets) {
+		return ErrNotFound
+	}
+
+	id := secrets[index].StableID()
+
+	if secrets, err = t.fresh(); err != nil {
+		return err
+	}
+
+	if index >= len(secrets) || secrets[index].StableID() != id {
+		return ErrConflict
+	}
+
+	return t.delete(t.secretsID, int(t.header)+index, secrets[index].StableID())
 }
 
-func (t *GoogleSheetsStorage) delete(sheetID int64, index int) error {
+func (t *GoogleSheetsStorage) delete(sheetID int64, index int, id string) error {
+	t.syncmx.Lock()
+
 	_, err := t.service.Spreadsheets.BatchUpdate(t.spreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
 		Requests: []*sheets.Request{
 			{
@@ -164,34 +574,213 @@ func (t *GoogleSheetsStorage) delete(sheetID int64, index int) error {
 		},
 	}).Do()
 	if err != nil {
+		t.syncmx.Unlock()
+
 		log.Error("Unable to delete values to table: "+err.Error(), "spreadsheet_id", t.spreadsheetID, "index", index)
 
-		return errors.Wrap(err, "delete from table")
+		return errors.Wrap(googleError(err), "delete from table")
+	}
+
+	t.written(nil, []string{id})
+	t.syncmx.Unlock()
+
+	t.refresh()
+
+	return nil
+}
+
+// DeleteSecrets deletes the rows of the secrets in a single batch update. The
+// rows are looked up in the freshly read tables and deleted from the last one,
+// so the earlier rows stay in place within the batch.
+func (t *GoogleSheetsStorage) DeleteSecrets(ids []string) error {
+	secrets, err := t.fresh()
+	if err != nil {
+		return err
+	}
+
+	deleted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+
+	var (
+		requests []*sheets.Request
+		found    []string
+	)
+
+	for index := len(secrets) - 1; index >= 0; index-- {
+		if !deleted[secrets[index].StableID()] {
+			continue
+		}
+
+		found = append(found, secrets[index].StableID())
+
+		requests = append(requests, &sheets.Request{
+			DeleteDimension: &sheets.DeleteDimensionRequest{
+				Range: &sheets.DimensionRange{
+					Dimension:  "ROWS",
+					StartIndex: int64(index) + t.header,
+					EndIndex:   int64(index+1) + t.header,
+					SheetId:    t.secretsID,
+				},
+			},
+		})
+	}
+
+	if len(requests) == 0 {
+		return nil
+	}
+
+	t.syncmx.Lock()
+
+	_, err = t.service.Spreadsheets.BatchUpdate(t.spreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: requests,
+	}).Do()
+	if err != nil {
+		t.syncmx.Unlock()
+
+		log.Error("Unable to delete values to table: "+err.Error(), "spreadsheet_id", t.spreadsheetID, "count", len(requests))
+
+		return errors.Wrap(googleError(err), "delete from table")
 	}
 
+	t.written(nil, found)
+	t.syncmx.Unlock()
+
+	t.refresh()
+
 	return nil
 }
 
+// fresh reads the tables and returns the secrets as they are stored now.
+func (t *GoogleSheetsStorage) fresh() ([]SecretsData, error) {
+	if err := t.update(); err != nil {
+		return nil, errors.Wrap(err, "update tables")
+	}
+
+	return t.GetSecrets()
+}
+
 func (t *GoogleSheetsStorage) updateSecrets(data []*sheets.GridData) {
 	var newrows []SecretsData
 
 	for _, item := range data {
-		for _, row := range item.RowData {
-			if len(row.Values) < 3 {
+		for i, row := range item.RowData {
+			if item.StartRow+int64(i) < t.header || len(row.Values) < 3 {
 				continue
 			}
 
-			newrows = append(newrows, SecretsData{
+			secret := SecretsData{
 				Description: row.Values[0].FormattedValue,
 				Username:    row.Values[1].FormattedValue,
 				Secret:      row.Values[2].FormattedValue,
-			})
+			}
+
+			if len(row.Values) > 3 {
+				secret.Owner, _ = strconv.ParseInt(row.Values[3].FormattedValue, 10, 64)
+			}
+
+			if len(row.Values) > 4 {
+				secret.Type = row.Values[4].FormattedValue
+			}
+
+			if len(row.Values) > 5 {
+				secret.URL = row.Values[5].FormattedValue
+			}
+
+			if len(row.Values) > 6 {
+				secret.ID = row.Values[6].FormattedValue
+			}
+
+			if len(row.Values) > 7 {
+				secret.MAC = row.Values[7].FormattedValue
+			}
+
+			newrows = append(newrows, secret)
 		}
 	}
 
+	t.detectChanges(newrows)
 	t.setSecrets(newrows)
 }
 
+// OnExternalChange sets the function called with the secrets changed directly
+// in the spreadsheet since the last read.
+func (t *GoogleSheetsStorage) OnExternalChange(f func(ExternalChange)) {
+	t.syncmx.Lock()
+	t.onChange = f
+	t.syncmx.Unlock()
+}
+
+// written records the rows written and deleted by the bot, so the next read
+// doesn't report them. It's called with syncmx locked.
+func (t *GoogleSheetsStorage) written(data []SecretsData, deleted []string) {
+	if t.rows == nil {
+		return
+	}
+
+	for _, secret := range data {
+		t.rows[secret.StableID()] = rowContent(secret)
+	}
+
+	for _, id := range deleted {
+		delete(t.rows, id)
+	}
+}
+
+// detectChanges compares the rows read with the rows known to the bot, it's
+// called with syncmx locked. The first read is taken as is.
+func (t *GoogleSheetsStorage) detectChanges(secrets []SecretsData) {
+	rows := make(map[string]string, len(secrets))
+	for _, secret := range secrets {
+		rows[secret.StableID()] = rowContent(secret)
+	}
+
+	known := t.rows
+	t.rows = rows
+
+	if known == nil || t.onChange == nil {
+		return
+	}
+
+	var change ExternalChange
+
+	for id, content := range rows {
+		old, ok := known[id]
+
+		switch {
+		case !ok:
+			change.Added++
+		case old != content:
+			change.Modified++
+		}
+	}
+
+	for id := range known {
+		if _, ok := rows[id]; !ok {
+			change.Removed++
+		}
+	}
+
+	if change != (ExternalChange{}) {
+		t.onChange(change)
+	}
+}
+
+func rowContent(secret SecretsData) string {
+	return strings.Join([]string{
+		secret.Description, secret.Username, secret.Secret, formatOwner(secret.Owner), secret.Type, secret.URL, secret.MAC,
+	}, "\x00")
+}
+
+func formatOwner(owner int64) string {
+	if owner == 0 {
+		return ""
+	}
+
+	return strconv.FormatInt(owner, 10)
+}
+
 func (t *GoogleSheetsStorage) updateKey(data []*sheets.GridData) {
 	for _, item := range data {
 		if len(item.RowData) == 0 {
@@ -215,15 +804,30 @@ func (t *GoogleSheetsStorage) updateKey(data []*sheets.GridData) {
 }
 
 func (t *GoogleSheetsStorage) update() error {
+	t.mx.Lock()
+	t.status.Running = time.Now()
+	t.mx.Unlock()
+
+	t.syncmx.Lock()
+	defer t.syncmx.Unlock()
+
 	ss, err := t.service.Spreadsheets.Get(t.spreadsheetID).IncludeGridData(true).Do()
+	t.synced(err)
+
 	if err != nil {
-		return errors.Wrap(err, "get spreadsheet")
+		return errors.Wrap(googleError(err), "get spreadsheet")
 	}
 
 	for _, sheet := range ss.Sheets {
 		switch sheet.Properties.Title {
-		case secretsTitle:
+		case t.title:
 			t.secretsID = sheet.Properties.SheetId
+			t.header = 0
+
+			if sheet.Properties.GridProperties != nil {
+				t.header = sheet.Properties.GridProperties.FrozenRowCount
+			}
+
 			t.updateSecrets(sheet.Data)
 		case keysTitle:
 			t.keysID = sheet.Properties.SheetId
@@ -234,6 +838,39 @@ func (t *GoogleSheetsStorage) update() error {
 	return nil
 }
 
+// SetSharing manages the Drive permissions of the spreadsheet.
+func (t *GoogleSheetsStorage) SetSharing(sharing *DriveSharing) {
+	t.sharing = sharing
+}
+
+// CheckSharing returns the permissions of the spreadsheet beyond the allowed
+// accounts and the revoked ones.
+func (t *GoogleSheetsStorage) CheckSharing() (drift, revoked []string, err error) {
+	if t.sharing == nil {
+		return nil, nil, nil
+	}
+
+	return t.sharing.Check(t.spreadsheetID)
+}
+
+// Open does nothing, the tables are kept in the clear.
+func (t *GoogleSheetsStorage) Open(string) error {
+	return nil
+}
+
+// Seal does nothing, the tables are kept in the clear.
+func (t *GoogleSheetsStorage) Seal(string) error {
+	return nil
+}
+
+// refresh reads the tables right after a change, so the positions of the
+// secrets match the table without waiting for the next update.
+func (t *GoogleSheetsStorage) refresh() {
+	if err := t.update(); err != nil {
+		log.Error("Unable update tables: " + err.Error())
+	}
+}
+
 func (t *GoogleSheetsStorage) setSecrets(secrets []SecretsData) {
 	t.mx.Lock()
 	t.secrets = make([]SecretsData, len(secrets))
@@ -250,6 +887,30 @@ func (t *GoogleSheetsStorage) GetSecrets() (secrets []SecretsData, err error) {
 	return secrets, nil
 }
 
+// Secrets iterates the last read tables, the update replaces them instead of
+// changing them so the iteration doesn't hold the lock.
+func (t *GoogleSheetsStorage) Secrets(fn func(index int, secret SecretsData) bool) error {
+	t.mx.RLock()
+	secrets := t.secrets
+	t.mx.RUnlock()
+
+	for index, secret := range secrets {
+		if !fn(index, secret) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// QuerySecrets filters the last read tables without copying the other secrets.
+func (t *GoogleSheetsStorage) QuerySecrets(filter SecretsFilter, offset, limit int) ([]SecretsData, error) {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+
+	return filterSecrets(t.secrets, filter, offset, limit), nil
+}
+
 func (t *GoogleSheetsStorage) setKey(key string) {
 	t.mx.Lock()
 	t.key = key
@@ -14,16 +14,141 @@
 
 package providers
 
+import "strings"
+
 type SecretsData struct {
+	// ID is the short immutable reference of the secret in the commands, the
+	// position of the secret changes as the secrets are added and deleted.
+	ID          string `json:",omitempty"`
 	Description string
 	Username    string
 	Secret      string
+	// Owner is the chat ID that created the secret, zero for legacy secrets.
+	Owner int64 `json:",omitempty"`
+	// Type defines how the secret is rendered, empty for a regular password.
+	Type string `json:",omitempty"`
+	// URL is the site of a web credential, it is matched by the registrable
+	// domain.
+	URL string `json:",omitempty"`
+	// MAC authenticates the stored fields with a key derived from the private
+	// key, empty for the secrets stored before the MACs.
+	MAC string `json:",omitempty"`
+}
+
+const (
+	TypePassword = ""
+	TypeWiFi     = "wifi"
+	TypeCard     = "card"
+	TypeIdentity = "identity"
+	TypeSSHKey   = "ssh-key"
+	TypeToken    = "token"
+)
+
+// Tags returns the hashtags of the description without the leading "#".
+func (s SecretsData) Tags() []string {
+	var tags []string
+
+	for _, word := range strings.Fields(s.Description) {
+		if len(word) > 1 && strings.HasPrefix(word, "#") {
+			tags = append(tags, strings.ToLower(strings.TrimPrefix(word, "#")))
+		}
+	}
+
+	return tags
+}
+
+// HasTag reports whether the secret is tagged with the tag, with or without "#".
+func (s SecretsData) HasTag(tag string) bool {
+	tag = strings.ToLower(strings.TrimPrefix(tag, "#"))
+
+	for _, t := range s.Tags() {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SecretsFilter selects the secrets, the zero filter selects all of them.
+type SecretsFilter struct {
+	// Description is a lowercase part of the description.
+	Description string
+	// Tag is a tag of the secret without the "#".
+	Tag string
+	// Owner selects the secrets of the chat along with the secrets without
+	// owner if not zero.
+	Owner int64
+}
+
+// Match reports whether the secret passes the filter.
+func (f SecretsFilter) Match(secret SecretsData) bool {
+	if f.Owner != 0 && secret.Owner != 0 && secret.Owner != f.Owner {
+		return false
+	}
+
+	if f.Tag != "" && !secret.HasTag(f.Tag) {
+		return false
+	}
+
+	return strings.Contains(strings.ToLower(secret.Description), f.Description)
+}
+
+// filterSecrets returns at most the limit of the secrets matching the filter
+// after the offset ones, zero limit returns all of them.
+func filterSecrets(secrets []SecretsData, filter SecretsFilter, offset, limit int) []SecretsData {
+	var found []SecretsData
+
+	for _, secret := range secrets {
+		if !filter.Match(secret) {
+			continue
+		}
+
+		if offset > 0 {
+			offset--
+
+			continue
+		}
+
+		found = append(found, secret)
+
+		if limit > 0 && len(found) == limit {
+			break
+		}
+	}
+
+	return found
 }
 
 type StorageProvider interface {
 	AddSecret(SecretsData) error
+	// AddSecrets appends the secrets in a single call.
+	AddSecrets([]SecretsData) error
 	DeleteSecret(index int) error
+	// DeleteSecrets deletes the secrets with the IDs in a single call, the
+	// unknown IDs are ignored.
+	DeleteSecrets(ids []string) error
+	// UpdateSecret replaces the secret with the ID in place, so it keeps its
+	// position.
+	UpdateSecret(id string, data SecretsData) error
+	// UpdateSecrets replaces the secrets with the IDs of the data in place in
+	// a single call.
+	UpdateSecrets([]SecretsData) error
 	GetSecrets() ([]SecretsData, error)
+	// QuerySecrets returns at most the limit of the secrets matching the
+	// filter after the offset ones, zero limit returns all of them. A storage
+	// with a query language translates the filter into it, e.g. a WHERE clause.
+	QuerySecrets(filter SecretsFilter, offset, limit int) ([]SecretsData, error)
+	// Secrets calls the fn with the position and the data of every secret
+	// until it returns false, the secrets are not copied. The fn must not
+	// change the storage.
+	Secrets(fn func(index int, secret SecretsData) bool) error
 	SetKey(key string) error
 	GetKey() (string, error)
+	// Open unlocks the storage encrypted at rest with the master password, the
+	// storages kept in the clear ignore it.
+	Open(masterPass string) error
+	// Seal encrypts the storage at rest with the verified master password, it
+	// is called again when the password changes.
+	Seal(masterPass string) error
 }
@@ -0,0 +1,186 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Syncer is the storage which reads the remote tables in the background.
+type Syncer interface {
+	// Start syncs until the context is done or the storage is closed.
+	Start(ctx context.Context)
+	Close() error
+	SyncStatus() SyncStatus
+}
+
+// SyncStatus describes the background sync of the storage.
+type SyncStatus struct {
+	// LastSync is the time of the last successful sync.
+	LastSync time.Time
+	// Failures counts the failed syncs since the last successful one.
+	Failures  int
+	LastError string
+	// Running is the start of the sync in progress, zero if none. A sync
+	// waits for the writes to the tables, so a hung request keeps it old.
+	Running time.Time
+}
+
+// syncLoop is the background sync of a storage along with its status, the
+// interval is doubled up to maxInterval while the storage stays the same.
+type syncLoop struct {
+	status    SyncStatus
+	onFailure func(SyncStatus)
+	stop      context.CancelFunc
+	stopped   chan struct{}
+
+	interval    time.Duration
+	maxInterval time.Duration
+
+	mx sync.RWMutex
+}
+
+func newSyncLoop() syncLoop {
+	return syncLoop{interval: defaultSyncInterval, maxInterval: defaultMaxSyncInterval}
+}
+
+// SetSyncInterval sets the time between the syncs and the longest time the
+// syncs back off to while the storage isn't modified, zero keeps the default.
+// It's called before Start.
+func (l *syncLoop) SetSyncInterval(interval, maxInterval time.Duration) {
+	if interval > 0 {
+		l.interval = interval
+	}
+
+	if maxInterval > 0 {
+		l.maxInterval = maxInterval
+	}
+
+	if l.maxInterval < l.interval {
+		l.maxInterval = l.interval
+	}
+}
+
+// start calls the sync every interval until the context is done or the loop
+// is closed, the sync reports whether the storage was modified.
+func (l *syncLoop) start(ctx context.Context, sync func() (bool, error)) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if l.stop != nil {
+		return
+	}
+
+	ctx, l.stop = context.WithCancel(ctx)
+	l.stopped = make(chan struct{})
+
+	go func(stopped chan struct{}) {
+		defer close(stopped)
+
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		interval := l.interval
+
+		for {
+			jitter := time.Duration((random.Float64()*2 - 1) * syncJitter * float64(interval))
+			timer := time.NewTimer(interval + jitter)
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+
+				return
+			case <-timer.C:
+			}
+
+			l.running()
+
+			changed, err := sync()
+			l.synced(err)
+
+			switch {
+			case err != nil, changed:
+				interval = l.interval
+			case interval*2 > l.maxInterval:
+				interval = l.maxInterval
+			default:
+				interval *= 2
+			}
+		}
+	}(l.stopped)
+}
+
+// Close stops the background sync and waits for the running one.
+func (l *syncLoop) Close() error {
+	l.mx.Lock()
+	stop, stopped := l.stop, l.stopped
+	l.stop = nil
+	l.mx.Unlock()
+
+	if stop != nil {
+		stop()
+		<-stopped
+	}
+
+	return nil
+}
+
+// SyncStatus returns the state of the sync.
+func (l *syncLoop) SyncStatus() SyncStatus {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	return l.status
+}
+
+// OnSyncFailure sets the function called with the status after every failed
+// sync.
+func (l *syncLoop) OnSyncFailure(f func(SyncStatus)) {
+	l.mx.Lock()
+	l.onFailure = f
+	l.mx.Unlock()
+}
+
+func (l *syncLoop) running() {
+	l.mx.Lock()
+	l.status.Running = time.Now()
+	l.mx.Unlock()
+}
+
+// synced records the result of the sync.
+func (l *syncLoop) synced(err error) {
+	l.mx.Lock()
+	l.status.Running = time.Time{}
+
+	if err == nil {
+		l.status.LastSync = time.Now()
+		l.status.Failures = 0
+		l.status.LastError = ""
+		l.mx.Unlock()
+
+		return
+	}
+
+	l.status.Failures++
+	l.status.LastError = err.Error()
+	status, onFailure := l.status, l.onFailure
+	l.mx.Unlock()
+
+	if onFailure != nil {
+		onFailure(status)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// WebDAVFile is the file of a WebDAV server, e.g. Nextcloud or ownCloud, with
+// the basic authentication. The revision is the ETag of the file.
+type WebDAVFile struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+}
+
+func NewWebDAVFile(fileURL, username, password string) *WebDAVFile {
+	return &WebDAVFile{
+		url:      fileURL,
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: remoteTimeout},
+	}
+}
+
+func (f *WebDAVFile) String() string {
+	return f.url
+}
+
+func (f *WebDAVFile) Download(ctx context.Context, rev string) ([]byte, string, error) {
+	req, err := f.request(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if rev != "" {
+		req.Header.Set("If-None-Match", rev)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "get file")
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, rev, ErrNotModified
+	default:
+		return nil, "", webdavError(resp, "get file")
+	}
+
+	b, err := readRemote(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return b, resp.Header.Get("ETag"), nil
+}
+
+// Upload writes the file with the If-Match of the ETag, a file created
+// meanwhile is a conflict as well.
+func (f *WebDAVFile) Upload(ctx context.Context, data []byte, rev string) (string, error) {
+	req, err := f.request(ctx, http.MethodPut, data)
+	if err != nil {
+		return "", err
+	}
+
+	if rev != "" {
+		req.Header.Set("If-Match", rev)
+	} else {
+		req.Header.Set("If-None-Match", "*")
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "put file")
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated &&
+		resp.StatusCode != http.StatusNoContent {
+		return "", webdavError(resp, "put file")
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return etag, nil
+	}
+
+	// Not every server returns the ETag of the upload, it's asked separately.
+	req, err = f.request(ctx, http.MethodHead, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if resp, err = f.client.Do(req); err != nil {
+		return "", errors.Wrap(err, "head file")
+	}
+
+	resp.Body.Close()
+
+	return resp.Header.Get("ETag"), nil
+}
+
+func (f *WebDAVFile) request(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, f.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "new request")
+	}
+
+	if f.username != "" || f.password != "" {
+		req.SetBasicAuth(f.username, f.password)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// webdavError marks the error status of the server with its kind.
+func webdavError(resp *http.Response, action string) error {
+	err := errors.New(action + ": " + resp.Status)
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return kindError{kind: ErrNotFound, err: err}
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return kindError{kind: ErrConflict, err: err}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return kindError{kind: ErrUnauthorized, err: err}
+	case http.StatusTooManyRequests:
+		return kindError{kind: ErrQuotaExceeded, err: err}
+	}
+
+	return err
+}
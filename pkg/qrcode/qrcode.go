@@ -0,0 +1,529 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package qrcode is a minimal QR code encoder (ISO/IEC 18004) supporting
+// the byte mode with the medium error correction level, versions 1-10.
+package qrcode
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	quietZone = 4
+
+	penaltyN1 = 3
+	penaltyN2 = 3
+	penaltyN3 = 40
+	penaltyN4 = 10
+
+	formatMask     = 0x5412
+	formatGen      = 0x537
+	versionGen     = 0x1F25
+	eclMediumBits  = 0
+	byteModeBits   = 4
+	numbMasks      = 8
+	minVersionInfo = 7
+)
+
+var ErrTooLong = errors.New("data too long")
+
+// block groups of the medium error correction level by version.
+type versionInfo struct {
+	ecPerBlock int
+	groups     [][2]int // number of blocks, data codewords per block
+	alignment  []int
+}
+
+var versions = []versionInfo{
+	{10, [][2]int{{1, 16}}, nil},
+	{16, [][2]int{{1, 28}}, []int{6, 18}},
+	{26, [][2]int{{1, 44}}, []int{6, 22}},
+	{18, [][2]int{{2, 32}}, []int{6, 26}},
+	{24, [][2]int{{2, 43}}, []int{6, 30}},
+	{16, [][2]int{{4, 27}}, []int{6, 34}},
+	{18, [][2]int{{4, 31}}, []int{6, 22, 38}},
+	{22, [][2]int{{2, 38}, {2, 39}}, []int{6, 24, 42}},
+	{22, [][2]int{{3, 36}, {2, 37}}, []int{6, 26, 46}},
+	{26, [][2]int{{4, 43}, {1, 44}}, []int{6, 28, 50}},
+}
+
+func (v versionInfo) dataCodewords() int {
+	n := 0
+	for _, g := range v.groups {
+		n += g[0] * g[1]
+	}
+
+	return n
+}
+
+// Encode returns the modules of the QR code, true is a dark module.
+func Encode(data []byte) ([][]bool, error) {
+	version := 0
+
+	for i, v := range versions {
+		if byteModeBits+countBits(i+1)+len(data)*8 <= v.dataCodewords()*8 {
+			version = i + 1
+
+			break
+		}
+	}
+
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	info := versions[version-1]
+	codewords := interleave(info, encodeData(data, version, info.dataCodewords()))
+
+	q := newMatrix(version)
+	q.drawFunctionPatterns(info)
+	q.drawCodewords(codewords)
+
+	best, bestPenalty := 0, -1
+
+	for mask := 0; mask < numbMasks; mask++ {
+		q.applyMask(mask)
+		q.drawFormat(mask)
+
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+
+		q.applyMask(mask)
+	}
+
+	q.applyMask(best)
+	q.drawFormat(best)
+
+	return q.modules, nil
+}
+
+// PNG renders the QR code of the data with the scale in pixels per module.
+func PNG(data []byte, scale int) ([]byte, error) {
+	modules, err := Encode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	size := (len(modules) + 2*quietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, size, size))
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			r, c := y/scale-quietZone, x/scale-quietZone
+			dark := r >= 0 && c >= 0 && r < len(modules) && c < len(modules) && modules[r][c]
+
+			if dark {
+				img.SetGray(x, y, color.Gray{Y: 0})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err = png.Encode(buf, img); err != nil {
+		return nil, errors.Wrap(err, "encode png")
+	}
+
+	return buf.Bytes(), nil
+}
+
+func countBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+
+	return 16
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>uint(i))&1 == 1)
+	}
+}
+
+func encodeData(data []byte, version, capacity int) []byte {
+	var bits bitBuffer
+
+	bits.append(byteModeBits, 4)
+	bits.append(len(data), countBits(version))
+
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	terminator := capacity*8 - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+
+	out := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for j := 0; j < 8; j++ {
+			if bits[i+j] {
+				b |= 1 << uint(7-j)
+			}
+		}
+
+		out = append(out, b)
+	}
+
+	for pad := byte(0xEC); len(out) < capacity; pad ^= 0xEC ^ 0x11 {
+		out = append(out, pad)
+	}
+
+	return out
+}
+
+func interleave(info versionInfo, data []byte) []byte {
+	var blocks, ecBlocks [][]byte
+
+	offset, maxLen := 0, 0
+
+	for _, g := range info.groups {
+		for i := 0; i < g[0]; i++ {
+			block := data[offset : offset+g[1]]
+			offset += g[1]
+
+			blocks = append(blocks, block)
+			ecBlocks = append(ecBlocks, reedSolomon(block, info.ecPerBlock))
+
+			if len(block) > maxLen {
+				maxLen = len(block)
+			}
+		}
+	}
+
+	var out []byte
+
+	for i := 0; i < maxLen; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+
+	for i := 0; i < info.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			out = append(out, block[i])
+		}
+	}
+
+	return out
+}
+
+type matrix struct {
+	version  int
+	size     int
+	modules  [][]bool
+	reserved [][]bool
+}
+
+func newMatrix(version int) *matrix {
+	size := version*4 + 17
+	q := &matrix{version: version, size: size}
+
+	q.modules = make([][]bool, size)
+	q.reserved = make([][]bool, size)
+
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.reserved[i] = make([]bool, size)
+	}
+
+	return q
+}
+
+func (q *matrix) set(row, col int, dark bool) {
+	q.modules[row][col] = dark
+	q.reserved[row][col] = true
+}
+
+func (q *matrix) drawFunctionPatterns(info versionInfo) {
+	for i := 0; i < q.size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+
+	q.drawFinder(0, 0)
+	q.drawFinder(q.size-7, 0)
+	q.drawFinder(0, q.size-7)
+
+	last := len(info.alignment) - 1
+
+	for i, row := range info.alignment {
+		for j, col := range info.alignment {
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+
+			q.drawAlignment(row, col)
+		}
+	}
+
+	// Reserve the format areas, the bits are drawn after masking.
+	q.drawFormat(0)
+
+	if q.version >= minVersionInfo {
+		q.drawVersion()
+	}
+}
+
+func (q *matrix) drawFinder(row, col int) {
+	for dr := -1; dr <= 7; dr++ {
+		for dc := -1; dc <= 7; dc++ {
+			r, c := row+dr, col+dc
+			if r < 0 || c < 0 || r >= q.size || c >= q.size {
+				continue
+			}
+
+			inner := dr >= 0 && dr <= 6 && dc >= 0 && dc <= 6
+			ring := dr == 0 || dr == 6 || dc == 0 || dc == 6
+			center := dr >= 2 && dr <= 4 && dc >= 2 && dc <= 4
+
+			q.set(r, c, inner && (ring || center))
+		}
+	}
+}
+
+func (q *matrix) drawAlignment(row, col int) {
+	for dr := -2; dr <= 2; dr++ {
+		for dc := -2; dc <= 2; dc++ {
+			dist := abs(dr)
+			if abs(dc) > dist {
+				dist = abs(dc)
+			}
+
+			q.set(row+dr, col+dc, dist != 1)
+		}
+	}
+}
+
+func (q *matrix) drawFormat(mask int) {
+	data := eclMediumBits<<3 | mask
+	rem := data
+
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * formatGen)
+	}
+
+	bits := (data<<10 | rem) ^ formatMask
+
+	for i := 0; i <= 5; i++ {
+		q.set(i, 8, bit(bits, i))
+	}
+
+	q.set(7, 8, bit(bits, 6))
+	q.set(8, 8, bit(bits, 7))
+	q.set(8, 7, bit(bits, 8))
+
+	for i := 9; i < 15; i++ {
+		q.set(8, 14-i, bit(bits, i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.set(8, q.size-1-i, bit(bits, i))
+	}
+
+	for i := 8; i < 15; i++ {
+		q.set(q.size-15+i, 8, bit(bits, i))
+	}
+
+	q.set(q.size-8, 8, true)
+}
+
+func (q *matrix) drawVersion() {
+	rem := q.version
+
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * versionGen)
+	}
+
+	bits := q.version<<12 | rem
+
+	for i := 0; i < 18; i++ {
+		a, b := q.size-11+i%3, i/3
+
+		q.set(b, a, bit(bits, i))
+		q.set(a, b, bit(bits, i))
+	}
+}
+
+// drawCodewords places the bits in the zigzag order, the remainder bits are
+// left light.
+func (q *matrix) drawCodewords(codewords []byte) {
+	total := len(codewords) * 8
+	i := 0
+
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+
+		upward := (right+1)&2 == 0
+
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				col := right - j
+
+				row := vert
+				if upward {
+					row = q.size - 1 - vert
+				}
+
+				if q.reserved[row][col] || i >= total {
+					continue
+				}
+
+				q.modules[row][col] = (codewords[i/8]>>uint(7-i%8))&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+func (q *matrix) applyMask(mask int) {
+	for row := 0; row < q.size; row++ {
+		for col := 0; col < q.size; col++ {
+			if !q.reserved[row][col] && masked(mask, row, col) {
+				q.modules[row][col] = !q.modules[row][col]
+			}
+		}
+	}
+}
+
+func masked(mask, row, col int) bool {
+	switch mask {
+	case 0:
+		return (row+col)%2 == 0
+	case 1:
+		return row%2 == 0
+	case 2:
+		return col%3 == 0
+	case 3:
+		return (row+col)%3 == 0
+	case 4:
+		return (row/2+col/3)%2 == 0
+	case 5:
+		return row*col%2+row*col%3 == 0
+	case 6:
+		return (row*col%2+row*col%3)%2 == 0
+	default:
+		return ((row+col)%2+row*col%3)%2 == 0
+	}
+}
+
+func (q *matrix) penalty() int {
+	result, dark := 0, 0
+
+	finderLike := []bool{true, false, true, true, true, false, true, false, false, false, false}
+
+	for i := 0; i < q.size; i++ {
+		result += runsPenalty(q.size, func(j int) bool { return q.modules[i][j] })
+		result += runsPenalty(q.size, func(j int) bool { return q.modules[j][i] })
+
+		for j := 0; j+len(finderLike) <= q.size; j++ {
+			if matchesPattern(finderLike, func(k int) bool { return q.modules[i][j+k] }) {
+				result += penaltyN3
+			}
+
+			if matchesPattern(finderLike, func(k int) bool { return q.modules[j+k][i] }) {
+				result += penaltyN3
+			}
+		}
+	}
+
+	for row := 0; row < q.size; row++ {
+		for col := 0; col < q.size; col++ {
+			if q.modules[row][col] {
+				dark++
+			}
+
+			if row+1 < q.size && col+1 < q.size {
+				c := q.modules[row][col]
+				if c == q.modules[row+1][col] && c == q.modules[row][col+1] && c == q.modules[row+1][col+1] {
+					result += penaltyN2
+				}
+			}
+		}
+	}
+
+	total := q.size * q.size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+
+	return result + k*penaltyN4
+}
+
+func runsPenalty(size int, get func(int) bool) int {
+	result, run := 0, 1
+
+	for j := 1; j <= size; j++ {
+		if j < size && get(j) == get(j-1) {
+			run++
+
+			continue
+		}
+
+		if run >= 5 {
+			result += penaltyN1 + run - 5
+		}
+
+		run = 1
+	}
+
+	return result
+}
+
+// matchesPattern checks the pattern in both directions.
+func matchesPattern(pattern []bool, get func(int) bool) bool {
+	forward, backward := true, true
+
+	for k, dark := range pattern {
+		if get(k) != dark {
+			forward = false
+		}
+
+		if get(len(pattern)-1-k) != dark {
+			backward = false
+		}
+	}
+
+	return forward || backward
+}
+
+func bit(value, i int) bool {
+	return (value>>uint(i))&1 == 1
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qrcode
+
+const gfPoly = 0x11D
+
+var gfExp, gfLog = gfTables()
+
+func gfTables() (exp [512]byte, log [256]byte) {
+	x := 1
+
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = byte(i)
+
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= gfPoly
+		}
+	}
+
+	for i := 255; i < len(exp); i++ {
+		exp[i] = exp[i-255]
+	}
+
+	return exp, log
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// generator returns the monic generator polynomial of the degree, highest
+// degree coefficient first.
+func generator(degree int) []byte {
+	gen := []byte{1}
+
+	for i := 0; i < degree; i++ {
+		next := append(make([]byte, 0, len(gen)+1), gen...)
+		next = append(next, 0)
+
+		for j := 1; j < len(next); j++ {
+			next[j] ^= gfMul(gen[j-1], gfExp[i])
+		}
+
+		gen = next
+	}
+
+	return gen
+}
+
+// reedSolomon returns the error correction codewords of the data.
+func reedSolomon(data []byte, degree int) []byte {
+	gen := generator(degree)
+
+	res := make([]byte, len(data)+degree)
+	copy(res, data)
+
+	for i := range data {
+		coef := res[i]
+		if coef == 0 {
+			continue
+		}
+
+		for j := 1; j < len(gen); j++ {
+			res[i+j] ^= gfMul(gen[j], coef)
+		}
+	}
+
+	return res[len(data):]
+}
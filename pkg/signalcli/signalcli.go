@@ -0,0 +1,575 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package signalcli is the chat transport of a Signal account driven by the
+// JSON-RPC of "signal-cli daemon --tcp". Signal has no inline buttons, so the
+// buttons of a message are listed as numbered choices and pressed by answering
+// the number.
+package signalcli
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"secretable/pkg/chat"
+	"secretable/pkg/log"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	callTimeout  = 30 * time.Second
+	pingInterval = 30 * time.Second
+	maxLine      = 16 << 20
+
+	reconnectDelay    = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+var ErrNotConnected = errors.New("signal-cli is not connected")
+
+// Transport sends and routes the messages of the Signal account.
+type Transport struct {
+	addr string
+
+	// chats maps the phone numbers and the UUIDs to the chat IDs, recipients
+	// maps them back.
+	chats      map[string]int64
+	recipients map[int64]string
+
+	// OnAlive is called on every line read from signal-cli, the version is
+	// asked regularly so an idle connection is reported too.
+	OnAlive func()
+
+	commands map[string]func(*chat.Message)
+	buttons  map[string]func(*chat.Callback)
+	routesmx sync.RWMutex
+
+	conn   net.Conn
+	closed chan struct{}
+	connmx sync.Mutex
+
+	lastID int64
+	calls  sync.Map
+
+	// choices keeps the buttons of the last message of the chat, sent keeps
+	// the chats of the messages sent by the bot, only they can be deleted.
+	choices sync.Map
+	sent    sync.Map
+}
+
+// choices are the buttons of a message by their numbers counted from one.
+type choices struct {
+	message *chat.Message
+	buttons []chat.Button
+}
+
+// New creates the transport of the daemon listening on the address, the users
+// map the phone numbers or the UUIDs of the Signal users to their chat IDs.
+func New(addr string, users map[string]int64) *Transport {
+	t := &Transport{
+		addr:       addr,
+		chats:      make(map[string]int64, len(users)),
+		recipients: make(map[int64]string, len(users)),
+		commands:   make(map[string]func(*chat.Message)),
+		buttons:    make(map[string]func(*chat.Callback)),
+	}
+
+	for user, chatID := range users {
+		t.chats[user] = chatID
+
+		if current, ok := t.recipients[chatID]; !ok || user < current {
+			t.recipients[chatID] = user
+		}
+	}
+
+	return t
+}
+
+func (t *Transport) SendMessage(chatID int64, text string, opts chat.Options) (*chat.Message, error) {
+	text, buttons := chat.WithChoices(text, opts.Buttons)
+
+	msg, err := t.send(chatID, text, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	t.setChoices(msg, buttons)
+
+	return msg, nil
+}
+
+func (t *Transport) SendFile(chatID int64, file chat.File, opts chat.Options) (*chat.Message, error) {
+	contentType := "application/octet-stream"
+
+	switch {
+	case file.Photo:
+		contentType = "image/png"
+	case mime.TypeByExtension(filepath.Ext(file.Name)) != "":
+		contentType = mime.TypeByExtension(filepath.Ext(file.Name))
+	}
+
+	name := file.Name
+	if name == "" {
+		name = "file"
+	}
+
+	attachment := "data:" + contentType + ";filename=" + name + ";base64," + base64.StdEncoding.EncodeToString(file.Data)
+
+	return t.send(chatID, file.Caption, []string{attachment}, 0)
+}
+
+func (t *Transport) EditMessage(chatID int64, messageID int, text string, opts chat.Options) error {
+	text, buttons := chat.WithChoices(text, opts.Buttons)
+
+	msg, err := t.send(chatID, text, nil, messageID)
+	if err != nil {
+		return err
+	}
+
+	if buttons != nil {
+		t.setChoices(msg, buttons)
+	} else if c, ok := t.choices.Load(chatID); ok && c.(choices).message.ID == messageID {
+		t.choices.Delete(chatID)
+	}
+
+	return nil
+}
+
+// DeleteMessage deletes the message for everyone, the messages of the users
+// can't be deleted in Signal and are kept.
+func (t *Transport) DeleteMessage(chatID int64, messageID int) error {
+	if _, ok := t.sent.Load(messageID); !ok {
+		return nil
+	}
+
+	recipient, err := t.recipient(chatID)
+	if err != nil {
+		return err
+	}
+
+	err = t.call("remoteDelete", map[string]interface{}{
+		"recipient":       []string{recipient},
+		"targetTimestamp": messageID,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	t.sent.Delete(messageID)
+
+	if c, ok := t.choices.Load(chatID); ok && c.(choices).message.ID == messageID {
+		t.choices.Delete(chatID)
+	}
+
+	return nil
+}
+
+// RespondCallback does nothing, the choices are answered with a message.
+func (t *Transport) RespondCallback(*chat.Callback) error {
+	return nil
+}
+
+func (t *Transport) RegisterCommand(endpoint string, handler func(*chat.Message)) {
+	t.routesmx.Lock()
+	defer t.routesmx.Unlock()
+
+	t.commands[endpoint] = handler
+}
+
+func (t *Transport) RegisterButton(unique string, handler func(*chat.Callback)) {
+	t.routesmx.Lock()
+	defer t.routesmx.Unlock()
+
+	t.buttons[unique] = handler
+}
+
+// SetCommands does nothing, Signal has no command menu.
+func (t *Transport) SetCommands(string, []chat.Command) error {
+	return nil
+}
+
+// Token is empty, the Web App is served only by Telegram.
+func (t *Transport) Token() string {
+	return ""
+}
+
+// Run receives the messages until the context is done, the connection is
+// opened again when it breaks.
+func (t *Transport) Run(ctx context.Context) {
+	delay := reconnectDelay
+
+	for {
+		err := t.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Error("signal-cli connection: "+err.Error(), "address", t.addr)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// rpcRequest is a request of the JSON-RPC, the messages are sent and read one
+// per line.
+type rpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int64       `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// rpcMessage is a response or a notification of the JSON-RPC.
+type rpcMessage struct {
+	ID     *int64          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// receiveParams are the params of the notification of a received message.
+type receiveParams struct {
+	Envelope struct {
+		Source       string `json:"source"`
+		SourceNumber string `json:"sourceNumber"`
+		SourceUUID   string `json:"sourceUuid"`
+		SourceName   string `json:"sourceName"`
+		DataMessage  *struct {
+			Timestamp int64           `json:"timestamp"`
+			Message   string          `json:"message"`
+			GroupInfo json.RawMessage `json:"groupInfo"`
+		} `json:"dataMessage"`
+	} `json:"envelope"`
+}
+
+// serve reads one connection until it breaks or the context is done.
+func (t *Transport) serve(ctx context.Context) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return errors.Wrap(err, "dial")
+	}
+
+	closed := make(chan struct{})
+
+	t.connmx.Lock()
+	t.conn, t.closed = conn, closed
+	t.connmx.Unlock()
+
+	defer func() {
+		t.connmx.Lock()
+		t.conn = nil
+		t.connmx.Unlock()
+
+		close(closed)
+		conn.Close()
+	}()
+
+	go t.ping(ctx, conn, closed)
+
+	log.Info("💬 Connected to signal-cli " + t.addr)
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64<<10), maxLine)
+
+	for scanner.Scan() {
+		if t.OnAlive != nil {
+			t.OnAlive()
+		}
+
+		var msg rpcMessage
+		if err = json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			log.Error("Skip broken signal-cli line: " + err.Error())
+
+			continue
+		}
+
+		switch {
+		case msg.Method == "receive":
+			var params receiveParams
+			if err = json.Unmarshal(msg.Params, &params); err != nil {
+				log.Error("Decode received Signal message: " + err.Error())
+
+				continue
+			}
+
+			go t.receive(params)
+		case msg.ID != nil:
+			if reply, ok := t.calls.LoadAndDelete(*msg.ID); ok {
+				reply.(chan rpcMessage) <- msg
+			}
+		}
+	}
+
+	if err = scanner.Err(); err == nil {
+		err = errors.New("closed by signal-cli")
+	}
+
+	return err
+}
+
+// ping asks the version of signal-cli, so the connection is checked and
+// reported alive while no messages arrive.
+func (t *Transport) ping(ctx context.Context, conn net.Conn, closed chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+
+			return
+		case <-closed:
+			return
+		case <-ticker.C:
+			if err := t.call("version", nil, nil); err != nil {
+				log.Error("Ping signal-cli: " + err.Error())
+				conn.Close()
+
+				return
+			}
+		}
+	}
+}
+
+// call sends the request and decodes the result of its response into the
+// result if it isn't nil.
+func (t *Transport) call(method string, params, result interface{}) error {
+	id := atomic.AddInt64(&t.lastID, 1)
+	reply := make(chan rpcMessage, 1)
+
+	data, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	if err != nil {
+		return errors.Wrap(err, "encode request")
+	}
+
+	t.connmx.Lock()
+	conn, closed := t.conn, t.closed
+
+	if conn == nil {
+		t.connmx.Unlock()
+
+		return ErrNotConnected
+	}
+
+	t.calls.Store(id, reply)
+
+	_ = conn.SetWriteDeadline(time.Now().Add(callTimeout))
+	_, err = conn.Write(append(data, '\n'))
+	t.connmx.Unlock()
+
+	if err != nil {
+		t.calls.Delete(id)
+
+		return errors.Wrap(err, "write request")
+	}
+
+	var resp rpcMessage
+
+	select {
+	case resp = <-reply:
+	case <-closed:
+		t.calls.Delete(id)
+
+		return ErrNotConnected
+	case <-time.After(callTimeout):
+		t.calls.Delete(id)
+
+		return errors.New("no response to " + method)
+	}
+
+	if resp.Error != nil {
+		return errors.New(method + ": " + resp.Error.Message)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return errors.Wrap(json.Unmarshal(resp.Result, result), "decode "+method)
+}
+
+// send sends the text with the attachments, or edits the sent message if the
+// edited ID isn't zero. The ID of a message is its timestamp.
+func (t *Transport) send(chatID int64, text string, attachments []string, edited int) (*chat.Message, error) {
+	recipient, err := t.recipient(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	text, styles := plain(text)
+
+	params := map[string]interface{}{
+		"recipient": []string{recipient},
+		"message":   text,
+	}
+
+	if len(styles) > 0 {
+		params["textStyle"] = styles
+	}
+
+	if len(attachments) > 0 {
+		params["attachments"] = attachments
+	}
+
+	if edited != 0 {
+		params["editTimestamp"] = edited
+	}
+
+	var result struct {
+		Timestamp int64 `json:"timestamp"`
+	}
+
+	if err = t.call("send", params, &result); err != nil {
+		return nil, err
+	}
+
+	id := int(result.Timestamp)
+	if edited != 0 {
+		id = edited
+	}
+
+	t.sent.Store(id, chatID)
+
+	return &chat.Message{
+		ID:     id,
+		Chat:   &chat.Chat{ID: chatID},
+		Sender: &chat.User{ID: chatID},
+		Text:   text,
+	}, nil
+}
+
+func (t *Transport) recipient(chatID int64) (string, error) {
+	recipient, ok := t.recipients[chatID]
+	if !ok {
+		return "", errors.New("no Signal user of the chat " + strconv.FormatInt(chatID, 10))
+	}
+
+	return recipient, nil
+}
+
+// receive routes the direct message of a known user, the messages of the
+// groups and of the unknown users are dropped.
+func (t *Transport) receive(params receiveParams) {
+	env := params.Envelope
+	if env.DataMessage == nil || env.DataMessage.Message == "" || len(env.DataMessage.GroupInfo) > 0 {
+		return
+	}
+
+	var (
+		chatID int64
+		known  bool
+	)
+
+	for _, source := range []string{env.SourceNumber, env.SourceUUID, env.Source} {
+		if id, ok := t.chats[source]; ok && source != "" {
+			chatID, known = id, true
+
+			break
+		}
+	}
+
+	if !known {
+		log.Info("🚫 Drop the Signal message of an unknown user " + env.Source)
+
+		return
+	}
+
+	msg := &chat.Message{
+		ID:     int(env.DataMessage.Timestamp),
+		Chat:   &chat.Chat{ID: chatID, FirstName: env.SourceName},
+		Sender: &chat.User{ID: chatID, FirstName: env.SourceName},
+		Text:   env.DataMessage.Message,
+	}
+
+	t.route(msg)
+}
+
+// route passes the answer of a choice to the handler of its button, a
+// command to its handler and other texts to the OnText handler. An unknown
+// command is passed as a text.
+func (t *Transport) route(msg *chat.Message) {
+	if c, ok := t.choices.Load(msg.Chat.ID); ok {
+		n, err := strconv.Atoi(strings.TrimSpace(msg.Text))
+		if buttons := c.(choices).buttons; err == nil && n >= 1 && n <= len(buttons) {
+			t.choices.Delete(msg.Chat.ID)
+
+			if handler := t.button(buttons[n-1].Unique); handler != nil {
+				handler(&chat.Callback{Data: buttons[n-1].Data, Sender: msg.Sender, Message: c.(choices).message})
+			}
+
+			return
+		}
+	}
+
+	if handler := t.command(msg.Text); handler != nil {
+		handler(msg)
+	}
+}
+
+func (t *Transport) command(text string) func(*chat.Message) {
+	t.routesmx.RLock()
+	defer t.routesmx.RUnlock()
+
+	if fields := strings.Fields(text); len(fields) > 0 && strings.HasPrefix(fields[0], "/") {
+		if handler, ok := t.commands[fields[0]]; ok {
+			return handler
+		}
+	}
+
+	return t.commands[chat.OnText]
+}
+
+func (t *Transport) button(unique string) func(*chat.Callback) {
+	t.routesmx.RLock()
+	defer t.routesmx.RUnlock()
+
+	return t.buttons[unique]
+}
+
+// setChoices replaces the choices of the chat with the buttons of the message.
+func (t *Transport) setChoices(msg *chat.Message, buttons []chat.Button) {
+	if len(buttons) == 0 {
+		t.choices.Delete(msg.Chat.ID)
+
+		return
+	}
+
+	t.choices.Store(msg.Chat.ID, choices{message: msg, buttons: buttons})
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signalcli
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// tagRx matches the tags of the Telegram HTML used by the handlers.
+var tagRx = regexp.MustCompile(`<(/?)(b|strong|i|em|u|ins|s|strike|del|code|pre|a|tg-spoiler)(\s[^>]*)?>`)
+
+var hrefRx = regexp.MustCompile(`href="([^"]*)"`)
+
+var styles = map[string]string{
+	"b":          "BOLD",
+	"strong":     "BOLD",
+	"i":          "ITALIC",
+	"em":         "ITALIC",
+	"s":          "STRIKETHROUGH",
+	"strike":     "STRIKETHROUGH",
+	"del":        "STRIKETHROUGH",
+	"code":       "MONOSPACE",
+	"pre":        "MONOSPACE",
+	"tg-spoiler": "SPOILER",
+}
+
+// plain converts the HTML of the handlers to the text and its styles of
+// signal-cli, "start:length:STYLE" counted in UTF-16 units. A link is kept as
+// its text followed by the URL.
+func plain(text string) (string, []string) {
+	var (
+		b      strings.Builder
+		ranges []string
+		pos    int
+		last   int
+		starts = make(map[string][]int)
+		hrefs  []string
+	)
+
+	write := func(s string) {
+		b.WriteString(s)
+		pos += len(utf16.Encode([]rune(s)))
+	}
+
+	for _, m := range tagRx.FindAllStringSubmatchIndex(text, -1) {
+		write(html.UnescapeString(text[last:m[0]]))
+		last = m[1]
+
+		closing, name := m[3] > m[2], text[m[4]:m[5]]
+
+		switch {
+		case name == "a" && !closing:
+			href := ""
+			if m[6] >= 0 {
+				if sm := hrefRx.FindStringSubmatch(text[m[6]:m[7]]); sm != nil {
+					href = html.UnescapeString(sm[1])
+				}
+			}
+
+			hrefs = append(hrefs, href)
+			starts[name] = append(starts[name], b.Len())
+		case name == "a":
+			if len(hrefs) == 0 {
+				continue
+			}
+
+			href, start := hrefs[len(hrefs)-1], starts[name][len(starts[name])-1]
+			hrefs, starts[name] = hrefs[:len(hrefs)-1], starts[name][:len(starts[name])-1]
+
+			if href != "" && b.String()[start:] != href {
+				write(" (" + href + ")")
+			}
+		case styles[name] == "":
+		case !closing:
+			starts[name] = append(starts[name], pos)
+		case len(starts[name]) > 0:
+			start := starts[name][len(starts[name])-1]
+			starts[name] = starts[name][:len(starts[name])-1]
+
+			if pos > start {
+				ranges = append(ranges, fmt.Sprintf("%d:%d:%s", start, pos-start, styles[name]))
+			}
+		}
+	}
+
+	write(html.UnescapeString(text[last:]))
+
+	return b.String(), ranges
+}
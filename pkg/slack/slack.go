@@ -0,0 +1,313 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package slack is a minimal client of a Slack app in Socket Mode: the slash
+// commands and the interactions arrive over a WebSocket opened with the app
+// token, the Web API is called with the bot token.
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"secretable/pkg/log"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	apiURL      = "https://slack.com/api/"
+	origin      = "https://slack.com"
+	httpTimeout = 10 * time.Second
+
+	reconnectDelay    = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// Command is the payload of a slash command.
+type Command struct {
+	Command     string `json:"command"`
+	Text        string `json:"text"`
+	UserID      string `json:"user_id"`
+	ChannelID   string `json:"channel_id"`
+	TriggerID   string `json:"trigger_id"`
+	ResponseURL string `json:"response_url"`
+}
+
+// Interaction is the payload of a submitted modal.
+type Interaction struct {
+	Type string `json:"type"`
+	User struct {
+		ID string `json:"id"`
+	} `json:"user"`
+	View View `json:"view"`
+}
+
+// View is a modal, the state keeps the values of its inputs by the block and
+// the action IDs.
+type View struct {
+	CallbackID      string `json:"callback_id"`
+	PrivateMetadata string `json:"private_metadata"`
+	State           struct {
+		Values map[string]map[string]struct {
+			Value string `json:"value"`
+		} `json:"values"`
+	} `json:"state"`
+}
+
+// Value returns the value of the input of the block.
+func (v View) Value(blockID string) string {
+	for _, action := range v.State.Values[blockID] {
+		return action.Value
+	}
+
+	return ""
+}
+
+// Client receives the events of the app and calls the Web API.
+type Client struct {
+	appToken string
+	botToken string
+	http     *http.Client
+
+	// OnCommand handles the slash command, the returned payload is sent
+	// along with the acknowledgement, nil sends none.
+	OnCommand func(Command) interface{}
+	// OnInteraction handles the submitted modal, the returned payload is
+	// the response action, nil closes the modal.
+	OnInteraction func(Interaction) interface{}
+}
+
+func New(appToken, botToken string) *Client {
+	return &Client{
+		appToken: appToken,
+		botToken: botToken,
+		http:     &http.Client{Timeout: httpTimeout},
+	}
+}
+
+// envelope is a message of the Socket Mode connection.
+type envelope struct {
+	EnvelopeID string          `json:"envelope_id"`
+	Type       string          `json:"type"`
+	Reason     string          `json:"reason"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+type ack struct {
+	EnvelopeID string      `json:"envelope_id"`
+	Payload    interface{} `json:"payload,omitempty"`
+}
+
+// Run receives the events until the context is done, the connection is opened
+// again when Slack closes it.
+func (c *Client) Run(ctx context.Context) {
+	delay := reconnectDelay
+
+	for {
+		err := c.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			log.Error("Slack connection: " + err.Error())
+		} else {
+			delay = reconnectDelay
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// serve handles the events of one connection, nil is returned if Slack asks
+// to reconnect.
+func (c *Client) serve(ctx context.Context) error {
+	var opened struct {
+		URL string `json:"url"`
+	}
+
+	if err := c.call(ctx, c.appToken, "apps.connections.open", nil, &opened); err != nil {
+		return errors.Wrap(err, "open connection")
+	}
+
+	conn, err := websocket.Dial(opened.URL, "", origin)
+	if err != nil {
+		return errors.Wrap(err, "dial")
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+
+		conn.Close()
+	}()
+
+	for {
+		var env envelope
+		if err = websocket.JSON.Receive(conn, &env); err != nil {
+			return errors.Wrap(err, "receive")
+		}
+
+		switch env.Type {
+		case "hello":
+			log.Info("💬 Connected to Slack")
+		case "disconnect":
+			log.Info("💬 Slack asked to reconnect: " + env.Reason)
+
+			return nil
+		case "slash_commands", "interactive":
+			reply := ack{EnvelopeID: env.EnvelopeID, Payload: c.dispatch(env)}
+			if err = websocket.JSON.Send(conn, reply); err != nil {
+				return errors.Wrap(err, "acknowledge")
+			}
+		default:
+			if env.EnvelopeID != "" {
+				if err = websocket.JSON.Send(conn, ack{EnvelopeID: env.EnvelopeID}); err != nil {
+					return errors.Wrap(err, "acknowledge")
+				}
+			}
+		}
+	}
+}
+
+func (c *Client) dispatch(env envelope) interface{} {
+	if env.Type == "slash_commands" {
+		var cmd Command
+		if err := json.Unmarshal(env.Payload, &cmd); err != nil || c.OnCommand == nil {
+			return nil
+		}
+
+		return c.OnCommand(cmd)
+	}
+
+	var interaction Interaction
+	if err := json.Unmarshal(env.Payload, &interaction); err != nil || c.OnInteraction == nil {
+		return nil
+	}
+
+	if interaction.Type != "view_submission" {
+		return nil
+	}
+
+	return c.OnInteraction(interaction)
+}
+
+// OpenView opens the modal in response to the trigger of a slash command.
+func (c *Client) OpenView(ctx context.Context, triggerID string, view interface{}) error {
+	return c.call(ctx, c.botToken, "views.open", map[string]interface{}{
+		"trigger_id": triggerID,
+		"view":       view,
+	}, nil)
+}
+
+// PostEphemeral sends the message only the user sees in the channel.
+func (c *Client) PostEphemeral(ctx context.Context, channelID, userID, text string) error {
+	return c.call(ctx, c.botToken, "chat.postEphemeral", map[string]interface{}{
+		"channel": channelID,
+		"user":    userID,
+		"text":    text,
+		"mrkdwn":  false,
+	}, nil)
+}
+
+// Respond posts the payload to the response URL of a slash command, e.g. a
+// late response or {"delete_original": true}.
+func (c *Client) Respond(ctx context.Context, responseURL string, payload interface{}) error {
+	b, _ := json.Marshal(payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, responseURL, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send request")
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("responded " + resp.Status)
+	}
+
+	return nil
+}
+
+// call calls the Web API method, the result is decoded into the response.
+func (c *Client) call(ctx context.Context, token, method string, body, response interface{}) error {
+	var b []byte
+	if body != nil {
+		b, _ = json.Marshal(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+method, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return errors.Wrap(err, method)
+	}
+
+	defer resp.Body.Close()
+
+	raw := new(bytes.Buffer)
+	if _, err = raw.ReadFrom(resp.Body); err != nil {
+		return errors.Wrap(err, method)
+	}
+
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+
+	if err = json.Unmarshal(raw.Bytes(), &result); err != nil {
+		return errors.Wrap(err, method+": decode response")
+	}
+
+	if !result.OK {
+		return errors.New(method + ": " + result.Error)
+	}
+
+	if response == nil {
+		return nil
+	}
+
+	return errors.Wrap(json.Unmarshal(raw.Bytes(), response), method+": decode response")
+}
@@ -0,0 +1,254 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package telegram is the chat transport of a Telegram bot.
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"secretable/pkg/chat"
+
+	"github.com/pkg/errors"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Transport sends and routes the messages of the bot.
+type Transport struct {
+	Bot *tb.Bot
+}
+
+func New(bot *tb.Bot) *Transport {
+	return &Transport{Bot: bot}
+}
+
+func (t *Transport) SendMessage(chatID int64, text string, opts chat.Options) (*chat.Message, error) {
+	if hasWebApp(opts.Buttons) {
+		return t.sendRaw(chatID, text, opts)
+	}
+
+	resp, err := t.Bot.Send(tb.ChatID(chatID), text, options(opts, true)...)
+
+	return message(resp), err
+}
+
+func (t *Transport) SendFile(chatID int64, file chat.File, opts chat.Options) (*chat.Message, error) {
+	var what interface{} = &tb.Document{
+		File:     tb.FromReader(bytes.NewReader(file.Data)),
+		FileName: file.Name,
+		Caption:  file.Caption,
+	}
+
+	if file.Photo {
+		what = &tb.Photo{
+			File:    tb.FromReader(bytes.NewReader(file.Data)),
+			Caption: file.Caption,
+		}
+	}
+
+	resp, err := t.Bot.Send(tb.ChatID(chatID), what, options(opts, file.Caption != "")...)
+
+	return message(resp), err
+}
+
+func (t *Transport) EditMessage(chatID int64, messageID int, text string, opts chat.Options) error {
+	_, err := t.Bot.Edit(stored(chatID, messageID), text, options(opts, true)...)
+
+	return err
+}
+
+func (t *Transport) DeleteMessage(chatID int64, messageID int) error {
+	return t.Bot.Delete(stored(chatID, messageID))
+}
+
+func (t *Transport) RespondCallback(c *chat.Callback) error {
+	return t.Bot.Respond(&tb.Callback{ID: c.ID})
+}
+
+func (t *Transport) RegisterCommand(endpoint string, handler func(*chat.Message)) {
+	t.Bot.Handle(endpoint, func(m *tb.Message) {
+		handler(message(m))
+	})
+}
+
+func (t *Transport) RegisterButton(unique string, handler func(*chat.Callback)) {
+	t.Bot.Handle(&tb.InlineButton{Unique: unique}, func(c *tb.Callback) {
+		handler(&chat.Callback{
+			ID:      c.ID,
+			Data:    c.Data,
+			Sender:  user(c.Sender),
+			Message: message(c.Message),
+		})
+	})
+}
+
+func (t *Transport) SetCommands(locale string, cmds []chat.Command) error {
+	tbCmds := make([]tb.Command, len(cmds))
+	for i, cmd := range cmds {
+		tbCmds[i] = tb.Command{Text: cmd.Text, Description: cmd.Description}
+	}
+
+	if locale == "" {
+		return t.Bot.SetCommands(tbCmds)
+	}
+
+	data, _ := json.Marshal(tbCmds)
+
+	_, err := t.Bot.Raw("setMyCommands", map[string]string{
+		"commands":      string(data),
+		"language_code": locale,
+	})
+
+	return err
+}
+
+func (t *Transport) Token() string {
+	return t.Bot.Token
+}
+
+// IsMember reports whether the user is in the group, the restricted members
+// are still members.
+func (t *Transport) IsMember(groupID, userID int64) (bool, error) {
+	member, err := t.Bot.ChatMemberOf(&tb.Chat{ID: groupID}, &tb.User{ID: int(userID)})
+	if err != nil {
+		return false, errors.Wrap(err, "get chat member")
+	}
+
+	switch member.Role {
+	case tb.Creator, tb.Administrator, tb.Member, tb.Restricted:
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// sendRaw sends the message with the Web App buttons, telebot v2 has no such
+// buttons, so the markup is sent as is.
+func (t *Transport) sendRaw(chatID int64, text string, opts chat.Options) (*chat.Message, error) {
+	keyboard := make([][]map[string]interface{}, len(opts.Buttons))
+
+	for i, row := range opts.Buttons {
+		for _, btn := range row {
+			button := map[string]interface{}{"text": btn.Text}
+			if btn.WebAppURL != "" {
+				button["web_app"] = map[string]string{"url": btn.WebAppURL}
+			} else {
+				button["callback_data"] = "\f" + btn.Unique + "|" + btn.Data
+			}
+
+			keyboard[i] = append(keyboard[i], button)
+		}
+	}
+
+	data, err := t.Bot.Raw("sendMessage", map[string]interface{}{
+		"chat_id":              chatID,
+		"text":                 text,
+		"parse_mode":           tb.ModeHTML,
+		"disable_notification": !opts.Notify,
+		"reply_markup":         map[string]interface{}{"inline_keyboard": keyboard},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Result *tb.Message `json:"result"`
+	}
+
+	if err = json.Unmarshal(data, &resp); err != nil {
+		return nil, errors.Wrap(err, "decode response")
+	}
+
+	return message(resp.Result), nil
+}
+
+func hasWebApp(rows [][]chat.Button) bool {
+	for _, row := range rows {
+		for _, btn := range row {
+			if btn.WebAppURL != "" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func options(opts chat.Options, html bool) []interface{} {
+	var sendOpts []interface{}
+
+	if !opts.Notify {
+		sendOpts = append(sendOpts, tb.Silent)
+	}
+
+	if html {
+		sendOpts = append(sendOpts, tb.ModeHTML)
+	}
+
+	switch {
+	case opts.ForceReply:
+		sendOpts = append(sendOpts, &tb.ReplyMarkup{ForceReply: true})
+	case len(opts.Buttons) > 0:
+		keyboard := make([][]tb.InlineButton, len(opts.Buttons))
+
+		for i, row := range opts.Buttons {
+			for _, btn := range row {
+				keyboard[i] = append(keyboard[i], tb.InlineButton{Unique: btn.Unique, Text: btn.Text, Data: btn.Data})
+			}
+		}
+
+		sendOpts = append(sendOpts, &tb.ReplyMarkup{InlineKeyboard: keyboard})
+	}
+
+	return sendOpts
+}
+
+func stored(chatID int64, messageID int) tb.StoredMessage {
+	return tb.StoredMessage{MessageID: strconv.Itoa(messageID), ChatID: chatID}
+}
+
+func message(m *tb.Message) *chat.Message {
+	if m == nil {
+		return nil
+	}
+
+	msg := &chat.Message{ID: m.ID, Sender: user(m.Sender), Text: m.Text}
+
+	if m.Chat != nil {
+		msg.Chat = &chat.Chat{
+			ID:        m.Chat.ID,
+			Username:  m.Chat.Username,
+			FirstName: m.Chat.FirstName,
+			LastName:  m.Chat.LastName,
+		}
+	}
+
+	return msg
+}
+
+func user(u *tb.User) *chat.User {
+	if u == nil {
+		return nil
+	}
+
+	return &chat.User{
+		ID:           int64(u.ID),
+		Username:     u.Username,
+		FirstName:    u.FirstName,
+		LastName:     u.LastName,
+		LanguageCode: u.LanguageCode,
+	}
+}
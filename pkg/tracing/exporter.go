@@ -0,0 +1,228 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"secretable/pkg/log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	tracesPath     = "/v1/traces"
+	batchSize      = 256
+	queueSize      = 2048
+	flushInterval  = 5 * time.Second
+	exportTimeout  = 10 * time.Second
+	defaultService = "secretable"
+
+	statusError  = 2
+	kindInternal = 1
+)
+
+// Exporter sends the finished spans in batches as OTLP/HTTP JSON.
+type Exporter struct {
+	url     string
+	headers map[string]string
+	service string
+	client  http.Client
+
+	queue chan *Span
+	done  chan struct{}
+}
+
+func newExporter(opts Options) *Exporter {
+	service := opts.ServiceName
+	if service == "" {
+		service = defaultService
+	}
+
+	e := &Exporter{
+		url:     strings.TrimSuffix(opts.Endpoint, "/") + tracesPath,
+		headers: opts.Headers,
+		service: service,
+		client:  http.Client{Timeout: exportTimeout},
+		queue:   make(chan *Span, queueSize),
+		done:    make(chan struct{}),
+	}
+
+	go e.run()
+
+	return e
+}
+
+func (e *Exporter) add(span *Span) {
+	select {
+	case e.queue <- span:
+	default:
+		log.Debug("Trace queue is full, span dropped", "span", span.Name)
+	}
+}
+
+func (e *Exporter) shutdown() {
+	close(e.queue)
+	<-e.done
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := e.export(batch); err != nil {
+			log.Debug("Export traces: " + err.Error())
+		}
+
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case span, ok := <-e.queue:
+			if !ok {
+				flush()
+
+				return
+			}
+
+			batch = append(batch, span)
+			if len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+func (e *Exporter) export(batch []*Span) error {
+	spans := make([]otlpSpan, 0, len(batch))
+
+	for _, s := range batch {
+		s.mx.Lock()
+
+		span := otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentID,
+			Name:              s.Name,
+			Kind:              kindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        attributes(s.Attrs),
+		}
+
+		if s.Err != "" {
+			span.Status = otlpStatus{Code: statusError, Message: s.Err}
+		}
+
+		s.mx.Unlock()
+
+		spans = append(spans, span)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": attributes(map[string]string{"service.name": e.service}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": defaultService},
+				"spans": spans,
+			}},
+		}},
+	})
+
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send request")
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.New("collector responded " + resp.Status)
+	}
+
+	return nil
+}
+
+func attributes(attrs map[string]string) []otlpAttr {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	res := make([]otlpAttr, 0, len(keys))
+	for _, k := range keys {
+		res = append(res, otlpAttr{Key: k, Value: otlpValue{StringValue: attrs[k]}})
+	}
+
+	return res
+}
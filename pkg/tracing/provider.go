@@ -0,0 +1,145 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"secretable/pkg/providers"
+	"strconv"
+)
+
+// provider records a span of every storage call as a child of the context.
+type provider struct {
+	next providers.StorageProvider
+	ctx  context.Context
+}
+
+// Provider returns the storage provider bound to the context, the provider is
+// returned unchanged when tracing is disabled.
+func Provider(ctx context.Context, next providers.StorageProvider) providers.StorageProvider {
+	if !Enabled() {
+		return next
+	}
+
+	return &provider{next: next, ctx: ctx}
+}
+
+func (p *provider) AddSecret(secret providers.SecretsData) error {
+	_, span := Start(p.ctx, "provider.AddSecret")
+	defer span.Finish()
+
+	return span.SetError(p.next.AddSecret(secret))
+}
+
+func (p *provider) AddSecrets(secrets []providers.SecretsData) error {
+	_, span := Start(p.ctx, "provider.AddSecrets", "count", strconv.Itoa(len(secrets)))
+	defer span.Finish()
+
+	return span.SetError(p.next.AddSecrets(secrets))
+}
+
+func (p *provider) DeleteSecret(index int) error {
+	_, span := Start(p.ctx, "provider.DeleteSecret", "index", strconv.Itoa(index))
+	defer span.Finish()
+
+	return span.SetError(p.next.DeleteSecret(index))
+}
+
+func (p *provider) DeleteSecrets(ids []string) error {
+	_, span := Start(p.ctx, "provider.DeleteSecrets", "count", strconv.Itoa(len(ids)))
+	defer span.Finish()
+
+	return span.SetError(p.next.DeleteSecrets(ids))
+}
+
+func (p *provider) UpdateSecret(id string, secret providers.SecretsData) error {
+	_, span := Start(p.ctx, "provider.UpdateSecret", "id", id)
+	defer span.Finish()
+
+	return span.SetError(p.next.UpdateSecret(id, secret))
+}
+
+func (p *provider) UpdateSecrets(secrets []providers.SecretsData) error {
+	_, span := Start(p.ctx, "provider.UpdateSecrets", "count", strconv.Itoa(len(secrets)))
+	defer span.Finish()
+
+	return span.SetError(p.next.UpdateSecrets(secrets))
+}
+
+func (p *provider) GetSecrets() ([]providers.SecretsData, error) {
+	_, span := Start(p.ctx, "provider.GetSecrets")
+	defer span.Finish()
+
+	secrets, err := p.next.GetSecrets()
+	span.SetAttr("count", strconv.Itoa(len(secrets)))
+
+	return secrets, span.SetError(err)
+}
+
+func (p *provider) QuerySecrets(filter providers.SecretsFilter, offset, limit int) ([]providers.SecretsData, error) {
+	_, span := Start(p.ctx, "provider.QuerySecrets")
+	defer span.Finish()
+
+	secrets, err := p.next.QuerySecrets(filter, offset, limit)
+	span.SetAttr("count", strconv.Itoa(len(secrets)))
+
+	return secrets, span.SetError(err)
+}
+
+func (p *provider) Secrets(fn func(index int, secret providers.SecretsData) bool) error {
+	_, span := Start(p.ctx, "provider.Secrets")
+	defer span.Finish()
+
+	count := 0
+
+	err := p.next.Secrets(func(index int, secret providers.SecretsData) bool {
+		count++
+
+		return fn(index, secret)
+	})
+	span.SetAttr("count", strconv.Itoa(count))
+
+	return span.SetError(err)
+}
+
+func (p *provider) SetKey(key string) error {
+	_, span := Start(p.ctx, "provider.SetKey")
+	defer span.Finish()
+
+	return span.SetError(p.next.SetKey(key))
+}
+
+func (p *provider) GetKey() (string, error) {
+	_, span := Start(p.ctx, "provider.GetKey")
+	defer span.Finish()
+
+	key, err := p.next.GetKey()
+
+	return key, span.SetError(err)
+}
+
+func (p *provider) Open(masterPass string) error {
+	_, span := Start(p.ctx, "provider.Open")
+	defer span.Finish()
+
+	return span.SetError(p.next.Open(masterPass))
+}
+
+func (p *provider) Seal(masterPass string) error {
+	_, span := Start(p.ctx, "provider.Seal")
+	defer span.Finish()
+
+	return span.SetError(p.next.Seal(masterPass))
+}
@@ -0,0 +1,187 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracing records spans of the bot requests and exports them to an
+// OpenTelemetry collector with OTLP over HTTP.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	traceIDLength = 16
+	spanIDLength  = 8
+	chatHashBytes = 8
+)
+
+// Options configure the exporter, tracing is disabled if the endpoint is empty.
+type Options struct {
+	// Endpoint is the OTLP/HTTP collector address, e.g. http://localhost:4318.
+	Endpoint    string            `yaml:"otlp_endpoint"`
+	Headers     map[string]string `yaml:"headers"`
+	ServiceName string            `yaml:"service_name"`
+}
+
+var (
+	exporter   *Exporter
+	exporterMx sync.RWMutex
+)
+
+type spanKey struct{}
+
+// Span is a timed operation of a trace. The nil span is a valid no-op span,
+// it is returned when tracing is disabled.
+type Span struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Name     string
+	Start    time.Time
+	End      time.Time
+	Attrs    map[string]string
+	Err      string
+
+	exporter *Exporter
+	mx       sync.Mutex
+}
+
+// Init starts exporting the spans if the endpoint is set.
+func Init(opts Options) {
+	if opts.Endpoint == "" {
+		return
+	}
+
+	exporterMx.Lock()
+	defer exporterMx.Unlock()
+
+	exporter = newExporter(opts)
+}
+
+// Enabled reports whether the spans are exported.
+func Enabled() bool {
+	exporterMx.RLock()
+	defer exporterMx.RUnlock()
+
+	return exporter != nil
+}
+
+// Shutdown exports the pending spans.
+func Shutdown() {
+	exporterMx.Lock()
+	e := exporter
+	exporter = nil
+	exporterMx.Unlock()
+
+	if e != nil {
+		e.shutdown()
+	}
+}
+
+// Start starts a span, the span of the context becomes its parent.
+func Start(ctx context.Context, name string, attrs ...string) (context.Context, *Span) {
+	exporterMx.RLock()
+	e := exporter
+	exporterMx.RUnlock()
+
+	if e == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		SpanID:   randomHex(spanIDLength),
+		Name:     name,
+		Start:    time.Now(),
+		Attrs:    make(map[string]string),
+		exporter: e,
+	}
+
+	if parent := FromContext(ctx); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	} else {
+		span.TraceID = randomHex(traceIDLength)
+	}
+
+	for i := 0; i+1 < len(attrs); i += 2 {
+		span.Attrs[attrs[i]] = attrs[i+1]
+	}
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// FromContext returns the current span of the context or nil.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+
+	return span
+}
+
+func (s *Span) SetAttr(key, value string) {
+	if s == nil {
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.Attrs[key] = value
+}
+
+// SetError marks the span as failed and returns the error unchanged.
+func (s *Span) SetError(err error) error {
+	if s == nil || err == nil {
+		return err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.Err = err.Error()
+
+	return err
+}
+
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+
+	s.mx.Lock()
+	s.End = time.Now()
+	s.mx.Unlock()
+
+	s.exporter.add(s)
+}
+
+// ChatHash returns a short hash of the chat ID, so traces do not keep who
+// used the bot.
+func ChatHash(chatID int64) string {
+	h := sha256.Sum256([]byte(strconv.FormatInt(chatID, 10)))
+
+	return hex.EncodeToString(h[:chatHashBytes])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,266 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package version
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	checksumsName = "checksums.txt"
+	signatureName = checksumsName + ".sig"
+
+	downloadTimeout = 5 * time.Minute
+	// maxBinarySize limits the downloaded binary.
+	maxBinarySize = 256 << 20
+	maxListSize   = 1 << 20
+
+	signifyAlgorithm = "Ed"
+	signifyKeyNumLen = 8
+)
+
+var (
+	// ErrUnsigned is returned by Update if the build has no release key.
+	ErrUnsigned = errors.New("the build has no release key to verify the update")
+	// ErrBadSignature is returned by Update if the checksums aren't signed
+	// with the release key or the binary doesn't match its checksum.
+	ErrBadSignature = errors.New("the release signature doesn't match")
+)
+
+// AssetName returns the name of the release binary of the platform, see the
+// archives of goreleaser.yml.
+func AssetName() string {
+	arch := runtime.GOARCH
+
+	switch {
+	case runtime.GOOS == "darwin":
+		// The darwin binaries are released as a universal binary.
+		arch = "all"
+	case arch == "arm":
+		// goreleaser builds arm for GOARM=6 by default.
+		arch = "armv6"
+	}
+
+	name := "secretable_" + runtime.GOOS + "_" + arch
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	return name
+}
+
+// Update downloads the release binary of the platform, verifies it with the
+// signed checksums and replaces the running executable. The path of the
+// replaced executable is returned.
+func Update(ctx context.Context, release Release) (string, error) {
+	if PublicKey == "" {
+		return "", ErrUnsigned
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, downloadTimeout)
+	defer cancel()
+
+	sums, err := release.download(ctx, checksumsName, maxListSize)
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := release.download(ctx, signatureName, maxListSize)
+	if err != nil {
+		return "", err
+	}
+
+	if err = verifySignify(PublicKey, sums, signature); err != nil {
+		return "", err
+	}
+
+	sum, err := checksum(sums, AssetName())
+	if err != nil {
+		return "", err
+	}
+
+	binary, err := release.download(ctx, AssetName(), maxBinarySize)
+	if err != nil {
+		return "", err
+	}
+
+	if actual := sha256.Sum256(binary); hex.EncodeToString(actual[:]) != sum {
+		return "", errors.Wrap(ErrBadSignature, "checksum of "+AssetName())
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return "", errors.Wrap(err, "find executable")
+	}
+
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return "", errors.Wrap(err, "resolve executable")
+	}
+
+	return path, replace(path, binary)
+}
+
+func (r Release) download(ctx context.Context, name string, limit int64) ([]byte, error) {
+	url, ok := r.Asset(name)
+	if !ok {
+		return nil, errors.New("release " + r.Tag + " has no " + name)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "new request")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "download "+name)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("download " + name + ": responded " + resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "download "+name)
+	}
+
+	if int64(len(b)) > limit {
+		return nil, errors.New("download " + name + ": too large")
+	}
+
+	return b, nil
+}
+
+// verifySignify checks the signify signature of the message, the key and the
+// signature are base64 lines after an optional "untrusted comment:" line.
+func verifySignify(publicKey string, message, signature []byte) error {
+	key, err := decodeSignify(publicKey, ed25519.PublicKeySize)
+	if err != nil {
+		return errors.Wrap(err, "decode release key")
+	}
+
+	sig, err := decodeSignify(string(signature), ed25519.SignatureSize)
+	if err != nil {
+		return errors.Wrap(err, "decode signature")
+	}
+
+	if !bytes.Equal(key[:signifyKeyNumLen], sig[:signifyKeyNumLen]) {
+		return errors.Wrap(ErrBadSignature, "signed with another key")
+	}
+
+	if !ed25519.Verify(key[signifyKeyNumLen:], message, sig[signifyKeyNumLen:]) {
+		return ErrBadSignature
+	}
+
+	return nil
+}
+
+// decodeSignify returns the key number followed by the key or the signature.
+func decodeSignify(s string, size int) ([]byte, error) {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > 1 && strings.HasPrefix(lines[0], "untrusted comment:") {
+		lines = lines[1:]
+	}
+
+	if len(lines) != 1 {
+		return nil, errors.New("wrong format")
+	}
+
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[0]))
+	if err != nil {
+		return nil, errors.Wrap(err, "decode base64")
+	}
+
+	if len(b) != len(signifyAlgorithm)+signifyKeyNumLen+size || string(b[:len(signifyAlgorithm)]) != signifyAlgorithm {
+		return nil, errors.New("wrong format")
+	}
+
+	return b[len(signifyAlgorithm):], nil
+}
+
+// checksum returns the SHA-256 of the file from the sha256sum list.
+func checksum(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+
+	return "", errors.New("no checksum of " + name)
+}
+
+// replace writes the binary next to the executable and renames it over the
+// executable, so the running process keeps the old file. Windows doesn't
+// rename over a running executable, so it is moved aside first.
+func replace(path string, binary []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrap(err, "stat executable")
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".new-")
+	if err != nil {
+		return errors.Wrap(err, "create temp file")
+	}
+
+	tmp := file.Name()
+	defer os.Remove(tmp)
+
+	if _, err = file.Write(binary); err != nil {
+		file.Close()
+
+		return errors.Wrap(err, "write temp file")
+	}
+
+	if err = file.Close(); err != nil {
+		return errors.Wrap(err, "close temp file")
+	}
+
+	if err = os.Chmod(tmp, info.Mode().Perm()|0o111); err != nil {
+		return errors.Wrap(err, "chmod temp file")
+	}
+
+	if runtime.GOOS == "windows" {
+		old := path + ".old"
+		_ = os.Remove(old)
+
+		if err = os.Rename(path, old); err != nil {
+			return errors.Wrap(err, "move executable aside")
+		}
+	}
+
+	return errors.Wrap(os.Rename(tmp, path), "replace executable")
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package version keeps the build info set by the release build with
+// -ldflags "-X secretable/pkg/version.Version=...".
+package version
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	releasesURL    = "https://api.github.com/repos/secretable/secretable/releases/latest"
+	requestTimeout = 10 * time.Second
+)
+
+var (
+	Version = "dev"
+	Commit  = "none"
+	Date    = "unknown"
+	// PublicKey is the signify public key of the release checksums.
+	PublicKey = ""
+)
+
+// Release is a published release of the bot.
+type Release struct {
+	Tag    string  `json:"tag_name"`
+	URL    string  `json:"html_url"`
+	Assets []Asset `json:"assets"`
+}
+
+// Asset is a file of the release.
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// Asset returns the URL of the file of the release.
+func (r Release) Asset(name string) (string, bool) {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset.URL, true
+		}
+	}
+
+	return "", false
+}
+
+// String returns the version along with the commit and the build date.
+func String() string {
+	return Version + " (commit " + Commit + ", built " + Date + ")"
+}
+
+// Released reports whether the build is a release, the development builds
+// aren't compared with the releases.
+func Released() bool {
+	_, ok := parse(Version)
+
+	return ok
+}
+
+// Latest returns the latest release published on GitHub.
+func Latest(ctx context.Context) (Release, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, releasesURL, nil)
+	if err != nil {
+		return Release{}, errors.Wrap(err, "new request")
+	}
+
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Release{}, errors.Wrap(err, "send request")
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Release{}, errors.New("responded " + resp.Status)
+	}
+
+	var release Release
+	if err = json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return Release{}, errors.Wrap(err, "decode release")
+	}
+
+	return release, nil
+}
+
+// Newer reports whether the release is newer than the build.
+func (r Release) Newer() bool {
+	current, ok := parse(Version)
+	if !ok {
+		return false
+	}
+
+	latest, ok := parse(r.Tag)
+	if !ok {
+		return false
+	}
+
+	for i := range latest {
+		if latest[i] != current[i] {
+			return latest[i] > current[i]
+		}
+	}
+
+	return false
+}
+
+// parse splits the major, minor and patch numbers of the "v1.2.3" version,
+// the pre-release and the build suffixes are ignored.
+func parse(v string) ([3]int, bool) {
+	var numbers [3]int
+
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+
+	parts := strings.Split(v, ".")
+	if len(parts) > len(numbers) {
+		return numbers, false
+	}
+
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return numbers, false
+		}
+
+		numbers[i] = n
+	}
+
+	return numbers, true
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webapp serves the Telegram Web App of the bot and validates the
+// init data it is opened with.
+package webapp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidHash = errors.New("invalid init data hash")
+	ErrExpired     = errors.New("init data expired")
+	ErrNoUser      = errors.New("init data without user")
+)
+
+// User is the Telegram user who opened the Web App.
+type User struct {
+	ID           int64  `json:"id"`
+	Username     string `json:"username"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	LanguageCode string `json:"language_code"`
+}
+
+// Validate checks the signature of the init data with the bot token as
+// described in https://core.telegram.org/bots/webapps#validating-data-received-via-the-mini-app
+// and returns the user. The init data older than maxAge is rejected.
+func Validate(initData, botToken string, maxAge time.Duration, now time.Time) (User, error) {
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return User{}, errors.Wrap(err, "parse init data")
+	}
+
+	hash := values.Get("hash")
+	values.Del("hash")
+
+	pairs := make([]string, 0, len(values))
+	for key := range values {
+		pairs = append(pairs, key+"="+values.Get(key))
+	}
+
+	sort.Strings(pairs)
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(botToken))
+
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+
+	expected, err := hex.DecodeString(hash)
+	if err != nil || !hmac.Equal(expected, mac.Sum(nil)) {
+		return User{}, ErrInvalidHash
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil || now.Sub(time.Unix(authDate, 0)) > maxAge {
+		return User{}, ErrExpired
+	}
+
+	var user User
+
+	if err = json.Unmarshal([]byte(values.Get("user")), &user); err != nil || user.ID == 0 {
+		return User{}, ErrNoUser
+	}
+
+	return user, nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2021 Mikhail Borovikov and The Secretable Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webapp
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// contentSecurityPolicy allows only the page, its API and the Telegram Web
+// App script.
+const contentSecurityPolicy = "default-src 'none'; script-src 'self' https://telegram.org; " +
+	"style-src 'self'; connect-src 'self'; img-src 'self' data:; form-action 'none'; frame-ancestors https://web.telegram.org"
+
+// Static serves the page of the Web App.
+func Static() http.Handler {
+	sub, _ := fs.Sub(static, "static")
+	files := http.FileServer(http.FS(sub))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", contentSecurityPolicy)
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,193 @@
+"use strict";
+
+const tg = window.Telegram.WebApp;
+const $ = (id) => document.getElementById(id);
+
+let strings = {};
+let secrets = [];
+let folder = "";
+let current = null;
+
+async function api(method, path, body) {
+  const resp = await fetch("api/" + path, {
+    method: method,
+    headers: {
+      "Authorization": "tma " + tg.initData,
+      "Content-Type": "application/json",
+    },
+    body: body ? JSON.stringify(body) : undefined,
+  });
+
+  const data = await resp.json().catch(() => ({}));
+  if (!resp.ok) {
+    const err = new Error(data.error || resp.statusText);
+    err.confirm = data.confirm === true;
+    throw err;
+  }
+
+  return data;
+}
+
+function show(view) {
+  for (const id of ["list-view", "detail-view", "form-view"]) {
+    $(id).hidden = id !== view;
+  }
+}
+
+function toast(text) {
+  $("toast").textContent = text;
+  $("toast").hidden = false;
+  setTimeout(() => { $("toast").hidden = true; }, 1500);
+}
+
+function fail(err) {
+  tg.showAlert(err.message);
+}
+
+function renderFolders() {
+  const tags = [...new Set(secrets.flatMap((s) => s.tags || []))].sort();
+  const nav = $("folders");
+
+  nav.replaceChildren();
+
+  for (const tag of [""].concat(tags)) {
+    const btn = document.createElement("button");
+    btn.textContent = tag ? "#" + tag : strings.all;
+    btn.className = tag === folder ? "active" : "";
+    btn.onclick = () => { folder = tag; renderFolders(); renderList(); };
+    nav.append(btn);
+  }
+}
+
+function renderList() {
+  const query = $("search").value.trim().toLowerCase();
+  const list = $("secrets");
+
+  list.replaceChildren();
+
+  const visible = secrets.filter((s) =>
+    (!folder || (s.tags || []).includes(folder)) &&
+    (!query || s.description.toLowerCase().includes(query)));
+
+  for (const secret of visible) {
+    const li = document.createElement("li");
+    li.textContent = secret.description;
+
+    if (secret.type) {
+      const type = document.createElement("small");
+      type.textContent = " " + secret.type;
+      li.append(type);
+    }
+
+    li.onclick = () => openSecret(secret.key).catch(fail);
+    list.append(li);
+  }
+
+  $("empty").hidden = visible.length > 0;
+}
+
+async function loadSecrets() {
+  secrets = (await api("GET", "secrets")).secrets || [];
+  renderFolders();
+  renderList();
+  show("list-view");
+}
+
+async function openSecret(key, confirmed) {
+  try {
+    current = await api("GET", "secrets/" + encodeURIComponent(key) + (confirmed ? "?confirm=1" : ""));
+  } catch (err) {
+    if (!err.confirm || confirmed) {
+      throw err;
+    }
+
+    tg.showConfirm(err.message, (ok) => { if (ok) openSecret(key, true).catch(fail); });
+
+    return;
+  }
+
+  $("detail-title").textContent = current.description;
+  $("detail-edit").hidden = !current.editable;
+
+  const fields = $("detail-fields");
+  fields.replaceChildren();
+
+  for (const field of current.fields) {
+    const dt = document.createElement("dt");
+    dt.textContent = field.label;
+
+    const dd = document.createElement("dd");
+    dd.textContent = field.value;
+    dd.onclick = () => navigator.clipboard.writeText(field.value).then(() => toast(strings.copied), fail);
+
+    fields.append(dt, dd);
+  }
+
+  show("detail-view");
+}
+
+function openForm(secret) {
+  current = secret;
+
+  $("form-description").value = secret ? secret.description : "";
+  $("form-username").value = secret ? secret.username : "";
+  $("form-secret").value = secret ? secret.secret : "";
+  $("form-url").value = secret ? secret.url || "" : "";
+
+  show("form-view");
+}
+
+async function save(event) {
+  event.preventDefault();
+
+  const body = {
+    description: $("form-description").value.trim(),
+    username: $("form-username").value.trim(),
+    secret: $("form-secret").value,
+    url: $("form-url").value.trim(),
+  };
+
+  if (current) {
+    await api("PUT", "secrets/" + encodeURIComponent(current.key), body);
+  } else {
+    await api("POST", "secrets", body);
+  }
+
+  $("form-secret").value = "";
+  current = null;
+
+  toast(strings.saved);
+  await loadSecrets();
+}
+
+async function init() {
+  tg.ready();
+  tg.expand();
+
+  const locale = await api("GET", "strings");
+  strings = locale.strings;
+  document.documentElement.dir = locale.dir;
+
+  $("search").placeholder = strings.search;
+  $("add").textContent = strings.add;
+  $("empty").textContent = strings.empty;
+  $("detail-back").textContent = strings.back;
+  $("detail-edit").textContent = strings.edit;
+  $("form-description-label").textContent = strings.description;
+  $("form-username-label").textContent = strings.username;
+  $("form-secret-label").textContent = strings.secret;
+  $("form-url-label").textContent = strings.url;
+  $("form-cancel").textContent = strings.cancel;
+  $("form-save").textContent = strings.save;
+
+  $("search").oninput = renderList;
+  $("add").onclick = () => openForm(null);
+  $("detail-back").onclick = () => { current = null; show("list-view"); };
+  $("detail-edit").onclick = () => openForm(current);
+  $("form-cancel").onclick = () => { current = null; show("list-view"); };
+  $("form").onsubmit = (event) => save(event).catch(fail);
+
+  await loadSecrets();
+}
+
+init().catch(fail);